@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/logcli"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/operator"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/systemd"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/backup"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/server"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/server/metrics"
 
 	"github.com/apex/log"
 	"github.com/urfave/cli"
@@ -61,6 +65,17 @@ func main() {
 		cli.ShowAppHelpAndExit(c, 1)
 	}
 
+	// summary of non-fatal issues, API commands keep warnings in status instead
+	cliapp.After = func(c *cli.Context) error {
+		if warnings := status.Current.CLIWarnings(); len(warnings) > 0 {
+			log.Warnf("finished with %d warnings:", len(warnings))
+			for _, w := range warnings {
+				log.Warnf("  [%s] %s", w.Kind, w.Message)
+			}
+		}
+		return nil
+	}
+
 	cli.VersionPrinter = func(c *cli.Context) {
 		fmt.Println("Version:\t", c.App.Version)
 		fmt.Println("Git Commit:\t", gitCommit)
@@ -97,10 +112,10 @@ func main() {
 		{
 			Name:        "create",
 			Usage:       "Create new backup",
-			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] [--skip-check-parts-columns] <backup_name>",
+			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] [--skip-check-parts-columns] [--include-detached] [--consistent-snapshot] [--if-not-exists] [--dry-run] <backup_name>",
 			Description: "Create new backup",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), append(dryRunOpts(c), backup.WithIfNotExists(c.Bool("if-not-exists")), backup.WithIncludeDetached(c.Bool("include-detached")), backup.WithConsistentSnapshot(c.Bool("consistent-snapshot")))...)
 				return b.CreateBackup(c.Args().First(), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
@@ -121,6 +136,8 @@ func main() {
 						"If PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"If PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"If PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"If --partitions value starts with db.table: pattern, then partitions apply only for matched tables, use --partitions=db.table1|db.table_*:partition_id1,partition_id2 --partitions=db.table2:(...) format\n" +
+						"Use --partitions=from..to for inclusive range of numeric or string partition_id, YYYY-MM-DD and YYYY-MM bounds match toYYYYMMDD and toYYYYMM partition_id, empty bound means open range\n" +
 						"Values depends on field types in your table, use single quotes for String and Date/DateTime related types\n" +
 						"Look at the system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -154,16 +171,36 @@ func main() {
 					Hidden: false,
 					Usage:  "Skip check system.parts_columns to disallow backup inconsistent column types for data parts",
 				},
+				cli.BoolFlag{
+					Name:   "include-detached",
+					Hidden: false,
+					Usage:  "Backup parts from detached directory of MergeTree tables on local disks into separate detached_parts section, restore attaches only active parts",
+				},
+				cli.BoolFlag{
+					Name:   "consistent-snapshot",
+					Hidden: false,
+					Usage:  "Stop merges of all MergeTree tables until FREEZE and skip parts inserted after the same snapshot barrier, so all tables represent the same point in time",
+				},
+				cli.BoolFlag{
+					Name:   "if-not-exists",
+					Hidden: false,
+					Usage:  "Exit successfully without creating backup when backup with the same name already exists locally or on remote storage",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print tables which will be frozen with data size and old local backups which will be deleted, without creating backup",
+				},
 			),
 		},
 		{
 			Name:        "create_remote",
 			Usage:       "Create and upload new backup",
-			UsageText:   "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] [--resumable] [--skip-check-parts-columns] <backup_name>",
+			UsageText:   "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] [--resumable] [--skip-check-parts-columns] [--include-detached] [--consistent-snapshot] [--destinations=<destination_names>] [--destinations-parallel] [--if-not-exists] <backup_name>",
 			Description: "Create and upload",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.CreateToRemote(c.Args().First(), c.Bool("delete-source"), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("resume"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithIfNotExists(c.Bool("if-not-exists")), backup.WithIncludeDetached(c.Bool("include-detached")), backup.WithConsistentSnapshot(c.Bool("consistent-snapshot")))
+				return b.CreateToRemote(c.Args().First(), c.Bool("delete-source"), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("resume"), c.Bool("skip-check-parts-columns"), c.StringSlice("destinations"), c.Bool("destinations-parallel"), version, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -178,6 +215,8 @@ func main() {
 						"If PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"If PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"If PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"If --partitions value starts with db.table: pattern, then partitions apply only for matched tables, use --partitions=db.table1|db.table_*:partition_id1,partition_id2 --partitions=db.table2:(...) format\n" +
+						"Use --partitions=from..to for inclusive range of numeric or string partition_id, YYYY-MM-DD and YYYY-MM bounds match toYYYYMMDD and toYYYYMM partition_id, empty bound means open range\n" +
 						"Values depends on field types in your table, use single quotes for String and Date/DateTime related types\n" +
 						"Look at the system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -226,6 +265,31 @@ func main() {
 					Hidden: false,
 					Usage:  "Skip check system.parts_columns to disallow backup inconsistent column types for data parts",
 				},
+				cli.StringSliceFlag{
+					Name:   "destinations",
+					Hidden: false,
+					Usage:  "Upload the same backup to several destinations from general->remote_destinations, separated by comma, use `primary` for current remote_storage, status for each destination will save in metadata.json",
+				},
+				cli.BoolFlag{
+					Name:   "destinations-parallel",
+					Hidden: false,
+					Usage:  "Upload to all --destinations in parallel instead of sequentially",
+				},
+				cli.BoolFlag{
+					Name:   "include-detached",
+					Hidden: false,
+					Usage:  "Backup parts from detached directory of MergeTree tables on local disks into separate detached_parts section, restore attaches only active parts",
+				},
+				cli.BoolFlag{
+					Name:   "consistent-snapshot",
+					Hidden: false,
+					Usage:  "Stop merges of all MergeTree tables until FREEZE and skip parts inserted after the same snapshot barrier, so all tables represent the same point in time",
+				},
+				cli.BoolFlag{
+					Name:   "if-not-exists",
+					Hidden: false,
+					Usage:  "Exit successfully without creating and uploading backup when backup with the same name already exists on remote storage, only upload when it exists locally",
+				},
 				cli.BoolFlag{
 					Name:   "delete, delete-source, delete-local",
 					Hidden: false,
@@ -233,13 +297,45 @@ func main() {
 				},
 			),
 		},
+		{
+			Name:        "create_cluster",
+			Usage:       "Create and upload backup on each replica of cluster via API, data only on one replica per shard",
+			UsageText:   "clickhouse-backup create_cluster --cluster=<cluster_name> [-t, --tables=<db>.<table>] [--rbac] [--configs] <backup_name>",
+			Description: "Run create_remote via API on each replica from system.clusters, wait for completion and upload <backup_name>.cluster.json manifest",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.CreateCluster(c.Args().First(), c.String("cluster"), c.String("t"), c.Bool("rbac"), c.Bool("configs"), version, c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "cluster",
+					Hidden: false,
+					Usage:  "Cluster name from system.clusters, macros allowed, clickhouse-backup API shall listen the same api->listen port on each replica",
+				},
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+					Usage:  "Create and upload backup only matched with table name patterns, separated by comma, allow ? and * as wildcard",
+				},
+				cli.BoolFlag{
+					Name:   "rbac, backup-rbac, do-backup-rbac",
+					Hidden: false,
+					Usage:  "Backup and upload RBAC related objects on each replica",
+				},
+				cli.BoolFlag{
+					Name:   "configs, backup-configs, do-backup-configs",
+					Hidden: false,
+					Usage:  "Backup and upload 'clickhouse-server' configuration files on each replica",
+				},
+			),
+		},
 		{
 			Name:      "upload",
 			Usage:     "Upload backup to remote storage",
-			UsageText: "clickhouse-backup upload [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup upload [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] [--resumable] [--destinations=<destination_names>] [--destinations-parallel] [--dry-run] [--only-failed] [--resume-remote] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Upload(c.Args().First(), c.Bool("delete-source"), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), append(dryRunOpts(c), backup.WithOnlyFailed(c.Bool("only-failed")), backup.WithResumeRemote(c.Bool("resume-remote")))...)
+				return b.UploadToDestinations(c.StringSlice("destinations"), c.Bool("destinations-parallel"), c.Args().First(), c.Bool("delete-source"), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -264,6 +360,8 @@ func main() {
 						"If PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"If PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"If PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"If --partitions value starts with db.table: pattern, then partitions apply only for matched tables, use --partitions=db.table1|db.table_*:partition_id1,partition_id2 --partitions=db.table2:(...) format\n" +
+						"Use --partitions=from..to for inclusive range of numeric or string partition_id, YYYY-MM-DD and YYYY-MM bounds match toYYYYMMDD and toYYYYMM partition_id, empty bound means open range\n" +
 						"Values depends on field types in your table, use single quotes for String and Date/DateTime related types\n" +
 						"Look at the system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -277,30 +375,171 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignored with 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
+				cli.StringSliceFlag{
+					Name:   "destinations",
+					Hidden: false,
+					Usage:  "Upload the same backup to several destinations from general->remote_destinations, separated by comma, use `primary` for current remote_storage, status for each destination will save in metadata.json",
+				},
+				cli.BoolFlag{
+					Name:   "destinations-parallel",
+					Hidden: false,
+					Usage:  "Upload to all --destinations in parallel instead of sequentially",
+				},
 				cli.BoolFlag{
 					Name:   "delete, delete-source, delete-local",
 					Hidden: false,
 					Usage:  "explicitly delete local backup during upload",
 				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print tables and archives which will be uploaded with estimated compressed size and remote keys, and backups which will be deleted or rebased by retention, without uploading",
+				},
+				cli.BoolFlag{
+					Name:   "only-failed",
+					Hidden: false,
+					Usage:  "Upload only `failed_tables` of remote backup uploaded with general->upload_failure_policy continue or threshold, and add them to `tables` of remote backup",
+				},
+				cli.BoolFlag{
+					Name:   "resume-remote",
+					Hidden: false,
+					Usage:  "Continue upload into remote backup without metadata.json left by interrupted upload or create_remote, without local resumable state, list remote objects and upload only absent tables, archives and files",
+				},
 			),
 		},
 		{
 			Name:      "list",
 			Usage:     "List of backups",
-			UsageText: "clickhouse-backup list [all|local|remote] [latest|previous]",
+			UsageText: "clickhouse-backup list [--no-cache] [--cost] [--timeline] [--timeline-period=week|month] [all|local|remote] [latest|previous]",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.List(c.Args().Get(0), c.Args().Get(1), c.Bool("cost"), c.Bool("timeline"), c.String("timeline-period"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "no-cache",
+					Hidden: false,
+					Usage:  "Ignore local cache of remote metadata.json, cache will updated with actual values",
+				},
+				cli.BoolFlag{
+					Name:   "cost",
+					Hidden: false,
+					Usage:  "Only for `list remote`, print monthly storage cost for each backup and tag based on general->storage_cost_per_gb, and backups which retention will never delete",
+				},
+				cli.BoolFlag{
+					Name:   "timeline",
+					Hidden: false,
+					Usage:  "Print backups grouped by week or month of creation date, with incremental chain, size and verification status for each backup, signature verified when general->verify_public_key_file defined",
+				},
+				cli.StringFlag{
+					Name:   "timeline-period",
+					Hidden: false,
+					Value:  "month",
+					Usage:  "Group backups for --timeline by week or month",
+				},
+			),
+		},
+		{
+			Name:        "chain",
+			Usage:       "Print incremental backups chain of remote backup",
+			UsageText:   "clickhouse-backup chain <backup_name>",
+			Description: "Print tree of required and dependent backups from full backup, unique size of each backup, size shared with required backups and retention status, retention never deletes backups required by kept backups",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Chain(c.Args().First(), c.Int("command-id"))
+			},
+			Flags: cliapp.Flags,
+		},
+		{
+			Name:        "consolidate",
+			Usage:       "Merge incremental backup and all its required backups into new full backup on remote storage",
+			UsageText:   "clickhouse-backup consolidate <backup_name> [<new_backup_name>]",
+			Description: "Copy data parts from required backups chain into new backup without required backups, server side copy is used for S3 and GCS, other remote storages stream files through clickhouse-backup, data is never read from clickhouse, after that old required backups could be deleted, default new backup name is <backup_name>_consolidated, backups uploaded with general->upload_by_part: false are not supported",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Consolidate(c.Args().First(), c.Args().Get(1), c.Int("command-id"))
+			},
+			Flags: cliapp.Flags,
+		},
+		{
+			Name:        "rebind",
+			Usage:       "Change hostname and disk names in remote backup metadata",
+			UsageText:   "clickhouse-backup rebind [--hostname-mapping=<old_hostname>:<new_hostname>[,<...>]] [--disk-mapping=<old_disk>:<new_disk>[,<...>]] <backup_name>",
+			Description: "Replace replica name of Replicated table and database engines which equals old hostname, rename disks in all backups of required backups chain, use after host re-provisioning with new names",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Rebind(c.Args().First(), c.StringSlice("hostname-mapping"), c.StringSlice("disk-mapping"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringSliceFlag{
+					Name:   "hostname-mapping",
+					Hidden: false,
+					Usage:  "Replace replica name which equals old hostname to new hostname or macro, format old_hostname:new_hostname, separated by comma",
+				},
+				cli.StringSliceFlag{
+					Name:   "disk-mapping",
+					Hidden: false,
+					Usage:  "Rename disks, format old_disk:new_disk, separated by comma, not supported for `compression_format: none` and embedded backups",
+				},
+			),
+		},
+		{
+			Name:        "estimate",
+			Usage:       "Estimate backup size, remote objects count and upload time",
+			UsageText:   "clickhouse-backup estimate [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--bandwidth=<bytes_per_second>]",
+			Description: "Use data parts size from system.parts, columns count from system.columns and compression ratio of latest full remote backups with the same compression_format",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Estimate(c.String("t"), c.StringSlice("partitions"), c.Uint64("bandwidth"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+					Usage:  "Estimate backup only for matched table name patterns, separated by comma, allow ? and * as wildcard",
+				},
+				cli.StringSliceFlag{
+					Name:   "partitions",
+					Hidden: false,
+					Usage:  "Estimate backup only for selected partition names, separated by comma, the same format as `create --partitions`",
+				},
+				cli.Uint64Flag{
+					Name:   "bandwidth",
+					Hidden: false,
+					Usage:  "Upload bandwidth in bytes per second, 0 means upload_max_bytes_per_second * upload_concurrency",
+				},
+			),
+		},
+		{
+			Name:        "du",
+			Usage:       "Show local disk usage by backup, disk and table",
+			UsageText:   "clickhouse-backup du [<backup_name>]",
+			Description: "Hardlinked files are counted once per backup, `exclusive` column shows space which will free after delete backup, files hardlinked to clickhouse data parts or other local backups are not exclusive",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.DiskUsage(c.Args().First(), c.Int("command-id"))
+			},
+			Flags: cliapp.Flags,
+		},
+		{
+			Name:        "verify",
+			Usage:       "Verify checksums of local backup data parts",
+			UsageText:   "clickhouse-backup verify <backup_name>",
+			Description: "Compare sha256 of each file inside data parts with checksums stored in tables metadata, backup shall be created with `integrity_manifest: true`, use after `download` to detect tampering or bit rot before `restore`",
 			Action: func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.List(c.Args().Get(0), c.Args().Get(1))
+				return b.Verify(c.Args().First(), c.Int("command-id"))
 			},
 			Flags: cliapp.Flags,
 		},
 		{
-			Name:      "download",
-			Usage:     "Download backup from remote storage",
-			UsageText: "clickhouse-backup download [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--resumable] <backup_name>",
+			Name:        "download",
+			Usage:       "Download backup from remote storage",
+			UsageText:   "clickhouse-backup download [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--resumable] [--destinations=<destination_names>] [--no-cache] <backup_name>",
+			Description: "Fetch only metadata and data archives of tables and partitions selected with --tables and --partitions, local metadata.json of such backup contains `partial_download` with used filters, partial backup is marked in `list` and can't be uploaded",
 			Action: func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Download(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
+				return b.DownloadFromDestinations(c.StringSlice("destinations"), c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -315,6 +554,8 @@ func main() {
 						"If PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"If PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"If PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"If --partitions value starts with db.table: pattern, then partitions apply only for matched tables, use --partitions=db.table1|db.table_*:partition_id1,partition_id2 --partitions=db.table2:(...) format\n" +
+						"Use --partitions=from..to for inclusive range of numeric or string partition_id, YYYY-MM-DD and YYYY-MM bounds match toYYYYMMDD and toYYYYMM partition_id, empty bound means open range\n" +
 						"Values depends on field types in your table, use single quotes for String and Date/DateTime related types\n" +
 						"Look at the system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -328,14 +569,27 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate download state and resume download if backup exists on local storage, ignored with 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
+				cli.StringSliceFlag{
+					Name:   "destinations",
+					Hidden: false,
+					Usage:  "Try to download backup from destinations in general->remote_destinations, separated by comma, use `primary` for current remote_storage, destinations with successful upload in metadata.json are tried first in listed order, partially downloaded backup is removed before next destination",
+				},
+				cli.BoolFlag{
+					Name:   "no-cache",
+					Hidden: false,
+					Usage:  "Ignore local cache of remote metadata.json, cache will updated with actual values",
+				},
 			),
 		},
 		{
 			Name:      "restore",
 			Usage:     "Create schema and restore data from backup",
-			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] <backup_name>",
+			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--convert-replicated] [--reshard-cluster=<cluster>] [--sync-replicas=<cluster>] [--encrypted-disk-mode=preserve|reencrypt] [--detached] [--repair-projections] [--from-snapshot] [--keeper-only] [--attach-readonly] [--dry-run] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), append(dryRunOpts(c), backup.WithConvertReplicated(c.Bool("convert-replicated")), backup.WithReshardCluster(c.String("reshard-cluster")), backup.WithSyncReplicas(c.String("sync-replicas")), backup.WithRestoreKeeperOnly(c.Bool("keeper-only")), backup.WithEncryptedDiskMode(c.String("encrypted-disk-mode")), backup.WithRestoreDetached(c.Bool("detached")), backup.WithRepairProjections(c.Bool("repair-projections")), backup.WithFromSnapshot(c.Bool("from-snapshot")))...)
+				if c.Bool("attach-readonly") {
+					return b.RestoreAttachReadOnly(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.Int("command-id"))
+				}
 				return b.Restore(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("schema"), c.Bool("data"), c.Bool("drop"), c.Bool("ignore-dependencies"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
@@ -356,6 +610,8 @@ func main() {
 						"If PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"If PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"If PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"If --partitions value starts with db.table: pattern, then partitions apply only for matched tables, use --partitions=db.table1|db.table_*:partition_id1,partition_id2 --partitions=db.table2:(...) format\n" +
+						"Use --partitions=from..to for inclusive range of numeric or string partition_id, YYYY-MM-DD and YYYY-MM bounds match toYYYYMMDD and toYYYYMM partition_id, empty bound means open range\n" +
 						"Values depends on field types in your table, use single quotes for String and Date/DateTime related types\n" +
 						"Look at the system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -399,14 +655,65 @@ func main() {
 					Hidden: false,
 					Usage:  "Restore 'clickhouse-server' configuration files only, will skip backup data, will backup schema only if --schema added",
 				},
+				cli.BoolFlag{
+					Name:   "convert-replicated",
+					Hidden: false,
+					Usage:  "Restore Replicated*MergeTree tables as *MergeTree and Replicated databases as Atomic without ON CLUSTER, for restore production backup into single node, restore_schema_on_cluster is ignored",
+				},
+				cli.StringFlag{
+					Name:   "reshard-cluster",
+					Hidden: false,
+					Usage:  "Restore data of MergeTree tables via INSERT SELECT through temporary Distributed table on cluster from system.clusters instead of ATTACH PART, sharding key is taken from Distributed table in backup or rand(), allow restore backup of each shard from N shards cluster into M shards cluster, tables shall exist on all shards",
+				},
+				cli.StringFlag{
+					Name:   "sync-replicas",
+					Hidden: false,
+					Usage:  "After data restore connect to other replicas of current shard from system.clusters for this cluster, run SYSTEM RESTORE REPLICA for readonly Replicated*MergeTree tables and wait SYSTEM SYNC REPLICA, macros allowed",
+				},
+				cli.StringFlag{
+					Name:   "encrypted-disk-mode",
+					Value:  "preserve",
+					Hidden: false,
+					Usage:  "How restore data parts of `encrypted` disks, preserve attach encrypted files as is, reencrypt rewrite restored tables with OPTIMIZE FINAL when current disk key differs from backup, all keys from backup shall be configured for disk in both modes",
+				},
+				cli.BoolFlag{
+					Name:   "detached",
+					Hidden: false,
+					Usage:  "Place parts from detached_parts section of backup created with --include-detached into detached directory of restored tables without attach",
+				},
+				cli.BoolFlag{
+					Name:   "repair-projections",
+					Hidden: false,
+					Usage:  "Drop projections which were broken during backup or fail ATTACH PART from restored data parts and rebuild them with MATERIALIZE PROJECTION after attach",
+				},
+				cli.BoolFlag{
+					Name:   "from-snapshot",
+					Hidden: false,
+					Usage:  "Create new volumes from cloud disk snapshots of backup created with `clickhouse->cloud_snapshot_type` and print how to replace volumes, tables are not restored",
+				},
+				cli.BoolFlag{
+					Name:   "keeper-only",
+					Hidden: false,
+					Usage:  "Re-create missing ClickHouse Keeper znodes from backup created with keeper_backup: true and SYSTEM RESTART REPLICA for readonly tables, schema and data restore skipped, existing znodes keep untouched",
+				},
+				cli.BoolFlag{
+					Name:   "attach-readonly",
+					Hidden: false,
+					Usage:  "Create tables stored on s3 object disk on `clickhouse->attach_readonly_disk` which points to backup objects in remote storage, attach data parts without copying objects, merges are stopped with SYSTEM STOP MERGES which doesn't survive clickhouse-server restart, execute it again after restart, other flags except --tables and --restore-database-mapping are ignored",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print databases and tables which will be created or replaced, existing tables with different schema, partitions which will be attached and data size for each disk, without changing ClickHouse",
+				},
 			),
 		},
 		{
 			Name:      "restore_remote",
 			Usage:     "Download and restore",
-			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--convert-replicated] [--reshard-cluster=<cluster>] [--sync-replicas=<cluster>] [--encrypted-disk-mode=preserve|reencrypt] [--detached] [--repair-projections] [--from-snapshot] [--keeper-only] [--resumable] [--no-cache] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithConvertReplicated(c.Bool("convert-replicated")), backup.WithReshardCluster(c.String("reshard-cluster")), backup.WithSyncReplicas(c.String("sync-replicas")), backup.WithRestoreKeeperOnly(c.Bool("keeper-only")), backup.WithEncryptedDiskMode(c.String("encrypted-disk-mode")), backup.WithRestoreDetached(c.Bool("detached")), backup.WithRepairProjections(c.Bool("repair-projections")), backup.WithFromSnapshot(c.Bool("from-snapshot")))
 				return b.RestoreFromRemote(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("i"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("resume"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
@@ -427,6 +734,8 @@ func main() {
 						"If PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"If PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"If PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"If --partitions value starts with db.table: pattern, then partitions apply only for matched tables, use --partitions=db.table1|db.table_*:partition_id1,partition_id2 --partitions=db.table2:(...) format\n" +
+						"Use --partitions=from..to for inclusive range of numeric or string partition_id, YYYY-MM-DD and YYYY-MM bounds match toYYYYMMDD and toYYYYMM partition_id, empty bound means open range\n" +
 						"Values depends on field types in your table, use single quotes for String and Date/DateTime related types\n" +
 						"Look at the system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -470,19 +779,285 @@ func main() {
 					Hidden: false,
 					Usage:  "Restore 'clickhouse-server' configuration files only, will skip backup data, will backup schema only if --schema added",
 				},
+				cli.BoolFlag{
+					Name:   "convert-replicated",
+					Hidden: false,
+					Usage:  "Restore Replicated*MergeTree tables as *MergeTree and Replicated databases as Atomic without ON CLUSTER, for restore production backup into single node, restore_schema_on_cluster is ignored",
+				},
+				cli.StringFlag{
+					Name:   "reshard-cluster",
+					Hidden: false,
+					Usage:  "Restore data of MergeTree tables via INSERT SELECT through temporary Distributed table on cluster from system.clusters instead of ATTACH PART, sharding key is taken from Distributed table in backup or rand(), allow restore backup of each shard from N shards cluster into M shards cluster, tables shall exist on all shards",
+				},
+				cli.StringFlag{
+					Name:   "sync-replicas",
+					Hidden: false,
+					Usage:  "After data restore connect to other replicas of current shard from system.clusters for this cluster, run SYSTEM RESTORE REPLICA for readonly Replicated*MergeTree tables and wait SYSTEM SYNC REPLICA, macros allowed",
+				},
+				cli.StringFlag{
+					Name:   "encrypted-disk-mode",
+					Value:  "preserve",
+					Hidden: false,
+					Usage:  "How restore data parts of `encrypted` disks, preserve attach encrypted files as is, reencrypt rewrite restored tables with OPTIMIZE FINAL when current disk key differs from backup, all keys from backup shall be configured for disk in both modes",
+				},
+				cli.BoolFlag{
+					Name:   "detached",
+					Hidden: false,
+					Usage:  "Place parts from detached_parts section of backup created with --include-detached into detached directory of restored tables without attach",
+				},
+				cli.BoolFlag{
+					Name:   "repair-projections",
+					Hidden: false,
+					Usage:  "Drop projections which were broken during backup or fail ATTACH PART from restored data parts and rebuild them with MATERIALIZE PROJECTION after attach",
+				},
+				cli.BoolFlag{
+					Name:   "from-snapshot",
+					Hidden: false,
+					Usage:  "Create new volumes from cloud disk snapshots of backup created with `clickhouse->cloud_snapshot_type` and print how to replace volumes, tables are not restored",
+				},
+				cli.BoolFlag{
+					Name:   "keeper-only",
+					Hidden: false,
+					Usage:  "Re-create missing ClickHouse Keeper znodes from backup created with keeper_backup: true and SYSTEM RESTART REPLICA for readonly tables, schema and data restore skipped, existing znodes keep untouched",
+				},
 				cli.BoolFlag{
 					Name:   "resume, resumable",
 					Hidden: false,
 					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignored with 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
+				cli.BoolFlag{
+					Name:   "no-cache",
+					Hidden: false,
+					Usage:  "Ignore local cache of remote metadata.json, cache will updated with actual values",
+				},
+			),
+		},
+		{
+			Name:        "restore-table",
+			Usage:       "Download and restore one table",
+			UsageText:   "clickhouse-backup restore-table --backup=<backup_name> [--into=<db>.<table>] [--rm, --drop] [--convert-replicated] [--sync-replicas=<cluster>] <db>.<table>",
+			Description: "Download only metadata and data of <db>.<table> when backup is not present locally, create table with other database and table name when --into defined and attach its data parts, other tables, databases, RBAC and configs are not touched",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c), backup.WithConvertReplicated(c.Bool("convert-replicated")), backup.WithSyncReplicas(c.String("sync-replicas")))
+				return b.RestoreTable(c.Args().First(), c.String("backup"), c.String("into"), c.Bool("rm"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "backup",
+					Hidden: false,
+					Usage:  "Backup name which contains table, downloaded with table metadata and data only if not present locally",
+				},
+				cli.StringFlag{
+					Name:   "into",
+					Hidden: false,
+					Usage:  "Restore table as <db>.<table>, UUID is re-generated and replication path is changed when table name is renamed",
+				},
+				cli.BoolFlag{
+					Name:   "rm, drop",
+					Hidden: false,
+					Usage:  "Drop restored table before restore",
+				},
+				cli.BoolFlag{
+					Name:   "convert-replicated",
+					Hidden: false,
+					Usage:  "Restore Replicated*MergeTree table as *MergeTree and Replicated database as Atomic without ON CLUSTER",
+				},
+				cli.StringFlag{
+					Name:   "sync-replicas",
+					Hidden: false,
+					Usage:  "After data restore connect to other replicas of current shard from system.clusters for this cluster, run SYSTEM RESTORE REPLICA for readonly Replicated*MergeTree tables and wait SYSTEM SYNC REPLICA, macros allowed",
+				},
+			),
+		},
+		{
+			Name:        "export",
+			Usage:       "Export tables data from backup to Parquet, CSV or Native files",
+			UsageText:   "clickhouse-backup export [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--format=parquet|csv|native] --path=<export_path> [--remote] <backup_name>",
+			Description: "Attach data parts of each MergeTree table from local backup into temporary clickhouse-local instance and write all rows into <export_path>/<db>.<table>.<format> file, with --remote only selected tables are downloaded and downloaded backup is deleted after export",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Export(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.String("format"), c.String("path"), c.Bool("remote"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "Export only database and objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "partitions",
+					Hidden: false,
+					Usage:  "Export only selected partition names, separated by comma, format is the same as for restore --partitions",
+				},
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "parquet",
+					Hidden: false,
+					Usage:  "Output format, parquet, csv with header or native",
+				},
+				cli.StringFlag{
+					Name:   "path",
+					Hidden: false,
+					Usage:  "Directory for exported files, created if not exists",
+				},
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Download selected tables from remote storage when backup is not present locally, clickhouse->local_command is used for conversion",
+				},
+			),
+		},
+		{
+			Name:      "adopt",
+			Usage:     "Create local backup from existing shadow, detached or ClickHouse data directory",
+			UsageText: "clickhouse-backup adopt [--name=<backup_name>] [-t, --tables=<db>.<table>] <path>",
+			Description: "Find data parts with checksums.txt inside <path>, which could be result of manual ALTER TABLE ... FREEZE, detached parts or copy of ClickHouse data directory, and hardlink or copy them into new local backup on default disk\n" +
+				"Schema is read from <path>/metadata when exists, otherwise from running clickhouse-server by table UUID or by database and table name",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Adopt(c.Args().First(), c.String("name"), c.String("t"), version, c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "name",
+					Hidden: false,
+					Usage:  "Name of created backup, current timestamp is used when empty",
+				},
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "Adopt only database and objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+			),
+		},
+		{
+			Name:        "restore_cluster",
+			Usage:       "Restore backup created by create_cluster, schema on each replica of cluster, data only on one replica per shard",
+			UsageText:   "clickhouse-backup restore_cluster [--cluster=<cluster_name>] [-t, --tables=<db>.<table>] [--rm, --drop] [--sync-timeout=<duration>] <backup_name>",
+			Description: "Read <backup_name>.cluster.json manifest, run restore_remote via API on each replica from system.clusters with backup of the same shard_num, wait until replication queues are empty and verify each shard contains data from backup of own shard, replicas without data are verified only for Replicated* tables",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.RestoreCluster(c.Args().First(), c.String("cluster"), c.String("t"), c.Bool("rm"), c.Duration("sync-timeout"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "cluster",
+					Hidden: false,
+					Usage:  "Cluster name from system.clusters, macros allowed, cluster from manifest used when empty, shards count shall be the same as in manifest",
+				},
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+					Usage:  "Restore only objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
+				},
+				cli.BoolFlag{
+					Name:   "rm, drop",
+					Hidden: false,
+					Usage:  "Drop schema objects before restore on each replica",
+				},
+				cli.DurationFlag{
+					Name:   "sync-timeout",
+					Hidden: false,
+					Value:  time.Hour,
+					Usage:  "How long to wait until replication queues are empty on all replicas after data restore",
+				},
+			),
+		},
+		{
+			Name:      "clone",
+			Usage:     "Copy database via temporary local backup and restore with database mapping",
+			UsageText: "clickhouse-backup clone --source=<db> --target=<db> [--target-host=<host>] [--target-port=<port>] [--schema] [--rm, --drop]",
+			Description: "Create temporary local backup for all tables in --source database, restore it into --target database with attaching data parts, and delete temporary backup\n" +
+				"--target-host and --target-port allow restore to another clickhouse-server which shall have access to the same disks as source clickhouse-server",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Clone(c.String("source"), c.String("target"), c.String("target-host"), c.Uint("target-port"), c.Bool("schema"), c.Bool("drop"), version, c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "source",
+					Hidden: false,
+					Usage:  "Source database name",
+				},
+				cli.StringFlag{
+					Name:   "target",
+					Hidden: false,
+					Usage:  "Target database name, will created if not exists",
+				},
+				cli.StringFlag{
+					Name:   "target-host",
+					Hidden: false,
+					Usage:  "Target clickhouse-server host, default is clickhouse->host from config",
+				},
+				cli.UintFlag{
+					Name:   "target-port",
+					Hidden: false,
+					Usage:  "Target clickhouse-server port, default is clickhouse->port from config",
+				},
+				cli.BoolFlag{
+					Name:   "schema, s",
+					Hidden: false,
+					Usage:  "Clone schema only",
+				},
+				cli.BoolFlag{
+					Name:   "rm, drop",
+					Hidden: false,
+					Usage:  "Drop exists tables in target database before clone",
+				},
+			),
+		},
+		{
+			Name:      "export_state",
+			Usage:     "Export resumable upload or download state for continue on another host",
+			UsageText: "clickhouse-backup export_state [--command=<upload|download|upload@destination>] [--output=<file>] <backup_name>",
+			Description: "Write JSON with already processed files from <default_data_path>/backup/<backup_name>/<command>.state into --output file or stdout\n" +
+				"Use import_state on another host with the same local backup, after that upload --resume or download --resume will skip already processed files",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.ExportResumableState(c.Args().First(), c.String("command"), c.String("output"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "command",
+					Hidden: false,
+					Usage:  "Resumable state command, required when backup has more than one state",
+				},
+				cli.StringFlag{
+					Name:   "output, o",
+					Hidden: false,
+					Usage:  "Output file name, '-' or empty means stdout",
+				},
+			),
+		},
+		{
+			Name:      "import_state",
+			Usage:     "Import resumable upload or download state exported with export_state",
+			UsageText: "clickhouse-backup import_state [--input=<file>] [--force]",
+			Description: "Write <default_data_path>/backup/<backup_name>/<command>.state from JSON created with export_state, local backup shall exist\n" +
+				"Local file paths are relocated from default_data_path of source host to default_data_path of current host",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.ImportResumableState(c.String("input"), c.Bool("force"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "input, i",
+					Hidden: false,
+					Usage:  "Input file name, '-' or empty means stdin",
+				},
+				cli.BoolFlag{
+					Name:   "force, f",
+					Hidden: false,
+					Usage:  "Overwrite exists resumable state",
+				},
 			),
 		},
 		{
 			Name:      "delete",
 			Usage:     "Delete specific backup",
-			UsageText: "clickhouse-backup delete <local|remote> <backup_name>",
+			UsageText: "clickhouse-backup delete [--dry-run] [--force-gc] <local|remote> <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetConfigFromCli(c), append(dryRunOpts(c), backup.WithForceGC(c.Bool("force-gc")))...)
 				if c.Args().Get(1) == "" {
 					log.Errorf("Backup name must be defined")
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
@@ -493,7 +1068,91 @@ func main() {
 				}
 				return b.Delete(c.Args().Get(0), c.Args().Get(1), c.Int("command-id"))
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print backup directories or remote backup which will be deleted with size, without deleting",
+				},
+				cli.BoolFlag{
+					Name:   "force-gc",
+					Hidden: false,
+					Usage:  "Only for `delete remote`, after delete warn about absent object disk data required by remaining backups and delete object disk data which is not referenced by any local or remote backup",
+				},
+			),
+		},
+		{
+			Name:        "rename",
+			Usage:       "Rename local or remote backup",
+			UsageText:   "clickhouse-backup rename [--remote] <old_backup_name> <new_backup_name>",
+			Description: "Rename backup directory on each disk or copy remote backup to new name and delete old one, required_backup of backups which require renamed backup is changed, embedded and object disk backups are not supported",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				if c.Args().Get(1) == "" {
+					log.Errorf("Old and new backup names must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.Rename(c.Args().Get(0), c.Args().Get(1), c.Bool("remote"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Rename backup on remote storage instead of local backup",
+				},
+			),
+		},
+		{
+			Name:      "diff",
+			Usage:     "Compare backup with current clickhouse-server before restore",
+			UsageText: "clickhouse-backup diff [-t, --tables=<db>.<table>] [--schema] [--settings] [--remote] <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Diff(c.Args().First(), c.String("t"), c.Bool("schema"), c.Bool("settings"), c.Bool("remote"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+					Usage:  "Compare only objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
+				},
+				cli.BoolFlag{
+					Name:   "schema, s",
+					Hidden: false,
+					Usage:  "Compare CREATE queries and partitions of tables in backup with current tables, print added, removed and changed tables, columns and partitions, default when --settings is not passed",
+				},
+				cli.BoolFlag{
+					Name:   "settings",
+					Hidden: false,
+					Usage:  "Compare changed system.settings and system.merge_tree_settings saved during backup with current values",
+				},
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Read backup metadata from remote storage instead of local backup",
+				},
+			),
+		},
+		{
+			Name:      "compare",
+			Usage:     "Compare two backups, print changed schemas, added and removed parts, size delta and estimated unique bytes of second backup",
+			UsageText: "clickhouse-backup compare [-t, --tables=<db>.<table>] [--remote] <backup_name_a> <backup_name_b>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Compare(c.Args().Get(0), c.Args().Get(1), c.String("t"), c.Bool("remote"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+					Usage:  "Compare only objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
+				},
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Read backups metadata from remote storage instead of local backups",
+				},
+			),
 		},
 		{
 			Name:  "default-config",
@@ -511,6 +1170,100 @@ func main() {
 			},
 			Flags: cliapp.Flags,
 		},
+		{
+			Name:        "doctor",
+			Usage:       "Validate config, check access to clickhouse, disks, keeper and remote storage, print report with hints",
+			UsageText:   "clickhouse-backup doctor",
+			Description: "Write, read and delete temporary file on remote storage and local disks, exit code 0 when all checks passed, 1 when some checks have warnings, 2 when some checks failed",
+			Action: func(c *cli.Context) error {
+				config.OverrideEnvVars(c)
+				report := backup.Doctor(context.Background(), config.GetConfigPath(c))
+				if err := report.Print(os.Stdout); err != nil {
+					return err
+				}
+				if exitCode := report.ExitCode(); exitCode != 0 {
+					return cli.NewExitError("", exitCode)
+				}
+				return nil
+			},
+			Flags: cliapp.Flags,
+		},
+		{
+			Name:      "systemd-unit",
+			Usage:     "Print systemd unit for server or watch command with Type=notify and watchdog",
+			UsageText: "clickhouse-backup systemd-unit [--server] [--user=clickhouse] [--watchdog-sec=60]",
+			Action: func(c *cli.Context) error {
+				binary, err := os.Executable()
+				if err != nil {
+					return err
+				}
+				command := "watch"
+				if c.Bool("server") {
+					command = "server --watch"
+				}
+				unit, err := systemd.UnitTemplate(systemd.UnitParams{
+					Binary:      binary,
+					ConfigPath:  config.GetConfigPath(c),
+					Command:     command,
+					User:        c.String("user"),
+					WatchdogSec: c.Int("watchdog-sec"),
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Print(unit)
+				return nil
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "server",
+					Hidden: false,
+					Usage:  "Generate unit for 'server --watch' instead of 'watch', REST API will available, watchdog checks that API server and watch loop are not hung",
+				},
+				cli.StringFlag{
+					Name:   "user",
+					Value:  "clickhouse",
+					Hidden: false,
+					Usage:  "User and group which will run clickhouse-backup, shall have access to clickhouse data directory",
+				},
+				cli.IntFlag{
+					Name:   "watchdog-sec",
+					Value:  60,
+					Hidden: false,
+					Usage:  "WatchdogSec in unit, systemd will restart process which not send WATCHDOG=1 during this interval, 0 means disable watchdog",
+				},
+			),
+		},
+		{
+			Name:        "prometheus-alerts",
+			Usage:       "Print Prometheus alerting rules file for metrics exposed by API server",
+			UsageText:   "clickhouse-backup prometheus-alerts [--selector='job=\"clickhouse-backup\"'] [--stale-factor=2]",
+			Description: "Alerts for stale and failed backups, too long increments chain, rising remote storage errors and broken remote backups, thresholds are calculated from watch_interval, full_interval and retention_policies",
+			Action: func(c *cli.Context) error {
+				rules, err := metrics.AlertingRules(config.GetConfigFromCli(c), metrics.AlertingRulesParams{
+					Selector:    c.String("selector"),
+					StaleFactor: c.Float64("stale-factor"),
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Print(string(rules))
+				return nil
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "selector",
+					Hidden: false,
+					Usage:  "Label matchers without braces which will add to each metric in expressions, like job=\"clickhouse-backup\"",
+				},
+				cli.Float64Flag{
+					Name:   "stale-factor",
+					Value:  2,
+					Hidden: false,
+					Usage:  "How many watch_interval could pass without new remote backup before alert",
+				},
+			),
+		},
 		{
 			Name:  "clean",
 			Usage: "Remove data in 'shadow' folder from all 'path' folders available from 'system.disks'",
@@ -529,15 +1282,53 @@ func main() {
 			},
 			Flags: cliapp.Flags,
 		},
+		{
+			Name:        "gc",
+			Usage:       "Find and delete remote objects which are not referenced by metadata of any backup",
+			UsageText:   "clickhouse-backup gc --remote [--min-age=24h] [--confirm]",
+			Description: "Report directories of broken backups, archives and parts inside `shadow` which are not listed in tables metadata, `object_disk_path` data which is not referenced by any local or remote backup, local backups are checked only when clickhouse is available, and incomplete S3 multipart uploads, objects modified after `--min-age` ago are skipped, nothing is deleted without `--confirm`",
+			Action: func(c *cli.Context) error {
+				if !c.Bool("remote") {
+					return fmt.Errorf("gc requires --remote")
+				}
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.GarbageCollectRemote(c.Duration("min-age"), c.Bool("confirm"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Scan remote storage",
+				},
+				cli.DurationFlag{
+					Name:   "min-age",
+					Hidden: false,
+					Value:  24 * time.Hour,
+					Usage:  "Skip objects modified later than this duration ago, they could belong to upload which is still running",
+				},
+				cli.BoolFlag{
+					Name:   "confirm",
+					Hidden: false,
+					Usage:  "Delete found objects and abort incomplete multipart uploads, only report without this flag",
+				},
+			),
+		},
 
 		{
 			Name:        "watch",
 			Usage:       "Run infinite loop which create full + incremental backup sequence to allow efficient backup sequences",
-			UsageText:   "clickhouse-backup watch [--watch-interval=1h] [--full-interval=24h] [--watch-backup-name-template=shard{shard}-{type}-{time:20060102150405}] [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--schema] [--rbac] [--configs] [--skip-check-parts-columns]",
-			Description: "Execute create_remote + delete local, create full backup every `--full-interval`, create and upload incremental backup every `--watch-interval` use previous backup as base with `--diff-from-remote` option, use `backups_to_keep_remote` config option for properly deletion remote backups, will delete old backups which not have references from other backups",
+			UsageText:   "clickhouse-backup watch [--watch-interval=1h] [--full-interval=24h] [--watch-backup-name-template=shard{shard}-{type}-{time:20060102150405}] [--retention-policy=<name>] [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--schema] [--rbac] [--configs] [--skip-check-parts-columns] [--no-cache]",
+			Description: "Execute create_remote + delete local, create full backup every `--full-interval`, create and upload incremental backup every `--watch-interval` use previous backup as base with `--diff-from-remote` option, use `backups_to_keep_remote` config option for properly deletion remote backups, will delete old backups which not have references from other backups, when `watch_full_schedule` defined in config, backups created by cron schedules `watch_full_schedule` and `watch_increment_schedule` instead of intervals",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Watch(c.String("watch-interval"), c.String("full-interval"), c.String("watch-backup-name-template"), c.String("tables"), c.StringSlice("partitions"), c.Bool("schema"), c.Bool("rbac"), c.Bool("configs"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"), nil, c)
+				cfg := config.GetConfigFromCli(c)
+				b := backup.NewBackuper(cfg)
+				if _, err := systemd.Notify(systemd.Ready); err != nil {
+					log.Warnf("can't notify systemd %s: %v", systemd.Ready, err)
+				}
+				watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+				defer stopWatchdog()
+				go systemd.RunWatchdog(watchdogCtx, systemd.ProgressAlive(backup.WatchdogProgressTimeout(cfg)))
+				return b.Watch(c.String("watch-interval"), c.String("full-interval"), c.String("watch-backup-name-template"), c.String("retention-policy"), c.String("tables"), c.StringSlice("partitions"), c.Bool("schema"), c.Bool("rbac"), c.Bool("configs"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"), nil, c)
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -555,6 +1346,11 @@ func main() {
 					Usage:  "Template for new backup name, could contain names from system.macros, {type} - full or incremental and {time:LAYOUT}, look to https://go.dev/src/time/format.go for layout examples",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "retention-policy",
+					Usage:  "Name of policy from general->retention_policies, backup only policy databases, use policy watch_interval, full_interval, and replace {policy} in backup name template",
+					Hidden: false,
+				},
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "Create and upload only objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
@@ -567,6 +1363,8 @@ func main() {
 						"If PARTITION BY clause returns numeric not hashed values for `partition_id` field in system.parts table, then use --partitions=partition_id1,partition_id2 format\n" +
 						"If PARTITION BY clause returns hashed string values, then use --partitions=('non_numeric_field_value_for_part1'),('non_numeric_field_value_for_part2') format\n" +
 						"If PARTITION BY clause returns tuple with multiple fields, then use --partitions=(numeric_value1,'string_value1','date_or_datetime_value'),(...) format\n" +
+						"If --partitions value starts with db.table: pattern, then partitions apply only for matched tables, use --partitions=db.table1|db.table_*:partition_id1,partition_id2 --partitions=db.table2:(...) format\n" +
+						"Use --partitions=from..to for inclusive range of numeric or string partition_id, YYYY-MM-DD and YYYY-MM bounds match toYYYYMMDD and toYYYYMM partition_id, empty bound means open range\n" +
 						"Values depends on field types in your table, use single quotes for String and Date/DateTime related types\n" +
 						"Look at the system.parts partition and partition_id fields for details https://clickhouse.com/docs/en/operations/system-tables/parts/",
 				},
@@ -590,6 +1388,50 @@ func main() {
 					Hidden: false,
 					Usage:  "Skip check system.parts_columns to disallow backup inconsistent column types for data parts",
 				},
+				cli.BoolFlag{
+					Name:   "no-cache",
+					Hidden: false,
+					Usage:  "Ignore local cache of remote metadata.json, cache will updated with actual values",
+				},
+			),
+		},
+		{
+			Name:        "continuous",
+			Usage:       "Run infinite loop which upload new data parts as incremental backups to allow RPO of minutes",
+			UsageText:   "clickhouse-backup continuous [--continuous-interval=5m] [-t, --tables=<db>.<table>] [--skip-check-parts-columns] [--no-cache]",
+			Description: "Each `--continuous-interval` check system.parts for active parts created after previous backup, when found execute create_remote + delete local with `--diff-from-remote` previous backup, so only new parts will upload, create full backup and start new chain after `continuous_max_increments` increments, backup names use `continuous_backup_name_template`",
+			Action: func(c *cli.Context) error {
+				cfg := config.GetConfigFromCli(c)
+				b := backup.NewBackuper(cfg)
+				if _, err := systemd.Notify(systemd.Ready); err != nil {
+					log.Warnf("can't notify systemd %s: %v", systemd.Ready, err)
+				}
+				watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+				defer stopWatchdog()
+				go systemd.RunWatchdog(watchdogCtx, systemd.ProgressAlive(backup.WatchdogProgressTimeout(cfg)))
+				return b.Continuous(c.String("continuous-interval"), c.String("tables"), c.Bool("skip-check-parts-columns"), version, c.Int("command-id"), nil, c)
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "continuous-interval",
+					Usage:  "Interval for check system.parts and run 'create_remote' + 'delete local' when new parts found, look format https://pkg.go.dev/time#ParseDuration",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "Create and upload only objects which matched with table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+				cli.BoolFlag{
+					Name:   "skip-check-parts-columns",
+					Hidden: false,
+					Usage:  "Skip check system.parts_columns to disallow backup inconsistent column types for data parts",
+				},
+				cli.BoolFlag{
+					Name:   "no-cache",
+					Hidden: false,
+					Usage:  "Ignore local cache of remote metadata.json, cache will updated with actual values",
+				},
 			),
 		},
 		{
@@ -619,6 +1461,41 @@ func main() {
 					Usage:  "Template for new backup name, could contain names from system.macros, {type} - full or incremental and {time:LAYOUT}, look to https://go.dev/src/time/format.go for layout examples",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "retention-policy",
+					Usage:  "Name of policy from general->retention_policies for watch go-routine, backup only policy databases, use policy watch_interval, full_interval, and replace {policy} in backup name template",
+					Hidden: false,
+				},
+				cli.BoolFlag{
+					Name:   "continuous",
+					Usage:  "Run continuous go-routine which upload new parts as incremental backups each 'continuous_interval', after API server startup",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "continuous-interval",
+					Usage:  "Interval for check new parts in continuous go-routine, look format https://pkg.go.dev/time#ParseDuration",
+					Hidden: false,
+				},
+			),
+		},
+		{
+			Name:        "operator",
+			Usage:       "Watch ClickHouseBackup and ClickHouseRestore custom resources in Kubernetes and execute them",
+			Description: "Run inside clickhouse-backup sidecar container, resources without spec.host execute by the first operator which claims it, status of resource contains phase, message and warnings",
+			Action: func(c *cli.Context) error {
+				return operator.Run(c, cliapp, config.GetConfigPath(c))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "namespace",
+					Usage:  "Kubernetes namespace for watch custom resources, namespace of pod by default",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "host",
+					Usage:  "Execute only resources with empty spec.host or equal to this value, hostname by default",
+					Hidden: false,
+				},
 			),
 		},
 	}
@@ -626,3 +1503,11 @@ func main() {
 		log.Fatal(err.Error())
 	}
 }
+
+// dryRunOpts - print planned actions to stdout instead of execution when --dry-run passed
+func dryRunOpts(c *cli.Context) []backup.BackuperOpt {
+	if c.Bool("dry-run") {
+		return []backup.BackuperOpt{backup.WithDryRun(os.Stdout)}
+	}
+	return nil
+}
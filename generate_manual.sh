@@ -7,12 +7,16 @@ cmds=(
   create_remote
   upload
   list
+  du
   download
   restore
   restore_remote
+  clone
   delete
+  diff
   default-config
   print-config
+  systemd-unit
   clean
   clean_remote_broken
   watch
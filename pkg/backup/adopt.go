@@ -0,0 +1,404 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// adoptPartNameRE - active part name, detached parts with prefixes like broken_ or ignored_ are skipped
+var adoptPartNameRE = regexp.MustCompile(`^[0-9a-zA-Z-]+_\d+_\d+_\d+(_\d+)?$`)
+var adoptUUIDRE = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+var adoptAttachRE = regexp.MustCompile(`^ATTACH ((?:MATERIALIZED |LIVE |WINDOW )?VIEW|TABLE|DICTIONARY|DATABASE) _(\s)`)
+
+// adoptedTable - table data directory found inside adopted path, `store/<uuid prefix>/<uuid>` for Atomic databases, `data/<database>/<table>` for Ordinary databases
+type adoptedTable struct {
+	uuid     string
+	database string
+	table    string
+	// parts - part name to part directory, parts from `detached` directory are included
+	parts map[string]string
+}
+
+// findAdoptedTables - walk adoptPath and group part directories with checksums.txt by table directory,
+// `backup` and `shadow` directories are skipped when adoptPath is ClickHouse data directory
+func findAdoptedTables(adoptPath string, log *apexLog.Entry) ([]*adoptedTable, error) {
+	tables := map[string]*adoptedTable{}
+	_, metadataErr := os.Stat(path.Join(adoptPath, "metadata"))
+	isDataDirectory := metadataErr == nil
+	err := filepath.WalkDir(adoptPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isDataDirectory && (filePath == path.Join(adoptPath, "backup") || filePath == path.Join(adoptPath, "shadow")) {
+			return filepath.SkipDir
+		}
+		if !adoptPartNameRE.MatchString(d.Name()) {
+			return nil
+		}
+		if _, statErr := os.Stat(path.Join(filePath, "checksums.txt")); statErr != nil {
+			return nil
+		}
+		tableDir := filepath.Dir(filePath)
+		if filepath.Base(tableDir) == "detached" {
+			tableDir = filepath.Dir(tableDir)
+		}
+		table, exists := tables[tableDir]
+		if !exists {
+			table = &adoptedTable{parts: map[string]string{}}
+			name, parentName := filepath.Base(tableDir), filepath.Base(filepath.Dir(tableDir))
+			if adoptUUIDRE.MatchString(name) && len(parentName) == 3 && strings.HasPrefix(name, parentName) {
+				table.uuid = name
+			} else {
+				if table.database, err = url.PathUnescape(parentName); err != nil {
+					return err
+				}
+				if table.table, err = url.PathUnescape(name); err != nil {
+					return err
+				}
+			}
+			tables[tableDir] = table
+		}
+		if existsPath, isDuplicated := table.parts[d.Name()]; isDuplicated {
+			log.Warnf("part %s found in %s and %s, use first", d.Name(), existsPath, filePath)
+		} else {
+			table.parts[d.Name()] = filePath
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	tableDirs := make([]string, 0, len(tables))
+	for tableDir := range tables {
+		tableDirs = append(tableDirs, tableDir)
+	}
+	sort.Strings(tableDirs)
+	result := make([]*adoptedTable, len(tableDirs))
+	for i, tableDir := range tableDirs {
+		result[i] = tables[tableDir]
+	}
+	return result, nil
+}
+
+// adoptQueryFromAttach - `metadata/*.sql` contains `ATTACH TABLE _ ...`, backup metadata requires CREATE query with database and table name
+func adoptQueryFromAttach(query, database, table string) string {
+	name := quoteAdoptIdentifier(database)
+	if table != "" {
+		name += "." + quoteAdoptIdentifier(table)
+	}
+	return adoptAttachRE.ReplaceAllString(strings.TrimSpace(query), "CREATE ${1} "+name+"${2}")
+}
+
+func quoteAdoptIdentifier(name string) string {
+	if usualIdentifier.MatchString(name) {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// readAdoptedMetadata - databases and tables from `metadata` directory of ClickHouse data directory, return os.ErrNotExist when adoptPath doesn't contain it
+func readAdoptedMetadata(adoptPath string) ([]clickhouse.Table, []clickhouse.Database, error) {
+	metadataPath := path.Join(adoptPath, "metadata")
+	entries, err := os.ReadDir(metadataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tables []clickhouse.Table
+	var databases []clickhouse.Database
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		database, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), ".sql"))
+		if err != nil {
+			return nil, nil, err
+		}
+		query, err := os.ReadFile(path.Join(metadataPath, entry.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		databases = append(databases, clickhouse.Database{Name: database, Query: adoptQueryFromAttach(string(query), database, "")})
+		databaseDir := path.Join(metadataPath, strings.TrimSuffix(entry.Name(), ".sql"))
+		tableEntries, err := os.ReadDir(databaseDir)
+		// metadata directory of Atomic database is absolute symlink to store, data directory of dead server could be mounted to other path
+		if os.IsNotExist(err) {
+			if target, linkErr := os.Readlink(databaseDir); linkErr == nil && path.IsAbs(target) {
+				if idx := strings.Index(target, "/store/"); idx >= 0 {
+					databaseDir = path.Join(adoptPath, target[idx+1:])
+					tableEntries, err = os.ReadDir(databaseDir)
+				}
+			}
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		for _, tableEntry := range tableEntries {
+			if tableEntry.IsDir() || !strings.HasSuffix(tableEntry.Name(), ".sql") {
+				continue
+			}
+			table, err := url.PathUnescape(strings.TrimSuffix(tableEntry.Name(), ".sql"))
+			if err != nil {
+				return nil, nil, err
+			}
+			tableQuery, err := os.ReadFile(path.Join(databaseDir, tableEntry.Name()))
+			if err != nil {
+				return nil, nil, err
+			}
+			t := clickhouse.Table{Database: database, Name: table, CreateTableQuery: adoptQueryFromAttach(string(tableQuery), database, table)}
+			if matches := uuidRE.FindStringSubmatch(t.CreateTableQuery); len(matches) > 1 {
+				t.UUID = matches[1]
+			}
+			tables = append(tables, t)
+		}
+	}
+	return tables, databases, nil
+}
+
+// matchAdoptedTable - find schema by UUID for Atomic databases or by names for Ordinary databases
+func matchAdoptedTable(adopted *adoptedTable, tables []clickhouse.Table) (clickhouse.Table, bool) {
+	for _, t := range tables {
+		if (adopted.uuid != "" && t.UUID == adopted.uuid) || (adopted.uuid == "" && t.Database == adopted.database && t.Name == adopted.table) {
+			return t, true
+		}
+	}
+	return clickhouse.Table{}, false
+}
+
+// linkOrCopyDir - adopted directory could be mounted from other filesystem, then files are copied instead of hardlinks
+func linkOrCopyDir(src, dst string) (int64, error) {
+	var size int64
+	err := filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+		dstPath := path.Join(dst, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(dstPath, 0750)
+		}
+		size += info.Size()
+		if err = os.Link(filePath, dstPath); err == nil || !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		srcFile, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = srcFile.Close()
+		}()
+		dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, info.Mode())
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(dstFile, srcFile); err != nil {
+			_ = dstFile.Close()
+			return err
+		}
+		return dstFile.Close()
+	})
+	return size, err
+}
+
+// Adopt - `adopt` command, wrap data parts from manual FREEZE shadow directory, detached directory or data directory of other server into local backup,
+// schema is read from `metadata` directory of adoptPath when present, otherwise from system.tables of current server
+func (b *Backuper) Adopt(adoptPath, backupName, tablePattern, version string, commandId int) (err error) {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	startAdopt := time.Now()
+	if backupName == "" {
+		backupName = NewBackupName()
+	}
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"path":      adoptPath,
+		"operation": "adopt",
+	})
+	if adoptPath == "" {
+		return fmt.Errorf("select path for adopt")
+	}
+	if adoptPath, err = filepath.Abs(adoptPath); err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(adoptPath); statErr != nil {
+		return statErr
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", adoptPath)
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	release, err := b.lockOperation("adopt", backupName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	if err = b.initDisksPaths(ctx, disks); err != nil {
+		return err
+	}
+	diskTypes := map[string]string{}
+	for _, disk := range disks {
+		diskTypes[disk.Name] = disk.Type
+	}
+	adoptedTables, err := findAdoptedTables(adoptPath, log)
+	if err != nil {
+		return err
+	}
+	if len(adoptedTables) == 0 {
+		return fmt.Errorf("no data parts with checksums.txt found in %s", adoptPath)
+	}
+	schemaTables, schemaDatabases, err := readAdoptedMetadata(adoptPath)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Infof("%s doesn't contain metadata directory, use schema from current server", adoptPath)
+		if schemaTables, err = b.ch.GetTables(ctx, ""); err != nil {
+			return err
+		}
+		schemaDatabases, err = b.ch.GetDatabases(ctx, b.cfg, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	backupPath := path.Join(b.DefaultDataPath, "backup", backupName)
+	if _, statErr := os.Stat(backupPath); statErr == nil {
+		return fmt.Errorf("'%s' already exists", backupName)
+	}
+	if err = filesystemhelper.MkdirAll(path.Join(backupPath, "metadata"), b.ch, disks); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if removeErr := os.RemoveAll(backupPath); removeErr != nil {
+				log.Warnf("can't remove %s: %v", backupPath, removeErr)
+			}
+		}
+	}()
+	tablePatterns := []string{"*"}
+	if tablePattern != "" {
+		tablePatterns = strings.Split(tablePattern, ",")
+	}
+	var tableMetas []metadata.TableTitle
+	var backupDataSize, backupMetadataSize uint64
+	usedDatabases := map[string]bool{}
+	for _, adopted := range adoptedTables {
+		schema, found := matchAdoptedTable(adopted, schemaTables)
+		if !found {
+			log.Warnf("schema for %s%s.%s with %d parts not found, skip", adopted.uuid, adopted.database, adopted.table, len(adopted.parts))
+			continue
+		}
+		tableName := fmt.Sprintf("%s.%s", schema.Database, schema.Name)
+		isMatched := false
+		for _, pattern := range tablePatterns {
+			if matched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), tableName); matched {
+				isMatched = true
+				break
+			}
+		}
+		if !isMatched {
+			continue
+		}
+		partsPath := path.Join(backupPath, "shadow", common.TablePathEncode(schema.Database), common.TablePathEncode(schema.Name), "default")
+		partNames := make([]string, 0, len(adopted.parts))
+		for partName := range adopted.parts {
+			partNames = append(partNames, partName)
+		}
+		sort.Strings(partNames)
+		parts := make([]metadata.Part, len(partNames))
+		var tableSize int64
+		for i, partName := range partNames {
+			partSize, linkErr := linkOrCopyDir(adopted.parts[partName], path.Join(partsPath, partName))
+			if linkErr != nil {
+				return fmt.Errorf("can't adopt %s: %v", adopted.parts[partName], linkErr)
+			}
+			tableSize += partSize
+			parts[i] = metadata.Part{Name: partName}
+		}
+		if err = filesystemhelper.Chown(partsPath, b.ch, disks, true); err != nil {
+			return err
+		}
+		disksToPartsMap := map[string][]metadata.Part{"default": parts}
+		var checksums map[string]map[string]string
+		if b.cfg.General.IntegrityManifest {
+			if checksums, err = b.calculateTableChecksums(ctx, backupName, schema, disks, disksToPartsMap); err != nil {
+				return err
+			}
+		}
+		metadataSize, metadataErr := b.createTableMetadata(path.Join(backupPath, "metadata"), metadata.TableMetadata{
+			Table:      schema.Name,
+			Database:   schema.Database,
+			Query:      schema.CreateTableQuery,
+			TotalBytes: uint64(tableSize),
+			Size:       map[string]int64{"default": tableSize},
+			Parts:      disksToPartsMap,
+			Checksums:  checksums,
+		}, disks)
+		if metadataErr != nil {
+			return metadataErr
+		}
+		backupDataSize += uint64(tableSize)
+		backupMetadataSize += metadataSize
+		tableMetas = append(tableMetas, metadata.TableTitle{Database: schema.Database, Table: schema.Name})
+		usedDatabases[schema.Database] = true
+		log.WithFields(apexLog.Fields{
+			"table": tableName,
+			"parts": len(parts),
+			"size":  utils.FormatBytes(uint64(tableSize)),
+		}).Info("adopted")
+	}
+	if len(tableMetas) == 0 {
+		return fmt.Errorf("no tables with known schema matched with '%s' found in %s", tablePattern, adoptPath)
+	}
+	var databases []clickhouse.Database
+	for _, database := range schemaDatabases {
+		if usedDatabases[database.Name] {
+			databases = append(databases, database)
+		}
+	}
+	diskMap := map[string]string{"default": b.DefaultDataPath}
+	if err = b.createBackupMetadata(ctx, path.Join(backupPath, "metadata.json"), backupName, "", version, "adopted", diskMap, diskTypes, disks, backupDataSize, backupMetadataSize, 0, 0, 0, tableMetas, databases, nil, log); err != nil {
+		return err
+	}
+	log.WithFields(apexLog.Fields{
+		"tables":   len(tableMetas),
+		"duration": utils.HumanizeDuration(time.Since(startAdopt)),
+		"size":     utils.FormatBytes(backupDataSize),
+	}).Info("done")
+	return nil
+}
@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdoptDataDirectory(t *testing.T) {
+	dataPath := t.TempDir()
+	tableUUID := "c7f1d3a0-2a4b-4a5e-9a0e-3f1d1c1b1a10"
+	writeFile := func(name, content string) {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(dataPath, name)), 0750))
+		require.NoError(t, os.WriteFile(path.Join(dataPath, name), []byte(content), 0640))
+	}
+	writeFile("metadata/db.sql", "ATTACH DATABASE _ UUID '1f1d1c1b-2a4b-4a5e-9a0e-c7f1d3a01a10'\nENGINE = Atomic\n")
+	writeFile("metadata/db/t1.sql", "ATTACH TABLE _ UUID '"+tableUUID+"'\n(\n    `id` UInt64\n)\nENGINE = MergeTree\nORDER BY id\n")
+	writeFile("metadata/my%2Ddb.sql", "ATTACH DATABASE _\nENGINE = Ordinary\n")
+	writeFile("metadata/my%2Ddb/t2.sql", "ATTACH TABLE _\n(\n    `id` UInt64\n)\nENGINE = MergeTree\nORDER BY id\n")
+	writeFile("store/c7f/"+tableUUID+"/all_1_1_0/checksums.txt", "checksums")
+	writeFile("store/c7f/"+tableUUID+"/all_1_1_0/data.bin", "data")
+	writeFile("store/c7f/"+tableUUID+"/detached/all_2_2_0/checksums.txt", "checksums")
+	writeFile("store/c7f/"+tableUUID+"/detached/broken_all_3_3_0/checksums.txt", "checksums")
+	writeFile("store/c7f/"+tableUUID+"/tmp_merge_all_1_2_1/data.bin", "data")
+	writeFile("data/my%2Ddb/t2/202401_1_1_0/checksums.txt", "checksums")
+	writeFile("shadow/1/store/c7f/"+tableUUID+"/all_5_5_0/checksums.txt", "checksums")
+
+	adoptedTables, err := findAdoptedTables(dataPath, apexLog.WithField("logger", "test"))
+	require.NoError(t, err)
+	require.Len(t, adoptedTables, 2)
+	assert.Equal(t, &adoptedTable{database: "my-db", table: "t2", parts: map[string]string{
+		"202401_1_1_0": path.Join(dataPath, "data/my%2Ddb/t2/202401_1_1_0"),
+	}}, adoptedTables[0])
+	assert.Equal(t, &adoptedTable{uuid: tableUUID, parts: map[string]string{
+		"all_1_1_0": path.Join(dataPath, "store/c7f", tableUUID, "all_1_1_0"),
+		"all_2_2_0": path.Join(dataPath, "store/c7f", tableUUID, "detached/all_2_2_0"),
+	}}, adoptedTables[1])
+
+	tables, databases, err := readAdoptedMetadata(dataPath)
+	require.NoError(t, err)
+	assert.Equal(t, []clickhouse.Database{
+		{Name: "db", Query: "CREATE DATABASE db UUID '1f1d1c1b-2a4b-4a5e-9a0e-c7f1d3a01a10'\nENGINE = Atomic"},
+		{Name: "my-db", Query: "CREATE DATABASE `my-db`\nENGINE = Ordinary"},
+	}, databases)
+	schema, found := matchAdoptedTable(adoptedTables[1], tables)
+	require.True(t, found)
+	assert.Equal(t, clickhouse.Table{
+		Database:         "db",
+		Name:             "t1",
+		UUID:             tableUUID,
+		CreateTableQuery: "CREATE TABLE db.t1 UUID '" + tableUUID + "'\n(\n    `id` UInt64\n)\nENGINE = MergeTree\nORDER BY id",
+	}, schema)
+	schema, found = matchAdoptedTable(adoptedTables[0], tables)
+	require.True(t, found)
+	assert.Equal(t, "CREATE TABLE `my-db`.t2\n(\n    `id` UInt64\n)\nENGINE = MergeTree\nORDER BY id", schema.CreateTableQuery)
+
+	size, err := linkOrCopyDir(adoptedTables[1].parts["all_1_1_0"], path.Join(dataPath, "backup/adopted/shadow/db/t1/default/all_1_1_0"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("checksums")+len("data")), size)
+	body, err := os.ReadFile(path.Join(dataPath, "backup/adopted/shadow/db/t1/default/all_1_1_0/data.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(body))
+
+	_, _, err = readAdoptedMetadata(path.Join(dataPath, "shadow", "1"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+)
+
+// AuditRecord - one destructive operation in general->audit_log_file and general->audit_log_remote
+type AuditRecord struct {
+	Time        string   `json:"time"`
+	Operation   string   `json:"operation"`
+	Backups     []string `json:"backups"`
+	Status      string   `json:"status"`
+	Error       string   `json:"error,omitempty"`
+	Source      string   `json:"source"`
+	User        string   `json:"user"`
+	Command     string   `json:"command"`
+	Host        string   `json:"host"`
+	OperationId string   `json:"operation_id,omitempty"`
+}
+
+// auditLogFileMutex - parallel API operations append to the same file
+var auditLogFileMutex sync.Mutex
+
+// newAuditRecord - who started operation, API user and command from status.Current for commands started from API, OS user and command line for CLI
+func newAuditRecord(commandId int, operation string, backups []string, operationErr error, operationId string, now time.Time) AuditRecord {
+	record := AuditRecord{
+		Time:        now.UTC().Format(time.RFC3339Nano),
+		Operation:   operation,
+		Backups:     backups,
+		Status:      status.SuccessStatus,
+		Source:      "cli",
+		Command:     strings.Join(os.Args, " "),
+		OperationId: operationId,
+	}
+	if operationErr != nil {
+		record.Status = status.ErrorStatus
+		record.Error = operationErr.Error()
+	}
+	if host, err := os.Hostname(); err == nil {
+		record.Host = host
+	}
+	if row, exists := status.Current.GetStatusById(commandId); commandId != status.NotFromAPI && exists {
+		record.Source = "api"
+		record.User = row.User
+		record.Command = row.Command
+	} else if osUser, err := user.Current(); err == nil {
+		record.User = osUser.Username
+	}
+	return record
+}
+
+// writeAuditRecord - audit log errors only logged, destructive operation already finished at this moment
+func (b *Backuper) writeAuditRecord(ctx context.Context, operation string, backups []string, operationErr error) {
+	if b.cfg.General.AuditLogFile == "" && !b.cfg.General.AuditLogRemote {
+		return
+	}
+	now := time.Now()
+	record := newAuditRecord(status.GetCommandId(ctx), operation, backups, operationErr, b.operationId, now)
+	log := b.log.WithField("audit", operation)
+	body, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("can't marshal audit record: %v", err)
+		return
+	}
+	if b.cfg.General.AuditLogFile != "" {
+		if err = appendAuditLogFile(b.cfg.General.AuditLogFile, body); err != nil {
+			log.Errorf("can't write %s: %v", b.cfg.General.AuditLogFile, err)
+		}
+	}
+	if b.cfg.General.AuditLogRemote {
+		name := fmt.Sprintf("%s_%s_%s.json", now.UTC().Format("20060102T150405.000000000Z"), operation, record.Host)
+		if err = b.putAuditRecordRemote(context.WithoutCancel(ctx), name, body); err != nil {
+			log.Errorf("can't upload audit record %s: %v", name, err)
+		}
+	}
+}
+
+// appendAuditLogFile - O_APPEND guarantees each JSON line is written as whole, file never truncated
+func appendAuditLogFile(auditLogFile string, body []byte) error {
+	auditLogFileMutex.Lock()
+	defer auditLogFileMutex.Unlock()
+	f, err := os.OpenFile(auditLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(body, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// putAuditRecordRemote - separate connection, operation could close b.dst before record is written
+func (b *Backuper) putAuditRecordRemote(ctx context.Context, name string, body []byte) error {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("audit_log_remote is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if b.remoteStorage == nil && !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+	}
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to %s: %v", bd.Kind(), err)
+	}
+	defer func() {
+		if closeErr := bd.Close(ctx); closeErr != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", closeErr)
+		}
+	}()
+	return bd.PutAuditRecord(ctx, name, body)
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditRecord(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := newAuditRecord(status.NotFromAPI, "delete_local", []string{"backup1"}, nil, "uuid", now)
+	assert.Equal(t, "2024-01-02T03:04:05Z", record.Time)
+	assert.Equal(t, "cli", record.Source)
+	assert.Equal(t, status.SuccessStatus, record.Status)
+	assert.Equal(t, "uuid", record.OperationId)
+	assert.NotEmpty(t, record.Command)
+
+	commandId, _ := status.Current.Start("delete remote backup2")
+	status.Current.SetUser(commandId, "operator")
+	status.Current.Stop(commandId, nil)
+	record = newAuditRecord(commandId, "delete_remote", []string{"backup2"}, fmt.Errorf("locked"), "1", now)
+	assert.Equal(t, "api", record.Source)
+	assert.Equal(t, "operator", record.User)
+	assert.Equal(t, "delete remote backup2", record.Command)
+	assert.Equal(t, status.ErrorStatus, record.Status)
+	assert.Equal(t, "locked", record.Error)
+}
+
+func TestWriteAuditRecordFile(t *testing.T) {
+	auditLogFile := path.Join(t.TempDir(), "audit.log")
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test")}
+	b.cfg.General.AuditLogFile = auditLogFile
+	b.writeAuditRecord(context.Background(), "retention_local", []string{"backup1"}, nil)
+	b.writeAuditRecord(context.Background(), "restore", []string{"backup2"}, fmt.Errorf("failed"))
+
+	f, err := os.Open(auditLogFile)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := AuditRecord{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+	assert.Equal(t, "retention_local", records[0].Operation)
+	assert.Equal(t, []string{"backup1"}, records[0].Backups)
+	assert.Equal(t, "restore", records[1].Operation)
+	assert.Equal(t, "failed", records[1].Error)
+}
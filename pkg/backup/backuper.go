@@ -5,17 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"io"
 	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/keeper"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/resumable"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
 
 	apexLog "github.com/apex/log"
+	"github.com/google/uuid"
 )
 
 const DirectoryFormat = "directory"
@@ -42,6 +49,73 @@ type Backuper struct {
 	isEmbedded             bool
 	resume                 bool
 	resumableState         *resumable.State
+	// destination - name from general->remote_destinations, empty for primary
+	destination string
+	// compressionDictionary - shared zstd dictionary for small tables during upload
+	compressionDictionary []byte
+	// compressionDictionaries - all dictionaries from incremental backups chain during download
+	compressionDictionaries [][]byte
+	// signer - collect checksums of uploaded metadata files when general->signing_private_key_file defined
+	signer *backupSigner
+	// verifiedSignatures - signatures of downloaded backups verified with general->verify_public_key_file
+	verifiedSignatures      map[string]*backupSignature
+	verifiedSignaturesMutex sync.Mutex
+	// restorePriorityAttaches - how many ATTACH run in parallel with restore_cpu_nice_priority and restore_io_nice_priority
+	restorePriorityAttaches int
+	restorePriorityMutex    sync.Mutex
+	// dryRun - create, upload, delete and restore print planned actions here instead of execution
+	dryRun io.Writer
+	// ifNotExists - create and create_remote do nothing when backup with the same name already exists
+	ifNotExists bool
+	// convertReplicated - restore Replicated engines as non-replicated, for restore production backups into single node
+	convertReplicated bool
+	// reshardCluster - restore data of MergeTree tables with INSERT through Distributed table on this cluster
+	reshardCluster string
+	// syncReplicasCluster - restore lost replica metadata and wait sync on other replicas of current shard in this cluster after data restore
+	syncReplicasCluster string
+	// restoreKeeperOnly - restore only missing Keeper znodes from backup, for disaster recovery after Keeper data loss
+	restoreKeeperOnly bool
+	// encryptedDiskMode - `preserve` or `reencrypt`, how restore data parts of `encrypted` disks when disk key changed after backup
+	encryptedDiskMode string
+	// includeDetached - `create --include-detached`, backup parts from `detached` directory of each table
+	includeDetached bool
+	// restoreDetached - `restore --detached`, place backed up detached parts into `detached` directory without ATTACH
+	restoreDetached bool
+	// repairProjections - `restore --repair-projections`, drop and rebuild broken projections instead of fail ATTACH PART
+	repairProjections bool
+	// consistentSnapshot - `create --consistent-snapshot`, stop merges until FREEZE and skip parts inserted after snapshot barrier
+	consistentSnapshot bool
+	snapshotBarrier    *snapshotBarrier
+	// fsSnapshot - clickhouse->filesystem_snapshot_type, snapshot of volume taken during `create`
+	fsSnapshot *fsSnapshot
+	// afterFreeze - general->hooks after_freeze of current `create`, nil when create doesn't freeze data
+	afterFreeze *afterFreezeHook
+	// cloudSnapshots - clickhouse->cloud_snapshot_type, snapshots taken during `create`, saved into metadata.json
+	cloudSnapshots []metadata.CloudSnapshot
+	// fromSnapshot - `restore --from-snapshot`, create volumes from cloud snapshots of backup
+	fromSnapshot bool
+	// reencryptDisks - `encrypted` disks with changed current key, restored parts on them are rewritten with `reencrypt` mode
+	reencryptDisks map[string]bool
+	// keeperLock - held general->keeper_lock_path lock, shared with nested create and upload inside create_remote and with destinations
+	keeperLock *keeper.Lock
+	// remoteStorage - implementation provided with WithRemoteStorage, used instead of general->remote_storage
+	remoteStorage storage.RemoteStorage
+	// progressCallback - provided with WithProgressCallback, receive finished tables of create, upload, download and restore
+	progressCallback ProgressCallback
+	progressMutex    sync.Mutex
+	// operationId - operation_id field of logs, set in setLogComment
+	operationId string
+	// retryPolicy - general->retries_* for all storage operations, shares general->retries_budget between them
+	retryPolicy *storage.RetryPolicy
+	// onlyFailed - `upload --only-failed`, upload only failed_tables of remote backup
+	onlyFailed bool
+	// resumeRemote - `upload --resume-remote`, reconcile with objects of incomplete remote backup instead of local resumable state
+	resumeRemote  bool
+	remoteObjects *remoteObjects
+	// forceGC - `delete remote --force-gc`, verify object disk data of remaining backups and delete not referenced object disk data after delete
+	forceGC bool
+	// knownRequiredBackups - required_backup of each backup listed during current command, keeps chains of already deleted backups
+	knownRequiredBackups map[string]string
 }
 
 func NewBackuper(cfg *config.Config, opts ...BackuperOpt) *Backuper {
@@ -50,11 +124,12 @@ func NewBackuper(cfg *config.Config, opts ...BackuperOpt) *Backuper {
 		Log:    apexLog.WithField("logger", "clickhouse"),
 	}
 	b := &Backuper{
-		cfg:  cfg,
-		ch:   ch,
-		vers: ch,
-		bs:   nil,
-		log:  apexLog.WithField("logger", "backuper"),
+		cfg:         cfg,
+		ch:          ch,
+		vers:        ch,
+		bs:          nil,
+		log:         apexLog.WithField("logger", "backuper"),
+		retryPolicy: storage.NewRetryPolicy(cfg),
 	}
 	for _, opt := range opts {
 		opt(b)
@@ -62,19 +137,182 @@ func NewBackuper(cfg *config.Config, opts ...BackuperOpt) *Backuper {
 	return b
 }
 
+// newRetrier - Backuper created without NewBackuper in tests has no retryPolicy
+func (b *Backuper) newRetrier() *storage.Retrier {
+	if b.retryPolicy == nil {
+		return storage.NewRetryPolicy(b.cfg).NewRetrier()
+	}
+	return b.retryPolicy.NewRetrier()
+}
+
+// cliOperationId - operation_id in log_comment for commands which run from CLI, commandId for commands which run from API
+var cliOperationId = uuid.New().String()
+
+// setLogComment - mark all queries of current operation with log_comment, to find them in system.query_log
+// the same operation_id added to logs of backuper, clickhouse and remote storage to correlate logs of parallel workers
+func (b *Backuper) setLogComment(operation, backupName string, commandId int) {
+	operationId := cliOperationId
+	if commandId != status.NotFromAPI {
+		operationId = strconv.Itoa(commandId)
+	}
+	b.ch.SetLogComment(clickhouse.FormatLogComment(operation, operationId, backupName))
+	b.operationId = operationId
+	if b.log != nil {
+		b.log = b.log.WithField("operation_id", operationId)
+	}
+	if b.ch.Log != nil {
+		b.ch.Log = b.ch.Log.WithField("operation_id", operationId)
+	}
+}
+
 func WithVersioner(v versioner) BackuperOpt {
 	return func(b *Backuper) {
 		b.vers = v
 	}
 }
 
+// WithIfNotExists - `create --if-not-exists`, allow safe retries of create and create_remote
+func WithIfNotExists(ifNotExists bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.ifNotExists = ifNotExists
+	}
+}
+
 func WithBackupSharder(s backupSharder) BackuperOpt {
 	return func(b *Backuper) {
 		b.bs = s
 	}
 }
 
+// WithLogger - use log of application which embeds clickhouse-backup as library, for backuper and clickhouse connection
+func WithLogger(log *apexLog.Entry) BackuperOpt {
+	return func(b *Backuper) {
+		b.log = log.WithField("logger", "backuper")
+		b.ch.Log = log.WithField("logger", "clickhouse")
+	}
+}
+
+// WithProgressCallback - callback is called after each table finished in create, upload, download and restore
+func WithProgressCallback(callback ProgressCallback) BackuperOpt {
+	return func(b *Backuper) {
+		b.progressCallback = callback
+	}
+}
+
+// WithRemoteStorage - use RemoteStorage implementation instead of built-in storage from general->remote_storage
+// general->remote_storage still defines compression and shall not be `none` or `custom`
+func WithRemoteStorage(remoteStorage storage.RemoteStorage) BackuperOpt {
+	return func(b *Backuper) {
+		b.remoteStorage = remoteStorage
+	}
+}
+
+// newBackupDestination - storage.NewBackupDestination or wrapper for RemoteStorage from WithRemoteStorage
+func (b *Backuper) newBackupDestination(ctx context.Context, calcMaxSize bool, backupName string) (*storage.BackupDestination, error) {
+	if b.remoteStorage != nil {
+		return storage.NewBackupDestinationFromRemoteStorage(b.cfg, b.remoteStorage, b.log.WithField("logger", b.remoteStorage.Kind())), nil
+	}
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, calcMaxSize, backupName)
+	if err == nil && b.operationId != "" {
+		bd.Log = bd.Log.WithField("operation_id", b.operationId)
+	}
+	return bd, err
+}
+
 func (b *Backuper) initDisksPathdsAndBackupDestination(ctx context.Context, disks []clickhouse.Disk, backupName string) error {
+	var err error
+	if err = b.initDisksPaths(ctx, disks); err != nil {
+		return err
+	}
+	if b.cfg.General.RemoteStorage != "none" && b.cfg.General.RemoteStorage != "custom" {
+		b.dst, err = b.newBackupDestination(ctx, true, backupName)
+		if err != nil {
+			return err
+		}
+		if err := b.dst.Connect(ctx); err != nil {
+			return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+		}
+	}
+	return nil
+}
+
+// lockOperation - prevent FREEZE from overlapped CLI runs and server on the same host, look general->lock_file
+// --dry-run only reads state, so it doesn't wait for real operations
+func (b *Backuper) lockOperation(operation, backupName string) (func(), error) {
+	if b.cfg.General.LockFile == "" || b.dryRun != nil {
+		return func() {}, nil
+	}
+	lock, err := utils.AcquireFileLock(b.cfg.General.LockFile, fmt.Sprintf("operation=%s backup=%s", operation, backupName))
+	if err != nil {
+		return nil, fmt.Errorf("can't start %s: %v", operation, err)
+	}
+	return func() {
+		if releaseErr := lock.Release(); releaseErr != nil {
+			b.log.Warnf("can't release %s: %v", b.cfg.General.LockFile, releaseErr)
+		}
+	}, nil
+}
+
+// lockKeeper - when general->keeper_lock enabled, acquire general->keeper_lock_path znode in Keeper, to avoid two replicas of the same shard run create or upload at the same time
+// nested calls reuse already acquired lock, schema only backups don't require lock, so `create_cluster` could run them on other replicas at the same time
+// returned context is canceled when lock is lost, operation shall use it after lockKeeper
+func (b *Backuper) lockKeeper(ctx context.Context, operation, backupName string, schemaOnly bool) (context.Context, func(), error) {
+	if !b.cfg.General.KeeperLock || schemaOnly || b.dryRun != nil {
+		return ctx, func() {}, nil
+	}
+	if b.keeperLock != nil {
+		lockCtx, cancel := b.cancelOnKeeperLockLost(ctx, operation, b.keeperLock)
+		return lockCtx, cancel, nil
+	}
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return nil, nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+	}
+	lockPath, err := b.ch.ApplyMacros(ctx, b.cfg.General.KeeperLockPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	k := &keeper.Keeper{Log: b.log.WithField("logger", "keeper")}
+	if err = k.Connect(ctx, b.ch); err != nil {
+		return nil, nil, fmt.Errorf("can't connect to keeper for keeper_lock: %v", err)
+	}
+	lock, err := k.AcquireLock(lockPath, fmt.Sprintf("operation=%s backup=%s", operation, backupName), b.cfg.General.KeeperLockTTLDuration)
+	if err != nil {
+		k.Close()
+		return nil, nil, fmt.Errorf("can't start %s: %v", operation, err)
+	}
+	b.keeperLock = lock
+	lockCtx, cancel := b.cancelOnKeeperLockLost(ctx, operation, lock)
+	return lockCtx, func() {
+		cancel()
+		if releaseErr := lock.Release(); releaseErr != nil {
+			b.log.Warnf("can't release keeper lock: %v", releaseErr)
+		}
+		k.Close()
+		b.keeperLock = nil
+	}, nil
+}
+
+// cancelOnKeeperLockLost - another replica could acquire lost lock and run the same operation, so current operation is canceled
+func (b *Backuper) cancelOnKeeperLockLost(ctx context.Context, operation string, lock *keeper.Lock) (context.Context, func()) {
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-lockCtx.Done():
+		case <-lock.Lost():
+			b.log.Errorf("cancel %s: %v", operation, lock.Err())
+			cancel(lock.Err())
+		}
+	}()
+	return lockCtx, func() {
+		cancel(context.Canceled)
+	}
+}
+
+// initDisksPaths - init local paths without connection to remote storage
+func (b *Backuper) initDisksPaths(ctx context.Context, disks []clickhouse.Disk) error {
 	var err error
 	if disks == nil {
 		disks, err = b.ch.GetDisks(ctx, true)
@@ -97,15 +335,6 @@ func (b *Backuper) initDisksPathdsAndBackupDestination(ctx context.Context, disk
 		b.EmbeddedBackupDataPath = b.DefaultDataPath
 	}
 	b.DiskToPathMap = diskMap
-	if b.cfg.General.RemoteStorage != "none" && b.cfg.General.RemoteStorage != "custom" {
-		b.dst, err = storage.NewBackupDestination(ctx, b.cfg, b.ch, true, backupName)
-		if err != nil {
-			return err
-		}
-		if err := b.dst.Connect(ctx); err != nil {
-			return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
-		}
-	}
 	return nil
 }
 
@@ -119,11 +348,13 @@ func (b *Backuper) getLocalBackupDataPathForTable(backupName string, disk string
 
 // populateBackupShardField populates the BackupShard field for a slice of Table structs
 func (b *Backuper) populateBackupShardField(ctx context.Context, tables []clickhouse.Table) error {
-	// By default, have all fields populated to full backup unless the table is to be skipped
+	// By default, have all fields populated to full backup unless the table is to be skipped or engine matched with clickhouse->skip_data_table_engines
 	for i := range tables {
 		tables[i].BackupType = clickhouse.ShardBackupFull
 		if tables[i].Skip {
 			tables[i].BackupType = clickhouse.ShardBackupNone
+		} else if clickhouse.IsTableEngineMatched(tables[i].Engine, b.cfg.ClickHouse.SkipDataTableEngines) {
+			tables[i].BackupType = clickhouse.ShardBackupSchema
 		}
 	}
 	if !doesShard(b.cfg.General.ShardedOperationMode) {
@@ -160,12 +391,25 @@ func (b *Backuper) populateBackupShardField(ctx context.Context, tables []clickh
 	return nil
 }
 
+// isDiskTypeObject - local files on object disk are metadata files which contain references to data objects in bucket
 func (b *Backuper) isDiskTypeObject(diskType string) bool {
 	return diskType == "s3" || diskType == "azure_blob_storage" || diskType == "azure"
 }
 
+// isDiskTypeWrapper - `encrypted` and `cache` disks don't store data itself, they wrap other disk with the same path prefix
+func (b *Backuper) isDiskTypeWrapper(diskType string) bool {
+	return diskType == "encrypted" || diskType == "cache"
+}
+
+// isDiskTypeStatic - `web` and `s3_plain` disks are read only and don't keep local metadata files, data parts can't be frozen,
+// data objects stay available by the same endpoint and table will see them again after restore of schema
+func (b *Backuper) isDiskTypeStatic(diskType string) bool {
+	return diskType == "web" || diskType == "s3_plain"
+}
+
+// isDiskTypeEncryptedObject - check `encrypted` or `cache` disk which wrap object disk, local files contain the same metadata as object disk
 func (b *Backuper) isDiskTypeEncryptedObject(disk clickhouse.Disk, disks []clickhouse.Disk) bool {
-	if disk.Type != "encrypted" {
+	if !b.isDiskTypeWrapper(disk.Type) {
 		return false
 	}
 	underlyingIdx := -1
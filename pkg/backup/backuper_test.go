@@ -183,3 +183,36 @@ func TestPopulateBackupShardField(t *testing.T) {
 		)
 	}
 }
+
+func TestDiskTypes(t *testing.T) {
+	b := &Backuper{}
+	disks := []clickhouse.Disk{
+		{Name: "default", Path: "/var/lib/clickhouse/", Type: "local"},
+		{Name: "s3", Path: "/var/lib/clickhouse/disks/s3/", Type: "s3"},
+		{Name: "s3_cache", Path: "/var/lib/clickhouse/disks/s3/", Type: "cache"},
+		{Name: "local_cache", Path: "/var/lib/clickhouse/disks/local_cache/", Type: "cache"},
+		{Name: "web", Path: "/var/lib/clickhouse/disks/web/", Type: "web"},
+		{Name: "s3_plain", Path: "/var/lib/clickhouse/disks/s3_plain/", Type: "s3_plain"},
+	}
+	if !b.isDiskTypeEncryptedObject(disks[2], disks) {
+		t.Errorf("cache disk over s3 shall be object disk")
+	}
+	if b.isDiskTypeEncryptedObject(disks[3], disks) {
+		t.Errorf("cache disk over local disk shall not be object disk")
+	}
+	testcases := []struct {
+		dataPaths []string
+		expected  bool
+	}{
+		{[]string{"/var/lib/clickhouse/disks/web/store/123/"}, true},
+		{[]string{"/var/lib/clickhouse/disks/web/store/123/", "/var/lib/clickhouse/disks/s3_plain/store/123/"}, true},
+		{[]string{"/var/lib/clickhouse/disks/web/store/123/", "/var/lib/clickhouse/store/123/"}, false},
+		{[]string{"/var/lib/clickhouse/disks/s3/store/123/"}, false},
+		{nil, false},
+	}
+	for _, tc := range testcases {
+		if actual := b.isTableOnStaticDisks(&clickhouse.Table{DataPaths: tc.dataPaths}, disks); actual != tc.expected {
+			t.Errorf("isTableOnStaticDisks(%v) expected %v, got %v", tc.dataPaths, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// getRequiredBackupsChain - required backups from requiredBackup to full backup, backup which is not found is the last item
+func getRequiredBackupsChain(backups []storage.Backup, requiredBackup string) []string {
+	backupsByName := make(map[string]storage.Backup, len(backups))
+	for _, backup := range backups {
+		backupsByName[backup.BackupName] = backup
+	}
+	var chain []string
+	visited := map[string]struct{}{}
+	for name := requiredBackup; name != ""; name = backupsByName[name].RequiredBackup {
+		if _, isVisited := visited[name]; isVisited {
+			break
+		}
+		visited[name] = struct{}{}
+		chain = append(chain, name)
+		if _, exists := backupsByName[name]; !exists {
+			break
+		}
+	}
+	return chain
+}
+
+// getBackupDependents - backups which directly require each backup, sorted by upload date
+func getBackupDependents(backups []storage.Backup) map[string][]storage.Backup {
+	dependents := map[string][]storage.Backup{}
+	for _, backup := range backups {
+		if backup.RequiredBackup != "" {
+			dependents[backup.RequiredBackup] = append(dependents[backup.RequiredBackup], backup)
+		}
+	}
+	for name := range dependents {
+		sort.SliceStable(dependents[name], func(i, j int) bool {
+			return dependents[name][i].UploadDate.Before(dependents[name][j].UploadDate)
+		})
+	}
+	return dependents
+}
+
+// excludeBackupsWithLiveDependents - retention can't delete base backup while any kept backup requires it, for example backup without upload date,
+// return backups to delete without such base backups, and kept dependents of each excluded base backup
+func excludeBackupsWithLiveDependents(backups []storage.Backup, backupsToDelete map[string][]storage.Backup) (map[string][]storage.Backup, map[string][]string) {
+	deleted := map[string]bool{}
+	for _, policyBackups := range backupsToDelete {
+		for _, backup := range policyBackups {
+			deleted[backup.BackupName] = true
+		}
+	}
+	excluded := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, backup := range backups {
+			if backup.RequiredBackup != "" && !deleted[backup.BackupName] && deleted[backup.RequiredBackup] {
+				deleted[backup.RequiredBackup] = false
+				excluded[backup.RequiredBackup] = true
+				changed = true
+			}
+		}
+	}
+	liveDependents := map[string][]string{}
+	for _, backup := range backups {
+		if excluded[backup.RequiredBackup] && !deleted[backup.BackupName] {
+			liveDependents[backup.RequiredBackup] = append(liveDependents[backup.RequiredBackup], backup.BackupName)
+		}
+	}
+	result := map[string][]storage.Backup{}
+	for policyName, policyBackups := range backupsToDelete {
+		for _, backup := range policyBackups {
+			if deleted[backup.BackupName] {
+				result[policyName] = append(result[policyName], backup)
+			}
+		}
+	}
+	return result, liveDependents
+}
+
+// printBackupChain - tree of all backups which share full backup with backupName, unique size is uploaded by backup itself, shared size is stored in its required backups
+func printBackupChain(w io.Writer, backups []storage.Backup, backupName string, retentionStatus map[string]string) error {
+	backupsByName := make(map[string]storage.Backup, len(backups))
+	for _, backup := range backups {
+		backupsByName[backup.BackupName] = backup
+	}
+	current, exists := backupsByName[backupName]
+	if !exists {
+		return fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	root := backupName
+	var sharedSize uint64
+	required := getRequiredBackupsChain(backups, current.RequiredBackup)
+	for _, name := range required {
+		backup, exists := backupsByName[name]
+		if !exists {
+			if _, err := fmt.Fprintf(w, "broken chain, required backup %s not found", name); err != nil {
+				return err
+			}
+			if len(current.RequiredBackupsChain) > 0 {
+				if _, err := fmt.Fprintf(w, ", chain during upload %s", strings.Join(current.RequiredBackupsChain, " -> ")); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+			break
+		}
+		root = name
+		sharedSize += remoteBackupSize(backup)
+	}
+	if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", "backup", "upload_date", "unique", "shared", "status"); err != nil {
+		return err
+	}
+	dependents := getBackupDependents(backups)
+	var treeSize uint64
+	treeBackups := 0
+	var printTree func(backup storage.Backup, depth int, shared uint64) error
+	printTree = func(backup storage.Backup, depth int, shared uint64) error {
+		treeSize += remoteBackupSize(backup)
+		treeBackups++
+		name := backup.BackupName
+		if depth > 0 {
+			name = strings.Repeat("  ", depth-1) + "└ " + name
+		}
+		if backup.BackupName == backupName {
+			name += " *"
+		}
+		backupStatus := retentionStatus[backup.BackupName]
+		if backup.Broken != "" {
+			backupStatus = backup.Broken
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, backup.UploadDate.Format("02/01/2006 15:04:05"), utils.FormatBytes(remoteBackupSize(backup)), utils.FormatBytes(shared), backupStatus); err != nil {
+			return err
+		}
+		for _, dependent := range dependents[backup.BackupName] {
+			if err := printTree(dependent, depth+1, shared+remoteBackupSize(backup)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := printTree(backupsByName[root], 0, 0); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d backups in chain, total %s, %s requires %d backups, unique %s, shared %s\n", treeBackups, utils.FormatBytes(treeSize), backupName, len(required), utils.FormatBytes(remoteBackupSize(current)), utils.FormatBytes(sharedSize))
+	return err
+}
+
+// Chain - `chain` command, print required and dependent backups of remote backup with retention status
+func (b *Backuper) Chain(backupName string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select backup for chain")
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "chain",
+	})
+	backupList, err := b.GetRemoteBackups(ctx, true)
+	if err != nil {
+		return err
+	}
+	retentionStatus := map[string]string{}
+	if b.cfg.General.BackupsToKeepRemote > 0 || len(b.cfg.General.RetentionPolicies) > 0 {
+		sortedList := make([]storage.Backup, len(backupList))
+		copy(sortedList, backupList)
+		backupsToDelete, liveDependents := excludeBackupsWithLiveDependents(backupList, storage.GetBackupsToDeleteRemoteByPolicies(sortedList, b.cfg.General.BackupsToKeepRemote, b.cfg.General.RetentionPolicies, time.Now()))
+		dependents := getBackupDependents(backupList)
+		for policyName, policyBackups := range backupsToDelete {
+			for _, backup := range policyBackups {
+				retentionStatus[backup.BackupName] = fmt.Sprintf("will be deleted by retention policy %s", policyName)
+				if len(dependents[backup.BackupName]) > 0 {
+					retentionStatus[backup.BackupName] += fmt.Sprintf(" with %d dependent backups", len(dependents[backup.BackupName]))
+				}
+			}
+		}
+		for name, names := range liveDependents {
+			retentionStatus[name] = fmt.Sprintf("retention keeps it, required by %s", strings.Join(names, ", "))
+			log.Warnf("%s shall be deleted by retention, but required by %s", name, strings.Join(names, ", "))
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	if err = printBackupChain(w, backupList, backupName, retentionStatus); err != nil {
+		return err
+	}
+	return w.Flush()
+}
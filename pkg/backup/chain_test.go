@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chainTestBackup(name, required string, day int, size uint64) storage.Backup {
+	return storage.Backup{
+		BackupMetadata: metadata.BackupMetadata{BackupName: name, RequiredBackup: required, DataSize: size},
+		UploadDate:     time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestGetRequiredBackupsChain(t *testing.T) {
+	backups := []storage.Backup{
+		chainTestBackup("full", "", 1, 100),
+		chainTestBackup("inc1", "full", 2, 10),
+		chainTestBackup("inc2", "inc1", 3, 5),
+		chainTestBackup("orphan", "deleted", 4, 1),
+	}
+	assert.Equal(t, []string{"inc1", "full"}, getRequiredBackupsChain(backups, "inc1"))
+	assert.Equal(t, []string{"deleted"}, getRequiredBackupsChain(backups, "deleted"))
+	assert.Nil(t, getRequiredBackupsChain(backups, ""))
+	dependents := getBackupDependents(backups)
+	assert.Len(t, dependents["full"], 1)
+	assert.Equal(t, "inc2", dependents["inc1"][0].BackupName)
+}
+
+func TestExcludeBackupsWithLiveDependents(t *testing.T) {
+	backups := []storage.Backup{
+		chainTestBackup("full", "", 1, 100),
+		chainTestBackup("inc1", "full", 2, 10),
+		chainTestBackup("inc2", "inc1", 3, 5),
+		chainTestBackup("full2", "", 4, 100),
+		chainTestBackup("inc3", "full2", 5, 5),
+	}
+	// inc2 has no upload date yet, so it is kept, but full and inc1 are candidates
+	toDelete := map[string][]storage.Backup{"default": {backups[0], backups[1], backups[3], backups[4]}}
+	result, liveDependents := excludeBackupsWithLiveDependents(backups, toDelete)
+	assert.Equal(t, []storage.Backup{backups[3], backups[4]}, result["default"])
+	assert.Equal(t, map[string][]string{"inc1": {"inc2"}, "full": {"inc1"}}, liveDependents)
+}
+
+func TestPrintBackupChain(t *testing.T) {
+	backups := []storage.Backup{
+		chainTestBackup("full", "", 1, 100),
+		chainTestBackup("inc1", "full", 2, 10),
+		chainTestBackup("inc2", "inc1", 3, 5),
+		chainTestBackup("inc1b", "full", 4, 7),
+		chainTestBackup("other", "", 5, 1),
+	}
+	out := &bytes.Buffer{}
+	require.NoError(t, printBackupChain(out, backups, "inc2", map[string]string{"inc1b": "will be deleted by retention policy default"}))
+	assert.Equal(t, "backup\tupload_date\tunique\tshared\tstatus\n"+
+		"full\t01/03/2024 00:00:00\t100B\t0B\t\n"+
+		"└ inc1\t02/03/2024 00:00:00\t10B\t100B\t\n"+
+		"  └ inc2 *\t03/03/2024 00:00:00\t5B\t110B\t\n"+
+		"└ inc1b\t04/03/2024 00:00:00\t7B\t100B\twill be deleted by retention policy default\n"+
+		"\n4 backups in chain, total 122B, inc2 requires 2 backups, unique 5B, shared 110B\n", out.String())
+
+	backups = append(backups, storage.Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "orphan", RequiredBackup: "deleted", RequiredBackupsChain: []string{"deleted", "full0"}}})
+	out.Reset()
+	require.NoError(t, printBackupChain(out, backups, "orphan", nil))
+	assert.Contains(t, out.String(), "broken chain, required backup deleted not found, chain during upload deleted -> full0\n")
+	assert.Error(t, printBackupChain(out, backups, "missing", nil))
+}
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"fmt"
+
+	apexLog "github.com/apex/log"
+)
+
+// Clone - copy database via temporary local backup and restore with database mapping, data parts are hardlinks and attached to target tables, temporary backup is always deleted
+func (b *Backuper) Clone(sourceDB, targetDB, targetHost string, targetPort uint, schemaOnly, dropExists bool, version string, commandId int) error {
+	if sourceDB == "" || targetDB == "" {
+		return fmt.Errorf("--source and --target are required")
+	}
+	if sourceDB == targetDB && targetHost == "" && targetPort == 0 {
+		return fmt.Errorf("--source and --target shall be different for the same clickhouse-server")
+	}
+	backupName := "clone_" + NewBackupName()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "clone",
+	})
+	tablePattern := sourceDB + ".*"
+	if err := b.CreateBackup(backupName, "", tablePattern, nil, schemaOnly, false, false, false, false, false, version, commandId); err != nil {
+		return fmt.Errorf("can't create temporary backup: %v", err)
+	}
+	defer func() {
+		if err := b.Delete("local", backupName, commandId); err != nil {
+			log.Errorf("can't delete temporary backup: %v", err)
+		}
+	}()
+
+	restoreBackuper := b
+	if targetHost != "" || targetPort != 0 {
+		targetCfg := *b.cfg
+		if targetHost != "" {
+			targetCfg.ClickHouse.Host = targetHost
+		}
+		if targetPort != 0 {
+			targetCfg.ClickHouse.Port = targetPort
+		}
+		restoreBackuper = NewBackuper(&targetCfg)
+		restoreBackuper.log = b.log.WithField("target", fmt.Sprintf("%s:%d", targetCfg.ClickHouse.Host, targetCfg.ClickHouse.Port))
+	}
+	databaseMapping := []string{sourceDB + ":" + targetDB}
+	if err := restoreBackuper.Restore(backupName, tablePattern, databaseMapping, nil, schemaOnly, false, dropExists, false, false, false, false, false, commandId); err != nil {
+		return fmt.Errorf("can't restore %s to %s: %v", sourceDB, targetDB, err)
+	}
+	log.Infof("%s cloned to %s", sourceDB, targetDB)
+	return nil
+}
@@ -0,0 +1,210 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// WithFromSnapshot - `restore --from-snapshot`, create volumes from cloud snapshots of backup instead of restore tables
+func WithFromSnapshot(fromSnapshot bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.fromSnapshot = fromSnapshot
+	}
+}
+
+// cloudSnapshotProvider - clickhouse->cloud_snapshot_type, provider CLI shall be installed and authorized on clickhouse-server host
+type cloudSnapshotProvider interface {
+	// CreateSnapshot - return id which allows to create volume from snapshot
+	CreateSnapshot(ctx context.Context, volume, name string) (string, error)
+	// CreateVolume - return id of new volume
+	CreateVolume(ctx context.Context, snapshot metadata.CloudSnapshot, name string) (string, error)
+	DeleteSnapshot(ctx context.Context, snapshot metadata.CloudSnapshot) error
+}
+
+// cloudSnapshotExec - replaced in tests
+var cloudSnapshotExec = func(ctx context.Context, cmd string, args ...string) (string, error) {
+	out, err := utils.ExecCmdOut(ctx, 30*time.Minute, cmd, args...)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %v, output: %s", cmd, strings.Join(args, " "), err, strings.TrimSpace(out))
+	}
+	return strings.TrimSpace(out), nil
+}
+
+type awsEBSSnapshotProvider struct {
+	zone string
+}
+
+func (p *awsEBSSnapshotProvider) CreateSnapshot(ctx context.Context, volume, name string) (string, error) {
+	return cloudSnapshotExec(ctx, "aws", "ec2", "create-snapshot", "--volume-id", volume, "--description", name, "--query", "SnapshotId", "--output", "text")
+}
+
+func (p *awsEBSSnapshotProvider) CreateVolume(ctx context.Context, snapshot metadata.CloudSnapshot, _ string) (string, error) {
+	if p.zone == "" {
+		return "", fmt.Errorf("aws_ebs requires `cloud_snapshot_zone` with availability zone of new volume")
+	}
+	return cloudSnapshotExec(ctx, "aws", "ec2", "create-volume", "--snapshot-id", snapshot.SnapshotId, "--availability-zone", p.zone, "--query", "VolumeId", "--output", "text")
+}
+
+func (p *awsEBSSnapshotProvider) DeleteSnapshot(ctx context.Context, snapshot metadata.CloudSnapshot) error {
+	_, err := cloudSnapshotExec(ctx, "aws", "ec2", "delete-snapshot", "--snapshot-id", snapshot.SnapshotId)
+	return err
+}
+
+type gcpPDSnapshotProvider struct {
+	zone string
+}
+
+func (p *gcpPDSnapshotProvider) CreateSnapshot(ctx context.Context, volume, name string) (string, error) {
+	if _, err := cloudSnapshotExec(ctx, "gcloud", "compute", "snapshots", "create", name, "--source-disk", volume, "--source-disk-zone", p.zone); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (p *gcpPDSnapshotProvider) CreateVolume(ctx context.Context, snapshot metadata.CloudSnapshot, name string) (string, error) {
+	if _, err := cloudSnapshotExec(ctx, "gcloud", "compute", "disks", "create", name, "--source-snapshot", snapshot.SnapshotId, "--zone", p.zone); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (p *gcpPDSnapshotProvider) DeleteSnapshot(ctx context.Context, snapshot metadata.CloudSnapshot) error {
+	_, err := cloudSnapshotExec(ctx, "gcloud", "compute", "snapshots", "delete", snapshot.SnapshotId, "--quiet")
+	return err
+}
+
+type azureDiskSnapshotProvider struct {
+	resourceGroup string
+}
+
+func (p *azureDiskSnapshotProvider) CreateSnapshot(ctx context.Context, volume, name string) (string, error) {
+	return cloudSnapshotExec(ctx, "az", "snapshot", "create", "--resource-group", p.resourceGroup, "--name", name, "--source", volume, "--incremental", "true", "--query", "id", "--output", "tsv")
+}
+
+func (p *azureDiskSnapshotProvider) CreateVolume(ctx context.Context, snapshot metadata.CloudSnapshot, name string) (string, error) {
+	return cloudSnapshotExec(ctx, "az", "disk", "create", "--resource-group", p.resourceGroup, "--name", name, "--source", snapshot.SnapshotId, "--query", "id", "--output", "tsv")
+}
+
+// DeleteSnapshot - snapshot id is full resource id, so resource group is not required
+func (p *azureDiskSnapshotProvider) DeleteSnapshot(ctx context.Context, snapshot metadata.CloudSnapshot) error {
+	_, err := cloudSnapshotExec(ctx, "az", "snapshot", "delete", "--ids", snapshot.SnapshotId)
+	return err
+}
+
+// newCloudSnapshotProvider - zone is taken from backup metadata when clickhouse->cloud_snapshot_zone is empty during restore
+func newCloudSnapshotProvider(snapshotType, zone string, cfg *config.Config) (cloudSnapshotProvider, error) {
+	if cfg.ClickHouse.CloudSnapshotZone != "" {
+		zone = cfg.ClickHouse.CloudSnapshotZone
+	}
+	switch snapshotType {
+	case "aws_ebs":
+		return &awsEBSSnapshotProvider{zone: zone}, nil
+	case "gcp_pd":
+		return &gcpPDSnapshotProvider{zone: zone}, nil
+	case "azure_disk":
+		if cfg.ClickHouse.CloudSnapshotResourceGroup == "" {
+			return nil, fmt.Errorf("azure_disk requires `cloud_snapshot_resource_group`")
+		}
+		return &azureDiskSnapshotProvider{resourceGroup: cfg.ClickHouse.CloudSnapshotResourceGroup}, nil
+	}
+	return nil, fmt.Errorf("unsupported cloud_snapshot_type: %s", snapshotType)
+}
+
+var cloudResourceNameRE = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// getCloudResourceName - GCP snapshot and disk names shall match [a-z]([-a-z0-9]*[a-z0-9])? and contain up to 63 characters, Azure and AWS accept them too
+func getCloudResourceName(prefix string, names ...string) string {
+	name := prefix
+	for _, n := range names {
+		name += "-" + cloudResourceNameRE.ReplaceAllString(strings.ToLower(path.Base(n)), "-")
+	}
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// createCloudSnapshots - merges are stopped and page cache is flushed before snapshots, so all volumes contain the same set of parts,
+// snapshots are crash consistent, parts inserted during snapshots are checked by clickhouse-server after volumes restore
+func (b *Backuper) createCloudSnapshots(ctx context.Context, backupName string, tables []clickhouse.Table, log *apexLog.Entry) error {
+	provider, err := newCloudSnapshotProvider(b.cfg.ClickHouse.CloudSnapshotType, b.cfg.ClickHouse.CloudSnapshotZone, b.cfg)
+	if err != nil {
+		return err
+	}
+	b.cloudSnapshots = nil
+	defer b.startAllMerges(log)
+	if err = b.stopMergesForSnapshot(ctx, tables, log); err != nil {
+		return err
+	}
+	if _, err = cloudSnapshotExec(ctx, "sync"); err != nil {
+		return err
+	}
+	for _, volume := range b.cfg.ClickHouse.CloudSnapshotVolumes {
+		start := time.Now()
+		snapshotId, createErr := provider.CreateSnapshot(ctx, volume, getCloudResourceName("clickhouse-backup", backupName, volume))
+		if createErr != nil {
+			return fmt.Errorf("can't create %s snapshot of %s: %v", b.cfg.ClickHouse.CloudSnapshotType, volume, createErr)
+		}
+		b.cloudSnapshots = append(b.cloudSnapshots, metadata.CloudSnapshot{
+			Type:       b.cfg.ClickHouse.CloudSnapshotType,
+			Volume:     volume,
+			SnapshotId: snapshotId,
+			Zone:       b.cfg.ClickHouse.CloudSnapshotZone,
+		})
+		log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Infof("%s snapshot %s of %s created", b.cfg.ClickHouse.CloudSnapshotType, snapshotId, volume)
+	}
+	return nil
+}
+
+// deleteCloudSnapshots - used when backup is deleted and to rollback snapshots of failed `create`, try to delete all snapshots and return all errors
+func (b *Backuper) deleteCloudSnapshots(ctx context.Context, snapshots []metadata.CloudSnapshot, log *apexLog.Entry) error {
+	var deleteErrors []error
+	for _, snapshot := range snapshots {
+		provider, err := newCloudSnapshotProvider(snapshot.Type, snapshot.Zone, b.cfg)
+		if err == nil {
+			err = provider.DeleteSnapshot(ctx, snapshot)
+		}
+		if err != nil {
+			deleteErrors = append(deleteErrors, fmt.Errorf("can't delete %s snapshot %s of %s: %v", snapshot.Type, snapshot.SnapshotId, snapshot.Volume, err))
+			continue
+		}
+		log.Infof("%s snapshot %s of %s deleted", snapshot.Type, snapshot.SnapshotId, snapshot.Volume)
+	}
+	return errors.Join(deleteErrors...)
+}
+
+// restoreFromCloudSnapshots - create new volumes only, replace volumes requires stop clickhouse-server and depends on infrastructure, so it is printed as instruction
+func (b *Backuper) restoreFromCloudSnapshots(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, log *apexLog.Entry) error {
+	if len(backupMetadata.CloudSnapshots) == 0 {
+		return fmt.Errorf("backup '%s' doesn't contain cloud snapshots, --from-snapshot requires backup created with `clickhouse->cloud_snapshot_type`", backupName)
+	}
+	createdVolumes := make([]string, 0, len(backupMetadata.CloudSnapshots))
+	for _, snapshot := range backupMetadata.CloudSnapshots {
+		provider, err := newCloudSnapshotProvider(snapshot.Type, snapshot.Zone, b.cfg)
+		if err != nil {
+			return err
+		}
+		volume, err := provider.CreateVolume(ctx, snapshot, getCloudResourceName("restored", backupName, snapshot.Volume))
+		if err != nil {
+			return fmt.Errorf("can't create volume from %s snapshot %s: %v", snapshot.Type, snapshot.SnapshotId, err)
+		}
+		createdVolumes = append(createdVolumes, volume)
+		log.Infof("%s volume %s created from snapshot %s of %s", snapshot.Type, volume, snapshot.SnapshotId, snapshot.Volume)
+	}
+	log.Info("to finish restore stop clickhouse-server, detach old volumes, attach and mount new volumes on the same mount points, then start clickhouse-server")
+	for i, snapshot := range backupMetadata.CloudSnapshots {
+		log.Infof("replace %s with %s", snapshot.Volume, createdVolumes[i])
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCloudResourceName(t *testing.T) {
+	assert.Equal(t, "clickhouse-backup-2024-01-02t03-04-05-vol-0123", getCloudResourceName("clickhouse-backup", "2024-01-02T03:04:05", "vol-0123"))
+	assert.Equal(t, "restored-backup-data-disk", getCloudResourceName("restored", "backup", "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/disks/Data_Disk"))
+	name := getCloudResourceName("clickhouse-backup", strings.Repeat("a", 44)+"_", "disk")
+	assert.Equal(t, "clickhouse-backup-"+strings.Repeat("a", 44), name)
+}
+
+func TestCloudSnapshotProviders(t *testing.T) {
+	var commands []string
+	oldExec := cloudSnapshotExec
+	cloudSnapshotExec = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		commands = append(commands, cmd+" "+strings.Join(args, " "))
+		return "id-1", nil
+	}
+	defer func() { cloudSnapshotExec = oldExec }()
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+
+	provider, err := newCloudSnapshotProvider("aws_ebs", "", cfg)
+	require.NoError(t, err)
+	snapshotId, err := provider.CreateSnapshot(ctx, "vol-1", "clickhouse-backup-b1-vol-1")
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", snapshotId)
+	_, err = provider.CreateVolume(ctx, metadata.CloudSnapshot{Type: "aws_ebs", Volume: "vol-1", SnapshotId: "snap-1"}, "restored-b1-vol-1")
+	assert.Error(t, err)
+	provider, err = newCloudSnapshotProvider("aws_ebs", "us-east-1a", cfg)
+	require.NoError(t, err)
+	volume, err := provider.CreateVolume(ctx, metadata.CloudSnapshot{Type: "aws_ebs", Volume: "vol-1", SnapshotId: "snap-1"}, "restored-b1-vol-1")
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", volume)
+
+	provider, err = newCloudSnapshotProvider("gcp_pd", "europe-west1-b", cfg)
+	require.NoError(t, err)
+	snapshotId, err = provider.CreateSnapshot(ctx, "data", "clickhouse-backup-b1-data")
+	require.NoError(t, err)
+	assert.Equal(t, "clickhouse-backup-b1-data", snapshotId)
+	volume, err = provider.CreateVolume(ctx, metadata.CloudSnapshot{Type: "gcp_pd", Volume: "data", SnapshotId: snapshotId}, "restored-b1-data")
+	require.NoError(t, err)
+	assert.Equal(t, "restored-b1-data", volume)
+
+	_, err = newCloudSnapshotProvider("azure_disk", "", cfg)
+	assert.Error(t, err)
+	cfg.ClickHouse.CloudSnapshotResourceGroup = "rg"
+	provider, err = newCloudSnapshotProvider("azure_disk", "", cfg)
+	require.NoError(t, err)
+	_, err = provider.CreateSnapshot(ctx, "data", "clickhouse-backup-b1-data")
+	require.NoError(t, err)
+	_, err = provider.CreateVolume(ctx, metadata.CloudSnapshot{Type: "azure_disk", Volume: "data", SnapshotId: "/snapshots/s1"}, "restored-b1-data")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"aws ec2 create-snapshot --volume-id vol-1 --description clickhouse-backup-b1-vol-1 --query SnapshotId --output text",
+		"aws ec2 create-volume --snapshot-id snap-1 --availability-zone us-east-1a --query VolumeId --output text",
+		"gcloud compute snapshots create clickhouse-backup-b1-data --source-disk data --source-disk-zone europe-west1-b",
+		"gcloud compute disks create restored-b1-data --source-snapshot clickhouse-backup-b1-data --zone europe-west1-b",
+		"az snapshot create --resource-group rg --name clickhouse-backup-b1-data --source data --incremental true --query id --output tsv",
+		"az disk create --resource-group rg --name restored-b1-data --source /snapshots/s1 --query id --output tsv",
+	}, commands)
+
+	_, err = newCloudSnapshotProvider("openstack", "", cfg)
+	assert.Error(t, err)
+}
+
+func TestDeleteCloudSnapshots(t *testing.T) {
+	var commands []string
+	oldExec := cloudSnapshotExec
+	cloudSnapshotExec = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		commands = append(commands, cmd+" "+strings.Join(args, " "))
+		if len(args) > 3 && args[3] == "snap-broken" {
+			return "", fmt.Errorf("snapshot is in use")
+		}
+		return "", nil
+	}
+	defer func() { cloudSnapshotExec = oldExec }()
+	cfg := config.DefaultConfig()
+	cfg.ClickHouse.CloudSnapshotResourceGroup = "rg"
+	b := NewBackuper(cfg)
+	log := apexLog.WithField("logger", "test")
+
+	snapshots := []metadata.CloudSnapshot{
+		{Type: "aws_ebs", Volume: "vol-1", SnapshotId: "snap-broken"},
+		{Type: "aws_ebs", Volume: "vol-2", SnapshotId: "snap-2"},
+		{Type: "gcp_pd", Volume: "data", SnapshotId: "clickhouse-backup-b1-data", Zone: "europe-west1-b"},
+		{Type: "azure_disk", Volume: "data", SnapshotId: "/snapshots/s1"},
+	}
+	err := b.deleteCloudSnapshots(context.Background(), snapshots, log)
+	assert.ErrorContains(t, err, "snap-broken")
+	assert.NotContains(t, err.Error(), "snap-2")
+	assert.Equal(t, []string{
+		"aws ec2 delete-snapshot --snapshot-id snap-broken",
+		"aws ec2 delete-snapshot --snapshot-id snap-2",
+		"gcloud compute snapshots delete clickhouse-backup-b1-data --quiet",
+		"az snapshot delete --ids /snapshots/s1",
+	}, commands, "all snapshots shall be deleted even when one of them failed")
+}
@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// tableComparison - EstimatedUniqueBytes is part of table size on all disks proportional to count of parts which are absent in other backup,
+// part sizes are not stored in backup metadata
+type tableComparison struct {
+	Table                string
+	Change               string
+	PartsAdded           int
+	PartsRemoved         int
+	SizeA                int64
+	SizeB                int64
+	EstimatedUniqueBytes int64
+	SchemaDiffs          []schemaDiff
+}
+
+// Compare - print table level differences between backupA and backupB, schema changes, parts added and removed in backupB, size delta and estimated bytes which present only in backupB
+func (b *Backuper) Compare(backupA, backupB, tablePattern string, remote bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    fmt.Sprintf("%s,%s", backupA, backupB),
+		"operation": "compare",
+	})
+	if backupA == "" || backupB == "" {
+		return fmt.Errorf("two backup names are required")
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if remote {
+		if err = b.initDisksPathdsAndBackupDestination(ctx, nil, ""); err != nil {
+			return err
+		}
+		if b.dst == nil {
+			return fmt.Errorf("remote_storage: %s doesn't support compare remote backups", b.cfg.General.RemoteStorage)
+		}
+		defer func() {
+			if closeErr := b.dst.Close(ctx); closeErr != nil {
+				log.Warnf("can't close BackupDestination error: %v", closeErr)
+			}
+		}()
+	} else if err = b.initDisksPaths(ctx, nil); err != nil {
+		return err
+	}
+	tablesA, err := b.getBackupTables(ctx, backupA, tablePattern, remote)
+	if err != nil {
+		return err
+	}
+	tablesB, err := b.getBackupTables(ctx, backupB, tablePattern, remote)
+	if err != nil {
+		return err
+	}
+	comparisons := compareBackupTables(tablesA, tablesB)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "table", "change", "parts added", "parts removed", backupA, backupB, "delta", "estimated unique bytes"); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	var sizeA, sizeB, uniqueBytes int64
+	for _, c := range comparisons {
+		sizeA += c.SizeA
+		sizeB += c.SizeB
+		uniqueBytes += c.EstimatedUniqueBytes
+		if c.Change == "" {
+			continue
+		}
+		if bytes, err := fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", c.Table, c.Change, c.PartsAdded, c.PartsRemoved, utils.FormatBytes(uint64(c.SizeA)), utils.FormatBytes(uint64(c.SizeB)), formatBytesDelta(c.SizeB-c.SizeA), utils.FormatBytes(uint64(c.EstimatedUniqueBytes))); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+	}
+	if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "total", "", "", "", utils.FormatBytes(uint64(sizeA)), utils.FormatBytes(uint64(sizeB)), formatBytesDelta(sizeB-sizeA), utils.FormatBytes(uint64(uniqueBytes))); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	schemaHeaderPrinted := false
+	for _, c := range comparisons {
+		for _, diff := range c.SchemaDiffs {
+			if !schemaHeaderPrinted {
+				schemaHeaderPrinted = true
+				if bytes, err := fmt.Fprintf(w, "\n%s\t%s\t%s\t%s\t%s\n", "table", "object", "change", backupA, backupB); err != nil {
+					log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+				}
+			}
+			if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Table, diff.Object, diff.Change, diff.Backup, diff.Current); err != nil {
+				log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// getBackupTables - tables from local or remote backup metadata matched by tablePattern
+func (b *Backuper) getBackupTables(ctx context.Context, backupName, tablePattern string, remote bool) (ListOfTables, error) {
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	if remote {
+		backupMetadata, err := b.ReadBackupMetadataRemote(ctx, backupName)
+		if err != nil {
+			return nil, err
+		}
+		if len(backupMetadata.Tables) == 0 {
+			return ListOfTables{}, nil
+		}
+		return getTableListByPatternRemote(ctx, b, backupMetadata, tablePattern, false)
+	}
+	backupMetadata, err := b.ReadBackupMetadataLocal(ctx, backupName)
+	if err != nil {
+		return nil, err
+	}
+	if len(backupMetadata.Tables) == 0 {
+		return ListOfTables{}, nil
+	}
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	if strings.Contains(backupMetadata.Tags, "embedded") && b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
+		metadataPath = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata")
+	}
+	tables, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, nil)
+	return tables, err
+}
+
+// compareBackupTables - comparison for each table from both backups sorted by table name, Change is empty when table is the same,
+// parts are compared by name, cause data part is immutable and the same name means the same data
+func compareBackupTables(tablesA, tablesB ListOfTables) []tableComparison {
+	byTitleA := make(map[metadata.TableTitle]metadata.TableMetadata, len(tablesA))
+	for _, t := range tablesA {
+		byTitleA[metadata.TableTitle{Database: t.Database, Table: t.Table}] = t
+	}
+	byTitleB := make(map[metadata.TableTitle]metadata.TableMetadata, len(tablesB))
+	for _, t := range tablesB {
+		byTitleB[metadata.TableTitle{Database: t.Database, Table: t.Table}] = t
+	}
+	comparisons := make([]tableComparison, 0)
+	for _, tableA := range tablesA {
+		title := metadata.TableTitle{Database: tableA.Database, Table: tableA.Table}
+		c := tableComparison{Table: fmt.Sprintf("%s.%s", tableA.Database, tableA.Table), SizeA: tableSize(tableA)}
+		partsA := tablePartNames(tableA)
+		tableB, existsInB := byTitleB[title]
+		if !existsInB {
+			c.Change = "removed"
+			c.PartsRemoved = len(partsA)
+			comparisons = append(comparisons, c)
+			continue
+		}
+		c.SizeB = tableSize(tableB)
+		partsB := tablePartNames(tableB)
+		for name := range partsB {
+			if !partsA[name] {
+				c.PartsAdded++
+			}
+		}
+		for name := range partsA {
+			if !partsB[name] {
+				c.PartsRemoved++
+			}
+		}
+		if len(partsB) > 0 {
+			c.EstimatedUniqueBytes = c.SizeB * int64(c.PartsAdded) / int64(len(partsB))
+		}
+		changes := make([]string, 0, 2)
+		if tableA.Query != "" && tableB.Query != "" {
+			c.SchemaDiffs = diffTableSchema(tableA.Query, tableB.Query)
+		}
+		if len(c.SchemaDiffs) > 0 {
+			changes = append(changes, "schema")
+		}
+		if c.PartsAdded > 0 || c.PartsRemoved > 0 || c.SizeA != c.SizeB {
+			changes = append(changes, "data")
+		}
+		c.Change = strings.Join(changes, ",")
+		comparisons = append(comparisons, c)
+	}
+	for _, tableB := range tablesB {
+		if _, existsInA := byTitleA[metadata.TableTitle{Database: tableB.Database, Table: tableB.Table}]; existsInA {
+			continue
+		}
+		size := tableSize(tableB)
+		comparisons = append(comparisons, tableComparison{Table: fmt.Sprintf("%s.%s", tableB.Database, tableB.Table), Change: "added", PartsAdded: len(tablePartNames(tableB)), SizeB: size, EstimatedUniqueBytes: size})
+	}
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Table < comparisons[j].Table
+	})
+	return comparisons
+}
+
+func tableSize(table metadata.TableMetadata) int64 {
+	size := int64(0)
+	for _, diskSize := range table.Size {
+		size += diskSize
+	}
+	return size
+}
+
+func tablePartNames(table metadata.TableMetadata) map[string]bool {
+	names := make(map[string]bool)
+	for _, parts := range table.Parts {
+		for _, part := range parts {
+			names[part.Name] = true
+		}
+	}
+	return names
+}
+
+func formatBytesDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + utils.FormatBytes(uint64(-delta))
+	}
+	return "+" + utils.FormatBytes(uint64(delta))
+}
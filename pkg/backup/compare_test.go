@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareBackupTables(t *testing.T) {
+	query := "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id"
+	tablesA := ListOfTables{
+		{Database: "db", Table: "t1", Query: query, Size: map[string]int64{"default": 300}, Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}, {Name: "all_2_2_0"}, {Name: "all_3_3_0"}}}},
+		{Database: "db", Table: "dropped", Query: "CREATE TABLE db.dropped (id UInt64) ENGINE = Memory", Size: map[string]int64{}},
+		{Database: "db", Table: "same", Query: query, Size: map[string]int64{"default": 100}, Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}},
+	}
+	tablesB := ListOfTables{
+		// all_1_1_0 and all_2_2_0 merged into all_1_2_1 on other disk, all_4_4_0 inserted
+		{Database: "db", Table: "t1", Query: "CREATE TABLE db.t1 (id UInt64, name String) ENGINE = MergeTree ORDER BY id", Size: map[string]int64{"default": 200, "s3": 200}, Parts: map[string][]metadata.Part{"default": {{Name: "all_3_3_0"}, {Name: "all_4_4_0"}}, "s3": {{Name: "all_1_2_1"}, {Name: "all_5_5_0"}}}},
+		{Database: "db", Table: "same", Query: query, Size: map[string]int64{"default": 100}, Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}},
+		{Database: "db", Table: "created", Query: query, Size: map[string]int64{"default": 50}, Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}},
+	}
+	comparisons := compareBackupTables(tablesA, tablesB)
+	assert.Len(t, comparisons, 4)
+
+	assert.Equal(t, tableComparison{Table: "db.created", Change: "added", PartsAdded: 1, SizeB: 50, EstimatedUniqueBytes: 50}, comparisons[0])
+	assert.Equal(t, tableComparison{Table: "db.dropped", Change: "removed"}, comparisons[1])
+	assert.Equal(t, tableComparison{Table: "db.same", SizeA: 100, SizeB: 100}, comparisons[2])
+
+	t1 := comparisons[3]
+	assert.Equal(t, "db.t1", t1.Table)
+	assert.Equal(t, "schema,data", t1.Change)
+	assert.Equal(t, 3, t1.PartsAdded)
+	assert.Equal(t, 2, t1.PartsRemoved)
+	assert.Equal(t, int64(300), t1.SizeA)
+	assert.Equal(t, int64(400), t1.SizeB)
+	assert.Equal(t, int64(300), t1.EstimatedUniqueBytes, "3 of 4 parts are absent in backup A")
+	assert.Equal(t, []schemaDiff{{Object: "column name", Change: "added", Current: "String"}}, t1.SchemaDiffs)
+}
+
+func TestFormatBytesDelta(t *testing.T) {
+	assert.Equal(t, "+1.00KiB", formatBytesDelta(1024))
+	assert.Equal(t, "-1.00KiB", formatBytesDelta(-1024))
+	assert.Equal(t, "+0B", formatBytesDelta(0))
+}
@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionDictionaryFile = "compression.dict"
+	// compressionDictionarySampleSize - max bytes which will read from each file for dictionary training
+	compressionDictionarySampleSize = 128 * 1024
+	// compressionDictionaryMaxSamplesSize - max bytes for all samples, prevent high memory usage with thousands of tables
+	compressionDictionaryMaxSamplesSize = 8 * 1024 * 1024
+	// compressionDictionaryMinSamples, compressionDictionaryMinSamplesSize - less data doesn't give profit from shared dictionary
+	compressionDictionaryMinSamples     = 8
+	compressionDictionaryMinSamplesSize = 64 * 1024
+	// compressionDictionaryMinSequences - zstd.BuildDict requires at least 512 sequences in samples, estimated count shall have margin
+	compressionDictionaryMinSequences = 1024
+	// compressionDictionaryHistorySize - the same as default `zstd --train --maxdict`
+	compressionDictionaryHistorySize = 110 * 1024
+)
+
+// isCompressionDictionaryTable - table archives will compress with shared backup dictionary, tables from general->table_compression_format are not compressed
+func (b *Backuper) isCompressionDictionaryTable(table metadata.TableMetadata) bool {
+	return b.cfg.General.CompressionDictionaryMaxTableSize > 0 && !table.MetadataOnly && table.TotalBytes <= b.cfg.General.CompressionDictionaryMaxTableSize && b.getTableDataFormat(table) == ""
+}
+
+// compressionDictionaryID - zstd dictionary ID shall be unique inside incremental backups chain, IDs less than 32768 are reserved
+func compressionDictionaryID(backupName string) uint32 {
+	return crc32.ChecksumIEEE([]byte(backupName)) | 1<<15
+}
+
+// trainCompressionDictionary - build one zstd dictionary from data parts of small tables, return nil when not enough samples
+func (b *Backuper) trainCompressionDictionary(backupName string, tables ListOfTables) ([]byte, error) {
+	log := b.log.WithField("logger", "trainCompressionDictionary")
+	samples := make([][]byte, 0)
+	samplesSize := 0
+	for _, table := range tables {
+		if !b.isCompressionDictionaryTable(table) {
+			continue
+		}
+		dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+		for disk, parts := range table.Parts {
+			backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+			for _, part := range parts {
+				if part.Required {
+					continue
+				}
+				walkErr := filepath.Walk(path.Join(backupPath, part.Name), func(filePath string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if !info.Mode().IsRegular() || info.Size() == 0 {
+						return nil
+					}
+					if samplesSize >= compressionDictionaryMaxSamplesSize {
+						return filepath.SkipAll
+					}
+					f, err := os.Open(filePath)
+					if err != nil {
+						return err
+					}
+					sample, err := io.ReadAll(io.LimitReader(f, compressionDictionarySampleSize))
+					if closeErr := f.Close(); closeErr != nil {
+						log.Warnf("can't close %s: %v", filePath, closeErr)
+					}
+					if err != nil {
+						return err
+					}
+					samples = append(samples, sample)
+					samplesSize += len(sample)
+					return nil
+				})
+				if walkErr != nil {
+					return nil, fmt.Errorf("can't collect dictionary samples from %s: %v", path.Join(backupPath, part.Name), walkErr)
+				}
+			}
+		}
+	}
+	if len(samples) < compressionDictionaryMinSamples || samplesSize < compressionDictionaryMinSamplesSize {
+		log.Debugf("not enough samples for %s, len(samples)=%d, samplesSize=%d, dictionary will not use", backupName, len(samples), samplesSize)
+		return nil, nil
+	}
+	history := make([]byte, 0, compressionDictionaryHistorySize)
+	for i := len(samples) - 1; i >= 0 && len(history) < compressionDictionaryHistorySize; i-- {
+		sample := samples[i]
+		if len(sample) > compressionDictionaryHistorySize-len(history) {
+			sample = sample[:compressionDictionaryHistorySize-len(history)]
+		}
+		history = append(history, sample...)
+	}
+	if len(history) < 8 {
+		return nil, nil
+	}
+	// zstd.BuildDict panics with division by zero when samples contain less than 512 sequences, for example already compressed or highly repetitive files
+	if sequences := estimateSamplesSequences(samples, history, compressionDictionaryMinSequences); sequences < compressionDictionaryMinSequences {
+		log.Debugf("samples for %s contain only %d repeated sequences, dictionary will not use", backupName, sequences)
+		return nil, nil
+	}
+	dictionary, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       compressionDictionaryID(backupName),
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("trained %d bytes dictionary from %d samples with %d bytes", len(dictionary), len(samples), samplesSize)
+	return dictionary, nil
+}
+
+// estimateSamplesSequences - count matches which zstd encoder could find inside each sample and in history, which BuildDict uses as dictionary,
+// matches are at least 8 bytes and extended as long as possible, so estimation is less than real sequences count, counting stops after limit
+func estimateSamplesSequences(samples [][]byte, history []byte, limit int) int {
+	const minMatch = 8
+	historyPositions := make(map[uint64]int, len(history))
+	for i := 0; i+minMatch <= len(history); i++ {
+		historyPositions[binary.LittleEndian.Uint64(history[i:])] = i
+	}
+	sequences := 0
+	for _, sample := range samples {
+		positions := make(map[uint64]int)
+		for i := 0; i+minMatch <= len(sample) && sequences < limit; {
+			key := binary.LittleEndian.Uint64(sample[i:])
+			matchLen := 0
+			if prev, exists := positions[key]; exists {
+				for i+matchLen < len(sample) && sample[prev+matchLen] == sample[i+matchLen] {
+					matchLen++
+				}
+			} else if prev, exists := historyPositions[key]; exists {
+				for prev+matchLen < len(history) && i+matchLen < len(sample) && history[prev+matchLen] == sample[i+matchLen] {
+					matchLen++
+				}
+			}
+			positions[key] = i
+			if matchLen < minMatch {
+				i++
+				continue
+			}
+			sequences++
+			i += matchLen
+		}
+	}
+	return sequences
+}
+
+// uploadCompressionDictionary - train and upload shared dictionary, return file name relative to backup which shall save in metadata.json
+func (b *Backuper) uploadCompressionDictionary(ctx context.Context, backupName string, tables ListOfTables) (string, error) {
+	remoteDictionaryFile := path.Join(backupName, compressionDictionaryFile)
+	if b.resume && b.resumableState.IsAlreadyProcessedBool(remoteDictionaryFile) {
+		dictionary, err := b.downloadCompressionDictionary(ctx, remoteDictionaryFile)
+		if err != nil {
+			return "", err
+		}
+		b.compressionDictionary = dictionary
+		return compressionDictionaryFile, nil
+	}
+	dictionary, err := b.trainCompressionDictionary(backupName, tables)
+	if err != nil || dictionary == nil {
+		return "", err
+	}
+	retry := b.newRetrier()
+	err = retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutFile(ctx, remoteDictionaryFile, io.NopCloser(bytes.NewReader(dictionary)))
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't upload %s: %v", remoteDictionaryFile, err)
+	}
+	if b.resume {
+		b.resumableState.AppendToState(remoteDictionaryFile, int64(len(dictionary)))
+	}
+	b.compressionDictionary = dictionary
+	return compressionDictionaryFile, nil
+}
+
+// loadCompressionDictionaries - download dictionaries for backup and all required backups, diff parts could be compressed with required backup dictionary
+func (b *Backuper) loadCompressionDictionaries(ctx context.Context, backup metadata.BackupMetadata) error {
+	b.compressionDictionaries = nil
+	if backup.DataFormat != "zstd" {
+		return nil
+	}
+	current := &backup
+	for current != nil {
+		if current.CompressionDictionary != "" {
+			dictionary, err := b.downloadCompressionDictionary(ctx, path.Join(current.BackupName, current.CompressionDictionary))
+			if err != nil {
+				return err
+			}
+			if err = b.verifyRemoteFile(ctx, current.BackupName, path.Join(current.BackupName, current.CompressionDictionary), dictionary); err != nil {
+				return err
+			}
+			b.compressionDictionaries = append(b.compressionDictionaries, dictionary)
+		}
+		if current.RequiredBackup == "" {
+			break
+		}
+		var err error
+		if current, err = b.ReadBackupMetadataRemote(ctx, current.RequiredBackup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) downloadCompressionDictionary(ctx context.Context, remoteDictionaryFile string) ([]byte, error) {
+	var dictionary []byte
+	retry := b.newRetrier()
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		reader, err := b.dst.GetFileReader(ctx, remoteDictionaryFile)
+		if err != nil {
+			return err
+		}
+		if dictionary, err = io.ReadAll(reader); err != nil {
+			return err
+		}
+		return reader.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't download %s: %v", remoteDictionaryFile, err)
+	}
+	return dictionary, nil
+}
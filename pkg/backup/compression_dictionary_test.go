@@ -0,0 +1,27 @@
+package backup
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateSamplesSequences(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 64*1024)
+	r.Read(random)
+	history := make([]byte, 8*1024)
+	r.Read(history)
+	assert.Zero(t, estimateSamplesSequences([][]byte{random}, history, 1024), "compressed data doesn't contain repeated sequences")
+
+	repetitive := []byte(strings.Repeat("a", 64*1024))
+	assert.Equal(t, 1, estimateSamplesSequences([][]byte{repetitive}, nil, 1024), "one long match")
+
+	columns := make([]byte, 0)
+	for i := 0; i < 4096; i++ {
+		columns = append(columns, []byte("`column_"+strings.Repeat("x", r.Intn(8))+"` UInt64\n")...)
+	}
+	assert.Equal(t, 100, estimateSamplesSequences([][]byte{columns}, nil, 100), "counting stops after limit")
+}
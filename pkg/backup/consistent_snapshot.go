@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+// WithConsistentSnapshot - `create --consistent-snapshot`, all tables in backup contain data inserted before the same point in time
+func WithConsistentSnapshot(consistentSnapshot bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.consistentSnapshot = consistentSnapshot
+	}
+}
+
+// snapshotBarrier - max block number of each partition for each table, when merges are stopped block numbers of new parts always greater than barrier
+type snapshotBarrier struct {
+	mu        sync.Mutex
+	stopped   map[metadata.TableTitle]bool
+	maxBlocks map[metadata.TableTitle]map[string]int64
+}
+
+// stopMergesForSnapshot - SYSTEM STOP MERGES for all MergeTree tables first, then read barrier for all tables, so barriers are close to each other
+func (b *Backuper) stopMergesForSnapshot(ctx context.Context, tables []clickhouse.Table, log *apexLog.Entry) error {
+	b.snapshotBarrier = &snapshotBarrier{
+		stopped:   map[metadata.TableTitle]bool{},
+		maxBlocks: map[metadata.TableTitle]map[string]int64{},
+	}
+	var snapshotTables []clickhouse.Table
+	for _, table := range tables {
+		if table.Skip || table.BackupType != clickhouse.ShardBackupFull || !strings.HasSuffix(table.Engine, "MergeTree") {
+			continue
+		}
+		if err := b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM STOP MERGES `%s`.`%s`", table.Database, table.Name)); err != nil {
+			return fmt.Errorf("can't stop merges for `%s`.`%s`: %v", table.Database, table.Name, err)
+		}
+		b.snapshotBarrier.stopped[metadata.TableTitle{Database: table.Database, Table: table.Name}] = true
+		snapshotTables = append(snapshotTables, table)
+	}
+	for _, table := range snapshotTables {
+		maxBlocks, err := b.ch.GetPartitionsMaxBlock(ctx, table.Database, table.Name)
+		if err != nil {
+			return err
+		}
+		b.snapshotBarrier.maxBlocks[metadata.TableTitle{Database: table.Database, Table: table.Name}] = maxBlocks
+	}
+	log.Infof("merges stopped for %d tables until freeze", len(snapshotTables))
+	return nil
+}
+
+// startMergesAfterFreeze - SYSTEM START MERGES for one table, merges are not required after parts are hardlinked into shadow
+func (b *Backuper) startMergesAfterFreeze(table metadata.TableTitle, log *apexLog.Entry) {
+	b.snapshotBarrier.mu.Lock()
+	isStopped := b.snapshotBarrier.stopped[table]
+	delete(b.snapshotBarrier.stopped, table)
+	b.snapshotBarrier.mu.Unlock()
+	if !isStopped {
+		return
+	}
+	// context of create could be canceled, merges shall be started anyway
+	if err := b.ch.QueryContext(context.Background(), fmt.Sprintf("SYSTEM START MERGES `%s`.`%s`", table.Database, table.Table)); err != nil {
+		log.Errorf("can't start merges for `%s`.`%s`, execute SYSTEM START MERGES manually: %v", table.Database, table.Table, err)
+	}
+}
+
+// startAllMerges - deferred after freeze phase, start merges for tables which were not frozen due to error
+func (b *Backuper) startAllMerges(log *apexLog.Entry) {
+	if b.snapshotBarrier == nil {
+		return
+	}
+	b.snapshotBarrier.mu.Lock()
+	tables := make([]metadata.TableTitle, 0, len(b.snapshotBarrier.stopped))
+	for table := range b.snapshotBarrier.stopped {
+		tables = append(tables, table)
+	}
+	b.snapshotBarrier.mu.Unlock()
+	for _, table := range tables {
+		b.startMergesAfterFreeze(table, log)
+	}
+}
+
+// applySnapshotBarrier - run right after FREEZE, remove parts inserted after barrier from shadow and resume merges of table
+func (b *Backuper) applySnapshotBarrier(table *clickhouse.Table, diskList []clickhouse.Disk, shadowBackupUUID string, log *apexLog.Entry) error {
+	tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Name}
+	defer b.startMergesAfterFreeze(tableTitle, log)
+	maxBlocks, exists := b.snapshotBarrier.maxBlocks[tableTitle]
+	if !exists {
+		return nil
+	}
+	for _, disk := range diskList {
+		shadowPath := filepath.Join(disk.Path, "shadow", shadowBackupUUID)
+		if _, err := os.Stat(shadowPath); err != nil && os.IsNotExist(err) {
+			continue
+		}
+		removedParts, err := removeShadowPartsAfterBarrier(shadowPath, maxBlocks)
+		if err != nil {
+			return err
+		}
+		if len(removedParts) > 0 {
+			log.WithField("disk", disk.Name).Debugf("parts %s inserted after snapshot barrier, skipped", strings.Join(removedParts, ", "))
+		}
+	}
+	return nil
+}
+
+// isPartAfterSnapshotBarrier - part name is <partition_id>_<min_block>_<max_block>_<level>[_<mutation>], parts of new partitions are inserted after barrier too
+func isPartAfterSnapshotBarrier(partName string, maxBlocks map[string]int64) bool {
+	nameParts := strings.Split(partName, "_")
+	if len(nameParts) < 4 {
+		return false
+	}
+	maxBlock, err := strconv.ParseInt(nameParts[2], 10, 64)
+	if err != nil {
+		return false
+	}
+	barrierBlock, partitionExists := maxBlocks[nameParts[0]]
+	return !partitionExists || maxBlock > barrierBlock
+}
+
+// removeShadowPartsAfterBarrier - shadow contains only hardlinks created by FREEZE, part directories are on the 4th level like in filesystemhelper.MoveShadowToBackup
+func removeShadowPartsAfterBarrier(shadowPath string, maxBlocks map[string]int64) ([]string, error) {
+	var removedParts []string
+	err := filepath.WalkDir(shadowPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath := strings.Trim(strings.TrimPrefix(filePath, shadowPath), "/")
+		if !d.IsDir() || len(strings.Split(relativePath, "/")) != 4 {
+			return nil
+		}
+		if !isPartAfterSnapshotBarrier(d.Name(), maxBlocks) {
+			return filepath.SkipDir
+		}
+		if removeErr := os.RemoveAll(filePath); removeErr != nil {
+			return removeErr
+		}
+		removedParts = append(removedParts, d.Name())
+		return filepath.SkipDir
+	})
+	return removedParts, err
+}
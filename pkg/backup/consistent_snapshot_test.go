@@ -0,0 +1,32 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveShadowPartsAfterBarrier(t *testing.T) {
+	maxBlocks := map[string]int64{"202401": 10, "all": 5}
+	assert.False(t, isPartAfterSnapshotBarrier("202401_1_10_2", maxBlocks))
+	assert.False(t, isPartAfterSnapshotBarrier("202401_3_3_0_12", maxBlocks))
+	assert.True(t, isPartAfterSnapshotBarrier("202401_11_11_0", maxBlocks))
+	assert.True(t, isPartAfterSnapshotBarrier("202402_1_1_0", maxBlocks))
+	assert.False(t, isPartAfterSnapshotBarrier("all_5_5_0", maxBlocks))
+	assert.False(t, isPartAfterSnapshotBarrier("frozen_metadata.txt", maxBlocks))
+
+	shadowPath := t.TempDir()
+	tablePath := path.Join(shadowPath, "store", "c7f", "c7f1d3a0-2a4b-4a5e-9a0e-3f1d1c1b1a10")
+	for _, part := range []string{"202401_1_10_2", "202401_11_11_0", "202402_1_1_0"} {
+		require.NoError(t, os.MkdirAll(path.Join(tablePath, part), 0750))
+		require.NoError(t, os.WriteFile(path.Join(tablePath, part, "checksums.txt"), []byte("checksums"), 0640))
+	}
+	removedParts, err := removeShadowPartsAfterBarrier(shadowPath, maxBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"202401_11_11_0", "202402_1_1_0"}, removedParts)
+	assert.DirExists(t, path.Join(tablePath, "202401_1_10_2"))
+	assert.NoDirExists(t, path.Join(tablePath, "202402_1_1_0"))
+}
@@ -0,0 +1,415 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// consolidateCopy - remote file or directory which shall be copied into consolidated backup
+type consolidateCopy struct {
+	srcKey string
+	dstKey string
+	// isDirectory - part uploaded with compression_format: none, each file inside is copied
+	isDirectory bool
+}
+
+// findConsolidatePartOwner - index in chain of the newest backup which stores part data without `required` flag and disk of part in this backup,
+// return -1 when part is not found in some backup of chain
+func findConsolidatePartOwner(chain []string, table metadata.TableTitle, partName string, loadTable func(backupName string, table metadata.TableTitle) (*metadata.TableMetadata, error)) (int, string, error) {
+	for i, backupName := range chain {
+		tm, err := loadTable(backupName, table)
+		if err != nil {
+			return -1, "", fmt.Errorf("can't read %s.%s from %s: %v", table.Database, table.Table, backupName, err)
+		}
+		found := false
+		for disk, parts := range tm.Parts {
+			for _, part := range parts {
+				if part.Name != partName {
+					continue
+				}
+				if !part.Required {
+					return i, disk, nil
+				}
+				found = true
+			}
+		}
+		if !found {
+			return -1, "", nil
+		}
+	}
+	return -1, "", nil
+}
+
+// findConsolidatePartArchives - per part archives of table files on disk, look splitPartArchives
+// archives uploaded with general->upload_by_part: false contain several parts and are never returned, copy them would mix data of other parts into consolidated backup
+func findConsolidatePartArchives(files []string, disk, partName string) []string {
+	var archives []string
+	partArchivePrefix := fmt.Sprintf("%s_%s.", disk, common.TablePathEncode(partName))
+	chunkPrefix := partArchiveChunkPrefix(disk, partName)
+	for _, file := range files {
+		if strings.HasPrefix(file, partArchivePrefix) || strings.HasPrefix(file, chunkPrefix) {
+			archives = append(archives, file)
+		}
+	}
+	return archives
+}
+
+// planConsolidateTable - chain is consolidated backup and all its required backups, each part is copied from the newest backup which stores part data,
+// return table metadata for newBackupName without `required` parts and remote files to copy
+func planConsolidateTable(newBackupName string, chain []string, table metadata.TableTitle, directoryFormat bool, loadTable func(backupName string, table metadata.TableTitle) (*metadata.TableMetadata, error)) (metadata.TableMetadata, []consolidateCopy, error) {
+	head, err := loadTable(chain[0], table)
+	if err != nil {
+		return metadata.TableMetadata{}, nil, fmt.Errorf("can't read %s.%s from %s: %v", table.Database, table.Table, chain[0], err)
+	}
+	consolidated := *head
+	consolidated.Parts = make(map[string][]metadata.Part, len(head.Parts))
+	consolidated.Files = nil
+	consolidated.Checksums = nil
+	consolidated.ParityGroups = nil
+	consolidated.RebalancedFiles = nil
+	consolidated.LocalFile = ""
+	// general->table_compression_format: none uploads table as directory inside archived backup
+	directoryFormat = directoryFormat || head.DataFormat == DirectoryFormat
+	var copies []consolidateCopy
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	dstTablePath := path.Join(newBackupName, "shadow", dbAndTablePath)
+	// the same archive could be required on several disks, when parts moved between disks after required backup upload
+	copiedArchives := map[string]bool{}
+	usedArchives := map[string]bool{}
+	disks := make([]string, 0, len(head.Parts))
+	for disk := range head.Parts {
+		disks = append(disks, disk)
+	}
+	sort.Strings(disks)
+	for _, disk := range disks {
+		consolidated.Parts[disk] = make([]metadata.Part, 0, len(head.Parts[disk]))
+		for _, part := range head.Parts[disk] {
+			if part.BasePart != "" || len(part.RequiredFiles) > 0 {
+				return consolidated, nil, fmt.Errorf("%s.%s part %s uploaded with general->upload_diff_files is not supported", table.Database, table.Table, part.Name)
+			}
+			if len(part.DuplicatedFiles) > 0 {
+				return consolidated, nil, fmt.Errorf("%s.%s part %s uploaded with general->upload_dedup_files is not supported", table.Database, table.Table, part.Name)
+			}
+			ownerIdx, ownerDisk, err := findConsolidatePartOwner(chain, table, part.Name, loadTable)
+			if err != nil {
+				return consolidated, nil, err
+			}
+			if ownerIdx < 0 {
+				return consolidated, nil, fmt.Errorf("%s.%s part %s not found in required backups sequence %s", table.Database, table.Table, part.Name, strings.Join(chain, " -> "))
+			}
+			owner, err := loadTable(chain[ownerIdx], table)
+			if err != nil {
+				return consolidated, nil, err
+			}
+			if owner.DataFormat != head.DataFormat {
+				return consolidated, nil, fmt.Errorf("%s.%s data_format is different in %s and %s, general->table_compression_format changed between backups", table.Database, table.Table, chain[ownerIdx], chain[0])
+			}
+			srcTablePath := path.Join(chain[ownerIdx], "shadow", dbAndTablePath)
+			if directoryFormat {
+				copies = append(copies, consolidateCopy{
+					srcKey:      path.Join(srcTablePath, ownerDisk, part.Name),
+					dstKey:      path.Join(dstTablePath, disk, part.Name),
+					isDirectory: true,
+				})
+			} else {
+				archives := findConsolidatePartArchives(owner.Files[ownerDisk], ownerDisk, part.Name)
+				if len(archives) == 0 {
+					return consolidated, nil, fmt.Errorf("%s.%s part %s archive not found in %s, backups uploaded with general->upload_by_part: false are not supported", table.Database, table.Table, part.Name, chain[ownerIdx])
+				}
+				for _, archive := range archives {
+					srcKey := path.Join(srcTablePath, archive)
+					if copiedArchives[path.Join(disk, srcKey)] {
+						continue
+					}
+					dstArchive := disk + "_" + strings.TrimPrefix(archive, ownerDisk+"_")
+					if usedArchives[dstArchive] {
+						dstArchive = fmt.Sprintf("%s_%s_%s", disk, common.TablePathEncode(chain[ownerIdx]), strings.TrimPrefix(archive, ownerDisk+"_"))
+					}
+					copiedArchives[path.Join(disk, srcKey)] = true
+					usedArchives[dstArchive] = true
+					if consolidated.Files == nil {
+						consolidated.Files = map[string][]string{}
+					}
+					consolidated.Files[disk] = append(consolidated.Files[disk], dstArchive)
+					copies = append(copies, consolidateCopy{srcKey: srcKey, dstKey: path.Join(dstTablePath, dstArchive)})
+				}
+			}
+			for file, checksum := range owner.Checksums[ownerDisk] {
+				if strings.HasPrefix(file, part.Name+"/") {
+					if consolidated.Checksums == nil {
+						consolidated.Checksums = map[string]map[string]string{}
+					}
+					if consolidated.Checksums[disk] == nil {
+						consolidated.Checksums[disk] = map[string]string{}
+					}
+					consolidated.Checksums[disk][file] = checksum
+				}
+			}
+			part.Required = false
+			consolidated.Parts[disk] = append(consolidated.Parts[disk], part)
+		}
+	}
+	return consolidated, copies, nil
+}
+
+func (b *Backuper) copyRemoteFile(ctx context.Context, srcSize int64, srcKey, dstKey string) error {
+	retry := b.newRetrier()
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.CopyFile(ctx, srcSize, srcKey, dstKey)
+	})
+	if err != nil {
+		return fmt.Errorf("can't copy %s to %s: %v", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// copyConsolidateFiles - return copied bytes
+func (b *Backuper) copyConsolidateFiles(ctx context.Context, copies []consolidateCopy) (int64, error) {
+	var copiedBytes int64
+	copyFile := func(ctx context.Context, srcSize int64, srcKey, dstKey string) error {
+		if err := b.copyRemoteFile(ctx, srcSize, srcKey, dstKey); err != nil {
+			return err
+		}
+		atomic.AddInt64(&copiedBytes, srcSize)
+		return nil
+	}
+	copyGroup, copyCtx := errgroup.WithContext(ctx)
+	copyGroup.SetLimit(int(b.cfg.General.UploadConcurrency))
+	for _, c := range copies {
+		copyGroup.Go(func() error {
+			if c.isDirectory {
+				return b.dst.Walk(copyCtx, c.srcKey+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+					if b.dst.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+						return nil
+					}
+					return copyFile(ctx, f.Size(), path.Join(c.srcKey, f.Name()), path.Join(c.dstKey, f.Name()))
+				})
+			}
+			srcFile, err := b.dst.StatFile(copyCtx, c.srcKey)
+			if err != nil {
+				return fmt.Errorf("can't stat %s: %v", c.srcKey, err)
+			}
+			return copyFile(copyCtx, srcFile.Size(), c.srcKey, c.dstKey)
+		})
+	}
+	if err := copyGroup.Wait(); err != nil {
+		return copiedBytes, err
+	}
+	return copiedBytes, nil
+}
+
+// Consolidate - `consolidate` command, copy data parts of incremental backup and all its required backups into new full backup on remote storage,
+// files are copied between remote keys, server side when remote storage supports it, so old required backups could be deleted without upload data from clickhouse
+func (b *Backuper) Consolidate(backupName, newBackupName string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	startConsolidate := time.Now()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select backup for consolidate")
+	}
+	newBackupName = utils.CleanBackupNameRE.ReplaceAllString(newBackupName, "")
+	if newBackupName == "" {
+		newBackupName = backupName + "_consolidated"
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "consolidate",
+	})
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("consolidate is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	release, err := b.lockOperation("consolidate", newBackupName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if b.dst, err = b.newBackupDestination(ctx, false, newBackupName); err != nil {
+		return err
+	}
+	if err = b.dst.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupList, err := b.dst.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	backupsByName := make(map[string]storage.Backup, len(backupList))
+	for _, backup := range backupList {
+		backupsByName[backup.BackupName] = backup
+	}
+	head, exists := backupsByName[backupName]
+	if !exists {
+		return fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	if _, exists = backupsByName[newBackupName]; exists {
+		return fmt.Errorf("'%s' already exists on remote storage", newBackupName)
+	}
+	if head.RequiredBackup == "" {
+		return fmt.Errorf("'%s' doesn't require other backups, nothing to consolidate", backupName)
+	}
+	// diff parts could be compressed with dictionary of any backup in chain, consolidated backup can store only one dictionary
+	dictionaryBackup := ""
+	chain := append([]string{backupName}, getRequiredBackupsChain(backupList, head.RequiredBackup)...)
+	for _, name := range chain {
+		backup, exists := backupsByName[name]
+		if !exists {
+			return fmt.Errorf("required backup %s is not found on remote storage", name)
+		}
+		if backup.Broken != "" {
+			return fmt.Errorf("required backup %s is broken: %s", name, backup.Broken)
+		}
+		if strings.Contains(backup.Tags, "embedded") || b.hasObjectDisksRemote(backup) {
+			return fmt.Errorf("%s is embedded or object disk backup, consolidate is not supported", name)
+		}
+		if backup.DataFormat != head.DataFormat {
+			return fmt.Errorf("%s data_format=%s is different from %s data_format=%s", name, backup.DataFormat, backupName, head.DataFormat)
+		}
+		if backup.CompressionDictionary != "" {
+			if dictionaryBackup != "" {
+				return fmt.Errorf("%s and %s contain compression dictionaries, consolidate is not supported", dictionaryBackup, name)
+			}
+			dictionaryBackup = name
+		}
+	}
+	log.Infof("consolidate required backups sequence %s into %s", strings.Join(chain, " -> "), newBackupName)
+	if err = b.initBackupSigner(); err != nil {
+		return err
+	}
+	newMetadata := head.BackupMetadata
+	newMetadata.BackupName = newBackupName
+	newMetadata.RequiredBackup = ""
+	newMetadata.RequiredBackupsChain = nil
+	newMetadata.OriginalUploadDate = nil
+	newMetadata.CompressionDictionary = ""
+	if dictionaryBackup != "" {
+		remoteDictionaryFile := path.Join(dictionaryBackup, backupsByName[dictionaryBackup].CompressionDictionary)
+		dictionary, err := b.downloadCompressionDictionary(ctx, remoteDictionaryFile)
+		if err != nil {
+			return err
+		}
+		if err = b.verifyRemoteFile(ctx, dictionaryBackup, remoteDictionaryFile, dictionary); err != nil {
+			return err
+		}
+		newDictionaryFile := path.Join(newBackupName, compressionDictionaryFile)
+		if err = b.putRemoteFile(ctx, newDictionaryFile, dictionary); err != nil {
+			return err
+		}
+		b.addSignedFile(newBackupName, newDictionaryFile, dictionary)
+		newMetadata.CompressionDictionary = compressionDictionaryFile
+	}
+
+	var copiedBytes, metadataSize int64
+	for _, table := range head.Tables {
+		start := time.Now()
+		tablesCache := map[string]*metadata.TableMetadata{}
+		loadTable := func(backupName string, table metadata.TableTitle) (*metadata.TableMetadata, error) {
+			if tm, isCached := tablesCache[backupName]; isCached {
+				return tm, nil
+			}
+			tm, err := b.readTableMetadataRemote(ctx, backupName, table)
+			if err != nil {
+				return nil, err
+			}
+			tablesCache[backupName] = tm
+			return tm, nil
+		}
+		consolidated, copies, err := planConsolidateTable(newBackupName, chain, table, head.DataFormat == DirectoryFormat, loadTable)
+		if err != nil {
+			return err
+		}
+		tableCopiedBytes, err := b.copyConsolidateFiles(ctx, copies)
+		if err != nil {
+			return err
+		}
+		if b.cfg.General.ParityShards > 0 && len(consolidated.Files) > 0 {
+			parityGroups, paritySize, parityErr := b.uploadTableParity(ctx, newBackupName, consolidated, nil)
+			if parityErr != nil {
+				return parityErr
+			}
+			tableCopiedBytes += paritySize
+			consolidated.ParityGroups = parityGroups
+		}
+		tableMetadataSize, err := b.uploadTableMetadataRegular(ctx, newBackupName, consolidated)
+		if err != nil {
+			return err
+		}
+		copiedBytes += tableCopiedBytes
+		metadataSize += tableMetadataSize
+		log.WithFields(apexLog.Fields{
+			"table":    fmt.Sprintf("%s.%s", table.Database, table.Table),
+			"files":    len(copies),
+			"duration": utils.HumanizeDuration(time.Since(start)),
+			"size":     utils.FormatBytes(uint64(tableCopiedBytes)),
+		}).Info("done")
+	}
+
+	// rbac, configs and keeper znodes are not incremental, copy them from consolidated backup
+	var rootCopies []consolidateCopy
+	rootSizes := map[string]uint64{"access": head.RBACSize, "configs": head.ConfigSize, "keeper": head.KeeperSize}
+	for name, size := range rootSizes {
+		if size == 0 {
+			continue
+		}
+		if head.DataFormat == DirectoryFormat {
+			rootCopies = append(rootCopies, consolidateCopy{srcKey: path.Join(backupName, name), dstKey: path.Join(newBackupName, name), isDirectory: true})
+		} else {
+			archiveName := fmt.Sprintf("%s.%s", name, config.ArchiveExtensions[head.DataFormat])
+			rootCopies = append(rootCopies, consolidateCopy{srcKey: path.Join(backupName, archiveName), dstKey: path.Join(newBackupName, archiveName)})
+		}
+	}
+	if _, err = b.copyConsolidateFiles(ctx, rootCopies); err != nil {
+		return err
+	}
+
+	newMetadata.CompressedSize = uint64(copiedBytes)
+	newMetadata.MetadataSize = uint64(metadataSize)
+	newMetadataBody, err := json.MarshalIndent(&newMetadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	remoteMetadataFile := path.Join(newBackupName, "metadata.json")
+	b.addSignedFile(newBackupName, remoteMetadataFile, newMetadataBody)
+	if err = b.putRemoteFile(ctx, remoteMetadataFile, newMetadataBody); err != nil {
+		return err
+	}
+	if _, err = b.uploadSignature(ctx, newBackupName); err != nil {
+		return err
+	}
+	if err = b.dst.AddToCatalog(ctx, storage.Backup{BackupMetadata: newMetadata, UploadDate: time.Now()}); err != nil {
+		log.Warnf("can't add %s to catalog: %v", newBackupName, err)
+	}
+	log.WithFields(apexLog.Fields{
+		"new_backup": newBackupName,
+		"duration":   utils.HumanizeDuration(time.Since(startConsolidate)),
+		"size":       utils.FormatBytes(uint64(copiedBytes)),
+	}).Info("done")
+	return nil
+}
@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanConsolidateTable(t *testing.T) {
+	title := metadata.TableTitle{Database: "db", Table: "t1"}
+	tables := map[string]*metadata.TableMetadata{
+		"full": {
+			Database: "db", Table: "t1",
+			Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}, {Name: "all_2_2_0"}}},
+			Files: map[string][]string{"default": {"default_all_1_1_0.tar", "default_all_1_1_0%2E2.tar", "default_all_2_2_0.tar"}},
+			Checksums: map[string]map[string]string{"default": {
+				"all_1_1_0/data.bin": "c1",
+				"all_2_2_0/data.bin": "c2",
+			}},
+		},
+		"increment1": {
+			Database: "db", Table: "t1",
+			Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0", Required: true}, {Name: "all_2_2_0", Required: true}, {Name: "all_3_3_0"}}},
+			Files: map[string][]string{"default": {"default_all_3_3_0.tar"}},
+		},
+		"increment2": {
+			Database: "db", Table: "t1",
+			Parts: map[string][]metadata.Part{
+				"default": {{Name: "all_1_1_0", Required: true}, {Name: "all_3_3_0", Required: true}, {Name: "all_4_4_0"}},
+				"hdd":     {{Name: "all_2_2_0", Required: true}},
+			},
+			Files:        map[string][]string{"default": {"default_all_4_4_0.tar", "default_all_4_4_0%2E2.tar"}},
+			ParityGroups: []metadata.ParityGroup{{Files: []string{"default_all_4_4_0.tar", "default_all_4_4_0%2E2.tar"}}},
+		},
+	}
+	loadTable := func(backupName string, table metadata.TableTitle) (*metadata.TableMetadata, error) {
+		if tm, exists := tables[backupName]; exists {
+			return tm, nil
+		}
+		return nil, fmt.Errorf("%s not found", backupName)
+	}
+	chain := []string{"increment2", "increment1", "full"}
+	consolidated, copies, err := planConsolidateTable("new", chain, title, false, loadTable)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]metadata.Part{
+		"default": {{Name: "all_1_1_0"}, {Name: "all_3_3_0"}, {Name: "all_4_4_0"}},
+		"hdd":     {{Name: "all_2_2_0"}},
+	}, consolidated.Parts)
+	// archive from other disk gets new disk prefix
+	assert.Equal(t, map[string][]string{
+		"default": {"default_all_1_1_0.tar", "default_all_1_1_0%2E2.tar", "default_all_3_3_0.tar", "default_all_4_4_0.tar", "default_all_4_4_0%2E2.tar"},
+		"hdd":     {"hdd_all_2_2_0.tar"},
+	}, consolidated.Files)
+	assert.Equal(t, []consolidateCopy{
+		{srcKey: "full/shadow/db/t1/default_all_1_1_0.tar", dstKey: "new/shadow/db/t1/default_all_1_1_0.tar"},
+		{srcKey: "full/shadow/db/t1/default_all_1_1_0%2E2.tar", dstKey: "new/shadow/db/t1/default_all_1_1_0%2E2.tar"},
+		{srcKey: "increment1/shadow/db/t1/default_all_3_3_0.tar", dstKey: "new/shadow/db/t1/default_all_3_3_0.tar"},
+		{srcKey: "increment2/shadow/db/t1/default_all_4_4_0.tar", dstKey: "new/shadow/db/t1/default_all_4_4_0.tar"},
+		{srcKey: "increment2/shadow/db/t1/default_all_4_4_0%2E2.tar", dstKey: "new/shadow/db/t1/default_all_4_4_0%2E2.tar"},
+		{srcKey: "full/shadow/db/t1/default_all_2_2_0.tar", dstKey: "new/shadow/db/t1/hdd_all_2_2_0.tar"},
+	}, copies)
+	assert.Equal(t, map[string]map[string]string{
+		"default": {"all_1_1_0/data.bin": "c1"},
+		"hdd":     {"all_2_2_0/data.bin": "c2"},
+	}, consolidated.Checksums)
+	assert.Nil(t, consolidated.ParityGroups)
+
+	_, copies, err = planConsolidateTable("new", chain, title, true, loadTable)
+	require.NoError(t, err)
+	assert.Equal(t, consolidateCopy{srcKey: "full/shadow/db/t1/default/all_2_2_0", dstKey: "new/shadow/db/t1/hdd/all_2_2_0", isDirectory: true}, copies[3])
+
+	// archives with several parts, uploaded with upload_by_part: false, are never copied
+	tables["increment1"].Files["default"] = []string{"default_1.tar"}
+	_, _, err = planConsolidateTable("new", chain, title, false, loadTable)
+	assert.ErrorContains(t, err, "part all_3_3_0 archive not found in increment1, backups uploaded with general->upload_by_part: false are not supported")
+	tables["increment1"].Files["default"] = []string{"default_all_3_3_0_5.tar"}
+	_, _, err = planConsolidateTable("new", chain, title, false, loadTable)
+	assert.ErrorContains(t, err, "part all_3_3_0 archive not found in increment1", "archive of other part with the same prefix")
+
+	// required part deleted from required backup
+	tables["increment1"].Parts["default"] = []metadata.Part{{Name: "all_3_3_0"}}
+	_, _, err = planConsolidateTable("new", chain, title, false, loadTable)
+	assert.ErrorContains(t, err, "part all_1_1_0 not found in required backups sequence increment2 -> increment1 -> full")
+
+	tables["increment2"].Parts["default"] = []metadata.Part{{Name: "all_4_4_0", BasePart: "all_1_1_0", RequiredFiles: []string{"data.bin"}}}
+	_, _, err = planConsolidateTable("new", chain, title, false, loadTable)
+	assert.ErrorContains(t, err, "upload_diff_files is not supported")
+}
@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/server/metrics"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	apexLog "github.com/apex/log"
+	"github.com/urfave/cli"
+)
+
+// continuousChangedTable - table with active parts created after last continuous backup
+type continuousChangedTable struct {
+	Database         string    `ch:"database"`
+	Table            string    `ch:"table"`
+	Parts            uint64    `ch:"parts"`
+	LastModification time.Time `ch:"last_modification"`
+}
+
+// filterContinuousChangedTables - only tables which match tablePattern and don't match clickhouse->skip_tables, sorted by name
+func filterContinuousChangedTables(changedTables []continuousChangedTable, tablePattern string, skipTables []string) []continuousChangedTable {
+	if tablePattern == "" {
+		tablePattern = "*.*"
+	}
+	patterns := strings.Split(tablePattern, ",")
+	result := make([]continuousChangedTable, 0, len(changedTables))
+	for _, t := range changedTables {
+		tableName := fmt.Sprintf("%s.%s", t.Database, t.Table)
+		isMatched := false
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), tableName); matched {
+				isMatched = true
+				break
+			}
+		}
+		for _, skipPattern := range skipTables {
+			if matched, _ := filepath.Match(strings.Trim(skipPattern, " \t\r\n"), tableName); matched {
+				isMatched = false
+				break
+			}
+		}
+		if isMatched {
+			result = append(result, t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Database != result[j].Database {
+			return result[i].Database < result[j].Database
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
+
+// getContinuousBackupType - full when no previous backup or chain reached general->continuous_max_increments, otherwise increment
+func getContinuousBackupType(prevBackupName string, incrementsAfterFull, maxIncrements int) string {
+	if prevBackupName == "" || (maxIncrements > 0 && incrementsAfterFull >= maxIncrements) {
+		return "full"
+	}
+	return "increment"
+}
+
+// getContinuousChangedTables - tables with active parts which modified after since, by ClickHouse server clock, with max modification_time as next since
+func (b *Backuper) getContinuousChangedTables(ctx context.Context, since time.Time, tablePattern string) ([]continuousChangedTable, time.Time, error) {
+	changedTables := make([]continuousChangedTable, 0)
+	query := "SELECT database, table, count() AS parts, max(modification_time) AS last_modification FROM system.parts WHERE active AND database NOT IN ('system','INFORMATION_SCHEMA','information_schema') AND modification_time > ? GROUP BY database, table"
+	if err := b.ch.SelectContext(ctx, &changedTables, query, since.UTC()); err != nil {
+		return nil, since, fmt.Errorf("can't get new parts from system.parts: %v", err)
+	}
+	changedTables = filterContinuousChangedTables(changedTables, tablePattern, b.cfg.ClickHouse.SkipTables)
+	for _, t := range changedTables {
+		if t.LastModification.After(since) {
+			since = t.LastModification
+		}
+	}
+	return changedTables, since, nil
+}
+
+// Continuous - near-real-time backup, each general->continuous_interval check system.parts for active parts created after previous backup
+// - when new parts found, run create_remote increment --diff-from-remote=previous + delete local, only new parts uploaded, other parts reference previous backups in chain
+// - first backup and backup after general->continuous_max_increments increments in chain is full, which starts new chain
+// - backup name from general->continuous_backup_name_template, state available in GET /backup/watch/status with `continuous` mode
+// - after failure the same check repeats after continuous_interval, parts created since last success backup will upload with next backup
+func (b *Backuper) Continuous(continuousInterval, tablePattern string, skipCheckPartsColumns bool, version string, commandId int, metrics metrics.APIMetricsInterface, cliCtx *cli.Context) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	defer deleteWatchState(commandId)
+
+	validateParams := func() error {
+		if continuousInterval != "" {
+			b.cfg.General.ContinuousInterval = continuousInterval
+			if b.cfg.General.ContinuousDuration, err = time.ParseDuration(continuousInterval); err != nil {
+				return fmt.Errorf("continuousInterval `%s` parsing error: %v", continuousInterval, err)
+			}
+		}
+		if b.cfg.General.ContinuousDuration <= 0 {
+			return fmt.Errorf("continuous_interval `%s` should be positive", b.cfg.General.ContinuousInterval)
+		}
+		if b.cfg.General.ContinuousBackupNameTemplate == b.cfg.General.WatchBackupNameTemplate {
+			return fmt.Errorf("continuous_backup_name_template shall be different with watch_backup_name_template, `%s`", b.cfg.General.ContinuousBackupNameTemplate)
+		}
+		return nil
+	}
+	if err = validateParams(); err != nil {
+		return err
+	}
+	if !b.ch.IsOpen {
+		if err = b.ch.Connect(); err != nil {
+			return err
+		}
+	}
+	prevBackupName, _, lastBackup, _, incrementsAfterFull, err := b.getLastWatchBackup(ctx, b.cfg.General.ContinuousBackupNameTemplate)
+	if err != nil {
+		return err
+	}
+	since := lastBackup
+	createRemoteErrCount := 0
+	deleteLocalErrCount := 0
+	failures := 0
+	for {
+		if !b.ch.IsOpen {
+			if err = b.ch.Connect(); err != nil {
+				return err
+			}
+		}
+		if cliCtx != nil {
+			if cfg, err := config.LoadConfig(config.GetConfigPath(cliCtx)); err == nil {
+				if cliCtx.Bool("no-cache") {
+					cfg.General.RemoteMetadataCacheDuration = 0
+				}
+				b.cfg = cfg
+			} else {
+				b.log.Warnf("continuous config.LoadConfig error: %v", err)
+			}
+			if err = validateParams(); err != nil {
+				return err
+			}
+		}
+		backupType := getContinuousBackupType(prevBackupName, incrementsAfterFull, b.cfg.General.ContinuousMaxIncrements)
+		changedTables, nextSince, err := b.getContinuousChangedTables(ctx, since, tablePattern)
+		if err != nil {
+			return err
+		}
+		if backupType == "full" || len(changedTables) > 0 {
+			backupName, err := b.newBackupNameFromTemplate(ctx, b.cfg.General.ContinuousBackupNameTemplate, "continuous_backup_name_template", backupType)
+			if err != nil {
+				return err
+			}
+			log := b.log.WithFields(apexLog.Fields{
+				"backup":    backupName,
+				"operation": "continuous",
+			})
+			newParts := uint64(0)
+			for _, t := range changedTables {
+				newParts += t.Parts
+			}
+			log.WithField("tables", len(changedTables)).WithField("parts", newParts).Infof("create %s backup", backupType)
+			diffFromRemote := ""
+			if backupType == "increment" {
+				diffFromRemote = prevBackupName
+			}
+			updateWatchState(commandId, "continuous", func(state *WatchState) {
+				state.State, state.BackupName, state.BackupType = "running", backupName, backupType
+				state.NextBackupType, state.NextBackupTime = "", nil
+			})
+			var createRemoteErr, deleteLocalErr error
+			if metrics != nil {
+				createRemoteErr, createRemoteErrCount = metrics.ExecuteWithMetrics("create_remote", createRemoteErrCount, func() error {
+					return b.CreateToRemote(backupName, false, "", diffFromRemote, tablePattern, nil, false, false, false, false, false, skipCheckPartsColumns, false, nil, false, version, commandId)
+				})
+				deleteLocalErr, deleteLocalErrCount = metrics.ExecuteWithMetrics("delete", deleteLocalErrCount, func() error {
+					return b.RemoveBackupLocal(ctx, backupName, nil)
+				})
+			} else {
+				createRemoteErr = b.CreateToRemote(backupName, false, "", diffFromRemote, tablePattern, nil, false, false, false, false, false, skipCheckPartsColumns, false, nil, false, version, commandId)
+				deleteLocalErr = b.RemoveBackupLocal(ctx, backupName, nil)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if createRemoteErr != nil {
+				failures += 1
+				log.Errorf("create_remote %s return error %d times in a row: %v", backupName, failures, createRemoteErr)
+				updateWatchState(commandId, "continuous", func(state *WatchState) {
+					state.LastError, state.ConsecutiveFailures = createRemoteErr.Error(), failures
+				})
+			} else {
+				failures = 0
+				prevBackupName = backupName
+				since = nextSince
+				if backupType == "full" {
+					incrementsAfterFull = 0
+				} else {
+					incrementsAfterFull += 1
+				}
+				updateWatchState(commandId, "continuous", func(state *WatchState) {
+					now := time.Now().UTC()
+					state.LastSuccessBackup, state.LastSuccessTime, state.LastError, state.ConsecutiveFailures = backupName, &now, "", 0
+				})
+			}
+			if deleteLocalErr != nil {
+				log.Errorf("delete local %s return error: %v", backupName, deleteLocalErr)
+			}
+		}
+		nextCheck := time.Now().Add(b.cfg.General.ContinuousDuration).UTC()
+		updateWatchState(commandId, "continuous", func(state *WatchState) {
+			state.State, state.NextBackupType, state.NextBackupTime = "waiting", getContinuousBackupType(prevBackupName, incrementsAfterFull, b.cfg.General.ContinuousMaxIncrements), &nextCheck
+		})
+		if b.ch.IsOpen {
+			b.ch.Close()
+		}
+		if err = waitWithProgress(ctx, b.cfg.General.ContinuousDuration); err != nil {
+			return err
+		}
+	}
+}
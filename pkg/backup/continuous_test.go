@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterContinuousChangedTables(t *testing.T) {
+	now := time.Now()
+	changedTables := []continuousChangedTable{
+		{Database: "logs", Table: "events", Parts: 2, LastModification: now},
+		{Database: "default", Table: "test", Parts: 1, LastModification: now},
+		{Database: "default", Table: "tmp_test", Parts: 3, LastModification: now},
+		{Database: "billing", Table: "invoices", Parts: 1, LastModification: now},
+	}
+	names := func(tables []continuousChangedTable) []string {
+		result := make([]string, len(tables))
+		for i, t := range tables {
+			result[i] = t.Database + "." + t.Table
+		}
+		return result
+	}
+	assert.Equal(t, []string{"billing.invoices", "default.test", "default.tmp_test", "logs.events"}, names(filterContinuousChangedTables(changedTables, "", nil)))
+	assert.Equal(t, []string{"default.test", "logs.events"}, names(filterContinuousChangedTables(changedTables, "default.*, logs.*", []string{"*.tmp_*"})))
+	assert.Empty(t, filterContinuousChangedTables(changedTables, "system.*", nil))
+}
+
+func TestGetContinuousBackupType(t *testing.T) {
+	assert.Equal(t, "full", getContinuousBackupType("", 0, 10))
+	assert.Equal(t, "increment", getContinuousBackupType("prev", 0, 10))
+	assert.Equal(t, "increment", getContinuousBackupType("prev", 9, 10))
+	assert.Equal(t, "full", getContinuousBackupType("prev", 10, 10))
+	assert.Equal(t, "increment", getContinuousBackupType("prev", 1000, 0))
+}
+
+func TestGetBackupNameTemplateRE(t *testing.T) {
+	watchRE := getBackupNameTemplateRE("shard1-{type}-{time:20060102150405}")
+	assert.True(t, watchRE.MatchString("shard1-full-20240103100000"))
+	assert.True(t, watchRE.MatchString("shard1-increment-20240103100000"))
+	assert.False(t, watchRE.MatchString("continuous-shard1-full-20240103100000"))
+	assert.False(t, watchRE.MatchString("policy-shard1-full-20240103100000"))
+
+	continuousRE := getBackupNameTemplateRE("continuous-shard1-{type}-{time:20060102150405}")
+	assert.True(t, continuousRE.MatchString("continuous-shard1-increment-20240103100000"))
+	assert.False(t, continuousRE.MatchString("shard1-full-20240103100000"))
+
+	dotRE := getBackupNameTemplateRE("db.host-{type}-{time:2006}")
+	assert.True(t, dotRE.MatchString("db.host-full-2024"))
+	assert.False(t, dotRE.MatchString("db_host-full-2024"))
+}
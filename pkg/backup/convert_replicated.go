@@ -0,0 +1,42 @@
+package backup
+
+import (
+	"regexp"
+)
+
+var replicatedEngineWithArgsRE = regexp.MustCompile(`(ENGINE\s*=\s*)Replicated(\w*MergeTree)\s*\(\s*'(?:[^'\\]|\\.)*'\s*,\s*'(?:[^'\\]|\\.)*'\s*(?:,\s*)?`)
+var replicatedEngineWithoutArgsRE = regexp.MustCompile(`(ENGINE\s*=\s*)Replicated(\w*MergeTree)\b`)
+var replicatedDatabaseEngineRE = regexp.MustCompile(`(ENGINE\s*=\s*)Replicated\s*\([^)]*\)`)
+var onClusterClauseRE = regexp.MustCompile("\\s+ON CLUSTER\\s+('[^']*'|`[^`]*`|[\\w{}.-]+)")
+
+// WithConvertReplicated - `restore --convert-replicated`, restore Replicated*MergeTree tables and Replicated databases as non-replicated
+func WithConvertReplicated(convertReplicated bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.convertReplicated = convertReplicated
+	}
+}
+
+// convertReplicatedTableQuery - Replicated*MergeTree('zk_path', 'replica'[, params]) to *MergeTree([params]), ON CLUSTER clause removed
+func convertReplicatedTableQuery(query string) string {
+	query = onClusterClauseRE.ReplaceAllString(query, "")
+	query = replicatedEngineWithArgsRE.ReplaceAllString(query, "${1}${2}(")
+	return replicatedEngineWithoutArgsRE.ReplaceAllString(query, "${1}${2}")
+}
+
+// convertReplicatedDatabaseQuery - ENGINE=Replicated('zk_path', 'shard', 'replica') to ENGINE=Atomic, ON CLUSTER clause removed
+func convertReplicatedDatabaseQuery(query string) string {
+	query = onClusterClauseRE.ReplaceAllString(query, "")
+	return replicatedDatabaseEngineRE.ReplaceAllString(query, "${1}Atomic")
+}
+
+// convertReplicatedTables - apply convertReplicatedTableQuery to all tables, return count of converted tables
+func convertReplicatedTables(tables ListOfTables) int {
+	converted := 0
+	for i := range tables {
+		if query := convertReplicatedTableQuery(tables[i].Query); query != tables[i].Query {
+			tables[i].Query = query
+			converted++
+		}
+	}
+	return converted
+}
@@ -0,0 +1,33 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertReplicatedTableQuery(t *testing.T) {
+	testCases := map[string]string{
+		"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id":                         "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id",
+		"CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{uuid}/{shard}', '{replica}', v) ORDER BY id": "CREATE TABLE db.t (id UInt64, v UInt64) ENGINE = ReplacingMergeTree(v) ORDER BY id",
+		"CREATE TABLE db.t ON CLUSTER '{cluster}' (id UInt64, s Int8) ENGINE=ReplicatedCollapsingMergeTree('/p/it\\'s', 'r1',s) ORDER BY id":             "CREATE TABLE db.t (id UInt64, s Int8) ENGINE=CollapsingMergeTree(s) ORDER BY id",
+		"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id":                                                                         "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+		"CREATE TABLE db.t (`d` Date, `v` UInt64) ENGINE = ReplicatedGraphiteMergeTree('/p', '{replica}', 'graphite_rollup') PARTITION BY d ORDER BY d":  "CREATE TABLE db.t (`d` Date, `v` UInt64) ENGINE = GraphiteMergeTree('graphite_rollup') PARTITION BY d ORDER BY d",
+		"CREATE MATERIALIZED VIEW db.mv ENGINE = ReplicatedSummingMergeTree('/p/{database}/{table}', '{replica}') ORDER BY id AS SELECT id FROM db.t":    "CREATE MATERIALIZED VIEW db.mv ENGINE = SummingMergeTree() ORDER BY id AS SELECT id FROM db.t",
+		"CREATE TABLE db.t_distr ON CLUSTER `prod` (id UInt64) ENGINE = Distributed('prod', 'db', 't')":                                                  "CREATE TABLE db.t_distr (id UInt64) ENGINE = Distributed('prod', 'db', 't')",
+		"CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id COMMENT 'ReplicatedMergeTree'":                                                     "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id COMMENT 'ReplicatedMergeTree'",
+	}
+	for query, expected := range testCases {
+		assert.Equal(t, expected, convertReplicatedTableQuery(query))
+	}
+	assert.Equal(t, "CREATE DATABASE db ENGINE = Atomic", convertReplicatedDatabaseQuery("CREATE DATABASE db ON CLUSTER 'prod' ENGINE = Replicated('/clickhouse/databases/db', '{shard}', '{replica}')"))
+	assert.Equal(t, "CREATE DATABASE db ENGINE = Atomic", convertReplicatedDatabaseQuery("CREATE DATABASE db ENGINE = Atomic"))
+
+	tables := ListOfTables{
+		{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/p', 'r') ORDER BY id"},
+		{Database: "db", Table: "t2", Query: "CREATE TABLE db.t2 (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	assert.Equal(t, 1, convertReplicatedTables(tables))
+	assert.Equal(t, metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id"}, tables[0])
+}
@@ -53,6 +53,21 @@ func NewBackupName() string {
 	return time.Now().UTC().Format(TimeFormatForBackup)
 }
 
+// maxClockSkew - backup names, retention and watch intervals depend on local time, warn when ClickHouse clock differs more
+const maxClockSkew = time.Minute
+
+// checkClockSkew - compare ClickHouse now() with local time
+func (b *Backuper) checkClockSkew(ctx context.Context, log *apexLog.Entry) {
+	var clickhouseNow time.Time
+	if err := b.ch.SelectSingleRow(ctx, &clickhouseNow, "SELECT now()"); err != nil {
+		log.Warnf("can't get now() from clickhouse: %v", err)
+		return
+	}
+	if skew := time.Since(clickhouseNow); skew > maxClockSkew || skew < -maxClockSkew {
+		status.Current.AddWarning(ctx, log, status.WarningClockSkew, "local clock differs from ClickHouse now() by %s, check NTP synchronization", skew.Round(time.Second))
+	}
+}
+
 // CreateBackup - create new backup of all tables matched by tablePattern
 // If backupName is empty string will use default backup name
 func (b *Backuper) CreateBackup(backupName, diffFromRemote, tablePattern string, partitions []string, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, skipCheckPartsColumns bool, version string, commandId int) error {
@@ -68,6 +83,7 @@ func (b *Backuper) CreateBackup(backupName, diffFromRemote, tablePattern string,
 		backupName = NewBackupName()
 	}
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	b.setLogComment("create", backupName, commandId)
 	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "create",
@@ -76,6 +92,25 @@ func (b *Backuper) CreateBackup(backupName, diffFromRemote, tablePattern string,
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer b.ch.Close()
+	release, err := b.lockOperation("create", backupName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, releaseKeeperLock, err := b.lockKeeper(ctx, "create", backupName, schemaOnly)
+	if err != nil {
+		return err
+	}
+	defer releaseKeeperLock()
+	if b.ifNotExists {
+		if location, existsErr := b.getExistingBackupLocation(ctx, backupName); existsErr != nil {
+			return existsErr
+		} else if location != "" {
+			log.Infof("'%s' already exists on %s, skip create", backupName, location)
+			return nil
+		}
+	}
+	b.checkClockSkew(ctx, log)
 
 	if skipCheckPartsColumns && b.cfg.ClickHouse.CheckPartsColumns {
 		b.cfg.ClickHouse.CheckPartsColumns = false
@@ -95,6 +130,7 @@ func (b *Backuper) CreateBackup(backupName, diffFromRemote, tablePattern string,
 	i := 0
 	for _, table := range tables {
 		if table.Skip {
+			status.Current.AddWarning(ctx, log, status.WarningSkippedTable, "%s.%s skipped by clickhouse->skip_tables or clickhouse->skip_table_engines", table.Database, table.Name)
 			continue
 		}
 		i++
@@ -102,6 +138,17 @@ func (b *Backuper) CreateBackup(backupName, diffFromRemote, tablePattern string,
 	if i == 0 && !b.cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("no tables for backup")
 	}
+	// replica name equal to hostname will break restore after host re-provisioning with new name
+	hostnameMacros, err := b.ch.GetHostnameMacros(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get hostname macros from clickhouse: %v", err)
+	}
+	for i := range tables {
+		tables[i].CreateTableQuery = replaceReplicaName(tables[i].CreateTableQuery, hostnameMacros)
+	}
+	for i := range allDatabases {
+		allDatabases[i].Query = replaceReplicaName(allDatabases[i].Query, hostnameMacros)
+	}
 
 	allFunctions, err := b.ch.GetUserDefinedFunctions(ctx)
 	if err != nil {
@@ -125,16 +172,61 @@ func (b *Backuper) CreateBackup(backupName, diffFromRemote, tablePattern string,
 	}
 	partitionsIdMap, partitionsNameList := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, tables, nil, partitions)
 	doBackupData := !schemaOnly && !rbacOnly && !configsOnly
+	if b.dryRun != nil {
+		return b.planCreate(ctx, backupName, tables, partitionsIdMap, doBackupData, createRBAC || rbacOnly, createConfigs || configsOnly, disks)
+	}
+	// size of partitions and embedded incremental backup is unknown before backup
+	if doBackupData && len(partitions) == 0 && (!b.cfg.ClickHouse.UseEmbeddedBackupRestore || diffFromRemote == "") {
+		requiredSpace, requiredSpaceErr := b.getCreateDiskSpace(ctx, tables, b.cfg.ClickHouse.UseEmbeddedBackupRestore)
+		if requiredSpaceErr != nil {
+			return requiredSpaceErr
+		}
+		if err = b.checkDiskSpace("create", disks, requiredSpace, nil, log); err != nil {
+			return err
+		}
+	}
 	backupRBACSize, backupConfigSize, rbacAndConfigsErr := b.createRBACAndConfigsIfNecessary(ctx, backupName, createRBAC, rbacOnly, createConfigs, configsOnly, disks, diskMap, log)
 	if rbacAndConfigsErr != nil {
 		return rbacAndConfigsErr
 	}
+	backupKeeperSize := uint64(0)
+	if b.cfg.General.KeeperBackup && doBackupData {
+		backupPath := path.Join(b.DefaultDataPath, "backup")
+		if b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
+			backupPath = diskMap[b.cfg.ClickHouse.EmbeddedBackupDisk]
+		}
+		if backupKeeperSize, err = b.createBackupKeeper(ctx, path.Join(backupPath, backupName), tables, disks, log); err != nil {
+			return fmt.Errorf("can't create keeper backup: %v", err)
+		}
+	}
+	b.afterFreeze = nil
+	if doBackupData {
+		if err = b.runHooks(ctx, hookEvent{Stage: "before_freeze", Operation: "create", BackupName: backupName}, log); err != nil {
+			return err
+		}
+		b.afterFreeze = b.newAfterFreezeHook(backupName, log)
+	}
 	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
-		err = b.createBackupEmbedded(ctx, backupName, diffFromRemote, doBackupData, schemaOnly, version, tablePattern, partitionsNameList, partitionsIdMap, tables, allDatabases, allFunctions, disks, diskMap, diskTypes, backupRBACSize, backupConfigSize, log, startBackup)
+		err = b.createBackupEmbedded(ctx, backupName, diffFromRemote, doBackupData, schemaOnly, version, tablePattern, partitionsNameList, partitionsIdMap, tables, allDatabases, allFunctions, disks, diskMap, diskTypes, backupRBACSize, backupConfigSize, backupKeeperSize, log, startBackup)
 	} else {
-		err = b.createBackupLocal(ctx, backupName, diffFromRemote, doBackupData, schemaOnly, rbacOnly, configsOnly, version, partitionsIdMap, tables, tablePattern, disks, diskMap, diskTypes, allDatabases, allFunctions, backupRBACSize, backupConfigSize, log, startBackup)
+		err = b.createBackupLocal(ctx, backupName, diffFromRemote, doBackupData, schemaOnly, rbacOnly, configsOnly, version, partitionsIdMap, tables, tablePattern, disks, diskMap, diskTypes, allDatabases, allFunctions, backupRBACSize, backupConfigSize, backupKeeperSize, log, startBackup)
+	}
+	// after_freeze usually executed right after freeze, here it executes when create failed before, to resume what before_freeze paused
+	if hookErr := b.afterFreeze.run(err); hookErr != nil {
+		if err == nil {
+			err = hookErr
+		} else {
+			log.Error(hookErr.Error())
+		}
 	}
 	if err != nil {
+		// snapshots are not referenced by any backup, context could be already canceled
+		if len(b.cloudSnapshots) > 0 {
+			if rollbackErr := b.deleteCloudSnapshots(context.Background(), b.cloudSnapshots, log); rollbackErr != nil {
+				log.Errorf("creating failed -> b.deleteCloudSnapshots error: %v", rollbackErr)
+			}
+			b.cloudSnapshots = nil
+		}
 		// delete local backup if can't create
 		if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
 			log.Errorf("creating failed -> b.RemoveBackupLocal error: %v", removeBackupErr)
@@ -185,7 +277,53 @@ func (b *Backuper) createRBACAndConfigsIfNecessary(ctx context.Context, backupNa
 	return backupRBACSize, backupConfigSize, nil
 }
 
-func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRemote string, doBackupData, schemaOnly, rbacOnly, configsOnly bool, backupVersion string, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, tables []clickhouse.Table, tablePattern string, disks []clickhouse.Disk, diskMap, diskTypes map[string]string, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, backupRBACSize, backupConfigSize uint64, log *apexLog.Entry, startBackup time.Time) error {
+// getExistingBackupLocation - return "local" or "remote" when backupName already exists, empty string otherwise,
+// broken backups are not counted, otherwise `--if-not-exists` would skip create forever after one failure
+func (b *Backuper) getExistingBackupLocation(ctx context.Context, backupName string) (string, error) {
+	localBackups, _, err := b.GetLocalBackups(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("can't get local backups: %v", err)
+	}
+	if isValidLocalBackupExists(localBackups, backupName) {
+		return "local", nil
+	}
+	return b.getExistingRemoteBackupLocation(ctx, backupName)
+}
+
+func (b *Backuper) getExistingRemoteBackupLocation(ctx context.Context, backupName string) (string, error) {
+	if b.cfg.General.RemoteStorage == "none" {
+		return "", nil
+	}
+	// metadata.json shall be parsed to detect broken backup
+	remoteBackups, err := b.GetRemoteBackups(ctx, true)
+	if err != nil {
+		return "", fmt.Errorf("can't get remote backups: %v", err)
+	}
+	if isValidRemoteBackupExists(remoteBackups, backupName) {
+		return "remote", nil
+	}
+	return "", nil
+}
+
+func isValidLocalBackupExists(localBackups []LocalBackup, backupName string) bool {
+	for _, localBackup := range localBackups {
+		if localBackup.BackupName == backupName && localBackup.Broken == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidRemoteBackupExists(remoteBackups []storage.Backup, backupName string) bool {
+	for _, remoteBackup := range remoteBackups {
+		if remoteBackup.BackupName == backupName && remoteBackup.Broken == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRemote string, doBackupData, schemaOnly, rbacOnly, configsOnly bool, backupVersion string, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, tables []clickhouse.Table, tablePattern string, disks []clickhouse.Disk, diskMap, diskTypes map[string]string, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, backupRBACSize, backupConfigSize, backupKeeperSize uint64, log *apexLog.Entry, startBackup time.Time) error {
 	// Create backup dir on all clickhouse disks
 	for _, disk := range disks {
 		if err := filesystemhelper.Mkdir(path.Join(disk.Path, "backup"), b.ch, disks); err != nil {
@@ -221,7 +359,7 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRe
 		if err = config.ValidateObjectDiskConfig(b.cfg); err != nil {
 			return err
 		}
-		b.dst, err = storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName)
+		b.dst, err = b.newBackupDestination(ctx, false, backupName)
 		if err != nil {
 			return err
 		}
@@ -243,10 +381,40 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRe
 		}
 	}
 
+	if b.cfg.ClickHouse.CloudSnapshotType != "" && doBackupData {
+		if err := b.createCloudSnapshots(ctx, backupName, tables, log); err != nil {
+			return err
+		}
+		b.afterFreeze.setTables(0)
+		// data parts are inside cloud snapshots, backup contains only schema
+		doBackupData, schemaOnly = false, true
+	} else if b.cfg.ClickHouse.FilesystemSnapshotType != "" && doBackupData {
+		removeFSSnapshot, err := b.createFSSnapshot(ctx, backupName, tables, log)
+		if err != nil {
+			return err
+		}
+		defer removeFSSnapshot()
+		b.afterFreeze.setTables(0)
+	} else if b.consistentSnapshot && doBackupData {
+		// merges of each table started right after FREEZE, deferred call starts merges of tables which were not frozen
+		defer b.startAllMerges(log)
+		if err := b.stopMergesForSnapshot(ctx, tables, log); err != nil {
+			return err
+		}
+	}
+
 	var backupDataSize, backupMetadataSize uint64
 	var metaMutex sync.Mutex
 	createBackupWorkingGroup, createCtx := errgroup.WithContext(ctx)
 	createBackupWorkingGroup.SetLimit(int(b.cfg.General.UploadConcurrency))
+	tablesForBackup := 0
+	for _, table := range tables {
+		if !table.Skip {
+			tablesForBackup++
+		}
+	}
+	progress := b.newProgressReporter("create", backupName, tablesForBackup)
+	b.afterFreeze.setTables(tablesForBackup)
 
 	var tableMetas []metadata.TableTitle
 	for _, tableItem := range tables {
@@ -256,9 +424,14 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRe
 			continue
 		}
 		createBackupWorkingGroup.Go(func() error {
-			log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name))
+			// tables without data are counted as frozen too
+			defer b.afterFreeze.tableFrozen(fmt.Sprintf("%s.%s", table.Database, table.Name))
+			log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name)).WithField("phase", "create_table")
 			var realSize map[string]int64
 			var disksToPartsMap map[string][]metadata.Part
+			var detachedPartsMap map[string][]metadata.Part
+			var projections []metadata.ProjectionMetadata
+			var checksums map[string]map[string]string
 			if doBackupData && table.BackupType == clickhouse.ShardBackupFull {
 				log.Debug("create data")
 				shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
@@ -272,6 +445,27 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRe
 				for _, size := range realSize {
 					atomic.AddUint64(&backupDataSize, uint64(size))
 				}
+				var projectionsErr error
+				if projections, projectionsErr = b.validateTableProjections(createCtx, backupName, &table, disks, disksToPartsMap, log); projectionsErr != nil {
+					log.Errorf("b.validateTableProjections error: %v", projectionsErr)
+					return projectionsErr
+				}
+				if b.includeDetached {
+					detachedSize := int64(0)
+					var detachedErr error
+					if detachedPartsMap, detachedSize, detachedErr = b.addDetachedPartsToLocalBackup(createCtx, backupName, &table, disks, disksToPartsMap); detachedErr != nil {
+						log.Errorf("b.addDetachedPartsToLocalBackup error: %v", detachedErr)
+						return detachedErr
+					}
+					atomic.AddUint64(&backupDataSize, uint64(detachedSize))
+				}
+				if b.cfg.General.IntegrityManifest {
+					var checksumsErr error
+					if checksums, checksumsErr = b.calculateTableChecksums(createCtx, backupName, table, disks, disksToPartsMap); checksumsErr != nil {
+						log.Errorf("b.calculateTableChecksums error: %v", checksumsErr)
+						return checksumsErr
+					}
+				}
 			}
 			// https://github.com/Altinity/clickhouse-backup/issues/529
 			log.Debug("get in progress mutations list")
@@ -284,17 +478,29 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRe
 					return inProgressMutationsErr
 				}
 			}
+			var streaming *metadata.StreamingMetadata
+			if !rbacOnly && !configsOnly {
+				var streamingErr error
+				if streaming, streamingErr = b.getStreamingMetadata(createCtx, &table, log); streamingErr != nil {
+					log.Errorf("b.getStreamingMetadata error: %v", streamingErr)
+					return streamingErr
+				}
+			}
 			log.Debug("create metadata")
 			if schemaOnly || doBackupData {
 				metadataSize, createTableMetadataErr := b.createTableMetadata(path.Join(backupPath, "metadata"), metadata.TableMetadata{
-					Table:        table.Name,
-					Database:     table.Database,
-					Query:        table.CreateTableQuery,
-					TotalBytes:   table.TotalBytes,
-					Size:         realSize,
-					Parts:        disksToPartsMap,
-					Mutations:    inProgressMutations,
-					MetadataOnly: schemaOnly || table.BackupType == clickhouse.ShardBackupSchema,
+					Table:         table.Name,
+					Database:      table.Database,
+					Query:         table.CreateTableQuery,
+					TotalBytes:    table.TotalBytes,
+					Size:          realSize,
+					Parts:         disksToPartsMap,
+					Mutations:     inProgressMutations,
+					MetadataOnly:  schemaOnly || table.BackupType == clickhouse.ShardBackupSchema,
+					Checksums:     checksums,
+					DetachedParts: detachedPartsMap,
+					Projections:   projections,
+					Streaming:     streaming,
 				}, disks)
 				if createTableMetadataErr != nil {
 					log.Errorf("b.createTableMetadata error: %v", createTableMetadataErr)
@@ -309,6 +515,11 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRe
 				metaMutex.Unlock()
 			}
 			log.Infof("done")
+			var tableSize uint64
+			for _, size := range realSize {
+				tableSize += uint64(size)
+			}
+			progress.tableDone(fmt.Sprintf("%s.%s", table.Database, table.Name), tableSize)
 			return nil
 		})
 	}
@@ -317,14 +528,18 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName, diffFromRe
 	}
 
 	backupMetaFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json")
-	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, diffFromRemote, backupVersion, "regular", diskMap, diskTypes, disks, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, tableMetas, allDatabases, allFunctions, log); err != nil {
+	tags := "regular"
+	if len(b.cloudSnapshots) > 0 {
+		tags += ",cloud_snapshot"
+	}
+	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, diffFromRemote, backupVersion, tags, diskMap, diskTypes, disks, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, backupKeeperSize, tableMetas, allDatabases, allFunctions, log); err != nil {
 		return fmt.Errorf("createBackupMetadata return error: %v", err)
 	}
 	log.WithField("duration", utils.HumanizeDuration(time.Since(startBackup))).Info("done")
 	return nil
 }
 
-func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, baseBackup string, doBackupData, schemaOnly bool, backupVersion, tablePattern string, partitionsNameList map[metadata.TableTitle][]string, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, tables []clickhouse.Table, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, disks []clickhouse.Disk, diskMap, diskTypes map[string]string, backupRBACSize, backupConfigSize uint64, log *apexLog.Entry, startBackup time.Time) error {
+func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, baseBackup string, doBackupData, schemaOnly bool, backupVersion, tablePattern string, partitionsNameList map[metadata.TableTitle][]string, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, tables []clickhouse.Table, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, disks []clickhouse.Disk, diskMap, diskTypes map[string]string, backupRBACSize, backupConfigSize, backupKeeperSize uint64, log *apexLog.Entry, startBackup time.Time) error {
 	// TODO: Implement sharded backup operations for embedded backups
 	if doesShard(b.cfg.General.ShardedOperationMode) {
 		return fmt.Errorf("cannot perform embedded backup: %w", errShardOperationUnsupported)
@@ -371,6 +586,7 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, baseBac
 		if err := b.ch.SelectContext(ctx, &backupResult, backupSQL); err != nil {
 			return fmt.Errorf("backup error: %v", err)
 		}
+		b.afterFreeze.setTables(0)
 		if len(backupResult) != 1 || (backupResult[0].Status != "BACKUP_COMPLETE" && backupResult[0].Status != "BACKUP_CREATED") {
 			return fmt.Errorf("backup return wrong results: %+v", backupResult)
 		}
@@ -397,7 +613,7 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, baseBac
 
 		if doBackupData && b.cfg.ClickHouse.EmbeddedBackupDisk == "" {
 			var err error
-			if b.dst, err = storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName); err != nil {
+			if b.dst, err = b.newBackupDestination(ctx, false, backupName); err != nil {
 				return err
 			}
 			if err = b.dst.Connect(ctx); err != nil {
@@ -450,7 +666,7 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, baseBac
 		}
 	}
 	backupMetaFile := path.Join(backupPath, "metadata.json")
-	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, baseBackup, backupVersion, "embedded", diskMap, diskTypes, disks, backupDataSize[0].Size, backupMetadataSize, backupRBACSize, backupConfigSize, tablesTitle, allDatabases, allFunctions, log); err != nil {
+	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, baseBackup, backupVersion, "embedded", diskMap, diskTypes, disks, backupDataSize[0].Size, backupMetadataSize, backupRBACSize, backupConfigSize, backupKeeperSize, tablesTitle, allDatabases, allFunctions, log); err != nil {
 		return err
 	}
 
@@ -711,16 +927,32 @@ func (b *Backuper) AddTableToLocalBackup(ctx context.Context, backupName string,
 		}
 		return nil, nil, nil
 	}
+	if b.isTableOnStaticDisks(table, diskList) {
+		log.Info("data stored on read only web or s3_plain disks, supports only schema backup")
+		return nil, nil, nil
+	}
 	if b.cfg.ClickHouse.CheckPartsColumns {
 		if err := b.ch.CheckSystemPartsColumns(ctx, table); err != nil {
 			return nil, nil, err
 		}
 	}
+	if b.fsSnapshot != nil {
+		disksToPartsMap, realSize, isCopied, err := b.addTableFromFSSnapshot(ctx, backupName, table, diskList, tablesDiffFromRemote[metadata.TableTitle{Database: table.Database, Table: table.Name}], partitionsIdsMap, log)
+		if err != nil || isCopied {
+			return disksToPartsMap, realSize, err
+		}
+	}
 	// backup data
 	if err := b.ch.FreezeTable(ctx, table, shadowBackupUUID); err != nil {
 		return nil, nil, err
 	}
 	log.Debug("frozen")
+	b.afterFreeze.tableFrozen(fmt.Sprintf("%s.%s", table.Database, table.Name))
+	if b.snapshotBarrier != nil {
+		if err := b.applySnapshotBarrier(table, diskList, shadowBackupUUID, log); err != nil {
+			return nil, nil, err
+		}
+	}
 	version, err := b.ch.GetVersion(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -733,6 +965,9 @@ func (b *Backuper) AddTableToLocalBackup(ctx context.Context, backupName string,
 		case <-ctx.Done():
 			return nil, nil, ctx.Err()
 		default:
+			if b.isDiskTypeStatic(disk.Type) {
+				continue
+			}
 			shadowPath := path.Join(disk.Path, "shadow", shadowBackupUUID)
 			if _, err := os.Stat(shadowPath); err != nil && os.IsNotExist(err) {
 				continue
@@ -782,6 +1017,26 @@ func (b *Backuper) AddTableToLocalBackup(ctx context.Context, backupName string,
 	return disksToPartsMap, realSize, nil
 }
 
+// isTableOnStaticDisks - FREEZE is not possible when all table data paths belong to `web` or `s3_plain` disks
+func (b *Backuper) isTableOnStaticDisks(table *clickhouse.Table, disks []clickhouse.Disk) bool {
+	if len(table.DataPaths) == 0 {
+		return false
+	}
+	for _, dataPath := range table.DataPaths {
+		isStatic := false
+		for _, disk := range disks {
+			if b.isDiskTypeStatic(disk.Type) && disk.Path != "" && strings.HasPrefix(dataPath, disk.Path) {
+				isStatic = true
+				break
+			}
+		}
+		if !isStatic {
+			return false
+		}
+	}
+	return true
+}
+
 func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName string, tableDiffFromRemote metadata.TableMetadata, backupShadowPath string, disk clickhouse.Disk) (int64, error) {
 	var size int64
 	var err error
@@ -791,7 +1046,14 @@ func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName string,
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	uploadObjectDiskPartsWorkingGroup, ctx := errgroup.WithContext(ctx)
-	uploadObjectDiskPartsWorkingGroup.SetLimit(int(b.cfg.General.UploadConcurrency * b.cfg.General.UploadConcurrency))
+	copyConcurrency := b.cfg.General.ObjectDiskCopyConcurrency
+	if copyConcurrency <= 0 {
+		copyConcurrency = int(b.cfg.General.UploadConcurrency) * int(b.cfg.General.UploadConcurrency)
+	}
+	uploadObjectDiskPartsWorkingGroup.SetLimit(copyConcurrency)
+	copyStart := time.Now()
+	var copiedSize int64
+	retry := b.newRetrier()
 	srcDiskConnection, exists := object_disk.DisksConnections.Load(disk.Name)
 	if !exists {
 		return 0, fmt.Errorf("uploadObjectDiskParts: %s not present in object_disk.DisksConnections", disk.Name)
@@ -808,7 +1070,6 @@ func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName string,
 		if strings.Contains(fInfo.Name(), "frozen_metadata") {
 			return nil
 		}
-		var realSize, objSize int64
 		// upload only not required parts, https://github.com/Altinity/clickhouse-backup/issues/865
 		if tableDiffFromRemote.Database != "" && tableDiffFromRemote.Table != "" && len(tableDiffFromRemote.Parts[disk.Name]) > 0 {
 			partPaths := strings.SplitN(strings.TrimPrefix(fPath, backupShadowPath), "/", 2)
@@ -819,6 +1080,7 @@ func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName string,
 			}
 		}
 		uploadObjectDiskPartsWorkingGroup.Go(func() error {
+			var realSize int64
 			objPartFileMeta, readMetadataErr := object_disk.ReadMetadataFromFile(fPath)
 			if readMetadataErr != nil {
 				return readMetadataErr
@@ -827,16 +1089,25 @@ func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName string,
 				if storageObject.ObjectSize == 0 {
 					continue
 				}
-				if objSize, err = b.dst.CopyObject(
-					ctx,
-					storageObject.ObjectSize,
-					srcBucket,
-					path.Join(srcDiskConnection.GetRemotePath(), storageObject.ObjectRelativePath),
-					path.Join(backupName, disk.Name, storageObject.ObjectRelativePath),
-				); err != nil {
+				var objSize int64
+				copyErr := retry.RunCtx(ctx, func(ctx context.Context) error {
+					var err error
+					objSize, err = b.dst.CopyObject(
+						ctx,
+						storageObject.ObjectSize,
+						srcBucket,
+						path.Join(srcDiskConnection.GetRemotePath(), storageObject.ObjectRelativePath),
+						path.Join(backupName, disk.Name, storageObject.ObjectRelativePath),
+					)
 					return err
+				})
+				if copyErr != nil {
+					return copyErr
 				}
 				realSize += objSize
+				if throttleErr := b.throttleObjectDiskCopy(ctx, b.cfg.General.ObjectDiskCopyMaxBytesPerSecond, copyStart, atomic.AddInt64(&copiedSize, objSize)); throttleErr != nil {
+					return throttleErr
+				}
 			}
 			if realSize > objPartFileMeta.TotalSize {
 				atomic.AddInt64(&size, realSize)
@@ -848,7 +1119,7 @@ func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName string,
 		return nil
 	})
 	if walkErr != nil {
-		return 0, err
+		return 0, walkErr
 	}
 
 	if wgWaitErr := uploadObjectDiskPartsWorkingGroup.Wait(); wgWaitErr != nil {
@@ -857,7 +1128,23 @@ func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName string,
 	return size, nil
 }
 
-func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, backupName, requiredBackup, version, tags string, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize uint64, tableMetas []metadata.TableTitle, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry) error {
+// throttleObjectDiskCopy - server-side copy doesn't use local network, but could exhaust request rate and bandwidth of source and backup buckets
+func (b *Backuper) throttleObjectDiskCopy(ctx context.Context, maxSpeed uint64, startTime time.Time, copiedSize int64) error {
+	if maxSpeed == 0 || copiedSize <= 0 {
+		return nil
+	}
+	expectedDuration := time.Duration(float64(copiedSize) / float64(maxSpeed) * float64(time.Second))
+	if pause := expectedDuration - time.Since(startTime); pause > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, backupName, requiredBackup, version, tags string, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, backupKeeperSize uint64, tableMetas []metadata.TableTitle, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -875,9 +1162,21 @@ func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, bac
 			MetadataSize:            backupMetadataSize,
 			RBACSize:                backupRBACSize,
 			ConfigSize:              backupConfigSize,
+			KeeperSize:              backupKeeperSize,
 			Tables:                  tableMetas,
 			Databases:               []metadata.DatabasesMeta{},
 			Functions:               []metadata.FunctionsMeta{},
+			CloudSnapshots:          b.cloudSnapshots,
+		}
+		var err error
+		if backupMetadata.Settings, err = b.ch.GetChangedSettings(ctx, "system.settings"); err != nil {
+			log.Warnf("can't get changed system.settings: %v", err)
+		}
+		if backupMetadata.MergeTreeSettings, err = b.ch.GetChangedSettings(ctx, "system.merge_tree_settings"); err != nil {
+			log.Warnf("can't get changed system.merge_tree_settings: %v", err)
+		}
+		if backupMetadata.EncryptedDisks, err = b.getEncryptedDisks(ctx, disks); err != nil {
+			log.Warnf("can't get encrypted disks settings: %v", err)
 		}
 		for _, database := range allDatabases {
 			backupMetadata.Databases = append(backupMetadata.Databases, metadata.DatabasesMeta(database))
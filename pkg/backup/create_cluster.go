@@ -0,0 +1,364 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// clusterOperationPollInterval - how often `create_cluster` poll /backup/actions on each replica
+var clusterOperationPollInterval = 5 * time.Second
+
+// clusterOperationMaxPollErrors - how many consecutive failed polls of /backup/actions mean replica is unavailable
+var clusterOperationMaxPollErrors = 10
+
+// clusterReplica - replica definition from system.clusters
+type clusterReplica struct {
+	ShardNum    uint32 `ch:"shard_num"`
+	ReplicaNum  uint32 `ch:"replica_num"`
+	HostName    string `ch:"host_name"`
+	ErrorsCount uint32 `ch:"errors_count"`
+}
+
+// clusterActionRow - row of JSONEachRow response from POST and GET /backup/actions
+type clusterActionRow struct {
+	Id          int    `json:"id"`
+	OperationId int    `json:"operation_id"`
+	Command     string `json:"command"`
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+}
+
+// getClusterBackupPlan - group replicas by shard, only one replica in each shard backup data, the same way as `sharded_operation_mode: first-replica`
+// replicas without errors in system.clusters are active, when all replicas in shard have errors, all of them are used as active
+func getClusterBackupPlan(backupName, cluster string, replicas []clusterReplica) (metadata.ClusterBackupMetadata, error) {
+	plan := metadata.ClusterBackupMetadata{
+		BackupName: backupName,
+		Cluster:    cluster,
+	}
+	if len(replicas) == 0 {
+		return plan, fmt.Errorf("cluster %s not found in system.clusters", cluster)
+	}
+	shards := map[uint32][]clusterReplica{}
+	var shardNums []uint32
+	for _, r := range replicas {
+		if _, exists := shards[r.ShardNum]; !exists {
+			shardNums = append(shardNums, r.ShardNum)
+		}
+		shards[r.ShardNum] = append(shards[r.ShardNum], r)
+	}
+	sort.Slice(shardNums, func(i, j int) bool { return shardNums[i] < shardNums[j] })
+	for _, shardNum := range shardNums {
+		shardReplicas := shards[shardNum]
+		sort.Slice(shardReplicas, func(i, j int) bool { return shardReplicas[i].ReplicaNum < shardReplicas[j].ReplicaNum })
+		var activeHosts, allHosts []string
+		for _, r := range shardReplicas {
+			allHosts = append(allHosts, r.HostName)
+			if r.ErrorsCount == 0 {
+				activeHosts = append(activeHosts, r.HostName)
+			}
+		}
+		if len(activeHosts) == 0 {
+			activeHosts = allHosts
+		}
+		sort.Strings(activeHosts)
+		shard := metadata.ClusterShardMetadata{ShardNum: shardNum}
+		dataAssigned := false
+		for _, r := range shardReplicas {
+			isData, err := firstReplicaShardFunc(&tableReplicaMetadata{
+				Database:       cluster,
+				Table:          fmt.Sprintf("shard%d", shardNum),
+				ReplicaName:    r.HostName,
+				ActiveReplicas: activeHosts,
+			})
+			if err != nil {
+				return plan, err
+			}
+			// the same host could be present twice in one shard with different ports
+			isData = isData && !dataAssigned
+			dataAssigned = dataAssigned || isData
+			shard.Replicas = append(shard.Replicas, metadata.ClusterReplicaMetadata{
+				ReplicaNum: r.ReplicaNum,
+				Host:       r.HostName,
+				BackupName: fmt.Sprintf("%s_shard%d_replica%d", backupName, shardNum, r.ReplicaNum),
+				Data:       isData,
+			})
+		}
+		plan.Shards = append(plan.Shards, shard)
+	}
+	return plan, nil
+}
+
+// getClusterCreateCommand - create_remote command for each replica, --if-not-exists allow run `create_cluster` again with the same name after partial failure
+func getClusterCreateCommand(replica metadata.ClusterReplicaMetadata, tablePattern string, backupRBAC, backupConfigs bool) string {
+	args := []string{"create_remote", "--if-not-exists"}
+	if !replica.Data {
+		args = append(args, "--schema")
+	}
+	if tablePattern != "" {
+		args = append(args, fmt.Sprintf("--tables=%q", tablePattern))
+	}
+	if backupRBAC {
+		args = append(args, "--rbac")
+	}
+	if backupConfigs {
+		args = append(args, "--configs")
+	}
+	return strings.Join(append(args, replica.BackupName), " ")
+}
+
+// CreateCluster - `create_cluster`, run create_remote via API on each replica of cluster from system.clusters, wait for completion and upload cluster level manifest
+func (b *Backuper) CreateCluster(backupName, cluster, tablePattern string, backupRBAC, backupConfigs bool, version string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startCluster := time.Now()
+	if backupName == "" {
+		backupName = NewBackupName()
+	}
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "create_cluster",
+	})
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("create_cluster doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if cluster == "" {
+		return fmt.Errorf("create_cluster require --cluster")
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if cluster, err = b.ch.ApplyMacros(ctx, cluster); err != nil {
+		return err
+	}
+	var replicas []clusterReplica
+	if err = b.ch.SelectContext(ctx, &replicas, "SELECT shard_num, replica_num, host_name, errors_count FROM system.clusters WHERE cluster=?", cluster); err != nil {
+		return fmt.Errorf("can't get cluster %s from system.clusters: %v", cluster, err)
+	}
+	plan, err := getClusterBackupPlan(backupName, cluster, replicas)
+	if err != nil {
+		return err
+	}
+	client, err := b.newClusterHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+	for i := range plan.Shards {
+		for j := range plan.Shards[i].Replicas {
+			replica := &plan.Shards[i].Replicas[j]
+			command := getClusterCreateCommand(*replica, tablePattern, backupRBAC, backupConfigs)
+			replicaLog := log.WithFields(apexLog.Fields{"shard": plan.Shards[i].ShardNum, "replica": replica.Host, "data": replica.Data})
+			g.Go(func() error {
+				baseURL, err := b.getClusterAPIBaseURL(replica.Host)
+				if err != nil {
+					return err
+				}
+				operationId, replicaErr := b.runClusterAction(gCtx, client, baseURL, command, replica.BackupName, replicaLog)
+				replica.OperationId = operationId
+				if replicaErr != nil {
+					replica.Status = status.ErrorStatus
+					replica.Error = replicaErr.Error()
+					return fmt.Errorf("%s on %s: %v", command, replica.Host, replicaErr)
+				}
+				replica.Status = status.SuccessStatus
+				return nil
+			})
+		}
+	}
+	if err = g.Wait(); err != nil {
+		return err
+	}
+
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	plan.ClickhouseBackupVersion = version
+	plan.CreationDate = time.Now().UTC()
+	if err = bd.PutClusterManifest(ctx, plan); err != nil {
+		return fmt.Errorf("can't upload %s%s: %v", backupName, storage.ClusterManifestSuffix, err)
+	}
+	log.WithFields(apexLog.Fields{
+		"cluster":  cluster,
+		"shards":   len(plan.Shards),
+		"duration": utils.HumanizeDuration(time.Since(startCluster)),
+	}).Info("done")
+	return nil
+}
+
+// getClusterAPIBaseURL - clickhouse-backup API on each replica shall listen the same port as api->listen and use the same credentials and TLS settings
+func (b *Backuper) getClusterAPIBaseURL(host string) (string, error) {
+	_, apiPort, err := net.SplitHostPort(b.cfg.API.ListenAddr)
+	if err != nil {
+		return "", fmt.Errorf("can't get port from api->listen %s: %v", b.cfg.API.ListenAddr, err)
+	}
+	scheme := "http"
+	if b.cfg.API.Secure {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, apiPort)), nil
+}
+
+// newClusterHTTPClient - when api->secure, trust api->ca_cert_file and present api->certificate_file as client certificate, cause replicas could require it with `client_cert_auth: require`
+func (b *Backuper) newClusterHTTPClient() (*http.Client, error) {
+	client := &http.Client{Timeout: time.Minute}
+	if !b.cfg.API.Secure {
+		return client, nil
+	}
+	tlsConfig := &tls.Config{}
+	if b.cfg.API.CACertFile != "" {
+		caCert, err := os.ReadFile(b.cfg.API.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read api->ca_cert_file: %v", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("can't parse api->ca_cert_file %s", b.cfg.API.CACertFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+	if b.cfg.API.CertificateFile != "" && b.cfg.API.PrivateKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(b.cfg.API.CertificateFile, b.cfg.API.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load api->certificate_file and api->private_key_file: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	return client, nil
+}
+
+// runClusterAction - POST /backup/actions and poll GET /backup/actions?filter=<filter> until command finished, return operation_id on replica
+// synchronous commands, like `delete`, return success status immediately, polling fails after api->cluster_timeout or clusterOperationMaxPollErrors consecutive errors
+func (b *Backuper) runClusterAction(ctx context.Context, client *http.Client, baseURL, command, filter string, log *apexLog.Entry) (int, error) {
+	if b.cfg.API.ClusterTimeoutDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.cfg.API.ClusterTimeoutDuration)
+		defer cancel()
+	}
+	body, err := json.Marshal(map[string]string{"command": command})
+	if err != nil {
+		return 0, err
+	}
+	rows, err := b.doClusterAPIRequest(ctx, client, http.MethodPost, baseURL+"/backup/actions", body)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("empty response from %s/backup/actions", baseURL)
+	}
+	operationId := rows[0].OperationId
+	if rows[0].Status == status.SuccessStatus {
+		log.Infof("%s finished", command)
+		return operationId, nil
+	}
+	log.Infof("%s started with operation_id=%d", command, operationId)
+	ticker := time.NewTicker(clusterOperationPollInterval)
+	defer ticker.Stop()
+	pollErrors := 0
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return operationId, fmt.Errorf("operation_id=%d not finished during api->cluster_timeout=%s", operationId, b.cfg.API.ClusterTimeout)
+			}
+			return operationId, ctx.Err()
+		case <-ticker.C:
+			rows, err = b.doClusterAPIRequest(ctx, client, http.MethodGet, baseURL+"/backup/actions?filter="+url.QueryEscape(filter), nil)
+			if err != nil {
+				pollErrors++
+				if pollErrors >= clusterOperationMaxPollErrors {
+					return operationId, fmt.Errorf("can't get status of operation_id=%d %d times: %v", operationId, pollErrors, err)
+				}
+				log.Warnf("can't get status of operation_id=%d: %v", operationId, err)
+				continue
+			}
+			pollErrors = 0
+			for _, row := range rows {
+				if row.Id != operationId {
+					continue
+				}
+				switch row.Status {
+				case status.SuccessStatus:
+					log.Infof("%s finished", command)
+					return operationId, nil
+				case status.ErrorStatus, status.CancelStatus:
+					return operationId, fmt.Errorf("operation_id=%d %s: %s", operationId, row.Status, row.Error)
+				}
+			}
+		}
+	}
+}
+
+func (b *Backuper) doClusterAPIRequest(ctx context.Context, client *http.Client, method, requestURL string, body []byte) ([]clusterActionRow, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.API.Username != "" {
+		req.SetBasicAuth(b.cfg.API.Username, b.cfg.API.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			b.log.Warnf("can't close %s response body: %v", requestURL, closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s %s return %s: %s", method, requestURL, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	var rows []clusterActionRow
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		row := clusterActionRow{}
+		if err = json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, fmt.Errorf("can't parse %s response: %v", requestURL, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetClusterBackupPlan(t *testing.T) {
+	plan, err := getClusterBackupPlan("b", "c", []clusterReplica{
+		{ShardNum: 2, ReplicaNum: 1, HostName: "s2r1", ErrorsCount: 3},
+		{ShardNum: 2, ReplicaNum: 2, HostName: "s2r2"},
+		{ShardNum: 1, ReplicaNum: 2, HostName: "s1r2"},
+		{ShardNum: 1, ReplicaNum: 1, HostName: "s1r1"},
+		{ShardNum: 3, ReplicaNum: 1, HostName: "s3", ErrorsCount: 1},
+		{ShardNum: 3, ReplicaNum: 2, HostName: "s3", ErrorsCount: 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []metadata.ClusterShardMetadata{
+		{ShardNum: 1, Replicas: []metadata.ClusterReplicaMetadata{
+			{ReplicaNum: 1, Host: "s1r1", BackupName: "b_shard1_replica1", Data: true},
+			{ReplicaNum: 2, Host: "s1r2", BackupName: "b_shard1_replica2"},
+		}},
+		{ShardNum: 2, Replicas: []metadata.ClusterReplicaMetadata{
+			{ReplicaNum: 1, Host: "s2r1", BackupName: "b_shard2_replica1"},
+			{ReplicaNum: 2, Host: "s2r2", BackupName: "b_shard2_replica2", Data: true},
+		}},
+		{ShardNum: 3, Replicas: []metadata.ClusterReplicaMetadata{
+			{ReplicaNum: 1, Host: "s3", BackupName: "b_shard3_replica1", Data: true},
+			{ReplicaNum: 2, Host: "s3", BackupName: "b_shard3_replica2"},
+		}},
+	}, plan.Shards)
+
+	_, err = getClusterBackupPlan("b", "unknown", nil)
+	assert.Error(t, err)
+}
+
+func TestGetClusterCreateCommand(t *testing.T) {
+	assert.Equal(t, "create_remote --if-not-exists b_shard1_replica1", getClusterCreateCommand(metadata.ClusterReplicaMetadata{BackupName: "b_shard1_replica1", Data: true}, "", false, false))
+	assert.Equal(t, `create_remote --if-not-exists --schema --tables="db.*" --rbac --configs b_shard1_replica2`, getClusterCreateCommand(metadata.ClusterReplicaMetadata{BackupName: "b_shard1_replica2"}, "db.*", true, true))
+}
+
+func TestRunClusterActionStopPolling(t *testing.T) {
+	pollInterval, maxPollErrors := clusterOperationPollInterval, clusterOperationMaxPollErrors
+	clusterOperationPollInterval, clusterOperationMaxPollErrors = time.Millisecond, 3
+	defer func() {
+		clusterOperationPollInterval, clusterOperationMaxPollErrors = pollInterval, maxPollErrors
+	}()
+	log := apexLog.WithField("logger", "test")
+	polls := 0
+	failPoll := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"id":1,"operation_id":1,"command":"create_remote b","status":"in progress"}` + "\n"))
+			return
+		}
+		polls++
+		if failPoll {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":1,"operation_id":1,"command":"create_remote b","status":"in progress"}` + "\n"))
+	}))
+	defer server.Close()
+
+	b := &Backuper{cfg: config.DefaultConfig(), log: log}
+	_, err := b.runClusterAction(context.Background(), server.Client(), server.URL, "create_remote b", "b", log)
+	require.ErrorContains(t, err, "3 times")
+	assert.Equal(t, 3, polls)
+
+	failPoll = false
+	b.cfg.API.ClusterTimeout = "50ms"
+	b.cfg.API.ClusterTimeoutDuration = 50 * time.Millisecond
+	_, err = b.runClusterAction(context.Background(), server.Client(), server.URL, "create_remote b", "b", log)
+	require.ErrorContains(t, err, "api->cluster_timeout=50ms")
+}
+
+func TestNewClusterHTTPClientTrustAPICA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	b := &Backuper{cfg: config.DefaultConfig()}
+	b.cfg.API.Secure = true
+	client, err := b.newClusterHTTPClient()
+	require.NoError(t, err)
+	_, err = client.Get(server.URL)
+	require.Error(t, err)
+
+	b.cfg.API.CACertFile = caFile
+	client, err = b.newClusterHTTPClient()
+	require.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+}
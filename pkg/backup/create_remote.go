@@ -3,9 +3,10 @@ package backup
 import (
 	"context"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	apexLog "github.com/apex/log"
 )
 
-func (b *Backuper) CreateToRemote(backupName string, deleteSource bool, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, resume bool, version string, commandId int) error {
+func (b *Backuper) CreateToRemote(backupName string, deleteSource bool, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, resume bool, destinations []string, parallelDestinations bool, version string, commandId int) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -15,10 +16,31 @@ func (b *Backuper) CreateToRemote(backupName string, deleteSource bool, diffFrom
 	if backupName == "" {
 		backupName = NewBackupName()
 	}
+	ctx, releaseKeeperLock, err := b.lockKeeper(ctx, "create_remote", backupName, schemaOnly)
+	if err != nil {
+		return err
+	}
+	defer releaseKeeperLock()
+	if b.ifNotExists {
+		if location, existsErr := b.getExistingRemoteBackupLocation(ctx, backupName); existsErr != nil {
+			return existsErr
+		} else if location != "" {
+			apexLog.WithField("backup", backupName).Infof("'%s' already exists on remote, skip create_remote", backupName)
+			return nil
+		}
+	}
+	// local backup created with --diff-from-remote already contains required_backup, so general->incremental_max_base_age shall be checked before create
+	if (diffFrom != "" || diffFromRemote != "") && b.cfg.General.IncrementalMaxBaseAgeDuration > 0 {
+		remoteBackups, err := b.GetRemoteBackups(ctx, true)
+		if err != nil {
+			return err
+		}
+		diffFrom, diffFromRemote = b.enforceIncrementalMaxBaseAge(ctx, diffFrom, diffFromRemote, remoteBackups, apexLog.WithField("backup", backupName))
+	}
 	if err := b.CreateBackup(backupName, diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, version, commandId); err != nil {
 		return err
 	}
-	if err := b.Upload(backupName, deleteSource, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
+	if err := b.UploadToDestinations(destinations, parallelDestinations, backupName, deleteSource, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
 		return err
 	}
 
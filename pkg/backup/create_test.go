@@ -0,0 +1,27 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsValidBackupExists(t *testing.T) {
+	localBackups := []LocalBackup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "valid"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "broken"}, Broken: "broken metadata.json not found"},
+	}
+	assert.True(t, isValidLocalBackupExists(localBackups, "valid"))
+	assert.False(t, isValidLocalBackupExists(localBackups, "broken"), "--if-not-exists shall create backup again instead of broken")
+	assert.False(t, isValidLocalBackupExists(localBackups, "absent"))
+
+	remoteBackups := []storage.Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "valid"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "broken"}, Broken: "broken (can't stat metadata.json)"},
+	}
+	assert.True(t, isValidRemoteBackupExists(remoteBackups, "valid"))
+	assert.False(t, isValidRemoteBackupExists(remoteBackups, "broken"), "--if-not-exists shall create backup again instead of broken")
+	assert.False(t, isValidRemoteBackupExists(remoteBackups, "absent"))
+}
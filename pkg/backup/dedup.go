@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+)
+
+// dedupCandidate - file inside part, which size is the same as size of other files in the same partition
+type dedupCandidate struct {
+	partIdx      int
+	relativePath string
+}
+
+// markDeduplicatedFiles - files with the same sha256 inside parts of one partition are uploaded only with the first part, look general->upload_dedup_files
+// parts of other partitions are not used as source, cause `download --partitions` could skip them, files on object disks contain only object references and are skipped
+// return count and size of files which will not upload
+func (b *Backuper) markDeduplicatedFiles(ctx context.Context, backupName string, table *metadata.TableMetadata, diskTypes map[string]string) (int, int64, error) {
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	deduplicatedFiles := 0
+	deduplicatedBytes := int64(0)
+	for disk, parts := range table.Parts {
+		if b.isDiskTypeObject(diskTypes[disk]) || len(parts) < 2 {
+			continue
+		}
+		partsPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+		// partition id and size -> candidates, only files with the same size are compared by sha256
+		candidates := map[string][]dedupCandidate{}
+		candidatesOrder := make([]string, 0)
+		for i := range parts {
+			if parts[i].Required {
+				continue
+			}
+			partPath := path.Join(partsPath, parts[i].Name)
+			partitionId := strings.Split(parts[i].Name, "_")[0]
+			walkErr := filepath.Walk(partPath, func(filePath string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if !info.Mode().IsRegular() || info.Size() == 0 || isRequiredFile(partPath, filePath, parts[i]) {
+					return nil
+				}
+				key := fmt.Sprintf("%s/%d", partitionId, info.Size())
+				if _, exists := candidates[key]; !exists {
+					candidatesOrder = append(candidatesOrder, key)
+				}
+				candidates[key] = append(candidates[key], dedupCandidate{partIdx: i, relativePath: strings.TrimPrefix(strings.TrimPrefix(filePath, partPath), "/")})
+				return nil
+			})
+			if walkErr != nil {
+				return 0, 0, fmt.Errorf("can't walk %s: %v", partPath, walkErr)
+			}
+		}
+		for _, key := range candidatesOrder {
+			if len(candidates[key]) < 2 {
+				continue
+			}
+			// sha256 -> "<part>/<file>" of the first uploaded copy
+			sources := map[string]string{}
+			for _, c := range candidates[key] {
+				part := &parts[c.partIdx]
+				filePath := path.Join(partsPath, part.Name, c.relativePath)
+				checksum, err := sha256File(filePath)
+				if err != nil {
+					return 0, 0, fmt.Errorf("can't calculate checksum for %s: %v", filePath, err)
+				}
+				source, exists := sources[checksum]
+				if !exists {
+					sources[checksum] = path.Join(part.Name, c.relativePath)
+					continue
+				}
+				if part.DuplicatedFiles == nil {
+					part.DuplicatedFiles = map[string]string{}
+				}
+				part.DuplicatedFiles[c.relativePath] = source
+				deduplicatedFiles++
+				if info, err := os.Stat(filePath); err == nil {
+					deduplicatedBytes += info.Size()
+				}
+			}
+		}
+	}
+	return deduplicatedFiles, deduplicatedBytes, nil
+}
+
+// isDuplicatedFile - file will link from other part after download, look general->upload_dedup_files
+func isDuplicatedFile(partPath, filePath string, part metadata.Part) bool {
+	if len(part.DuplicatedFiles) == 0 {
+		return false
+	}
+	_, exists := part.DuplicatedFiles[strings.TrimPrefix(strings.TrimPrefix(filePath, partPath), "/")]
+	return exists
+}
+
+// hasDuplicatedFiles - parts of backup uploaded with general->upload_dedup_files don't contain all files in remote storage
+func hasDuplicatedFiles(table *metadata.TableMetadata) bool {
+	for _, parts := range table.Parts {
+		for _, part := range parts {
+			if len(part.DuplicatedFiles) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// linkDeduplicatedFiles - restore files which weren't uploaded with general->upload_dedup_files, source part could be re-balanced to other disk, then file is copied
+func (b *Backuper) linkDeduplicatedFiles(ctx context.Context, backupName string, table metadata.TableMetadata, dbAndTableDir string) error {
+	for disk, parts := range table.Parts {
+		partPaths := map[string]string{}
+		for _, part := range parts {
+			partDisk := disk
+			if _, diskExists := b.DiskToPathMap[disk]; !diskExists && part.RebalancedDisk != "" {
+				partDisk = part.RebalancedDisk
+			}
+			partPaths[part.Name] = path.Join(b.getLocalBackupDataPathForTable(backupName, partDisk, dbAndTableDir), part.Name)
+		}
+		for _, part := range parts {
+			for file, source := range part.DuplicatedFiles {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				sourceFields := strings.SplitN(source, "/", 2)
+				sourcePartPath, exists := partPaths[sourceFields[0]]
+				if !exists || len(sourceFields) != 2 {
+					return fmt.Errorf("`%s`.`%s` part %s duplicated file %s source %s not found", table.Database, table.Table, part.Name, file, source)
+				}
+				if err := linkOrCopyFile(path.Join(sourcePartPath, sourceFields[1]), path.Join(partPaths[part.Name], file)); err != nil {
+					return fmt.Errorf("`%s`.`%s` part %s can't restore duplicated file %s: %v", table.Database, table.Table, part.Name, file, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func linkOrCopyFile(existsF, newF string) error {
+	if err := os.MkdirAll(path.Dir(newF), 0750); err != nil {
+		return err
+	}
+	if err := os.Link(existsF, newF); err == nil || os.IsExist(err) {
+		return nil
+	}
+	src, err := os.Open(existsF)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	dst, err := os.OpenFile(newF, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
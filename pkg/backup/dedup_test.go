@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicatedFiles(t *testing.T) {
+	ctx := context.Background()
+	diskPath := t.TempDir()
+	partsPath := path.Join(diskPath, "backup", "backup1", "shadow", "db", "t1", "default")
+	files := map[string]string{
+		"202401_1_1_0/columns.txt": "columns",
+		"202401_1_1_0/count.txt":   "10",
+		"202401_1_1_0/data.bin":    "data1",
+		"202401_2_2_0/columns.txt": "columns",
+		"202401_2_2_0/count.txt":   "20",
+		"202401_2_2_0/data.bin":    "data1",
+		"202402_3_3_0/columns.txt": "columns",
+	}
+	for file, content := range files {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(partsPath, file)), 0750))
+		require.NoError(t, os.WriteFile(path.Join(partsPath, file), []byte(content), 0640))
+	}
+	b := &Backuper{DiskToPathMap: map[string]string{"default": diskPath}}
+	table := metadata.TableMetadata{
+		Database: "db",
+		Table:    "t1",
+		Parts:    map[string][]metadata.Part{"default": {{Name: "202401_1_1_0"}, {Name: "202401_2_2_0"}, {Name: "202402_3_3_0"}}},
+	}
+	count, size, err := b.markDeduplicatedFiles(ctx, "backup1", &table, map[string]string{"default": "local"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(len("columns")+len("data1")), size)
+	parts := table.Parts["default"]
+	assert.Empty(t, parts[0].DuplicatedFiles)
+	assert.Equal(t, map[string]string{"columns.txt": "202401_1_1_0/columns.txt", "data.bin": "202401_1_1_0/data.bin"}, parts[1].DuplicatedFiles)
+	// other partition could be skipped by `download --partitions`
+	assert.Empty(t, parts[2].DuplicatedFiles)
+	assert.True(t, isDuplicatedFile(path.Join(partsPath, "202401_2_2_0"), path.Join(partsPath, "202401_2_2_0", "data.bin"), parts[1]))
+	assert.False(t, isDuplicatedFile(path.Join(partsPath, "202401_2_2_0"), path.Join(partsPath, "202401_2_2_0", "count.txt"), parts[1]))
+	assert.True(t, hasDuplicatedFiles(&table))
+
+	// duplicated files are not uploaded, download restores them
+	require.NoError(t, os.Remove(path.Join(partsPath, "202401_2_2_0", "columns.txt")))
+	require.NoError(t, os.Remove(path.Join(partsPath, "202401_2_2_0", "data.bin")))
+	require.NoError(t, b.linkDeduplicatedFiles(ctx, "backup1", table, path.Join("db", "t1")))
+	for file, content := range files {
+		actual, err := os.ReadFile(path.Join(partsPath, file))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(actual))
+	}
+
+	table.Parts["default"] = parts[1:]
+	assert.ErrorContains(t, b.linkDeduplicatedFiles(ctx, "backup1", table, path.Join("db", "t1")), "not found")
+}
@@ -28,6 +28,11 @@ func (b *Backuper) Clean(ctx context.Context) error {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer b.ch.Close()
+	release, err := b.lockOperation("clean", "")
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	disks, err := b.ch.GetDisks(ctx, true)
 	if err != nil {
@@ -71,10 +76,17 @@ func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
+	b.setLogComment("delete", backupName, commandId)
 	switch backupType {
 	case "local":
+		if b.dryRun != nil {
+			return b.planDeleteLocal(ctx, backupName)
+		}
 		return b.RemoveBackupLocal(ctx, backupName, nil)
 	case "remote":
+		if b.dryRun != nil {
+			return b.planDeleteRemote(ctx, backupName)
+		}
 		return b.RemoveBackupRemote(ctx, backupName)
 	default:
 		return fmt.Errorf("unknown backup type")
@@ -82,9 +94,25 @@ func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
 }
 
 func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup bool, disks []clickhouse.Disk) error {
+	backupsToDelete, disks, err := b.getOldBackupsLocal(ctx, keepLastBackup, disks)
+	if err != nil {
+		return err
+	}
+	for _, backup := range backupsToDelete {
+		deleteErr := b.removeBackupLocal(ctx, backup.BackupName, disks)
+		b.writeAuditRecord(ctx, "retention_local", []string{backup.BackupName}, deleteErr)
+		if deleteErr != nil {
+			return deleteErr
+		}
+	}
+	return nil
+}
+
+// getOldBackupsLocal - local backups which exceed general->backups_to_keep_local, plannedBackups are not created yet, used for --dry-run
+func (b *Backuper) getOldBackupsLocal(ctx context.Context, keepLastBackup bool, disks []clickhouse.Disk, plannedBackups ...LocalBackup) ([]LocalBackup, []clickhouse.Disk, error) {
 	keep := b.cfg.General.BackupsToKeepLocal
 	if keep == 0 {
-		return nil
+		return nil, disks, nil
 	}
 	// fix https://github.com/Altinity/clickhouse-backup/issues/698
 	if keep < 0 {
@@ -96,18 +124,19 @@ func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup boo
 
 	backupList, disks, err := b.GetLocalBackups(ctx, disks)
 	if err != nil {
-		return err
-	}
-	backupsToDelete := GetBackupsToDeleteLocal(backupList, keep)
-	for _, backup := range backupsToDelete {
-		if deleteErr := b.RemoveBackupLocal(ctx, backup.BackupName, disks); deleteErr != nil {
-			return deleteErr
-		}
+		return nil, nil, err
 	}
-	return nil
+	backupList = append(backupList, plannedBackups...)
+	return GetBackupsToDeleteLocal(backupList, keep), disks, nil
 }
 
 func (b *Backuper) RemoveBackupLocal(ctx context.Context, backupName string, disks []clickhouse.Disk) error {
+	err := b.removeBackupLocal(ctx, backupName, disks)
+	b.writeAuditRecord(ctx, "delete_local", []string{backupName}, err)
+	return err
+}
+
+func (b *Backuper) removeBackupLocal(ctx context.Context, backupName string, disks []clickhouse.Disk) error {
 	log := b.log.WithField("logger", "RemoveBackupLocal")
 	var err error
 	start := time.Now()
@@ -132,7 +161,7 @@ func (b *Backuper) RemoveBackupLocal(ctx context.Context, backupName string, dis
 		if backup.BackupName == backupName {
 			b.isEmbedded = strings.Contains(backup.Tags, "embedded")
 			if hasObjectDisks || (b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk == "") {
-				bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName)
+				bd, err := b.newBackupDestination(ctx, false, backupName)
 				if err != nil {
 					return err
 				}
@@ -178,6 +207,11 @@ func (b *Backuper) cleanEmbeddedAndObjectDiskLocalIfSameRemoteNotPresent(ctx con
 	if err != nil {
 		return err
 	}
+	if !skip && len(backup.CloudSnapshots) > 0 {
+		if err = b.deleteCloudSnapshots(ctx, backup.CloudSnapshots, log); err != nil {
+			return err
+		}
+	}
 	if !skip && (hasObjectDisks || (b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk == "")) {
 		if deletedKeys, deleteErr := b.cleanBackupObjectDisks(ctx, backupName); deleteErr != nil {
 			log.Warnf("b.cleanBackupObjectDisks return error: %v", deleteErr)
@@ -223,7 +257,7 @@ func (b *Backuper) cleanLocalEmbedded(ctx context.Context, backup LocalBackup, d
 				if err != nil {
 					return err
 				}
-				if !info.IsDir() && !strings.HasSuffix(filePath, ".json") && !strings.HasPrefix(filePath, path.Join(backupPath, "access")) {
+				if !info.IsDir() && !strings.HasSuffix(filePath, ".json") && !strings.HasPrefix(filePath, path.Join(backupPath, "access")) && !strings.HasPrefix(filePath, path.Join(backupPath, "keeper")) {
 					apexLog.Debugf("object_disk.ReadMetadataFromFile(%s)", filePath)
 					meta, err := object_disk.ReadMetadataFromFile(filePath)
 					if err != nil {
@@ -263,6 +297,12 @@ func (b *Backuper) skipIfTheSameRemoteBackupPresent(ctx context.Context, backupN
 }
 
 func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) error {
+	err := b.removeBackupRemote(ctx, backupName)
+	b.writeAuditRecord(ctx, "delete_remote", []string{backupName}, err)
+	return err
+}
+
+func (b *Backuper) removeBackupRemote(ctx context.Context, backupName string) error {
 	log := b.log.WithField("logger", "RemoveBackupRemote")
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
 	start := time.Now()
@@ -279,7 +319,7 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 	}
 	defer b.ch.Close()
 
-	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	bd, err := b.newBackupDestination(ctx, false, "")
 	if err != nil {
 		return err
 	}
@@ -301,6 +341,10 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 	}
 	for _, backup := range backupList {
 		if backup.BackupName == backupName {
+			if err = bd.CheckBackupLock(ctx, backupName); err != nil {
+				log.Error(err.Error())
+				return err
+			}
 			err = b.cleanEmbeddedAndObjectDiskRemoteIfSameLocalNotPresent(ctx, backup, log)
 			if err != nil {
 				return err
@@ -310,6 +354,11 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 				log.Warnf("bd.RemoveBackup return error: %v", err)
 				return err
 			}
+			if b.forceGC {
+				if err = b.forceObjectDiskGC(ctx, log); err != nil {
+					return err
+				}
+			}
 			log.WithFields(apexLog.Fields{
 				"backup":    backupName,
 				"location":  "remote",
@@ -329,6 +378,11 @@ func (b *Backuper) cleanEmbeddedAndObjectDiskRemoteIfSameLocalNotPresent(ctx con
 		return err
 	}
 	if !skip {
+		if len(backup.CloudSnapshots) > 0 {
+			if err = b.deleteCloudSnapshots(ctx, backup.CloudSnapshots, log); err != nil {
+				return err
+			}
+		}
 		if b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
 			if err = b.cleanRemoteEmbedded(ctx, backup); err != nil {
 				log.Warnf("b.cleanRemoteEmbedded return error: %v", err)
@@ -336,7 +390,10 @@ func (b *Backuper) cleanEmbeddedAndObjectDiskRemoteIfSameLocalNotPresent(ctx con
 			}
 			return nil
 		}
-		if b.hasObjectDisksRemote(backup) || (b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk == "") {
+		if b.hasObjectDisksRemote(backup) && !b.isEmbedded {
+			return b.cleanObjectDisksIfNotReferenced(ctx, backup, log)
+		}
+		if b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk == "" {
 			if deletedKeys, deleteErr := b.cleanBackupObjectDisks(ctx, backup.BackupName); deleteErr != nil {
 				log.Warnf("b.cleanBackupObjectDisks return error: %v", deleteErr)
 			} else {
@@ -0,0 +1,307 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// newDestinationBackuper - Backuper for one of general->remote_destinations, empty name and `primary` means current config
+func (b *Backuper) newDestinationBackuper(name string) (*Backuper, error) {
+	destinationCfg, err := b.cfg.LoadDestinationConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	destinationBackuper := NewBackuper(destinationCfg, WithDryRun(b.dryRun), WithOnlyFailed(b.onlyFailed), WithResumeRemote(b.resumeRemote))
+	destinationBackuper.keeperLock = b.keeperLock
+	if name != config.PrimaryDestination {
+		destinationBackuper.destination = name
+	}
+	destinationBackuper.log = b.log.WithField("destination", name)
+	return destinationBackuper, nil
+}
+
+// resumableCommand - each destination shall have separate resumable state
+func (b *Backuper) resumableCommand(command string) string {
+	if b.destination == "" {
+		return command
+	}
+	return command + "@" + b.destination
+}
+
+// splitDestinations - allow both --destinations=primary,dr and --destinations=primary --destinations=dr
+func splitDestinations(destinations []string) []string {
+	result := make([]string, 0, len(destinations))
+	for _, arg := range destinations {
+		for _, name := range strings.Split(arg, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
+
+// UploadToDestinations - upload the same local backup to several remote destinations, sequentially or in parallel, failed destination doesn't stop others
+func (b *Backuper) UploadToDestinations(destinations []string, parallel bool, backupName string, deleteSource bool, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) error {
+	destinations = splitDestinations(destinations)
+	if len(destinations) == 0 {
+		return b.Upload(backupName, deleteSource, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId)
+	}
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	ctx, releaseKeeperLock, err := b.lockKeeper(ctx, "upload", backupName, schemaOnly)
+	if err != nil {
+		return err
+	}
+	defer releaseKeeperLock()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "upload_to_destinations",
+	})
+	statuses := make([]metadata.DestinationStatus, len(destinations))
+	uploadErrors := make([]error, len(destinations))
+	uploadGroup := errgroup.Group{}
+	// dry run plans of destinations shall not interleave
+	if !parallel || b.dryRun != nil {
+		uploadGroup.SetLimit(1)
+	}
+	for i, name := range destinations {
+		uploadGroup.Go(func() error {
+			statuses[i] = metadata.DestinationStatus{Name: name, Status: status.SuccessStatus}
+			destinationBackuper, err := b.newDestinationBackuper(name)
+			if err == nil {
+				statuses[i].RemoteStorage = destinationBackuper.cfg.General.RemoteStorage
+				if b.dryRun != nil {
+					_, _ = fmt.Fprintf(b.dryRun, "destination %s\n", name)
+				}
+				err = destinationBackuper.Upload(backupName, false, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId)
+			}
+			statuses[i].UploadDate = time.Now().UTC()
+			if err != nil {
+				statuses[i].Status = status.ErrorStatus
+				statuses[i].Error = err.Error()
+				uploadErrors[i] = fmt.Errorf("destination %s: %v", name, err)
+				log.WithField("destination", name).Errorf("upload failed: %v", err)
+			}
+			return nil
+		})
+	}
+	_ = uploadGroup.Wait()
+	if b.dryRun != nil {
+		return errors.Join(uploadErrors...)
+	}
+	if err = b.saveDestinationsStatus(ctx, backupName, statuses); err != nil {
+		log.Warnf("can't save destinations status: %v", err)
+	}
+	for i, name := range destinations {
+		if uploadErrors[i] != nil {
+			continue
+		}
+		destinationBackuper, err := b.newDestinationBackuper(name)
+		if err == nil {
+			err = destinationBackuper.uploadDestinationsStatus(ctx, backupName, statuses)
+		}
+		if err != nil {
+			log.WithField("destination", name).Warnf("can't upload destinations status: %v", err)
+		}
+	}
+	if err = errors.Join(uploadErrors...); err != nil {
+		return err
+	}
+	if b.cfg.General.BackupsToKeepLocal >= 0 && deleteSource {
+		if err = b.RemoveBackupLocal(ctx, backupName, nil); err != nil {
+			return fmt.Errorf("can't explicitly delete local source backup: %v", err)
+		}
+	}
+	return nil
+}
+
+// saveDestinationsStatus - write upload result for each destination into local metadata.json
+func (b *Backuper) saveDestinationsStatus(ctx context.Context, backupName string, statuses []metadata.DestinationStatus) error {
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err := b.initDisksPaths(ctx, nil); err != nil {
+		return err
+	}
+	backupMetadata, err := b.ReadBackupMetadataLocal(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	backupMetadata.Destinations = statuses
+	backupMetadataFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json")
+	if _, err = os.Stat(backupMetadataFile); err != nil && b.EmbeddedBackupDataPath != "" {
+		backupMetadataFile = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata.json")
+	}
+	return backupMetadata.Save(backupMetadataFile)
+}
+
+// uploadDestinationsStatus - write upload result for each destination into remote metadata.json, so download could choose healthy destination
+func (b *Backuper) uploadDestinationsStatus(ctx context.Context, backupName string, statuses []metadata.DestinationStatus) error {
+	if b.cfg.General.RemoteStorage == "custom" {
+		return nil
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err := b.initDisksPathdsAndBackupDestination(ctx, nil, ""); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	return b.writeDestinationsStatusRemote(ctx, backupName, statuses)
+}
+
+// writeDestinationsStatusRemote - rewrite metadata.json of already uploaded backup on connected destination, look rewriteRemoteMetadata
+func (b *Backuper) writeDestinationsStatusRemote(ctx context.Context, backupName string, statuses []metadata.DestinationStatus) error {
+	backupList, err := b.dst.BackupList(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	for _, backup := range backupList {
+		if backup.BackupName == backupName {
+			return b.rewriteRemoteMetadata(ctx, backup, func(backupMetadata *metadata.BackupMetadata) error {
+				backupMetadata.Destinations = statuses
+				return nil
+			})
+		}
+	}
+	return fmt.Errorf("%s not found on remote storage", backupName)
+}
+
+// readDestinationsStatus - upload result of each destination from remote metadata.json of first available destination
+func (b *Backuper) readDestinationsStatus(ctx context.Context, destinations []string, backupName string) []metadata.DestinationStatus {
+	for _, name := range destinations {
+		destinationBackuper, err := b.newDestinationBackuper(name)
+		if err != nil || destinationBackuper.cfg.General.RemoteStorage == "custom" {
+			continue
+		}
+		statuses, err := destinationBackuper.readDestinationsStatusRemote(ctx, backupName)
+		if err != nil {
+			b.log.WithField("destination", name).Debugf("can't read destinations status: %v", err)
+			continue
+		}
+		if len(statuses) > 0 {
+			return statuses
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) readDestinationsStatusRemote(ctx context.Context, backupName string) ([]metadata.DestinationStatus, error) {
+	if err := b.ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err := b.initDisksPathdsAndBackupDestination(ctx, nil, ""); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupMetadata, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return nil, err
+	}
+	return backupMetadata.Destinations, nil
+}
+
+// sortDestinationsByStatus - destinations with successful upload first, then destinations without status, failed destinations last, listed order is kept inside each group
+func sortDestinationsByStatus(destinations []string, statuses []metadata.DestinationStatus) []string {
+	priority := func(name string) int {
+		for _, destinationStatus := range statuses {
+			if destinationStatus.Name != name {
+				continue
+			}
+			if destinationStatus.Status == status.SuccessStatus {
+				return 0
+			}
+			return 2
+		}
+		return 1
+	}
+	sorted := append([]string{}, destinations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return priority(sorted[i]) < priority(sorted[j])
+	})
+	return sorted
+}
+
+// removeNewLocalBackups - remove partially downloaded backups which didn't exist before download, otherwise next destination returns ErrBackupIsAlreadyExists
+func (b *Backuper) removeNewLocalBackups(ctx context.Context, existingBackups map[string]bool) error {
+	localBackups, _, err := b.GetLocalBackups(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, localBackup := range localBackups {
+		if existingBackups[localBackup.BackupName] {
+			continue
+		}
+		if err = b.RemoveBackupLocal(ctx, localBackup.BackupName, nil); err != nil {
+			return fmt.Errorf("can't remove partially downloaded %s: %v", localBackup.BackupName, err)
+		}
+	}
+	return nil
+}
+
+// DownloadFromDestinations - download backup from first healthy destination, destinations with successful upload in remote metadata.json are tried first, in the listed order
+// partially downloaded backups are removed before next destination, unless download is resumable
+func (b *Backuper) DownloadFromDestinations(destinations []string, backupName string, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) error {
+	destinations = splitDestinations(destinations)
+	if len(destinations) == 0 {
+		return b.Download(backupName, tablePattern, partitions, schemaOnly, resume, commandId)
+	}
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	localBackups, _, err := b.GetLocalBackups(ctx, nil)
+	if err != nil {
+		return err
+	}
+	existingBackups := make(map[string]bool, len(localBackups))
+	for _, localBackup := range localBackups {
+		existingBackups[localBackup.BackupName] = true
+	}
+	var downloadErrors []error
+	for _, name := range sortDestinationsByStatus(destinations, b.readDestinationsStatus(ctx, destinations, backupName)) {
+		destinationBackuper, err := b.newDestinationBackuper(name)
+		if err == nil {
+			err = destinationBackuper.Download(backupName, tablePattern, partitions, schemaOnly, resume, commandId)
+		}
+		if err == nil || errors.Is(err, ErrBackupIsAlreadyExists) {
+			return err
+		}
+		b.log.WithField("destination", name).Warnf("download failed, will try next destination: %v", err)
+		downloadErrors = append(downloadErrors, fmt.Errorf("destination %s: %v", name, err))
+		if !resume && !b.cfg.General.UseResumableState {
+			if removeErr := b.removeNewLocalBackups(ctx, existingBackups); removeErr != nil {
+				return errors.Join(append(downloadErrors, removeErr)...)
+			}
+		}
+	}
+	return errors.Join(downloadErrors...)
+}
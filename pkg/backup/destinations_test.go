@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortDestinationsByStatus(t *testing.T) {
+	statuses := []metadata.DestinationStatus{
+		{Name: "primary", Status: status.ErrorStatus},
+		{Name: "dr", Status: status.SuccessStatus},
+	}
+	assert.Equal(t, []string{"dr", "archive", "primary"}, sortDestinationsByStatus([]string{"primary", "archive", "dr"}, statuses))
+	assert.Equal(t, []string{"primary", "dr"}, sortDestinationsByStatus([]string{"primary", "dr"}, nil))
+}
+
+func TestWriteDestinationsStatusRemote(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+	cfg.General.RetriesOnFailure = 0
+	remote := &gcTestStorage{objects: map[string][]byte{
+		"backup1/metadata.json": []byte(`{"backup_name":"backup1"}`),
+	}}
+	b := NewBackuper(cfg)
+	b.dst = storage.NewBackupDestinationFromRemoteStorage(cfg, remote, apexLog.WithField("logger", "test"))
+	statuses := []metadata.DestinationStatus{{Name: "primary", Status: status.SuccessStatus}, {Name: "dr", Status: status.ErrorStatus, Error: "unavailable"}}
+	require.NoError(t, b.writeDestinationsStatusRemote(ctx, "backup1", statuses))
+	backupMetadata := metadata.BackupMetadata{}
+	require.NoError(t, json.Unmarshal(remote.objects["backup1/metadata.json"], &backupMetadata))
+	assert.Equal(t, "backup1", backupMetadata.BackupName)
+	assert.Equal(t, statuses, backupMetadata.Destinations)
+
+	assert.ErrorContains(t, b.writeDestinationsStatusRemote(ctx, "backup2", statuses), "backup2 not found on remote storage")
+}
@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+// WithIncludeDetached - `create --include-detached`, archive parts from `detached` directory of each table into `detached_parts` section of table metadata
+func WithIncludeDetached(includeDetached bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.includeDetached = includeDetached
+	}
+}
+
+// WithRestoreDetached - `restore --detached`, place parts from `detached_parts` back into `detached` directory of restored table without ATTACH
+func WithRestoreDetached(restoreDetached bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.restoreDetached = restoreDetached
+	}
+}
+
+// isDetachedPartInProgress - ClickHouse is still writing or removing these directories, they can't be archived consistently
+func isDetachedPartInProgress(name string) bool {
+	for _, prefix := range []string{"attaching_", "deleting_", "tmp_", "tmp-fetch_"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// getDetachedPartsForBackup - part directories from `detached`, in-progress directories and names of active parts from the same disk are skipped
+func getDetachedPartsForBackup(detachedPath string, activeParts []metadata.Part, log *apexLog.Entry) ([]metadata.Part, error) {
+	entries, err := os.ReadDir(detachedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	activePartNames := make(map[string]struct{}, len(activeParts))
+	for _, part := range activeParts {
+		activePartNames[part.Name] = struct{}{}
+	}
+	var detachedParts []metadata.Part
+	for _, entry := range entries {
+		if !entry.IsDir() || isDetachedPartInProgress(entry.Name()) {
+			continue
+		}
+		if _, isActive := activePartNames[entry.Name()]; isActive {
+			log.Warnf("detached part %s has the same name as active part, skip it", entry.Name())
+			continue
+		}
+		detachedParts = append(detachedParts, metadata.Part{Name: entry.Name()})
+	}
+	return detachedParts, nil
+}
+
+// addDetachedPartsToLocalBackup - detached parts are not frozen, ClickHouse never changes them and only DROP DETACHED PART removes them,
+// so they are hardlinked into the same backup directory of disk as active parts, object disks are skipped
+func (b *Backuper) addDetachedPartsToLocalBackup(ctx context.Context, backupName string, table *clickhouse.Table, disks []clickhouse.Disk, activeParts map[string][]metadata.Part) (map[string][]metadata.Part, int64, error) {
+	if !strings.HasSuffix(table.Engine, "MergeTree") {
+		return nil, 0, nil
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "create",
+		"table":     fmt.Sprintf("%s.%s", table.Database, table.Name),
+	})
+	encodedTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Name))
+	detachedPartsMap := map[string][]metadata.Part{}
+	var size int64
+	for diskName, dataPath := range clickhouse.GetDisksByPaths(disks, table.DataPaths) {
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		default:
+		}
+		var disk clickhouse.Disk
+		for _, d := range disks {
+			if d.Name == diskName {
+				disk = d
+				break
+			}
+		}
+		if disk.Name == "" || disk.IsBackup || b.isDiskTypeObject(disk.Type) || b.isDiskTypeEncryptedObject(disk, disks) || b.isDiskTypeStatic(disk.Type) {
+			continue
+		}
+		detachedParts, err := getDetachedPartsForBackup(path.Join(dataPath, "detached"), activeParts[diskName], log)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(detachedParts) == 0 {
+			continue
+		}
+		backupShadowPath := path.Join(disk.Path, "backup", backupName, "shadow", encodedTablePath, diskName)
+		if err = filesystemhelper.MkdirAll(backupShadowPath, b.ch, disks); err != nil && !os.IsExist(err) {
+			return nil, 0, err
+		}
+		for _, part := range detachedParts {
+			partBackupPath := path.Join(backupShadowPath, part.Name)
+			if err = b.makePartHardlinks(path.Join(dataPath, "detached", part.Name), partBackupPath); err != nil {
+				return nil, 0, fmt.Errorf("can't link detached part %s from disk %s: %v", part.Name, diskName, err)
+			}
+			size += localDirSize(partBackupPath)
+		}
+		detachedPartsMap[diskName] = detachedParts
+		log.WithField("disk", diskName).Debugf("%d detached parts linked", len(detachedParts))
+	}
+	return detachedPartsMap, size, nil
+}
+
+// filterDetachedPartsFiles - remove archives of detached parts, archives created with `upload_by_part: false` contain files of several parts and are not matched
+func filterDetachedPartsFiles(files []string, disk string, detachedParts []metadata.Part) []string {
+	result := make([]string, 0, len(files))
+	for _, fileName := range files {
+		isDetached := false
+		for _, part := range detachedParts {
+			if strings.HasPrefix(fileName, disk+"_"+common.TablePathEncode(part.Name)+".") || strings.HasPrefix(fileName, partArchiveChunkPrefix(disk, part.Name)) {
+				isDetached = true
+				break
+			}
+		}
+		if !isDetached {
+			result = append(result, fileName)
+		}
+	}
+	return result
+}
+
+// restoreDetachedParts - hardlink parts from `detached_parts` into `detached` directory of restored table, ATTACH PART or DROP DETACHED PART is up to user
+func (b *Backuper) restoreDetachedParts(backupName string, table metadata.TableMetadata, diskMap map[string]string, disks []clickhouse.Disk, dstTable clickhouse.Table, log *apexLog.Entry) error {
+	detachedTable := table
+	detachedTable.Parts = table.DetachedParts
+	if err := filesystemhelper.HardlinkBackupPartsToStorage(backupName, detachedTable, disks, diskMap, dstTable.DataPaths, b.ch, true); err != nil {
+		return fmt.Errorf("can't copy detached parts of '%s.%s': %v", table.Database, table.Table, err)
+	}
+	detachedCount := 0
+	for _, parts := range table.DetachedParts {
+		detachedCount += len(parts)
+	}
+	log.Infof("%d parts placed into 'detached' without attach", detachedCount)
+	return nil
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDetachedPartsForBackup(t *testing.T) {
+	detachedPath := path.Join(t.TempDir(), "detached")
+	for _, name := range []string{"broken_all_1_1_0", "all_2_2_0", "attaching_all_3_3_0", "tmp-fetch_all_4_4_0", "ignored_all_5_5_0"} {
+		require.NoError(t, os.MkdirAll(path.Join(detachedPath, name), 0750))
+	}
+	require.NoError(t, os.WriteFile(path.Join(detachedPath, "file.txt"), []byte("not a part"), 0640))
+	log := apexLog.WithField("logger", "test")
+
+	detachedParts, err := getDetachedPartsForBackup(detachedPath, []metadata.Part{{Name: "all_2_2_0"}}, log)
+	require.NoError(t, err)
+	assert.Equal(t, []metadata.Part{{Name: "broken_all_1_1_0"}, {Name: "ignored_all_5_5_0"}}, detachedParts)
+
+	detachedParts, err = getDetachedPartsForBackup(path.Join(detachedPath, "not_exists"), nil, log)
+	require.NoError(t, err)
+	assert.Empty(t, detachedParts)
+}
+
+func TestFilterDetachedPartsFiles(t *testing.T) {
+	files := []string{"default_all_1_1_0.tar", "default_broken_all_2_2_0.tar", "default_broken_all_2_2_0%2E0001.tar", "default_broken_all_2_2_0_2.tar"}
+	assert.Equal(t,
+		[]string{"default_all_1_1_0.tar", "default_broken_all_2_2_0_2.tar"},
+		filterDetachedPartsFiles(files, "default", []metadata.Part{{Name: "broken_all_2_2_0"}}),
+	)
+}
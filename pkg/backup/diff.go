@@ -0,0 +1,434 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	apexLog "github.com/apex/log"
+)
+
+const (
+	settingDefaultValue = "<default>"
+	settingAbsentValue  = "<absent>"
+)
+
+type settingDiff struct {
+	Table        string
+	Name         string
+	BackupValue  string
+	CurrentValue string
+}
+
+type schemaDiff struct {
+	Table   string
+	Object  string
+	Change  string
+	Backup  string
+	Current string
+}
+
+// Diff - compare backup with current clickhouse-server, which will be restore target, and print differences to stdout, schema is compared when nothing selected
+func (b *Backuper) Diff(backupName, tablePattern string, diffSchema, diffSettings, remote bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "diff",
+	})
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if !diffSchema && !diffSettings {
+		diffSchema = true
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	var backupMetadata *metadata.BackupMetadata
+	if remote {
+		if err = b.initDisksPathdsAndBackupDestination(ctx, nil, backupName); err != nil {
+			return err
+		}
+		if b.dst == nil {
+			return fmt.Errorf("remote_storage: %s doesn't support diff with remote backup", b.cfg.General.RemoteStorage)
+		}
+		defer func() {
+			if closeErr := b.dst.Close(ctx); closeErr != nil {
+				log.Warnf("can't close BackupDestination error: %v", closeErr)
+			}
+		}()
+		backupMetadata, err = b.ReadBackupMetadataRemote(ctx, backupName)
+	} else {
+		if err = b.initDisksPaths(ctx, nil); err != nil {
+			return err
+		}
+		backupMetadata, err = b.ReadBackupMetadataLocal(ctx, backupName)
+	}
+	if err != nil {
+		return err
+	}
+	if diffSchema {
+		if err = b.diffSchema(ctx, backupMetadata, tablePattern, remote, log); err != nil {
+			return err
+		}
+	}
+	if diffSettings {
+		if err = b.diffSettings(ctx, backupMetadata, log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffSchema - tables, columns, table level clauses and partitions which were added, removed or changed in current clickhouse-server after backup
+func (b *Backuper) diffSchema(ctx context.Context, backupMetadata *metadata.BackupMetadata, tablePattern string, remote bool, log *apexLog.Entry) error {
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	var backupTables ListOfTables
+	var err error
+	if len(backupMetadata.Tables) > 0 {
+		if remote {
+			backupTables, err = getTableListByPatternRemote(ctx, b, backupMetadata, tablePattern, false)
+		} else {
+			metadataPath := path.Join(b.DefaultDataPath, "backup", backupMetadata.BackupName, "metadata")
+			if strings.Contains(backupMetadata.Tags, "embedded") && b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
+				metadataPath = path.Join(b.EmbeddedBackupDataPath, backupMetadata.BackupName, "metadata")
+			}
+			backupTables, _, err = b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	currentTables, err := b.ch.GetTables(ctx, tablePattern)
+	if err != nil {
+		return fmt.Errorf("can't get tables: %v", err)
+	}
+	// `create` replace hostname replica name with macros, so current queries shall be compared the same way
+	hostnameMacros, err := b.ch.GetHostnameMacros(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get hostname macros from clickhouse: %v", err)
+	}
+	for i := range currentTables {
+		currentTables[i].CreateTableQuery = replaceReplicaName(currentTables[i].CreateTableQuery, hostnameMacros)
+	}
+	var currentPartitions []struct {
+		Database   string   `ch:"database"`
+		Table      string   `ch:"table"`
+		Partitions []string `ch:"partitions"`
+	}
+	if err = b.ch.SelectContext(ctx, &currentPartitions, "SELECT database, table, groupUniqArray(partition_id) AS partitions FROM system.parts WHERE active GROUP BY database, table"); err != nil {
+		return fmt.Errorf("can't get partitions from system.parts: %v", err)
+	}
+	currentPartitionsByTable := make(map[metadata.TableTitle][]string, len(currentPartitions))
+	for _, p := range currentPartitions {
+		currentPartitionsByTable[metadata.TableTitle{Database: p.Database, Table: p.Table}] = p.Partitions
+	}
+	currentByTitle := make(map[metadata.TableTitle]clickhouse.Table, len(currentTables))
+	for _, t := range currentTables {
+		if !t.Skip {
+			currentByTitle[metadata.TableTitle{Database: t.Database, Table: t.Name}] = t
+		}
+	}
+	diffs := make([]schemaDiff, 0)
+	backupTitles := make(map[metadata.TableTitle]bool, len(backupTables))
+	for _, backupTable := range backupTables {
+		title := metadata.TableTitle{Database: backupTable.Database, Table: backupTable.Table}
+		backupTitles[title] = true
+		tableName := fmt.Sprintf("%s.%s", title.Database, title.Table)
+		currentTable, exists := currentByTitle[title]
+		if !exists {
+			diffs = append(diffs, schemaDiff{Table: tableName, Object: "table", Change: "removed", Backup: ddlEngine(backupTable.Query)})
+			continue
+		}
+		if backupTable.Query != "" && currentTable.CreateTableQuery != "" {
+			for _, diff := range diffTableSchema(backupTable.Query, currentTable.CreateTableQuery) {
+				diff.Table = tableName
+				diffs = append(diffs, diff)
+			}
+		}
+		var backupPartitions []string
+		for _, parts := range backupTable.Parts {
+			backupPartitions = append(backupPartitions, getPartitionIds(parts)...)
+		}
+		if !backupTable.MetadataOnly {
+			for _, diff := range diffPartitions(backupPartitions, currentPartitionsByTable[title]) {
+				diff.Table = tableName
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+	for title, currentTable := range currentByTitle {
+		if !backupTitles[title] {
+			diffs = append(diffs, schemaDiff{Table: fmt.Sprintf("%s.%s", title.Database, title.Table), Object: "table", Change: "added", Current: currentTable.Engine})
+		}
+	}
+	if len(diffs) == 0 {
+		log.Infof("schema is the same")
+		return nil
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Table < diffs[j].Table
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", "table", "object", "change", "backup", "current"); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	for _, diff := range diffs {
+		if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", diff.Table, diff.Object, diff.Change, diff.Backup, diff.Current); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+	}
+	return w.Flush()
+}
+
+// diffTableSchema - columns, indexes, projections, constraints and table level clauses which differ between backup and current CREATE query
+func diffTableSchema(backupQuery, currentQuery string) []schemaDiff {
+	backupQuery, currentQuery = normalizeCreateQuery(backupQuery), normalizeCreateQuery(currentQuery)
+	if backupQuery == currentQuery {
+		return nil
+	}
+	diffs := make([]schemaDiff, 0)
+	backupColumns, currentColumns := ddlColumns(backupQuery), ddlColumns(currentQuery)
+	for _, name := range sortedKeys(backupColumns) {
+		currentDefinition, exists := currentColumns[name]
+		if !exists {
+			diffs = append(diffs, schemaDiff{Object: name, Change: "removed", Backup: backupColumns[name]})
+		} else if currentDefinition != backupColumns[name] {
+			diffs = append(diffs, schemaDiff{Object: name, Change: "changed", Backup: backupColumns[name], Current: currentDefinition})
+		}
+	}
+	for _, name := range sortedKeys(currentColumns) {
+		if _, exists := backupColumns[name]; !exists {
+			diffs = append(diffs, schemaDiff{Object: name, Change: "added", Current: currentColumns[name]})
+		}
+	}
+	backupClauses, currentClauses := ddlClauses(backupQuery), ddlClauses(currentQuery)
+	for _, k := range ddlClauseKeywords {
+		// column level clauses prefixed by column name, they are compared as part of column definition
+		backupClause, currentClause := tableLevelClause(k, backupClauses[k]), tableLevelClause(k, currentClauses[k])
+		if backupClause != currentClause {
+			diffs = append(diffs, schemaDiff{Object: k, Change: "changed", Backup: backupClause, Current: currentClause})
+		}
+	}
+	if len(diffs) == 0 {
+		diffs = append(diffs, schemaDiff{Object: "query", Change: "changed", Backup: backupQuery, Current: currentQuery})
+	}
+	return diffs
+}
+
+func tableLevelClause(keyword string, clauses []string) string {
+	tableClauses := make([]string, 0)
+	for _, clause := range clauses {
+		if strings.HasPrefix(clause, keyword) {
+			tableClauses = append(tableClauses, clause)
+		}
+	}
+	return strings.Join(tableClauses, "; ")
+}
+
+// ddlColumns - definitions inside first top level brackets of normalized query, key is `column <name>` or `index <name>`, `projection <name>`, `constraint <name>`,
+// views without columns list return empty map
+func ddlColumns(query string) map[string]string {
+	columns := make(map[string]string)
+	start := strings.IndexByte(query, '(')
+	if start < 0 || strings.Contains(query[:start], " AS ") || strings.Contains(query[:start], " ENGINE") {
+		return columns
+	}
+	addColumn := func(item string) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return
+		}
+		kind := "column"
+		for _, k := range []string{"INDEX ", "PROJECTION ", "CONSTRAINT "} {
+			if strings.HasPrefix(item, k) {
+				kind = strings.ToLower(strings.TrimSpace(k))
+				item = strings.TrimPrefix(item, k)
+				break
+			}
+		}
+		if strings.HasPrefix(item, "PRIMARY KEY") {
+			columns["primary key"] = strings.TrimSpace(strings.TrimPrefix(item, "PRIMARY KEY"))
+			return
+		}
+		nameEnd := strings.IndexByte(item, ' ')
+		if strings.HasPrefix(item, "`") {
+			if closing := strings.IndexByte(item[1:], '`'); closing >= 0 {
+				nameEnd = closing + 2
+			}
+		}
+		if nameEnd < 0 || nameEnd > len(item) {
+			nameEnd = len(item)
+		}
+		columns[kind+" "+strings.Trim(item[:nameEnd], "`")] = strings.TrimSpace(item[nameEnd:])
+	}
+	depth, itemStart := 0, start+1
+	var quote byte
+	for i := start; i < len(query); i++ {
+		c := query[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				addColumn(query[itemStart:i])
+				return columns
+			}
+		case ',':
+			if depth == 1 {
+				addColumn(query[itemStart:i])
+				itemStart = i + 1
+			}
+		}
+	}
+	return columns
+}
+
+// ddlEngine - ENGINE clause of CREATE query, or kind of object for views and dictionaries
+func ddlEngine(query string) string {
+	query = normalizeCreateQuery(query)
+	if engine := tableLevelClause("ENGINE", ddlClauses(query)["ENGINE"]); engine != "" {
+		return engine
+	}
+	return ddlKindRE.FindString(query)
+}
+
+// diffPartitions - partition_id which present only in backup or only in current clickhouse-server
+func diffPartitions(backupPartitions, currentPartitions []string) []schemaDiff {
+	removed, added := make([]string, 0), make([]string, 0)
+	for _, partitionId := range backupPartitions {
+		if !slices.Contains(currentPartitions, partitionId) && !slices.Contains(removed, partitionId) {
+			removed = append(removed, partitionId)
+		}
+	}
+	for _, partitionId := range currentPartitions {
+		if !slices.Contains(backupPartitions, partitionId) && !slices.Contains(added, partitionId) {
+			added = append(added, partitionId)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	diffs := make([]schemaDiff, 0)
+	if len(removed) > 0 {
+		diffs = append(diffs, schemaDiff{Object: "partitions", Change: "removed", Backup: strings.Join(removed, ",")})
+	}
+	if len(added) > 0 {
+		diffs = append(diffs, schemaDiff{Object: "partitions", Change: "added", Current: strings.Join(added, ",")})
+	}
+	return diffs
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffSettings - changed system.settings and system.merge_tree_settings saved during backup which differ from current values
+func (b *Backuper) diffSettings(ctx context.Context, backupMetadata *metadata.BackupMetadata, log *apexLog.Entry) error {
+	if backupMetadata.Settings == nil && backupMetadata.MergeTreeSettings == nil {
+		log.Warnf("%s doesn't contain settings snapshot, it was created by clickhouse-backup %s", backupMetadata.BackupName, backupMetadata.ClickhouseBackupVersion)
+		return nil
+	}
+	diffs := make([]settingDiff, 0)
+	for settingsTable, backupSettings := range map[string]map[string]string{
+		"system.settings":            backupMetadata.Settings,
+		"system.merge_tree_settings": backupMetadata.MergeTreeSettings,
+	} {
+		currentSettings, err := b.ch.GetChangedSettings(ctx, settingsTable)
+		if err != nil {
+			return fmt.Errorf("can't get changed %s: %v", settingsTable, err)
+		}
+		// settings changed only during backup, need to know current value
+		names := make([]string, 0)
+		for name := range backupSettings {
+			if _, exists := currentSettings[name]; !exists {
+				names = append(names, name)
+			}
+		}
+		currentValues, err := b.ch.GetSettingsValues(ctx, settingsTable, names)
+		if err != nil {
+			return fmt.Errorf("can't get %s values: %v", settingsTable, err)
+		}
+		diffs = append(diffs, compareSettings(settingsTable, backupSettings, currentSettings, currentValues)...)
+	}
+	if len(diffs) == 0 {
+		log.Infof("settings are the same")
+		return nil
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Table != diffs[j].Table {
+			return diffs[i].Table < diffs[j].Table
+		}
+		return diffs[i].Name < diffs[j].Name
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "table", "setting", "backup", "current"); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	for _, diff := range diffs {
+		if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", diff.Table, diff.Name, diff.BackupValue, diff.CurrentValue); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+	}
+	return w.Flush()
+}
+
+// compareSettings - backupSettings and changedSettings contain only changed settings, currentValues contains current values for settings which changed only in backup
+// settings which clickhouse-backup sets itself are skipped, look clickhouse.IsSessionSetting
+func compareSettings(settingsTable string, backupSettings, changedSettings, currentValues map[string]string) []settingDiff {
+	diffs := make([]settingDiff, 0)
+	for name, backupValue := range backupSettings {
+		if settingsTable == "system.settings" && clickhouse.IsSessionSetting(name) {
+			continue
+		}
+		currentValue, exists := changedSettings[name]
+		if !exists {
+			if currentValue, exists = currentValues[name]; !exists {
+				currentValue = settingAbsentValue
+			}
+		}
+		if backupValue != currentValue {
+			diffs = append(diffs, settingDiff{Table: settingsTable, Name: name, BackupValue: backupValue, CurrentValue: currentValue})
+		}
+	}
+	for name, currentValue := range changedSettings {
+		if settingsTable == "system.settings" && clickhouse.IsSessionSetting(name) {
+			continue
+		}
+		if _, exists := backupSettings[name]; !exists {
+			diffs = append(diffs, settingDiff{Table: settingsTable, Name: name, BackupValue: settingDefaultValue, CurrentValue: currentValue})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareSettings(t *testing.T) {
+	backupSettings := map[string]string{
+		"max_threads":              "8",
+		"max_insert_block_size":    "1048576",
+		"allow_experimental_stuff": "1",
+		"log_comment":              `{"operation":"create","backup":"backup1"}`,
+	}
+	changedSettings := map[string]string{
+		"max_threads":      "16",
+		"max_memory_usage": "10000000000",
+		"log_comment":      `{"operation":"diff","backup":"backup1"}`,
+		"receive_timeout":  "300",
+	}
+	currentValues := map[string]string{
+		"max_insert_block_size": "1048576",
+	}
+	diffs := compareSettings("system.settings", backupSettings, changedSettings, currentValues)
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+	assert.Equal(t, []settingDiff{
+		{Table: "system.settings", Name: "allow_experimental_stuff", BackupValue: "1", CurrentValue: settingAbsentValue},
+		{Table: "system.settings", Name: "max_memory_usage", BackupValue: settingDefaultValue, CurrentValue: "10000000000"},
+		{Table: "system.settings", Name: "max_threads", BackupValue: "8", CurrentValue: "16"},
+	}, diffs)
+	assert.Empty(t, compareSettings("system.merge_tree_settings", nil, nil, nil))
+}
+
+func TestDiffTableSchema(t *testing.T) {
+	backupQuery := "CREATE TABLE db.t1 UUID '11111111-1111-1111-1111-111111111111' (`id` UInt64, `name` String, `removed` Int8 DEFAULT 1, INDEX idx name TYPE bloom_filter GRANULARITY 1) ENGINE = MergeTree PARTITION BY id % 10 ORDER BY id SETTINGS index_granularity = 8192"
+	currentQuery := "CREATE TABLE db.t1 (`id` UInt64, `name` LowCardinality(String), `added` Date, INDEX idx name TYPE bloom_filter GRANULARITY 1) ENGINE = MergeTree PARTITION BY id % 10 ORDER BY (id, name) SETTINGS index_granularity = 8192"
+	assert.Equal(t, []schemaDiff{
+		{Object: "column name", Change: "changed", Backup: "String", Current: "LowCardinality(String)"},
+		{Object: "column removed", Change: "removed", Backup: "Int8 DEFAULT 1"},
+		{Object: "column added", Change: "added", Current: "Date"},
+		{Object: "ORDER BY", Change: "changed", Backup: "ORDER BY id", Current: "ORDER BY (id, name)"},
+	}, diffTableSchema(backupQuery, currentQuery))
+	assert.Empty(t, diffTableSchema(backupQuery, backupQuery))
+
+	viewQuery := "CREATE VIEW db.v1 AS SELECT id FROM db.t1"
+	assert.Equal(t, []schemaDiff{
+		{Object: "query", Change: "changed", Backup: "CREATE VIEW AS SELECT id FROM db.t1", Current: "CREATE VIEW AS SELECT id, name FROM db.t1"},
+	}, diffTableSchema(viewQuery, "CREATE VIEW db.v1 AS SELECT id, name FROM db.t1"))
+}
+
+func TestDdlColumns(t *testing.T) {
+	columns := ddlColumns(normalizeCreateQuery("CREATE TABLE db.t1 (`id` UInt64 CODEC(Delta, ZSTD(1)), `m` Map(String, UInt64) COMMENT 'a, b', PROJECTION p (SELECT id ORDER BY m), CONSTRAINT c CHECK id > 0) ENGINE = MergeTree ORDER BY id"))
+	assert.Equal(t, map[string]string{
+		"column id":    "UInt64 CODEC(Delta, ZSTD(1))",
+		"column m":     "Map(String, UInt64) COMMENT 'a, b'",
+		"projection p": "(SELECT id ORDER BY m)",
+		"constraint c": "CHECK id > 0",
+	}, columns)
+	assert.Empty(t, ddlColumns(normalizeCreateQuery("CREATE TABLE db.t2 ENGINE = MergeTree() ORDER BY tuple()")))
+}
+
+func TestDiffPartitions(t *testing.T) {
+	assert.Equal(t, []schemaDiff{
+		{Object: "partitions", Change: "removed", Backup: "202401"},
+		{Object: "partitions", Change: "added", Current: "202403,202404"},
+	}, diffPartitions([]string{"202402", "202401", "202401"}, []string{"202404", "202402", "202403"}))
+	assert.Empty(t, diffPartitions([]string{"all"}, []string{"all"}))
+}
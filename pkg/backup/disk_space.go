@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"github.com/ricochet2200/go-disk-usage/du"
+)
+
+// diskSpaceRequirement - bytes which operation writes to one local disk, look general->check_disk_space
+type diskSpaceRequirement struct {
+	Disk       string
+	Path       string
+	Raw        uint64 // data parts
+	Compressed uint64 // archives written to disk before extract and removed after extract
+	Free       uint64
+	Size       uint64
+}
+
+// checkDiskSpaceRequirements - reserve is percent of disk size which shall stay free, all disks without enough space are listed in error
+func checkDiskSpaceRequirements(operation string, requirements []diskSpaceRequirement, reservePercent float64) error {
+	var notEnough []string
+	for _, r := range requirements {
+		required := r.Raw + r.Compressed
+		// statfs failed, free space is unknown
+		if required == 0 || r.Size == 0 {
+			continue
+		}
+		reserve := uint64(float64(r.Size) * reservePercent / 100)
+		if required+reserve <= r.Free {
+			continue
+		}
+		notEnough = append(notEnough, fmt.Sprintf("disk %s (%s) requires %s (raw %s, compressed %s) + reserve %s, free %s", r.Disk, r.Path, utils.FormatBytes(required), utils.FormatBytes(r.Raw), utils.FormatBytes(r.Compressed), utils.FormatBytes(reserve), utils.FormatBytes(r.Free)))
+	}
+	if len(notEnough) > 0 {
+		return fmt.Errorf("not enough disk space for %s: %s, free up space, decrease disk_space_reserve_percent or set check_disk_space: false", operation, strings.Join(notEnough, "; "))
+	}
+	return nil
+}
+
+// checkDiskSpace - raw and compressed contain bytes for each disk name, only local disks are checked, object disks don't store data locally
+func (b *Backuper) checkDiskSpace(operation string, disks []clickhouse.Disk, raw, compressed map[string]uint64, log *apexLog.Entry) error {
+	if !b.cfg.General.CheckDiskSpace {
+		return nil
+	}
+	requirements := make([]diskSpaceRequirement, 0, len(raw))
+	for _, disk := range disks {
+		if disk.Type != "local" || (raw[disk.Name] == 0 && compressed[disk.Name] == 0) {
+			continue
+		}
+		usage := du.NewDiskUsage(disk.Path)
+		requirements = append(requirements, diskSpaceRequirement{
+			Disk:       disk.Name,
+			Path:       disk.Path,
+			Raw:        raw[disk.Name],
+			Compressed: compressed[disk.Name],
+			Free:       usage.Available(),
+			Size:       usage.Size(),
+		})
+		log.WithField("disk", disk.Name).Debugf("%s requires %s, available %s", operation, utils.FormatBytes(raw[disk.Name]+compressed[disk.Name]), utils.FormatBytes(usage.Available()))
+	}
+	return checkDiskSpaceRequirements(operation, requirements, b.cfg.General.DiskSpaceReservePercent)
+}
+
+// getCreateDiskSpace - FREEZE creates hardlinks without additional space, data is copied only from filesystem snapshot and into local embedded backup disk
+func (b *Backuper) getCreateDiskSpace(ctx context.Context, tables []clickhouse.Table, isEmbedded bool) (map[string]uint64, error) {
+	raw := map[string]uint64{}
+	if isEmbedded {
+		if b.cfg.ClickHouse.EmbeddedBackupDisk == "" {
+			return raw, nil
+		}
+		for _, table := range tables {
+			if !table.Skip {
+				raw[b.cfg.ClickHouse.EmbeddedBackupDisk] += table.TotalBytes
+			}
+		}
+		return raw, nil
+	}
+	if b.cfg.ClickHouse.FilesystemSnapshotType == "" {
+		return raw, nil
+	}
+	sizes, err := b.ch.GetTablesSizeByDisk(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backupTables := map[metadata.TableTitle]bool{}
+	for _, table := range tables {
+		if !table.Skip && table.BackupType == clickhouse.ShardBackupFull {
+			backupTables[metadata.TableTitle{Database: table.Database, Table: table.Name}] = true
+		}
+	}
+	for _, size := range sizes {
+		if backupTables[metadata.TableTitle{Database: size.Database, Table: size.Table}] {
+			raw[size.DiskName] += size.Size
+		}
+	}
+	return raw, nil
+}
+
+// getDownloadDiskSpace - parts of disks which don't exist locally are downloaded to disk selected in reBalanceTablesMetadataIfDiskNotExists,
+// archives of s3->allow_multipart_download are downloaded into temporary file, at most download_concurrency biggest tables are downloaded in parallel
+func (b *Backuper) getDownloadDiskSpace(tables []*metadata.TableMetadata, disks []clickhouse.Disk, backupMetadata metadata.BackupMetadata) (map[string]uint64, map[string]uint64) {
+	localDisks := map[string]bool{}
+	for _, disk := range disks {
+		localDisks[disk.Name] = true
+	}
+	raw := map[string]uint64{}
+	tableSizes := map[string][]uint64{}
+	for _, table := range tables {
+		if table == nil || table.MetadataOnly {
+			continue
+		}
+		// general->table_compression_format could upload table without archives
+		isArchived := table.GetDataFormat(backupMetadata.DataFormat) != DirectoryFormat
+		for disk, size := range table.Size {
+			if localDisks[disk] {
+				raw[disk] += uint64(size)
+				if isArchived {
+					tableSizes[disk] = append(tableSizes[disk], uint64(size))
+				}
+				continue
+			}
+			rebalancedSizes := map[string]uint64{}
+			for _, part := range table.Parts[disk] {
+				if part.RebalancedDisk != "" {
+					rebalancedSizes[part.RebalancedDisk] += uint64(size) / uint64(len(table.Parts[disk]))
+				}
+			}
+			for dstDisk, rebalancedSize := range rebalancedSizes {
+				raw[dstDisk] += rebalancedSize
+				if isArchived {
+					tableSizes[dstDisk] = append(tableSizes[dstDisk], rebalancedSize)
+				}
+			}
+		}
+	}
+	compressed := map[string]uint64{}
+	// general->staging_path moves archives outside clickhouse disks
+	if b.cfg.General.StagingPath != "" || b.cfg.General.RemoteStorage != "s3" || !b.cfg.S3.AllowMultipartDownload || backupMetadata.DataFormat == DirectoryFormat || backupMetadata.DataSize == 0 {
+		return raw, compressed
+	}
+	ratio := float64(backupMetadata.CompressedSize) / float64(backupMetadata.DataSize)
+	for disk, sizes := range tableSizes {
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+		if len(sizes) > int(b.cfg.General.DownloadConcurrency) {
+			sizes = sizes[:b.cfg.General.DownloadConcurrency]
+		}
+		for _, size := range sizes {
+			compressed[disk] += uint64(float64(size) * ratio)
+		}
+	}
+	return raw, compressed
+}
@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDiskSpaceRequirements(t *testing.T) {
+	requirements := []diskSpaceRequirement{
+		{Disk: "default", Path: "/var/lib/clickhouse/", Raw: 600, Compressed: 100, Free: 800, Size: 1000},
+		{Disk: "hdd", Path: "/hdd/", Raw: 100, Free: 0, Size: 0},
+		{Disk: "ssd", Path: "/ssd/", Free: 0, Size: 1000},
+	}
+	require.NoError(t, checkDiskSpaceRequirements("download", requirements, 0))
+	require.NoError(t, checkDiskSpaceRequirements("download", requirements, 10))
+	err := checkDiskSpaceRequirements("download", requirements, 15)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not enough disk space for download: disk default (/var/lib/clickhouse/) requires 700B (raw 600B, compressed 100B) + reserve 150B, free 800B")
+	assert.NotContains(t, err.Error(), "hdd")
+	assert.NotContains(t, err.Error(), "ssd")
+}
+
+func TestGetDownloadDiskSpace(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.DownloadConcurrency = 1
+	b := &Backuper{cfg: cfg}
+	disks := []clickhouse.Disk{{Name: "default", Type: "local"}, {Name: "hdd", Type: "local"}}
+	tables := []*metadata.TableMetadata{
+		{Size: map[string]int64{"default": 1000, "hdd": 200}},
+		{Size: map[string]int64{"default": 500, "missing": 400}, Parts: map[string][]metadata.Part{"missing": {{Name: "all_1_1_0", RebalancedDisk: "hdd"}, {Name: "all_2_2_0", RebalancedDisk: "default"}}}},
+		{Size: map[string]int64{"default": 100}, MetadataOnly: true},
+		nil,
+	}
+	backupMetadata := metadata.BackupMetadata{DataFormat: "tar", DataSize: 2000, CompressedSize: 1000}
+	raw, compressed := b.getDownloadDiskSpace(tables, disks, backupMetadata)
+	assert.Equal(t, map[string]uint64{"default": 1700, "hdd": 400}, raw)
+	assert.Empty(t, compressed)
+
+	cfg.General.RemoteStorage = "s3"
+	cfg.S3.AllowMultipartDownload = true
+	raw, compressed = b.getDownloadDiskSpace(tables, disks, backupMetadata)
+	assert.Equal(t, map[string]uint64{"default": 1700, "hdd": 400}, raw)
+	assert.Equal(t, map[string]uint64{"default": 500, "hdd": 100}, compressed)
+}
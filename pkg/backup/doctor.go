@@ -0,0 +1,272 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/custom"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+)
+
+const (
+	DoctorOK   = "OK"
+	DoctorWarn = "WARN"
+	DoctorFail = "FAIL"
+)
+
+// DoctorCheck - one line of `doctor` report, Hint explains how to fix WARN and FAIL
+type DoctorCheck struct {
+	Name    string
+	Status  string
+	Message string
+	Hint    string
+}
+
+// DoctorReport - result of `doctor` command
+type DoctorReport struct {
+	Checks []DoctorCheck
+}
+
+func (r *DoctorReport) add(name, status, message, hint string) {
+	r.Checks = append(r.Checks, DoctorCheck{Name: name, Status: status, Message: message, Hint: hint})
+}
+
+// ExitCode - 0 when all checks passed, 1 when some checks have warnings, 2 when some checks failed
+func (r *DoctorReport) ExitCode() int {
+	exitCode := 0
+	for _, check := range r.Checks {
+		if check.Status == DoctorFail {
+			return 2
+		}
+		if check.Status == DoctorWarn {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// Print - checks as table, hints on separate lines under failed checks
+func (r *DoctorReport) Print(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	counts := map[string]int{}
+	for _, check := range r.Checks {
+		counts[check.Status]++
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", check.Status, check.Name, check.Message); err != nil {
+			return err
+		}
+		if check.Hint != "" && check.Status != DoctorOK {
+			if _, err := fmt.Fprintf(w, "\t\thint: %s\n", check.Hint); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%d passed, %d warnings, %d failed\n", counts[DoctorOK], counts[DoctorWarn], counts[DoctorFail])
+	return err
+}
+
+// Doctor - validate config, check connection to clickhouse and remote storage, disks and keeper access,
+// failed check doesn't stop other checks which not depend on it
+func Doctor(ctx context.Context, configPath string) *DoctorReport {
+	report := &DoctorReport{}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		report.add("config", DoctorFail, err.Error(), fmt.Sprintf("fix %s or environment variables, use `clickhouse-backup default-config` for reference", configPath))
+		return report
+	}
+	report.add("config", DoctorOK, fmt.Sprintf("%s is valid", configPath), "")
+	b := NewBackuper(cfg)
+	b.doctorConfig(report)
+	if err = b.ch.Connect(); err != nil {
+		report.add("clickhouse", DoctorFail, fmt.Sprintf("can't connect to %s:%d: %v", cfg.ClickHouse.Host, cfg.ClickHouse.Port, err), "check host, port, username, password and secure in clickhouse section")
+		report.add("remote_storage", DoctorWarn, "skipped, clickhouse connection is required to apply macros in remote path", "fix clickhouse connection first")
+		return report
+	}
+	defer b.ch.Close()
+	report.add("clickhouse", DoctorOK, fmt.Sprintf("connected to %s:%d, version %s", cfg.ClickHouse.Host, cfg.ClickHouse.Port, b.ch.GetVersionDescribe(ctx)), "")
+	b.doctorDisks(ctx, report)
+	b.doctorKeeper(ctx, report)
+	b.doctorRemoteStorage(ctx, report)
+	return report
+}
+
+// doctorConfig - valid but suspicious settings
+func (b *Backuper) doctorConfig(report *DoctorReport) {
+	if b.cfg.General.RemoteStorage == "none" {
+		report.add("config", DoctorWarn, "remote_storage is 'none', only local backups are available", "set general->remote_storage to upload backups outside clickhouse-server host")
+		return
+	}
+	if b.cfg.General.BackupsToKeepRemote == 0 && len(b.cfg.General.RetentionPolicies) == 0 {
+		report.add("config", DoctorWarn, "backups_to_keep_remote is 0, remote backups will never deleted", "set general->backups_to_keep_remote or general->retention_policies")
+	}
+}
+
+// doctorDisks - clickhouse-backup shall run on the same host as clickhouse-server and have write access to all disks paths
+func (b *Backuper) doctorDisks(ctx context.Context, report *DoctorReport) {
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		report.add("disks", DoctorFail, fmt.Sprintf("can't read system.disks: %v", err), "grant SELECT on system.* to clickhouse->username")
+		return
+	}
+	dataSizes := make([]struct {
+		Disk string `ch:"disk_name"`
+		Size uint64 `ch:"size"`
+	}, 0)
+	if err = b.ch.SelectContext(ctx, &dataSizes, "SELECT disk_name, sum(bytes_on_disk) AS size FROM system.parts WHERE active GROUP BY disk_name"); err != nil {
+		report.add("disks", DoctorWarn, fmt.Sprintf("can't read system.parts: %v", err), "grant SELECT on system.* to clickhouse->username")
+	}
+	for _, disk := range disks {
+		name := "disk " + disk.Name
+		if strings.HasSuffix(disk.Type, "_plain") {
+			continue
+		}
+		if err = checkDiskPath(disk.Path); err != nil {
+			report.add(name, DoctorFail, err.Error(), "run clickhouse-backup on clickhouse-server host or mount the same volumes into container, as root or clickhouse user, use clickhouse->disk_mapping when paths differ")
+			continue
+		}
+		if disk.Type != "local" {
+			report.add(name, DoctorOK, fmt.Sprintf("%s is writable, type %s", disk.Path, disk.Type), "")
+			continue
+		}
+		dataSize := uint64(0)
+		for _, d := range dataSizes {
+			if d.Disk == disk.Name {
+				dataSize = d.Size
+			}
+		}
+		if disk.FreeSpace < dataSize {
+			report.add(name, DoctorWarn, fmt.Sprintf("%s free space %s is less than data size %s", disk.Path, utils.FormatBytes(disk.FreeSpace), utils.FormatBytes(dataSize)), "download and restore of full backup could fail, free up space or restore tables partially")
+			continue
+		}
+		report.add(name, DoctorOK, fmt.Sprintf("%s is writable, free space %s, data size %s", disk.Path, utils.FormatBytes(disk.FreeSpace), utils.FormatBytes(dataSize)), "")
+	}
+}
+
+// checkDiskPath - create and remove temporary file, the same as create and delete local backup do
+func checkDiskPath(diskPath string) error {
+	if _, err := os.Stat(diskPath); err != nil {
+		return fmt.Errorf("%s is not accessible: %v", diskPath, err)
+	}
+	f, err := os.CreateTemp(diskPath, ".clickhouse-backup-doctor-")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %v", diskPath, err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("can't close %s: %v", f.Name(), err)
+	}
+	if err = os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("can't remove %s: %v", f.Name(), err)
+	}
+	return nil
+}
+
+// doctorKeeper - restore of Replicated tables requires keeper, readonly replicas can't attach restored parts
+func (b *Backuper) doctorKeeper(ctx context.Context, report *DoctorReport) {
+	var replicated, readonly uint64
+	if err := b.ch.SelectSingleRow(ctx, &replicated, "SELECT count() FROM system.tables WHERE engine LIKE 'Replicated%'"); err != nil {
+		report.add("keeper", DoctorWarn, fmt.Sprintf("can't read system.tables: %v", err), "grant SELECT on system.* to clickhouse->username")
+		return
+	}
+	if replicated == 0 {
+		report.add("keeper", DoctorOK, "no Replicated tables, keeper is not required", "")
+		return
+	}
+	var nodes uint64
+	if err := b.ch.SelectSingleRow(ctx, &nodes, "SELECT count() FROM system.zookeeper WHERE path='/'"); err != nil {
+		report.add("keeper", DoctorFail, fmt.Sprintf("%d Replicated tables, but keeper is not accessible: %v", replicated, err), "check <zookeeper> section in clickhouse-server config and keeper availability")
+		return
+	}
+	if err := b.ch.SelectSingleRow(ctx, &readonly, "SELECT count() FROM system.replicas WHERE is_readonly"); err != nil {
+		report.add("keeper", DoctorWarn, fmt.Sprintf("can't read system.replicas: %v", err), "grant SELECT on system.* to clickhouse->username")
+		return
+	}
+	if readonly > 0 {
+		report.add("keeper", DoctorWarn, fmt.Sprintf("%d of %d Replicated tables are readonly", readonly, replicated), "check SELECT * FROM system.replicas WHERE is_readonly, restore data into readonly replica will fail")
+		return
+	}
+	report.add("keeper", DoctorOK, fmt.Sprintf("accessible, %d Replicated tables", replicated), "")
+}
+
+// doctorRemoteStorage - list, write, read and delete temporary file in the root of remote path
+func (b *Backuper) doctorRemoteStorage(ctx context.Context, report *DoctorReport) {
+	remoteStorage := b.cfg.General.RemoteStorage
+	if remoteStorage == "none" {
+		return
+	}
+	if remoteStorage == "custom" {
+		if _, err := custom.List(ctx, b.cfg); err != nil {
+			report.add("remote_storage", DoctorFail, fmt.Sprintf("custom list_command failed: %v", err), "check custom->list_command")
+			return
+		}
+		report.add("remote_storage", DoctorOK, "custom list_command succeeded", "")
+		return
+	}
+	hint := fmt.Sprintf("check credentials, endpoint, bucket and path in %s section", remoteStorage)
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		report.add("remote_storage", DoctorFail, err.Error(), hint)
+		return
+	}
+	if err = bd.Connect(ctx); err != nil {
+		report.add("remote_storage", DoctorFail, fmt.Sprintf("can't connect to %s: %v", bd.Kind(), err), hint)
+		return
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	report.add("remote_storage", DoctorOK, fmt.Sprintf("connected to %s", bd.Kind()), "")
+	if err = bd.Walk(ctx, "/", false, func(context.Context, storage.RemoteFile) error { return nil }); err != nil {
+		report.add("remote_storage list", DoctorFail, err.Error(), "grant list permission, `list remote` and retention will fail")
+	} else {
+		report.add("remote_storage list", DoctorOK, "allowed", "")
+	}
+	hostname, _ := os.Hostname()
+	key := fmt.Sprintf("clickhouse-backup-doctor-%s-%d.tmp", hostname, time.Now().UnixNano())
+	content := "clickhouse-backup doctor " + key
+	if err = bd.PutFile(ctx, key, io.NopCloser(strings.NewReader(content))); err != nil {
+		report.add("remote_storage write", DoctorFail, err.Error(), "grant write permission, `upload` will fail")
+		return
+	}
+	report.add("remote_storage write", DoctorOK, "allowed", "")
+	if err = b.doctorReadRemoteFile(ctx, bd, key, content); err != nil {
+		report.add("remote_storage read", DoctorFail, err.Error(), "grant read permission, `download` will fail")
+	} else {
+		report.add("remote_storage read", DoctorOK, "allowed", "")
+	}
+	if err = bd.DeleteFile(ctx, key); err != nil {
+		report.add("remote_storage delete", DoctorFail, fmt.Sprintf("can't delete %s: %v", key, err), "grant delete permission, `delete remote` and backups_to_keep_remote will fail, with object lock retention delete fails by design, remove file manually")
+		return
+	}
+	report.add("remote_storage delete", DoctorOK, "allowed", "")
+}
+
+func (b *Backuper) doctorReadRemoteFile(ctx context.Context, bd *storage.BackupDestination, key, expected string) error {
+	reader, err := bd.GetFileReader(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			b.log.Warnf("can't close %s reader: %v", key, err)
+		}
+	}()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if string(content) != expected {
+		return fmt.Errorf("%s content mismatch, got %d bytes, expected %d", key, len(content), len(expected))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoctorReport(t *testing.T) {
+	report := &DoctorReport{}
+	report.add("config", DoctorOK, "valid", "")
+	assert.Equal(t, 0, report.ExitCode())
+	report.add("keeper", DoctorWarn, "1 of 2 Replicated tables are readonly", "check system.replicas")
+	assert.Equal(t, 1, report.ExitCode())
+	report.add("remote_storage write", DoctorFail, "access denied", "grant write permission")
+	report.add("remote_storage delete", DoctorOK, "allowed", "ignored hint")
+	assert.Equal(t, 2, report.ExitCode())
+
+	out := &bytes.Buffer{}
+	require.NoError(t, report.Print(out))
+	expected := "" +
+		"OK     config                  valid\n" +
+		"WARN   keeper                  1 of 2 Replicated tables are readonly\n" +
+		"                               hint: check system.replicas\n" +
+		"FAIL   remote_storage write    access denied\n" +
+		"                               hint: grant write permission\n" +
+		"OK     remote_storage delete   allowed\n" +
+		"2 passed, 1 warnings, 1 failed\n"
+	assert.Equal(t, expected, out.String())
+}
+
+func TestCheckDiskPath(t *testing.T) {
+	diskPath := t.TempDir()
+	require.NoError(t, checkDiskPath(diskPath))
+	entries, err := os.ReadDir(diskPath)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.ErrorContains(t, checkDiskPath(path.Join(diskPath, "absent")), "is not accessible")
+}
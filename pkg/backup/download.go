@@ -12,7 +12,6 @@ import (
 	"github.com/Altinity/clickhouse-backup/v2/pkg/partition"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
-	"github.com/eapache/go-resiliency/retrier"
 	"io"
 	"io/fs"
 	"math/rand"
@@ -46,6 +45,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	}
 	defer cancel()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	b.setLogComment("download", backupName, commandId)
 	if err := b.ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
@@ -87,7 +87,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	}
 	startDownload := time.Now()
 	if b.cfg.General.RemoteStorage == "custom" {
-		return custom.Download(ctx, b.cfg, backupName, tablePattern, partitions, schemaOnly)
+		return custom.Download(ctx, b.cfg, backupName, tablePattern, partitions, schemaOnly, getCustomDiskMap(disks))
 	}
 	if err := b.initDisksPathdsAndBackupDestination(ctx, disks, ""); err != nil {
 		return err
@@ -114,6 +114,17 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	if !found {
 		return fmt.Errorf("'%s' is not found on remote storage", backupName)
 	}
+	// BackupList could return metadata from catalog.json or local cache, so read signed metadata.json directly
+	if b.cfg.General.VerifyPublicKeyFile != "" {
+		verifiedMetadata, err := b.readVerifiedBackupMetadataRemote(ctx, backupName)
+		if err != nil {
+			return err
+		}
+		remoteBackup.BackupMetadata = *verifiedMetadata
+	}
+	if len(remoteBackup.FailedTables) > 0 {
+		log.Warnf("'%s' is incomplete, %d tables failed during upload and will not download, use `upload --only-failed` to finish it", backupName, len(remoteBackup.FailedTables))
+	}
 	if len(remoteBackup.Tables) == 0 && !b.cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("'%s' is empty backup", backupName)
 	}
@@ -139,8 +150,10 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	if err != nil && !resume {
 		return err
 	}
+	b.cleanStagingPath(backupName, true, log)
+	defer b.cleanStagingPath(backupName, false, log)
 	if b.resume {
-		b.resumableState = resumable.NewState(b.DefaultDataPath, backupName, "download", map[string]interface{}{
+		b.resumableState = resumable.NewState(b.DefaultDataPath, backupName, b.resumableCommand("download"), map[string]interface{}{
 			"tablePattern": tablePattern,
 			"partitions":   partitions,
 			"schemaOnly":   schemaOnly,
@@ -176,12 +189,29 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	}
 
 	if !schemaOnly {
-		if reBalanceErr := b.reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownload, disks, remoteBackup, log); reBalanceErr != nil {
+		if err = b.loadCompressionDictionaries(ctx, remoteBackup.BackupMetadata); err != nil {
+			return fmt.Errorf("b.loadCompressionDictionaries return error: %v", err)
+		}
+		if reBalanceErr := b.reBalanceTablesMetadataIfDiskNotExists(ctx, tableMetadataAfterDownload, disks, remoteBackup, log); reBalanceErr != nil {
 			return reBalanceErr
 		}
+		// table size in metadata doesn't depend on partitions, already downloaded parts are not counted during resume
+		if len(partitions) == 0 && !b.resume && !b.isEmbedded {
+			raw, compressed := b.getDownloadDiskSpace(tableMetadataAfterDownload, disks, remoteBackup.BackupMetadata)
+			if err = b.checkDiskSpace("download", disks, raw, compressed, log); err != nil {
+				return err
+			}
+		}
 		log.Debugf("prepare table SHADOW concurrent semaphore with concurrency=%d len(tableMetadataAfterDownload)=%d", b.cfg.General.DownloadConcurrency, len(tableMetadataAfterDownload))
 		dataGroup, dataCtx := errgroup.WithContext(ctx)
 		dataGroup.SetLimit(int(b.cfg.General.DownloadConcurrency))
+		tablesWithData := 0
+		for _, tableMetadata := range tableMetadataAfterDownload {
+			if tableMetadata != nil && !tableMetadata.MetadataOnly {
+				tablesWithData++
+			}
+		}
+		progress := b.newProgressReporter("download", backupName, tablesWithData)
 
 		for i, tableMetadata := range tableMetadataAfterDownload {
 			if tableMetadata == nil || tableMetadata.MetadataOnly {
@@ -194,12 +224,15 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 				if err := b.downloadTableData(dataCtx, remoteBackup.BackupMetadata, *tableMetadataAfterDownload[idx]); err != nil {
 					return err
 				}
+				tableName := fmt.Sprintf("%s.%s", tableMetadataAfterDownload[idx].Database, tableMetadataAfterDownload[idx].Table)
 				log.
 					WithField("operation", "download_data").
-					WithField("table", fmt.Sprintf("%s.%s", tableMetadataAfterDownload[idx].Database, tableMetadataAfterDownload[idx].Table)).
+					WithField("table", tableName).
+					WithField("phase", "download_table").
 					WithField("duration", utils.HumanizeDuration(time.Since(start))).
 					WithField("size", utils.FormatBytes(tableMetadataAfterDownload[idx].TotalBytes)).
 					Info("done")
+				progress.tableDone(tableName, tableMetadataAfterDownload[idx].TotalBytes)
 				return nil
 			})
 		}
@@ -207,7 +240,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 			return fmt.Errorf("one of Download go-routine return error: %v", err)
 		}
 	}
-	var rbacSize, configSize uint64
+	var rbacSize, configSize, keeperSize uint64
 	rbacSize, err = b.downloadRBACData(ctx, remoteBackup)
 	if err != nil {
 		return fmt.Errorf("download RBAC error: %v", err)
@@ -218,6 +251,11 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		return fmt.Errorf("download CONFIGS error: %v", err)
 	}
 
+	keeperSize, err = b.downloadKeeperData(ctx, remoteBackup)
+	if err != nil {
+		return fmt.Errorf("download KEEPER error: %v", err)
+	}
+
 	backupMetadata := remoteBackup.BackupMetadata
 	backupMetadata.Tables = tablesForDownload
 	backupMetadata.DataSize = dataSize
@@ -235,6 +273,8 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	backupMetadata.DataFormat = ""
 	backupMetadata.ConfigSize = configSize
 	backupMetadata.RBACSize = rbacSize
+	backupMetadata.KeeperSize = keeperSize
+	backupMetadata.PartialDownload = getPartialDownload(remoteBackup.Tables, tablesForDownload, tablePattern, partitions, schemaOnly)
 
 	backupMetafileLocalPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json")
 	if b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
@@ -272,14 +312,45 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		}
 	}
 
+	status.Current.AddBytes(commandId, dataSize+metadataSize+rbacSize+configSize+keeperSize)
 	log.
 		WithField("duration", utils.HumanizeDuration(time.Since(startDownload))).
-		WithField("size", utils.FormatBytes(dataSize+metadataSize+rbacSize+configSize)).
+		WithField("size", utils.FormatBytes(dataSize+metadataSize+rbacSize+configSize+keeperSize)).
 		Info("done")
 	return nil
 }
 
-func (b *Backuper) reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownload []*metadata.TableMetadata, disks []clickhouse.Disk, remoteBackup storage.Backup, log *apexLog.Entry) error {
+// getPartialDownload - nil when all tables of remote backup downloaded with all data parts
+// cleanStagingPath - temporary archives of interrupted download can't be continued, resumable state in backup directory contains only extracted archives
+func (b *Backuper) cleanStagingPath(backupName string, isBeforeDownload bool, log *apexLog.Entry) {
+	if b.cfg.General.StagingPath == "" {
+		return
+	}
+	stagingPath := path.Join(b.cfg.General.StagingPath, backupName)
+	if _, err := os.Stat(stagingPath); err != nil {
+		return
+	}
+	if isBeforeDownload {
+		log.Infof("remove %s left after interrupted download", stagingPath)
+	}
+	if err := os.RemoveAll(stagingPath); err != nil {
+		log.Warnf("can't remove %s: %v", stagingPath, err)
+	}
+}
+
+func getPartialDownload(remoteTables, downloadedTables []metadata.TableTitle, tablePattern string, partitions []string, schemaOnly bool) *metadata.PartialDownload {
+	if len(downloadedTables) >= len(remoteTables) && len(partitions) == 0 && !schemaOnly {
+		return nil
+	}
+	return &metadata.PartialDownload{
+		Tables:       tablePattern,
+		Partitions:   partitions,
+		SchemaOnly:   schemaOnly,
+		RemoteTables: len(remoteTables),
+	}
+}
+
+func (b *Backuper) reBalanceTablesMetadataIfDiskNotExists(ctx context.Context, tableMetadataAfterDownload []*metadata.TableMetadata, disks []clickhouse.Disk, remoteBackup storage.Backup, log *apexLog.Entry) error {
 	var disksByStoragePolicyAndType map[string]map[string][]clickhouse.Disk
 	filterDisksByTypeAndStoragePolicies := func(disk string, diskType string, disks []clickhouse.Disk, remoteBackup storage.Backup, t metadata.TableMetadata) (string, []clickhouse.Disk, error) {
 		_, ok := remoteBackup.DiskTypes[disk]
@@ -313,15 +384,28 @@ func (b *Backuper) reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDown
 			continue
 		}
 		isRebalanced := false
-		totalFiles := 0
-		for disk := range t.Files {
-			totalFiles += len(t.Files[disk])
+		// detached parts are never attached, so they are not re-balanced and skipped when disk doesn't exist
+		for disk, detachedParts := range t.DetachedParts {
+			if _, diskExists := b.DiskToPathMap[disk]; diskExists {
+				continue
+			}
+			if len(t.Files[disk]) > 0 {
+				t.Files[disk] = filterDetachedPartsFiles(t.Files[disk], disk, detachedParts)
+			}
+			delete(t.DetachedParts, disk)
+			isRebalanced = true
+			log.Warnf("table '%s.%s' require disk '%s' that not found in system.disks, %d detached parts will not downloaded", t.Database, t.Table, disk, len(detachedParts))
 		}
 		totalParts := 0
 		for disk := range t.Parts {
 			totalParts += len(t.Parts[disk])
 		}
-		if totalFiles == 0 && totalParts == 0 {
+		if totalParts == 0 {
+			if isRebalanced {
+				if _, saveErr := t.Save(t.LocalFile, false); saveErr != nil {
+					return saveErr
+				}
+			}
 			continue
 		}
 		partSize := t.TotalBytes / uint64(totalParts)
@@ -351,7 +435,7 @@ func (b *Backuper) reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDown
 							return fmt.Errorf("table: `%s`.`%s` part.Name: %s, part.RebalancedDisk: %s, non empty `files` can't find disk: %s", t.Table, t.Database, t.Parts[disk][j].Name, t.Parts[disk][j].RebalancedDisk, disk)
 						}
 						for _, fileName := range t.Files[disk] {
-							if strings.HasPrefix(fileName, disk+"_"+t.Parts[disk][j].Name+".") {
+							if strings.HasPrefix(fileName, disk+"_"+t.Parts[disk][j].Name+".") || strings.HasPrefix(fileName, partArchiveChunkPrefix(disk, t.Parts[disk][j].Name)) {
 								if tableMetadataAfterDownload[i].RebalancedFiles == nil {
 									tableMetadataAfterDownload[i].RebalancedFiles = map[string]string{}
 								}
@@ -363,7 +447,7 @@ func (b *Backuper) reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDown
 				rebalancedDisksStr := strings.TrimPrefix(
 					strings.Replace(fmt.Sprintf("%v", rebalancedDisks), ":{}", "", -1), "map",
 				)
-				log.Warnf("table '%s.%s' require disk '%s' that not found in system.disks, you can add nonexistent disks to `disk_mapping` in `clickhouse` config section, data will download to %v", t.Database, t.Table, disk, rebalancedDisksStr)
+				status.Current.AddWarning(ctx, log, status.WarningFallback, "table '%s.%s' require disk '%s' that not found in system.disks, you can add nonexistent disks to `disk_mapping` in `clickhouse` config section, data will download to %v", t.Database, t.Table, disk, rebalancedDisksStr)
 			}
 		}
 		if isRebalanced {
@@ -420,7 +504,7 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 			}
 		}
 		var tmBody []byte
-		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		retry := b.newRetrier()
 		err := retry.RunCtx(ctx, func(ctx context.Context) error {
 			tmReader, err := b.dst.GetFileReader(ctx, remoteMetadataFile)
 			if err != nil {
@@ -439,6 +523,9 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 		if err != nil {
 			return nil, 0, err
 		}
+		if err = b.verifyRemoteFile(ctx, backupName, remoteMetadataFile, tmBody); err != nil {
+			return nil, 0, err
+		}
 
 		if err = os.MkdirAll(path.Dir(localMetadataFile), 0755); err != nil {
 			return nil, 0, err
@@ -458,6 +545,7 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 				return nil, 0, err
 			}
 			if b.shouldSkipByTableEngine(tableMetadata) || b.shouldSkipByTableName(fmt.Sprintf("%s.%s", tableMetadata.Database, tableMetadata.Table)) {
+				status.Current.AddWarning(ctx, b.log, status.WarningSkippedTable, "%s.%s skipped by clickhouse->skip_tables or clickhouse->skip_table_engines", tableMetadata.Database, tableMetadata.Table)
 				return nil, 0, nil
 			}
 			partitionsIdMap, _ = partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, []metadata.TableMetadata{tableMetadata}, partitions)
@@ -534,6 +622,10 @@ func (b *Backuper) downloadConfigData(ctx context.Context, remoteBackup storage.
 	return b.downloadBackupRelatedDir(ctx, remoteBackup, "configs")
 }
 
+func (b *Backuper) downloadKeeperData(ctx context.Context, remoteBackup storage.Backup) (uint64, error) {
+	return b.downloadBackupRelatedDir(ctx, remoteBackup, "keeper")
+}
+
 func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup storage.Backup, prefix string) (uint64, error) {
 	log := b.log.WithField("logger", "downloadBackupRelatedDir")
 
@@ -550,7 +642,7 @@ func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup st
 		}
 	}
 	if remoteBackup.DataFormat == DirectoryFormat {
-		if err := b.dst.DownloadPath(ctx, remoteSource, localDir, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, b.cfg.General.DownloadMaxBytesPerSecond); err != nil {
+		if err := b.dst.DownloadPath(ctx, remoteSource, localDir, b.newRetrier(), b.cfg.General.DownloadMaxBytesPerSecond); err != nil {
 			//SFTP can't walk on non exists paths and return error
 			if !strings.Contains(err.Error(), "not exist") {
 				return 0, err
@@ -582,9 +674,9 @@ func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup st
 		log.Debugf("%s not exists on remote storage, skip download", remoteSource)
 		return 0, nil
 	}
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := b.newRetrier()
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
-		return b.dst.DownloadCompressedStream(ctx, remoteSource, localDir, b.cfg.General.DownloadMaxBytesPerSecond)
+		return b.dst.DownloadCompressedStream(ctx, remoteSource, localDir, b.cfg.General.DownloadMaxBytesPerSecond, nil)
 	})
 	if err != nil {
 		return 0, err
@@ -596,14 +688,14 @@ func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup st
 }
 
 func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.BackupMetadata, table metadata.TableMetadata) error {
-	log := b.log.WithField("logger", "downloadTableData")
+	log := b.log.WithField("logger", "downloadTableData").WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table))
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	dataGroup, dataCtx := errgroup.WithContext(ctx)
 	dataGroup.SetLimit(int(b.cfg.General.DownloadConcurrency))
 
-	if remoteBackup.DataFormat != DirectoryFormat {
+	if table.GetDataFormat(remoteBackup.DataFormat) != DirectoryFormat {
 		capacity := 0
 		downloadOffset := make(map[string]int)
 		for disk := range table.Files {
@@ -624,18 +716,26 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 				}
 				tableLocalDir := b.getLocalBackupDataPathForTable(remoteBackup.BackupName, diskName, dbAndTableDir)
 				downloadOffset[disk] += 1
+				log := log.WithField("disk", diskName)
 				tableRemoteFile := path.Join(remoteBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), archiveFile)
 				dataGroup.Go(func() error {
 					log.Debugf("start download %s", tableRemoteFile)
 					if b.resume && b.resumableState.IsAlreadyProcessedBool(tableRemoteFile) {
 						return nil
 					}
-					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+					retry := b.newRetrier()
 					err := retry.RunCtx(dataCtx, func(dataCtx context.Context) error {
-						return b.dst.DownloadCompressedStream(dataCtx, tableRemoteFile, tableLocalDir, b.cfg.General.DownloadMaxBytesPerSecond)
+						return b.dst.DownloadCompressedStream(dataCtx, tableRemoteFile, tableLocalDir, b.cfg.General.DownloadMaxBytesPerSecond, b.compressionDictionaries)
 					})
 					if err != nil {
-						return err
+						parityGroup, archiveIdx := findParityGroup(table.ParityGroups, archiveFile)
+						if archiveIdx < 0 {
+							return err
+						}
+						status.Current.AddWarning(dataCtx, log, status.WarningFallback, "can't download %s: %v, will rebuild it from parity archives", tableRemoteFile, err)
+						if err = b.downloadArchiveFromParity(dataCtx, path.Dir(tableRemoteFile), parityGroup, archiveIdx, tableLocalDir); err != nil {
+							return fmt.Errorf("can't rebuild %s from parity archives: %v", tableRemoteFile, err)
+						}
 					}
 					if b.resume {
 						b.resumableState.AppendToState(tableRemoteFile, 0)
@@ -646,13 +746,14 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 			}
 		}
 	} else {
+		partsWithDetached := table.GetPartsWithDetached()
 		capacity := 0
-		for disk := range table.Parts {
-			capacity += len(table.Parts[disk])
+		for disk := range partsWithDetached {
+			capacity += len(partsWithDetached[disk])
 		}
 		log.Debugf("start %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.General.DownloadConcurrency, capacity)
 
-		for disk, parts := range table.Parts {
+		for disk, parts := range partsWithDetached {
 			tableRemotePath := path.Join(remoteBackup.BackupName, "shadow", dbAndTableDir, disk)
 			diskPath, diskExists := b.DiskToPathMap[disk]
 			tableLocalPath := path.Join(diskPath, "backup", remoteBackup.BackupName, "shadow", dbAndTableDir, disk)
@@ -676,7 +777,7 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 					if b.resume && b.resumableState.IsAlreadyProcessedBool(partRemotePath) {
 						return nil
 					}
-					if err := b.dst.DownloadPath(dataCtx, partRemotePath, partLocalPath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, b.cfg.General.DownloadMaxBytesPerSecond); err != nil {
+					if err := b.dst.DownloadPath(dataCtx, partRemotePath, partLocalPath, b.newRetrier(), b.cfg.General.DownloadMaxBytesPerSecond); err != nil {
 						return err
 					}
 					if b.resume {
@@ -692,6 +793,12 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 		return fmt.Errorf("one of downloadTableData go-routine return error: %v", err)
 	}
 
+	if !b.isEmbedded && hasDuplicatedFiles(&table) {
+		if err := b.linkDeduplicatedFiles(ctx, remoteBackup.BackupName, table, dbAndTableDir); err != nil {
+			return err
+		}
+	}
+
 	if !b.isEmbedded && remoteBackup.RequiredBackup != "" {
 		err := b.downloadDiffParts(ctx, remoteBackup, table, dbAndTableDir)
 		if err != nil {
@@ -731,10 +838,17 @@ func (b *Backuper) downloadDiffParts(ctx context.Context, remoteBackup metadata.
 			if err := b.checkNewPath(newPath, part); err != nil {
 				return err
 			}
+			requiredPart := part
+			var requiredFiles []string
 			if !part.Required {
-				continue
+				if part.BasePart == "" {
+					continue
+				}
+				// changed files already downloaded, unchanged files shall link from base part, look general->upload_diff_files
+				requiredPart = metadata.Part{Name: part.BasePart, RebalancedDisk: part.RebalancedDisk}
+				requiredFiles = part.RequiredFiles
 			}
-			existsPath := path.Join(b.DiskToPathMap[disk], "backup", remoteBackup.RequiredBackup, "shadow", dbAndTableDir, disk, part.Name)
+			existsPath := path.Join(b.DiskToPathMap[disk], "backup", remoteBackup.RequiredBackup, "shadow", dbAndTableDir, disk, requiredPart.Name)
 			_, err := os.Stat(existsPath)
 			if err != nil && !os.IsNotExist(err) {
 				return fmt.Errorf("%s stat return error: %v", existsPath, err)
@@ -751,7 +865,7 @@ func (b *Backuper) downloadDiffParts(ctx context.Context, remoteBackup metadata.
 					}
 					continue
 				}
-				partForDownload := part
+				partForDownload := requiredPart
 				diskForDownload := disk
 				if !diskExists {
 					diskForDownload = part.RebalancedDisk
@@ -784,7 +898,7 @@ func (b *Backuper) downloadDiffParts(ctx context.Context, remoteBackup metadata.
 						}
 						atomic.AddUint32(&downloadedDiffParts, 1)
 					}
-					if err = b.makePartHardlinks(existsPath, newPath); err != nil {
+					if err = b.makeRequiredPartHardlinks(existsPath, newPath, requiredFiles); err != nil {
 						return fmt.Errorf("can't to add link to exists part %s -> %s error: %v", newPath, existsPath, err)
 					}
 					if b.resume {
@@ -794,7 +908,7 @@ func (b *Backuper) downloadDiffParts(ctx context.Context, remoteBackup metadata.
 				})
 			} else {
 				if !b.resume || (b.resume && !b.resumableState.IsAlreadyProcessedBool(existsPath)) {
-					if err = b.makePartHardlinks(existsPath, newPath); err != nil {
+					if err = b.makeRequiredPartHardlinks(existsPath, newPath, requiredFiles); err != nil {
 						return fmt.Errorf("can't to add exists part: %v", err)
 					}
 				}
@@ -828,9 +942,9 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 		namedLock.Lock()
 		diffRemoteFilesLock.Unlock()
 		if path.Ext(tableRemoteFile) != "" {
-			retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+			retry := b.newRetrier()
 			err := retry.RunCtx(ctx, func(ctx context.Context) error {
-				return b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir, b.cfg.General.DownloadMaxBytesPerSecond)
+				return b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir, b.cfg.General.DownloadMaxBytesPerSecond, b.compressionDictionaries)
 			})
 			if err != nil {
 				log.Warnf("DownloadCompressedStream %s -> %s return error: %v", tableRemoteFile, tableLocalDir, err)
@@ -838,7 +952,7 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 			}
 		} else {
 			// remoteFile could be a directory
-			if err := b.dst.DownloadPath(ctx, tableRemoteFile, tableLocalDir, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, b.cfg.General.DownloadMaxBytesPerSecond); err != nil {
+			if err := b.dst.DownloadPath(ctx, tableRemoteFile, tableLocalDir, b.newRetrier(), b.cfg.General.DownloadMaxBytesPerSecond); err != nil {
 				log.Warnf("DownloadPath %s -> %s return error: %v", tableRemoteFile, tableLocalDir, err)
 				return err
 			}
@@ -887,7 +1001,7 @@ func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadat
 
 	found = false
 	// try to find part on the same disk
-	tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, disk, part)
+	tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, requiredTable, disk, disk, part)
 	if found {
 		return tableRemoteFiles, nil
 	}
@@ -895,7 +1009,7 @@ func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadat
 	// try to find part on other disks
 	for requiredDisk := range requiredBackup.Disks {
 		if requiredDisk != disk {
-			tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, requiredDisk, part)
+			tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, requiredTable, disk, requiredDisk, part)
 			if found {
 				return tableRemoteFiles, nil
 			}
@@ -946,14 +1060,21 @@ func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metada
 	return nil, false, nil
 }
 
-func (b *Backuper) findDiffOnePart(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (map[string]string, error, bool) {
+func (b *Backuper) findDiffOnePart(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (map[string]string, error, bool) {
 	log := apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePart"})
 	log.Debugf("start")
 	tableRemoteFiles := make(map[string]string)
 	// find same disk and part name archive
-	if requiredBackup.DataFormat != DirectoryFormat {
-		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartArchive(ctx, requiredBackup, table, localDisk, remoteDisk, part); err == nil {
+	if dataFormat := requiredTable.GetDataFormat(requiredBackup.DataFormat); dataFormat != DirectoryFormat {
+		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartArchive(ctx, requiredBackup, table, dataFormat, localDisk, remoteDisk, part); err == nil {
 			tableRemoteFiles[tableRemoteFile] = tableLocalDir
+			// big part could split into several archives, look splitPartArchives
+			chunkPrefix := partArchiveChunkPrefix(remoteDisk, part.Name)
+			for _, remoteFile := range requiredTable.Files[remoteDisk] {
+				if strings.HasPrefix(remoteFile, chunkPrefix) {
+					tableRemoteFiles[path.Join(path.Dir(tableRemoteFile), remoteFile)] = tableLocalDir
+				}
+			}
 			return tableRemoteFiles, nil, true
 		}
 	} else {
@@ -975,11 +1096,11 @@ func (b *Backuper) findDiffOnePartDirectory(ctx context.Context, requiredBackup
 	return b.findDiffFileExist(ctx, requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
 }
 
-func (b *Backuper) findDiffOnePartArchive(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
+func (b *Backuper) findDiffOnePartArchive(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, dataFormat, localDisk, remoteDisk string, part metadata.Part) (string, string, error) {
 	log := apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePartArchive"})
 	log.Debugf("start")
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
-	remoteExt := config.ArchiveExtensions[requiredBackup.DataFormat]
+	remoteExt := config.ArchiveExtensions[dataFormat]
 	tableRemotePath := path.Join(requiredBackup.BackupName, "shadow", dbAndTableDir, fmt.Sprintf("%s_%s.%s", remoteDisk, common.TablePathEncode(part.Name), remoteExt))
 	tableRemoteFile := tableRemotePath
 	return b.findDiffFileExist(ctx, requiredBackup, tableRemoteFile, tableRemotePath, localDisk, dbAndTableDir, part)
@@ -1011,6 +1132,9 @@ func (b *Backuper) findDiffFileExist(ctx context.Context, requiredBackup *metada
 }
 
 func (b *Backuper) ReadBackupMetadataRemote(ctx context.Context, backupName string) (*metadata.BackupMetadata, error) {
+	if b.cfg.General.VerifyPublicKeyFile != "" {
+		return b.readVerifiedBackupMetadataRemote(ctx, backupName)
+	}
 	backupList, err := b.dst.BackupList(ctx, true, backupName)
 	if err != nil {
 		return nil, err
@@ -1023,6 +1147,28 @@ func (b *Backuper) ReadBackupMetadataRemote(ctx context.Context, backupName stri
 	return nil, fmt.Errorf("%s not found on remote storage", backupName)
 }
 
+// makeRequiredPartHardlinks - link whole part, or only requiredFiles when part uploaded with general->upload_diff_files
+func (b *Backuper) makeRequiredPartHardlinks(exists, new string, requiredFiles []string) error {
+	if len(requiredFiles) == 0 {
+		return b.makePartHardlinks(exists, new)
+	}
+	for _, requiredFile := range requiredFiles {
+		existsF := path.Join(exists, requiredFile)
+		newF := path.Join(new, requiredFile)
+		if err := os.MkdirAll(path.Dir(newF), 0750); err != nil {
+			return err
+		}
+		if err := os.Link(existsF, newF); err != nil {
+			existsFInfo, existsStatErr := os.Stat(existsF)
+			newFInfo, newStatErr := os.Stat(newF)
+			if existsStatErr != nil || newStatErr != nil || !os.SameFile(existsFInfo, newFInfo) {
+				return fmt.Errorf("link %s -> %s error: %v, existsStatErr: %v newStatErr: %v", existsF, newF, err, existsStatErr, newStatErr)
+			}
+		}
+	}
+	return nil
+}
+
 func (b *Backuper) makePartHardlinks(exists, new string) error {
 	log := apexLog.WithField("logger", "makePartHardlinks")
 	_, err := os.Stat(exists)
@@ -1069,7 +1215,7 @@ func (b *Backuper) downloadSingleBackupFile(ctx context.Context, remoteFile stri
 		return nil
 	}
 	log := b.log.WithField("logger", "downloadSingleBackupFile")
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := b.newRetrier()
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
 		remoteReader, err := b.dst.GetFileReader(ctx, remoteFile)
 		if err != nil {
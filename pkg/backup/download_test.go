@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
@@ -136,7 +137,7 @@ func TestReBalanceTablesMetadataIfDiskNotExists_Files_NoErrors(t *testing.T) {
 	for i := range tableMetadataAfterDownload {
 		tableMetadataAfterDownloadRepacked[i] = &tableMetadataAfterDownload[i]
 	}
-	assert.NoError(t, b.reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownloadRepacked, baseDisks, remoteBackup, log))
+	assert.NoError(t, b.reBalanceTablesMetadataIfDiskNotExists(context.Background(), tableMetadataAfterDownloadRepacked, baseDisks, remoteBackup, log))
 	//rebalanced table
 	meta := tableMetadataAfterDownload[1]
 	assert.Equal(t, 4, len(meta.RebalancedFiles), "expect 4 rebalanced files in %s.%s", meta.Database, meta.Table)
@@ -191,7 +192,7 @@ func TestReBalanceTablesMetadataIfDiskNotExists_Parts_NoErrors(t *testing.T) {
 	for i := range tableMetadataAfterDownload {
 		tableMetadataAfterDownloadRepacked[i] = &tableMetadataAfterDownload[i]
 	}
-	assert.NoError(t, b.reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownloadRepacked, baseDisks, remoteBackup, log))
+	assert.NoError(t, b.reBalanceTablesMetadataIfDiskNotExists(context.Background(), tableMetadataAfterDownloadRepacked, baseDisks, remoteBackup, log))
 	// no files re-balance
 	for _, meta := range tableMetadataAfterDownload {
 		assert.Equal(t, 0, len(meta.RebalancedFiles))
@@ -237,7 +238,7 @@ func TestReBalanceTablesMetadataIfDiskNotExists_CheckErrors(t *testing.T) {
 	for i := range tableMetadataAfterDownload {
 		tableMetadataAfterDownloadRepacked[i] = &tableMetadataAfterDownload[i]
 	}
-	err := b.reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownloadRepacked, baseDisks, invalidRemoteBackup, log)
+	err := b.reBalanceTablesMetadataIfDiskNotExists(context.Background(), tableMetadataAfterDownloadRepacked, baseDisks, invalidRemoteBackup, log)
 	assert.Error(t, err)
 	assert.Equal(t,
 		"disk: hdd2 not found in disk_types section map[string]string{\"default\":\"local\", \"s3\":\"s3\", \"s3_disk2\":\"s3\"} in Test/metadata.json",
@@ -250,7 +251,7 @@ func TestReBalanceTablesMetadataIfDiskNotExists_CheckErrors(t *testing.T) {
 	for i := range tableMetadataAfterDownload {
 		tableMetadataAfterDownloadRepacked[i] = &tableMetadataAfterDownload[i]
 	}
-	err = b.reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownloadRepacked, baseDisks, invalidRemoteBackup, log)
+	err = b.reBalanceTablesMetadataIfDiskNotExists(context.Background(), tableMetadataAfterDownloadRepacked, baseDisks, invalidRemoteBackup, log)
 	assert.Error(t, err)
 	assert.Equal(t, "disk: hdd2, diskType: unknown not found in system.disks", err.Error())
 
@@ -266,7 +267,7 @@ func TestReBalanceTablesMetadataIfDiskNotExists_CheckErrors(t *testing.T) {
 	invalidTable.Table = "test3"
 	invalidTable.Query = "CREATE TABLE default.test3(id UInt64) ENGINE=MergeTree() ORDER BY id SETTINGS storage_policy='invalid'"
 	tableMetadataAfterDownloadRepacked = []*metadata.TableMetadata{&invalidTable}
-	err = b.reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownloadRepacked, baseDisks, invalidRemoteBackup, log)
+	err = b.reBalanceTablesMetadataIfDiskNotExists(context.Background(), tableMetadataAfterDownloadRepacked, baseDisks, invalidRemoteBackup, log)
 	assert.Error(t, err)
 	matched, matchErr := regexp.MatchString(`storagePolicy: invalid with diskType: \w+ not found in system.disks`, err.Error())
 	assert.NoError(t, matchErr)
@@ -282,8 +283,20 @@ func TestReBalanceTablesMetadataIfDiskNotExists_CheckErrors(t *testing.T) {
 		"hdd2":    {{Name: "part_3_3_0"}, {Name: "part_4_4_0"}},
 	}
 	tableMetadataAfterDownloadRepacked = []*metadata.TableMetadata{&invalidTable}
-	err = b.reBalanceTablesMetadataIfDiskNotExists(tableMetadataAfterDownloadRepacked, invalidDisks, invalidRemoteBackup, log)
+	err = b.reBalanceTablesMetadataIfDiskNotExists(context.Background(), tableMetadataAfterDownloadRepacked, invalidDisks, invalidRemoteBackup, log)
 	assert.Error(t, err)
 	assert.Equal(t, "250B free space, not found in system.disks with `local` type", err.Error())
 
 }
+
+func TestGetPartialDownload(t *testing.T) {
+	remoteTables := []metadata.TableTitle{{Database: "db", Table: "t1"}, {Database: "db", Table: "t2"}}
+	assert.Nil(t, getPartialDownload(remoteTables, remoteTables, "", nil, false))
+	assert.Nil(t, getPartialDownload(remoteTables, remoteTables, "db.*", nil, false))
+
+	partial := getPartialDownload(remoteTables, remoteTables[:1], "db.t1", []string{"202401", "202402"}, false)
+	assert.Equal(t, &metadata.PartialDownload{Tables: "db.t1", Partitions: []string{"202401", "202402"}, RemoteTables: 2}, partial)
+	assert.Equal(t, "--tables=db.t1 --partitions=202401,202402", partial.String())
+
+	assert.Equal(t, "--schema", getPartialDownload(remoteTables, remoteTables, "", nil, true).String())
+}
@@ -0,0 +1,652 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+)
+
+// dryRunSampleSize - how many bytes of each table data compressed to estimate upload size
+const dryRunSampleSize = 1024 * 1024
+
+// WithDryRun - create, upload, delete and restore print planned actions to out instead of execution
+func WithDryRun(out io.Writer) BackuperOpt {
+	return func(b *Backuper) {
+		b.dryRun = out
+	}
+}
+
+// dryRunAction - Size is bytes which will be frozen, uploaded or deleted, 0 when unknown
+type dryRunAction struct {
+	Action  string
+	Object  string
+	Size    uint64
+	Details string
+}
+
+// dryRunPlan - actions in the same order as command would execute them
+type dryRunPlan struct {
+	Actions []dryRunAction
+}
+
+func (p *dryRunPlan) add(action, object string, size uint64, details string) {
+	p.Actions = append(p.Actions, dryRunAction{Action: action, Object: object, Size: size, Details: details})
+}
+
+// Print - one line for each action, and count with total size for each kind of action
+func (p *dryRunPlan) Print(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	kinds := make([]string, 0)
+	counts := map[string]int{}
+	sizes := map[string]uint64{}
+	for _, action := range p.Actions {
+		size := ""
+		if action.Size > 0 {
+			size = utils.FormatBytes(action.Size)
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", action.Action, action.Object, size, action.Details); err != nil {
+			return err
+		}
+		if _, exists := counts[action.Action]; !exists {
+			kinds = append(kinds, action.Action)
+		}
+		counts[action.Action]++
+		sizes[action.Action] += action.Size
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	summary := make([]string, len(kinds))
+	for i, kind := range kinds {
+		summary[i] = fmt.Sprintf("%s %d (%s)", kind, counts[kind], utils.FormatBytes(sizes[kind]))
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "no actions")
+	}
+	_, err := fmt.Fprintf(out, "dry run, nothing changed: %s\n", strings.Join(summary, ", "))
+	return err
+}
+
+// planCreate - tables which will be frozen, or backed up with BACKUP when use_embedded_backup_restore, and old local backups which will be deleted after create
+func (b *Backuper) planCreate(ctx context.Context, backupName string, tables []clickhouse.Table, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, doBackupData, createRBAC, createConfigs bool, disks []clickhouse.Disk) error {
+	backupPath := path.Join(b.DefaultDataPath, "backup", backupName)
+	action := "freeze"
+	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		action = "backup"
+		for _, disk := range disks {
+			if disk.Name == b.cfg.ClickHouse.EmbeddedBackupDisk {
+				backupPath = path.Join(disk.Path, backupName)
+			}
+		}
+	}
+	if _, err := os.Stat(path.Join(backupPath, "metadata.json")); err == nil {
+		return fmt.Errorf("'%s' medatata.json already exists", backupName)
+	}
+	type partsSize struct {
+		Database    string `ch:"database"`
+		Table       string `ch:"table"`
+		Disk        string `ch:"disk_name"`
+		PartitionId string `ch:"partition_id"`
+		Parts       uint64 `ch:"parts"`
+		Bytes       uint64 `ch:"bytes"`
+	}
+	partsSizes := make([]partsSize, 0)
+	if doBackupData {
+		if err := b.ch.SelectContext(ctx, &partsSizes, "SELECT database, table, disk_name, partition_id, count() AS parts, sum(bytes_on_disk) AS bytes FROM system.parts WHERE active GROUP BY database, table, disk_name, partition_id"); err != nil {
+			return fmt.Errorf("can't get data parts size from system.parts: %v", err)
+		}
+	}
+	partsSizesByTable := map[metadata.TableTitle][]partsSize{}
+	for _, size := range partsSizes {
+		tableTitle := metadata.TableTitle{Database: size.Database, Table: size.Table}
+		partsSizesByTable[tableTitle] = append(partsSizesByTable[tableTitle], size)
+	}
+	plan := &dryRunPlan{}
+	for _, table := range tables {
+		tableName := fmt.Sprintf("%s.%s", table.Database, table.Name)
+		if table.Skip {
+			plan.add("skip", tableName, 0, "clickhouse->skip_tables or clickhouse->skip_table_engines")
+			continue
+		}
+		tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Name}
+		partsCount, size := uint64(0), uint64(0)
+		tableDisks := make([]string, 0)
+		if doBackupData && table.BackupType == clickhouse.ShardBackupFull {
+			partitionsIds := partitionsIdMap[tableTitle]
+			for _, partitionSize := range partsSizesByTable[tableTitle] {
+				if len(partitionsIds) > 0 {
+					if _, isSelected := partitionsIds[partitionSize.PartitionId]; !isSelected {
+						continue
+					}
+				}
+				partsCount += partitionSize.Parts
+				size += partitionSize.Bytes
+				if !slices.Contains(tableDisks, partitionSize.Disk) {
+					tableDisks = append(tableDisks, partitionSize.Disk)
+				}
+			}
+		}
+		if partsCount == 0 {
+			plan.add("schema", tableName, 0, table.Engine)
+			continue
+		}
+		sort.Strings(tableDisks)
+		plan.add(action, tableName, size, fmt.Sprintf("%s, %d parts on %s", table.Engine, partsCount, strings.Join(tableDisks, ",")))
+	}
+	if createRBAC {
+		plan.add("rbac", "access", 0, "users, roles, quotas, row and settings policies")
+	}
+	if createConfigs {
+		plan.add("configs", "configs", 0, b.cfg.ClickHouse.ConfigDir)
+	}
+	if b.cfg.General.KeeperBackup && doBackupData {
+		plan.add("keeper", "keeper", 0, "znodes of replicated tables and user directories")
+	}
+	oldBackups, _, err := b.getOldBackupsLocal(ctx, true, disks, LocalBackup{BackupMetadata: metadata.BackupMetadata{BackupName: backupName, CreationDate: time.Now()}})
+	if err != nil {
+		return err
+	}
+	for _, backup := range oldBackups {
+		plan.add("delete local", backup.BackupName, backup.DataSize+backup.MetadataSize, fmt.Sprintf("general->backups_to_keep_local=%d", b.cfg.General.BackupsToKeepLocal))
+	}
+	return plan.Print(b.dryRun)
+}
+
+// planUpload - tables data which will be uploaded with compressed size estimated by compression of data sample, and retention which will apply after upload
+func (b *Backuper) planUpload(ctx context.Context, backupMetadata *metadata.BackupMetadata, tablesForUpload ListOfTables, tablesForUploadFromDiff map[metadata.TableTitle]metadata.TableMetadata, diffFrom, diffFromRemote string, schemaOnly, deleteSource bool, disks []clickhouse.Disk) error {
+	backupName := backupMetadata.BackupName
+	plan := &dryRunPlan{}
+	for _, table := range tablesForUpload {
+		tableName := fmt.Sprintf("%s.%s", table.Database, table.Table)
+		if schemaOnly || table.MetadataOnly || (b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk == "") {
+			plan.add("upload", tableName, 0, "metadata only")
+			continue
+		}
+		if diffTable, diffExists := tablesForUploadFromDiff[metadata.TableTitle{Database: table.Database, Table: table.Table}]; diffExists {
+			b.markDuplicatedParts(backupMetadata, &diffTable, &table, diffFrom != "" && diffFromRemote == "")
+		}
+		size, uploadParts, requiredParts, sample := b.sampleTableDataLocal(backupName, table)
+		var err error
+		estimatedSize := size
+		// general->table_compression_format uploads table without compression
+		if b.getTableDataFormat(table) == "" {
+			if estimatedSize, err = b.dst.EstimateCompressedSize(size, sample); err != nil {
+				return fmt.Errorf("can't estimate compressed size of %s: %v", tableName, err)
+			}
+		}
+		details := fmt.Sprintf("%d parts, %s before compression", uploadParts, utils.FormatBytes(uint64(size)))
+		if requiredParts > 0 {
+			details += fmt.Sprintf(", %d parts required from %s", requiredParts, backupMetadata.RequiredBackup)
+		}
+		plan.add("upload", tableName, uint64(estimatedSize), details)
+		if err = b.planUploadArchives(plan, backupName, table, size, estimatedSize); err != nil {
+			return err
+		}
+	}
+	backupPath := path.Join(b.DefaultDataPath, "backup", backupName)
+	if b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
+		backupPath = path.Join(b.EmbeddedBackupDataPath, backupName)
+	}
+	for _, relatedDir := range []string{"access", "configs", "keeper"} {
+		if size := localDirSize(path.Join(backupPath, relatedDir)); size > 0 {
+			plan.add("upload", relatedDir, uint64(size), "")
+		}
+	}
+	if b.cfg.General.BackupsToKeepRemote > 0 || len(b.cfg.General.RetentionPolicies) > 0 {
+		backupList, err := b.dst.BackupList(ctx, true, "")
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		backupList = append(backupList, storage.Backup{BackupMetadata: *backupMetadata, UploadDate: now})
+		rebaseTargets := map[string]string{}
+		if b.cfg.General.RetentionRebaseIncrements {
+			rebaseTargets = b.planRebaseIncrementalBackups(ctx, backupList, now)
+		}
+		for _, backup := range backupList {
+			if newRequired, isRebased := rebaseTargets[backup.BackupName]; isRebased {
+				if newRequired == "" {
+					newRequired = "none"
+				}
+				plan.add("rebase remote", backup.BackupName, 0, fmt.Sprintf("required_backup %s -> %s", backup.RequiredBackup, newRequired))
+			}
+		}
+		backupsToDeleteByPolicy := storage.GetBackupsToDeleteRemoteByPolicies(applyRebaseTargets(backupList, rebaseTargets), b.cfg.General.BackupsToKeepRemote, b.cfg.General.RetentionPolicies, now)
+		policyNames := make([]string, 0, len(backupsToDeleteByPolicy))
+		for policyName := range backupsToDeleteByPolicy {
+			policyNames = append(policyNames, policyName)
+		}
+		sort.Strings(policyNames)
+		for _, policyName := range policyNames {
+			for _, backup := range backupsToDeleteByPolicy[policyName] {
+				plan.add("delete remote", backup.BackupName, remoteBackupSize(backup), fmt.Sprintf("retention policy %s", policyName))
+			}
+		}
+	}
+	oldBackups, _, err := b.getOldBackupsLocal(ctx, false, disks)
+	if err != nil {
+		return err
+	}
+	deleteSourcePlanned := false
+	for _, backup := range oldBackups {
+		deleteSourcePlanned = deleteSourcePlanned || backup.BackupName == backupName
+		plan.add("delete local", backup.BackupName, backup.DataSize+backup.MetadataSize, fmt.Sprintf("general->backups_to_keep_local=%d", b.cfg.General.BackupsToKeepLocal))
+	}
+	if b.cfg.General.BackupsToKeepLocal >= 0 && deleteSource && !deleteSourcePlanned {
+		plan.add("delete local", backupName, backupMetadata.DataSize+backupMetadata.MetadataSize, "--delete-source")
+	}
+	return plan.Print(b.dryRun)
+}
+
+// planUploadArchives - archives which uploadTableData will create for each disk with the same split by general->max_file_size or upload_part_archive_size,
+// compressed size of each archive estimated with the same ratio as the whole table
+func (b *Backuper) planUploadArchives(plan *dryRunPlan, backupName string, table metadata.TableMetadata, tableSize, estimatedSize int64) error {
+	ratio := 1.0
+	if tableSize > 0 {
+		ratio = float64(estimatedSize) / float64(tableSize)
+	}
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	baseRemoteDataPath := path.Join(backupName, "shadow", dbAndTablePath)
+	compressionFormat := b.cfg.GetTableCompressionFormat(table.Database, table.Table)
+	disks := make([]string, 0, len(table.Parts))
+	for disk := range table.Parts {
+		disks = append(disks, disk)
+	}
+	sort.Strings(disks)
+	archiveSize := b.getTableArchiveSize(backupName, table)
+	for _, disk := range disks {
+		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+		splitParts, err := b.splitPartFiles(backupPath, table.Parts[disk], compressionFormat, archiveSize)
+		if err != nil {
+			return fmt.Errorf("can't split %s.%s files on disk %s: %v", table.Database, table.Table, disk, err)
+		}
+		for _, splitPart := range splitParts {
+			size := int64(0)
+			for _, f := range splitPart.Files {
+				if info, statErr := os.Stat(path.Join(backupPath, f)); statErr == nil {
+					size += info.Size()
+				}
+			}
+			remoteKey := path.Join(baseRemoteDataPath, getArchiveFileName(disk, splitPart.Prefix, compressionFormat))
+			if compressionFormat == "none" {
+				remoteKey = path.Join(baseRemoteDataPath, disk, splitPart.Prefix)
+			}
+			plan.add("archive", remoteKey, uint64(float64(size)*ratio), fmt.Sprintf("%d files, %s before compression", len(splitPart.Files), utils.FormatBytes(uint64(size))))
+		}
+	}
+	return nil
+}
+
+// sampleTableDataLocal - size of files which will be uploaded, parts which will be uploaded and required from diff backup, and first dryRunSampleSize bytes of files
+func (b *Backuper) sampleTableDataLocal(backupName string, table metadata.TableMetadata) (int64, int, int, []byte) {
+	log := b.log.WithField("logger", "sampleTableDataLocal")
+	size, uploadParts, requiredParts := int64(0), 0, 0
+	sample := make([]byte, 0)
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for disk, parts := range table.Parts {
+		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+		for _, part := range parts {
+			if part.Required {
+				requiredParts++
+				continue
+			}
+			uploadParts++
+			partPath := path.Join(backupPath, part.Name)
+			walkErr := filepath.Walk(partPath, func(filePath string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.Mode().IsRegular() || isRequiredFile(partPath, filePath, part) {
+					return nil
+				}
+				size += info.Size()
+				if len(sample) >= dryRunSampleSize {
+					return nil
+				}
+				f, err := os.Open(filePath)
+				if err != nil {
+					return err
+				}
+				fileSample, err := io.ReadAll(io.LimitReader(f, int64(dryRunSampleSize-len(sample))))
+				if closeErr := f.Close(); closeErr != nil {
+					log.Warnf("can't close %s: %v", filePath, closeErr)
+				}
+				if err != nil {
+					return err
+				}
+				sample = append(sample, fileSample...)
+				return nil
+			})
+			if walkErr != nil {
+				log.Warnf("filepath.Walk return error: %v", walkErr)
+			}
+		}
+	}
+	return size, uploadParts, requiredParts, sample
+}
+
+// planDeleteLocal - backup directories on each disk, object disk data could be deleted when the same remote backup is not present
+func (b *Backuper) planDeleteLocal(ctx context.Context, backupName string) error {
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	backup, disks, err := b.getLocalBackup(ctx, backupName, nil)
+	if err != nil {
+		return fmt.Errorf("'%s' is not found on local storage", backupName)
+	}
+	plan := &dryRunPlan{}
+	for _, disk := range disks {
+		backupPath := path.Join(disk.Path, "backup", backupName)
+		if disk.IsBackup {
+			backupPath = path.Join(disk.Path, backupName)
+		}
+		if _, err = os.Stat(backupPath); err != nil {
+			continue
+		}
+		plan.add("delete local", backupPath, uint64(localDirSize(backupPath)), "disk "+disk.Name)
+	}
+	if b.hasObjectDisksLocal([]LocalBackup{*backup}, backupName, disks) || (strings.Contains(backup.Tags, "embedded") && b.cfg.ClickHouse.EmbeddedBackupDisk == "") {
+		plan.add("delete object disk", backupName, 0, "when the same remote backup is not present")
+	}
+	for _, snapshot := range backup.CloudSnapshots {
+		plan.add("delete cloud snapshot", snapshot.SnapshotId, 0, "when the same remote backup is not present")
+	}
+	return plan.Print(b.dryRun)
+}
+
+// planDeleteRemote - remote backup with backups which require it, they can't be restored after delete
+func (b *Backuper) planDeleteRemote(ctx context.Context, backupName string) error {
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("--dry-run is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupList, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	requiredBy := make([]string, 0)
+	for _, backup := range backupList {
+		if backup.RequiredBackup == backupName {
+			requiredBy = append(requiredBy, backup.BackupName)
+		}
+	}
+	for _, backup := range backupList {
+		if backup.BackupName != backupName {
+			continue
+		}
+		details := backup.DataFormat
+		if backup.Broken != "" {
+			details = backup.Broken
+		}
+		if len(requiredBy) > 0 {
+			details = fmt.Sprintf("required by %s, they can't be restored after delete", strings.Join(requiredBy, ", "))
+		}
+		if lockErr := bd.CheckBackupLock(ctx, backupName); lockErr != nil {
+			details = fmt.Sprintf("delete will fail: %v", lockErr)
+		}
+		plan := &dryRunPlan{}
+		plan.add("delete remote", backupName, remoteBackupSize(backup), details)
+		if b.hasObjectDisksRemote(backup) || strings.Contains(backup.Tags, "embedded") {
+			plan.add("delete object disk", backupName, 0, "when the same local backup is not present")
+		}
+		for _, snapshot := range backup.CloudSnapshots {
+			plan.add("delete cloud snapshot", snapshot.SnapshotId, 0, "when the same local backup is not present")
+		}
+		return plan.Print(b.dryRun)
+	}
+	return fmt.Errorf("'%s' is not found on remote storage", backupName)
+}
+
+// planRestore - databases and tables which will be created or replaced, existing tables with different schema, partitions which will be attached and data size for each disk,
+// ClickHouse is only queried by SELECT from system tables
+func (b *Backuper) planRestore(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, tablePattern string, partitions []string, schemaOnly, dataOnly, dropExists, restoreRBAC, rbacOnly, restoreConfigs, configsOnly bool, disks []clickhouse.Disk) error {
+	doRestoreSchema := schemaOnly || dropExists || (schemaOnly == dataOnly && !rbacOnly && !configsOnly)
+	doRestoreData := dataOnly || (schemaOnly == dataOnly && !rbacOnly && !configsOnly)
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	plan := &dryRunPlan{}
+	if schemaOnly || doRestoreData {
+		existingDatabases := make([]string, 0)
+		if err := b.ch.SelectContext(ctx, &existingDatabases, "SELECT name FROM system.databases"); err != nil {
+			return fmt.Errorf("can't get databases from system.databases: %v", err)
+		}
+		for _, database := range backupMetadata.Databases {
+			targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[database.Name]
+			if !isMapped {
+				targetDB = database.Name
+			}
+			if IsInformationSchema(targetDB) || ShallSkipDatabase(b.cfg, targetDB, tablePattern) {
+				continue
+			}
+			if !slices.Contains(existingDatabases, targetDB) {
+				plan.add("create database", targetDB, 0, database.Engine)
+			} else if schemaOnly && dropExists {
+				plan.add("replace database", targetDB, 0, "--rm with --schema drops database with all tables")
+			}
+		}
+	}
+	var tablesForRestore ListOfTables
+	if !rbacOnly && !configsOnly {
+		metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+		if b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
+			metadataPath = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata")
+		}
+		var err error
+		if tablesForRestore, _, err = b.getTablesForRestoreLocal(ctx, backupName, metadataPath, tablePattern, dropExists, partitions); err != nil {
+			return err
+		}
+	}
+	if b.convertReplicated {
+		convertReplicatedTables(tablesForRestore)
+	}
+	if len(b.cfg.General.RestoreSchemaRewriteRules) > 0 && !b.isEmbedded {
+		for i, table := range tablesForRestore {
+			query, _, err := applyRestoreSchemaRewriteRules(b.cfg.General.RestoreSchemaRewriteRules, table.Database, table.Table, table.Query)
+			if err != nil {
+				return err
+			}
+			tablesForRestore[i].Query = query
+		}
+	}
+	existingQueries, err := b.getExistingCreateQueries(ctx, tablesForRestore)
+	if err != nil {
+		return err
+	}
+	diskTypes := make(map[string]string, len(disks))
+	b.DiskToPathMap = make(map[string]string, len(disks))
+	for _, disk := range disks {
+		b.DiskToPathMap[disk.Name] = disk.Path
+		diskTypes[disk.Name] = disk.Type
+	}
+	sourceDatabases := make(map[string]string, len(b.cfg.General.RestoreDatabaseMapping))
+	for sourceDB, targetDB := range b.cfg.General.RestoreDatabaseMapping {
+		sourceDatabases[targetDB] = sourceDB
+	}
+	disksParts, disksSizes := map[string]int{}, map[string]uint64{}
+	for _, table := range tablesForRestore {
+		tableName := fmt.Sprintf("%s.%s", table.Database, table.Table)
+		existingQuery, exists := existingQueries[metadata.TableTitle{Database: table.Database, Table: table.Table}]
+		if doRestoreSchema || doRestoreData {
+			action, details := restoreTableAction(table.Query, existingQuery, exists, doRestoreSchema, dropExists)
+			plan.add(action, tableName, 0, details)
+		}
+		if !doRestoreData || table.MetadataOnly {
+			continue
+		}
+		sourceDB, isMapped := sourceDatabases[table.Database]
+		if !isMapped {
+			sourceDB = table.Database
+		}
+		dbAndTablePath := path.Join(common.TablePathEncode(sourceDB), common.TablePathEncode(table.Table))
+		tableDisks := make([]string, 0, len(table.Parts))
+		for disk := range table.Parts {
+			tableDisks = append(tableDisks, disk)
+		}
+		sort.Strings(tableDisks)
+		for _, disk := range tableDisks {
+			parts := table.Parts[disk]
+			if len(parts) == 0 {
+				continue
+			}
+			diskType, diskExists := diskTypes[disk]
+			if !diskExists && parts[0].RebalancedDisk == "" {
+				plan.add("conflict", tableName, 0, fmt.Sprintf("disk %s is absent, %d parts can't be attached", disk, len(parts)))
+				continue
+			}
+			size := table.Size[disk]
+			// object disk parts contain only metadata locally, data size is known from backup
+			if !b.isEmbedded && !b.isDiskTypeObject(diskType) {
+				size = 0
+				backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+				for _, part := range parts {
+					size += localDirSize(path.Join(backupPath, part.Name))
+				}
+			}
+			disksParts[disk] += len(parts)
+			disksSizes[disk] += uint64(size)
+			plan.add("attach", tableName, uint64(size), fmt.Sprintf("disk %s, %d parts, partitions %s", disk, len(parts), strings.Join(getPartitionIds(parts), ",")))
+		}
+	}
+	diskNames := make([]string, 0, len(disksParts))
+	for disk := range disksParts {
+		diskNames = append(diskNames, disk)
+	}
+	sort.Strings(diskNames)
+	for _, disk := range diskNames {
+		plan.add("disk total", disk, disksSizes[disk], fmt.Sprintf("%d parts", disksParts[disk]))
+	}
+	if schemaOnly || (schemaOnly == dataOnly && !rbacOnly && !configsOnly) {
+		for _, function := range backupMetadata.Functions {
+			plan.add("create function", function.Name, 0, "")
+		}
+	}
+	if restoreRBAC || rbacOnly {
+		plan.add("rbac", "access", 0, "users, roles, quotas, row and settings policies, ClickHouse will be restarted")
+	}
+	if restoreConfigs || configsOnly {
+		plan.add("configs", "configs", 0, "ClickHouse will be restarted")
+	}
+	return plan.Print(b.dryRun)
+}
+
+// restoreTableAction - what restore will do with table, existing table is a conflict when CREATE will fail without --rm,
+// or when --data will attach parts into table with different schema, differences explained by diffCreateQueries
+func restoreTableAction(backupQuery, existingQuery string, exists, doRestoreSchema, dropExists bool) (string, string) {
+	var differences []string
+	if exists && backupQuery != "" && existingQuery != "" {
+		differences = diffCreateQueries(backupQuery, existingQuery, "current")
+	}
+	schemaDiff := "same schema"
+	if len(differences) > 0 {
+		schemaDiff = "schema differs, " + strings.Join(differences, ", ")
+	}
+	switch {
+	case !exists && doRestoreSchema:
+		return "create", ""
+	case !exists:
+		return "conflict", "table doesn't exist, data can't be attached"
+	case doRestoreSchema && dropExists:
+		return "replace", schemaDiff
+	case doRestoreSchema:
+		return "conflict", "table already exists, use --rm to replace it, " + schemaDiff
+	case len(differences) > 0:
+		return "conflict", schemaDiff
+	}
+	return "exists", schemaDiff
+}
+
+// getExistingCreateQueries - create_table_query of tables which already exist in databases of tablesForRestore,
+// hostname replica name is replaced with macros the same way as during `create`
+func (b *Backuper) getExistingCreateQueries(ctx context.Context, tablesForRestore ListOfTables) (map[metadata.TableTitle]string, error) {
+	existingQueries := map[metadata.TableTitle]string{}
+	hostnameMacros, err := b.ch.GetHostnameMacros(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't get hostname macros from clickhouse: %v", err)
+	}
+	queriedDatabases := map[string]bool{}
+	for _, table := range tablesForRestore {
+		if queriedDatabases[table.Database] {
+			continue
+		}
+		queriedDatabases[table.Database] = true
+		var rows []struct {
+			Name             string `ch:"name"`
+			CreateTableQuery string `ch:"create_table_query"`
+		}
+		if err := b.ch.SelectContext(ctx, &rows, "SELECT name, create_table_query FROM system.tables WHERE database=?", table.Database); err != nil {
+			return nil, fmt.Errorf("can't get tables of database `%s` from system.tables: %v", table.Database, err)
+		}
+		for _, row := range rows {
+			existingQueries[metadata.TableTitle{Database: table.Database, Table: row.Name}] = replaceReplicaName(row.CreateTableQuery, hostnameMacros)
+		}
+	}
+	return existingQueries, nil
+}
+
+// getPartitionIds - partition_id is a prefix of part name before first `_`
+func getPartitionIds(parts []metadata.Part) []string {
+	partitionIds := make([]string, 0)
+	for _, part := range parts {
+		partitionId := strings.Split(part.Name, "_")[0]
+		if !slices.Contains(partitionIds, partitionId) {
+			partitionIds = append(partitionIds, partitionId)
+		}
+	}
+	sort.Strings(partitionIds)
+	return partitionIds
+}
+
+// remoteBackupSize - the same size as `list remote` shows
+func remoteBackupSize(backup storage.Backup) uint64 {
+	if backup.CompressedSize > 0 {
+		return backup.CompressedSize + backup.MetadataSize
+	}
+	return backup.DataSize + backup.MetadataSize
+}
+
+func localDirSize(dir string) int64 {
+	size := int64(0)
+	_ = filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunPlanPrint(t *testing.T) {
+	plan := &dryRunPlan{}
+	plan.add("upload", "db.t1", 1024, "2 parts, 4KiB before compression")
+	plan.add("upload", "db.view", 0, "metadata only")
+	plan.add("delete remote", "old_backup", 2048, "retention policy default")
+	out := &bytes.Buffer{}
+	require.NoError(t, plan.Print(out))
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 4)
+	assert.Regexp(t, `^upload\s+db\.t1\s+1.00KiB\s+2 parts, 4KiB before compression$`, string(lines[0]))
+	assert.Regexp(t, `^upload\s+db\.view\s+metadata only$`, string(lines[1]))
+	assert.Equal(t, "dry run, nothing changed: upload 2 (1.00KiB), delete remote 1 (2.00KiB)", string(lines[3]))
+
+	out.Reset()
+	require.NoError(t, (&dryRunPlan{}).Print(out))
+	assert.Equal(t, "dry run, nothing changed: no actions\n", out.String())
+}
+
+func TestPlanUploadArchives(t *testing.T) {
+	dataPath := t.TempDir()
+	b := &Backuper{
+		cfg:           &config.Config{General: config.GeneralConfig{RemoteStorage: "sftp", MaxFileSize: 150}, SFTP: config.SFTPConfig{CompressionFormat: "tar"}},
+		DiskToPathMap: map[string]string{"default": dataPath},
+		log:           apexLog.WithField("logger", "test"),
+	}
+	table := metadata.TableMetadata{Database: "db", Table: "t1", Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}, {Name: "all_2_2_0"}}}}
+	for _, part := range table.Parts["default"] {
+		partPath := path.Join(dataPath, "backup", "test_backup", "shadow", "db", "t1", "default", part.Name)
+		require.NoError(t, os.MkdirAll(partPath, 0750))
+		require.NoError(t, os.WriteFile(path.Join(partPath, "data.bin"), make([]byte, 100), 0640))
+	}
+	plan := &dryRunPlan{}
+	require.NoError(t, b.planUploadArchives(plan, "test_backup", table, 200, 100))
+	// max_file_size=150 allow only one 100 bytes file in each archive
+	assert.Equal(t, []dryRunAction{
+		{Action: "archive", Object: "test_backup/shadow/db/t1/default_1.tar", Size: 50, Details: "1 files, 100B before compression"},
+		{Action: "archive", Object: "test_backup/shadow/db/t1/default_2.tar", Size: 50, Details: "1 files, 100B before compression"},
+	}, plan.Actions)
+}
+
+func TestRestoreTableAction(t *testing.T) {
+	backupQuery := "CREATE TABLE db.t1 UUID '11111111-1111-1111-1111-111111111111' (id UInt64) ENGINE = MergeTree ORDER BY id"
+	sameQuery := "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id"
+	otherQuery := "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY tuple()"
+
+	action, details := restoreTableAction(backupQuery, "", false, true, false)
+	assert.Equal(t, "create", action)
+	assert.Empty(t, details)
+
+	action, details = restoreTableAction(backupQuery, "", false, false, false)
+	assert.Equal(t, "conflict", action, "--data into absent table")
+	assert.Contains(t, details, "doesn't exist")
+
+	action, details = restoreTableAction(backupQuery, otherQuery, true, true, true)
+	assert.Equal(t, "replace", action)
+	assert.Equal(t, "schema differs, ORDER BY: backup [ORDER BY id] current [ORDER BY tuple()]", details)
+
+	action, details = restoreTableAction(backupQuery, sameQuery, true, true, false)
+	assert.Equal(t, "conflict", action, "CREATE fails without --rm")
+	assert.Contains(t, details, "use --rm")
+
+	action, details = restoreTableAction(backupQuery, otherQuery, true, false, false)
+	assert.Equal(t, "conflict", action, "--data into table with different schema")
+
+	action, details = restoreTableAction(backupQuery, sameQuery, true, false, false)
+	assert.Equal(t, "exists", action)
+	assert.Equal(t, "same schema", details)
+}
+
+func TestRestoreTableActionHostnameReplica(t *testing.T) {
+	hostnameMacros := map[string]string{"ch-0.cluster.local": "{replica}"}
+	backupQuery := "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id"
+	existingQuery := "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', 'ch-0.cluster.local') ORDER BY id"
+	action, _ := restoreTableAction(backupQuery, existingQuery, true, false, false)
+	assert.Equal(t, "conflict", action, "hostname is replaced with macros only in backup")
+	action, details := restoreTableAction(backupQuery, replaceReplicaName(existingQuery, hostnameMacros), true, false, false)
+	assert.Equal(t, "exists", action)
+	assert.Equal(t, "same schema", details)
+	assert.Empty(t, diffTableSchema(backupQuery, replaceReplicaName(existingQuery, hostnameMacros)))
+}
+
+func TestGetPartitionIds(t *testing.T) {
+	parts := []metadata.Part{{Name: "202402_3_3_0"}, {Name: "202401_1_1_0"}, {Name: "202401_2_2_0"}}
+	assert.Equal(t, []string{"202401", "202402"}, getPartitionIds(parts))
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+type diskUsageKey struct {
+	Backup string
+	Disk   string
+	Table  string
+}
+
+type diskUsage struct {
+	diskUsageKey
+	// Size - each hardlinked file counted once per backup
+	Size uint64
+	// Exclusive - will free after delete backup, file is not hardlinked to clickhouse data parts or other backups
+	Exclusive uint64
+}
+
+type diskUsageInode struct {
+	size      uint64
+	nlink     uint64
+	seenLinks uint64
+	key       diskUsageKey
+	backups   map[string]struct{}
+}
+
+type diskUsageCalculator struct {
+	usage  map[diskUsageKey]*diskUsage
+	inodes map[[2]uint64]*diskUsageInode
+}
+
+func newDiskUsageCalculator() *diskUsageCalculator {
+	return &diskUsageCalculator{
+		usage:  map[diskUsageKey]*diskUsage{},
+		inodes: map[[2]uint64]*diskUsageInode{},
+	}
+}
+
+// diskUsageTable - shadow/<db>/<table>/... and metadata/<db>/<table>.json belongs to table, other backup files belongs to `-`
+func diskUsageTable(relativePath string) string {
+	parts := strings.Split(filepath.ToSlash(relativePath), "/")
+	if len(parts) < 3 || (parts[0] != "shadow" && parts[0] != "metadata") {
+		return "-"
+	}
+	table := parts[2]
+	if parts[0] == "metadata" {
+		table = strings.TrimSuffix(table, ".json")
+	}
+	if decoded, err := url.PathUnescape(parts[1]); err == nil {
+		parts[1] = decoded
+	}
+	if decoded, err := url.PathUnescape(table); err == nil {
+		table = decoded
+	}
+	return parts[1] + "." + table
+}
+
+func (c *diskUsageCalculator) walk(ctx context.Context, backupName, diskName, backupPath string) error {
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.WalkDir(backupPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(backupPath, filePath)
+		if err != nil {
+			return err
+		}
+		key := diskUsageKey{Backup: backupName, Disk: diskName, Table: diskUsageTable(relativePath)}
+		usage, exists := c.usage[key]
+		if !exists {
+			usage = &diskUsage{diskUsageKey: key}
+			c.usage[key] = usage
+		}
+		stat, isStat := info.Sys().(*syscall.Stat_t)
+		if !isStat {
+			usage.Size += uint64(info.Size())
+			usage.Exclusive += uint64(info.Size())
+			return nil
+		}
+		inodeId := [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}
+		inode, exists := c.inodes[inodeId]
+		if !exists {
+			inode = &diskUsageInode{size: uint64(info.Size()), nlink: uint64(stat.Nlink), key: key, backups: map[string]struct{}{}}
+			c.inodes[inodeId] = inode
+		}
+		inode.seenLinks++
+		if _, exists = inode.backups[backupName]; !exists {
+			inode.backups[backupName] = struct{}{}
+			usage.Size += inode.size
+		}
+		return nil
+	})
+}
+
+// result - when all hardlinks of file are inside one backup, then file is exclusive
+func (c *diskUsageCalculator) result(backupOrder map[string]int) []diskUsage {
+	for _, inode := range c.inodes {
+		if inode.seenLinks >= inode.nlink && len(inode.backups) == 1 {
+			c.usage[inode.key].Exclusive += inode.size
+		}
+	}
+	result := make([]diskUsage, 0, len(c.usage))
+	for _, usage := range c.usage {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Backup != result[j].Backup {
+			return backupOrder[result[i].Backup] < backupOrder[result[j].Backup]
+		}
+		if result[i].Disk != result[j].Disk {
+			return result[i].Disk < result[j].Disk
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
+
+// DiskUsage - print local disk usage by backup, disk and table with hardlinks accounting, `exclusive` column shows space which will free after delete backup
+func (b *Backuper) DiskUsage(backupName string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "du",
+	})
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	backupList, disks, err := b.GetLocalBackups(ctx, nil)
+	if err != nil {
+		return err
+	}
+	calculator := newDiskUsageCalculator()
+	backupOrder := map[string]int{}
+	for i, backup := range backupList {
+		if backupName != "" && backup.BackupName != backupName {
+			continue
+		}
+		backupOrder[backup.BackupName] = i
+		for _, disk := range disks {
+			backupPath := path.Join(disk.Path, "backup", backup.BackupName)
+			if disk.IsBackup || disk.Name == b.cfg.ClickHouse.EmbeddedBackupDisk {
+				backupPath = path.Join(disk.Path, backup.BackupName)
+			}
+			if err = calculator.walk(ctx, backup.BackupName, disk.Name, backupPath); err != nil {
+				return fmt.Errorf("can't calculate disk usage for %s: %v", backupPath, err)
+			}
+		}
+	}
+	if backupName != "" && len(backupOrder) == 0 {
+		return fmt.Errorf("'%s' is not found on local storage", backupName)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", "backup", "disk", "table", "size", "exclusive"); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	totals := map[string]*diskUsage{}
+	for _, usage := range calculator.result(backupOrder) {
+		if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", usage.Backup, usage.Disk, usage.Table, utils.FormatBytes(usage.Size), utils.FormatBytes(usage.Exclusive)); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+		if _, exists := totals[usage.Backup]; !exists {
+			totals[usage.Backup] = &diskUsage{}
+		}
+		totals[usage.Backup].Size += usage.Size
+		totals[usage.Backup].Exclusive += usage.Exclusive
+	}
+	for _, backup := range backupList {
+		if total, exists := totals[backup.BackupName]; exists {
+			if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", backup.BackupName, "*", "*", utils.FormatBytes(total.Size), utils.FormatBytes(total.Exclusive)); err != nil {
+				log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+			}
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskUsageTable(t *testing.T) {
+	assert.Equal(t, "db.table-1", diskUsageTable("shadow/db/table%2D1/default/all_1_1_0/data.bin"))
+	assert.Equal(t, "db.table", diskUsageTable("metadata/db/table.json"))
+	assert.Equal(t, "-", diskUsageTable("metadata.json"))
+	assert.Equal(t, "-", diskUsageTable("access/users.list"))
+}
+
+func TestDiskUsageCalculator(t *testing.T) {
+	dataPath := t.TempDir()
+	partPath := func(backupName string) string {
+		return path.Join(dataPath, "backup", backupName, "shadow", "db", "table", "default", "all_1_1_0")
+	}
+	storePath := path.Join(dataPath, "store")
+	for _, dir := range []string{partPath("backup1"), partPath("backup2"), storePath} {
+		require.NoError(t, os.MkdirAll(dir, 0750))
+	}
+	// shared between backups
+	require.NoError(t, os.WriteFile(path.Join(partPath("backup1"), "shared.bin"), make([]byte, 100), 0640))
+	require.NoError(t, os.Link(path.Join(partPath("backup1"), "shared.bin"), path.Join(partPath("backup2"), "shared.bin")))
+	// shared with clickhouse data
+	require.NoError(t, os.WriteFile(path.Join(storePath, "data.bin"), make([]byte, 10), 0640))
+	require.NoError(t, os.Link(path.Join(storePath, "data.bin"), path.Join(partPath("backup2"), "data.bin")))
+	// exclusive
+	require.NoError(t, os.WriteFile(path.Join(partPath("backup2"), "exclusive.bin"), make([]byte, 1), 0640))
+
+	calculator := newDiskUsageCalculator()
+	for _, backupName := range []string{"backup1", "backup2"} {
+		require.NoError(t, calculator.walk(context.Background(), backupName, "default", path.Join(dataPath, "backup", backupName)))
+	}
+	result := calculator.result(map[string]int{"backup1": 0, "backup2": 1})
+	require.Len(t, result, 2)
+	assert.Equal(t, diskUsage{diskUsageKey: diskUsageKey{Backup: "backup1", Disk: "default", Table: "db.table"}, Size: 100, Exclusive: 0}, result[0])
+	assert.Equal(t, diskUsage{diskUsageKey: diskUsageKey{Backup: "backup2", Disk: "default", Table: "db.table"}, Size: 111, Exclusive: 1}, result[1])
+}
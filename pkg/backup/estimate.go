@@ -0,0 +1,264 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/partition"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// estimateHistoryBackups - how many latest full remote backups used to calculate compression ratio
+const estimateHistoryBackups = 10
+
+// compact part contains data.bin, data.cmrk*, checksums.txt, columns.txt, count.txt, primary.idx, default_compression_codec.txt
+// wide part contains .bin and .mrk* for each column and the same service files except data.bin and data.cmrk*
+const (
+	estimateCompactPartFiles = 7
+	estimateWidePartFiles    = 5
+)
+
+type estimatePartsGroup struct {
+	Database    string `ch:"database"`
+	Table       string `ch:"table"`
+	Disk        string `ch:"disk_name"`
+	PartitionId string `ch:"partition_id"`
+	PartType    string `ch:"part_type"`
+	Parts       uint64 `ch:"parts"`
+	Bytes       uint64 `ch:"bytes"`
+}
+
+type tableEstimate struct {
+	Table      string
+	Parts      uint64
+	Size       uint64
+	Compressed uint64
+	Objects    uint64
+}
+
+// estimatePartFiles - approximate files count in one data part, wide part contains separate files for each column
+func estimatePartFiles(partType string, columns uint64) uint64 {
+	if partType == "Wide" {
+		return estimateWidePartFiles + 2*columns
+	}
+	return estimateCompactPartFiles
+}
+
+// estimateDataObjects - remote objects count for parts of one table on one disk, split the same way as splitFilesByName and splitFilesBySize do
+func estimateDataObjects(cfg config.GeneralConfig, compressionFormat string, groups []estimatePartsGroup, columns uint64) uint64 {
+	objects, files, bytes := uint64(0), uint64(0), uint64(0)
+	for _, group := range groups {
+		if group.Parts == 0 {
+			continue
+		}
+		partFiles := estimatePartFiles(group.PartType, columns)
+		files += group.Parts * partFiles
+		bytes += group.Bytes
+		archives := uint64(1)
+		partBytes := group.Bytes / group.Parts
+		if cfg.UploadPartArchiveSize > 0 && partBytes > uint64(cfg.UploadPartArchiveSize) {
+			archives = (partBytes + uint64(cfg.UploadPartArchiveSize) - 1) / uint64(cfg.UploadPartArchiveSize)
+			if cfg.UploadPartMaxArchives > 0 && archives > uint64(cfg.UploadPartMaxArchives) {
+				archives = uint64(cfg.UploadPartMaxArchives)
+			}
+			if archives > partFiles {
+				archives = partFiles
+			}
+		}
+		objects += group.Parts * archives
+	}
+	if compressionFormat == "none" {
+		return files
+	}
+	if !cfg.UploadByPart {
+		if bytes == 0 {
+			return 0
+		}
+		if cfg.MaxFileSize <= 0 {
+			return 1
+		}
+		archives := (bytes + uint64(cfg.MaxFileSize) - 1) / uint64(cfg.MaxFileSize)
+		if cfg.UploadMaxTableArchives > 0 && archives > uint64(cfg.UploadMaxTableArchives) {
+			archives = uint64(cfg.UploadMaxTableArchives)
+		}
+		return archives
+	}
+	return objects
+}
+
+// estimateCompressionRatio - compressed_size / data_size of latest full remote backups with the same data_format, increments are skipped cause their compressed_size contains only uploaded parts
+func estimateCompressionRatio(backupList []storage.Backup, dataFormat string) (float64, int) {
+	sorted := make([]storage.Backup, 0, len(backupList))
+	for _, backup := range backupList {
+		if backup.Broken == "" && backup.RequiredBackup == "" && backup.DataFormat == dataFormat && backup.DataSize > 0 && backup.CompressedSize > 0 {
+			sorted = append(sorted, backup)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].UploadDate.After(sorted[j].UploadDate)
+	})
+	if len(sorted) > estimateHistoryBackups {
+		sorted = sorted[:estimateHistoryBackups]
+	}
+	if len(sorted) == 0 {
+		return 1, 0
+	}
+	dataSize, compressedSize := uint64(0), uint64(0)
+	for _, backup := range sorted {
+		dataSize += backup.DataSize
+		compressedSize += backup.CompressedSize
+	}
+	return float64(compressedSize) / float64(dataSize), len(sorted)
+}
+
+// Estimate - print projected backup size, remote objects count and upload time for matched tables, bandwidth 0 means upload_max_bytes_per_second * upload_concurrency
+func (b *Backuper) Estimate(tablePattern string, partitions []string, bandwidth uint64, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	log := b.log.WithFields(apexLog.Fields{
+		"operation": "estimate",
+	})
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	tables, err := b.GetTables(ctx, tablePattern)
+	if err != nil {
+		return fmt.Errorf("can't get tables from clickhouse: %v", err)
+	}
+	partitionsIdMap, _ := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, tables, nil, partitions)
+	partsGroups := make([]estimatePartsGroup, 0)
+	if err = b.ch.SelectContext(ctx, &partsGroups, "SELECT database, table, disk_name, partition_id, part_type, count() AS parts, sum(bytes_on_disk) AS bytes FROM system.parts WHERE active GROUP BY database, table, disk_name, partition_id, part_type"); err != nil {
+		return fmt.Errorf("can't get data parts from system.parts: %v", err)
+	}
+	columns := make([]struct {
+		Database string `ch:"database"`
+		Table    string `ch:"table"`
+		Columns  uint64 `ch:"columns"`
+	}, 0)
+	if err = b.ch.SelectContext(ctx, &columns, "SELECT database, table, count() AS columns FROM system.columns GROUP BY database, table"); err != nil {
+		return fmt.Errorf("can't get columns from system.columns: %v", err)
+	}
+	columnsByTable := map[metadata.TableTitle]uint64{}
+	for _, c := range columns {
+		columnsByTable[metadata.TableTitle{Database: c.Database, Table: c.Table}] = c.Columns
+	}
+	partsByTable := map[metadata.TableTitle]map[string][]estimatePartsGroup{}
+	for _, group := range partsGroups {
+		tableTitle := metadata.TableTitle{Database: group.Database, Table: group.Table}
+		if partitionsIds := partitionsIdMap[tableTitle]; len(partitionsIds) > 0 {
+			if _, isSelected := partitionsIds[group.PartitionId]; !isSelected {
+				continue
+			}
+		}
+		if _, exists := partsByTable[tableTitle]; !exists {
+			partsByTable[tableTitle] = map[string][]estimatePartsGroup{}
+		}
+		partsByTable[tableTitle][group.Disk] = append(partsByTable[tableTitle][group.Disk], group)
+	}
+
+	compressionFormat := b.cfg.GetCompressionFormat()
+	ratio, historyBackups := 1.0, 0
+	if compressionFormat != "none" && b.cfg.General.RemoteStorage != "none" && b.cfg.General.RemoteStorage != "custom" {
+		if backupList, listErr := b.estimateRemoteBackupList(ctx); listErr != nil {
+			log.Warnf("can't get remote backups for compression ratio, will use 1.0: %v", listErr)
+		} else {
+			ratio, historyBackups = estimateCompressionRatio(backupList, compressionFormat)
+		}
+	}
+
+	estimates := make([]tableEstimate, 0, len(tables))
+	total := tableEstimate{Table: "*"}
+	// metadata.json
+	total.Objects = 1
+	for _, table := range tables {
+		if table.Skip {
+			continue
+		}
+		tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Name}
+		// table metadata json
+		estimate := tableEstimate{Table: fmt.Sprintf("%s.%s", table.Database, table.Name), Objects: 1}
+		if table.BackupType == clickhouse.ShardBackupFull {
+			for _, groups := range partsByTable[tableTitle] {
+				for _, group := range groups {
+					estimate.Parts += group.Parts
+					estimate.Size += group.Bytes
+				}
+				estimate.Objects += estimateDataObjects(b.cfg.General, compressionFormat, groups, columnsByTable[tableTitle])
+			}
+		}
+		estimate.Compressed = uint64(float64(estimate.Size) * ratio)
+		total.Parts += estimate.Parts
+		total.Size += estimate.Size
+		total.Compressed += estimate.Compressed
+		total.Objects += estimate.Objects
+		estimates = append(estimates, estimate)
+	}
+	if len(estimates) == 0 {
+		return fmt.Errorf("no tables for backup")
+	}
+	sort.SliceStable(estimates, func(i, j int) bool {
+		return estimates[i].Size > estimates[j].Size
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", "table", "parts", "size", "compressed", "objects"); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	for _, estimate := range append(estimates, total) {
+		if bytes, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\n", estimate.Table, estimate.Parts, utils.FormatBytes(estimate.Size), utils.FormatBytes(estimate.Compressed), estimate.Objects); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	ratioSource := "no previous full backups on remote storage"
+	if compressionFormat == "none" {
+		ratioSource = "compression_format: none"
+	} else if historyBackups > 0 {
+		ratioSource = fmt.Sprintf("%d previous full backups with %s compression", historyBackups, compressionFormat)
+	}
+	fmt.Printf("compression ratio: %.2f, %s\n", ratio, ratioSource)
+	bandwidthSource := "--bandwidth"
+	if bandwidth == 0 {
+		bandwidth = b.cfg.General.UploadMaxBytesPerSecond * uint64(b.cfg.General.UploadConcurrency)
+		bandwidthSource = fmt.Sprintf("upload_max_bytes_per_second * upload_concurrency=%d", b.cfg.General.UploadConcurrency)
+	}
+	if bandwidth == 0 {
+		fmt.Println("upload time: unknown, upload_max_bytes_per_second is 0, use --bandwidth")
+		return nil
+	}
+	uploadTime := time.Duration(float64(total.Compressed) / float64(bandwidth) * float64(time.Second))
+	fmt.Printf("upload time: %s at %s/s, %s\n", utils.HumanizeDuration(uploadTime), utils.FormatBytes(bandwidth), bandwidthSource)
+	return nil
+}
+
+func (b *Backuper) estimateRemoteBackupList(ctx context.Context) ([]storage.Backup, error) {
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	return bd.BackupList(ctx, true, "")
+}
@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateDataObjects(t *testing.T) {
+	cfg := config.GeneralConfig{UploadByPart: true, UploadPartArchiveSize: 100, UploadPartMaxArchives: 4, MaxFileSize: 1000}
+	groups := []estimatePartsGroup{
+		{PartType: "Compact", Parts: 3, Bytes: 30},
+		// 250 bytes per part split into 3 archives
+		{PartType: "Wide", Parts: 2, Bytes: 500},
+	}
+	assert.Equal(t, uint64(3+2*3), estimateDataObjects(cfg, "tar", groups, 10))
+	// each file is separate object
+	assert.Equal(t, uint64(3*estimateCompactPartFiles+2*(estimateWidePartFiles+2*10)), estimateDataObjects(cfg, "none", groups, 10))
+	// archives count is limited by upload_part_max_archives
+	assert.Equal(t, uint64(4), estimateDataObjects(cfg, "tar", []estimatePartsGroup{{PartType: "Wide", Parts: 1, Bytes: 1000}}, 10))
+	// archives count is limited by files in part
+	assert.Equal(t, uint64(estimateCompactPartFiles), estimateDataObjects(config.GeneralConfig{UploadByPart: true, UploadPartArchiveSize: 1}, "tar", []estimatePartsGroup{{PartType: "Compact", Parts: 1, Bytes: 1000}}, 10))
+	cfg.UploadByPart = false
+	assert.Equal(t, uint64(1), estimateDataObjects(cfg, "tar", groups, 10))
+	cfg.MaxFileSize = 100
+	assert.Equal(t, uint64(6), estimateDataObjects(cfg, "tar", groups, 10))
+	assert.Equal(t, uint64(0), estimateDataObjects(cfg, "tar", nil, 10))
+}
+
+func TestEstimateCompressionRatio(t *testing.T) {
+	now := time.Now()
+	backup := func(name, required, dataFormat string, dataSize, compressedSize uint64, age time.Duration) storage.Backup {
+		return storage.Backup{
+			BackupMetadata: metadata.BackupMetadata{BackupName: name, RequiredBackup: required, DataFormat: dataFormat, DataSize: dataSize, CompressedSize: compressedSize},
+			UploadDate:     now.Add(-age),
+		}
+	}
+	backupList := []storage.Backup{
+		backup("full1", "", "zstd", 100, 50, 2*time.Hour),
+		backup("full2", "", "zstd", 300, 100, time.Hour),
+		backup("increment", "full2", "zstd", 300, 1, 0),
+		backup("tar", "", "tar", 100, 100, 0),
+	}
+	ratio, count := estimateCompressionRatio(backupList, "zstd")
+	assert.Equal(t, 2, count)
+	assert.InDelta(t, 150.0/400.0, ratio, 0.0001)
+
+	ratio, count = estimateCompressionRatio(backupList, "lz4")
+	assert.Equal(t, 0, count)
+	assert.Equal(t, 1.0, ratio)
+
+	// only latest estimateHistoryBackups
+	for i := 0; i < estimateHistoryBackups; i++ {
+		backupList = append(backupList, backup("new", "", "zstd", 100, 10, time.Duration(i)*time.Minute))
+	}
+	ratio, count = estimateCompressionRatio(backupList, "zstd")
+	assert.Equal(t, estimateHistoryBackups, count)
+	assert.InDelta(t, 0.1, ratio, 0.0001)
+}
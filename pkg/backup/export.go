@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// exportFormat - ClickHouse output format and extension of exported file
+type exportFormat struct {
+	name      string
+	extension string
+}
+
+var exportFormats = map[string]exportFormat{
+	"parquet": {name: "Parquet", extension: "parquet"},
+	"csv":     {name: "CSVWithNames", extension: "csv"},
+	"native":  {name: "Native", extension: "native"},
+}
+
+// getExportQuery - clickhouse-local has no keeper and storage policies, so table is created as not replicated on default disk
+func getExportQuery(table metadata.TableMetadata) (string, error) {
+	createQuery, err := adjustQueryForReadOnlyDisk(table.Query, "default")
+	if err != nil {
+		return "", fmt.Errorf("can't export %s.%s: %v", table.Database, table.Table, err)
+	}
+	return fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`; %s; SELECT data_paths[1] FROM system.tables WHERE database='%s' AND name='%s' FORMAT TSVRaw",
+		table.Database, createQuery, strings.ReplaceAll(table.Database, "'", "\\'"), strings.ReplaceAll(table.Table, "'", "\\'"),
+	), nil
+}
+
+// getExportSelectQuery - attach hardlinked parts and write all rows into exportFile
+func getExportSelectQuery(table metadata.TableMetadata, exportFile string, format exportFormat) string {
+	var queries []string
+	for _, parts := range table.Parts {
+		for _, part := range parts {
+			if !strings.HasSuffix(part.Name, ".proj") {
+				queries = append(queries, fmt.Sprintf("ALTER TABLE `%s`.`%s` ATTACH PART '%s'", table.Database, table.Table, part.Name))
+			}
+		}
+	}
+	queries = append(queries, fmt.Sprintf("SELECT * FROM `%s`.`%s` INTO OUTFILE '%s' FORMAT %s", table.Database, table.Table, strings.ReplaceAll(exportFile, "'", "\\'"), format.name))
+	return strings.Join(queries, "; ")
+}
+
+// Export - `export` command, convert data of tables from local backup, or from remote backup downloaded partially, to Parquet, CSV or Native files,
+// each table is attached into temporary clickhouse-local instance, running clickhouse-server is used only to find backup location
+func (b *Backuper) Export(backupName, tablePattern string, partitions []string, formatName, exportPath string, remote bool, commandId int) (err error) {
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select backup for export")
+	}
+	format, isSupported := exportFormats[strings.ToLower(formatName)]
+	if !isSupported {
+		return fmt.Errorf("unsupported export format '%s', shall be one of parquet, csv, native", formatName)
+	}
+	if exportPath == "" {
+		return fmt.Errorf("select export path with --path")
+	}
+	if exportPath, err = filepath.Abs(exportPath); err != nil {
+		return err
+	}
+	downloaded := false
+	if remote {
+		if err = b.Download(backupName, tablePattern, partitions, false, false, commandId); err == nil {
+			downloaded = true
+		} else if !errors.Is(err, ErrBackupIsAlreadyExists) {
+			return err
+		}
+	}
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startExport := time.Now()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "export",
+		"format":    format.name,
+	})
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	if err = b.initDisksPaths(ctx, disks); err != nil {
+		return err
+	}
+	if downloaded {
+		defer func() {
+			if removeErr := b.removeBackupLocal(ctx, backupName, disks); removeErr != nil {
+				log.Warnf("can't remove downloaded backup: %v", removeErr)
+			}
+		}()
+	}
+	backupMetadataBody, err := os.ReadFile(path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json"))
+	if err != nil {
+		return err
+	}
+	backupMetadata := metadata.BackupMetadata{}
+	if err = json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
+		return err
+	}
+	if strings.Contains(backupMetadata.Tags, "embedded") {
+		return fmt.Errorf("'%s' is embedded backup, export is not supported", backupName)
+	}
+	tables, _, err := b.getTableListByPatternLocal(ctx, path.Join(b.DefaultDataPath, "backup", backupName, "metadata"), tablePattern, false, partitions)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(exportPath, 0750); err != nil {
+		return err
+	}
+	exported := 0
+	for _, table := range tables {
+		if len(table.Parts) == 0 || !strings.Contains(table.Query, "MergeTree") {
+			log.Debugf("%s.%s doesn't contain MergeTree data parts, skip", table.Database, table.Table)
+			continue
+		}
+		for disk := range table.Parts {
+			if b.isDiskTypeObject(backupMetadata.DiskTypes[disk]) {
+				return fmt.Errorf("%s.%s contains parts on object disk %s, export is not supported", table.Database, table.Table, disk)
+			}
+		}
+		start := time.Now()
+		exportFile := path.Join(exportPath, fmt.Sprintf("%s.%s.%s", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), format.extension))
+		if err = b.exportTable(ctx, backupName, table, exportFile, format, log); err != nil {
+			return err
+		}
+		exported++
+		fileInfo, statErr := os.Stat(exportFile)
+		if statErr != nil {
+			return statErr
+		}
+		log.WithFields(apexLog.Fields{
+			"table":    fmt.Sprintf("%s.%s", table.Database, table.Table),
+			"file":     exportFile,
+			"duration": utils.HumanizeDuration(time.Since(start)),
+			"size":     utils.FormatBytes(uint64(fileInfo.Size())),
+		}).Info("done")
+	}
+	if exported == 0 {
+		return fmt.Errorf("no tables with data parts found by '%s' in '%s'", tablePattern, backupName)
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(startExport))).Infof("exported %d tables to %s", exported, exportPath)
+	return nil
+}
+
+// exportTable - temporary clickhouse-local path is created inside default disk, so backup parts could be hardlinked instead of copied
+func (b *Backuper) exportTable(ctx context.Context, backupName string, table metadata.TableMetadata, exportFile string, format exportFormat, log *apexLog.Entry) error {
+	localPath, err := os.MkdirTemp(b.DefaultDataPath, "clickhouse_backup_export_")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if removeErr := os.RemoveAll(localPath); removeErr != nil {
+			log.Warnf("can't remove %s: %v", localPath, removeErr)
+		}
+	}()
+	createQuery, err := getExportQuery(table)
+	if err != nil {
+		return err
+	}
+	out, err := b.runClickHouseLocal(ctx, localPath, createQuery)
+	if err != nil {
+		return err
+	}
+	tableDataPath := strings.TrimSpace(out)
+	if tableDataPath == "" {
+		return fmt.Errorf("clickhouse-local doesn't return data path for %s.%s", table.Database, table.Table)
+	}
+	if !path.IsAbs(tableDataPath) {
+		tableDataPath = path.Join(localPath, tableDataPath)
+	}
+	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for disk, parts := range table.Parts {
+		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTableDir)
+		for _, part := range parts {
+			if err = b.makePartHardlinks(path.Join(backupPath, part.Name), path.Join(tableDataPath, "detached", part.Name)); err != nil {
+				return fmt.Errorf("can't link %s.%s part %s from disk %s: %v", table.Database, table.Table, part.Name, disk, err)
+			}
+		}
+	}
+	if err = os.Remove(exportFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err = b.runClickHouseLocal(ctx, localPath, getExportSelectQuery(table, exportFile, format))
+	return err
+}
+
+func (b *Backuper) runClickHouseLocal(ctx context.Context, localPath, query string) (string, error) {
+	args := strings.Fields(b.cfg.ClickHouse.LocalCommand)
+	if len(args) == 0 {
+		return "", fmt.Errorf("clickhouse->local_command is empty")
+	}
+	args = append(args, "--path", localPath, "--multiquery", "--query", query)
+	b.log.Debugf("run %s", strings.Join(args[:len(args)-1], " "))
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s error: %v, stderr: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
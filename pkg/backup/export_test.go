@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetExportQuery(t *testing.T) {
+	table := metadata.TableMetadata{
+		Database: "db",
+		Table:    "t1",
+		Query:    "CREATE TABLE db.t1 UUID 'c7f1d3a0-2a4b-4a5e-9a0e-3f1d1c1b1a10' (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t1', '{replica}') ORDER BY id SETTINGS storage_policy = 'hot_and_cold', index_granularity = 8192",
+		Parts: map[string][]metadata.Part{
+			"default": {{Name: "all_1_1_0"}, {Name: "all_1_1_0.proj"}},
+		},
+	}
+	query, err := getExportQuery(table)
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE DATABASE IF NOT EXISTS `db`; CREATE TABLE db.t1  (id UInt64) ENGINE = MergeTree() ORDER BY id SETTINGS disk = default, index_granularity = 8192; SELECT data_paths[1] FROM system.tables WHERE database='db' AND name='t1' FORMAT TSVRaw", query)
+	assert.Equal(t,
+		"ALTER TABLE `db`.`t1` ATTACH PART 'all_1_1_0'; SELECT * FROM `db`.`t1` INTO OUTFILE '/export/it\\'s/db.t1.csv' FORMAT CSVWithNames",
+		getExportSelectQuery(table, "/export/it's/db.t1.csv", exportFormats["csv"]),
+	)
+
+	table.Query = "CREATE VIEW db.v1 AS SELECT 1"
+	_, err = getExportQuery(table)
+	assert.ErrorContains(t, err, "can't export db.t1")
+}
@@ -0,0 +1,235 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// fsSnapshotProvider - snapshot of volume which contains ClickHouse local disks, look clickhouse->filesystem_snapshot_type
+type fsSnapshotProvider interface {
+	// Create - take snapshot and return path where content of clickhouse->filesystem_snapshot_mount_point is readable
+	Create(ctx context.Context, name string) (string, error)
+	Remove(ctx context.Context, name, snapshotPath string) error
+}
+
+// fsSnapshotExec - replaced in tests
+var fsSnapshotExec = func(ctx context.Context, cmd string, args ...string) error {
+	out, err := utils.ExecCmdOut(ctx, 5*time.Minute, cmd, args...)
+	if err != nil {
+		return fmt.Errorf("%s %s: %v, output: %s", cmd, strings.Join(args, " "), err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+type lvmSnapshotProvider struct {
+	volume string
+	size   string
+}
+
+func (p *lvmSnapshotProvider) Create(ctx context.Context, name string) (string, error) {
+	sizeArgs := []string{"-l", "10%ORIGIN"}
+	if p.size != "" {
+		sizeArgs = []string{"-L", p.size}
+	}
+	if err := fsSnapshotExec(ctx, "lvcreate", append(append([]string{"-s", "-n", name}, sizeArgs...), p.volume)...); err != nil {
+		return "", err
+	}
+	mountPath, err := os.MkdirTemp("", "clickhouse-backup-snapshot-")
+	if err != nil {
+		return "", err
+	}
+	if err = fsSnapshotExec(ctx, "mount", "-o", "ro", path.Join("/dev", path.Dir(p.volume), name), mountPath); err != nil {
+		_ = os.Remove(mountPath)
+		return "", err
+	}
+	return mountPath, nil
+}
+
+func (p *lvmSnapshotProvider) Remove(ctx context.Context, name, snapshotPath string) error {
+	if snapshotPath != "" {
+		if err := fsSnapshotExec(ctx, "umount", snapshotPath); err != nil {
+			return err
+		}
+		if err := os.Remove(snapshotPath); err != nil {
+			return err
+		}
+	}
+	return fsSnapshotExec(ctx, "lvremove", "-f", path.Join(path.Dir(p.volume), name))
+}
+
+type zfsSnapshotProvider struct {
+	dataset    string
+	mountPoint string
+}
+
+func (p *zfsSnapshotProvider) Create(ctx context.Context, name string) (string, error) {
+	if err := fsSnapshotExec(ctx, "zfs", "snapshot", p.dataset+"@"+name); err != nil {
+		return "", err
+	}
+	// snapshot is available read only without mount via hidden .zfs directory of dataset mount point
+	return path.Join(p.mountPoint, ".zfs", "snapshot", name), nil
+}
+
+func (p *zfsSnapshotProvider) Remove(ctx context.Context, name, _ string) error {
+	return fsSnapshotExec(ctx, "zfs", "destroy", p.dataset+"@"+name)
+}
+
+type btrfsSnapshotProvider struct {
+	subvolume string
+}
+
+func (p *btrfsSnapshotProvider) Create(ctx context.Context, name string) (string, error) {
+	snapshotPath := path.Join(p.subvolume, ".clickhouse-backup-snapshot-"+name)
+	if err := fsSnapshotExec(ctx, "btrfs", "subvolume", "snapshot", "-r", p.subvolume, snapshotPath); err != nil {
+		return "", err
+	}
+	return snapshotPath, nil
+}
+
+func (p *btrfsSnapshotProvider) Remove(ctx context.Context, _, snapshotPath string) error {
+	if snapshotPath == "" {
+		return nil
+	}
+	return fsSnapshotExec(ctx, "btrfs", "subvolume", "delete", snapshotPath)
+}
+
+func newFSSnapshotProvider(cfg *config.Config) (fsSnapshotProvider, error) {
+	switch cfg.ClickHouse.FilesystemSnapshotType {
+	case "lvm":
+		return &lvmSnapshotProvider{volume: cfg.ClickHouse.FilesystemSnapshotVolume, size: cfg.ClickHouse.FilesystemSnapshotSize}, nil
+	case "zfs":
+		return &zfsSnapshotProvider{dataset: cfg.ClickHouse.FilesystemSnapshotVolume, mountPoint: cfg.ClickHouse.FilesystemSnapshotMountPoint}, nil
+	case "btrfs":
+		return &btrfsSnapshotProvider{subvolume: cfg.ClickHouse.FilesystemSnapshotVolume}, nil
+	}
+	return nil, fmt.Errorf("unsupported filesystem_snapshot_type: %s", cfg.ClickHouse.FilesystemSnapshotType)
+}
+
+// fsSnapshot - snapshot taken for current `create`, parts contain active parts of each table at snapshot time
+type fsSnapshot struct {
+	path       string
+	mountPoint string
+	parts      map[metadata.TableTitle][]clickhouse.ActivePart
+}
+
+var fsSnapshotNameRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// createFSSnapshot - merges are stopped until snapshot is taken, so active parts listed before snapshot are present inside snapshot,
+// parts inserted after listing are not backed up, return cleanup function which removes snapshot
+func (b *Backuper) createFSSnapshot(ctx context.Context, backupName string, tables []clickhouse.Table, log *apexLog.Entry) (func(), error) {
+	provider, err := newFSSnapshotProvider(b.cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer b.startAllMerges(log)
+	if err = b.stopMergesForSnapshot(ctx, tables, log); err != nil {
+		return nil, err
+	}
+	snapshot := &fsSnapshot{
+		mountPoint: strings.TrimSuffix(b.cfg.ClickHouse.FilesystemSnapshotMountPoint, "/") + "/",
+		parts:      map[metadata.TableTitle][]clickhouse.ActivePart{},
+	}
+	for table := range b.snapshotBarrier.stopped {
+		parts, partsErr := b.ch.GetActiveParts(ctx, table.Database, table.Table)
+		if partsErr != nil {
+			return nil, partsErr
+		}
+		snapshot.parts[table] = parts
+	}
+	name := "clickhouse_backup_" + fsSnapshotNameRE.ReplaceAllString(backupName, "_")
+	start := time.Now()
+	snapshot.path, err = provider.Create(ctx, name)
+	cleanup := func() {
+		if removeErr := provider.Remove(context.Background(), name, snapshot.path); removeErr != nil {
+			log.Errorf("can't remove %s snapshot %s, remove it manually: %v", b.cfg.ClickHouse.FilesystemSnapshotType, name, removeErr)
+		}
+	}
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("can't create %s snapshot: %v", b.cfg.ClickHouse.FilesystemSnapshotType, err)
+	}
+	b.fsSnapshot = snapshot
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Infof("%s snapshot %s created", b.cfg.ClickHouse.FilesystemSnapshotType, name)
+	return cleanup, nil
+}
+
+// getFSSnapshotPartPath - path of part inside snapshot, empty when part is outside snapshot volume or on object disk
+func (b *Backuper) getFSSnapshotPartPath(part clickhouse.ActivePart, disks []clickhouse.Disk) string {
+	if !strings.HasPrefix(part.Path, b.fsSnapshot.mountPoint) {
+		return ""
+	}
+	for _, disk := range disks {
+		if disk.Name == part.DiskName && (disk.IsBackup || b.isDiskTypeObject(disk.Type) || b.isDiskTypeEncryptedObject(disk, disks) || b.isDiskTypeStatic(disk.Type)) {
+			return ""
+		}
+	}
+	return path.Join(b.fsSnapshot.path, strings.TrimPrefix(part.Path, b.fsSnapshot.mountPoint))
+}
+
+// addTableFromFSSnapshot - copy active parts from snapshot instead of FREEZE, return false when some parts are not available in snapshot
+func (b *Backuper) addTableFromFSSnapshot(ctx context.Context, backupName string, table *clickhouse.Table, diskList []clickhouse.Disk, tableDiffFromRemote metadata.TableMetadata, partitionsIdsMap common.EmptyMap, log *apexLog.Entry) (map[string][]metadata.Part, map[string]int64, bool, error) {
+	activeParts, exists := b.fsSnapshot.parts[metadata.TableTitle{Database: table.Database, Table: table.Name}]
+	if !exists {
+		return nil, nil, false, nil
+	}
+	snapshotPartPaths := make([]string, len(activeParts))
+	for i, part := range activeParts {
+		if snapshotPartPaths[i] = b.getFSSnapshotPartPath(part, diskList); snapshotPartPaths[i] == "" {
+			log.WithField("disk", part.DiskName).Info("data parts outside of filesystem snapshot, use FREEZE")
+			return nil, nil, false, nil
+		}
+	}
+	diskPaths := map[string]string{}
+	for _, disk := range diskList {
+		diskPaths[disk.Name] = disk.Path
+	}
+	encodedTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Name))
+	realSize := map[string]int64{}
+	disksToPartsMap := map[string][]metadata.Part{}
+	for i, part := range activeParts {
+		select {
+		case <-ctx.Done():
+			return nil, nil, false, ctx.Err()
+		default:
+		}
+		if len(partitionsIdsMap) != 0 && !filesystemhelper.IsPartInPartition(part.Name, partitionsIdsMap) {
+			continue
+		}
+		isRequired := false
+		for _, diffPart := range tableDiffFromRemote.Parts[part.DiskName] {
+			if diffPart.Name == part.Name {
+				isRequired = true
+				break
+			}
+		}
+		if isRequired {
+			disksToPartsMap[part.DiskName] = append(disksToPartsMap[part.DiskName], metadata.Part{Name: part.Name, Required: true})
+			continue
+		}
+		backupShadowPath := path.Join(diskPaths[part.DiskName], "backup", backupName, "shadow", encodedTablePath, part.DiskName)
+		if err := filesystemhelper.MkdirAll(backupShadowPath, b.ch, diskList); err != nil && !os.IsExist(err) {
+			return nil, nil, false, err
+		}
+		size, err := linkOrCopyDir(snapshotPartPaths[i], path.Join(backupShadowPath, part.Name))
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("can't copy part %s from snapshot: %v", part.Name, err)
+		}
+		realSize[part.DiskName] += size
+		disksToPartsMap[part.DiskName] = append(disksToPartsMap[part.DiskName], metadata.Part{Name: part.Name})
+	}
+	log.Debug("copied from filesystem snapshot")
+	return disksToPartsMap, realSize, true, nil
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSSnapshotProviders(t *testing.T) {
+	var commands []string
+	oldExec := fsSnapshotExec
+	fsSnapshotExec = func(ctx context.Context, cmd string, args ...string) error {
+		commands = append(commands, cmd+" "+strings.Join(args, " "))
+		return nil
+	}
+	defer func() { fsSnapshotExec = oldExec }()
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+
+	cfg.ClickHouse.FilesystemSnapshotType = "zfs"
+	cfg.ClickHouse.FilesystemSnapshotVolume = "tank/clickhouse"
+	cfg.ClickHouse.FilesystemSnapshotMountPoint = "/var/lib/clickhouse"
+	provider, err := newFSSnapshotProvider(cfg)
+	require.NoError(t, err)
+	snapshotPath, err := provider.Create(ctx, "clickhouse_backup_b1")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/clickhouse/.zfs/snapshot/clickhouse_backup_b1", snapshotPath)
+	require.NoError(t, provider.Remove(ctx, "clickhouse_backup_b1", snapshotPath))
+
+	cfg.ClickHouse.FilesystemSnapshotType = "btrfs"
+	cfg.ClickHouse.FilesystemSnapshotVolume = "/var/lib/clickhouse"
+	provider, err = newFSSnapshotProvider(cfg)
+	require.NoError(t, err)
+	snapshotPath, err = provider.Create(ctx, "clickhouse_backup_b1")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/clickhouse/.clickhouse-backup-snapshot-clickhouse_backup_b1", snapshotPath)
+	require.NoError(t, provider.Remove(ctx, "clickhouse_backup_b1", snapshotPath))
+
+	cfg.ClickHouse.FilesystemSnapshotType = "lvm"
+	cfg.ClickHouse.FilesystemSnapshotVolume = "vg0/clickhouse"
+	cfg.ClickHouse.FilesystemSnapshotSize = "20G"
+	provider, err = newFSSnapshotProvider(cfg)
+	require.NoError(t, err)
+	snapshotPath, err = provider.Create(ctx, "clickhouse_backup_b1")
+	require.NoError(t, err)
+	assert.DirExists(t, snapshotPath)
+	require.NoError(t, provider.Remove(ctx, "clickhouse_backup_b1", snapshotPath))
+	assert.NoDirExists(t, snapshotPath)
+
+	assert.Equal(t, []string{
+		"zfs snapshot tank/clickhouse@clickhouse_backup_b1",
+		"zfs destroy tank/clickhouse@clickhouse_backup_b1",
+		"btrfs subvolume snapshot -r /var/lib/clickhouse /var/lib/clickhouse/.clickhouse-backup-snapshot-clickhouse_backup_b1",
+		"btrfs subvolume delete /var/lib/clickhouse/.clickhouse-backup-snapshot-clickhouse_backup_b1",
+		"lvcreate -s -n clickhouse_backup_b1 -L 20G vg0/clickhouse",
+		"mount -o ro /dev/vg0/clickhouse_backup_b1 " + snapshotPath,
+		"umount " + snapshotPath,
+		"lvremove -f vg0/clickhouse_backup_b1",
+	}, commands)
+
+	cfg.ClickHouse.FilesystemSnapshotType = "xfs"
+	_, err = newFSSnapshotProvider(cfg)
+	assert.Error(t, err)
+}
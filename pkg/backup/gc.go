@@ -0,0 +1,401 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// defaultGCMinAge - objects of running upload or create_remote are younger
+const defaultGCMinAge = 24 * time.Hour
+
+const (
+	gcKindBrokenBackup    = "broken_backup"
+	gcKindUnreferenced    = "unreferenced"
+	gcKindObjectDisk      = "object_disk"
+	gcKindMultipartUpload = "multipart_upload"
+)
+
+// gcObject - remote object which is not referenced by metadata.json of any backup, key is relative to remote path or object disk path
+type gcObject struct {
+	kind       string
+	backupName string
+	key        string
+	size       int64
+	modified   time.Time
+	upload     *storage.IncompleteUpload
+}
+
+// GarbageCollectRemote - `gc --remote`, report objects which don't belong to any backup, delete them only when confirm is true
+// objects modified after now-minAge are skipped, cause they could belong to upload which is still running
+func (b *Backuper) GarbageCollectRemote(minAge time.Duration, confirm bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	log := b.log.WithField("operation", "gc")
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("gc --remote is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	start := time.Now()
+	// local backups reference object disk data copied during `create`, without clickhouse only remote storage is checked
+	withLocal := true
+	if err = b.ch.Connect(); err != nil {
+		log.Warnf("can't connect to clickhouse, object disk data of local backups which are not uploaded is not protected: %v", err)
+		withLocal = false
+	} else {
+		defer b.ch.Close()
+	}
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	b.dst = bd
+
+	objects, err := b.findGarbageRemote(ctx, time.Now().Add(-minAge), withLocal, log)
+	if err != nil {
+		return err
+	}
+	if err = printGarbage(objects); err != nil {
+		return err
+	}
+	totalSize := int64(0)
+	for _, o := range objects {
+		totalSize += o.size
+	}
+	if !confirm {
+		log.Infof("found %d orphaned objects with %s, use `gc --remote --confirm` to delete them", len(objects), utils.FormatBytes(uint64(totalSize)))
+		return nil
+	}
+	if err = b.deleteGarbage(ctx, objects); err != nil {
+		return err
+	}
+	log.WithFields(apexLog.Fields{
+		"objects":  len(objects),
+		"size":     utils.FormatBytes(uint64(totalSize)),
+		"duration": utils.HumanizeDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
+
+// findGarbageRemote - broken backups, objects inside shadow directory of backups which are not listed in tables metadata, object disk copies of absent backups, stale multipart uploads
+func (b *Backuper) findGarbageRemote(ctx context.Context, olderThan time.Time, withLocal bool, log *apexLog.Entry) ([]gcObject, error) {
+	validBackups, objects, err := b.findBrokenBackupsRemote(ctx, olderThan, log)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, backupName := range sortedBackupNames(validBackups) {
+		backupObjects, err := b.findUnreferencedBackupObjects(ctx, validBackups[backupName], olderThan, log)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, backupObjects...)
+	}
+
+	references, err := b.getRemainingObjectDiskReferences(ctx, "", withLocal)
+	if err != nil {
+		return nil, err
+	}
+	objectDiskObjects, err := b.findOrphanedObjectDiskObjects(ctx, references, olderThan, log)
+	if err != nil {
+		return nil, err
+	}
+	objects = append(objects, objectDiskObjects...)
+
+	if cleaner, isCleaner := b.dst.RemoteStorage.(storage.IncompleteUploadsCleaner); isCleaner {
+		uploads, err := cleaner.ListIncompleteUploads(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range uploads {
+			if uploads[i].Initiated.After(olderThan) {
+				continue
+			}
+			objects = append(objects, gcObject{kind: gcKindMultipartUpload, key: uploads[i].Key, modified: uploads[i].Initiated, upload: &uploads[i]})
+		}
+	}
+	return objects, nil
+}
+
+// findBrokenBackupsRemote - backups without valid metadata.json, catalog.json could be stale, so backup list is read from remote storage directly
+func (b *Backuper) findBrokenBackupsRemote(ctx context.Context, olderThan time.Time, log *apexLog.Entry) (map[string]storage.Backup, []gcObject, error) {
+	backupList, err := b.dst.BackupListWithoutCatalog(ctx, true, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	validBackups := map[string]storage.Backup{}
+	var brokenNames []string
+	for _, backup := range backupList {
+		if backup.Broken == "" {
+			validBackups[backup.BackupName] = backup
+		} else {
+			brokenNames = append(brokenNames, backup.BackupName)
+		}
+	}
+	var objects []gcObject
+	for _, name := range brokenNames {
+		var brokenObjects []gcObject
+		if err = b.dst.Walk(ctx, name+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+			if b.isDirectoryPlaceholder(f) {
+				return nil
+			}
+			brokenObjects = append(brokenObjects, gcObject{kind: gcKindBrokenBackup, backupName: name, key: path.Join(name, f.Name()), size: f.Size(), modified: f.LastModified()})
+			return nil
+		}); err != nil {
+			return nil, nil, fmt.Errorf("can't list %s: %v", name, err)
+		}
+		if !isGarbageOlderThan(brokenObjects, olderThan) {
+			log.Infof("skip %s, contains objects modified after %s, upload could be still running", name, olderThan.Format(time.RFC3339))
+			continue
+		}
+		objects = append(objects, brokenObjects...)
+	}
+	return validBackups, objects, nil
+}
+
+// findUnreferencedBackupObjects - only shadow directory is checked, other objects of backup are not listed in metadata and can't be orphaned by interrupted upload
+func (b *Backuper) findUnreferencedBackupObjects(ctx context.Context, backup storage.Backup, olderThan time.Time, log *apexLog.Entry) ([]gcObject, error) {
+	if err := b.dst.CheckBackupLock(ctx, backup.BackupName); err != nil {
+		log.Warnf("skip %s: %v", backup.BackupName, err)
+		return nil, nil
+	}
+	// <db>/<table> -> objects relative to shadow/<db>/<table>
+	tableObjects := map[string][]storage.RemoteFile{}
+	shadowPrefix := path.Join(backup.BackupName, "shadow")
+	if err := b.dst.Walk(ctx, shadowPrefix+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		if b.isDirectoryPlaceholder(f) {
+			return nil
+		}
+		nameParts := strings.SplitN(strings.Trim(f.Name(), "/"), "/", 3)
+		if len(nameParts) < 3 {
+			return nil
+		}
+		dbAndTable := path.Join(nameParts[0], nameParts[1])
+		tableObjects[dbAndTable] = append(tableObjects[dbAndTable], gcRemoteFile{RemoteFile: f, name: nameParts[2]})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("can't list %s: %v", shadowPrefix, err)
+	}
+	backupTables := map[string]metadata.TableTitle{}
+	for _, table := range backup.Tables {
+		backupTables[path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))] = table
+	}
+	dbAndTables := make([]string, 0, len(tableObjects))
+	for dbAndTable := range tableObjects {
+		dbAndTables = append(dbAndTables, dbAndTable)
+	}
+	sort.Strings(dbAndTables)
+	var objects []gcObject
+	for _, dbAndTable := range dbAndTables {
+		files := tableObjects[dbAndTable]
+		unreferenced := map[string]bool{}
+		if tableTitle, exists := backupTables[dbAndTable]; exists {
+			tableMetadata, err := b.readTableMetadataRemote(ctx, backup.BackupName, tableTitle)
+			if err != nil {
+				log.Warnf("skip %s.%s in %s: %v", tableTitle.Database, tableTitle.Table, backup.BackupName, err)
+				continue
+			}
+			for _, name := range getUnreferencedTableObjects(*tableMetadata, getRemoteFileNames(files)) {
+				unreferenced[name] = true
+			}
+		} else {
+			// table failed to upload or was excluded from backup
+			for _, f := range files {
+				unreferenced[f.Name()] = true
+			}
+		}
+		for _, f := range files {
+			if !unreferenced[f.Name()] || f.LastModified().After(olderThan) {
+				continue
+			}
+			objects = append(objects, gcObject{kind: gcKindUnreferenced, backupName: backup.BackupName, key: path.Join(shadowPrefix, dbAndTable, f.Name()), size: f.Size(), modified: f.LastModified()})
+		}
+	}
+	return objects, nil
+}
+
+// getUnreferencedTableObjects - names relative to shadow/<db>/<table>, archive tables reference archives and parity files, directory tables reference <disk>/<part>/ prefixes
+func getUnreferencedTableObjects(table metadata.TableMetadata, names []string) []string {
+	referenced := map[string]bool{}
+	for _, files := range table.Files {
+		for _, f := range files {
+			referenced[f] = true
+		}
+	}
+	for _, group := range table.ParityGroups {
+		for _, f := range group.ParityFiles {
+			referenced[f] = true
+		}
+	}
+	referencedParts := map[string]bool{}
+	if len(table.Files) == 0 {
+		for disk, parts := range table.GetPartsWithDetached() {
+			for _, part := range parts {
+				referencedParts[path.Join(disk, part.Name)] = true
+			}
+		}
+	}
+	var unreferenced []string
+	for _, name := range names {
+		if referenced[name] {
+			continue
+		}
+		if nameParts := strings.SplitN(name, "/", 3); len(nameParts) == 3 && referencedParts[path.Join(nameParts[0], nameParts[1])] {
+			continue
+		}
+		unreferenced = append(unreferenced, name)
+	}
+	return unreferenced
+}
+
+// findOrphanedObjectDiskObjects - <object_disk_path>/<backup_name> which is not referenced by local or remote backups, left by interrupted `create_remote` or failed `delete remote`, look getObjectDiskReferences
+func (b *Backuper) findOrphanedObjectDiskObjects(ctx context.Context, references map[string][]string, olderThan time.Time, log *apexLog.Entry) ([]gcObject, error) {
+	if b.cfg.General.RemoteStorage != "s3" && b.cfg.General.RemoteStorage != "azblob" && b.cfg.General.RemoteStorage != "gcs" {
+		return nil, nil
+	}
+	objectDiskPath, err := b.getObjectDiskPath()
+	if err != nil || objectDiskPath == "" {
+		return nil, err
+	}
+	var names []string
+	if err = b.dst.WalkAbsolute(ctx, objectDiskPath, false, func(ctx context.Context, f storage.RemoteFile) error {
+		name := strings.Trim(f.Name(), "/")
+		if _, isReferenced := references[name]; name != "" && !isReferenced {
+			names = append(names, name)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("can't list %s: %v", objectDiskPath, err)
+	}
+	var objects []gcObject
+	for _, name := range names {
+		var orphaned []gcObject
+		if err = b.dst.WalkAbsolute(ctx, path.Join(objectDiskPath, name), true, func(ctx context.Context, f storage.RemoteFile) error {
+			if b.isDirectoryPlaceholder(f) {
+				return nil
+			}
+			orphaned = append(orphaned, gcObject{kind: gcKindObjectDisk, backupName: name, key: path.Join(name, f.Name()), size: f.Size(), modified: f.LastModified()})
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("can't list %s: %v", path.Join(objectDiskPath, name), err)
+		}
+		if !isGarbageOlderThan(orphaned, olderThan) {
+			log.Infof("skip %s, contains objects modified after %s, create_remote could be still running", path.Join(objectDiskPath, name), olderThan.Format(time.RFC3339))
+			continue
+		}
+		objects = append(objects, orphaned...)
+	}
+	return objects, nil
+}
+
+// deleteGarbage - broken backups are deleted as whole directory, cause sftp and ftp can't delete directory by objects
+func (b *Backuper) deleteGarbage(ctx context.Context, objects []gcObject) error {
+	deletedBrokenBackups := map[string]bool{}
+	for _, o := range objects {
+		var err error
+		retry := b.newRetrier()
+		switch o.kind {
+		case gcKindBrokenBackup:
+			if deletedBrokenBackups[o.backupName] {
+				continue
+			}
+			deletedBrokenBackups[o.backupName] = true
+			err = retry.RunCtx(ctx, func(ctx context.Context) error {
+				return b.dst.RemoveBackupRemote(ctx, storage.Backup{BackupMetadata: metadata.BackupMetadata{BackupName: o.backupName}})
+			})
+		case gcKindUnreferenced:
+			err = retry.RunCtx(ctx, func(ctx context.Context) error {
+				return b.dst.DeleteFile(ctx, o.key)
+			})
+		case gcKindObjectDisk:
+			err = retry.RunCtx(ctx, func(ctx context.Context) error {
+				return b.dst.DeleteFileFromObjectDiskBackup(ctx, o.key)
+			})
+		case gcKindMultipartUpload:
+			err = retry.RunCtx(ctx, func(ctx context.Context) error {
+				return b.dst.RemoteStorage.(storage.IncompleteUploadsCleaner).AbortIncompleteUpload(ctx, *o.upload)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("can't delete %s %s: %v", o.kind, o.key, err)
+		}
+	}
+	return nil
+}
+
+// isDirectoryPlaceholder - azblob returns virtual directories during recursive walk
+func (b *Backuper) isDirectoryPlaceholder(f storage.RemoteFile) bool {
+	return b.dst.Kind() == "azblob" && f.Size() == 0 && f.LastModified().IsZero()
+}
+
+// isGarbageOlderThan - whole directory is skipped when any object was modified recently
+func isGarbageOlderThan(objects []gcObject, olderThan time.Time) bool {
+	for _, o := range objects {
+		if o.modified.After(olderThan) {
+			return false
+		}
+	}
+	return true
+}
+
+func printGarbage(objects []gcObject) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "kind", "key", "size", "modified"); err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.kind, o.key, utils.FormatBytes(uint64(o.size)), o.modified.Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func sortedBackupNames(backups map[string]storage.Backup) []string {
+	names := make([]string, 0, len(backups))
+	for name := range backups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gcRemoteFile - remote file with name relative to table directory
+type gcRemoteFile struct {
+	storage.RemoteFile
+	name string
+}
+
+func (f gcRemoteFile) Name() string {
+	return f.name
+}
+
+func getRemoteFileNames(files []storage.RemoteFile) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name()
+	}
+	return names
+}
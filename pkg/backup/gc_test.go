@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetUnreferencedTableObjects(t *testing.T) {
+	archiveTable := metadata.TableMetadata{
+		Database:     "db",
+		Table:        "t1",
+		Parts:        map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}, {Name: "all_2_2_0"}}},
+		Files:        map[string][]string{"default": {"default_1.tar.gz"}},
+		ParityGroups: []metadata.ParityGroup{{Files: []string{"default_1.tar.gz"}, ParityFiles: []string{"parity_0_0"}}},
+	}
+	names := []string{"default_1.tar.gz", "parity_0_0", "default_2.tar.gz", "default/all_1_1_0/data.bin"}
+	assert.Equal(t, []string{"default_2.tar.gz", "default/all_1_1_0/data.bin"}, getUnreferencedTableObjects(archiveTable, names))
+
+	directoryTable := metadata.TableMetadata{
+		Database:      "db",
+		Table:         "t2",
+		Parts:         map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}},
+		DetachedParts: map[string][]metadata.Part{"default": {{Name: "broken_all_3_3_0"}}},
+	}
+	names = []string{"default/all_1_1_0/data.bin", "default/all_1_1_0/projection.proj/data.bin", "default/broken_all_3_3_0/data.bin", "default/all_2_2_0/data.bin", "hdd/all_1_1_0/data.bin", "default_1.tar.gz"}
+	assert.Equal(t, []string{"default/all_2_2_0/data.bin", "hdd/all_1_1_0/data.bin", "default_1.tar.gz"}, getUnreferencedTableObjects(directoryTable, names))
+}
+
+func TestIsGarbageOlderThan(t *testing.T) {
+	olderThan := time.Now().Add(-24 * time.Hour)
+	objects := []gcObject{
+		{key: "backup1/shadow/db/t1/default_1.tar.gz", modified: olderThan.Add(-time.Hour)},
+	}
+	assert.True(t, isGarbageOlderThan(objects, olderThan))
+	objects = append(objects, gcObject{key: "backup1/shadow/db/t1/default_2.tar.gz", modified: time.Now()})
+	assert.False(t, isGarbageOlderThan(objects, olderThan), "upload could be still running")
+}
+
+type gcTestFile struct {
+	name     string
+	size     int64
+	modified time.Time
+}
+
+func (f gcTestFile) Size() int64             { return f.size }
+func (f gcTestFile) Name() string            { return f.name }
+func (f gcTestFile) LastModified() time.Time { return f.modified }
+
+// gcTestStorage - in memory remote storage, all objects are modified at the same time
+type gcTestStorage struct {
+	objects  map[string][]byte
+	modified time.Time
+}
+
+func (s *gcTestStorage) Kind() string                      { return "gc-test" }
+func (s *gcTestStorage) Connect(ctx context.Context) error { return nil }
+func (s *gcTestStorage) Close(ctx context.Context) error   { return nil }
+
+func (s *gcTestStorage) StatFile(ctx context.Context, key string) (storage.RemoteFile, error) {
+	body, exists := s.objects[key]
+	if !exists {
+		return nil, storage.ErrNotFound
+	}
+	return gcTestFile{name: key, size: int64(len(body)), modified: s.modified}, nil
+}
+
+func (s *gcTestStorage) DeleteFile(ctx context.Context, key string) error {
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *gcTestStorage) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) error {
+	return s.DeleteFile(ctx, key)
+}
+
+func (s *gcTestStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, storage.RemoteFile) error) error {
+	prefix = strings.TrimPrefix(prefix, "/")
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, prefix)
+		size := int64(len(s.objects[key]))
+		if i := strings.Index(name, "/"); !recursive && i >= 0 {
+			name, size = name[:i+1], 0
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if err := fn(ctx, gcTestFile{name: name, size: size, modified: s.modified}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *gcTestStorage) WalkAbsolute(ctx context.Context, absolutePrefix string, recursive bool, fn func(context.Context, storage.RemoteFile) error) error {
+	return s.Walk(ctx, absolutePrefix, recursive, fn)
+}
+
+func (s *gcTestStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, exists := s.objects[key]
+	if !exists {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (s *gcTestStorage) GetFileReaderAbsolute(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetFileReader(ctx, key)
+}
+
+func (s *gcTestStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	return s.GetFileReader(ctx, key)
+}
+
+func (s *gcTestStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = body
+	return r.Close()
+}
+
+func (s *gcTestStorage) PutFileAbsolute(ctx context.Context, key string, r io.ReadCloser) error {
+	return s.PutFile(ctx, key, r)
+}
+
+func (s *gcTestStorage) CopyObject(ctx context.Context, srcSize int64, srcBucket, srcKey, dstKey string) (int64, error) {
+	s.objects[dstKey] = s.objects[srcKey]
+	return srcSize, nil
+}
+
+func TestFindBrokenBackupsRemoteIgnoresStaleCatalog(t *testing.T) {
+	ctx := context.Background()
+	backupMetadata := func(name string) []byte {
+		body, err := json.Marshal(metadata.BackupMetadata{BackupName: name})
+		require.NoError(t, err)
+		return body
+	}
+	// catalog.json was written before backup2 upload finished
+	catalog, err := json.Marshal(storage.Catalog{Backups: []storage.Backup{{BackupMetadata: metadata.BackupMetadata{BackupName: "backup1"}}}})
+	require.NoError(t, err)
+	remote := &gcTestStorage{
+		modified: time.Now().Add(-48 * time.Hour),
+		objects: map[string][]byte{
+			storage.CatalogFile:                           catalog,
+			"backup1/metadata.json":                       backupMetadata("backup1"),
+			"backup2/metadata.json":                       backupMetadata("backup2"),
+			"backup2/shadow/db/t1/default_1.tar.gz":       []byte("data"),
+			"broken_backup/shadow/db/t1/default_1.tar.gz": []byte("data"),
+		},
+	}
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteCatalog = true
+	cfg.General.RemoteMetadataCacheDuration = 0
+	b := NewBackuper(cfg)
+	b.dst = storage.NewBackupDestinationFromRemoteStorage(cfg, remote, apexLog.WithField("logger", "test"))
+
+	validBackups, objects, err := b.findBrokenBackupsRemote(ctx, time.Now().Add(-24*time.Hour), apexLog.WithField("logger", "test"))
+	require.NoError(t, err)
+	assert.Contains(t, validBackups, "backup1")
+	assert.Contains(t, validBackups, "backup2")
+	for _, o := range objects {
+		assert.Equal(t, gcKindBrokenBackup, o.kind)
+		assert.Equal(t, "broken_backup", o.backupName)
+	}
+	require.NoError(t, b.deleteGarbage(ctx, objects))
+	assert.Contains(t, remote.objects, "backup2/metadata.json")
+	assert.Contains(t, remote.objects, "backup2/shadow/db/t1/default_1.tar.gz")
+	assert.NotContains(t, remote.objects, "broken_backup/shadow/db/t1/default_1.tar.gz")
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// hookEvent - describe backup and stage for general->hooks, passed as environment variables to command and as JSON body to url
+type hookEvent struct {
+	Stage      string `json:"stage"`
+	Operation  string `json:"operation"`
+	BackupName string `json:"backup_name"`
+	Table      string `json:"table,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// getHookEnv - environment of hook command, current process environment with CLICKHOUSE_BACKUP_* variables
+func getHookEnv(event hookEvent) []string {
+	return append(os.Environ(),
+		"CLICKHOUSE_BACKUP_HOOK_STAGE="+event.Stage,
+		"CLICKHOUSE_BACKUP_OPERATION="+event.Operation,
+		"CLICKHOUSE_BACKUP_NAME="+event.BackupName,
+		"CLICKHOUSE_BACKUP_TABLE="+event.Table,
+		"CLICKHOUSE_BACKUP_ERROR="+event.Error,
+	)
+}
+
+// getStageHooks - hooks from general->hooks for stage in config order
+func getStageHooks(hooks []config.BackupHook, stage string) []config.BackupHook {
+	var result []config.BackupHook
+	for _, hook := range hooks {
+		if hook.Stage == stage {
+			result = append(result, hook)
+		}
+	}
+	return result
+}
+
+// runHooks - execute general->hooks for event.Stage one by one, hook with `on_failure: abort` stops next hooks and returns error, `on_failure: ignore` only logs error
+func (b *Backuper) runHooks(ctx context.Context, event hookEvent, log *apexLog.Entry) error {
+	for _, hook := range getStageHooks(b.cfg.General.Hooks, event.Stage) {
+		start := time.Now()
+		hookLog := log.WithField("hook", event.Stage)
+		if event.Table != "" {
+			hookLog = hookLog.WithField("table", event.Table)
+		}
+		var err error
+		if hook.Command != "" {
+			err = runHookCommand(ctx, hook, event, hookLog)
+		} else {
+			err = runHookURL(ctx, hook, event)
+		}
+		if err != nil {
+			if hook.OnFailure == "ignore" {
+				hookLog.Warnf("hook failed, on_failure: ignore, error: %v", err)
+				continue
+			}
+			return fmt.Errorf("%s hook failed: %v", event.Stage, err)
+		}
+		hookLog.WithField("duration", utils.HumanizeDuration(time.Since(start))).Info("hook done")
+	}
+	return nil
+}
+
+// afterFreezeHook - after_freeze shall resume what before_freeze paused right after all tables are frozen, not after whole `create`,
+// hooks run only once with own context, operation context could be already canceled when create failed
+type afterFreezeHook struct {
+	b            *Backuper
+	event        hookEvent
+	log          *apexLog.Entry
+	mu           sync.Mutex
+	totalTables  int
+	frozenTables map[string]struct{}
+	isDone       bool
+	err          error
+}
+
+func (b *Backuper) newAfterFreezeHook(backupName string, log *apexLog.Entry) *afterFreezeHook {
+	return &afterFreezeHook{
+		b:            b,
+		event:        hookEvent{Stage: "after_freeze", Operation: "create", BackupName: backupName},
+		log:          log,
+		frozenTables: map[string]struct{}{},
+	}
+}
+
+// setTables - count of tables which will be frozen, hooks run when all of them are frozen
+func (h *afterFreezeHook) setTables(totalTables int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.totalTables = totalTables
+	h.mu.Unlock()
+	if totalTables == 0 {
+		h.run(nil)
+	}
+}
+
+// tableFrozen - could be called several times for the same table, skipped and failed tables are counted as frozen too
+func (h *afterFreezeHook) tableFrozen(table string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.frozenTables[table] = struct{}{}
+	isAllFrozen := h.totalTables > 0 && len(h.frozenTables) >= h.totalTables
+	h.mu.Unlock()
+	if isAllFrozen {
+		h.run(nil)
+	}
+}
+
+// run - execute hooks when they were not executed yet, return error of hooks
+func (h *afterFreezeHook) run(createErr error) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.isDone {
+		return h.err
+	}
+	h.isDone = true
+	if createErr != nil {
+		h.event.Error = createErr.Error()
+	}
+	h.err = h.b.runHooks(context.Background(), h.event, h.log)
+	return h.err
+}
+
+// runHookCommand - `sh -c command` with hook timeout, output logged
+func runHookCommand(ctx context.Context, hook config.BackupHook, event hookEvent, log *apexLog.Entry) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.TimeoutDuration)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Env = getHookEnv(event)
+	// background children of sh could keep output open after timeout
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if output := strings.TrimSpace(string(out)); output != "" {
+		log.Info(output)
+	}
+	if err != nil {
+		return fmt.Errorf("`%s` return error: %v", hook.Command, err)
+	}
+	return nil
+}
+
+// runHookURL - POST event as JSON with hook timeout, any status except 2xx is failure
+func runHookURL(ctx context.Context, hook config.BackupHook, event hookEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.TimeoutDuration)
+	defer cancel()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("can't create request to %s: %v", hook.URL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST %s return error: %v", hook.URL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("POST %s return status %d: %s", hook.URL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStageHooks(t *testing.T) {
+	hooks := []config.BackupHook{
+		{Stage: "before_freeze", Command: "first"},
+		{Stage: "after_freeze", Command: "resume"},
+		{Stage: "before_freeze", URL: "http://second"},
+	}
+	stageHooks := getStageHooks(hooks, "before_freeze")
+	require.Len(t, stageHooks, 2)
+	assert.Equal(t, "first", stageHooks[0].Command)
+	assert.Equal(t, "http://second", stageHooks[1].URL)
+	assert.Empty(t, getStageHooks(hooks, "after_restore"))
+}
+
+func TestRunHooks(t *testing.T) {
+	log := apexLog.WithField("logger", "test")
+	outFile := path.Join(t.TempDir(), "hook.out")
+	var received hookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if received.Table == "fail.table" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	b := &Backuper{cfg: config.DefaultConfig()}
+	b.cfg.General.Hooks = []config.BackupHook{
+		{Stage: "after_upload_table", Command: "echo \"$CLICKHOUSE_BACKUP_HOOK_STAGE $CLICKHOUSE_BACKUP_NAME $CLICKHOUSE_BACKUP_TABLE\" > " + outFile, OnFailure: "abort", TimeoutDuration: time.Minute},
+		{Stage: "after_upload_table", URL: server.URL, OnFailure: "abort", TimeoutDuration: time.Minute},
+	}
+	event := hookEvent{Stage: "after_upload_table", Operation: "upload", BackupName: "backup1", Table: "db.table"}
+	require.NoError(t, b.runHooks(context.Background(), event, log))
+	out, err := os.ReadFile(outFile)
+	require.NoError(t, err)
+	assert.Equal(t, "after_upload_table backup1 db.table\n", string(out))
+	assert.Equal(t, event, received)
+
+	event.Table = "fail.table"
+	assert.ErrorContains(t, b.runHooks(context.Background(), event, log), "status 503")
+	b.cfg.General.Hooks[1].OnFailure = "ignore"
+	assert.NoError(t, b.runHooks(context.Background(), event, log))
+
+	b.cfg.General.Hooks = []config.BackupHook{{Stage: "before_freeze", Command: "sleep 5", OnFailure: "abort", TimeoutDuration: 100 * time.Millisecond}}
+	start := time.Now()
+	assert.Error(t, b.runHooks(context.Background(), hookEvent{Stage: "before_freeze"}, log))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestAfterFreezeHook(t *testing.T) {
+	log := apexLog.WithField("logger", "test")
+	outFile := path.Join(t.TempDir(), "hook.out")
+	b := &Backuper{cfg: config.DefaultConfig()}
+	b.cfg.General.Hooks = []config.BackupHook{
+		{Stage: "after_freeze", Command: "echo \"$CLICKHOUSE_BACKUP_HOOK_STAGE $CLICKHOUSE_BACKUP_ERROR\" >> " + outFile, OnFailure: "abort", TimeoutDuration: time.Minute},
+	}
+	hook := b.newAfterFreezeHook("backup1", log)
+	hook.setTables(2)
+	hook.tableFrozen("db.t1")
+	hook.tableFrozen("db.t1")
+	assert.NoFileExists(t, outFile, "hook shall wait until all tables are frozen")
+	hook.tableFrozen("db.t2")
+	require.NoError(t, hook.run(fmt.Errorf("upload failed")))
+	out, err := os.ReadFile(outFile)
+	require.NoError(t, err)
+	assert.Equal(t, "after_freeze \n", string(out), "hook executes once, right after freeze")
+
+	// create failed before freeze, hook resume what before_freeze paused, operation context is not used
+	outFile = path.Join(t.TempDir(), "hook.out")
+	b.cfg.General.Hooks[0].Command = "echo \"$CLICKHOUSE_BACKUP_HOOK_STAGE $CLICKHOUSE_BACKUP_ERROR\" >> " + outFile
+	hook = b.newAfterFreezeHook("backup1", log)
+	hook.setTables(2)
+	require.NoError(t, hook.run(fmt.Errorf("freeze failed")))
+	out, err = os.ReadFile(outFile)
+	require.NoError(t, err)
+	assert.Equal(t, "after_freeze freeze failed\n", string(out))
+
+	var nilHook *afterFreezeHook
+	nilHook.tableFrozen("db.t1")
+	assert.NoError(t, nilHook.run(nil))
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// sha256File - hex encoded sha256 of file content
+func sha256File(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// calculatePartsChecksums - sha256 for each file inside parts, key is <part>/<relative file path>, required parts are not present in local backup
+func calculatePartsChecksums(ctx context.Context, partsPath string, parts []metadata.Part) (map[string]string, error) {
+	checksums := map[string]string{}
+	for _, part := range parts {
+		if part.Required {
+			continue
+		}
+		walkErr := filepath.WalkDir(path.Join(partsPath, part.Name), func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			relativePath, err := filepath.Rel(partsPath, filePath)
+			if err != nil {
+				return err
+			}
+			checksum, err := sha256File(filePath)
+			if err != nil {
+				return err
+			}
+			checksums[filepath.ToSlash(relativePath)] = checksum
+			return nil
+		})
+		if walkErr != nil {
+			return nil, fmt.Errorf("can't calculate checksums for %s: %v", path.Join(partsPath, part.Name), walkErr)
+		}
+	}
+	return checksums, nil
+}
+
+// calculateTableChecksums - files on object disks contain only object references which could be rewritten during restore, so skip them
+func (b *Backuper) calculateTableChecksums(ctx context.Context, backupName string, table clickhouse.Table, disks []clickhouse.Disk, disksToPartsMap map[string][]metadata.Part) (map[string]map[string]string, error) {
+	checksums := map[string]map[string]string{}
+	encodedTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Name))
+	for _, disk := range disks {
+		parts := disksToPartsMap[disk.Name]
+		if len(parts) == 0 || b.isDiskTypeObject(disk.Type) || b.isDiskTypeEncryptedObject(disk, disks) {
+			continue
+		}
+		partsPath := path.Join(disk.Path, "backup", backupName, "shadow", encodedTablePath, disk.Name)
+		diskChecksums, err := calculatePartsChecksums(ctx, partsPath, parts)
+		if err != nil {
+			return nil, err
+		}
+		if len(diskChecksums) > 0 {
+			checksums[disk.Name] = diskChecksums
+		}
+	}
+	return checksums, nil
+}
+
+// verifyTableChecksums - check files of each part present in table.Parts, part could be re-balanced to other disk during download, return count of checked files
+func verifyTableChecksums(ctx context.Context, diskMap map[string]string, backupName string, table metadata.TableMetadata) (int, error) {
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	verified := 0
+	for disk, checksums := range table.Checksums {
+		partDisks := map[string]string{}
+		for _, part := range table.Parts[disk] {
+			partDisks[part.Name] = disk
+			if part.RebalancedDisk != "" {
+				partDisks[part.Name] = part.RebalancedDisk
+			}
+		}
+		for file, expectedChecksum := range checksums {
+			if ctx.Err() != nil {
+				return verified, ctx.Err()
+			}
+			partDisk, exists := partDisks[strings.SplitN(file, "/", 2)[0]]
+			if !exists {
+				continue
+			}
+			diskPath, exists := diskMap[partDisk]
+			if !exists {
+				return verified, fmt.Errorf("`%s`.`%s` disk %s is not present in system.disks", table.Database, table.Table, partDisk)
+			}
+			filePath := path.Join(diskPath, "backup", backupName, "shadow", dbAndTablePath, partDisk, file)
+			actualChecksum, err := sha256File(filePath)
+			if err != nil {
+				return verified, fmt.Errorf("`%s`.`%s` can't verify %s: %v", table.Database, table.Table, filePath, err)
+			}
+			if actualChecksum != expectedChecksum {
+				return verified, fmt.Errorf("`%s`.`%s` checksum mismatch for %s, expected %s, actual %s", table.Database, table.Table, filePath, expectedChecksum, actualChecksum)
+			}
+			verified++
+		}
+	}
+	return verified, nil
+}
+
+// verifyTablesChecksums - return count of checked files
+func (b *Backuper) verifyTablesChecksums(ctx context.Context, backupName string, tables ListOfTables, diskMap map[string]string, concurrency int, log *apexLog.Entry) (int64, error) {
+	var verified int64
+	verifyGroup, verifyCtx := errgroup.WithContext(ctx)
+	verifyGroup.SetLimit(concurrency)
+	for _, table := range tables {
+		if table.MetadataOnly {
+			continue
+		}
+		if len(table.Checksums) == 0 {
+			log.Warnf("`%s`.`%s` doesn't contain checksums, create backup with `integrity_manifest: true`", table.Database, table.Table)
+			continue
+		}
+		verifyGroup.Go(func() error {
+			tableVerified, err := verifyTableChecksums(verifyCtx, diskMap, backupName, table)
+			atomic.AddInt64(&verified, int64(tableVerified))
+			return err
+		})
+	}
+	if err := verifyGroup.Wait(); err != nil {
+		return verified, err
+	}
+	return verified, nil
+}
+
+// Verify - check sha256 of each file in local backup data parts with checksums stored in tables metadata during create
+func (b *Backuper) Verify(backupName string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	startVerify := time.Now()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select backup for verify")
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "verify",
+	})
+	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+		return fmt.Errorf("verify is not supported for embedded backups")
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	if err = b.initDisksPaths(ctx, disks); err != nil {
+		return err
+	}
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	if _, err = os.Stat(metadataPath); err != nil {
+		return fmt.Errorf("'%s' is not found on local storage: %v", backupName, err)
+	}
+	tables, _, err := b.getTableListByPatternLocal(ctx, metadataPath, "*", false, nil)
+	if err != nil {
+		return err
+	}
+	verified, err := b.verifyTablesChecksums(ctx, backupName, tables, b.DiskToPathMap, int(b.cfg.General.DownloadConcurrency), log)
+	if err != nil {
+		return err
+	}
+	log.WithFields(apexLog.Fields{
+		"files":    verified,
+		"duration": utils.HumanizeDuration(time.Since(startVerify)),
+	}).Info("done")
+	return nil
+}
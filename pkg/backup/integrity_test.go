@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableChecksums(t *testing.T) {
+	ctx := context.Background()
+	diskPath := t.TempDir()
+	partsPath := path.Join(diskPath, "backup", "backup1", "shadow", "db", "t1", "default")
+	require.NoError(t, os.MkdirAll(path.Join(partsPath, "all_1_1_0", "p1.proj"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(partsPath, "all_1_1_0", "data.bin"), []byte("data"), 0640))
+	require.NoError(t, os.WriteFile(path.Join(partsPath, "all_1_1_0", "p1.proj", "data.bin"), []byte("projection"), 0640))
+
+	parts := []metadata.Part{{Name: "all_1_1_0"}, {Name: "all_2_2_0", Required: true}}
+	checksums, err := calculatePartsChecksums(ctx, partsPath, parts)
+	require.NoError(t, err)
+	assert.Len(t, checksums, 2)
+	assert.Equal(t, "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", checksums["all_1_1_0/data.bin"])
+	assert.Contains(t, checksums, "all_1_1_0/p1.proj/data.bin")
+
+	table := metadata.TableMetadata{
+		Database:  "db",
+		Table:     "t1",
+		Parts:     map[string][]metadata.Part{"default": parts},
+		Checksums: map[string]map[string]string{"default": checksums},
+	}
+	diskMap := map[string]string{"default": diskPath}
+	verified, err := verifyTableChecksums(ctx, diskMap, "backup1", table)
+	require.NoError(t, err)
+	assert.Equal(t, 2, verified)
+
+	// only parts selected for restore are checked
+	table.Parts["default"] = []metadata.Part{{Name: "all_2_2_0", Required: true}}
+	verified, err = verifyTableChecksums(ctx, diskMap, "backup1", table)
+	require.NoError(t, err)
+	assert.Equal(t, 0, verified)
+
+	table.Parts["default"] = parts
+	require.NoError(t, os.WriteFile(path.Join(partsPath, "all_1_1_0", "data.bin"), []byte("tampered"), 0640))
+	_, err = verifyTableChecksums(ctx, diskMap, "backup1", table)
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	require.NoError(t, os.Remove(path.Join(partsPath, "all_1_1_0", "p1.proj", "data.bin")))
+	require.NoError(t, os.WriteFile(path.Join(partsPath, "all_1_1_0", "data.bin"), []byte("data"), 0640))
+	_, err = verifyTableChecksums(ctx, diskMap, "backup1", table)
+	assert.ErrorContains(t, err, "can't verify")
+
+	// part re-balanced to other disk during download
+	rebalancedPath := path.Join(diskPath, "backup", "backup1", "shadow", "db", "t1", "hdd")
+	require.NoError(t, os.MkdirAll(rebalancedPath, 0750))
+	require.NoError(t, os.Rename(partsPath+"/all_1_1_0", path.Join(rebalancedPath, "all_1_1_0")))
+	table.Parts["default"] = []metadata.Part{{Name: "all_1_1_0", RebalancedDisk: "hdd"}}
+	table.Checksums["default"] = map[string]string{"all_1_1_0/data.bin": checksums["all_1_1_0/data.bin"]}
+	diskMap["hdd"] = diskPath
+	verified, err = verifyTableChecksums(ctx, diskMap, "backup1", table)
+	require.NoError(t, err)
+	assert.Equal(t, 1, verified)
+}
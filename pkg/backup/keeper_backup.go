@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/keeper"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// WithRestoreKeeperOnly - `restore --keeper-only`, re-create missing Keeper znodes from `keeper` directory of backup, schema and data restore skipped
+func WithRestoreKeeperOnly(keeperOnly bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.restoreKeeperOnly = keeperOnly
+	}
+}
+
+// getKeeperDumpFileName - Keeper path encoded as file name, to restore znodes to the same path
+func getKeeperDumpFileName(keeperPath string) string {
+	return common.TablePathEncode(keeperPath) + ".jsonl"
+}
+
+// getKeeperDumpPath - Keeper path decoded from dump file name
+func getKeeperDumpPath(dumpFile string) (string, error) {
+	return url.PathUnescape(strings.TrimSuffix(filepath.Base(dumpFile), ".jsonl"))
+}
+
+// getKeeperBackupPaths - unique sorted paths, nested paths removed cause they already dumped with parent, paths for auxiliary zookeepers like `aux:/path` skipped
+func getKeeperBackupPaths(paths []string, log *apexLog.Entry) []string {
+	var result []string
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/") {
+			log.Warnf("%s is not path in default zookeeper, skip keeper backup", p)
+			continue
+		}
+		result = append(result, path.Clean(p))
+	}
+	sort.Strings(result)
+	unique := result[:0]
+	for _, p := range result {
+		if len(unique) > 0 {
+			last := unique[len(unique)-1]
+			if p == last || last == "/" || strings.HasPrefix(p, last+"/") {
+				continue
+			}
+		}
+		unique = append(unique, p)
+	}
+	return unique
+}
+
+// createBackupKeeper - dump persistent znodes for `zookeeper_path` of replicated tables in backup, replicated user directories and general->keeper_backup_paths into `keeper` directory
+func (b *Backuper) createBackupKeeper(ctx context.Context, backupPath string, tables []clickhouse.Table, disks []clickhouse.Disk, log *apexLog.Entry) (uint64, error) {
+	replicas := make([]struct {
+		Database      string `ch:"database"`
+		Table         string `ch:"table"`
+		ZookeeperPath string `ch:"zookeeper_path"`
+	}, 0)
+	if err := b.ch.SelectContext(ctx, &replicas, "SELECT database, table, zookeeper_path FROM system.replicas"); err != nil {
+		return 0, fmt.Errorf("can't get zookeeper_path from system.replicas: %v", err)
+	}
+	backupTables := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		if !table.Skip {
+			backupTables[table.Database+"."+table.Name] = true
+		}
+	}
+	var paths []string
+	for _, replica := range replicas {
+		if backupTables[replica.Database+"."+replica.Table] {
+			paths = append(paths, replica.ZookeeperPath)
+		}
+	}
+	for _, keeperPath := range b.cfg.General.KeeperBackupPaths {
+		keeperPath, err := b.ch.ApplyMacros(ctx, keeperPath)
+		if err != nil {
+			return 0, err
+		}
+		paths = append(paths, keeperPath)
+	}
+	k := &keeper.Keeper{Log: b.log.WithField("logger", "keeper")}
+	if err := k.Connect(ctx, b.ch); err != nil {
+		return 0, fmt.Errorf("can't connect to keeper for keeper_backup: %v", err)
+	}
+	defer k.Close()
+	replicatedUserDirectories := make([]clickhouse.UserDirectory, 0)
+	if err := b.ch.SelectContext(ctx, &replicatedUserDirectories, "SELECT name FROM system.user_directories WHERE type='replicated'"); err == nil {
+		for _, userDirectory := range replicatedUserDirectories {
+			replicatedAccessPath, err := k.GetReplicatedAccessPath(userDirectory.Name)
+			if err != nil {
+				return 0, err
+			}
+			paths = append(paths, replicatedAccessPath)
+		}
+	}
+	paths = getKeeperBackupPaths(paths, log)
+	if len(paths) == 0 {
+		log.Warn("keeper_backup: true, but nothing to dump")
+		return 0, nil
+	}
+	keeperBackup := path.Join(backupPath, "keeper")
+	if err := os.MkdirAll(keeperBackup, 0755); err != nil {
+		return 0, err
+	}
+	keeperSize := uint64(0)
+	for _, keeperPath := range paths {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		default:
+		}
+		dumpFile := path.Join(keeperBackup, getKeeperDumpFileName(keeperPath))
+		log.Debugf("keeper.DumpPersistent %s -> %s", keeperPath, dumpFile)
+		dumpSize, err := k.DumpPersistent(keeperPath, dumpFile)
+		if err != nil {
+			return 0, err
+		}
+		keeperSize += uint64(dumpSize)
+	}
+	if err := filesystemhelper.Chown(keeperBackup, b.ch, disks, true); err != nil {
+		return 0, err
+	}
+	log.WithField("paths", len(paths)).WithField("size", utils.FormatBytes(keeperSize)).Info("done createBackupKeeper")
+	return keeperSize, nil
+}
+
+// restoreKeeper - re-create missing znodes from each dump file in `keeper` directory of backup, then SYSTEM RESTART REPLICA for readonly replicated tables
+// after SYSTEM RESTART REPLICA tables re-initialize session with restored metadata, use SYSTEM RESTORE REPLICA when replica metadata wasn't in backup
+func (b *Backuper) restoreKeeper(ctx context.Context, backupName string, log *apexLog.Entry) error {
+	dumpFiles, err := filepath.Glob(path.Join(b.DefaultDataPath, "backup", backupName, "keeper", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	if len(dumpFiles) == 0 {
+		return fmt.Errorf("'%s' doesn't contain keeper directory, create backup with `keeper_backup: true`", backupName)
+	}
+	k := &keeper.Keeper{Log: b.log.WithField("logger", "keeper")}
+	if err = k.Connect(ctx, b.ch); err != nil {
+		return fmt.Errorf("can't connect to keeper: %v", err)
+	}
+	defer k.Close()
+	for _, dumpFile := range dumpFiles {
+		keeperPath, err := getKeeperDumpPath(dumpFile)
+		if err != nil {
+			return fmt.Errorf("can't decode keeper path from %s: %v", dumpFile, err)
+		}
+		created, err := k.RestoreMissing(dumpFile, keeperPath)
+		if err != nil {
+			return err
+		}
+		log.WithField("path", keeperPath).Infof("%d znodes created", created)
+	}
+	readonlyReplicas := make([]struct {
+		Database string `ch:"database"`
+		Table    string `ch:"table"`
+	}, 0)
+	if err = b.ch.SelectContext(ctx, &readonlyReplicas, "SELECT database, table FROM system.replicas WHERE is_readonly"); err != nil {
+		return fmt.Errorf("can't get readonly replicas: %v", err)
+	}
+	for _, replica := range readonlyReplicas {
+		if err = b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM RESTART REPLICA `%s`.`%s`", replica.Database, replica.Table)); err != nil {
+			return err
+		}
+		log.WithField("table", fmt.Sprintf("%s.%s", replica.Database, replica.Table)).Info("replica restarted")
+	}
+	return nil
+}
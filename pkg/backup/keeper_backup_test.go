@@ -0,0 +1,32 @@
+package backup
+
+import (
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetKeeperBackupPaths(t *testing.T) {
+	paths := getKeeperBackupPaths([]string{
+		"/clickhouse/tables/01/db/t2",
+		"",
+		"aux:/clickhouse/tables/01/db/t3",
+		"/clickhouse/access/",
+		"/clickhouse/tables/01/db/t1",
+		"/clickhouse/tables/01/db/t1",
+		"/clickhouse/tables/01/db",
+		"/clickhouse/tables/01/db_other",
+	}, apexLog.WithField("logger", "test"))
+	assert.Equal(t, []string{"/clickhouse/access", "/clickhouse/tables/01/db", "/clickhouse/tables/01/db_other"}, paths)
+}
+
+func TestGetKeeperDumpFileName(t *testing.T) {
+	for _, keeperPath := range []string{"/clickhouse/tables/{uuid}/01", "/clickhouse/tables/01/my-db.t_1", "/clickhouse/access"} {
+		fileName := getKeeperDumpFileName(keeperPath)
+		assert.NotContains(t, fileName, "/")
+		decoded, err := getKeeperDumpPath("/var/lib/clickhouse/backup/b/keeper/" + fileName)
+		assert.NoError(t, err)
+		assert.Equal(t, keeperPath, decoded)
+	}
+}
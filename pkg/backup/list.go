@@ -24,9 +24,21 @@ import (
 )
 
 // List - list backups to stdout from command line
-func (b *Backuper) List(what, format string) error {
+func (b *Backuper) List(what, format string, cost, timeline bool, timelinePeriod string) error {
 	ctx, cancel, _ := status.Current.GetContextWithCancel(status.NotFromAPI)
 	defer cancel()
+	if timeline {
+		if cost {
+			return fmt.Errorf("--cost and --timeline can't be used together")
+		}
+		return b.PrintBackupsTimeline(what, timelinePeriod)
+	}
+	if cost {
+		if what != "remote" {
+			return fmt.Errorf("--cost is supported only for `list remote`")
+		}
+		return b.PrintRemoteBackupsCost(ctx)
+	}
 	switch what {
 	case "local":
 		return b.PrintLocalBackups(ctx, format)
@@ -115,6 +127,12 @@ func printBackupsLocal(ctx context.Context, w io.Writer, backupList []LocalBacku
 					}
 					description += backup.Tags
 				}
+				if backup.PartialDownload != nil {
+					if description != "" {
+						description += ", "
+					}
+					description += "partial"
+				}
 				creationDate := backup.CreationDate.Format("02/01/2006 15:04:05")
 				required := ""
 				if backup.RequiredBackup != "" {
@@ -369,7 +387,34 @@ func (b *Backuper) GetRemoteBackups(ctx context.Context, parseMetadata bool) ([]
 	if b.cfg.General.RemoteStorage == "custom" {
 		return custom.List(ctx, b.cfg)
 	}
-	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return []storage.Backup{}, err
+	}
+	if err := bd.Connect(ctx); err != nil {
+		return []storage.Backup{}, err
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	return b.getRemoteBackupList(ctx, bd, parseMetadata)
+}
+
+// GetRemoteBackupsWithLastChain - the same as GetRemoteBackups, but when parseMetadata is false, metadata.json is also parsed for backups required to restore last backup,
+// allow calculate IncrementalChainLength of last backup without parse metadata.json for all remote backups
+func (b *Backuper) GetRemoteBackupsWithLastChain(ctx context.Context, parseMetadata bool) ([]storage.Backup, error) {
+	if parseMetadata || b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return b.GetRemoteBackups(ctx, parseMetadata)
+	}
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return nil, err
+		}
+		defer b.ch.Close()
+	}
+	bd, err := b.newBackupDestination(ctx, false, "")
 	if err != nil {
 		return []storage.Backup{}, err
 	}
@@ -381,6 +426,42 @@ func (b *Backuper) GetRemoteBackups(ctx context.Context, parseMetadata bool) ([]
 			b.log.Warnf("can't close BackupDestination error: %v", err)
 		}
 	}()
+	backupList, err := b.getRemoteBackupList(ctx, bd, false)
+	if err != nil {
+		return backupList, err
+	}
+	b.readLastBackupChainMetadata(ctx, bd, backupList)
+	return backupList, nil
+}
+
+// readLastBackupChainMetadata - parse metadata.json for each backup in required_backup chain of last backup in backupList
+func (b *Backuper) readLastBackupChainMetadata(ctx context.Context, bd *storage.BackupDestination, backupList []storage.Backup) {
+	if len(backupList) == 0 {
+		return
+	}
+	backupIndex := make(map[string]int, len(backupList))
+	for i := range backupList {
+		backupIndex[backupList[i].BackupName] = i
+	}
+	visited := map[string]struct{}{}
+	for requiredBackup := backupList[len(backupList)-1].RequiredBackup; requiredBackup != ""; {
+		i, exists := backupIndex[requiredBackup]
+		if _, isVisited := visited[requiredBackup]; isVisited || !exists {
+			return
+		}
+		visited[requiredBackup] = struct{}{}
+		// metadata could be already cached by previous BackupList
+		if backupList[i].RequiredBackup == "" && backupList[i].CreationDate.IsZero() {
+			if err := b.readRemoteBackupMetadata(ctx, bd, &backupList[i]); err != nil {
+				b.log.Warnf("can't read %s/metadata.json: %v", requiredBackup, err)
+				return
+			}
+		}
+		requiredBackup = backupList[i].RequiredBackup
+	}
+}
+
+func (b *Backuper) getRemoteBackupList(ctx context.Context, bd *storage.BackupDestination, parseMetadata bool) ([]storage.Backup, error) {
 	backupList, err := bd.BackupList(ctx, parseMetadata, "")
 	if err != nil {
 		return []storage.Backup{}, err
@@ -396,6 +477,22 @@ func (b *Backuper) GetRemoteBackups(ctx context.Context, parseMetadata bool) ([]
 	return backupList, err
 }
 
+// readRemoteBackupMetadata - read only metadata.json of one backup without listing of all remote backups
+func (b *Backuper) readRemoteBackupMetadata(ctx context.Context, bd *storage.BackupDestination, backup *storage.Backup) error {
+	r, err := bd.GetFileReader(ctx, path.Join(backup.BackupName, "metadata.json"))
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, &backup.BackupMetadata)
+}
+
 // GetTables - get all tables for use by CreateBackup, PrintTables, and API
 func (b *Backuper) GetTables(ctx context.Context, tablePattern string) ([]clickhouse.Table, error) {
 	if !b.ch.IsOpen {
@@ -483,7 +580,7 @@ func (b *Backuper) GetTablesRemote(ctx context.Context, backupName string, table
 		return nil, fmt.Errorf("GetTablesRemote does not support `none` and `custom` remote storage")
 	}
 	if b.dst == nil {
-		bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+		bd, err := b.newBackupDestination(ctx, false, "")
 		if err != nil {
 			return nil, err
 		}
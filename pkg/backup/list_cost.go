@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+type backupCost struct {
+	Backups      int
+	Size         uint64
+	MonthlyCost  float64
+	UnknownPrice bool
+}
+
+// getRemoteStorageClass - storage class which upload uses for backupName, s3->custom_storage_class_map and gcs->custom_storage_class_map regexp applied to backup name
+func getRemoteStorageClass(cfg *config.Config, backupName string) string {
+	storageClass, customStorageClassMap := "", map[string]string{}
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		storageClass, customStorageClassMap = cfg.S3.StorageClass, cfg.S3.CustomStorageClassMap
+	case "gcs":
+		storageClass, customStorageClassMap = cfg.GCS.StorageClass, cfg.GCS.CustomStorageClassMap
+	}
+	for pattern, customStorageClass := range customStorageClassMap {
+		re := regexp.MustCompile(pattern)
+		if re.MatchString(backupName) {
+			storageClass = customStorageClass
+		}
+	}
+	return storageClass
+}
+
+// getStorageCostPerGB - general->storage_cost_per_gb price for storageClass, case-insensitive, `default` key used for other storage classes
+func getStorageCostPerGB(costPerGB map[string]float64, storageClass string) (float64, bool) {
+	for class, price := range costPerGB {
+		if strings.EqualFold(class, storageClass) {
+			return price, true
+		}
+	}
+	for class, price := range costPerGB {
+		if strings.EqualFold(class, "default") {
+			return price, true
+		}
+	}
+	return 0, false
+}
+
+// calculateMonthlyCost - monthly cost of size bytes stored in storageClass, false when storage class doesn't have price
+func calculateMonthlyCost(costPerGB map[string]float64, storageClass string, size uint64) (float64, bool) {
+	price, exists := getStorageCostPerGB(costPerGB, storageClass)
+	if !exists {
+		return 0, false
+	}
+	return float64(size) / float64(1<<30) * price, true
+}
+
+func formatMonthlyCost(cost backupCost) string {
+	if cost.UnknownPrice {
+		if cost.MonthlyCost > 0 {
+			return fmt.Sprintf(">%.2f", cost.MonthlyCost)
+		}
+		return "unknown"
+	}
+	return fmt.Sprintf("%.2f", cost.MonthlyCost)
+}
+
+// printBackupsRemoteCost - monthly cost of each remote backup, summary for each tag and backups which retention will never delete
+func printBackupsRemoteCost(w io.Writer, cfg *config.Config, backupList []storage.Backup) error {
+	log := apexLog.WithField("logger", "printBackupsRemoteCost")
+	neverDeleted := storage.GetBackupsNeverDeletedRemote(backupList, cfg.General.BackupsToKeepRemote, cfg.General.RetentionPolicies)
+	costByTag := map[string]*backupCost{}
+	total := backupCost{}
+	if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", "backup", "storage class", "size", "monthly cost", "retention policy", "never deleted"); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	for _, backup := range backupList {
+		storageClass := getRemoteStorageClass(cfg, backup.BackupName)
+		size := remoteBackupSize(backup)
+		monthlyCost, hasPrice := calculateMonthlyCost(cfg.General.StorageCostPerGB, storageClass, size)
+		cost := backupCost{Backups: 1, Size: size, MonthlyCost: monthlyCost, UnknownPrice: !hasPrice}
+		tags := backup.Tags
+		if tags == "" {
+			tags = "regular"
+		}
+		for _, tag := range strings.Split(tags, ",") {
+			if _, exists := costByTag[tag]; !exists {
+				costByTag[tag] = &backupCost{}
+			}
+			costByTag[tag].Backups++
+			costByTag[tag].Size += size
+			costByTag[tag].MonthlyCost += cost.MonthlyCost
+			costByTag[tag].UnknownPrice = costByTag[tag].UnknownPrice || cost.UnknownPrice
+		}
+		total.Backups++
+		total.Size += size
+		total.MonthlyCost += cost.MonthlyCost
+		total.UnknownPrice = total.UnknownPrice || cost.UnknownPrice
+		if storageClass == "" {
+			storageClass = "default"
+		}
+		if bytes, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", backup.BackupName, storageClass, utils.FormatBytes(size), formatMonthlyCost(cost), storage.GetRetentionPolicyName(cfg.General.RetentionPolicies, backup), neverDeleted[backup.BackupName]); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+	}
+	tags := make([]string, 0, len(costByTag))
+	for tag := range costByTag {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	if bytes, err := fmt.Fprintf(w, "\n%s\t%s\t%s\t%s\n", "tag", "backups", "size", "monthly cost"); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	for _, tag := range tags {
+		if bytes, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", tag, costByTag[tag].Backups, utils.FormatBytes(costByTag[tag].Size), formatMonthlyCost(*costByTag[tag])); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+	}
+	if bytes, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", "*", total.Backups, utils.FormatBytes(total.Size), formatMonthlyCost(total)); err != nil {
+		log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+	}
+	if len(neverDeleted) > 0 {
+		if bytes, err := fmt.Fprintf(w, "\n%d backups will never be deleted by retention\n", len(neverDeleted)); err != nil {
+			log.Errorf("fmt.Fprintf write %d bytes return error: %v", bytes, err)
+		}
+	}
+	return nil
+}
+
+// PrintRemoteBackupsCost - print `list remote --cost` report
+func (b *Backuper) PrintRemoteBackupsCost(ctx context.Context) error {
+	if b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("--cost is not supported for `remote_storage: custom`")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	defer func() {
+		if err := w.Flush(); err != nil {
+			b.log.Errorf("can't flush tabular writer error: %v", err)
+		}
+	}()
+	if len(b.cfg.General.StorageCostPerGB) == 0 {
+		b.log.Warn("general->storage_cost_per_gb is empty, monthly cost is unknown")
+	}
+	backupList, err := b.GetRemoteBackups(ctx, true)
+	if err != nil {
+		return err
+	}
+	return printBackupsRemoteCost(w, b.cfg, backupList)
+}
@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateMonthlyCost(t *testing.T) {
+	costPerGB := map[string]float64{"standard": 0.02, "Default": 0.01}
+	cost, hasPrice := calculateMonthlyCost(costPerGB, "STANDARD", 10<<30)
+	assert.True(t, hasPrice)
+	assert.InDelta(t, 0.2, cost, 0.0001)
+	cost, hasPrice = calculateMonthlyCost(costPerGB, "GLACIER", 1<<30)
+	assert.True(t, hasPrice)
+	assert.InDelta(t, 0.01, cost, 0.0001)
+	_, hasPrice = calculateMonthlyCost(map[string]float64{"STANDARD": 0.02}, "", 1<<30)
+	assert.False(t, hasPrice)
+}
+
+func TestGetRemoteStorageClass(t *testing.T) {
+	cfg := &config.Config{General: config.GeneralConfig{RemoteStorage: "s3"}, S3: config.S3Config{StorageClass: "STANDARD", CustomStorageClassMap: map[string]string{"^monthly-": "GLACIER_IR"}}}
+	assert.Equal(t, "STANDARD", getRemoteStorageClass(cfg, "daily-1"))
+	assert.Equal(t, "GLACIER_IR", getRemoteStorageClass(cfg, "monthly-1"))
+	cfg.General.RemoteStorage = "sftp"
+	assert.Equal(t, "", getRemoteStorageClass(cfg, "monthly-1"))
+}
+
+func TestPrintBackupsRemoteCost(t *testing.T) {
+	cfg := &config.Config{General: config.GeneralConfig{RemoteStorage: "s3", BackupsToKeepRemote: 0, StorageCostPerGB: map[string]float64{"STANDARD": 0.5}}, S3: config.S3Config{StorageClass: "STANDARD"}}
+	backupList := []storage.Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "full", Tags: "regular", CompressedSize: 2 << 30}, UploadDate: time.Now()},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "embedded", Tags: "embedded", DataSize: 1 << 30}, UploadDate: time.Now()},
+	}
+	out := &bytes.Buffer{}
+	assert.NoError(t, printBackupsRemoteCost(out, cfg, backupList))
+	assert.Contains(t, out.String(), "full\tSTANDARD\t2.00GiB\t1.00\tdefault\tbackups_to_keep_remote is 0\n")
+	assert.Contains(t, out.String(), "embedded\t1\t1.00GiB\t0.50\n")
+	assert.Contains(t, out.String(), "*\t2\t3.00GiB\t1.50\n")
+	assert.Contains(t, out.String(), "2 backups will never be deleted by retention\n")
+}
@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// timelineBackup - local or remote backup for `list --timeline`
+type timelineBackup struct {
+	BackupName     string
+	Location       string
+	CreationDate   time.Time
+	Size           uint64
+	RequiredBackup string
+	// Chain - name of full backup at the root of incremental chain and depth of current backup in chain
+	Chain      string
+	ChainDepth int
+	Status     string
+	// Broken - backup or any backup in its chain can't be restored
+	Broken bool
+}
+
+// timelinePeriod - backups created during one week or month
+type timelinePeriod struct {
+	Name    string
+	Size    uint64
+	Backups []timelineBackup
+}
+
+// getTimelinePeriodName - `2006-01` for month, ISO week `2006-W01` for week
+func getTimelinePeriodName(t time.Time, period string) string {
+	if period == "week" {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return t.Format("2006-01")
+}
+
+// getBackupsTimeline - resolve incremental chains inside each location, backup with required backup which doesn't exist is marked as broken chain
+// backups grouped by period of creation date, periods and backups inside period sorted by creation date
+func getBackupsTimeline(backups []timelineBackup, period string) ([]timelinePeriod, error) {
+	if period != "week" && period != "month" {
+		return nil, fmt.Errorf("unknown timeline period %q, valid options: week, month", period)
+	}
+	byName := make(map[string]int, len(backups))
+	for i, backup := range backups {
+		byName[backup.Location+"/"+backup.BackupName] = i
+	}
+	for i := range backups {
+		backups[i].Chain = backups[i].BackupName
+		backups[i].ChainDepth = 0
+		visited := map[string]bool{backups[i].BackupName: true}
+		current := backups[i]
+		for current.RequiredBackup != "" {
+			j, exists := byName[current.Location+"/"+current.RequiredBackup]
+			if !exists || visited[current.RequiredBackup] {
+				if !backups[i].Broken {
+					backups[i].Broken = true
+					backups[i].Status = fmt.Sprintf("broken chain, %s not found", current.RequiredBackup)
+				}
+				break
+			}
+			visited[current.RequiredBackup] = true
+			current = backups[j]
+			backups[i].Chain = current.BackupName
+			backups[i].ChainDepth++
+			if current.Broken && !backups[i].Broken {
+				backups[i].Broken = true
+				backups[i].Status = fmt.Sprintf("broken chain, required %s is broken", current.BackupName)
+			}
+		}
+		if backups[i].Status == "" {
+			backups[i].Status = "ok"
+		}
+	}
+	periods := map[string]*timelinePeriod{}
+	var periodNames []string
+	for _, backup := range backups {
+		name := getTimelinePeriodName(backup.CreationDate, period)
+		if _, exists := periods[name]; !exists {
+			periods[name] = &timelinePeriod{Name: name}
+			periodNames = append(periodNames, name)
+		}
+		periods[name].Size += backup.Size
+		periods[name].Backups = append(periods[name].Backups, backup)
+	}
+	sort.Strings(periodNames)
+	result := make([]timelinePeriod, 0, len(periodNames))
+	for _, name := range periodNames {
+		p := periods[name]
+		sort.SliceStable(p.Backups, func(i, j int) bool {
+			return p.Backups[i].CreationDate.Before(p.Backups[j].CreationDate)
+		})
+		result = append(result, *p)
+	}
+	return result, nil
+}
+
+// printBackupsTimeline - period header with count and size, then backups with chain relationship and status
+func printBackupsTimeline(w io.Writer, periods []timelinePeriod) error {
+	for i, p := range periods {
+		separator := ""
+		if i > 0 {
+			separator = "\n"
+		}
+		if _, err := fmt.Fprintf(w, "%s%s\t%d backups\t%s\t\t\t\n", separator, p.Name, len(p.Backups), utils.FormatBytes(p.Size)); err != nil {
+			return err
+		}
+		for _, backup := range p.Backups {
+			chain := "full"
+			if backup.ChainDepth > 0 {
+				chain = fmt.Sprintf("%s└ +%s (chain %s, depth %d)", strings.Repeat("  ", backup.ChainDepth-1), backup.RequiredBackup, backup.Chain, backup.ChainDepth)
+			} else if backup.RequiredBackup != "" {
+				chain = "+" + backup.RequiredBackup
+			}
+			if _, err := fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\n", backup.CreationDate.Format("02/01/2006 15:04:05"), backup.BackupName, utils.FormatBytes(backup.Size), backup.Location, chain, backup.Status); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrintBackupsTimeline - `list --timeline`, backups grouped by week or month with incremental chains, sizes and verification status
+func (b *Backuper) PrintBackupsTimeline(what, period string) error {
+	ctx, cancel, _ := status.Current.GetContextWithCancel(status.NotFromAPI)
+	defer cancel()
+	if period == "" {
+		period = "month"
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	var backups []timelineBackup
+	if what == "local" || what == "all" || what == "" {
+		localBackups, _, err := b.GetLocalBackups(ctx, nil)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, backup := range localBackups {
+			backups = append(backups, timelineBackup{
+				BackupName:     backup.BackupName,
+				Location:       "local",
+				CreationDate:   backup.CreationDate,
+				Size:           backup.DataSize + backup.MetadataSize,
+				RequiredBackup: backup.RequiredBackup,
+				Status:         backup.Broken,
+				Broken:         backup.Broken != "",
+			})
+		}
+	}
+	if (what == "remote" || what == "all" || what == "") && b.cfg.General.RemoteStorage != "none" {
+		remoteBackups, err := b.GetRemoteBackups(ctx, true)
+		if err != nil {
+			return err
+		}
+		signatureStatuses, err := b.getRemoteSignatureStatuses(ctx, remoteBackups)
+		if err != nil {
+			return err
+		}
+		for _, backup := range remoteBackups {
+			backupStatus, isBroken := backup.Broken, backup.Broken != ""
+			if !isBroken {
+				for _, destination := range backup.Destinations {
+					if destination.Status == status.ErrorStatus {
+						backupStatus = fmt.Sprintf("upload to destination %s failed", destination.Name)
+						break
+					}
+				}
+			}
+			if signatureStatus, exists := signatureStatuses[backup.BackupName]; exists && backupStatus == "" {
+				backupStatus, isBroken = signatureStatus, signatureStatus != "signature verified"
+			}
+			backups = append(backups, timelineBackup{
+				BackupName:     backup.BackupName,
+				Location:       "remote",
+				CreationDate:   backup.CreationDate,
+				Size:           remoteBackupSize(backup),
+				RequiredBackup: backup.RequiredBackup,
+				Status:         backupStatus,
+				Broken:         isBroken,
+			})
+		}
+	}
+	periods, err := getBackupsTimeline(backups, period)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	if err = printBackupsTimeline(w, periods); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// getRemoteSignatureStatuses - when general->verify_public_key_file defined, verify signature.json of each remote backup which is not broken
+func (b *Backuper) getRemoteSignatureStatuses(ctx context.Context, remoteBackups []storage.Backup) (map[string]string, error) {
+	statuses := map[string]string{}
+	if b.cfg.General.VerifyPublicKeyFile == "" || b.cfg.General.RemoteStorage == "custom" {
+		return statuses, nil
+	}
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	b.dst = bd
+	for _, backup := range remoteBackups {
+		if backup.Broken != "" {
+			continue
+		}
+		if _, err = b.getVerifiedSignature(ctx, backup.BackupName); err != nil {
+			apexLog.WithField("backup", backup.BackupName).Warnf("%v", err)
+			statuses[backup.BackupName] = "signature verification failed"
+		} else {
+			statuses[backup.BackupName] = "signature verified"
+		}
+	}
+	return statuses, nil
+}
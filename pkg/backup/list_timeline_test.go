@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBackupsTimeline(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	backups := []timelineBackup{
+		{BackupName: "inc2", Location: "remote", CreationDate: day(time.March, 3), Size: 1, RequiredBackup: "inc1"},
+		{BackupName: "full", Location: "remote", CreationDate: day(time.February, 28), Size: 10},
+		{BackupName: "inc1", Location: "remote", CreationDate: day(time.March, 1), Size: 2, RequiredBackup: "full"},
+		{BackupName: "orphan", Location: "remote", CreationDate: day(time.March, 4), Size: 3, RequiredBackup: "deleted"},
+		{BackupName: "inc1", Location: "local", CreationDate: day(time.March, 1), Size: 5, RequiredBackup: "full"},
+		{BackupName: "broken", Location: "remote", CreationDate: day(time.March, 5), Status: "broken (can't stat metadata.json)", Broken: true},
+		{BackupName: "after_broken", Location: "remote", CreationDate: day(time.March, 6), RequiredBackup: "broken"},
+	}
+	periods, err := getBackupsTimeline(backups, "month")
+	assert.NoError(t, err)
+	assert.Len(t, periods, 2)
+	assert.Equal(t, "2024-02", periods[0].Name)
+	assert.Equal(t, "2024-03", periods[1].Name)
+	assert.Equal(t, uint64(11), periods[1].Size)
+	byName := map[string]timelineBackup{}
+	for _, p := range periods {
+		for _, b := range p.Backups {
+			byName[b.Location+"/"+b.BackupName] = b
+		}
+	}
+	assert.Equal(t, "full", byName["remote/inc2"].Chain)
+	assert.Equal(t, 2, byName["remote/inc2"].ChainDepth)
+	assert.Equal(t, "ok", byName["remote/inc2"].Status)
+	assert.Equal(t, "broken chain, deleted not found", byName["remote/orphan"].Status)
+	assert.Equal(t, "broken chain, full not found", byName["local/inc1"].Status)
+	assert.Equal(t, "broken chain, required broken is broken", byName["remote/after_broken"].Status)
+	assert.Equal(t, []string{"inc1", "inc1", "inc2", "orphan", "broken", "after_broken"}, func() []string {
+		var names []string
+		for _, b := range periods[1].Backups {
+			names = append(names, b.BackupName)
+		}
+		return names
+	}())
+
+	periods, err = getBackupsTimeline(backups, "week")
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-W09", periods[0].Name)
+
+	_, err = getBackupsTimeline(backups, "year")
+	assert.Error(t, err)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, printBackupsTimeline(out, periods[:1]))
+	assert.Contains(t, out.String(), "2024-W09")
+	assert.Contains(t, out.String(), "└ +full (chain full, depth 1)")
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+)
+
+// WithForceGC - `delete remote --force-gc`, after delete warn about absent object disk data required by remaining backups and delete object disk data which is not referenced by any backup
+func WithForceGC(forceGC bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.forceGC = forceGC
+	}
+}
+
+// getRequiredBackupsChainOf - required_backups_chain persisted during upload keeps names of required backups which were deleted before,
+// it is continued by requiredBackups for backups uploaded without required_backups_chain and for local backups
+func getRequiredBackupsChainOf(backup metadata.BackupMetadata, requiredBackups map[string]string) []string {
+	var chain []string
+	visited := map[string]bool{backup.BackupName: true}
+	appendRequired := func(name string) bool {
+		if name == "" || visited[name] {
+			return false
+		}
+		visited[name] = true
+		chain = append(chain, name)
+		return true
+	}
+	next := backup.RequiredBackup
+	// chain is stale when required_backup was changed without required_backups_chain
+	if len(backup.RequiredBackupsChain) > 0 && backup.RequiredBackupsChain[0] == backup.RequiredBackup {
+		for _, name := range backup.RequiredBackupsChain {
+			if !appendRequired(name) {
+				return chain
+			}
+		}
+		next = requiredBackups[chain[len(chain)-1]]
+	}
+	for name := next; appendRequired(name); name = requiredBackups[name] {
+	}
+	return chain
+}
+
+// getObjectDiskReferences - backup name -> backups which copy object disk data from <object_disk_path>/<backup name> during restore,
+// required data parts are copied from backup which uploaded them, so each backup references itself and each backup of its required_backup chain
+func getObjectDiskReferences(backups []metadata.BackupMetadata, requiredBackups map[string]string) map[string][]string {
+	references := map[string][]string{}
+	for _, backup := range backups {
+		for _, name := range append([]string{backup.BackupName}, getRequiredBackupsChainOf(backup, requiredBackups)...) {
+			isReferenced := false
+			for _, referencedBy := range references[name] {
+				if referencedBy == backup.BackupName {
+					isReferenced = true
+					break
+				}
+			}
+			if !isReferenced {
+				references[name] = append(references[name], backup.BackupName)
+			}
+		}
+	}
+	return references
+}
+
+// getRemainingObjectDiskReferences - references of remote backups with object disks, and local backups when withLocal is true, except deletedBackup,
+// required_backup of listed backups is remembered in knownRequiredBackups
+func (b *Backuper) getRemainingObjectDiskReferences(ctx context.Context, deletedBackup string, withLocal bool) (map[string][]string, error) {
+	if b.knownRequiredBackups == nil {
+		b.knownRequiredBackups = map[string]string{}
+	}
+	var backups []metadata.BackupMetadata
+	remoteBackups, err := b.dst.BackupListWithoutCatalog(ctx, true, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range remoteBackups {
+		if backup.Broken != "" {
+			continue
+		}
+		b.knownRequiredBackups[backup.BackupName] = backup.RequiredBackup
+		if backup.BackupName != deletedBackup && b.hasObjectDisksRemote(backup) {
+			backups = append(backups, backup.BackupMetadata)
+		}
+	}
+	if !withLocal {
+		return getObjectDiskReferences(backups, b.knownRequiredBackups), nil
+	}
+	// local backup copies object disk data during `create`, so it references object disk data before upload
+	localBackups, _, err := b.GetLocalBackups(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range localBackups {
+		if backup.Broken != "" || backup.BackupName == deletedBackup {
+			continue
+		}
+		if _, isRemote := b.knownRequiredBackups[backup.BackupName]; !isRemote {
+			b.knownRequiredBackups[backup.BackupName] = backup.RequiredBackup
+		}
+		if b.hasObjectDisksRemote(storage.Backup{BackupMetadata: backup.BackupMetadata}) {
+			backups = append(backups, backup.BackupMetadata)
+		}
+	}
+	return getObjectDiskReferences(backups, b.knownRequiredBackups), nil
+}
+
+// cleanObjectDisksIfNotReferenced - <object_disk_path>/<backup name> is kept while remaining backups require data parts of deleted backup,
+// it is deleted together with data of required backups, which were deleted before, when last dependent backup is deleted
+func (b *Backuper) cleanObjectDisksIfNotReferenced(ctx context.Context, backup storage.Backup, log *apexLog.Entry) error {
+	references, err := b.getRemainingObjectDiskReferences(ctx, backup.BackupName, true)
+	if err != nil {
+		return err
+	}
+	released := make([]string, 0)
+	if referencedBy := references[backup.BackupName]; len(referencedBy) > 0 {
+		log.Warnf("keep object disk data of %s, required by %s, it will be deleted with last of them", backup.BackupName, strings.Join(referencedBy, ", "))
+	} else {
+		released = append(released, backup.BackupName)
+	}
+	// required backups which are still present reference own object disk data, only deleted before are released
+	for _, name := range getRequiredBackupsChainOf(backup.BackupMetadata, b.knownRequiredBackups) {
+		if len(references[name]) == 0 {
+			released = append(released, name)
+		}
+	}
+	for _, name := range released {
+		if deletedKeys, deleteErr := b.cleanBackupObjectDisks(ctx, name); deleteErr != nil {
+			log.Warnf("b.cleanBackupObjectDisks(%s) return error: %v", name, deleteErr)
+		} else {
+			log.Infof("cleanBackupObjectDisks(%s) deleted %d keys", name, deletedKeys)
+		}
+	}
+	return nil
+}
+
+// forceObjectDiskGC - `delete remote --force-gc`, warn about absent object disk data required by remaining backups, delete object disk data which is not referenced by any backup
+// object disk data modified later than defaultGCMinAge ago is skipped, cause it could belong to `create` which is still running
+func (b *Backuper) forceObjectDiskGC(ctx context.Context, log *apexLog.Entry) error {
+	if b.cfg.General.RemoteStorage != "s3" && b.cfg.General.RemoteStorage != "azblob" && b.cfg.General.RemoteStorage != "gcs" {
+		return nil
+	}
+	objectDiskPath, err := b.getObjectDiskPath()
+	if err != nil || objectDiskPath == "" {
+		return err
+	}
+	references, err := b.getRemainingObjectDiskReferences(ctx, "", true)
+	if err != nil {
+		return err
+	}
+	existingData := map[string]bool{}
+	if err = b.dst.WalkAbsolute(ctx, objectDiskPath, false, func(ctx context.Context, f storage.RemoteFile) error {
+		existingData[strings.Trim(f.Name(), "/")] = true
+		return nil
+	}); err != nil {
+		return fmt.Errorf("can't list %s: %v", objectDiskPath, err)
+	}
+	for name, referencedBy := range references {
+		if !existingData[name] {
+			log.Warnf("object disk data of %s is absent in %s, required data parts of %s could fail to restore", name, objectDiskPath, strings.Join(referencedBy, ", "))
+		}
+	}
+	orphaned, err := b.findOrphanedObjectDiskObjects(ctx, references, time.Now().Add(-defaultGCMinAge), log)
+	if err != nil {
+		return err
+	}
+	if err = b.deleteGarbage(ctx, orphaned); err != nil {
+		return err
+	}
+	log.Infof("force gc deleted %d not referenced object disk keys", len(orphaned))
+	return nil
+}
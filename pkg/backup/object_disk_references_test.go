@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetObjectDiskReferences(t *testing.T) {
+	// full <- increment1 <- increment2, deleted <- orphan_increment, increment1 is deleted
+	requiredBackups := map[string]string{
+		"full":             "",
+		"increment1":       "full",
+		"increment2":       "increment1",
+		"orphan_increment": "deleted",
+		"cycle1":           "cycle2",
+		"cycle2":           "cycle1",
+	}
+	backups := []metadata.BackupMetadata{
+		{BackupName: "full"},
+		{BackupName: "increment2", RequiredBackup: "increment1"},
+		{BackupName: "orphan_increment", RequiredBackup: "deleted"},
+		{BackupName: "cycle1", RequiredBackup: "cycle2"},
+	}
+	references := getObjectDiskReferences(backups, requiredBackups)
+	assert.Equal(t, []string{"full", "increment2"}, references["full"])
+	assert.Equal(t, []string{"increment2"}, references["increment1"], "object disk data of deleted increment is required by next increment")
+	assert.Equal(t, []string{"increment2"}, references["increment2"])
+	assert.Equal(t, []string{"orphan_increment"}, references["deleted"])
+	assert.Equal(t, []string{"cycle1"}, references["cycle2"])
+	assert.NotContains(t, references, "unknown")
+
+	// last increment deleted, whole chain is released except full backup which references itself
+	references = getObjectDiskReferences([]metadata.BackupMetadata{{BackupName: "full"}}, requiredBackups)
+	assert.Empty(t, references["increment1"])
+	assert.Empty(t, references["increment2"])
+	assert.Equal(t, []string{"full"}, references["full"])
+}
+
+func TestGetRequiredBackupsChainOf(t *testing.T) {
+	requiredBackups := map[string]string{
+		"increment3": "increment2",
+		"full":       "",
+		"old_full":   "",
+	}
+	// increment2 and increment1 were deleted before, their names are known only from required_backups_chain
+	increment3 := metadata.BackupMetadata{BackupName: "increment3", RequiredBackup: "increment2", RequiredBackupsChain: []string{"increment2", "increment1", "full"}}
+	assert.Equal(t, []string{"increment2", "increment1", "full"}, getRequiredBackupsChainOf(increment3, requiredBackups))
+	// uploaded without required_backups_chain
+	assert.Equal(t, []string{"increment2"}, getRequiredBackupsChainOf(metadata.BackupMetadata{BackupName: "increment3", RequiredBackup: "increment2"}, requiredBackups))
+	// chain was uploaded before required backup on remote storage was known, rest of chain comes from listing
+	partial := metadata.BackupMetadata{BackupName: "partial", RequiredBackup: "increment3", RequiredBackupsChain: []string{"increment3"}}
+	assert.Equal(t, []string{"increment3", "increment2"}, getRequiredBackupsChainOf(partial, requiredBackups))
+	// required_backup was changed without required_backups_chain
+	stale := metadata.BackupMetadata{BackupName: "stale", RequiredBackup: "old_full", RequiredBackupsChain: []string{"increment2", "increment1", "full"}}
+	assert.Equal(t, []string{"old_full"}, getRequiredBackupsChainOf(stale, requiredBackups))
+	assert.Empty(t, getRequiredBackupsChainOf(metadata.BackupMetadata{BackupName: "full"}, requiredBackups))
+}
+
+func TestGetRemainingObjectDiskReferencesWithoutLocal(t *testing.T) {
+	backupMetadata := func(backup metadata.BackupMetadata) []byte {
+		backup.DiskTypes = map[string]string{"s3": "s3"}
+		body, err := json.Marshal(backup)
+		require.NoError(t, err)
+		return body
+	}
+	// full <- increment1 <- increment2 <- increment3, increment1 and increment2 were deleted by earlier commands
+	remote := &gcTestStorage{
+		modified: time.Now().Add(-48 * time.Hour),
+		objects: map[string][]byte{
+			"full/metadata.json":       backupMetadata(metadata.BackupMetadata{BackupName: "full"}),
+			"increment3/metadata.json": backupMetadata(metadata.BackupMetadata{BackupName: "increment3", RequiredBackup: "increment2", RequiredBackupsChain: []string{"increment2", "increment1", "full"}}),
+		},
+	}
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteMetadataCacheDuration = 0
+	b := NewBackuper(cfg)
+	b.dst = storage.NewBackupDestinationFromRemoteStorage(cfg, remote, apexLog.WithField("logger", "test"))
+
+	// local backups are not listed, so clickhouse connection is not required
+	references, err := b.getRemainingObjectDiskReferences(context.Background(), "increment3", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"full": {"full"}}, references)
+
+	references, err = b.getRemainingObjectDiskReferences(context.Background(), "", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"increment3"}, references["increment1"])
+	assert.Equal(t, []string{"increment3"}, references["increment2"])
+	assert.Equal(t, []string{"full", "increment3"}, references["full"])
+}
@@ -0,0 +1,352 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/klauspost/reedsolomon"
+	"golang.org/x/sync/errgroup"
+)
+
+// parityBlockSize - each stripe reads parityBlockSize bytes from each archive in group
+const parityBlockSize = 1024 * 1024
+
+// getParityArchiveGroups - uploaded archives sorted by disk, each group contains up to dataShards archives
+func getParityArchiveGroups(files map[string][]string, dataShards int) [][]string {
+	disks := make([]string, 0, len(files))
+	for disk := range files {
+		disks = append(disks, disk)
+	}
+	sort.Strings(disks)
+	var archives []string
+	for _, disk := range disks {
+		archives = append(archives, files[disk]...)
+	}
+	var groups [][]string
+	for len(archives) > 0 {
+		groupSize := min(dataShards, len(archives))
+		groups = append(groups, archives[:groupSize])
+		archives = archives[groupSize:]
+	}
+	return groups
+}
+
+// findParityGroup - return -1 when archive is not covered by parity
+func findParityGroup(groups []metadata.ParityGroup, archiveFile string) (metadata.ParityGroup, int) {
+	for _, group := range groups {
+		for i, file := range group.Files {
+			if file == archiveFile {
+				return group, i
+			}
+		}
+	}
+	return metadata.ParityGroup{}, -1
+}
+
+// parityArchiveSpool - local copies of archives written from upload stream, so parity is computed without reading uploaded archives back from remote storage
+type parityArchiveSpool struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func newParityArchiveSpool() *parityArchiveSpool {
+	return &parityArchiveSpool{files: map[string]string{}}
+}
+
+// create - each upload attempt writes new copy, copy of failed attempt is removed
+func (s *parityArchiveSpool) create(archiveFile, dir string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, "parity_*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, exists := s.files[archiveFile]; exists {
+		_ = os.Remove(previous)
+	}
+	s.files[archiveFile] = f.Name()
+	return f, nil
+}
+
+// open - false when archive was not uploaded with current spool, for example skipped by --resume
+func (s *parityArchiveSpool) open(archiveFile string) (io.ReadCloser, bool, error) {
+	if s == nil {
+		return nil, false, nil
+	}
+	s.mu.Lock()
+	localFile, exists := s.files[archiveFile]
+	s.mu.Unlock()
+	if !exists {
+		return nil, false, nil
+	}
+	f, err := os.Open(localFile)
+	return f, true, err
+}
+
+func (s *parityArchiveSpool) remove() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for archiveFile, localFile := range s.files {
+		if err := os.Remove(localFile); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+		delete(s.files, archiveFile)
+	}
+	return errors.Join(errs...)
+}
+
+// uploadTableParity - archives are read from spool, archives which are absent in spool are read back from remote storage, return parity groups and uploaded parity size
+func (b *Backuper) uploadTableParity(ctx context.Context, backupName string, table metadata.TableMetadata, spool *parityArchiveSpool) ([]metadata.ParityGroup, int64, error) {
+	remotePath := path.Join(backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	openArchive := func(ctx context.Context, file string) (io.ReadCloser, error) {
+		if reader, isSpooled, err := spool.open(file); isSpooled {
+			return reader, err
+		}
+		return b.dst.GetFileReader(ctx, path.Join(remotePath, file))
+	}
+	var groups []metadata.ParityGroup
+	var paritySize int64
+	for groupNum, files := range getParityArchiveGroups(table.Files, b.cfg.General.ParityDataShards) {
+		var group metadata.ParityGroup
+		retry := b.newRetrier()
+		err := retry.RunCtx(ctx, func(ctx context.Context) error {
+			var writeErr error
+			group, writeErr = b.writeParityGroup(ctx, remotePath, groupNum, files, openArchive)
+			return writeErr
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("can't write parity for %s: %v", remotePath, err)
+		}
+		var maxSize int64
+		for _, size := range group.Sizes {
+			maxSize = max(maxSize, size)
+		}
+		paritySize += maxSize * int64(len(group.ParityFiles))
+		groups = append(groups, group)
+	}
+	return groups, paritySize, nil
+}
+
+func (b *Backuper) writeParityGroup(ctx context.Context, remotePath string, groupNum int, files []string, openArchive func(ctx context.Context, file string) (io.ReadCloser, error)) (metadata.ParityGroup, error) {
+	rs, err := reedsolomon.New(len(files), b.cfg.General.ParityShards)
+	if err != nil {
+		return metadata.ParityGroup{}, err
+	}
+	group := metadata.ParityGroup{
+		Files:     files,
+		Sizes:     make([]int64, len(files)),
+		Checksums: make([]string, len(files)),
+	}
+	readers := make([]io.Reader, len(files))
+	hashes := make([]hash.Hash, len(files))
+	for i, file := range files {
+		reader, err := openArchive(ctx, file)
+		if err != nil {
+			return group, fmt.Errorf("can't read %s: %v", path.Join(remotePath, file), err)
+		}
+		defer func() {
+			if closeErr := reader.Close(); closeErr != nil {
+				b.log.Warnf("can't close %s: %v", path.Join(remotePath, file), closeErr)
+			}
+		}()
+		h := sha256.New()
+		hashes[i] = h
+		readers[i] = io.TeeReader(reader, h)
+	}
+	writers := make([]*io.PipeWriter, b.cfg.General.ParityShards)
+	uploadGroup, uploadCtx := errgroup.WithContext(ctx)
+	for i := range writers {
+		parityFile := fmt.Sprintf("parity_%d_%d.rs", groupNum, i)
+		group.ParityFiles = append(group.ParityFiles, parityFile)
+		pipeReader, pipeWriter := io.Pipe()
+		writers[i] = pipeWriter
+		uploadGroup.Go(func() error {
+			putErr := b.dst.PutFile(uploadCtx, path.Join(remotePath, parityFile), pipeReader)
+			// unblock encoding when upload failed
+			_ = pipeReader.CloseWithError(putErr)
+			return putErr
+		})
+	}
+	encodeErr := encodeParityStripes(rs, readers, group.Sizes, writers)
+	for _, pipeWriter := range writers {
+		_ = pipeWriter.CloseWithError(encodeErr)
+	}
+	if err = uploadGroup.Wait(); encodeErr == nil && err != nil {
+		encodeErr = err
+	}
+	if encodeErr != nil {
+		return group, encodeErr
+	}
+	for i := range hashes {
+		group.Checksums[i] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return group, nil
+}
+
+// encodeParityStripes - archive shorter than longest archive in group is padded with zeros
+func encodeParityStripes(rs reedsolomon.Encoder, readers []io.Reader, sizes []int64, writers []*io.PipeWriter) error {
+	data := make([][]byte, len(readers))
+	for i := range data {
+		data[i] = make([]byte, parityBlockSize)
+	}
+	parityShards := make([][]byte, len(writers))
+	for i := range parityShards {
+		parityShards[i] = make([]byte, parityBlockSize)
+	}
+	stripe := make([][]byte, len(readers)+len(writers))
+	for {
+		stripeLen := 0
+		for i, reader := range readers {
+			n, err := io.ReadFull(reader, data[i])
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			clear(data[i][n:])
+			sizes[i] += int64(n)
+			stripeLen = max(stripeLen, n)
+		}
+		if stripeLen == 0 {
+			return nil
+		}
+		for i := range data {
+			stripe[i] = data[i][:stripeLen]
+		}
+		for i := range parityShards {
+			stripe[len(data)+i] = parityShards[i][:stripeLen]
+		}
+		if err := rs.Encode(stripe); err != nil {
+			return err
+		}
+		for i, writer := range writers {
+			if _, err := writer.Write(stripe[len(data)+i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// rebuildArchive - restore archive with index missing in group from other archives and parity archives, unavailable archives are skipped
+func (b *Backuper) rebuildArchive(remotePath string, group metadata.ParityGroup, missing int, getFileReader func(key string) (io.ReadCloser, error), w io.Writer) error {
+	dataShards := len(group.Files)
+	rs, err := reedsolomon.New(dataShards, len(group.ParityFiles))
+	if err != nil {
+		return err
+	}
+	var maxSize int64
+	for _, size := range group.Sizes {
+		maxSize = max(maxSize, size)
+	}
+	shardNames := append(append([]string{}, group.Files...), group.ParityFiles...)
+	shardSizes := make([]int64, len(shardNames))
+	readers := make([]io.Reader, len(shardNames))
+	available := 0
+	for i := range shardNames {
+		shardSizes[i] = maxSize
+		if i < dataShards {
+			shardSizes[i] = group.Sizes[i]
+		}
+		if i == missing || available == dataShards {
+			continue
+		}
+		reader, err := getFileReader(path.Join(remotePath, shardNames[i]))
+		if err != nil {
+			b.log.Warnf("can't read %s: %v, skip it", path.Join(remotePath, shardNames[i]), err)
+			continue
+		}
+		defer func() {
+			if closeErr := reader.Close(); closeErr != nil {
+				b.log.Warnf("can't close %s: %v", path.Join(remotePath, shardNames[i]), closeErr)
+			}
+		}()
+		readers[i] = reader
+		available++
+	}
+	if available < dataShards {
+		return fmt.Errorf("only %d archives available from %d required to rebuild %s", available, dataShards, group.Files[missing])
+	}
+	buffers := make([][]byte, len(shardNames))
+	for i := range readers {
+		if readers[i] != nil || i == missing {
+			buffers[i] = make([]byte, parityBlockSize)
+		}
+	}
+	h := sha256.New()
+	out := io.MultiWriter(w, h)
+	shards := make([][]byte, len(shardNames))
+	for offset := int64(0); offset < maxSize; offset += parityBlockSize {
+		stripeLen := min(int64(parityBlockSize), maxSize-offset)
+		for i, reader := range readers {
+			shards[i] = nil
+			if reader == nil {
+				// zero length shard with capacity is reconstructed without allocation
+				if i == missing {
+					shards[i] = buffers[i][:0]
+				}
+				continue
+			}
+			n := min(max(shardSizes[i]-offset, 0), stripeLen)
+			if _, err = io.ReadFull(reader, buffers[i][:n]); err != nil {
+				return fmt.Errorf("can't read %s: %v", path.Join(remotePath, shardNames[i]), err)
+			}
+			clear(buffers[i][n:stripeLen])
+			shards[i] = buffers[i][:stripeLen]
+		}
+		if err = rs.ReconstructData(shards); err != nil {
+			return err
+		}
+		n := min(max(group.Sizes[missing]-offset, 0), stripeLen)
+		if _, err = out.Write(shards[missing][:n]); err != nil {
+			return err
+		}
+	}
+	if checksum := hex.EncodeToString(h.Sum(nil)); checksum != group.Checksums[missing] {
+		return fmt.Errorf("rebuilt %s checksum %s mismatch with %s, other archives in group could be corrupted", group.Files[missing], checksum, group.Checksums[missing])
+	}
+	return nil
+}
+
+// downloadArchiveFromParity - rebuild archive to temporary file near extracted parts or inside general->staging_path, then extract it
+func (b *Backuper) downloadArchiveFromParity(ctx context.Context, remotePath string, group metadata.ParityGroup, missing int, localPath string) error {
+	if err := os.MkdirAll(localPath, 0750); err != nil {
+		return err
+	}
+	stagingPath, err := b.dst.GetStagingPath(path.Join(remotePath, group.Files[missing]), localPath)
+	if err != nil {
+		return err
+	}
+	archive, err := os.CreateTemp(stagingPath, "parity_*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = archive.Close()
+		if removeErr := os.Remove(archive.Name()); removeErr != nil {
+			b.log.Warnf("can't remove %s: %v", archive.Name(), removeErr)
+		}
+	}()
+	getFileReader := func(key string) (io.ReadCloser, error) {
+		return b.dst.GetFileReader(ctx, key)
+	}
+	if err = b.rebuildArchive(remotePath, group, missing, getFileReader, archive); err != nil {
+		return err
+	}
+	if _, err = archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return b.dst.ExtractArchive(ctx, archive, group.Files[missing], localPath, b.compressionDictionaries)
+}
@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/klauspost/reedsolomon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetParityArchiveGroups(t *testing.T) {
+	files := map[string][]string{"hdd": {"hdd_1.tar", "hdd_2.tar"}, "default": {"default_1.tar", "default_2.tar", "default_3.tar"}}
+	assert.Equal(t, [][]string{{"default_1.tar", "default_2.tar"}, {"default_3.tar", "hdd_1.tar"}, {"hdd_2.tar"}}, getParityArchiveGroups(files, 2))
+	group, idx := findParityGroup([]metadata.ParityGroup{{Files: []string{"default_1.tar"}}, {Files: []string{"default_2.tar", "hdd_1.tar"}}}, "hdd_1.tar")
+	assert.Equal(t, 1, idx)
+	assert.Equal(t, "default_2.tar", group.Files[0])
+	_, idx = findParityGroup(nil, "hdd_1.tar")
+	assert.Equal(t, -1, idx)
+}
+
+func TestRebuildArchive(t *testing.T) {
+	remoteDir := t.TempDir()
+	archives := map[string][]byte{}
+	files := []string{"a.tar", "b.tar", "c.tar"}
+	// archives longer and shorter than parityBlockSize
+	for i, size := range []int{parityBlockSize + 100, 10, 2*parityBlockSize + 1} {
+		archives[files[i]] = make([]byte, size)
+		rand.Read(archives[files[i]])
+		require.NoError(t, os.WriteFile(path.Join(remoteDir, files[i]), archives[files[i]], 0640))
+	}
+	rs, err := reedsolomon.New(3, 2)
+	require.NoError(t, err)
+	group := metadata.ParityGroup{Files: files, Sizes: make([]int64, 3), Checksums: make([]string, 3), ParityFiles: []string{"parity_0_0.rs", "parity_0_1.rs"}}
+	readers := make([]io.Reader, 3)
+	for i, file := range files {
+		readers[i] = bytes.NewReader(archives[file])
+	}
+	writers := make([]*io.PipeWriter, 2)
+	parityBodies := make([]chan []byte, 2)
+	for i := range writers {
+		pipeReader, pipeWriter := io.Pipe()
+		writers[i] = pipeWriter
+		parityBodies[i] = make(chan []byte, 1)
+		go func(i int) {
+			body, _ := io.ReadAll(pipeReader)
+			parityBodies[i] <- body
+		}(i)
+	}
+	require.NoError(t, encodeParityStripes(rs, readers, group.Sizes, writers))
+	for i := range writers {
+		require.NoError(t, writers[i].Close())
+		require.NoError(t, os.WriteFile(path.Join(remoteDir, group.ParityFiles[i]), <-parityBodies[i], 0640))
+	}
+	assert.Equal(t, []int64{parityBlockSize + 100, 10, 2*parityBlockSize + 1}, group.Sizes)
+	for i, file := range files {
+		group.Checksums[i] = fmt.Sprintf("%x", sha256.Sum256(archives[file]))
+	}
+
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test")}
+	getFileReader := func(key string) (io.ReadCloser, error) {
+		return os.Open(path.Join(remoteDir, key))
+	}
+	// a.tar and c.tar lost
+	require.NoError(t, os.Remove(path.Join(remoteDir, "a.tar")))
+	require.NoError(t, os.Remove(path.Join(remoteDir, "c.tar")))
+	for _, missing := range []int{0, 2} {
+		rebuilt := &bytes.Buffer{}
+		require.NoError(t, b.rebuildArchive("", group, missing, getFileReader, rebuilt))
+		assert.True(t, bytes.Equal(archives[files[missing]], rebuilt.Bytes()))
+	}
+
+	require.NoError(t, os.Remove(path.Join(remoteDir, "parity_0_1.rs")))
+	assert.ErrorContains(t, b.rebuildArchive("", group, 0, getFileReader, io.Discard), "only 2 archives available")
+
+	// corrupted archive is detected by checksum
+	require.NoError(t, os.WriteFile(path.Join(remoteDir, "c.tar"), make([]byte, 2*parityBlockSize+1), 0640))
+	assert.ErrorContains(t, b.rebuildArchive("", group, 0, getFileReader, io.Discard), "checksum")
+}
+
+func TestParityArchiveSpool(t *testing.T) {
+	spoolDir := t.TempDir()
+	spool := newParityArchiveSpool()
+	// failed attempt is replaced by next attempt
+	for _, body := range []string{"partial", "uploaded archive"} {
+		f, err := spool.create("default_1.tar", spoolDir)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	remoteReads := 0
+	b := &Backuper{cfg: config.DefaultConfig(), log: apexLog.WithField("logger", "test")}
+	openArchive := func(ctx context.Context, file string) (io.ReadCloser, error) {
+		if reader, isSpooled, err := spool.open(file); isSpooled {
+			return reader, err
+		}
+		remoteReads++
+		return io.NopCloser(bytes.NewReader([]byte("skipped by resume"))), nil
+	}
+	b.cfg.General.ParityShards = 1
+	remote := &gcTestStorage{objects: map[string][]byte{}}
+	b.dst = storage.NewBackupDestinationFromRemoteStorage(b.cfg, remote, apexLog.WithField("logger", "test"))
+	group, err := b.writeParityGroup(context.Background(), "backup/shadow/db/t", 0, []string{"default_1.tar", "default_2.tar"}, openArchive)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"parity_0_0.rs"}, group.ParityFiles)
+	assert.Len(t, remote.objects["backup/shadow/db/t/parity_0_0.rs"], len("skipped by resume"))
+	assert.Equal(t, []int64{int64(len("uploaded archive")), int64(len("skipped by resume"))}, group.Sizes)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("uploaded archive"))), group.Checksums[0])
+	assert.Equal(t, 1, remoteReads)
+
+	require.NoError(t, spool.remove())
+	entries, err = os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	var nilSpool *parityArchiveSpool
+	_, isSpooled, err := nilSpool.open("default_1.tar")
+	assert.False(t, isSpooled)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"sync"
+)
+
+// ProgressEvent - one table finished during create, upload, download or restore, passed to callback from WithProgressCallback
+type ProgressEvent struct {
+	Operation   string `json:"operation"`
+	BackupName  string `json:"backup_name"`
+	Table       string `json:"table"`
+	Bytes       uint64 `json:"bytes"`
+	TablesDone  int    `json:"tables_done"`
+	TablesTotal int    `json:"tables_total"`
+}
+
+// ProgressCallback - receive ProgressEvent, never called concurrently for the same Backuper, so shall not block for a long time
+type ProgressCallback func(ProgressEvent)
+
+// progressReporter - count finished tables of one operation, safe to call from table go-routines
+type progressReporter struct {
+	callback    ProgressCallback
+	operation   string
+	backupName  string
+	tablesTotal int
+	tablesDone  int
+	mutex       *sync.Mutex
+}
+
+func (b *Backuper) newProgressReporter(operation, backupName string, tablesTotal int) *progressReporter {
+	return &progressReporter{
+		callback:    b.progressCallback,
+		operation:   operation,
+		backupName:  backupName,
+		tablesTotal: tablesTotal,
+		mutex:       &b.progressMutex,
+	}
+}
+
+// tableDone - do nothing when Backuper created without WithProgressCallback
+func (p *progressReporter) tableDone(table string, bytes uint64) {
+	if p.callback == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.tablesDone++
+	p.callback(ProgressEvent{
+		Operation:   p.operation,
+		BackupName:  p.backupName,
+		Table:       table,
+		Bytes:       bytes,
+		TablesDone:  p.tablesDone,
+		TablesTotal: p.tablesTotal,
+	})
+}
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReporter(t *testing.T) {
+	var events []ProgressEvent
+	b := &Backuper{progressCallback: func(event ProgressEvent) {
+		events = append(events, event)
+	}}
+	progress := b.newProgressReporter("upload", "backup1", 10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			progress.tableDone(fmt.Sprintf("default.t%d", i), 100)
+		}(i)
+	}
+	wg.Wait()
+	require.Len(t, events, 10)
+	for i, event := range events {
+		assert.Equal(t, i+1, event.TablesDone)
+		assert.Equal(t, 10, event.TablesTotal)
+		assert.Equal(t, "upload", event.Operation)
+		assert.Equal(t, "backup1", event.BackupName)
+		assert.Equal(t, uint64(100), event.Bytes)
+	}
+
+	b = &Backuper{}
+	assert.NotPanics(t, func() {
+		b.newProgressReporter("restore", "backup1", 1).tableDone("default.t", 1)
+	})
+}
@@ -0,0 +1,266 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+// WithRepairProjections - `restore --repair-projections`, drop broken projections from restored parts and rebuild them after attach instead of fail
+func WithRepairProjections(repairProjections bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.repairProjections = repairProjections
+	}
+}
+
+// isProjectionDirComplete - projection part is a regular part inside `<name>.proj` directory, ClickHouse can't load it without checksums.txt and columns.txt
+func isProjectionDirComplete(projectionPath string) bool {
+	for _, fileName := range []string{"checksums.txt", "columns.txt"} {
+		info, err := os.Stat(path.Join(projectionPath, fileName))
+		if err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+	}
+	return true
+}
+
+// collectTableProjections - scan `.proj` directories of backup parts on each disk, projection is broken when directory is incomplete
+// or when system.projection_parts contains projection part which is absent in backup
+func collectTableProjections(backupShadowPaths map[string]string, disksToPartsMap map[string][]metadata.Part, projectionParts []clickhouse.ProjectionPart) ([]metadata.ProjectionMetadata, error) {
+	projections := map[string]*metadata.ProjectionMetadata{}
+	addProjectionPart := func(name, disk, part string, isBroken bool) {
+		projection, exists := projections[name]
+		if !exists {
+			projection = &metadata.ProjectionMetadata{Name: name, Parts: map[string][]string{}}
+			projections[name] = projection
+		}
+		if isBroken {
+			if projection.BrokenParts == nil {
+				projection.BrokenParts = map[string][]string{}
+			}
+			projection.BrokenParts[disk] = append(projection.BrokenParts[disk], part)
+			return
+		}
+		projection.Parts[disk] = append(projection.Parts[disk], part)
+	}
+	// disk -> part -> projections which directories found in backup
+	backupParts := map[string]map[string]map[string]bool{}
+	for disk, parts := range disksToPartsMap {
+		backupShadowPath, exists := backupShadowPaths[disk]
+		if !exists {
+			continue
+		}
+		backupParts[disk] = map[string]map[string]bool{}
+		for _, part := range parts {
+			if part.Required || strings.HasSuffix(part.Name, ".proj") {
+				continue
+			}
+			entries, err := os.ReadDir(path.Join(backupShadowPath, part.Name))
+			if err != nil {
+				return nil, err
+			}
+			backupParts[disk][part.Name] = map[string]bool{}
+			for _, entry := range entries {
+				if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".proj") {
+					continue
+				}
+				name := strings.TrimSuffix(entry.Name(), ".proj")
+				backupParts[disk][part.Name][name] = true
+				addProjectionPart(name, disk, part.Name, !isProjectionDirComplete(path.Join(backupShadowPath, part.Name, entry.Name())))
+			}
+		}
+	}
+	// parts merged after FREEZE are absent in system.projection_parts, they are checked only by directory content
+	for _, projectionPart := range projectionParts {
+		partProjections, isPartInBackup := backupParts[projectionPart.DiskName][projectionPart.ParentName]
+		if !isPartInBackup || partProjections[projectionPart.Name] {
+			continue
+		}
+		addProjectionPart(projectionPart.Name, projectionPart.DiskName, projectionPart.ParentName, true)
+	}
+	result := make([]metadata.ProjectionMetadata, 0, len(projections))
+	for _, projection := range projections {
+		for _, parts := range projection.Parts {
+			sort.Strings(parts)
+		}
+		for _, parts := range projection.BrokenParts {
+			sort.Strings(parts)
+		}
+		result = append(result, *projection)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// validateTableProjections - run after table parts moved from shadow into backup directory, broken projections don't fail create,
+// they are recorded in table metadata and could be rebuilt during restore
+func (b *Backuper) validateTableProjections(ctx context.Context, backupName string, table *clickhouse.Table, disks []clickhouse.Disk, disksToPartsMap map[string][]metadata.Part, log *apexLog.Entry) ([]metadata.ProjectionMetadata, error) {
+	if len(disksToPartsMap) == 0 || !strings.Contains(strings.ToUpper(table.CreateTableQuery), "PROJECTION") {
+		return nil, nil
+	}
+	projectionParts, err := b.ch.GetProjectionParts(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	encodedTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Name))
+	backupShadowPaths := map[string]string{}
+	for _, disk := range disks {
+		if _, exists := disksToPartsMap[disk.Name]; exists {
+			backupShadowPaths[disk.Name] = path.Join(disk.Path, "backup", backupName, "shadow", encodedTablePath, disk.Name)
+		}
+	}
+	projections, err := collectTableProjections(backupShadowPaths, disksToPartsMap, projectionParts)
+	if err != nil {
+		return nil, err
+	}
+	for _, projection := range projections {
+		for disk, parts := range projection.BrokenParts {
+			log.WithField("disk", disk).Warnf("projection %s is absent or incomplete in parts %s, use `restore --repair-projections` to rebuild it", projection.Name, strings.Join(parts, ", "))
+		}
+	}
+	return projections, nil
+}
+
+// getBrokenProjections - disk -> part -> names of projections which shall be dropped before attach
+func getBrokenProjections(table metadata.TableMetadata) map[string]map[string][]string {
+	brokenProjections := map[string]map[string][]string{}
+	for _, projection := range table.Projections {
+		for disk, parts := range projection.BrokenParts {
+			if _, exists := brokenProjections[disk]; !exists {
+				brokenProjections[disk] = map[string][]string{}
+			}
+			for _, part := range parts {
+				brokenProjections[disk][part] = append(brokenProjections[disk][part], projection.Name)
+			}
+		}
+	}
+	return brokenProjections
+}
+
+// dropPartProjections - remove `<name>.proj` directories from detached part, empty names remove all projections, return removed names
+func dropPartProjections(partPath string, names []string) ([]string, error) {
+	entries, err := os.ReadDir(partPath)
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".proj") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".proj")
+		if len(names) > 0 {
+			found := false
+			for _, n := range names {
+				if n == name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		if err = os.RemoveAll(path.Join(partPath, entry.Name())); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, nil
+}
+
+// attachDataPartsRepairProjections - ATTACH PART one by one like clickhouse.AttachDataParts, broken projections from metadata are dropped before attach,
+// when attach fails, all projections of part are dropped and attach is repeated, dropped projections are rebuilt via MATERIALIZE PROJECTION
+func (b *Backuper) attachDataPartsRepairProjections(ctx context.Context, table metadata.TableMetadata, dstTable clickhouse.Table, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	if dstTable.Database != "" && dstTable.Database != table.Database {
+		table.Database = dstTable.Database
+	}
+	if dstTable.Name != "" && dstTable.Name != table.Table {
+		table.Table = dstTable.Name
+	}
+	canContinue, err := b.ch.CheckReplicationInProgress(table)
+	if err != nil {
+		return err
+	}
+	if !canContinue {
+		return nil
+	}
+	dstDataPaths := clickhouse.GetDisksByPaths(disks, dstTable.DataPaths)
+	brokenProjections := getBrokenProjections(table)
+	rebuildProjections := map[string]bool{}
+	for disk := range table.Parts {
+		for _, part := range table.Parts[disk] {
+			if strings.HasSuffix(part.Name, ".proj") {
+				continue
+			}
+			dstDisk := disk
+			if _, exists := dstDataPaths[dstDisk]; !exists && part.RebalancedDisk != "" {
+				dstDisk = part.RebalancedDisk
+			}
+			detachedPath := path.Join(dstDataPaths[dstDisk], "detached")
+			if broken := brokenProjections[disk][part.Name]; len(broken) > 0 {
+				dropped, dropErr := dropPartProjections(path.Join(detachedPath, part.Name), broken)
+				if dropErr != nil {
+					return dropErr
+				}
+				// absent projections shall be rebuilt too
+				for _, name := range broken {
+					rebuildProjections[name] = true
+				}
+				if len(dropped) > 0 {
+					log.WithField("part", part.Name).Infof("broken projections %s dropped before attach", strings.Join(dropped, ", "))
+				}
+			}
+			query := fmt.Sprintf("ALTER TABLE `%s`.`%s` ATTACH PART '%s'", table.Database, table.Table, part.Name)
+			if attachErr := b.ch.QueryContext(ctx, query); attachErr != nil {
+				// failed ATTACH PART could leave part with `attaching_` prefix
+				if _, statErr := os.Stat(path.Join(detachedPath, part.Name)); os.IsNotExist(statErr) {
+					if renameErr := os.Rename(path.Join(detachedPath, "attaching_"+part.Name), path.Join(detachedPath, part.Name)); renameErr != nil {
+						return attachErr
+					}
+				}
+				dropped, dropErr := dropPartProjections(path.Join(detachedPath, part.Name), nil)
+				if dropErr != nil || len(dropped) == 0 {
+					return attachErr
+				}
+				log.WithField("part", part.Name).Warnf("can't attach: %v, try again without projections %s", attachErr, strings.Join(dropped, ", "))
+				if attachErr = b.ch.QueryContext(ctx, query); attachErr != nil {
+					return attachErr
+				}
+				for _, name := range dropped {
+					rebuildProjections[name] = true
+				}
+			}
+			log.WithField("disk", disk).WithField("part", part.Name).Debug("attached")
+			if b.cfg.General.RestoreAttachPauseDuration > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(b.cfg.General.RestoreAttachPauseDuration):
+				}
+			}
+		}
+	}
+	projectionNames := make([]string, 0, len(rebuildProjections))
+	for name := range rebuildProjections {
+		projectionNames = append(projectionNames, name)
+	}
+	sort.Strings(projectionNames)
+	for _, name := range projectionNames {
+		if err = b.ch.QueryContext(ctx, fmt.Sprintf("ALTER TABLE `%s`.`%s` MATERIALIZE PROJECTION `%s`", table.Database, table.Table, name)); err != nil {
+			log.Warnf("can't rebuild projection %s: %v", name, err)
+			continue
+		}
+		log.Infof("projection %s rebuild started", name)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectTableProjections(t *testing.T) {
+	backupShadowPath := t.TempDir()
+	writeFile := func(name string) {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(backupShadowPath, name)), 0750))
+		require.NoError(t, os.WriteFile(path.Join(backupShadowPath, name), []byte(name), 0640))
+	}
+	writeFile("all_1_1_0/checksums.txt")
+	writeFile("all_1_1_0/p1.proj/checksums.txt")
+	writeFile("all_1_1_0/p1.proj/columns.txt")
+	writeFile("all_1_1_0/p2.proj/columns.txt")
+	writeFile("all_2_2_0/checksums.txt")
+	writeFile("all_2_2_0/p2.proj/checksums.txt")
+	writeFile("all_2_2_0/p2.proj/columns.txt")
+
+	disksToPartsMap := map[string][]metadata.Part{
+		"default": {{Name: "all_1_1_0"}, {Name: "all_2_2_0"}, {Name: "all_0_0_0", Required: true}},
+	}
+	projectionParts := []clickhouse.ProjectionPart{
+		{Name: "p1", ParentName: "all_1_1_0", DiskName: "default"},
+		{Name: "p1", ParentName: "all_2_2_0", DiskName: "default"},
+		{Name: "p1", ParentName: "all_3_3_0", DiskName: "default"},
+		{Name: "p2", ParentName: "all_2_2_0", DiskName: "default"},
+	}
+	projections, err := collectTableProjections(map[string]string{"default": backupShadowPath}, disksToPartsMap, projectionParts)
+	require.NoError(t, err)
+	assert.Equal(t, []metadata.ProjectionMetadata{
+		{Name: "p1", Parts: map[string][]string{"default": {"all_1_1_0"}}, BrokenParts: map[string][]string{"default": {"all_2_2_0"}}},
+		{Name: "p2", Parts: map[string][]string{"default": {"all_2_2_0"}}, BrokenParts: map[string][]string{"default": {"all_1_1_0"}}},
+	}, projections)
+
+	brokenProjections := getBrokenProjections(metadata.TableMetadata{Projections: projections})
+	assert.Equal(t, map[string]map[string][]string{"default": {"all_1_1_0": {"p2"}, "all_2_2_0": {"p1"}}}, brokenProjections)
+
+	dropped, err := dropPartProjections(path.Join(backupShadowPath, "all_1_1_0"), []string{"p2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p2"}, dropped)
+	assert.DirExists(t, path.Join(backupShadowPath, "all_1_1_0", "p1.proj"))
+	dropped, err = dropPartProjections(path.Join(backupShadowPath, "all_1_1_0"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p1"}, dropped)
+	assert.FileExists(t, path.Join(backupShadowPath, "all_1_1_0", "checksums.txt"))
+}
@@ -0,0 +1,331 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// replicatedEngineRE - quoted arguments of Replicated*MergeTree table engine or Replicated database engine
+var replicatedEngineRE = regexp.MustCompile(`(Replicated\w*)\s*\(((?:\s*'(?:[^'\\]|\\.)*'\s*,?)+)`)
+var quotedArgRE = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+
+// replaceReplicaName - replica name argument of Replicated* table engine, or Replicated database engine, is replaced when it exactly matches one of replacements
+// zookeeper path is not changed, cause hostname segment can't be distinguished from other path segments
+func replaceReplicaName(query string, replacements map[string]string) string {
+	if len(replacements) == 0 {
+		return query
+	}
+	return replicatedEngineRE.ReplaceAllStringFunc(query, func(engine string) string {
+		matches := replicatedEngineRE.FindStringSubmatch(engine)
+		replicaArgIndex := 1
+		// Replicated('zoo_path', 'shard_name', 'replica_name')
+		if matches[1] == "Replicated" {
+			replicaArgIndex = 2
+		}
+		argIndex := 0
+		args := quotedArgRE.ReplaceAllStringFunc(matches[2], func(arg string) string {
+			defer func() { argIndex++ }()
+			if argIndex != replicaArgIndex {
+				return arg
+			}
+			if replacement, exists := replacements[arg[1:len(arg)-1]]; exists {
+				return "'" + replacement + "'"
+			}
+			return arg
+		})
+		return strings.TrimSuffix(engine, matches[2]) + args
+	})
+}
+
+// parseRebindMapping - the same old:new[,...] format as --restore-database-mapping
+func parseRebindMapping(name string, values []string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, value := range values {
+		for _, rule := range strings.Split(value, ",") {
+			if rule = strings.TrimSpace(rule); rule == "" {
+				continue
+			}
+			oldAndNew := strings.Split(rule, ":")
+			if len(oldAndNew) != 2 || oldAndNew[0] == "" || oldAndNew[1] == "" {
+				return nil, fmt.Errorf("%s %s should only have old:new format for each map rule", name, rule)
+			}
+			mapping[oldAndNew[0]] = oldAndNew[1]
+		}
+	}
+	return mapping, nil
+}
+
+// rebindTableMetadata - replace replica name and rename disks in table metadata, return true when something changed
+func rebindTableMetadata(tm *metadata.TableMetadata, hostnameMapping, diskMapping map[string]string) bool {
+	changed := false
+	if query := replaceReplicaName(tm.Query, hostnameMapping); query != tm.Query {
+		tm.Query = query
+		changed = true
+	}
+	for oldDisk, newDisk := range diskMapping {
+		if parts, exists := tm.Parts[oldDisk]; exists {
+			delete(tm.Parts, oldDisk)
+			tm.Parts[newDisk] = parts
+			changed = true
+		}
+		if files, exists := tm.Files[oldDisk]; exists {
+			delete(tm.Files, oldDisk)
+			tm.Files[newDisk] = files
+			changed = true
+		}
+		if checksums, exists := tm.Checksums[oldDisk]; exists {
+			delete(tm.Checksums, oldDisk)
+			tm.Checksums[newDisk] = checksums
+			changed = true
+		}
+		if size, exists := tm.Size[oldDisk]; exists {
+			delete(tm.Size, oldDisk)
+			tm.Size[newDisk] = size
+			changed = true
+		}
+		for file, disk := range tm.RebalancedFiles {
+			if disk == oldDisk {
+				tm.RebalancedFiles[file] = newDisk
+				changed = true
+			}
+		}
+		for disk := range tm.Parts {
+			for i := range tm.Parts[disk] {
+				if tm.Parts[disk][i].RebalancedDisk == oldDisk {
+					tm.Parts[disk][i].RebalancedDisk = newDisk
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// rebindBackupMetadata - replace replica name in databases and rename disks in metadata.json, return true when something changed
+func rebindBackupMetadata(backupMetadata *metadata.BackupMetadata, hostnameMapping, diskMapping map[string]string) (bool, error) {
+	changed := false
+	for oldDisk, newDisk := range diskMapping {
+		diskType, exists := backupMetadata.DiskTypes[oldDisk]
+		if !exists {
+			continue
+		}
+		if _, newExists := backupMetadata.DiskTypes[newDisk]; newExists {
+			return false, fmt.Errorf("%s already contains disk %s, can't rename %s", backupMetadata.BackupName, newDisk, oldDisk)
+		}
+		// remote path of data parts contains disk name for directory format and embedded backups
+		if backupMetadata.DataFormat == DirectoryFormat || strings.Contains(backupMetadata.Tags, "embedded") {
+			return false, fmt.Errorf("%s has data_format=%s tags=%s, can't rename disk %s cause remote path contains disk name", backupMetadata.BackupName, backupMetadata.DataFormat, backupMetadata.Tags, oldDisk)
+		}
+		delete(backupMetadata.DiskTypes, oldDisk)
+		backupMetadata.DiskTypes[newDisk] = diskType
+		if diskPath, pathExists := backupMetadata.Disks[oldDisk]; pathExists {
+			delete(backupMetadata.Disks, oldDisk)
+			backupMetadata.Disks[newDisk] = diskPath
+		}
+		changed = true
+	}
+	for i := range backupMetadata.Databases {
+		if query := replaceReplicaName(backupMetadata.Databases[i].Query, hostnameMapping); query != backupMetadata.Databases[i].Query {
+			backupMetadata.Databases[i].Query = query
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// Rebind - replace hostname in replica name of Replicated engines and rename disks in remote backup metadata, so backup could be restored after host re-provisioning,
+// disks are renamed in all backups of required backups chain, cause required parts are looked up by disk name
+func (b *Backuper) Rebind(backupName string, hostnames, disks []string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "rebind",
+	})
+	hostnameMapping, err := parseRebindMapping("hostname-mapping", hostnames)
+	if err != nil {
+		return err
+	}
+	diskMapping, err := parseRebindMapping("disk-mapping", disks)
+	if err != nil {
+		return err
+	}
+	if len(hostnameMapping) == 0 && len(diskMapping) == 0 {
+		return fmt.Errorf("--hostname-mapping or --disk-mapping is required")
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("rebind is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	release, err := b.lockOperation("rebind", backupName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if b.dst, err = b.newBackupDestination(ctx, false, backupName); err != nil {
+		return err
+	}
+	if err = b.dst.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupList, err := b.dst.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	backupsByName := make(map[string]storage.Backup, len(backupList))
+	for _, backup := range backupList {
+		backupsByName[backup.BackupName] = backup
+	}
+	chain := make([]storage.Backup, 0)
+	for name := backupName; name != "" && len(chain) <= len(backupList); {
+		backup, exists := backupsByName[name]
+		if !exists {
+			return fmt.Errorf("'%s' is not found on remote storage", name)
+		}
+		chain = append(chain, backup)
+		if len(diskMapping) == 0 {
+			break
+		}
+		name = backup.RequiredBackup
+	}
+	for _, backup := range chain {
+		start := time.Now()
+		changedFiles, err := b.rebindRemoteBackup(ctx, backup, hostnameMapping, diskMapping)
+		if err != nil {
+			return fmt.Errorf("can't rebind %s: %v", backup.BackupName, err)
+		}
+		log.WithFields(apexLog.Fields{
+			"rebound":  backup.BackupName,
+			"files":    changedFiles,
+			"duration": utils.HumanizeDuration(time.Since(start)),
+		}).Info("done")
+	}
+	return nil
+}
+
+// rebindRemoteBackup - changed table metadata files are uploaded before metadata.json and signature.json, already uploaded files are restored when next upload failed
+func (b *Backuper) rebindRemoteBackup(ctx context.Context, backup storage.Backup, hostnameMapping, diskMapping map[string]string) (int, error) {
+	if err := b.dst.CheckBackupLock(ctx, backup.BackupName); err != nil {
+		return 0, err
+	}
+	remoteMetadataFile := path.Join(backup.BackupName, "metadata.json")
+	originalBody, err := b.readRemoteFile(ctx, remoteMetadataFile)
+	if err != nil {
+		return 0, err
+	}
+	if err = b.verifyRemoteFile(ctx, backup.BackupName, remoteMetadataFile, originalBody); err != nil {
+		return 0, err
+	}
+	backupMetadata := metadata.BackupMetadata{}
+	if err = json.Unmarshal(originalBody, &backupMetadata); err != nil {
+		return 0, fmt.Errorf("can't parse %s: %v", remoteMetadataFile, err)
+	}
+	isMetadataChanged, err := rebindBackupMetadata(&backupMetadata, hostnameMapping, diskMapping)
+	if err != nil {
+		return 0, err
+	}
+	changedFiles := map[string][]byte{}
+	originalFiles := map[string][]byte{}
+	uploadOrder := make([]string, 0)
+	for _, tableTitle := range backupMetadata.Tables {
+		remoteTableFile := path.Join(backup.BackupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
+		tableBody, err := b.readRemoteFile(ctx, remoteTableFile)
+		if err != nil {
+			return 0, err
+		}
+		if err = b.verifyRemoteFile(ctx, backup.BackupName, remoteTableFile, tableBody); err != nil {
+			return 0, err
+		}
+		tm := metadata.TableMetadata{}
+		if err = json.Unmarshal(tableBody, &tm); err != nil {
+			return 0, fmt.Errorf("can't parse %s: %v", remoteTableFile, err)
+		}
+		// general->table_compression_format: none uploads table as directory, remote path contains disk name
+		if tm.DataFormat == DirectoryFormat {
+			for oldDisk := range diskMapping {
+				if _, exists := tm.Parts[oldDisk]; exists {
+					return 0, fmt.Errorf("%s.%s has data_format=%s, can't rename disk %s cause remote path contains disk name", tm.Database, tm.Table, tm.DataFormat, oldDisk)
+				}
+			}
+		}
+		if !rebindTableMetadata(&tm, hostnameMapping, diskMapping) {
+			continue
+		}
+		if changedFiles[remoteTableFile], err = json.MarshalIndent(&tm, "", "\t"); err != nil {
+			return 0, err
+		}
+		originalFiles[remoteTableFile] = tableBody
+		uploadOrder = append(uploadOrder, remoteTableFile)
+	}
+	sort.Strings(uploadOrder)
+	if !isMetadataChanged && len(changedFiles) == 0 {
+		return 0, nil
+	}
+	// re-uploaded metadata.json shall not change upload date used by retention
+	if backupMetadata.OriginalUploadDate == nil {
+		uploadDate := backup.UploadDate
+		backupMetadata.OriginalUploadDate = &uploadDate
+	}
+	if changedFiles[remoteMetadataFile], err = json.MarshalIndent(&backupMetadata, "", "\t"); err != nil {
+		return 0, err
+	}
+	originalFiles[remoteMetadataFile] = originalBody
+	uploadOrder = append(uploadOrder, remoteMetadataFile)
+	remoteSignatureFile := path.Join(backup.BackupName, backupSignatureFile)
+	if _, err = b.dst.StatFile(ctx, remoteSignatureFile); err == nil {
+		signatureBody, err := b.resignRemoteMetadata(ctx, backup.BackupName, remoteSignatureFile, changedFiles)
+		if err != nil {
+			return 0, err
+		}
+		if originalFiles[remoteSignatureFile], err = b.readRemoteFile(ctx, remoteSignatureFile); err != nil {
+			return 0, err
+		}
+		changedFiles[remoteSignatureFile] = signatureBody
+		uploadOrder = append(uploadOrder, remoteSignatureFile)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return 0, fmt.Errorf("can't stat %s: %v", remoteSignatureFile, err)
+	}
+	for i, remoteFile := range uploadOrder {
+		if err = b.putRemoteFile(ctx, remoteFile, changedFiles[remoteFile]); err != nil {
+			for _, uploadedFile := range uploadOrder[:i] {
+				if rollbackErr := b.putRemoteFile(ctx, uploadedFile, originalFiles[uploadedFile]); rollbackErr != nil {
+					return 0, fmt.Errorf("%v, rollback %s failed: %v", err, uploadedFile, rollbackErr)
+				}
+			}
+			return 0, err
+		}
+	}
+	if err = b.dst.AddToCatalog(ctx, storage.Backup{BackupMetadata: backupMetadata, UploadDate: backup.UploadDate}); err != nil {
+		b.log.Warnf("can't update %s in catalog: %v", backup.BackupName, err)
+	}
+	if err = b.dst.RemoveFromMetadataCache(ctx, backup.BackupName); err != nil {
+		b.log.Warnf("can't remove %s from metadata cache: %v", backup.BackupName, err)
+	}
+	return len(uploadOrder), nil
+}
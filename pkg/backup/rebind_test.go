@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceReplicaName(t *testing.T) {
+	hostnames := map[string]string{"ch-0.cluster.local": "{replica}"}
+	assert.Equal(t,
+		"CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', ver) ORDER BY id",
+		replaceReplicaName("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/db/t', 'ch-0.cluster.local', ver) ORDER BY id", hostnames),
+	)
+	// Replicated database engine has replica name in third argument
+	assert.Equal(t,
+		"CREATE DATABASE db ENGINE = Replicated('/clickhouse/db/ch-0.cluster.local', 'shard1', '{replica}')",
+		replaceReplicaName("CREATE DATABASE db ENGINE = Replicated('/clickhouse/db/ch-0.cluster.local', 'shard1', 'ch-0.cluster.local')", hostnames),
+	)
+	// zookeeper path and replica name which doesn't match exactly are not changed
+	query := "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/ch-0.cluster.local', 'ch-0.cluster.local.old') ORDER BY id"
+	assert.Equal(t, query, replaceReplicaName(query, hostnames))
+	query = "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id COMMENT 'ch-0.cluster.local'"
+	assert.Equal(t, query, replaceReplicaName(query, hostnames))
+	assert.Equal(t, query, replaceReplicaName(query, nil))
+}
+
+func TestParseRebindMapping(t *testing.T) {
+	mapping, err := parseRebindMapping("disk-mapping", []string{"hdd1:hdd2,ssd:nvme", "s3:s3_new"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"hdd1": "hdd2", "ssd": "nvme", "s3": "s3_new"}, mapping)
+	_, err = parseRebindMapping("disk-mapping", []string{"hdd1"})
+	assert.ErrorContains(t, err, "old:new format")
+	_, err = parseRebindMapping("disk-mapping", []string{"hdd1:"})
+	assert.Error(t, err)
+}
+
+func TestRebindMetadata(t *testing.T) {
+	tm := &metadata.TableMetadata{
+		Database:        "db",
+		Table:           "t",
+		Query:           "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', 'old-host') ORDER BY id",
+		Parts:           map[string][]metadata.Part{"hdd1": {{Name: "all_1_1_0"}}, "default": {{Name: "all_2_2_0", RebalancedDisk: "hdd1"}}},
+		Files:           map[string][]string{"hdd1": {"hdd1_all_1_1_0.tar"}, "default": {"default_all_2_2_0.tar"}},
+		Size:            map[string]int64{"hdd1": 100, "default": 10},
+		RebalancedFiles: map[string]string{"default_all_2_2_0.tar": "hdd1"},
+		Checksums:       map[string]map[string]string{"hdd1": {"all_1_1_0/data.bin": "checksum"}},
+	}
+	require.True(t, rebindTableMetadata(tm, map[string]string{"old-host": "new-host"}, map[string]string{"hdd1": "hdd2"}))
+	assert.Contains(t, tm.Query, "'new-host'")
+	assert.Equal(t, []metadata.Part{{Name: "all_1_1_0"}}, tm.Parts["hdd2"])
+	assert.NotContains(t, tm.Parts, "hdd1")
+	assert.Equal(t, "hdd2", tm.Parts["default"][0].RebalancedDisk)
+	assert.Equal(t, []string{"hdd1_all_1_1_0.tar"}, tm.Files["hdd2"])
+	assert.Equal(t, int64(100), tm.Size["hdd2"])
+	assert.Equal(t, map[string]map[string]string{"hdd2": {"all_1_1_0/data.bin": "checksum"}}, tm.Checksums)
+	assert.Equal(t, "hdd2", tm.RebalancedFiles["default_all_2_2_0.tar"])
+	assert.False(t, rebindTableMetadata(tm, map[string]string{"old-host": "new-host"}, map[string]string{"hdd1": "hdd2"}))
+
+	backupMetadata := &metadata.BackupMetadata{
+		BackupName: "backup",
+		DataFormat: "tar",
+		Disks:      map[string]string{"default": "/var/lib/clickhouse", "hdd1": "/hdd1"},
+		DiskTypes:  map[string]string{"default": "local", "hdd1": "local"},
+		Databases:  []metadata.DatabasesMeta{{Name: "db", Query: "CREATE DATABASE db ENGINE = Replicated('/db', 's1', 'old-host')"}},
+	}
+	changed, err := rebindBackupMetadata(backupMetadata, map[string]string{"old-host": "new-host"}, map[string]string{"hdd1": "hdd2"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]string{"default": "local", "hdd2": "local"}, backupMetadata.DiskTypes)
+	assert.Equal(t, "/hdd1", backupMetadata.Disks["hdd2"])
+	assert.Contains(t, backupMetadata.Databases[0].Query, "'new-host'")
+
+	_, err = rebindBackupMetadata(backupMetadata, nil, map[string]string{"hdd2": "default"})
+	assert.ErrorContains(t, err, "already contains disk default")
+	backupMetadata.DataFormat = DirectoryFormat
+	_, err = rebindBackupMetadata(backupMetadata, nil, map[string]string{"hdd2": "hdd3"})
+	assert.ErrorContains(t, err, "remote path contains disk name")
+}
@@ -0,0 +1,293 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// renameRequiredBackup - replace oldName in required_backup and required_backups_chain, return true when backup metadata changed
+func renameRequiredBackup(backupMetadata *metadata.BackupMetadata, oldName, newName string) bool {
+	isChanged := false
+	if backupMetadata.RequiredBackup == oldName {
+		backupMetadata.RequiredBackup = newName
+		isChanged = true
+	}
+	for i, name := range backupMetadata.RequiredBackupsChain {
+		if name == oldName {
+			backupMetadata.RequiredBackupsChain[i] = newName
+			isChanged = true
+		}
+	}
+	return isChanged
+}
+
+// Rename - `rename` command, rename local or remote backup and replace required_backup in backups which require it
+func (b *Backuper) Rename(oldName, newName string, remote bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	oldName = utils.CleanBackupNameRE.ReplaceAllString(oldName, "")
+	newName = utils.CleanBackupNameRE.ReplaceAllString(newName, "")
+	if oldName == "" || newName == "" {
+		return fmt.Errorf("select backup and new backup name for rename")
+	}
+	if oldName == newName {
+		return fmt.Errorf("new backup name shall be different from '%s'", oldName)
+	}
+	location := "local"
+	if remote {
+		location = "remote"
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":     oldName,
+		"new_backup": newName,
+		"location":   location,
+		"operation":  "rename",
+	})
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	release, err := b.lockOperation("rename", oldName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	start := time.Now()
+	if remote {
+		err = b.renameBackupRemote(ctx, oldName, newName, log)
+	} else {
+		err = b.renameBackupLocal(ctx, oldName, newName, log)
+	}
+	b.writeAuditRecord(ctx, "rename_"+location, []string{oldName, newName}, err)
+	if err != nil {
+		return err
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Info("done")
+	return nil
+}
+
+// renameBackupLocal - move backup directory on each disk, backups with object disks are not supported, cause object disk data is copied with backup name as prefix
+func (b *Backuper) renameBackupLocal(ctx context.Context, oldName, newName string, log *apexLog.Entry) error {
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	backupList, disks, err := b.GetLocalBackups(ctx, disks)
+	if err != nil {
+		return err
+	}
+	var backup *LocalBackup
+	for i := range backupList {
+		if backupList[i].BackupName == newName {
+			return fmt.Errorf("'%s' already exists on local storage", newName)
+		}
+		if backupList[i].BackupName == oldName {
+			backup = &backupList[i]
+		}
+	}
+	if backup == nil {
+		return fmt.Errorf("'%s' is not found on local storage", oldName)
+	}
+	if backup.Broken != "" {
+		return fmt.Errorf("'%s' is broken: %s", oldName, backup.Broken)
+	}
+	if strings.Contains(backup.Tags, "embedded") || b.hasObjectDisksLocal(backupList, oldName, disks) {
+		return fmt.Errorf("'%s' is embedded or object disk backup, rename is not supported", oldName)
+	}
+	backupPath := func(disk clickhouse.Disk, backupName string) string {
+		if disk.IsBackup {
+			return path.Join(disk.Path, backupName)
+		}
+		return path.Join(disk.Path, "backup", backupName)
+	}
+	renamedDisks := make([]clickhouse.Disk, 0, len(disks))
+	rollback := func(err error) error {
+		for _, disk := range renamedDisks {
+			if rollbackErr := os.Rename(backupPath(disk, newName), backupPath(disk, oldName)); rollbackErr != nil {
+				return fmt.Errorf("%v, rollback %s failed: %v", err, backupPath(disk, newName), rollbackErr)
+			}
+		}
+		return err
+	}
+	for _, disk := range disks {
+		if _, err = os.Stat(backupPath(disk, oldName)); os.IsNotExist(err) {
+			continue
+		}
+		log.Infof("rename '%s' to '%s'", backupPath(disk, oldName), backupPath(disk, newName))
+		if err = os.Rename(backupPath(disk, oldName), backupPath(disk, newName)); err != nil {
+			return rollback(err)
+		}
+		renamedDisks = append(renamedDisks, disk)
+	}
+	backup.BackupName = newName
+	if err = backup.BackupMetadata.Save(path.Join(b.DefaultDataPath, "backup", newName, "metadata.json")); err != nil {
+		return rollback(err)
+	}
+	for _, dependent := range backupList {
+		if dependent.BackupName == oldName || !renameRequiredBackup(&dependent.BackupMetadata, oldName, newName) {
+			continue
+		}
+		if err = dependent.BackupMetadata.Save(path.Join(b.DefaultDataPath, "backup", dependent.BackupName, "metadata.json")); err != nil {
+			return fmt.Errorf("'%s' renamed, but required_backup of %s wasn't changed: %v", newName, dependent.BackupName, err)
+		}
+		log.Infof("%s required_backup changed to %s", dependent.BackupName, newName)
+	}
+	return nil
+}
+
+// renameBackupRemote - remote storages can't rename prefix, so copy all backup files, upload metadata.json with new name,
+// re-point backups which require old backup and delete old backup only after all of them changed
+func (b *Backuper) renameBackupRemote(ctx context.Context, oldName, newName string, log *apexLog.Entry) error {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("rename is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	var err error
+	if b.dst, err = b.newBackupDestination(ctx, false, newName); err != nil {
+		return err
+	}
+	if err = b.dst.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	backupList, err := b.dst.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	var backup *storage.Backup
+	for i := range backupList {
+		if backupList[i].BackupName == newName {
+			return fmt.Errorf("'%s' already exists on remote storage", newName)
+		}
+		if backupList[i].BackupName == oldName {
+			backup = &backupList[i]
+		}
+	}
+	if backup == nil {
+		return fmt.Errorf("'%s' is not found on remote storage", oldName)
+	}
+	if backup.Broken != "" {
+		return fmt.Errorf("'%s' is broken: %s", oldName, backup.Broken)
+	}
+	if strings.Contains(backup.Tags, "embedded") || b.hasObjectDisksRemote(*backup) {
+		return fmt.Errorf("'%s' is embedded or object disk backup, rename is not supported", oldName)
+	}
+	// old backup shall be deleted after copy
+	if err = b.dst.CheckBackupLock(ctx, oldName); err != nil {
+		return err
+	}
+
+	remoteMetadataFile := path.Join(oldName, "metadata.json")
+	remoteSignatureFile := path.Join(oldName, backupSignatureFile)
+	metadataBody, err := b.readRemoteFile(ctx, remoteMetadataFile)
+	if err != nil {
+		return err
+	}
+	if err = b.verifyRemoteFile(ctx, oldName, remoteMetadataFile, metadataBody); err != nil {
+		return err
+	}
+	backupMetadata := metadata.BackupMetadata{}
+	if err = json.Unmarshal(metadataBody, &backupMetadata); err != nil {
+		return fmt.Errorf("can't parse %s: %v", remoteMetadataFile, err)
+	}
+	type remoteFile struct {
+		name string
+		size int64
+	}
+	var files []remoteFile
+	err = b.dst.Walk(ctx, oldName+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		if b.dst.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+			return nil
+		}
+		// metadata.json uploads last, so backup becomes visible only after all files copied
+		if f.Name() != "metadata.json" && f.Name() != backupSignatureFile {
+			files = append(files, remoteFile{name: f.Name(), size: f.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	copyGroup, copyCtx := errgroup.WithContext(ctx)
+	copyGroup.SetLimit(int(b.cfg.General.UploadConcurrency))
+	for _, f := range files {
+		copyGroup.Go(func() error {
+			return b.copyRemoteFile(copyCtx, f.size, path.Join(oldName, f.name), path.Join(newName, f.name))
+		})
+	}
+	if err = copyGroup.Wait(); err != nil {
+		return err
+	}
+	log.Infof("copied %d files", len(files))
+
+	backupMetadata.BackupName = newName
+	// renamed backup shall keep upload date used by retention
+	if backupMetadata.OriginalUploadDate == nil {
+		uploadDate := backup.UploadDate
+		backupMetadata.OriginalUploadDate = &uploadDate
+	}
+	newMetadataBody, err := json.MarshalIndent(&backupMetadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	newMetadataFile := path.Join(newName, "metadata.json")
+	var signatureBody []byte
+	if _, err = b.dst.StatFile(ctx, remoteSignatureFile); err == nil {
+		if signatureBody, err = b.resignRemoteMetadata(ctx, newName, remoteSignatureFile, map[string][]byte{newMetadataFile: newMetadataBody}); err != nil {
+			return err
+		}
+		if err = b.putRemoteFile(ctx, path.Join(newName, backupSignatureFile), signatureBody); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("can't stat %s: %v", remoteSignatureFile, err)
+	}
+	if err = b.putRemoteFile(ctx, newMetadataFile, newMetadataBody); err != nil {
+		return err
+	}
+	if err = b.dst.AddToCatalog(ctx, storage.Backup{BackupMetadata: backupMetadata, UploadDate: backup.UploadDate}); err != nil {
+		log.Warnf("can't add %s to catalog: %v", newName, err)
+	}
+
+	for _, dependent := range backupList {
+		if dependent.BackupName == oldName || (dependent.RequiredBackup != oldName && !slices.Contains(dependent.RequiredBackupsChain, oldName)) {
+			continue
+		}
+		err = b.rewriteRemoteMetadata(ctx, dependent, func(dependentMetadata *metadata.BackupMetadata) error {
+			renameRequiredBackup(dependentMetadata, oldName, newName)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("'%s' copied to '%s', but required_backup of %s wasn't changed, '%s' is kept: %v", oldName, newName, dependent.BackupName, oldName, err)
+		}
+		log.Infof("%s required_backup changed to %s", dependent.BackupName, newName)
+	}
+	if err = b.dst.RemoveBackupRemote(ctx, *backup); err != nil {
+		return fmt.Errorf("'%s' copied to '%s', but can't delete '%s': %v", oldName, newName, oldName, err)
+	}
+	if err = b.dst.RemoveFromMetadataCache(ctx, oldName); err != nil {
+		log.Warnf("can't remove %s from metadata cache: %v", oldName, err)
+	}
+	return nil
+}
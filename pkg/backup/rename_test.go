@@ -0,0 +1,23 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameRequiredBackup(t *testing.T) {
+	backupMetadata := metadata.BackupMetadata{RequiredBackup: "old", RequiredBackupsChain: []string{"old", "full"}}
+	assert.True(t, renameRequiredBackup(&backupMetadata, "old", "new"))
+	assert.Equal(t, "new", backupMetadata.RequiredBackup)
+	assert.Equal(t, []string{"new", "full"}, backupMetadata.RequiredBackupsChain)
+
+	// renamed backup is required through other increment
+	backupMetadata = metadata.BackupMetadata{RequiredBackup: "increment", RequiredBackupsChain: []string{"increment", "old"}}
+	assert.True(t, renameRequiredBackup(&backupMetadata, "old", "new"))
+	assert.Equal(t, "increment", backupMetadata.RequiredBackup)
+	assert.Equal(t, []string{"increment", "new"}, backupMetadata.RequiredBackupsChain)
+
+	assert.False(t, renameRequiredBackup(&backupMetadata, "other", "new"))
+}
@@ -39,7 +39,7 @@ import (
 var CreateDatabaseRE = regexp.MustCompile(`(?m)^CREATE DATABASE (\s*)(\S+)(\s*)`)
 
 // Restore - restore tables matched by tablePattern from backupName
-func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropExists, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly bool, commandId int) error {
+func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropExists, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly bool, commandId int) (err error) {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -48,11 +48,17 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	defer cancel()
 	startRestore := time.Now()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	b.setLogComment("restore", backupName, commandId)
+	defer func() {
+		if b.dryRun == nil {
+			b.writeAuditRecord(ctx, "restore", []string{backupName}, err)
+		}
+	}()
 	if err := b.prepareRestoreDatabaseMapping(databaseMapping); err != nil {
 		return err
 	}
 
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
 	})
@@ -110,6 +116,45 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 		return err
 	}
 	b.isEmbedded = strings.Contains(backupMetadata.Tags, "embedded")
+	if b.reshardCluster != "" && b.isEmbedded {
+		return fmt.Errorf("--reshard-cluster is not supported for embedded backup '%s'", backupName)
+	}
+	if b.dryRun != nil {
+		if b.restoreKeeperOnly || b.fromSnapshot {
+			return fmt.Errorf("--dry-run is not supported with --keeper-only and --from-snapshot")
+		}
+		return b.planRestore(ctx, backupName, backupMetadata, tablePattern, partitions, schemaOnly, dataOnly, dropExists, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, disks)
+	}
+	if b.restoreKeeperOnly {
+		if err = b.restoreKeeper(ctx, backupName, log); err != nil {
+			return fmt.Errorf("can't restore keeper: %v", err)
+		}
+		log.Info("keeper successfully restored")
+		return nil
+	}
+	if b.fromSnapshot {
+		return b.restoreFromCloudSnapshots(ctx, backupName, backupMetadata, log)
+	}
+	if doRestoreData && len(backupMetadata.CloudSnapshots) > 0 {
+		log.Warnf("data of backup is inside %s snapshots, only schema will be restored, use --from-snapshot to create volumes from snapshots", backupMetadata.CloudSnapshots[0].Type)
+	}
+	if b.convertReplicated {
+		if b.isEmbedded {
+			return fmt.Errorf("--convert-replicated is not supported for embedded backup '%s'", backupName)
+		}
+		if b.cfg.General.RestoreSchemaOnCluster != "" {
+			log.Warnf("--convert-replicated will ignore restore_schema_on_cluster: %s", b.cfg.General.RestoreSchemaOnCluster)
+			// config could be shared with other API operations
+			cfg := *b.cfg
+			cfg.General.RestoreSchemaOnCluster = ""
+			b.cfg = &cfg
+		}
+	}
+	if doRestoreData && !b.isEmbedded {
+		if err = b.checkEncryptedDisks(ctx, backupName, backupMetadata, tablePattern, partitions, disks, log); err != nil {
+			return err
+		}
+	}
 
 	if schemaOnly || doRestoreData {
 		for _, database := range backupMetadata.Databases {
@@ -160,13 +205,13 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	isObjectDiskPresents := false
 	if b.cfg.General.RemoteStorage != "custom" {
 		for _, d := range disks {
-			if isObjectDiskPresents = b.isDiskTypeObject(d.Type); isObjectDiskPresents {
+			if isObjectDiskPresents = b.isDiskTypeObject(d.Type) || b.isDiskTypeEncryptedObject(d, disks); isObjectDiskPresents {
 				break
 			}
 		}
 	}
 	if (b.cfg.ClickHouse.UseEmbeddedBackupRestore && b.cfg.ClickHouse.EmbeddedBackupDisk == "") || isObjectDiskPresents {
-		if b.dst, err = storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName); err != nil {
+		if b.dst, err = b.newBackupDestination(ctx, false, backupName); err != nil {
 			return err
 		}
 		if err = b.dst.Connect(ctx); err != nil {
@@ -198,6 +243,10 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 		if err = b.RestoreSchema(ctx, backupName, backupMetadata, disks, tablesForRestore, ignoreDependencies, version); err != nil {
 			return err
 		}
+		if err = b.runHooks(ctx, hookEvent{Stage: "after_restore_schema", Operation: "restore", BackupName: backupName}, log); err != nil {
+			return err
+		}
+		b.reportStreamingTables(tablesForRestore, log)
 	}
 	// https://github.com/Altinity/clickhouse-backup/issues/756
 	if dataOnly && !schemaOnly && !rbacOnly && !configsOnly && len(partitions) > 0 {
@@ -219,10 +268,39 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 			}
 		}
 	}
+	if err = b.runHooks(ctx, hookEvent{Stage: "after_restore", Operation: "restore", BackupName: backupName}, log); err != nil {
+		return err
+	}
 	log.WithField("duration", utils.HumanizeDuration(time.Since(startRestore))).Info("done")
 	return nil
 }
 
+// applyRestorePriority - restore_cpu_nice_priority and restore_io_nice_priority apply only during ATTACH, download and hardlinks keep general priorities,
+// priority is process wide and tables attach in parallel, so returned function sets general priorities back only after the last attach finished
+func (b *Backuper) applyRestorePriority() func() {
+	if b.cfg.General.RestoreCPUNicePriority == 0 && b.cfg.General.RestoreIONicePriority == "" {
+		return func() {}
+	}
+	b.restorePriorityMutex.Lock()
+	defer b.restorePriorityMutex.Unlock()
+	if b.restorePriorityAttaches == 0 {
+		if err := b.cfg.SetRestorePriority(); err != nil {
+			b.log.Warnf("can't set restore priority: %v", err)
+		}
+	}
+	b.restorePriorityAttaches++
+	return func() {
+		b.restorePriorityMutex.Lock()
+		defer b.restorePriorityMutex.Unlock()
+		b.restorePriorityAttaches--
+		if b.restorePriorityAttaches == 0 {
+			if err := b.cfg.SetPriority(); err != nil {
+				b.log.Warnf("can't set priority: %v", err)
+			}
+		}
+	}
+}
+
 func (b *Backuper) getTablesForRestoreLocal(ctx context.Context, backupName string, metadataPath string, tablePattern string, dropTable bool, partitions []string) (ListOfTables, map[metadata.TableTitle][]string, error) {
 	var tablesForRestore ListOfTables
 	var partitionsNames map[metadata.TableTitle][]string
@@ -347,6 +425,9 @@ func (b *Backuper) restoreEmptyDatabase(ctx context.Context, targetDB, tablePatt
 
 	}
 	substitution := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS ${1}`%s`${3}", targetDB)
+	if b.convertReplicated {
+		database.Query = convertReplicatedDatabaseQuery(database.Query)
+	}
 	if err := b.ch.CreateDatabaseFromQuery(ctx, CreateDatabaseRE.ReplaceAllString(database.Query, substitution), b.cfg.General.RestoreSchemaOnCluster); err != nil {
 		return err
 	}
@@ -793,6 +874,10 @@ func (b *Backuper) dropExistPartitions(ctx context.Context, tablesForRestore Lis
 		if !isExists {
 			return fmt.Errorf("`%s`.`%s` doesn't contains %#v partitions", table.Database, table.Table, partitions)
 		}
+		// table doesn't match `db.table:` pattern of any --partitions value
+		if len(partitionsIds) == 0 {
+			continue
+		}
 		partitionsSQL := fmt.Sprintf("DROP PARTITION %s", strings.Join(partitionsIds, ", DROP PARTITION "))
 		settings := ""
 		if version >= 19017000 {
@@ -808,11 +893,38 @@ func (b *Backuper) dropExistPartitions(ctx context.Context, tablesForRestore Lis
 
 // RestoreSchema - restore schemas matched by tablePattern from backupName
 func (b *Backuper) RestoreSchema(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, disks []clickhouse.Disk, tablesForRestore ListOfTables, ignoreDependencies bool, version int) error {
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore_schema",
 	})
 	startRestoreSchema := time.Now()
+	if b.convertReplicated {
+		log.Infof("%d tables will restore with non-replicated engines", convertReplicatedTables(tablesForRestore))
+	}
+	if len(b.cfg.General.RestoreSchemaRewriteRules) > 0 {
+		if b.isEmbedded {
+			log.Warn("general->restore_schema_rewrite_rules is not supported with use_embedded_backup_restore: true, will ignore")
+		} else {
+			for i, schema := range tablesForRestore {
+				query, appliedRules, err := applyRestoreSchemaRewriteRules(b.cfg.General.RestoreSchemaRewriteRules, schema.Database, schema.Table, schema.Query)
+				if err != nil {
+					return err
+				}
+				if len(appliedRules) > 0 {
+					log.Infof("`%s`.`%s` schema rewritten by %s", schema.Database, schema.Table, strings.Join(appliedRules, ", "))
+					log.Debugf("`%s`.`%s` rewritten query: %s", schema.Database, schema.Table, query)
+					tablesForRestore[i].Query = query
+				}
+			}
+		}
+	}
+	// check before drop, to keep existing tables when target ClickHouse can't read experimental column types
+	backupVersion := clickhouse.ParseVersionDescribe(backupMetadata.ClickHouseVersion)
+	for _, schema := range tablesForRestore {
+		if err := clickhouse.CheckExperimentalTypes(schema.Database, schema.Table, schema.Query, backupVersion, version); err != nil {
+			return err
+		}
+	}
 	if dropErr := b.dropExistsTables(tablesForRestore, ignoreDependencies, version, log); dropErr != nil {
 		return dropErr
 	}
@@ -825,6 +937,9 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName string, backupM
 	if restoreErr != nil {
 		return restoreErr
 	}
+	if b.cfg.General.RestoreSchemaFidelityCheck {
+		b.checkRestoredSchemaFidelity(ctx, tablesForRestore, log)
+	}
 	log.WithField("duration", utils.HumanizeDuration(time.Since(startRestoreSchema))).Info("done")
 	return nil
 }
@@ -1120,7 +1235,7 @@ func (b *Backuper) dropExistsTables(tablesForDrop ListOfTables, ignoreDependenci
 func (b *Backuper) RestoreData(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, dataOnly bool, metadataPath, tablePattern string, partitions []string, disks []clickhouse.Disk) error {
 	var err error
 	startRestoreData := time.Now()
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore_data",
 	})
@@ -1151,14 +1266,46 @@ func (b *Backuper) RestoreData(ctx context.Context, backupName string, backupMet
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
 	log.Debugf("found %d tables with data in backup", len(tablesForRestore))
+	if b.convertReplicated {
+		convertReplicatedTables(tablesForRestore)
+	}
 	if b.isEmbedded {
+		// RESTORE copies data from local embedded backup disk into default storage policy, regular restore creates hardlinks
+		if b.cfg.ClickHouse.EmbeddedBackupDisk != "" && len(partitions) == 0 {
+			requiredSpace := map[string]uint64{}
+			for _, table := range tablesForRestore {
+				requiredSpace["default"] += table.TotalBytes
+			}
+			if err = b.checkDiskSpace("restore", disks, requiredSpace, nil, log); err != nil {
+				return err
+			}
+		}
 		err = b.restoreDataEmbedded(ctx, backupName, dataOnly, tablesForRestore, partitionsNameList)
 	} else {
-		err = b.restoreDataRegular(ctx, backupName, backupMetadata, tablePattern, tablesForRestore, diskMap, diskTypes, disks, log)
+		var shardingKeys map[metadata.TableTitle]string
+		if b.reshardCluster != "" {
+			// Distributed tables could be not matched with tablePattern
+			allTables, _, getTablesErr := b.getTableListByPatternLocal(ctx, metadataPath, "*", false, nil)
+			if getTablesErr != nil {
+				return getTablesErr
+			}
+			shardingKeys = getReshardShardingKeys(allTables)
+		}
+		err = b.restoreDataRegular(ctx, backupName, backupMetadata, tablePattern, tablesForRestore, diskMap, diskTypes, disks, shardingKeys, log)
 	}
 	if err != nil {
 		return err
 	}
+	if len(b.reencryptDisks) > 0 {
+		if err = b.reencryptRestoredTables(ctx, tablesForRestore, log); err != nil {
+			return err
+		}
+	}
+	if b.syncReplicasCluster != "" {
+		if err = b.syncRestoredReplicas(ctx, tablesForRestore, log); err != nil {
+			return fmt.Errorf("can't sync other replicas after restore: %v", err)
+		}
+	}
 	log.WithField("duration", utils.HumanizeDuration(time.Since(startRestoreData))).Info("done")
 	return nil
 }
@@ -1167,7 +1314,7 @@ func (b *Backuper) restoreDataEmbedded(ctx context.Context, backupName string, d
 	return b.restoreEmbedded(ctx, backupName, false, dataOnly, tablesForRestore, partitionsNameList)
 }
 
-func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, tablePattern string, tablesForRestore ListOfTables, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, tablePattern string, tablesForRestore ListOfTables, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, shardingKeys map[metadata.TableTitle]string, log *apexLog.Entry) error {
 	if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
 		tablePattern = b.changeTablePatternFromRestoreDatabaseMapping(tablePattern)
 	}
@@ -1185,51 +1332,103 @@ func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, ba
 	if len(missingTables) > 0 {
 		return fmt.Errorf("%s is not created. Restore schema first or create missing tables manually", strings.Join(missingTables, ", "))
 	}
-	restoreBackupWorkingGroup, restoreCtx := errgroup.WithContext(ctx)
-	restoreBackupWorkingGroup.SetLimit(int(b.cfg.General.DownloadConcurrency))
+	restoreConcurrency := b.cfg.General.DownloadConcurrency
+	if b.cfg.General.RestoreConcurrency > 0 {
+		restoreConcurrency = b.cfg.General.RestoreConcurrency
+	}
+	dependencies := getRestoreDataDependencies(tablesForRestore)
+	restoreWaves, circularErr := getRestoreDataWaves(tablesForRestore, dependencies)
+	if circularErr != nil {
+		log.Warnf("%v, will restore them after other tables", circularErr)
+	}
+	log.Debugf("restore data for %d tables in %d dependency waves with restore_concurrency=%d", len(tablesForRestore), len(restoreWaves), restoreConcurrency)
+	if b.cfg.General.IntegrityManifest {
+		verified, verifyErr := b.verifyTablesChecksums(ctx, backupName, tablesForRestore, diskMap, int(restoreConcurrency), log)
+		if verifyErr != nil {
+			return fmt.Errorf("backup integrity check failed: %v", verifyErr)
+		}
+		log.Infof("verified checksums of %d files", verified)
+	}
 
+	dstTables := make([]clickhouse.Table, len(tablesForRestore))
 	for i := range tablesForRestore {
-		tableRestoreStartTime := time.Now()
-		table := tablesForRestore[i]
 		// need mapped database path and original table.Database for HardlinkBackupPartsToStorage
-		dstDatabase := table.Database
+		dstDatabase := tablesForRestore[i].Database
 		if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
-			if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
+			if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[tablesForRestore[i].Database]; isMapped {
 				dstDatabase = targetDB
-				tablesForRestore[i].Database = targetDB
 			}
 		}
-		log := log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, table.Table))
 		dstTable, ok := dstTablesMap[metadata.TableTitle{
 			Database: dstDatabase,
-			Table:    table.Table}]
+			Table:    tablesForRestore[i].Table}]
 		if !ok {
-			return fmt.Errorf("can't find '%s.%s' in current system.tables", dstDatabase, table.Table)
-		}
-		idx := i
-		restoreBackupWorkingGroup.Go(func() error {
-			// https://github.com/Altinity/clickhouse-backup/issues/529
-			if b.cfg.ClickHouse.RestoreAsAttach {
-				if restoreErr := b.restoreDataRegularByAttach(restoreCtx, backupName, backupMetadata, table, diskMap, diskTypes, disks, dstTable, log); restoreErr != nil {
-					return restoreErr
+			return fmt.Errorf("can't find '%s.%s' in current system.tables", dstDatabase, tablesForRestore[i].Table)
+		}
+		dstTables[i] = dstTable
+	}
+
+	progress := b.newProgressReporter("restore", backupName, len(tablesForRestore))
+	for waveNum, wave := range restoreWaves {
+		restoreBackupWorkingGroup, restoreCtx := errgroup.WithContext(ctx)
+		restoreBackupWorkingGroup.SetLimit(int(restoreConcurrency))
+		for _, idx := range wave {
+			tableRestoreStartTime := time.Now()
+			table := tablesForRestore[idx]
+			dstTable := dstTables[idx]
+			tablesForRestore[idx].Database = dstTable.Database
+			log := log.WithField("table", fmt.Sprintf("%s.%s", dstTable.Database, table.Table)).WithField("phase", "restore_table")
+			if waveNum > 0 {
+				log = log.WithField("wave", waveNum)
+			}
+			hasDependencies := len(dependencies[idx]) > 0
+			restoreBackupWorkingGroup.Go(func() error {
+				if b.reshardCluster != "" && isReshardSupported(table) {
+					shardingKey, exists := shardingKeys[metadata.TableTitle{Database: table.Database, Table: table.Table}]
+					if !exists {
+						shardingKey = "rand()"
+					}
+					if reshardErr := b.restoreDataResharding(restoreCtx, backupName, backupMetadata, table, diskMap, diskTypes, disks, dstTable, shardingKey, log); reshardErr != nil {
+						return reshardErr
+					}
+					progress.tableDone(fmt.Sprintf("%s.%s", dstTable.Database, table.Table), table.TotalBytes)
+					return nil
 				}
-			} else {
-				if restoreErr := b.restoreDataRegularByParts(restoreCtx, backupName, backupMetadata, table, diskMap, diskTypes, disks, dstTable, log); restoreErr != nil {
-					return restoreErr
+				// https://github.com/Altinity/clickhouse-backup/issues/529
+				if b.cfg.ClickHouse.RestoreAsAttach {
+					if restoreErr := b.restoreDataRegularByAttach(restoreCtx, backupName, backupMetadata, table, diskMap, diskTypes, disks, dstTable, log); restoreErr != nil {
+						return restoreErr
+					}
+				} else {
+					if restoreErr := b.restoreDataRegularByParts(restoreCtx, backupName, backupMetadata, table, diskMap, diskTypes, disks, dstTable, log); restoreErr != nil {
+						return restoreErr
+					}
 				}
-			}
-			// https://github.com/Altinity/clickhouse-backup/issues/529
-			for _, mutation := range table.Mutations {
-				if err := b.ch.ApplyMutation(restoreCtx, tablesForRestore[idx], mutation); err != nil {
-					log.Warnf("can't apply mutation %s for table `%s`.`%s`	: %v", mutation.Command, tablesForRestore[idx].Database, tablesForRestore[idx].Table, err)
+				if b.restoreDetached && len(table.DetachedParts) > 0 {
+					if detachedErr := b.restoreDetachedParts(backupName, table, diskMap, disks, dstTable, log); detachedErr != nil {
+						return detachedErr
+					}
 				}
-			}
-			log.WithField("duration", utils.HumanizeDuration(time.Since(tableRestoreStartTime))).Info("done")
-			return nil
-		})
-	}
-	if wgWaitErr := restoreBackupWorkingGroup.Wait(); wgWaitErr != nil {
-		return fmt.Errorf("one of restoreDataRegular go-routine return error: %v", wgWaitErr)
+				// https://github.com/Altinity/clickhouse-backup/issues/529
+				for _, mutation := range table.Mutations {
+					if err := b.ch.ApplyMutation(restoreCtx, tablesForRestore[idx], mutation); err != nil {
+						log.Warnf("can't apply mutation %s for table `%s`.`%s`	: %v", mutation.Command, tablesForRestore[idx].Database, tablesForRestore[idx].Table, err)
+					}
+				}
+				// dictionary loaded during CREATE DICTIONARY from empty source table, reload it after source data restored
+				if hasDependencies && strings.HasPrefix(table.Query, "CREATE DICTIONARY") {
+					if err := b.ch.QueryContext(restoreCtx, fmt.Sprintf("SYSTEM RELOAD DICTIONARY `%s`.`%s`", tablesForRestore[idx].Database, tablesForRestore[idx].Table)); err != nil {
+						log.Warnf("can't reload dictionary after restore source table data: %v", err)
+					}
+				}
+				log.WithField("duration", utils.HumanizeDuration(time.Since(tableRestoreStartTime))).Info("done")
+				progress.tableDone(fmt.Sprintf("%s.%s", dstTable.Database, table.Table), table.TotalBytes)
+				return nil
+			})
+		}
+		if wgWaitErr := restoreBackupWorkingGroup.Wait(); wgWaitErr != nil {
+			return fmt.Errorf("one of restoreDataRegular go-routine return error: %v", wgWaitErr)
+		}
 	}
 	return nil
 }
@@ -1242,9 +1441,22 @@ func (b *Backuper) restoreDataRegularByAttach(ctx context.Context, backupName st
 	if err := b.downloadObjectDiskParts(ctx, backupName, backupMetadata, table, diskMap, diskTypes, disks); err != nil {
 		return fmt.Errorf("can't restore object_disk server-side copy data parts '%s.%s': %v", table.Database, table.Table, err)
 	}
-	if err := b.ch.AttachTable(ctx, table, dstTable); err != nil {
+	if b.repairProjections && len(getBrokenProjections(table)) > 0 {
+		log.Warn("--repair-projections is not supported with `clickhouse->restore_as_attach: true`, broken projections will not be repaired")
+	}
+	restorePriorityDone := b.applyRestorePriority()
+	err := b.ch.AttachTable(ctx, table, dstTable)
+	restorePriorityDone()
+	if err != nil {
 		return fmt.Errorf("can't attach table '%s.%s': %v", table.Database, table.Table, err)
 	}
+	if b.cfg.General.RestoreAttachPauseDuration > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.cfg.General.RestoreAttachPauseDuration):
+		}
+	}
 	return nil
 }
 
@@ -1256,14 +1468,24 @@ func (b *Backuper) restoreDataRegularByParts(ctx context.Context, backupName str
 	if err := b.downloadObjectDiskParts(ctx, backupName, backupMetadata, table, diskMap, diskTypes, disks); err != nil {
 		return fmt.Errorf("can't restore object_disk server-side copy data parts '%s.%s': %v", table.Database, table.Table, err)
 	}
-	if err := b.ch.AttachDataParts(table, dstTable); err != nil {
+	defer b.applyRestorePriority()()
+	if b.repairProjections {
+		if err := b.attachDataPartsRepairProjections(ctx, table, dstTable, disks, log); err != nil {
+			return fmt.Errorf("can't attach data parts for table '%s.%s': %v", table.Database, table.Table, err)
+		}
+		return nil
+	}
+	if len(getBrokenProjections(table)) > 0 {
+		log.Warn("backup contains broken projections, use --repair-projections if ATTACH PART fails")
+	}
+	if err := b.ch.AttachDataParts(ctx, table, dstTable, b.cfg.General.RestoreAttachPauseDuration); err != nil {
 		return fmt.Errorf("can't attach data parts for table '%s.%s': %v", table.Database, table.Table, err)
 	}
 	return nil
 }
 
 func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, backupTable metadata.TableMetadata, diskMap, diskTypes map[string]string, disks []clickhouse.Disk) error {
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"operation": "downloadObjectDiskParts",
 		"table":     fmt.Sprintf("%s.%s", backupTable.Database, backupTable.Table),
 	})
@@ -1279,7 +1501,7 @@ func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName strin
 			return fmt.Errorf("%s disk doesn't present in diskTypes: %v", diskName, diskTypes)
 		}
 		isObjectDiskEncrypted := false
-		if diskType == "encrypted" {
+		if b.isDiskTypeWrapper(diskType) {
 			if diskPath, exists := diskMap[diskName]; !exists {
 				for _, part := range parts {
 					if part.RebalancedDisk != "" {
@@ -1311,6 +1533,7 @@ func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName strin
 				return err
 			}
 			start := time.Now()
+			var throttledSize int64
 			downloadObjectDiskPartsWorkingGroup, downloadCtx := errgroup.WithContext(ctx)
 			downloadObjectDiskPartsWorkingGroup.SetLimit(int(b.cfg.General.DownloadConcurrency))
 			for _, part := range parts {
@@ -1362,13 +1585,13 @@ func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName strin
 							if storageObject.ObjectSize == 0 {
 								continue
 							}
-							if b.cfg.General.RemoteStorage == "s3" && (diskType == "s3" || diskType == "encrypted") {
+							if b.cfg.General.RemoteStorage == "s3" && (diskType == "s3" || b.isDiskTypeWrapper(diskType)) {
 								srcBucket = b.cfg.S3.Bucket
 								srcKey = path.Join(b.cfg.S3.ObjectDiskPath, srcBackupName, srcDiskName, storageObject.ObjectRelativePath)
-							} else if b.cfg.General.RemoteStorage == "gcs" && (diskType == "s3" || diskType == "encrypted") {
+							} else if b.cfg.General.RemoteStorage == "gcs" && (diskType == "s3" || b.isDiskTypeWrapper(diskType)) {
 								srcBucket = b.cfg.GCS.Bucket
 								srcKey = path.Join(b.cfg.GCS.ObjectDiskPath, srcBackupName, srcDiskName, storageObject.ObjectRelativePath)
-							} else if b.cfg.General.RemoteStorage == "azblob" && (diskType == "azure_blob_storage" || diskType == "azure" || diskType == "encrypted") {
+							} else if b.cfg.General.RemoteStorage == "azblob" && (diskType == "azure_blob_storage" || diskType == "azure" || b.isDiskTypeWrapper(diskType)) {
 								srcBucket = b.cfg.AzureBlob.Container
 								srcKey = path.Join(b.cfg.AzureBlob.ObjectDiskPath, srcBackupName, srcDiskName, storageObject.ObjectRelativePath)
 							} else {
@@ -1378,6 +1601,9 @@ func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName strin
 								return fmt.Errorf("object_disk.CopyObject error: %v", copyObjectErr)
 							} else {
 								atomic.AddInt64(&size, copiedSize)
+								if throttleErr := b.throttleObjectDiskCopy(downloadCtx, b.cfg.General.RestoreMaxBytesPerSecond, start, atomic.AddInt64(&throttledSize, copiedSize)); throttleErr != nil {
+									return throttleErr
+								}
 							}
 						}
 						return nil
@@ -0,0 +1,393 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// clusterRestoreSizeTolerance - allowed relative difference between size of table parts in backup and restored active parts, merges after restore could change size
+const clusterRestoreSizeTolerance = 0.1
+
+// clusterRestoreReplica - replica of target cluster and backup of the same shard from cluster manifest
+type clusterRestoreReplica struct {
+	ShardNum   uint32
+	Host       string
+	BackupName string
+	Data       bool
+}
+
+// clusterTableSize - size of active parts of each table on each replica of cluster
+type clusterTableSize struct {
+	ShardNum uint32 `ch:"shard_num"`
+	Host     string `ch:"host"`
+	Database string `ch:"database"`
+	Table    string `ch:"table"`
+	Bytes    uint64 `ch:"bytes"`
+}
+
+// getClusterRestorePlan - map shards from cluster manifest to shards of target cluster with the same shard_num, schema restored on all replicas from backup of the data replica in source shard
+// data restored only on one replica of each target shard, chosen the same way as `create_cluster` choose data replica
+func getClusterRestorePlan(manifest metadata.ClusterBackupMetadata, cluster string, replicas []clusterReplica) ([]clusterRestoreReplica, error) {
+	targetPlan, err := getClusterBackupPlan(manifest.BackupName, cluster, replicas)
+	if err != nil {
+		return nil, err
+	}
+	sourceBackups := map[uint32]string{}
+	for _, shard := range manifest.Shards {
+		for _, replica := range shard.Replicas {
+			if replica.Data {
+				sourceBackups[shard.ShardNum] = replica.BackupName
+			}
+		}
+		if _, exists := sourceBackups[shard.ShardNum]; !exists {
+			return nil, fmt.Errorf("shard %d in %s%s doesn't contain replica with data", shard.ShardNum, manifest.BackupName, storage.ClusterManifestSuffix)
+		}
+	}
+	if len(sourceBackups) != len(targetPlan.Shards) {
+		return nil, fmt.Errorf("%s%s contains %d shards, cluster %s contains %d shards, use `restore_remote --reshard-cluster` for each shard backup instead", manifest.BackupName, storage.ClusterManifestSuffix, len(sourceBackups), cluster, len(targetPlan.Shards))
+	}
+	var plan []clusterRestoreReplica
+	for _, shard := range targetPlan.Shards {
+		backupName, exists := sourceBackups[shard.ShardNum]
+		if !exists {
+			return nil, fmt.Errorf("shard %d of cluster %s is not present in %s%s", shard.ShardNum, cluster, manifest.BackupName, storage.ClusterManifestSuffix)
+		}
+		for _, replica := range shard.Replicas {
+			plan = append(plan, clusterRestoreReplica{
+				ShardNum:   shard.ShardNum,
+				Host:       replica.Host,
+				BackupName: backupName,
+				Data:       replica.Data,
+			})
+		}
+	}
+	return plan, nil
+}
+
+// verifyClusterShardsData - each replica shall contain size of active parts close to size of table in backup of own shard
+// when size is closer to backup of another shard, then data of shards probably mixed up,
+// not replicated tables are restored only on data replica, so only replica with the biggest size of table is checked
+func verifyClusterShardsData(expected map[uint32]map[metadata.TableTitle]uint64, replicated map[metadata.TableTitle]bool, actual []clusterTableSize, hosts map[uint32][]string) []string {
+	actualByHost := map[string]map[metadata.TableTitle]uint64{}
+	for _, row := range actual {
+		key := fmt.Sprintf("%d/%s", row.ShardNum, row.Host)
+		if _, exists := actualByHost[key]; !exists {
+			actualByHost[key] = map[metadata.TableTitle]uint64{}
+		}
+		actualByHost[key][metadata.TableTitle{Database: row.Database, Table: row.Table}] += row.Bytes
+	}
+	isClose := func(actualBytes, expectedBytes uint64) bool {
+		return math.Abs(float64(actualBytes)-float64(expectedBytes)) <= float64(expectedBytes)*clusterRestoreSizeTolerance
+	}
+	shardNums := make([]uint32, 0, len(expected))
+	for shardNum := range expected {
+		shardNums = append(shardNums, shardNum)
+	}
+	sort.Slice(shardNums, func(i, j int) bool { return shardNums[i] < shardNums[j] })
+	var problems []string
+	for _, shardNum := range shardNums {
+		tables := make([]metadata.TableTitle, 0, len(expected[shardNum]))
+		for table := range expected[shardNum] {
+			tables = append(tables, table)
+		}
+		sort.Slice(tables, func(i, j int) bool {
+			return tables[i].Database+"."+tables[i].Table < tables[j].Database+"."+tables[j].Table
+		})
+		dataHosts := map[metadata.TableTitle]string{}
+		for _, table := range tables {
+			maxBytes := uint64(0)
+			for _, host := range hosts[shardNum] {
+				if bytes := actualByHost[fmt.Sprintf("%d/%s", shardNum, host)][table]; dataHosts[table] == "" || bytes > maxBytes {
+					dataHosts[table], maxBytes = host, bytes
+				}
+			}
+		}
+		for _, host := range hosts[shardNum] {
+			for _, table := range tables {
+				if !replicated[table] && host != dataHosts[table] {
+					continue
+				}
+				expectedBytes := expected[shardNum][table]
+				actualBytes := actualByHost[fmt.Sprintf("%d/%s", shardNum, host)][table]
+				if isClose(actualBytes, expectedBytes) {
+					continue
+				}
+				problem := fmt.Sprintf("shard %d replica %s table %s.%s contains %s, expected %s", shardNum, host, table.Database, table.Table, utils.FormatBytes(actualBytes), utils.FormatBytes(expectedBytes))
+				for _, otherShardNum := range shardNums {
+					if otherBytes, exists := expected[otherShardNum][table]; otherShardNum != shardNum && exists && isClose(actualBytes, otherBytes) {
+						problem += fmt.Sprintf(", looks like data of shard %d", otherShardNum)
+						break
+					}
+				}
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems
+}
+
+// RestoreCluster - `restore_cluster`, read cluster manifest created by `create_cluster`, restore schema on all replicas and data on one replica per shard via API
+// then wait until replication queues are empty and verify each shard received data from backup of the same shard
+func (b *Backuper) RestoreCluster(backupName, cluster, tablePattern string, dropExists bool, syncTimeout time.Duration, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startRestore := time.Now()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_cluster",
+	})
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("restore_cluster doesn't support remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	bd, err := b.newBackupDestination(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	b.dst = bd
+	manifest, err := bd.GetClusterManifest(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if cluster == "" {
+		cluster = manifest.Cluster
+	}
+	if cluster, err = b.ch.ApplyMacros(ctx, cluster); err != nil {
+		return err
+	}
+	var replicas []clusterReplica
+	if err = b.ch.SelectContext(ctx, &replicas, "SELECT shard_num, replica_num, host_name, errors_count FROM system.clusters WHERE cluster=?", cluster); err != nil {
+		return fmt.Errorf("can't get cluster %s from system.clusters: %v", cluster, err)
+	}
+	plan, err := getClusterRestorePlan(*manifest, cluster, replicas)
+	if err != nil {
+		return err
+	}
+	expected, replicated, err := b.getClusterExpectedTableSizes(ctx, plan, tablePattern)
+	if err != nil {
+		return err
+	}
+
+	schemaCommand := []string{"restore_remote", "--schema"}
+	if dropExists {
+		schemaCommand = append(schemaCommand, "--rm")
+	}
+	dataCommand := []string{"restore_remote", "--data"}
+	if tablePattern != "" {
+		schemaCommand = append(schemaCommand, fmt.Sprintf("--tables=%q", tablePattern))
+		dataCommand = append(dataCommand, fmt.Sprintf("--tables=%q", tablePattern))
+	}
+	log.Info("restore schema on all replicas")
+	if err = b.runClusterRestoreStep(ctx, plan, false, schemaCommand, log); err != nil {
+		return err
+	}
+	log.Info("restore data on one replica per shard")
+	if err = b.runClusterRestoreStep(ctx, plan, true, dataCommand, log); err != nil {
+		return err
+	}
+	if err = b.runClusterRestoreStep(ctx, plan, false, []string{"delete", "local"}, log); err != nil {
+		log.Warnf("can't delete local backups after restore: %v", err)
+	}
+	if err = b.waitClusterReplicationSync(ctx, cluster, syncTimeout, log); err != nil {
+		return err
+	}
+	var actual []clusterTableSize
+	query := fmt.Sprintf("SELECT _shard_num AS shard_num, hostName() AS host, database, table, sum(bytes_on_disk) AS bytes FROM clusterAllReplicas('%s', system.parts) WHERE active GROUP BY shard_num, host, database, table", cluster)
+	if err = b.ch.SelectContext(ctx, &actual, query); err != nil {
+		return fmt.Errorf("can't get restored parts from cluster %s: %v", cluster, err)
+	}
+	// system.clusters contains host_name from config, hostName() returns real hostname, so use hosts which really answered from each shard
+	hosts := map[uint32][]string{}
+	for _, row := range actual {
+		isExists := false
+		for _, host := range hosts[row.ShardNum] {
+			if host == row.Host {
+				isExists = true
+				break
+			}
+		}
+		if !isExists {
+			hosts[row.ShardNum] = append(hosts[row.ShardNum], row.Host)
+		}
+	}
+	problems := verifyClusterShardsData(expected, replicated, actual, hosts)
+	replicasCount := map[uint32]int{}
+	for _, replica := range plan {
+		replicasCount[replica.ShardNum]++
+	}
+	for shardNum, tables := range expected {
+		// replicas without data receive parts only for Replicated* tables
+		hasReplicatedTables := false
+		for table := range tables {
+			hasReplicatedTables = hasReplicatedTables || replicated[table]
+		}
+		if hasReplicatedTables && len(hosts[shardNum]) < replicasCount[shardNum] {
+			problems = append(problems, fmt.Sprintf("shard %d only %d of %d replicas contain active parts", shardNum, len(hosts[shardNum]), replicasCount[shardNum]))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("restored data verification failed:\n%s", strings.Join(problems, "\n"))
+	}
+	log.WithFields(apexLog.Fields{
+		"cluster":  cluster,
+		"shards":   len(expected),
+		"duration": utils.HumanizeDuration(time.Since(startRestore)),
+	}).Info("done")
+	return nil
+}
+
+// runClusterRestoreStep - run command with backup name of own shard on all replicas or only on data replicas in parallel
+func (b *Backuper) runClusterRestoreStep(ctx context.Context, plan []clusterRestoreReplica, dataOnly bool, command []string, log *apexLog.Entry) error {
+	client, err := b.newClusterHTTPClient()
+	if err != nil {
+		return err
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	for _, replica := range plan {
+		if dataOnly && !replica.Data {
+			continue
+		}
+		replicaCommand := strings.Join(append(append([]string{}, command...), replica.BackupName), " ")
+		replicaLog := log.WithFields(apexLog.Fields{"shard": replica.ShardNum, "replica": replica.Host})
+		g.Go(func() error {
+			baseURL, err := b.getClusterAPIBaseURL(replica.Host)
+			if err != nil {
+				return err
+			}
+			if _, err = b.runClusterAction(gCtx, client, baseURL, replicaCommand, replica.BackupName, replicaLog); err != nil {
+				return fmt.Errorf("%s on %s: %v", replicaCommand, replica.Host, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// waitClusterReplicationSync - wait until replication queue is empty on all replicas of cluster
+func (b *Backuper) waitClusterReplicationSync(ctx context.Context, cluster string, syncTimeout time.Duration, log *apexLog.Entry) error {
+	syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+	query := fmt.Sprintf("SELECT count() AS queue FROM clusterAllReplicas('%s', system.replicas) WHERE queue_size > 0 OR inserts_in_queue > 0", cluster)
+	for {
+		var rows []struct {
+			Queue uint64 `ch:"queue"`
+		}
+		if err := b.ch.SelectContext(syncCtx, &rows, query); err != nil {
+			return fmt.Errorf("can't get replication queue on cluster %s: %v", cluster, err)
+		}
+		if len(rows) == 0 || rows[0].Queue == 0 {
+			log.Info("replication queues are empty")
+			return nil
+		}
+		log.Infof("wait replication, %d tables have non empty queue", rows[0].Queue)
+		select {
+		case <-syncCtx.Done():
+			return fmt.Errorf("replication on cluster %s not finished during %s", cluster, syncTimeout)
+		case <-time.After(clusterOperationPollInterval):
+		}
+	}
+}
+
+// getClusterExpectedTableSizes - size of tables with data parts in backup of each shard, read from remote table metadata, and which of these tables use Replicated* engine
+func (b *Backuper) getClusterExpectedTableSizes(ctx context.Context, plan []clusterRestoreReplica, tablePattern string) (map[uint32]map[metadata.TableTitle]uint64, map[metadata.TableTitle]bool, error) {
+	tablePatterns := []string{"*"}
+	if tablePattern != "" {
+		tablePatterns = strings.Split(tablePattern, ",")
+	}
+	expected := map[uint32]map[metadata.TableTitle]uint64{}
+	replicated := map[metadata.TableTitle]bool{}
+	for _, replica := range plan {
+		if !replica.Data {
+			continue
+		}
+		backupMetadata, err := b.readClusterRemoteJSON(ctx, replica.BackupName, path.Join(replica.BackupName, "metadata.json"), &metadata.BackupMetadata{})
+		if err != nil {
+			return nil, nil, err
+		}
+		expected[replica.ShardNum] = map[metadata.TableTitle]uint64{}
+		for _, table := range backupMetadata.(*metadata.BackupMetadata).Tables {
+			tableName := fmt.Sprintf("%s.%s", table.Database, table.Table)
+			isMatched := false
+			for _, p := range tablePatterns {
+				if isMatched, _ = filepath.Match(strings.Trim(p, " \t\r\n"), tableName); isMatched {
+					break
+				}
+			}
+			if !isMatched || b.shouldSkipByTableName(tableName) {
+				continue
+			}
+			remoteTableMetadata := path.Join(replica.BackupName, "metadata", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table)+".json")
+			tableMetadata, err := b.readClusterRemoteJSON(ctx, replica.BackupName, remoteTableMetadata, &metadata.TableMetadata{})
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(tableMetadata.(*metadata.TableMetadata).Parts) == 0 {
+				continue
+			}
+			size := uint64(0)
+			for _, diskSize := range tableMetadata.(*metadata.TableMetadata).Size {
+				size += uint64(diskSize)
+			}
+			expected[replica.ShardNum][table] = size
+			replicated[table] = strings.Contains(ddlEngine(tableMetadata.(*metadata.TableMetadata).Query), "Replicated")
+		}
+	}
+	return expected, replicated, nil
+}
+
+func (b *Backuper) readClusterRemoteJSON(ctx context.Context, backupName, remoteFile string, v interface{}) (interface{}, error) {
+	reader, err := b.dst.GetFileReader(ctx, remoteFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %v", remoteFile, err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	if err = reader.Close(); err != nil {
+		return nil, err
+	}
+	if err = b.verifyRemoteFile(ctx, backupName, remoteFile, body); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", remoteFile, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetClusterRestorePlan(t *testing.T) {
+	manifest := metadata.ClusterBackupMetadata{
+		BackupName: "b",
+		Cluster:    "old",
+		Shards: []metadata.ClusterShardMetadata{
+			{ShardNum: 1, Replicas: []metadata.ClusterReplicaMetadata{
+				{ReplicaNum: 1, BackupName: "b_shard1_replica1"},
+				{ReplicaNum: 2, BackupName: "b_shard1_replica2", Data: true},
+			}},
+			{ShardNum: 2, Replicas: []metadata.ClusterReplicaMetadata{
+				{ReplicaNum: 1, BackupName: "b_shard2_replica1", Data: true},
+			}},
+		},
+	}
+	plan, err := getClusterRestorePlan(manifest, "new", []clusterReplica{
+		{ShardNum: 2, ReplicaNum: 1, HostName: "s2r1"},
+		{ShardNum: 2, ReplicaNum: 2, HostName: "s2r2"},
+		{ShardNum: 1, ReplicaNum: 1, HostName: "s1r1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []clusterRestoreReplica{
+		{ShardNum: 1, Host: "s1r1", BackupName: "b_shard1_replica2", Data: true},
+		{ShardNum: 2, Host: "s2r1", BackupName: "b_shard2_replica1", Data: true},
+		{ShardNum: 2, Host: "s2r2", BackupName: "b_shard2_replica1"},
+	}, plan)
+
+	_, err = getClusterRestorePlan(manifest, "new", []clusterReplica{{ShardNum: 1, ReplicaNum: 1, HostName: "s1r1"}})
+	assert.ErrorContains(t, err, "--reshard-cluster")
+
+	_, err = getClusterRestorePlan(manifest, "new", []clusterReplica{
+		{ShardNum: 1, ReplicaNum: 1, HostName: "s1r1"},
+		{ShardNum: 3, ReplicaNum: 1, HostName: "s3r1"},
+	})
+	assert.ErrorContains(t, err, "shard 3")
+}
+
+func TestVerifyClusterShardsData(t *testing.T) {
+	table := metadata.TableTitle{Database: "db", Table: "t"}
+	expected := map[uint32]map[metadata.TableTitle]uint64{
+		1: {table: 1000},
+		2: {table: 5000},
+	}
+	replicated := map[metadata.TableTitle]bool{table: true}
+	hosts := map[uint32][]string{1: {"s1r1", "s1r2"}, 2: {"s2r1"}}
+	assert.Empty(t, verifyClusterShardsData(expected, replicated, []clusterTableSize{
+		{ShardNum: 1, Host: "s1r1", Database: "db", Table: "t", Bytes: 1050},
+		{ShardNum: 1, Host: "s1r2", Database: "db", Table: "t", Bytes: 950},
+		{ShardNum: 2, Host: "s2r1", Database: "db", Table: "t", Bytes: 5000},
+	}, hosts))
+
+	problems := verifyClusterShardsData(expected, replicated, []clusterTableSize{
+		{ShardNum: 1, Host: "s1r1", Database: "db", Table: "t", Bytes: 5000},
+		{ShardNum: 2, Host: "s2r1", Database: "db", Table: "t", Bytes: 1000},
+	}, hosts)
+	assert.Equal(t, 3, len(problems))
+	assert.Contains(t, problems[0], "looks like data of shard 2")
+	assert.Contains(t, problems[1], "s1r2")
+	assert.NotContains(t, problems[1], "looks like")
+	assert.Contains(t, problems[2], "looks like data of shard 1")
+
+	// not replicated table is restored only on one replica of shard
+	notReplicated := map[metadata.TableTitle]bool{}
+	assert.Empty(t, verifyClusterShardsData(expected, notReplicated, []clusterTableSize{
+		{ShardNum: 1, Host: "s1r1", Database: "db", Table: "t", Bytes: 1000},
+		{ShardNum: 1, Host: "s1r2", Database: "db", Table: "other", Bytes: 100},
+		{ShardNum: 2, Host: "s2r1", Database: "db", Table: "t", Bytes: 5000},
+	}, hosts))
+	problems = verifyClusterShardsData(expected, notReplicated, []clusterTableSize{
+		{ShardNum: 1, Host: "s1r1", Database: "db", Table: "t", Bytes: 5000},
+		{ShardNum: 2, Host: "s2r1", Database: "db", Table: "t", Bytes: 5000},
+	}, hosts)
+	assert.Equal(t, 1, len(problems))
+	assert.Contains(t, problems[0], "looks like data of shard 2")
+}
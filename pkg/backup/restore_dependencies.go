@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+)
+
+var materializedViewToTableRE = regexp.MustCompile("^(?:CREATE|ATTACH) MATERIALIZED VIEW \\S+(?: UUID '[^']+')?(?: ON CLUSTER \\S+)? TO (`[^`]+`|\\w+)(?:\\.(`[^`]+`|\\w+))?")
+var materializedViewUUIDRE = regexp.MustCompile(`(?is)^(?:CREATE|ATTACH) MATERIALIZED VIEW \S+ UUID '([^']+)'`)
+var viewFromTableRE = regexp.MustCompile("(?is)\\sFROM\\s+(`[^`]+`|[a-zA-Z_][\\w]*)(?:\\.(`[^`]+`|[a-zA-Z_][\\w]*))?")
+var dictionaryClickHouseSourceRE = regexp.MustCompile(`(?is)SOURCE\s*\(\s*CLICKHOUSE\s*\((.*?)\)\s*\)`)
+var dictionarySourceParamRE = regexp.MustCompile(`(?is)\b(TABLE|DB)\s+'([^']+)'`)
+var distributedEngineRE = regexp.MustCompile(`ENGINE = Distributed\(\s*'?[^,']+'?\s*,\s*'?([^,']+?)'?\s*,\s*'?([^,')]+?)'?\s*[,)]`)
+
+// getRestoreDataDependencies - tables from tablesForRestore which should be restored before each table
+// materialized view waits for TO table or .inner. table and source tables, view waits for source tables,
+// dictionary with SOURCE(CLICKHOUSE(...)) waits for source table, Distributed table waits for local table
+func getRestoreDataDependencies(tablesForRestore ListOfTables) [][]int {
+	tableIndex := make(map[metadata.TableTitle]int, len(tablesForRestore))
+	for i, t := range tablesForRestore {
+		tableIndex[metadata.TableTitle{Database: t.Database, Table: t.Table}] = i
+	}
+	dependencies := make([][]int, len(tablesForRestore))
+	for i, t := range tablesForRestore {
+		addDependency := func(database, table string) {
+			database, table = strings.Trim(database, "`"), strings.Trim(table, "`")
+			if table == "" {
+				database, table = t.Database, database
+			}
+			if database == "" {
+				database = t.Database
+			}
+			if j, exists := tableIndex[metadata.TableTitle{Database: database, Table: table}]; exists && j != i {
+				for _, existing := range dependencies[i] {
+					if existing == j {
+						return
+					}
+				}
+				dependencies[i] = append(dependencies[i], j)
+			}
+		}
+		query := t.Query
+		switch {
+		case strings.HasPrefix(query, "CREATE MATERIALIZED VIEW") || strings.HasPrefix(query, "ATTACH MATERIALIZED VIEW"):
+			if matches := materializedViewToTableRE.FindStringSubmatch(query); len(matches) > 0 {
+				addDependency(matches[1], matches[2])
+			} else {
+				addDependency(t.Database, ".inner."+t.Table)
+				if matches = materializedViewUUIDRE.FindStringSubmatch(query); len(matches) > 0 {
+					addDependency(t.Database, ".inner_id."+matches[1])
+				}
+			}
+			for _, matches := range viewFromTableRE.FindAllStringSubmatch(query, -1) {
+				addDependency(matches[1], matches[2])
+			}
+		case strings.HasPrefix(query, "CREATE VIEW") || strings.HasPrefix(query, "CREATE LIVE VIEW") || strings.HasPrefix(query, "CREATE WINDOW VIEW") || strings.HasPrefix(query, "ATTACH WINDOW VIEW"):
+			for _, matches := range viewFromTableRE.FindAllStringSubmatch(query, -1) {
+				addDependency(matches[1], matches[2])
+			}
+		case strings.HasPrefix(query, "CREATE DICTIONARY"):
+			if source := dictionaryClickHouseSourceRE.FindStringSubmatch(query); len(source) > 0 {
+				database, table := "", ""
+				for _, param := range dictionarySourceParamRE.FindAllStringSubmatch(source[1], -1) {
+					if strings.EqualFold(param[1], "DB") {
+						database = param[2]
+					} else {
+						table = param[2]
+					}
+				}
+				if table != "" {
+					addDependency(database, table)
+				}
+			}
+		default:
+			if matches := distributedEngineRE.FindStringSubmatch(query); len(matches) > 0 {
+				addDependency(matches[1], matches[2])
+			}
+		}
+		sort.Ints(dependencies[i])
+	}
+	return dependencies
+}
+
+// getRestoreDataWaves - split tablesForRestore into waves, each table restored only after all its dependencies restored in previous waves
+// tables inside each wave sorted by size descending, to start the longest restore first, tables with circular dependencies restored in the last wave
+func getRestoreDataWaves(tablesForRestore ListOfTables, dependencies [][]int) ([][]int, error) {
+	var waves [][]int
+	var circularErr error
+	restored := make([]bool, len(tablesForRestore))
+	restoredCount := 0
+	for restoredCount < len(tablesForRestore) {
+		var wave []int
+		for i := range tablesForRestore {
+			if restored[i] {
+				continue
+			}
+			isReady := true
+			for _, j := range dependencies[i] {
+				if !restored[j] {
+					isReady = false
+					break
+				}
+			}
+			if isReady {
+				wave = append(wave, i)
+			}
+		}
+		if len(wave) == 0 {
+			var circular []string
+			for i := range tablesForRestore {
+				if !restored[i] {
+					wave = append(wave, i)
+					circular = append(circular, fmt.Sprintf("%s.%s", tablesForRestore[i].Database, tablesForRestore[i].Table))
+				}
+			}
+			circularErr = fmt.Errorf("circular dependencies detected between %s", strings.Join(circular, ", "))
+		}
+		sort.SliceStable(wave, func(a, b int) bool {
+			return tablesForRestore[wave[a]].TotalBytes > tablesForRestore[wave[b]].TotalBytes
+		})
+		for _, i := range wave {
+			restored[i] = true
+		}
+		restoredCount += len(wave)
+		waves = append(waves, wave)
+	}
+	return waves, circularErr
+}
@@ -0,0 +1,237 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/antchfx/xmlquery"
+	apexLog "github.com/apex/log"
+)
+
+const (
+	// encryptedDiskModePreserve - files of `encrypted` disks are attached as is, current disk configuration shall contain keys from backup
+	encryptedDiskModePreserve = "preserve"
+	// encryptedDiskModeReencrypt - after attach ClickHouse decrypts restored parts with old key and writes them with current key
+	encryptedDiskModeReencrypt = "reencrypt"
+)
+
+// WithEncryptedDiskMode - `restore --encrypted-disk-mode`, define how restore data parts of `encrypted` disks when disk key changed after backup
+func WithEncryptedDiskMode(mode string) BackuperOpt {
+	return func(b *Backuper) {
+		b.encryptedDiskMode = mode
+	}
+}
+
+// encryptedKeyFingerprint - keys shall not leave the server, so only short sha256 prefix is saved in backup metadata
+func encryptedKeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// getEncryptedDisksFromXML - parse `key`, `key_hex`, `current_key_id`, `current_key`, `current_key_hex` and `algorithm` of each `encrypted` disk
+func getEncryptedDisksFromXML(doc *xmlquery.Node) (map[string]metadata.EncryptedDisk, error) {
+	root := xmlquery.FindOne(doc, "/")
+	if root == nil || root.FirstChild == nil {
+		return nil, nil
+	}
+	encryptedDisks := map[string]metadata.EncryptedDisk{}
+	for _, d := range xmlquery.Find(doc, fmt.Sprintf("/%s/storage_configuration/disks/*", root.Data)) {
+		diskTypeNode := d.SelectElement("type")
+		if diskTypeNode == nil || strings.Trim(diskTypeNode.InnerText(), "\r\n \t") != "encrypted" {
+			continue
+		}
+		encryptedDisk := metadata.EncryptedDisk{Algorithm: "AES_128_CTR"}
+		if algorithmNode := d.SelectElement("algorithm"); algorithmNode != nil {
+			encryptedDisk.Algorithm = strings.Trim(algorithmNode.InnerText(), "\r\n \t")
+		}
+		keysById := map[string]string{}
+		for _, keyNode := range d.SelectElements("*") {
+			if keyNode.Data != "key" && keyNode.Data != "key_hex" {
+				continue
+			}
+			fingerprint, err := getEncryptedKeyNodeFingerprint(keyNode)
+			if err != nil {
+				return nil, fmt.Errorf("storage_configuration/disks/%s/%s: %v", d.Data, keyNode.Data, err)
+			}
+			keyId := keyNode.SelectAttr("id")
+			if keyId == "" {
+				keyId = "0"
+			}
+			keysById[keyId] = fingerprint
+			encryptedDisk.Keys = append(encryptedDisk.Keys, fingerprint)
+		}
+		if len(encryptedDisk.Keys) == 0 {
+			return nil, fmt.Errorf("storage_configuration/disks/%s doesn't contain <key> or <key_hex>", d.Data)
+		}
+		sort.Strings(encryptedDisk.Keys)
+		if currentKeyIdNode := d.SelectElement("current_key_id"); currentKeyIdNode != nil {
+			encryptedDisk.CurrentKey = keysById[strings.Trim(currentKeyIdNode.InnerText(), "\r\n \t")]
+		} else if currentKeyNode := d.SelectElement("current_key"); currentKeyNode != nil {
+			encryptedDisk.CurrentKey = encryptedKeyFingerprint([]byte(currentKeyNode.InnerText()))
+		} else if currentKeyHexNode := d.SelectElement("current_key_hex"); currentKeyHexNode != nil {
+			fingerprint, err := getEncryptedKeyNodeFingerprint(currentKeyHexNode)
+			if err != nil {
+				return nil, fmt.Errorf("storage_configuration/disks/%s/current_key_hex: %v", d.Data, err)
+			}
+			encryptedDisk.CurrentKey = fingerprint
+		} else if len(keysById) == 1 {
+			encryptedDisk.CurrentKey = encryptedDisk.Keys[0]
+		} else {
+			encryptedDisk.CurrentKey = keysById["0"]
+		}
+		if encryptedDisk.CurrentKey == "" {
+			return nil, fmt.Errorf("storage_configuration/disks/%s, can't detect current key", d.Data)
+		}
+		encryptedDisks[d.Data] = encryptedDisk
+	}
+	return encryptedDisks, nil
+}
+
+func getEncryptedKeyNodeFingerprint(keyNode *xmlquery.Node) (string, error) {
+	if !strings.HasSuffix(keyNode.Data, "_hex") {
+		return encryptedKeyFingerprint([]byte(keyNode.InnerText())), nil
+	}
+	key, err := hex.DecodeString(strings.Trim(keyNode.InnerText(), "\r\n \t"))
+	if err != nil {
+		return "", err
+	}
+	return encryptedKeyFingerprint(key), nil
+}
+
+// getEncryptedDisks - read current settings of `encrypted` disks from preprocessed config.xml, nil when no `encrypted` disks
+func (b *Backuper) getEncryptedDisks(ctx context.Context, disks []clickhouse.Disk) (map[string]metadata.EncryptedDisk, error) {
+	isEncryptedDiskPresent := false
+	for _, disk := range disks {
+		if disk.Type == "encrypted" {
+			isEncryptedDiskPresent = true
+			break
+		}
+	}
+	if !isEncryptedDiskPresent {
+		return nil, nil
+	}
+	configFile, doc, err := b.ch.ParseXML(ctx, "config.xml")
+	if err != nil {
+		return nil, err
+	}
+	encryptedDisks, err := getEncryptedDisksFromXML(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", configFile, err)
+	}
+	return encryptedDisks, nil
+}
+
+// checkEncryptedDisksCompatibility - ClickHouse reads files of `encrypted` disk with key from file header, so all keys from backup shall be configured,
+// return disks where current key changed and restored parts shall be rewritten with `reencrypt` mode
+func checkEncryptedDisksCompatibility(backupDisks, currentDisks map[string]metadata.EncryptedDisk, usedDisks []string, mode string) (map[string]bool, error) {
+	reencryptDisks := map[string]bool{}
+	for _, diskName := range usedDisks {
+		backupDisk, isEncrypted := backupDisks[diskName]
+		if !isEncrypted {
+			continue
+		}
+		currentDisk, exists := currentDisks[diskName]
+		if !exists {
+			return nil, fmt.Errorf("disk %s is `encrypted` in backup, but current configuration doesn't contain `encrypted` disk with the same name", diskName)
+		}
+		for _, backupKey := range backupDisk.Keys {
+			keyExists := false
+			for _, currentKey := range currentDisk.Keys {
+				if currentKey == backupKey {
+					keyExists = true
+					break
+				}
+			}
+			if !keyExists {
+				return nil, fmt.Errorf("disk %s: key with sha256 fingerprint %s from backup is not configured, add it as <key_hex id=\"...\"> into disk configuration, keep new key in <current_key_id> and use --encrypted-disk-mode=%s", diskName, backupKey, encryptedDiskModeReencrypt)
+			}
+		}
+		if backupDisk.CurrentKey != currentDisk.CurrentKey && mode == encryptedDiskModeReencrypt {
+			reencryptDisks[diskName] = true
+		}
+	}
+	return reencryptDisks, nil
+}
+
+// checkEncryptedDisks - run before any DROP and CREATE during restore
+func (b *Backuper) checkEncryptedDisks(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, tablePattern string, partitions []string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	if b.encryptedDiskMode != "" && b.encryptedDiskMode != encryptedDiskModePreserve && b.encryptedDiskMode != encryptedDiskModeReencrypt {
+		return fmt.Errorf("unsupported --encrypted-disk-mode=%s, shall be %s or %s", b.encryptedDiskMode, encryptedDiskModePreserve, encryptedDiskModeReencrypt)
+	}
+	if len(backupMetadata.EncryptedDisks) == 0 || len(backupMetadata.Tables) == 0 {
+		if b.encryptedDiskMode == encryptedDiskModeReencrypt {
+			log.Warnf("--encrypted-disk-mode=%s ignored, backup doesn't contain `encrypted` disks settings", encryptedDiskModeReencrypt)
+		}
+		return nil
+	}
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	tables, _, err := b.getTableListByPatternLocal(ctx, path.Join(b.DefaultDataPath, "backup", backupName, "metadata"), tablePattern, false, partitions)
+	if err != nil {
+		return err
+	}
+	usedDisksMap := map[string]bool{}
+	for _, table := range tables {
+		for diskName, parts := range table.Parts {
+			if _, isEncrypted := backupMetadata.EncryptedDisks[diskName]; isEncrypted && len(parts) > 0 {
+				usedDisksMap[diskName] = true
+			}
+		}
+	}
+	usedDisks := make([]string, 0, len(usedDisksMap))
+	for diskName := range usedDisksMap {
+		usedDisks = append(usedDisks, diskName)
+	}
+	sort.Strings(usedDisks)
+	if len(usedDisks) == 0 {
+		return nil
+	}
+	currentDisks, err := b.getEncryptedDisks(ctx, disks)
+	if err != nil {
+		return err
+	}
+	b.reencryptDisks, err = checkEncryptedDisksCompatibility(backupMetadata.EncryptedDisks, currentDisks, usedDisks, b.encryptedDiskMode)
+	if err != nil {
+		return err
+	}
+	for _, diskName := range usedDisks {
+		if backupMetadata.EncryptedDisks[diskName].CurrentKey != currentDisks[diskName].CurrentKey && !b.reencryptDisks[diskName] {
+			log.Warnf("disk %s: current key changed after backup, restored parts will use old key until merge, use --encrypted-disk-mode=%s to rewrite them", diskName, encryptedDiskModeReencrypt)
+		}
+	}
+	return nil
+}
+
+// reencryptRestoredTables - OPTIMIZE ... FINAL rewrites all parts of table, ClickHouse decrypts them with old key and writes with current key of `encrypted` disk
+func (b *Backuper) reencryptRestoredTables(ctx context.Context, tablesForRestore ListOfTables, log *apexLog.Entry) error {
+	for _, table := range tablesForRestore {
+		needReencrypt := false
+		for diskName, parts := range table.Parts {
+			if b.reencryptDisks[diskName] && len(parts) > 0 {
+				needReencrypt = true
+				break
+			}
+		}
+		if !needReencrypt {
+			continue
+		}
+		dstDatabase := table.Database
+		if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
+			dstDatabase = targetDB
+		}
+		query := fmt.Sprintf("OPTIMIZE TABLE `%s`.`%s` FINAL", dstDatabase, table.Table)
+		if err := b.ch.QueryContext(ctx, query); err != nil {
+			return fmt.Errorf("can't reencrypt `%s`.`%s`: %v", dstDatabase, table.Table, err)
+		}
+		log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, table.Table)).Info("reencrypted with current disk key")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/antchfx/xmlquery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEncryptedDisksFromXML(t *testing.T) {
+	doc, err := xmlquery.Parse(strings.NewReader(`<clickhouse>
+	<storage_configuration>
+		<disks>
+			<disk_local><type>local</type><path>/var/lib/clickhouse/disks/local/</path></disk_local>
+			<encrypted_single><type>encrypted</type><disk>disk_local</disk><path>single/</path><key>firstfirstfirstf</key></encrypted_single>
+			<encrypted_rotated>
+				<type>encrypted</type>
+				<disk>disk_local</disk>
+				<path>rotated/</path>
+				<key id="0">firstfirstfirstf</key>
+				<key_hex id="1">00112233445566778899aabbccddeeff</key_hex>
+				<current_key_id>1</current_key_id>
+				<algorithm>AES_256_CTR</algorithm>
+			</encrypted_rotated>
+		</disks>
+	</storage_configuration>
+</clickhouse>`))
+	require.NoError(t, err)
+	encryptedDisks, err := getEncryptedDisksFromXML(doc)
+	require.NoError(t, err)
+	require.Len(t, encryptedDisks, 2)
+	oldKey := encryptedKeyFingerprint([]byte("firstfirstfirstf"))
+	newKey := encryptedKeyFingerprint([]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	assert.Len(t, oldKey, 16)
+	assert.Equal(t, metadata.EncryptedDisk{Algorithm: "AES_128_CTR", CurrentKey: oldKey, Keys: []string{oldKey}}, encryptedDisks["encrypted_single"])
+	assert.Equal(t, "AES_256_CTR", encryptedDisks["encrypted_rotated"].Algorithm)
+	assert.Equal(t, newKey, encryptedDisks["encrypted_rotated"].CurrentKey)
+	assert.ElementsMatch(t, []string{oldKey, newKey}, encryptedDisks["encrypted_rotated"].Keys)
+
+	doc, err = xmlquery.Parse(strings.NewReader(`<clickhouse><storage_configuration><disks><encrypted><type>encrypted</type><key_hex>zz</key_hex></encrypted></disks></storage_configuration></clickhouse>`))
+	require.NoError(t, err)
+	_, err = getEncryptedDisksFromXML(doc)
+	assert.ErrorContains(t, err, "storage_configuration/disks/encrypted/key_hex")
+}
+
+func TestCheckEncryptedDisksCompatibility(t *testing.T) {
+	backupDisks := map[string]metadata.EncryptedDisk{
+		"encrypted": {Algorithm: "AES_128_CTR", CurrentKey: "old", Keys: []string{"old"}},
+	}
+	sameKey := map[string]metadata.EncryptedDisk{
+		"encrypted": {Algorithm: "AES_128_CTR", CurrentKey: "old", Keys: []string{"old"}},
+	}
+	rotatedKey := map[string]metadata.EncryptedDisk{
+		"encrypted": {Algorithm: "AES_128_CTR", CurrentKey: "new", Keys: []string{"new", "old"}},
+	}
+	onlyNewKey := map[string]metadata.EncryptedDisk{
+		"encrypted": {Algorithm: "AES_128_CTR", CurrentKey: "new", Keys: []string{"new"}},
+	}
+
+	reencryptDisks, err := checkEncryptedDisksCompatibility(backupDisks, sameKey, []string{"default", "encrypted"}, encryptedDiskModeReencrypt)
+	require.NoError(t, err)
+	assert.Empty(t, reencryptDisks)
+
+	reencryptDisks, err = checkEncryptedDisksCompatibility(backupDisks, rotatedKey, []string{"encrypted"}, encryptedDiskModePreserve)
+	require.NoError(t, err)
+	assert.Empty(t, reencryptDisks)
+
+	reencryptDisks, err = checkEncryptedDisksCompatibility(backupDisks, rotatedKey, []string{"encrypted"}, encryptedDiskModeReencrypt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"encrypted": true}, reencryptDisks)
+
+	_, err = checkEncryptedDisksCompatibility(backupDisks, onlyNewKey, []string{"encrypted"}, encryptedDiskModeReencrypt)
+	assert.ErrorContains(t, err, "key with sha256 fingerprint old from backup is not configured")
+
+	_, err = checkEncryptedDisksCompatibility(backupDisks, nil, []string{"encrypted"}, encryptedDiskModePreserve)
+	assert.ErrorContains(t, err, "current configuration doesn't contain `encrypted` disk")
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	apexLog "github.com/apex/log"
+)
+
+// ddlClauseKeywords - clauses which compared separately to explain what exactly differs, multi-word keywords shall go before single-word
+var ddlClauseKeywords = []string{
+	"ORDER BY", "PARTITION BY", "PRIMARY KEY", "SAMPLE BY",
+	"ENGINE", "TTL", "SETTINGS", "COMMENT", "CODEC", "DEFAULT", "MATERIALIZED", "ALIAS", "EPHEMERAL",
+}
+
+var (
+	ddlUUIDClauseRE  = regexp.MustCompile(`\s+UUID\s+'[^']+'`)
+	ddlOnClusterRE   = regexp.MustCompile(`\s+ON\s+CLUSTER\s+('[^']+'|` + "`[^`]+`" + `|[^\s(]+)`)
+	ddlUUIDLiteralRE = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	ddlHeaderRE      = regexp.MustCompile(`^(CREATE [A-Z ]*?(?:TABLE|VIEW|DICTIONARY)) (?:(?:` + "`[^`]+`" + `|[^\s.(]+)\.)?(?:` + "`[^`]+`" + `|[^\s(]+)`)
+	ddlKindRE        = regexp.MustCompile(`^CREATE [A-Z ]*?(?:TABLE|VIEW|DICTIONARY)`)
+	ddlSpacesRE      = regexp.MustCompile(`\s+`)
+)
+
+// normalizeCreateQuery - remove parts of CREATE query which differ between backup metadata and system.tables by design:
+// ATTACH for views, UUID, ON CLUSTER, IF NOT EXISTS, database and table name which could change by restore_database_mapping, {uuid} macro expanded for Replicated tables
+func normalizeCreateQuery(query string) string {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSuffix(query, ";")
+	if strings.HasPrefix(query, "ATTACH ") {
+		query = "CREATE " + strings.TrimPrefix(query, "ATTACH ")
+	}
+	query = ddlUUIDClauseRE.ReplaceAllString(query, "")
+	query = ddlOnClusterRE.ReplaceAllString(query, "")
+	query = strings.Replace(query, " IF NOT EXISTS ", " ", 1)
+	query = ddlSpacesRE.ReplaceAllString(query, " ")
+	query = ddlHeaderRE.ReplaceAllString(query, "$1")
+	query = ddlUUIDLiteralRE.ReplaceAllString(query, "{uuid}")
+	query = strings.ReplaceAll(query, "( ", "(")
+	query = strings.ReplaceAll(query, " )", ")")
+	return query
+}
+
+// ddlClauses - split normalized query into clauses by ddlClauseKeywords outside quotes,
+// column level clauses (depth 1) prefixed with column name, clause ends on next keyword, on comma between columns or when enclosing brackets close
+func ddlClauses(query string) map[string][]string {
+	clauses := make(map[string][]string)
+	depth := 0
+	var quote byte
+	keyword, keywordStart, keywordDepth, column := "", -1, 0, ""
+	// inColumns - inside the first top level brackets before any clause, which contain columns definition
+	inColumns, columnsDone, expectColumn := false, false, false
+	closeClause := func(end int) {
+		if keyword == "" {
+			return
+		}
+		clause := strings.TrimSpace(query[keywordStart:end])
+		if keywordDepth > 0 && column != "" {
+			clause = column + " " + clause
+		}
+		clauses[keyword] = append(clauses[keyword], clause)
+		keyword = ""
+	}
+	isBoundary := func(i int) bool {
+		if i < 0 || i >= len(query) {
+			return true
+		}
+		c := query[i]
+		return !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z')
+	}
+	// skip `CREATE MATERIALIZED VIEW`, MATERIALIZED is not a clause here
+	for i := len(ddlKindRE.FindString(query)); i < len(query); i++ {
+		c := query[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if expectColumn && c != ' ' {
+			expectColumn = false
+			end := i + 1
+			if c == '`' {
+				if closing := strings.IndexByte(query[i+1:], '`'); closing >= 0 {
+					end = i + closing + 2
+				}
+			} else {
+				for end < len(query) && !isBoundary(end) {
+					end++
+				}
+			}
+			column = query[i:end]
+			i = end - 1
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+			continue
+		case '(':
+			depth++
+			if depth == 1 && keyword == "" && !columnsDone {
+				inColumns, expectColumn = true, true
+			}
+			continue
+		case ')':
+			if keyword != "" && depth <= keywordDepth {
+				closeClause(i)
+			}
+			depth--
+			if depth == 0 && inColumns {
+				inColumns, columnsDone = false, true
+			}
+			continue
+		case ',':
+			if depth == 1 && inColumns {
+				if keywordDepth == 1 {
+					closeClause(i)
+				}
+				expectColumn = true
+			}
+			continue
+		}
+		if (depth > 1 || depth == 1 && !inColumns) || !isBoundary(i-1) {
+			continue
+		}
+		for _, k := range ddlClauseKeywords {
+			if strings.HasPrefix(query[i:], k) && isBoundary(i+len(k)) {
+				closeClause(i)
+				keyword, keywordStart, keywordDepth = k, i, depth
+				i += len(k) - 1
+				break
+			}
+		}
+	}
+	closeClause(len(query))
+	for k := range clauses {
+		sort.Strings(clauses[k])
+	}
+	return clauses
+}
+
+// compareCreateQueries - return human-readable differences between backup and restored CREATE queries, empty when queries are equivalent
+func compareCreateQueries(backupQuery, restoredQuery string) []string {
+	return diffCreateQueries(backupQuery, restoredQuery, "restored")
+}
+
+// diffCreateQueries - differences between backup CREATE query and query of the same table in ClickHouse, label names ClickHouse side in each difference
+func diffCreateQueries(backupQuery, query, label string) []string {
+	backupQuery, query = normalizeCreateQuery(backupQuery), normalizeCreateQuery(query)
+	if backupQuery == query {
+		return nil
+	}
+	backupClauses, clauses := ddlClauses(backupQuery), ddlClauses(query)
+	var differences []string
+	for _, k := range ddlClauseKeywords {
+		b, r := backupClauses[k], clauses[k]
+		if strings.Join(b, "\n") == strings.Join(r, "\n") {
+			continue
+		}
+		differences = append(differences, fmt.Sprintf("%s: backup [%s] %s [%s]", k, strings.Join(b, "; "), label, strings.Join(r, "; ")))
+	}
+	if len(differences) == 0 {
+		differences = append(differences, fmt.Sprintf("query: backup [%s] %s [%s]", backupQuery, label, query))
+	}
+	return differences
+}
+
+// checkRestoredSchemaFidelity - compare CREATE queries from backup metadata with system.tables after restore schema, report silent divergence as warnings
+func (b *Backuper) checkRestoredSchemaFidelity(ctx context.Context, tablesForRestore ListOfTables, log *apexLog.Entry) {
+	restoredQueries := make(map[string]map[string]string)
+	for _, t := range tablesForRestore {
+		if _, exists := restoredQueries[t.Database]; exists {
+			continue
+		}
+		var rows []struct {
+			Name             string `ch:"name"`
+			CreateTableQuery string `ch:"create_table_query"`
+		}
+		if err := b.ch.SelectContext(ctx, &rows, "SELECT name, create_table_query FROM system.tables WHERE database=?", t.Database); err != nil {
+			log.Warnf("can't check restored schema fidelity for database `%s`: %v", t.Database, err)
+			restoredQueries[t.Database] = nil
+			continue
+		}
+		restoredQueries[t.Database] = make(map[string]string, len(rows))
+		for _, row := range rows {
+			restoredQueries[t.Database][row.Name] = row.CreateTableQuery
+		}
+	}
+	for _, t := range tablesForRestore {
+		if restoredQueries[t.Database] == nil {
+			continue
+		}
+		restoredQuery, exists := restoredQueries[t.Database][t.Table]
+		if !exists || restoredQuery == "" || t.Query == "" {
+			continue
+		}
+		if differences := compareCreateQueries(t.Query, restoredQuery); len(differences) > 0 {
+			status.Current.AddWarning(ctx, log, status.WarningDDLDivergence, "restored `%s`.`%s` schema differs from backup, %s", t.Database, t.Table, strings.Join(differences, ", "))
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareCreateQueries(t *testing.T) {
+	backupQuery := "CREATE TABLE default.t1 UUID 'b6c3a6b4-0c29-4ac4-a5d2-7e6c6f54d3a1' (`id` UInt64 CODEC(Delta(8), ZSTD(1)), `d` Date DEFAULT today() COMMENT 'day', `s` String TTL d + toIntervalDay(1)) " +
+		"ENGINE = ReplicatedMergeTree('/clickhouse/tables/{uuid}/{shard}', '{replica}') ORDER BY id TTL d + toIntervalDay(30) SETTINGS index_granularity = 8192 COMMENT 'table'"
+	restoredQuery := "CREATE TABLE db2.t1 (`id` UInt64 CODEC(Delta(8), ZSTD(1)), `d` Date DEFAULT today() COMMENT 'day', `s` String TTL d + toIntervalDay(1))\n" +
+		"ENGINE = ReplicatedMergeTree('/clickhouse/tables/b6c3a6b4-0c29-4ac4-a5d2-7e6c6f54d3a1/{shard}', '{replica}') ORDER BY id TTL d + toIntervalDay(30) SETTINGS index_granularity = 8192 COMMENT 'table'"
+	assert.Empty(t, compareCreateQueries(backupQuery, restoredQuery))
+	assert.Empty(t, compareCreateQueries("ATTACH MATERIALIZED VIEW IF NOT EXISTS default.mv TO default.t1 (`id` UInt64) AS SELECT id FROM default.src", "CREATE MATERIALIZED VIEW default.mv TO default.t1 (`id` UInt64) AS SELECT id FROM default.src"))
+
+	diverged := "CREATE TABLE default.t1 (`id` UInt64 CODEC(ZSTD(1)), `d` Date COMMENT 'day', `s` String TTL d + toIntervalDay(1)) " +
+		"ENGINE = ReplicatedMergeTree('/clickhouse/tables/{uuid}/{shard}', '{replica}') ORDER BY id SETTINGS index_granularity = 8192"
+	differences := compareCreateQueries(backupQuery, diverged)
+	assert.Equal(t, []string{
+		"TTL: backup [TTL d + toIntervalDay(30); `s` TTL d + toIntervalDay(1)] restored [`s` TTL d + toIntervalDay(1)]",
+		"COMMENT: backup [COMMENT 'table'; `d` COMMENT 'day'] restored [`d` COMMENT 'day']",
+		"CODEC: backup [`id` CODEC(Delta(8), ZSTD(1))] restored [`id` CODEC(ZSTD(1))]",
+		"DEFAULT: backup [`d` DEFAULT today()] restored []",
+	}, differences)
+
+	differences = compareCreateQueries("CREATE TABLE default.t2 (`id` UInt64) ENGINE = ReplicatedMergeTree ORDER BY id", "CREATE TABLE default.t2 (`id` UInt64) ENGINE = MergeTree ORDER BY id")
+	assert.Equal(t, []string{"ENGINE: backup [ENGINE = ReplicatedMergeTree] restored [ENGINE = MergeTree]"}, differences)
+}
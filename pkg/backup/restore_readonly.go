@@ -0,0 +1,276 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage/object_disk"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+var readOnlyReplicatedEngineRE = regexp.MustCompile(`Replicated(\w*MergeTree)\(\s*'[^']*'\s*,\s*'[^']*'\s*(,\s*)?`)
+var readOnlyReplicatedEngineWithoutArgsRE = regexp.MustCompile(`Replicated(\w*MergeTree)`)
+var readOnlyStoragePolicyRE = regexp.MustCompile(`storage_policy\s*=\s*'[^']*'`)
+var readOnlySettingsRE = regexp.MustCompile(`\bSETTINGS\b`)
+
+// RestoreAttachReadOnly - create tables from backupName on clickhouse->attach_readonly_disk which points to s3->object_disk_path
+// and attach object disk data parts without copying objects, allow querying backup data without full restore
+func (b *Backuper) RestoreAttachReadOnly(backupName, tablePattern string, databaseMapping []string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startRestore := time.Now()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select backup for restore")
+	}
+	if err = b.prepareRestoreDatabaseMapping(databaseMapping); err != nil {
+		return err
+	}
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_attach_readonly",
+	})
+	if b.cfg.General.RemoteStorage != "s3" {
+		return fmt.Errorf("--attach-readonly supports only remote_storage: s3, current remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if b.cfg.ClickHouse.AttachReadOnlyDisk == "" {
+		return fmt.Errorf("--attach-readonly requires `clickhouse->attach_readonly_disk`, s3 disk from clickhouse-server configuration which endpoint points to s3->object_disk_path")
+	}
+	if err = config.ValidateObjectDiskConfig(b.cfg); err != nil {
+		return err
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	if err = b.checkAttachReadOnlyDisk(disks); err != nil {
+		return err
+	}
+	b.DefaultDataPath, err = b.ch.GetDefaultPath(disks)
+	if err != nil {
+		log.Warnf("%v", err)
+		return ErrUnknownClickhouseDataPath
+	}
+	backupMetadataBody, err := os.ReadFile(path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json"))
+	if err != nil {
+		return err
+	}
+	backupMetadata := metadata.BackupMetadata{}
+	if err = json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
+		return err
+	}
+	if backupMetadata.RequiredBackup != "" {
+		return fmt.Errorf("--attach-readonly doesn't support incremental backup %s, required backup %s", backupName, backupMetadata.RequiredBackup)
+	}
+	diskMap := make(map[string]string, len(disks))
+	diskTypes := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		diskMap[disk.Name] = disk.Path
+		diskTypes[disk.Name] = disk.Type
+	}
+	for diskName := range backupMetadata.DiskTypes {
+		if _, exists := diskTypes[diskName]; !exists {
+			diskTypes[diskName] = backupMetadata.DiskTypes[diskName]
+		}
+	}
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	tablesForRestore, _, err := b.getTablesForRestoreLocal(ctx, backupName, path.Join(b.DefaultDataPath, "backup", backupName, "metadata"), tablePattern, false, nil)
+	if err != nil {
+		return err
+	}
+	for _, table := range tablesForRestore {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		// need mapped database for new table and original table.Database for shadow path
+		originDatabase := table.Database
+		if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
+			table.Database = targetDB
+		}
+		if err = b.attachReadOnlyTable(ctx, backupName, table, originDatabase, diskMap, diskTypes, disks, version); err != nil {
+			return err
+		}
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(startRestore))).Info("done")
+	return nil
+}
+
+func (b *Backuper) attachReadOnlyTable(ctx context.Context, backupName string, table metadata.TableMetadata, originDatabase string, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, version int) error {
+	log := apexLog.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_attach_readonly",
+		"table":     fmt.Sprintf("%s.%s", table.Database, table.Table),
+	})
+	objectDiskName := ""
+	for diskName, parts := range table.Parts {
+		if len(parts) == 0 {
+			continue
+		}
+		if !b.isDiskTypeObject(diskTypes[diskName]) {
+			return fmt.Errorf("`%s`.`%s` contains data parts on non object disk %s, --attach-readonly supports only tables stored on one object disk", table.Database, table.Table, diskName)
+		}
+		if objectDiskName != "" && objectDiskName != diskName {
+			return fmt.Errorf("`%s`.`%s` contains data parts on multiple object disks %s and %s, --attach-readonly supports only tables stored on one object disk", table.Database, table.Table, objectDiskName, diskName)
+		}
+		objectDiskName = diskName
+	}
+	if objectDiskName == "" {
+		log.Warn("no data parts on object disk, skip")
+		return nil
+	}
+	if diskTypes[objectDiskName] != "s3" {
+		return fmt.Errorf("`%s`.`%s` stored on %s disk with type %s, --attach-readonly supports only s3 disks", table.Database, table.Table, objectDiskName, diskTypes[objectDiskName])
+	}
+	query, err := adjustQueryForReadOnlyDisk(table.Query, "'"+strings.ReplaceAll(b.cfg.ClickHouse.AttachReadOnlyDisk, "'", "\\'")+"'")
+	if err != nil {
+		return err
+	}
+	if err = b.ch.CreateDatabase(table.Database, ""); err != nil {
+		return err
+	}
+	dstTable := clickhouse.Table{Database: table.Database, Name: table.Table}
+	if err = b.ch.CreateTable(dstTable, query, false, false, "", version, b.DefaultDataPath); err != nil {
+		return fmt.Errorf("can't create readonly table `%s`.`%s`: %v", table.Database, table.Table, err)
+	}
+	chTables, err := b.ch.GetTables(ctx, fmt.Sprintf("%s.%s", table.Database, table.Table))
+	if err != nil {
+		return err
+	}
+	if len(chTables) != 1 || len(chTables[0].DataPaths) == 0 {
+		return fmt.Errorf("can't find data path for `%s`.`%s` in system.tables", table.Database, table.Table)
+	}
+	dstTable = chTables[0]
+	backupDiskPath, exists := diskMap[objectDiskName]
+	if !exists {
+		backupDiskPath = b.DefaultDataPath
+	}
+	dbAndTableDir := path.Join(common.TablePathEncode(originDatabase), common.TablePathEncode(table.Table))
+	detachedPath := path.Join(dstTable.DataPaths[0], "detached")
+	for _, part := range table.Parts[objectDiskName] {
+		srcPartPath := path.Join(backupDiskPath, "backup", backupName, "shadow", dbAndTableDir, objectDiskName, part.Name)
+		dstPartPath := path.Join(detachedPath, part.Name)
+		if err = copyReadOnlyObjectDiskPart(srcPartPath, dstPartPath, path.Join(backupName, objectDiskName)); err != nil {
+			return fmt.Errorf("can't prepare %s for `%s`.`%s`: %v", part.Name, table.Database, table.Table, err)
+		}
+		if err = filesystemhelper.Chown(dstPartPath, b.ch, disks, true); err != nil {
+			return err
+		}
+	}
+	table.Parts = map[string][]metadata.Part{objectDiskName: table.Parts[objectDiskName]}
+	restorePriorityDone := b.applyRestorePriority()
+	err = b.ch.AttachDataParts(ctx, table, dstTable, b.cfg.General.RestoreAttachPauseDuration)
+	restorePriorityDone()
+	if err != nil {
+		return fmt.Errorf("can't attach data parts for readonly table `%s`.`%s`: %v", table.Database, table.Table, err)
+	}
+	// merges would try to create new objects in backup, STOP MERGES doesn't survive clickhouse-server restart
+	if err = b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM STOP MERGES `%s`.`%s`", table.Database, table.Table)); err != nil {
+		return err
+	}
+	log.Warnf("merges stopped until clickhouse-server restart, execute SYSTEM STOP MERGES `%s`.`%s` after each restart", table.Database, table.Table)
+	log.WithField("disk", objectDiskName).WithField("parts", len(table.Parts[objectDiskName])).Info("attached readonly")
+	return nil
+}
+
+// checkAttachReadOnlyDisk - disk shall be defined in clickhouse-server configuration, so credentials never appear in table DDL
+func (b *Backuper) checkAttachReadOnlyDisk(disks []clickhouse.Disk) error {
+	for _, disk := range disks {
+		if disk.Name != b.cfg.ClickHouse.AttachReadOnlyDisk {
+			continue
+		}
+		if !b.isDiskTypeObject(disk.Type) {
+			return fmt.Errorf("`clickhouse->attach_readonly_disk` %s has type %s, shall be s3 disk which endpoint points to s3->object_disk_path", disk.Name, disk.Type)
+		}
+		return nil
+	}
+	return fmt.Errorf("`clickhouse->attach_readonly_disk` %s not found in system.disks", b.cfg.ClickHouse.AttachReadOnlyDisk)
+}
+
+// adjustQueryForReadOnlyDisk - remove UUID and replication, replace storage_policy to disk
+func adjustQueryForReadOnlyDisk(query, disk string) (string, error) {
+	if !strings.HasPrefix(query, "CREATE TABLE") && !strings.HasPrefix(query, "ATTACH TABLE") {
+		return "", fmt.Errorf("--attach-readonly supports only MergeTree tables, unexpected query: %s", query)
+	}
+	query = uuidRE.ReplaceAllString(query, "")
+	query = readOnlyReplicatedEngineRE.ReplaceAllString(query, "$1(")
+	query = readOnlyReplicatedEngineWithoutArgsRE.ReplaceAllString(query, "$1")
+	if !strings.Contains(query, "MergeTree") {
+		return "", fmt.Errorf("--attach-readonly supports only MergeTree tables, unexpected query: %s", query)
+	}
+	diskSetting := "disk = " + disk
+	if readOnlyStoragePolicyRE.MatchString(query) {
+		return readOnlyStoragePolicyRE.ReplaceAllLiteralString(query, diskSetting), nil
+	}
+	if loc := readOnlySettingsRE.FindStringIndex(query); loc != nil {
+		return query[:loc[1]] + " " + diskSetting + "," + query[loc[1]:], nil
+	}
+	return query + " SETTINGS " + diskSetting, nil
+}
+
+// copyReadOnlyObjectDiskPart - copy object disk metadata files and set ReadOnly flag, to avoid remove backup objects when table dropped,
+// objects uploaded to object_disk_path/backupName/diskName, so objectPrefix is added to relative paths for disk which points to object_disk_path
+func copyReadOnlyObjectDiskPart(srcPartPath, dstPartPath, objectPrefix string) error {
+	return filepath.Walk(srcPartPath, func(fPath string, fInfo fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcPartPath, fPath)
+		if err != nil {
+			return err
+		}
+		dstPath := path.Join(dstPartPath, relPath)
+		if fInfo.IsDir() {
+			return os.MkdirAll(dstPath, 0750)
+		}
+		// fix https://github.com/Altinity/clickhouse-backup/issues/826
+		if strings.Contains(fInfo.Name(), "frozen_metadata") {
+			return nil
+		}
+		objMeta, err := object_disk.ReadMetadataFromFile(fPath)
+		if err != nil {
+			return err
+		}
+		if objMeta.Version < object_disk.VersionRelativePath {
+			return fmt.Errorf("%s: object_disk.Metadata version %d contains absolute paths, can't attach readonly", fPath, objMeta.Version)
+		}
+		if objMeta.Version < object_disk.VersionReadOnlyFlag {
+			objMeta.Version = object_disk.VersionReadOnlyFlag
+		}
+		objMeta.ReadOnly = true
+		objMeta.RefCount = 0
+		for i := range objMeta.StorageObjects {
+			objMeta.StorageObjects[i].ObjectRelativePath = path.Join(objectPrefix, objMeta.StorageObjects[i].ObjectRelativePath)
+		}
+		return object_disk.WriteMetadataToFile(objMeta, dstPath)
+	})
+}
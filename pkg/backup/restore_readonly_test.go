@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage/object_disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdjustQueryForReadOnlyDisk(t *testing.T) {
+	disk := "disk(name = 'backup_test_s3', type = s3, endpoint = 'https://bucket.s3.us-east-1.amazonaws.com/test/s3/')"
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "CREATE TABLE db.t UUID 'a6ec1b3c-4f3d-4b1d-9c5e-1a2b3c4d5e6f' (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id SETTINGS storage_policy = 's3_only', index_granularity = 8192",
+			expected: "CREATE TABLE db.t  (`id` UInt64) ENGINE = MergeTree() ORDER BY id SETTINGS disk = " + disk + ", index_granularity = 8192",
+		},
+		{
+			query:    "CREATE TABLE db.t (`id` UInt64, `v` Int64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', v) ORDER BY id SETTINGS index_granularity = 8192",
+			expected: "CREATE TABLE db.t (`id` UInt64, `v` Int64) ENGINE = ReplacingMergeTree(v) ORDER BY id SETTINGS disk = " + disk + ", index_granularity = 8192",
+		},
+		{
+			query:    "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedMergeTree ORDER BY id",
+			expected: "CREATE TABLE db.t (`id` UInt64) ENGINE = MergeTree ORDER BY id SETTINGS disk = " + disk,
+		},
+	}
+	for _, tc := range testCases {
+		actual, err := adjustQueryForReadOnlyDisk(tc.query, disk)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, actual)
+	}
+	_, err := adjustQueryForReadOnlyDisk("CREATE VIEW db.v AS SELECT 1", disk)
+	assert.Error(t, err)
+}
+
+func TestCopyReadOnlyObjectDiskPartAddPrefix(t *testing.T) {
+	srcPartPath := path.Join(t.TempDir(), "all_1_1_0")
+	dstPartPath := path.Join(t.TempDir(), "detached", "all_1_1_0")
+	require.NoError(t, os.MkdirAll(srcPartPath, 0750))
+	srcMeta := &object_disk.Metadata{
+		Version:            object_disk.VersionRelativePath,
+		StorageObjectCount: 1,
+		TotalSize:          10,
+		StorageObjects:     []object_disk.StorageObject{{ObjectSize: 10, ObjectRelativePath: "abc/defghijk"}},
+		RefCount:           1,
+	}
+	require.NoError(t, object_disk.WriteMetadataToFile(srcMeta, path.Join(srcPartPath, "data.bin")))
+	require.NoError(t, copyReadOnlyObjectDiskPart(srcPartPath, dstPartPath, "backup1/s3"))
+	dstMeta, err := object_disk.ReadMetadataFromFile(path.Join(dstPartPath, "data.bin"))
+	require.NoError(t, err)
+	assert.True(t, dstMeta.ReadOnly)
+	assert.Equal(t, 0, dstMeta.RefCount)
+	assert.Equal(t, "backup1/s3/abc/defghijk", dstMeta.StorageObjects[0].ObjectRelativePath)
+}
@@ -3,7 +3,14 @@ package backup
 import "errors"
 
 func (b *Backuper) RestoreFromRemote(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropExists, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, resume bool, commandId int) error {
-	if err := b.Download(backupName, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
+	// restore_max_bytes_per_second caps download too, copy config to avoid change config shared with API server
+	if maxSpeed := b.cfg.General.RestoreMaxBytesPerSecond; maxSpeed > 0 && (b.cfg.General.DownloadMaxBytesPerSecond == 0 || b.cfg.General.DownloadMaxBytesPerSecond > maxSpeed) {
+		restoreCfg := *b.cfg
+		restoreCfg.General.DownloadMaxBytesPerSecond = maxSpeed
+		b.cfg = &restoreCfg
+	}
+	// keeper directory is downloaded with any backup, table data is not required
+	if err := b.Download(backupName, tablePattern, partitions, schemaOnly || b.restoreKeeperOnly, resume, commandId); err != nil {
 		// https://github.com/Altinity/clickhouse-backup/issues/625
 		if !errors.Is(err, ErrBackupIsAlreadyExists) {
 			return err
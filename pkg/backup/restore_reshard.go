@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+var reshardCreateTableNameRE = regexp.MustCompile("^CREATE TABLE (?:`[^`]+`|[^\\s.(`]+)\\.(?:`[^`]+`|[^\\s(`]+)(?:\\s+UUID '[^']+')?")
+
+// WithReshardCluster - `restore --reshard-cluster`, insert data of MergeTree tables through Distributed table on cluster instead of attach data parts
+func WithReshardCluster(cluster string) BackuperOpt {
+	return func(b *Backuper) {
+		b.reshardCluster = cluster
+	}
+}
+
+// parseDistributedEngineArgs - arguments of ENGINE = Distributed(cluster, database, table[, sharding_key[, policy_name]]), split by top level commas
+func parseDistributedEngineArgs(query string) []string {
+	start := strings.Index(query, "ENGINE = Distributed(")
+	if start < 0 {
+		return nil
+	}
+	start += len("ENGINE = Distributed(")
+	var args []string
+	depth, quote, argStart := 0, byte(0), start
+	for i := start; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '`' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(query[argStart:i]))
+			argStart = i + 1
+		case c == ')':
+			return append(args, strings.TrimSpace(query[argStart:i]))
+		}
+	}
+	return nil
+}
+
+// getReshardShardingKeys - sharding key for each local table from Distributed tables in backup, rand() used when Distributed table not found or doesn't have sharding key
+func getReshardShardingKeys(tables ListOfTables) map[metadata.TableTitle]string {
+	shardingKeys := map[metadata.TableTitle]string{}
+	for _, t := range tables {
+		args := parseDistributedEngineArgs(t.Query)
+		if len(args) < 3 {
+			continue
+		}
+		localTable := metadata.TableTitle{
+			Database: strings.Trim(args[1], "'`\""),
+			Table:    strings.Trim(args[2], "'`\""),
+		}
+		if localTable.Database == "" || strings.HasPrefix(localTable.Database, "currentDatabase(") {
+			localTable.Database = t.Database
+		}
+		if len(args) >= 4 && args[3] != "" {
+			shardingKeys[localTable] = args[3]
+		}
+	}
+	return shardingKeys
+}
+
+// getReshardStagingQuery - CREATE query for staging non-replicated table with the same structure, partition key and sorting key, to attach data parts from backup
+func getReshardStagingQuery(query, database, stagingTable string) (string, error) {
+	if !reshardCreateTableNameRE.MatchString(query) {
+		return "", fmt.Errorf("can't find table name in %s", query)
+	}
+	query = convertReplicatedTableQuery(query)
+	return reshardCreateTableNameRE.ReplaceAllLiteralString(query, fmt.Sprintf("CREATE TABLE `%s`.`%s`", database, stagingTable)), nil
+}
+
+func isReshardSupported(table metadata.TableMetadata) bool {
+	return strings.HasPrefix(table.Query, "CREATE TABLE") && strings.Contains(table.Query, "MergeTree") && len(table.Parts) > 0
+}
+
+// restoreDataResharding - attach data parts to staging table, then INSERT SELECT through temporary Distributed table on reshard cluster, sharding key from Distributed table in backup
+func (b *Backuper) restoreDataResharding(ctx context.Context, backupName string, backupMetadata metadata.BackupMetadata, table metadata.TableMetadata, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, dstTable clickhouse.Table, shardingKey string, log *apexLog.Entry) error {
+	start := time.Now()
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	stagingName := "_reshard_" + dstTable.Name
+	distributedName := "_reshard_distributed_" + dstTable.Name
+	dropQuery := "DROP TABLE IF EXISTS `%s`.`%s` SYNC"
+	for _, name := range []string{distributedName, stagingName} {
+		if err = b.ch.QueryContext(ctx, fmt.Sprintf(dropQuery, dstTable.Database, name)); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		for _, name := range []string{distributedName, stagingName} {
+			if dropErr := b.ch.QueryContext(context.Background(), fmt.Sprintf(dropQuery, dstTable.Database, name)); dropErr != nil {
+				log.Warnf("can't drop `%s`.`%s`: %v", dstTable.Database, name, dropErr)
+			}
+		}
+	}()
+	stagingQuery, err := getReshardStagingQuery(table.Query, dstTable.Database, stagingName)
+	if err != nil {
+		return err
+	}
+	if err = b.ch.CreateTable(clickhouse.Table{Database: dstTable.Database, Name: stagingName}, stagingQuery, false, false, "", version, b.DefaultDataPath); err != nil {
+		return fmt.Errorf("can't create staging table `%s`.`%s`: %v", dstTable.Database, stagingName, err)
+	}
+	stagingTables, err := b.ch.GetTables(ctx, fmt.Sprintf("%s.%s", dstTable.Database, stagingName))
+	if err != nil {
+		return err
+	}
+	var stagingTable clickhouse.Table
+	for _, t := range stagingTables {
+		if t.Database == dstTable.Database && t.Name == stagingName {
+			stagingTable = t
+		}
+	}
+	if stagingTable.Name == "" {
+		return fmt.Errorf("can't find staging table `%s`.`%s` in system.tables", dstTable.Database, stagingName)
+	}
+	if err = filesystemhelper.HardlinkBackupPartsToStorage(backupName, table, disks, diskMap, stagingTable.DataPaths, b.ch, true); err != nil {
+		return fmt.Errorf("can't copy data to detached '%s.%s': %v", dstTable.Database, stagingName, err)
+	}
+	if err = b.downloadObjectDiskParts(ctx, backupName, backupMetadata, table, diskMap, diskTypes, disks); err != nil {
+		return fmt.Errorf("can't restore object_disk server-side copy data parts '%s.%s': %v", table.Database, table.Table, err)
+	}
+	stagingMetadata := table
+	stagingMetadata.Database = dstTable.Database
+	stagingMetadata.Table = stagingName
+	restorePriorityDone := b.applyRestorePriority()
+	err = b.ch.AttachDataParts(ctx, stagingMetadata, stagingTable, b.cfg.General.RestoreAttachPauseDuration)
+	restorePriorityDone()
+	if err != nil {
+		return fmt.Errorf("can't attach data parts for staging table '%s.%s': %v", dstTable.Database, stagingName, err)
+	}
+	distributedQuery := fmt.Sprintf(
+		"CREATE TABLE `%s`.`%s` AS `%s`.`%s` ENGINE = Distributed('%s', '%s', '%s', %s)",
+		dstTable.Database, distributedName, dstTable.Database, stagingName, b.reshardCluster, dstTable.Database, dstTable.Name, shardingKey,
+	)
+	if err = b.ch.QueryContext(ctx, distributedQuery); err != nil {
+		return fmt.Errorf("can't create distributed table for reshard: %v", err)
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO `%s`.`%s` SELECT * FROM `%s`.`%s` SETTINGS insert_distributed_sync=1", dstTable.Database, distributedName, dstTable.Database, stagingName)
+	if err = b.ch.QueryContext(ctx, insertQuery); err != nil {
+		return fmt.Errorf("can't insert data through distributed table on cluster %s: %v", b.reshardCluster, err)
+	}
+	log.WithFields(apexLog.Fields{
+		"cluster":      b.reshardCluster,
+		"sharding_key": shardingKey,
+		"duration":     utils.HumanizeDuration(time.Since(start)),
+	}).Info("resharded")
+	return nil
+}
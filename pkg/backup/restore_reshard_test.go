@@ -0,0 +1,38 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDistributedEngineArgs(t *testing.T) {
+	assert.Equal(t, []string{"'cluster'", "'db'", "'t'", "cityHash64(id, 'a,b')"}, parseDistributedEngineArgs("CREATE TABLE db.t_distr (id UInt64) ENGINE = Distributed('cluster', 'db', 't', cityHash64(id, 'a,b'))"))
+	assert.Equal(t, []string{"'cluster'", "currentDatabase()", "'t'"}, parseDistributedEngineArgs("CREATE TABLE db.t_distr (id UInt64) ENGINE = Distributed('cluster', currentDatabase(), 't')"))
+	assert.Nil(t, parseDistributedEngineArgs("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"))
+}
+
+func TestGetReshardShardingKeys(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "t_distr", Query: "CREATE TABLE db.t_distr (id UInt64) ENGINE = Distributed('cluster', 'db', 't', cityHash64(id), 'policy')"},
+		{Database: "db", Table: "t2_distr", Query: "CREATE TABLE db.t2_distr (id UInt64) ENGINE = Distributed('cluster', currentDatabase(), 't2')"},
+		{Database: "db", Table: "t3_distr", Query: "CREATE TABLE db.t3_distr (id UInt64) ENGINE = Distributed('cluster', '', 't3', id)"},
+		{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	assert.Equal(t, map[metadata.TableTitle]string{
+		{Database: "db", Table: "t"}:  "cityHash64(id)",
+		{Database: "db", Table: "t3"}: "id",
+	}, getReshardShardingKeys(tables))
+}
+
+func TestGetReshardStagingQuery(t *testing.T) {
+	query, err := getReshardStagingQuery("CREATE TABLE db.t UUID '7a4c5b1e-1c4d-4b8a-9f2a-3f4b2d1e0c9a' (id UInt64, d Date) ENGINE = ReplicatedMergeTree('/clickhouse/{shard}/db/t', '{replica}') PARTITION BY toYYYYMM(d) ORDER BY id", "db2", "_reshard_t")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE `db2`.`_reshard_t` (id UInt64, d Date) ENGINE = MergeTree() PARTITION BY toYYYYMM(d) ORDER BY id", query)
+	query, err = getReshardStagingQuery("CREATE TABLE `db`.`t 1` (id UInt64) ENGINE = MergeTree ORDER BY id", "db", "_reshard_t 1")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE `db`.`_reshard_t 1` (id UInt64) ENGINE = MergeTree ORDER BY id", query)
+	_, err = getReshardStagingQuery("CREATE VIEW db.v AS SELECT 1", "db", "_reshard_v")
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"text/template"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+)
+
+// applyRestoreSchemaRewriteRules - apply general->restore_schema_rewrite_rules to CREATE query of database.table one by one, return names of applied rules
+func applyRestoreSchemaRewriteRules(rules []config.RestoreSchemaRewriteRule, database, table, query string) (string, []string, error) {
+	var appliedRules []string
+	tableName := fmt.Sprintf("%s.%s", database, table)
+	for i, rule := range rules {
+		if len(rule.Tables) > 0 {
+			isMatched := false
+			for _, pattern := range rule.Tables {
+				if matched, _ := filepath.Match(pattern, tableName); matched {
+					isMatched = true
+					break
+				}
+			}
+			if !isMatched {
+				continue
+			}
+		}
+		matchRE, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return "", nil, fmt.Errorf("restore_schema_rewrite_rules[%d] invalid match regexp: %v", i, err)
+		}
+		if !matchRE.MatchString(query) {
+			continue
+		}
+		replaceTemplate, err := template.New("replace").Parse(rule.Replace)
+		if err != nil {
+			return "", nil, fmt.Errorf("restore_schema_rewrite_rules[%d] invalid replace template: %v", i, err)
+		}
+		replace := &bytes.Buffer{}
+		if err = replaceTemplate.Execute(replace, struct {
+			Database string
+			Table    string
+		}{database, table}); err != nil {
+			return "", nil, fmt.Errorf("restore_schema_rewrite_rules[%d] replace template for %s return error: %v", i, tableName, err)
+		}
+		query = matchRE.ReplaceAllString(query, replace.String())
+		appliedRules = append(appliedRules, fmt.Sprintf("restore_schema_rewrite_rules[%d]", i))
+	}
+	return query, appliedRules, nil
+}
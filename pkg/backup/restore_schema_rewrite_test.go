@@ -0,0 +1,35 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyRestoreSchemaRewriteRules(t *testing.T) {
+	rules := []config.RestoreSchemaRewriteRule{
+		{Match: "/clickhouse/prod/", Replace: "/clickhouse/staging/"},
+		{Match: "storage_policy = 'hot_and_cold'", Replace: "storage_policy = 'default'"},
+		{Tables: []string{"logs.*"}, Match: " TTL .+? SETTINGS ", Replace: " SETTINGS "},
+		{Tables: []string{"analytics.*"}, Match: `Replicated(\w*MergeTree)\('[^']*',\s*'[^']*'(,\s*)?`, Replace: "${1}("},
+		{Tables: []string{"analytics.events"}, Match: "COMMENT '[^']*'", Replace: "COMMENT 'restored {{.Database}}.{{.Table}}'"},
+	}
+	query, applied, err := applyRestoreSchemaRewriteRules(rules, "logs", "requests", "CREATE TABLE logs.requests (d Date) ENGINE = ReplicatedMergeTree('/clickhouse/prod/{shard}/logs/requests', '{replica}') ORDER BY d TTL d + toIntervalDay(7) SETTINGS storage_policy = 'hot_and_cold', index_granularity = 8192")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE logs.requests (d Date) ENGINE = ReplicatedMergeTree('/clickhouse/staging/{shard}/logs/requests', '{replica}') ORDER BY d SETTINGS storage_policy = 'default', index_granularity = 8192", query)
+	assert.Equal(t, []string{"restore_schema_rewrite_rules[0]", "restore_schema_rewrite_rules[1]", "restore_schema_rewrite_rules[2]"}, applied)
+
+	query, applied, err = applyRestoreSchemaRewriteRules(rules, "analytics", "events", "CREATE TABLE analytics.events (id UInt64, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/prod/{shard}/events', '{replica}', v) ORDER BY id COMMENT 'events'")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE analytics.events (id UInt64, v UInt64) ENGINE = ReplacingMergeTree(v) ORDER BY id COMMENT 'restored analytics.events'", query)
+	assert.Equal(t, []string{"restore_schema_rewrite_rules[0]", "restore_schema_rewrite_rules[3]", "restore_schema_rewrite_rules[4]"}, applied)
+
+	query, applied, err = applyRestoreSchemaRewriteRules(rules, "default", "t", "CREATE TABLE default.t (id UInt64) ENGINE = MergeTree ORDER BY id")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE default.t (id UInt64) ENGINE = MergeTree ORDER BY id", query)
+	assert.Empty(t, applied)
+
+	_, _, err = applyRestoreSchemaRewriteRules([]config.RestoreSchemaRewriteRule{{Match: "("}}, "default", "t", "CREATE TABLE default.t")
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// WithSyncReplicas - `restore --sync-replicas`, after data restore run SYSTEM RESTORE REPLICA and SYSTEM SYNC REPLICA on other replicas of the same shard in cluster
+func WithSyncReplicas(cluster string) BackuperOpt {
+	return func(b *Backuper) {
+		b.syncReplicasCluster = cluster
+	}
+}
+
+// syncReplicaHost - other replica of current shard from system.clusters
+type syncReplicaHost struct {
+	HostName string `ch:"host_name"`
+	Port     uint16 `ch:"port"`
+}
+
+// syncReplicaState - state of restored table on other replica before sync
+type syncReplicaState struct {
+	IsReadonly uint8  `ch:"is_readonly"`
+	QueueSize  uint32 `ch:"queue_size"`
+}
+
+// getSyncReplicasTables - restored Replicated*MergeTree tables, sorted to sync them in the same order on each replica
+func getSyncReplicasTables(tables ListOfTables) []metadata.TableTitle {
+	var result []metadata.TableTitle
+	for _, t := range tables {
+		if replicatedEngineWithoutArgsRE.MatchString(t.Query) {
+			result = append(result, metadata.TableTitle{Database: t.Database, Table: t.Table})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Database != result[j].Database {
+			return result[i].Database < result[j].Database
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
+
+// syncRestoredReplicas - connect to each other replica of current shard with the same credentials, restore lost replica metadata in Keeper with SYSTEM RESTORE REPLICA for readonly tables
+// and wait SYSTEM SYNC REPLICA, so other replicas fetch restored data parts without manual actions
+func (b *Backuper) syncRestoredReplicas(ctx context.Context, tablesForRestore ListOfTables, log *apexLog.Entry) error {
+	tables := getSyncReplicasTables(tablesForRestore)
+	if len(tables) == 0 {
+		log.Warnf("--sync-replicas=%s ignored, no Replicated*MergeTree tables restored", b.syncReplicasCluster)
+		return nil
+	}
+	cluster, err := b.ch.ApplyMacros(ctx, b.syncReplicasCluster)
+	if err != nil {
+		return err
+	}
+	var hosts []syncReplicaHost
+	query := "SELECT host_name, port FROM system.clusters WHERE cluster=? AND is_local=0 AND shard_num IN (SELECT shard_num FROM system.clusters WHERE cluster=? AND is_local=1)"
+	if err = b.ch.SelectContext(ctx, &hosts, query, cluster, cluster); err != nil {
+		return fmt.Errorf("can't get replicas of cluster %s from system.clusters: %v", cluster, err)
+	}
+	if len(hosts) == 0 {
+		log.Warnf("--sync-replicas=%s ignored, no other replicas of current shard found in system.clusters", cluster)
+		return nil
+	}
+	startSync := time.Now()
+	syncGroup, syncCtx := errgroup.WithContext(ctx)
+	for _, host := range hosts {
+		replicaLog := log.WithField("replica", fmt.Sprintf("%s:%d", host.HostName, host.Port))
+		syncGroup.Go(func() error {
+			replicaCfg := *b.cfg
+			replicaCfg.ClickHouse.Host = host.HostName
+			replicaCfg.ClickHouse.Port = uint(host.Port)
+			replicaCh := &clickhouse.ClickHouse{
+				Config: &replicaCfg.ClickHouse,
+				Log:    replicaLog,
+			}
+			if err := replicaCh.Connect(); err != nil {
+				return fmt.Errorf("can't connect to replica %s:%d: %v", host.HostName, host.Port, err)
+			}
+			defer replicaCh.Close()
+			for i, table := range tables {
+				if err := b.syncReplicaTable(syncCtx, replicaCh, table, replicaLog.WithField("progress", fmt.Sprintf("%d/%d", i+1, len(tables)))); err != nil {
+					return fmt.Errorf("replica %s:%d: %v", host.HostName, host.Port, err)
+				}
+			}
+			return nil
+		})
+	}
+	if err = syncGroup.Wait(); err != nil {
+		return err
+	}
+	log.WithFields(apexLog.Fields{
+		"cluster":  cluster,
+		"replicas": len(hosts),
+		"tables":   len(tables),
+		"duration": utils.HumanizeDuration(time.Since(startSync)),
+	}).Info("replicas synced")
+	return nil
+}
+
+// syncReplicaTable - SYSTEM RESTORE REPLICA when table is readonly cause metadata in Keeper is lost, then SYSTEM SYNC REPLICA to wait until restored parts fetched
+func (b *Backuper) syncReplicaTable(ctx context.Context, replicaCh *clickhouse.ClickHouse, table metadata.TableTitle, log *apexLog.Entry) error {
+	start := time.Now()
+	log = log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table))
+	var states []syncReplicaState
+	if err := replicaCh.SelectContext(ctx, &states, "SELECT is_readonly, queue_size FROM system.replicas WHERE database=? AND table=?", table.Database, table.Table); err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		return fmt.Errorf("`%s`.`%s` is not a replicated table, restore schema on all replicas first or use restore_schema_on_cluster", table.Database, table.Table)
+	}
+	if states[0].IsReadonly > 0 {
+		log.Info("readonly, restore replica metadata")
+		if err := replicaCh.QueryContext(ctx, fmt.Sprintf("SYSTEM RESTORE REPLICA `%s`.`%s`", table.Database, table.Table)); err != nil {
+			return err
+		}
+	}
+	log.Debugf("sync replica, queue_size=%d", states[0].QueueSize)
+	if err := replicaCh.QueryContext(ctx, fmt.Sprintf("SYSTEM SYNC REPLICA `%s`.`%s`", table.Database, table.Table)); err != nil {
+		return err
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Info("synced")
+	return nil
+}
@@ -0,0 +1,22 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSyncReplicasTables(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db2", Table: "t", Query: "CREATE TABLE db2.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}') ORDER BY id"},
+		{Database: "db1", Table: "local", Query: "CREATE TABLE db1.local (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db1", Table: "agg", Query: "CREATE TABLE db1.agg (id UInt64) ENGINE = ReplicatedAggregatingMergeTree ORDER BY id"},
+		{Database: "db1", Table: "dist", Query: "CREATE TABLE db1.dist (id UInt64) ENGINE = Distributed('cluster', 'db1', 'agg', rand())"},
+	}
+	assert.Equal(t, []metadata.TableTitle{
+		{Database: "db1", Table: "agg"},
+		{Database: "db2", Table: "t"},
+	}, getSyncReplicasTables(tables))
+	assert.Empty(t, getSyncReplicasTables(ListOfTables{tables[1]}))
+}
@@ -0,0 +1,213 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// parseRestoreTableName - `db.table` without wildcards, database name can't contain dot
+func parseRestoreTableName(name string) (metadata.TableTitle, error) {
+	database, table, found := strings.Cut(strings.Trim(name, " \t\r\n"), ".")
+	if !found || database == "" || table == "" || strings.ContainsAny(database+table, "*?,") {
+		return metadata.TableTitle{}, fmt.Errorf("'%s' shall be in db.table format without wildcards", name)
+	}
+	return metadata.TableTitle{Database: database, Table: table}, nil
+}
+
+// RestoreTable - `restore-table` command, download only one table from remote backup when backup is not present locally,
+// create it with other database or table name when `into` defined and attach its data parts, other tables, databases, RBAC and configs are not touched
+func (b *Backuper) RestoreTable(tableName, backupName, into string, dropExists bool, commandId int) (err error) {
+	source, err := parseRestoreTableName(tableName)
+	if err != nil {
+		return err
+	}
+	target := source
+	if into != "" {
+		if target, err = parseRestoreTableName(into); err != nil {
+			return err
+		}
+	}
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select backup for restore-table with --backup")
+	}
+	tablePattern := fmt.Sprintf("%s.%s", source.Database, source.Table)
+	if b.cfg.General.RemoteStorage != "none" {
+		if err = b.Download(backupName, tablePattern, nil, false, false, commandId); err != nil && !errors.Is(err, ErrBackupIsAlreadyExists) {
+			return err
+		}
+	}
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startRestore := time.Now()
+	b.setLogComment("restore_table", backupName, commandId)
+	defer func() {
+		b.writeAuditRecord(ctx, "restore_table", []string{backupName}, err)
+	}()
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_table",
+		"table":     tablePattern,
+		"into":      fmt.Sprintf("%s.%s", target.Database, target.Table),
+	})
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if b.DefaultDataPath, err = b.ch.GetDefaultPath(disks); err != nil {
+		log.Warnf("%v", err)
+		return ErrUnknownClickhouseDataPath
+	}
+	if b.cfg.General.RestoreSchemaOnCluster != "" {
+		if b.cfg.General.RestoreSchemaOnCluster, err = b.ch.ApplyMacros(ctx, b.cfg.General.RestoreSchemaOnCluster); err != nil {
+			return err
+		}
+	}
+	backupMetadataBody, err := os.ReadFile(path.Join(b.DefaultDataPath, "backup", backupName, "metadata.json"))
+	if err != nil {
+		return err
+	}
+	backupMetadata := metadata.BackupMetadata{}
+	if err = json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
+		return err
+	}
+	if strings.Contains(backupMetadata.Tags, "embedded") {
+		return fmt.Errorf("'%s' is embedded backup, use `restore --tables=%s` instead", backupName, tablePattern)
+	}
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	tables, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, nil)
+	if err != nil {
+		return err
+	}
+	if len(tables) != 1 {
+		return fmt.Errorf("%s is not found in local backup '%s', delete partially downloaded backup and try again", tablePattern, backupName)
+	}
+	table := tables[0]
+	if b.convertReplicated {
+		convertReplicatedTables(tables)
+		table = tables[0]
+	}
+
+	targetTables := ListOfTables{table}
+	if target.Database != source.Database {
+		if err = changeTableQueryToAdjustDatabaseMapping(&targetTables, map[string]string{source.Database: target.Database}); err != nil {
+			return err
+		}
+	}
+	if err = changeTableQueryToAdjustTableName(&targetTables[0], target.Table); err != nil {
+		return err
+	}
+	targetTable := targetTables[0]
+	for _, database := range backupMetadata.Databases {
+		if database.Name == source.Database && database.Query != "" {
+			if b.convertReplicated {
+				database.Query = convertReplicatedDatabaseQuery(database.Query)
+			}
+			substitution := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS ${1}`%s`${3}", target.Database)
+			if err = b.ch.CreateDatabaseFromQuery(ctx, CreateDatabaseRE.ReplaceAllString(database.Query, substitution), b.cfg.General.RestoreSchemaOnCluster); err != nil {
+				return err
+			}
+		}
+	}
+	if dropExists {
+		if err = b.dropExistsTables(ListOfTables{targetTable}, false, version, log); err != nil {
+			return err
+		}
+	}
+	if err = b.restoreSchemaRegular(ListOfTables{targetTable}, version, log); err != nil {
+		return err
+	}
+	if len(table.Parts) == 0 {
+		log.WithField("duration", utils.HumanizeDuration(time.Since(startRestore))).Info("done, table doesn't contain data parts")
+		return nil
+	}
+
+	if b.cfg.General.RemoteStorage != "custom" && b.hasObjectDisksLocal([]LocalBackup{{BackupMetadata: backupMetadata}}, backupName, disks) {
+		if b.dst, err = b.newBackupDestination(ctx, false, backupName); err != nil {
+			return err
+		}
+		if err = b.dst.Connect(ctx); err != nil {
+			return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+		}
+		defer func() {
+			if err := b.dst.Close(ctx); err != nil {
+				b.log.Warnf("can't close BackupDestination error: %v", err)
+			}
+		}()
+	}
+	diskMap := make(map[string]string, len(disks))
+	diskTypes := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		diskMap[disk.Name] = disk.Path
+		diskTypes[disk.Name] = disk.Type
+	}
+	for diskName := range backupMetadata.DiskTypes {
+		if _, exists := diskTypes[diskName]; !exists {
+			diskTypes[diskName] = backupMetadata.DiskTypes[diskName]
+		}
+	}
+	if b.cfg.General.IntegrityManifest {
+		verified, verifyErr := b.verifyTablesChecksums(ctx, backupName, ListOfTables{table}, diskMap, 1, log)
+		if verifyErr != nil {
+			return fmt.Errorf("backup integrity check failed: %v", verifyErr)
+		}
+		log.Infof("verified checksums of %d files", verified)
+	}
+	chTables, err := b.ch.GetTables(ctx, fmt.Sprintf("%s.%s", target.Database, target.Table))
+	if err != nil {
+		return err
+	}
+	dstTable, exists := b.prepareDstTablesMap(chTables)[target]
+	if !exists {
+		return fmt.Errorf("can't find '%s.%s' in current system.tables", target.Database, target.Table)
+	}
+	// data parts are hardlinked from source table path in backup, attach queries use dstTable name and replication path from target query
+	dataTable := table
+	dataTable.Query = targetTable.Query
+	if b.cfg.ClickHouse.RestoreAsAttach {
+		err = b.restoreDataRegularByAttach(ctx, backupName, backupMetadata, dataTable, diskMap, diskTypes, disks, dstTable, log)
+	} else {
+		err = b.restoreDataRegularByParts(ctx, backupName, backupMetadata, dataTable, diskMap, diskTypes, disks, dstTable, log)
+	}
+	if err != nil {
+		return err
+	}
+	for _, mutation := range table.Mutations {
+		if mutationErr := b.ch.ApplyMutation(ctx, targetTable, mutation); mutationErr != nil {
+			log.Warnf("can't apply mutation %s for table `%s`.`%s`: %v", mutation.Command, target.Database, target.Table, mutationErr)
+		}
+	}
+	if b.syncReplicasCluster != "" {
+		if err = b.syncRestoredReplicas(ctx, ListOfTables{targetTable}, log); err != nil {
+			return fmt.Errorf("can't sync other replicas after restore: %v", err)
+		}
+	}
+	log.WithFields(apexLog.Fields{
+		"duration": utils.HumanizeDuration(time.Since(startRestore)),
+		"size":     utils.FormatBytes(table.TotalBytes),
+	}).Info("done")
+	return nil
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRestoreTableName(t *testing.T) {
+	title, err := parseRestoreTableName("db.table.with.dots")
+	require.NoError(t, err)
+	assert.Equal(t, metadata.TableTitle{Database: "db", Table: "table.with.dots"}, title)
+	for _, name := range []string{"table", "db.", ".table", "db.*", "db.t1,db.t2"} {
+		_, err = parseRestoreTableName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestChangeTableQueryToAdjustTableName(t *testing.T) {
+	table := metadata.TableMetadata{
+		Database: "db",
+		Table:    "t1",
+		Query:    "CREATE TABLE db.t1 UUID 'c7f1d3a0-2a4b-4a5e-9a0e-3f1d1c1b1a10' (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t1', '{replica}') ORDER BY id",
+	}
+	require.NoError(t, changeTableQueryToAdjustTableName(&table, "t1_restored"))
+	assert.Equal(t, "t1_restored", table.Table)
+	assert.Contains(t, table.Query, "CREATE TABLE db.t1_restored UUID '")
+	assert.NotContains(t, table.Query, "c7f1d3a0-2a4b-4a5e-9a0e-3f1d1c1b1a10")
+	assert.Contains(t, table.Query, "ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t1_restored', '{replica}')")
+
+	table = metadata.TableMetadata{
+		Database: "db",
+		Table:    "t1",
+		Query:    "CREATE TABLE db.t1 (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t10', '{replica}') ORDER BY id",
+	}
+	require.NoError(t, changeTableQueryToAdjustTableName(&table, "my-table"))
+	assert.Equal(t, "CREATE TABLE db.`my-table` (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t10', '{replica}') ORDER BY id", table.Query)
+
+	table = metadata.TableMetadata{Database: "db", Table: "t1", Query: "CREATE TABLE db.t2 (id UInt64) ENGINE = MergeTree ORDER BY id"}
+	assert.ErrorContains(t, changeTableQueryToAdjustTableName(&table, "t3"), "invalid SQL")
+}
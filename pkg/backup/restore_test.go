@@ -83,3 +83,23 @@ func TestDetectRBACObject(t *testing.T) {
 		}
 	}
 }
+
+func TestGetRestoreDataWaves(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "events", Query: "CREATE TABLE db.events (id UInt64) ENGINE = MergeTree ORDER BY id", TotalBytes: 100},
+		{Database: "db", Table: "big", Query: "CREATE TABLE db.big (id UInt64) ENGINE = MergeTree ORDER BY id", TotalBytes: 1000},
+		{Database: "db", Table: "events_agg", Query: "CREATE TABLE db.events_agg (id UInt64) ENGINE = SummingMergeTree ORDER BY id", TotalBytes: 10},
+		{Database: "db", Table: "events_mv", Query: "CREATE MATERIALIZED VIEW db.events_mv TO db.events_agg AS SELECT id FROM db.events"},
+		{Database: "db", Table: ".inner_id.1234", Query: "CREATE TABLE db.`.inner_id.1234` (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "inner_mv", Query: "CREATE MATERIALIZED VIEW db.inner_mv UUID '1234' ENGINE = MergeTree ORDER BY id AS SELECT id FROM `db`.`big`"},
+		{Database: "db", Table: "dict", Query: "CREATE DICTIONARY db.dict (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE 'events' DB 'db')) LIFETIME(0) LAYOUT(FLAT())"},
+		{Database: "db", Table: "events_distr", Query: "CREATE TABLE db.events_distr (id UInt64) ENGINE = Distributed('cluster', 'db', 'events', rand())"},
+		{Database: "db", Table: "a", Query: "CREATE VIEW db.a AS SELECT * FROM db.b"},
+		{Database: "db", Table: "b", Query: "CREATE VIEW db.b AS SELECT * FROM db.a"},
+	}
+	dependencies := getRestoreDataDependencies(tables)
+	assert.Equal(t, [][]int{nil, nil, nil, {0, 2}, nil, {1, 4}, {0}, {0}, {9}, {8}}, dependencies)
+	waves, err := getRestoreDataWaves(tables, dependencies)
+	assert.Error(t, err)
+	assert.Equal(t, [][]int{{1, 0, 2, 4}, {3, 5, 6, 7}, {8, 9}}, waves)
+}
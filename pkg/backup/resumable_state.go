@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/resumable"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+)
+
+// ExportResumableState - write upload or download resumable state as JSON into outputFile or stdout, allow continue operation on other host via ImportResumableState
+func (b *Backuper) ExportResumableState(backupName, command, outputFile string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err = b.initDisksPaths(ctx, nil); err != nil {
+		return err
+	}
+	if command == "" {
+		commands, err := resumable.ListStateCommands(b.DefaultDataPath, backupName)
+		if err != nil {
+			return err
+		}
+		if len(commands) != 1 {
+			return fmt.Errorf("found %d resumable states [%s] for %s, use --command to choose one", len(commands), strings.Join(commands, ", "), backupName)
+		}
+		command = commands[0]
+	}
+	exported, err := resumable.Export(b.DefaultDataPath, backupName, command)
+	if err != nil {
+		return fmt.Errorf("can't export %s resumable state for %s: %v", command, backupName, err)
+	}
+	exportedBody, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return err
+	}
+	if outputFile == "" || outputFile == "-" {
+		_, err = fmt.Fprintln(os.Stdout, string(exportedBody))
+		return err
+	}
+	if err = os.WriteFile(outputFile, exportedBody, 0640); err != nil {
+		return err
+	}
+	b.log.WithField("backup", backupName).WithField("command", command).Infof("%d processed items exported to %s", len(exported.Processed), outputFile)
+	return nil
+}
+
+// ImportResumableState - read JSON from ExportResumableState and write resumable state for local backup, next upload or download with --resume will skip processed items
+func (b *Backuper) ImportResumableState(inputFile string, force bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	var importedBody []byte
+	if inputFile == "" || inputFile == "-" {
+		importedBody, err = io.ReadAll(os.Stdin)
+	} else {
+		importedBody, err = os.ReadFile(inputFile)
+	}
+	if err != nil {
+		return err
+	}
+	exported := resumable.ExportedState{}
+	if err = json.Unmarshal(importedBody, &exported); err != nil {
+		return fmt.Errorf("can't parse resumable state: %v", err)
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err = b.initDisksPaths(ctx, nil); err != nil {
+		return err
+	}
+	release, err := b.lockOperation("import_state", exported.BackupName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	stateFile, err := resumable.Import(b.DefaultDataPath, &exported, force)
+	if err != nil {
+		return err
+	}
+	b.log.WithField("backup", exported.BackupName).WithField("command", exported.Command).WithField("source_host", exported.Hostname).Infof("%d processed items imported to %s", len(exported.Processed), stateFile)
+	return nil
+}
@@ -0,0 +1,427 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+// rebaseIncrementalBackups - when backup shall be deleted by retention, but it is kept only because retained increments require it,
+// re-point these increments to older backups of the same chain which store all required data parts, and re-upload their metadata.json before delete,
+// return backupList with required_backup of successfully re-uploaded backups
+func (b *Backuper) rebaseIncrementalBackups(ctx context.Context, backupList []storage.Backup, now time.Time) []storage.Backup {
+	log := b.log.WithField("logger", "rebaseIncrementalBackups")
+	rebaseTargets := b.planRebaseIncrementalBackups(ctx, backupList, now)
+	rebased := map[string]string{}
+	for _, backup := range backupList {
+		newRequired, isRebased := rebaseTargets[backup.BackupName]
+		if !isRebased {
+			continue
+		}
+		start := time.Now()
+		if err := b.uploadRebasedMetadata(ctx, backup, newRequired); err != nil {
+			log.WithField("backup", backup.BackupName).Warnf("can't rebase from %s to %s: %v", backup.RequiredBackup, newRequired, err)
+			continue
+		}
+		rebased[backup.BackupName] = newRequired
+		log.WithFields(apexLog.Fields{
+			"backup":        backup.BackupName,
+			"from_required": backup.RequiredBackup,
+			"to_required":   newRequired,
+			"duration":      utils.HumanizeDuration(time.Since(start)),
+		}).Info("rebased")
+	}
+	return applyRebaseTargets(backupList, rebased)
+}
+
+// applyRebaseTargets - copy of backupList with required_backup replaced by rebase targets
+func applyRebaseTargets(backupList []storage.Backup, targets map[string]string) []storage.Backup {
+	result := make([]storage.Backup, len(backupList))
+	for i, backup := range backupList {
+		if newRequired, isRebased := targets[backup.BackupName]; isRebased {
+			backup.RequiredBackup = newRequired
+		}
+		result[i] = backup
+	}
+	return result
+}
+
+// planRebaseIncrementalBackups - new required_backup for each increment which shall be rebased to allow retention delete its required backup
+func (b *Backuper) planRebaseIncrementalBackups(ctx context.Context, backupList []storage.Backup, now time.Time) map[string]string {
+	log := b.log.WithField("logger", "planRebaseIncrementalBackups")
+	backupsToDelete := func(backups []storage.Backup) map[string]bool {
+		sorted := make([]storage.Backup, len(backups))
+		copy(sorted, backups)
+		result := map[string]bool{}
+		for _, policyBackups := range storage.GetBackupsToDeleteRemoteByPolicies(sorted, b.cfg.General.BackupsToKeepRemote, b.cfg.General.RetentionPolicies, now) {
+			for _, backup := range policyBackups {
+				result[backup.BackupName] = true
+			}
+		}
+		return result
+	}
+	// which backups retention would delete if increments didn't require them
+	independent := make([]storage.Backup, len(backupList))
+	for i := range backupList {
+		independent[i] = backupList[i]
+		independent[i].RequiredBackup = ""
+	}
+	candidates := backupsToDelete(independent)
+	rebaseTargets := map[string]string{}
+	if len(candidates) == 0 {
+		return rebaseTargets
+	}
+	backupsByName := make(map[string]storage.Backup, len(backupList))
+	for _, backup := range backupList {
+		backupsByName[backup.BackupName] = backup
+	}
+	tablesCache := map[string]*metadata.TableMetadata{}
+	// rebased increment could release next pinned backup in the chain, repeat until nothing changed
+	for checked := map[string]bool{}; ; {
+		deleted := backupsToDelete(applyRebaseTargets(backupList, rebaseTargets))
+		changed := false
+		for _, backup := range applyRebaseTargets(backupList, rebaseTargets) {
+			if deleted[backup.BackupName] || checked[backup.BackupName] || backup.Broken != "" || !candidates[backup.RequiredBackup] || deleted[backup.RequiredBackup] {
+				continue
+			}
+			checked[backup.BackupName] = true
+			newRequired, err := b.findRebaseTarget(ctx, backup, backupsByName, tablesCache)
+			if err != nil {
+				log.WithField("backup", backup.BackupName).Infof("can't rebase from %s: %v", backup.RequiredBackup, err)
+				continue
+			}
+			if newRequired != backup.RequiredBackup {
+				rebaseTargets[backup.BackupName] = newRequired
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	// rebase only when old required backup will be deleted, drop useless rebase until nothing changed
+	for {
+		deleted := backupsToDelete(applyRebaseTargets(backupList, rebaseTargets))
+		changed := false
+		for backupName := range rebaseTargets {
+			if deleted[backupName] || !deleted[backupsByName[backupName].RequiredBackup] {
+				delete(rebaseTargets, backupName)
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return rebaseTargets
+}
+
+// findRebaseTarget - newest backup in the required backups chain which store own copy of data parts required by backup,
+// chains with embedded backups, object disks and compression dictionaries are not supported, cause required backup name is part of the data
+func (b *Backuper) findRebaseTarget(ctx context.Context, backup storage.Backup, backupsByName map[string]storage.Backup, tablesCache map[string]*metadata.TableMetadata) (string, error) {
+	if strings.Contains(backup.Tags, "embedded") || b.hasObjectDisksRemote(backup) {
+		return "", fmt.Errorf("embedded and object disk backups are not supported")
+	}
+	chain := make([]string, 0)
+	visited := map[string]bool{backup.BackupName: true}
+	for required := backup.RequiredBackup; required != "" && !visited[required]; required = backupsByName[required].RequiredBackup {
+		requiredBackup, exists := backupsByName[required]
+		if !exists || requiredBackup.Broken != "" {
+			break
+		}
+		if strings.Contains(requiredBackup.Tags, "embedded") || b.hasObjectDisksRemote(requiredBackup) {
+			return "", fmt.Errorf("%s is embedded or object disk backup", required)
+		}
+		visited[required] = true
+		chain = append(chain, required)
+	}
+	if len(chain) == 0 {
+		return "", fmt.Errorf("required backup %s not found", backup.RequiredBackup)
+	}
+	loadTable := func(backupName string, table metadata.TableTitle) (*metadata.TableMetadata, error) {
+		cacheKey := path.Join(backupName, table.Database, table.Table)
+		if tm, isCached := tablesCache[cacheKey]; isCached {
+			return tm, nil
+		}
+		tm, err := b.readTableMetadataRemote(ctx, backupName, table)
+		if err != nil {
+			return nil, err
+		}
+		tablesCache[cacheKey] = tm
+		return tm, nil
+	}
+	tables := make([]*metadata.TableMetadata, 0, len(backup.Tables))
+	for _, table := range backup.Tables {
+		tm, err := loadTable(backup.BackupName, table)
+		if err != nil {
+			return "", err
+		}
+		tables = append(tables, tm)
+	}
+	newRequired, err := resolveRebaseTarget(tables, chain, loadTable)
+	if err != nil {
+		return "", err
+	}
+	// diff parts could be compressed with dictionary of any backup in chain, look loadCompressionDictionaries
+	for _, required := range chain {
+		if required == newRequired {
+			break
+		}
+		if backupsByName[required].CompressionDictionary != "" {
+			return "", fmt.Errorf("%s contains compression dictionary", required)
+		}
+	}
+	return newRequired, nil
+}
+
+// resolveRebaseTarget - chain is the sequence of required backups starting from current required backup,
+// each part with `required` flag or `base_part` shall be found in chain, the newest backup which stores part without `required` flag owns the part data,
+// return the newest owner across all tables, or empty string when backup doesn't require any part
+func resolveRebaseTarget(tables []*metadata.TableMetadata, chain []string, loadTable func(backupName string, table metadata.TableTitle) (*metadata.TableMetadata, error)) (string, error) {
+	newestOwner := len(chain)
+	for _, table := range tables {
+		if table.MetadataOnly {
+			continue
+		}
+		requiredParts := map[string]struct{}{}
+		for _, parts := range table.Parts {
+			for _, part := range parts {
+				if part.Required {
+					requiredParts[part.Name] = struct{}{}
+				}
+				if part.BasePart != "" {
+					requiredParts[part.BasePart] = struct{}{}
+				}
+			}
+		}
+		for i := 0; i < len(chain) && len(requiredParts) > 0; i++ {
+			tableTitle := metadata.TableTitle{Database: table.Database, Table: table.Table}
+			requiredTable, err := loadTable(chain[i], tableTitle)
+			if err != nil {
+				return "", fmt.Errorf("can't read %s.%s from %s: %v", table.Database, table.Table, chain[i], err)
+			}
+			isRequired := map[string]bool{}
+			for _, parts := range requiredTable.Parts {
+				for _, part := range parts {
+					isRequired[part.Name] = part.Required
+				}
+			}
+			for partName := range requiredParts {
+				required, exists := isRequired[partName]
+				if !exists {
+					return "", fmt.Errorf("%s.%s part %s not found in %s", table.Database, table.Table, partName, chain[i])
+				}
+				if !required {
+					delete(requiredParts, partName)
+					if i < newestOwner {
+						newestOwner = i
+					}
+				}
+			}
+			if newestOwner == 0 {
+				return chain[0], nil
+			}
+		}
+		for partName := range requiredParts {
+			return "", fmt.Errorf("%s.%s part %s is required in all backups up to %s", table.Database, table.Table, partName, chain[len(chain)-1])
+		}
+	}
+	if newestOwner == len(chain) {
+		return "", nil
+	}
+	return chain[newestOwner], nil
+}
+
+// uploadRebasedMetadata - replace required_backup in remote metadata.json
+func (b *Backuper) uploadRebasedMetadata(ctx context.Context, backup storage.Backup, newRequired string) error {
+	return b.rewriteRemoteMetadata(ctx, backup, func(backupMetadata *metadata.BackupMetadata) error {
+		if backupMetadata.RequiredBackup != backup.RequiredBackup {
+			return fmt.Errorf("%s contains required_backup=%s, expected %s, changed concurrently", path.Join(backup.BackupName, "metadata.json"), backupMetadata.RequiredBackup, backup.RequiredBackup)
+		}
+		backupMetadata.RequiredBackup = newRequired
+		for i, name := range backupMetadata.RequiredBackupsChain {
+			if name == newRequired {
+				backupMetadata.RequiredBackupsChain = backupMetadata.RequiredBackupsChain[i:]
+				break
+			}
+		}
+		if len(backupMetadata.RequiredBackupsChain) > 0 && backupMetadata.RequiredBackupsChain[0] != newRequired {
+			backupMetadata.RequiredBackupsChain = nil
+		}
+		return nil
+	})
+}
+
+// rewriteRemoteMetadata - apply change to remote metadata.json, re-uploaded metadata.json keeps original upload date,
+// signed backup is re-signed with general->signing_private_key_file
+func (b *Backuper) rewriteRemoteMetadata(ctx context.Context, backup storage.Backup, change func(backupMetadata *metadata.BackupMetadata) error) error {
+	if err := b.dst.CheckBackupLock(ctx, backup.BackupName); err != nil {
+		return err
+	}
+	remoteMetadataFile := path.Join(backup.BackupName, "metadata.json")
+	originalBody, err := b.readRemoteFile(ctx, remoteMetadataFile)
+	if err != nil {
+		return err
+	}
+	if err = b.verifyRemoteFile(ctx, backup.BackupName, remoteMetadataFile, originalBody); err != nil {
+		return err
+	}
+	backupMetadata := metadata.BackupMetadata{}
+	if err = json.Unmarshal(originalBody, &backupMetadata); err != nil {
+		return fmt.Errorf("can't parse %s: %v", remoteMetadataFile, err)
+	}
+	if err = change(&backupMetadata); err != nil {
+		return err
+	}
+	if backupMetadata.OriginalUploadDate == nil {
+		uploadDate := backup.UploadDate
+		backupMetadata.OriginalUploadDate = &uploadDate
+	}
+	newBody, err := json.MarshalIndent(&backupMetadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	var signatureBody []byte
+	remoteSignatureFile := path.Join(backup.BackupName, backupSignatureFile)
+	if _, err = b.dst.StatFile(ctx, remoteSignatureFile); err == nil {
+		if signatureBody, err = b.resignRemoteMetadata(ctx, backup.BackupName, remoteSignatureFile, map[string][]byte{remoteMetadataFile: newBody}); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("can't stat %s: %v", remoteSignatureFile, err)
+	}
+	if err = b.swapRemoteFiles(ctx, remoteMetadataFile, newBody, originalBody, remoteSignatureFile, signatureBody); err != nil {
+		return err
+	}
+	if err = b.dst.AddToCatalog(ctx, storage.Backup{BackupMetadata: backupMetadata, UploadDate: backup.UploadDate}); err != nil {
+		b.log.Warnf("can't update %s in catalog: %v", backup.BackupName, err)
+	}
+	if err = b.dst.RemoveFromMetadataCache(ctx, backup.BackupName); err != nil {
+		b.log.Warnf("can't remove %s from metadata cache: %v", backup.BackupName, err)
+	}
+	return nil
+}
+
+// remoteTempSuffix - new content is uploaded to temporary key first, so interrupted upload never leaves partially written metadata.json
+const remoteTempSuffix = ".tmp"
+
+// swapRemoteFiles - upload metadata.json and signature.json to temporary keys and copy them over original keys only when both uploads succeed,
+// original metadata.json is restored when signature.json copy failed, signatureBody is nil for unsigned backup
+func (b *Backuper) swapRemoteFiles(ctx context.Context, remoteMetadataFile string, metadataBody, originalBody []byte, remoteSignatureFile string, signatureBody []byte) error {
+	type remoteFileBody struct {
+		key  string
+		body []byte
+	}
+	files := []remoteFileBody{{remoteMetadataFile, metadataBody}}
+	if signatureBody != nil {
+		files = append(files, remoteFileBody{remoteSignatureFile, signatureBody})
+	}
+	defer func() {
+		for _, f := range files {
+			if err := b.dst.DeleteFile(ctx, f.key+remoteTempSuffix); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				b.log.Warnf("can't delete %s: %v", f.key+remoteTempSuffix, err)
+			}
+		}
+	}()
+	for _, f := range files {
+		if err := b.putRemoteFile(ctx, f.key+remoteTempSuffix, f.body); err != nil {
+			return err
+		}
+	}
+	for i, f := range files {
+		if err := b.copyRemoteFile(ctx, int64(len(f.body)), f.key+remoteTempSuffix, f.key); err != nil {
+			if i == 0 {
+				return err
+			}
+			if rollbackErr := b.putRemoteFile(ctx, remoteMetadataFile, originalBody); rollbackErr != nil {
+				return fmt.Errorf("%v, rollback %s failed: %v", err, remoteMetadataFile, rollbackErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// resignRemoteMetadata - existing signature shall be valid for signing_private_key_file public key, otherwise changed files could be signed
+func (b *Backuper) resignRemoteMetadata(ctx context.Context, backupName, remoteSignatureFile string, changedFiles map[string][]byte) ([]byte, error) {
+	if b.cfg.General.SigningPrivateKeyFile == "" {
+		return nil, fmt.Errorf("%s is signed, general->signing_private_key_file is required to sign changed metadata", backupName)
+	}
+	privateKey, err := loadSigningPrivateKey(b.cfg.General.SigningPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't load signing_private_key_file: %v", err)
+	}
+	existingBody, err := b.readRemoteFile(ctx, remoteSignatureFile)
+	if err != nil {
+		return nil, err
+	}
+	existingSignature, err := verifyBackupSignature(existingBody, privateKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", remoteSignatureFile, err)
+	}
+	signer := &backupSigner{privateKey: privateKey, files: existingSignature.Files}
+	for remoteFile, body := range changedFiles {
+		signer.add(backupName, remoteFile, body)
+	}
+	return signer.sign()
+}
+
+// readTableMetadataRemote - read <backup>/metadata/<db>/<table>.json without saving to local disk
+func (b *Backuper) readTableMetadataRemote(ctx context.Context, backupName string, table metadata.TableTitle) (*metadata.TableMetadata, error) {
+	remoteMetadataFile := path.Join(backupName, "metadata", common.TablePathEncode(table.Database), fmt.Sprintf("%s.json", common.TablePathEncode(table.Table)))
+	body, err := b.readRemoteFile(ctx, remoteMetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.verifyRemoteFile(ctx, backupName, remoteMetadataFile, body); err != nil {
+		return nil, err
+	}
+	tm := &metadata.TableMetadata{}
+	if err = json.Unmarshal(body, tm); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", remoteMetadataFile, err)
+	}
+	return tm, nil
+}
+
+func (b *Backuper) readRemoteFile(ctx context.Context, remoteFile string) ([]byte, error) {
+	var body []byte
+	retry := b.newRetrier()
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		reader, err := b.dst.GetFileReader(ctx, remoteFile)
+		if err != nil {
+			return err
+		}
+		if body, err = io.ReadAll(reader); err != nil {
+			return err
+		}
+		return reader.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't download %s: %v", remoteFile, err)
+	}
+	return body, nil
+}
+
+func (b *Backuper) putRemoteFile(ctx context.Context, remoteFile string, body []byte) error {
+	retry := b.newRetrier()
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutFile(ctx, remoteFile, io.NopCloser(bytes.NewReader(body)))
+	})
+	if err != nil {
+		return fmt.Errorf("can't upload %s: %v", remoteFile, err)
+	}
+	return nil
+}
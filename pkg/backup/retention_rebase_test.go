@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveRebaseTarget(t *testing.T) {
+	table := func(parts ...metadata.Part) *metadata.TableMetadata {
+		return &metadata.TableMetadata{Database: "db", Table: "t", Parts: map[string][]metadata.Part{"default": parts}}
+	}
+	// full <- increment1 <- increment2 <- increment3, increment2 contains only parts from full, increment1 owns all_2_2_0
+	backups := map[string]*metadata.TableMetadata{
+		"full":       table(metadata.Part{Name: "all_1_1_0"}),
+		"increment1": table(metadata.Part{Name: "all_1_1_0", Required: true}, metadata.Part{Name: "all_2_2_0"}),
+		"increment2": table(metadata.Part{Name: "all_1_1_0", Required: true}),
+	}
+	loadTable := func(backupName string, tableTitle metadata.TableTitle) (*metadata.TableMetadata, error) {
+		if tm, exists := backups[backupName]; exists {
+			return tm, nil
+		}
+		return nil, fmt.Errorf("%s not found", backupName)
+	}
+
+	newRequired, err := resolveRebaseTarget([]*metadata.TableMetadata{table(metadata.Part{Name: "all_1_1_0", Required: true}, metadata.Part{Name: "all_3_3_0"})}, []string{"increment2", "increment1", "full"}, loadTable)
+	require.NoError(t, err)
+	assert.Equal(t, "full", newRequired)
+
+	// the newest owner across all parts
+	newRequired, err = resolveRebaseTarget([]*metadata.TableMetadata{table(metadata.Part{Name: "all_1_1_0", Required: true}, metadata.Part{Name: "all_2_2_0", Required: true})}, []string{"increment1", "full"}, loadTable)
+	require.NoError(t, err)
+	assert.Equal(t, "increment1", newRequired)
+
+	// base_part of upload_diff_files is required the same as part with required flag
+	newRequired, err = resolveRebaseTarget([]*metadata.TableMetadata{table(metadata.Part{Name: "all_2_2_1", BasePart: "all_2_2_0"})}, []string{"increment1", "full"}, loadTable)
+	require.NoError(t, err)
+	assert.Equal(t, "increment1", newRequired)
+
+	// increment without required parts doesn't need required backup
+	newRequired, err = resolveRebaseTarget([]*metadata.TableMetadata{table(metadata.Part{Name: "all_3_3_0"}), {Database: "db", Table: "view", MetadataOnly: true}}, []string{"increment2", "increment1", "full"}, loadTable)
+	require.NoError(t, err)
+	assert.Equal(t, "", newRequired)
+
+	_, err = resolveRebaseTarget([]*metadata.TableMetadata{table(metadata.Part{Name: "all_4_4_0", Required: true})}, []string{"increment2", "increment1", "full"}, loadTable)
+	assert.ErrorContains(t, err, "part all_4_4_0 not found in increment2")
+
+	_, err = resolveRebaseTarget([]*metadata.TableMetadata{table(metadata.Part{Name: "all_1_1_0", Required: true})}, []string{"increment2", "increment1"}, loadTable)
+	assert.ErrorContains(t, err, "is required in all backups up to increment1")
+
+	_, err = resolveRebaseTarget([]*metadata.TableMetadata{table(metadata.Part{Name: "all_1_1_0", Required: true})}, []string{"absent"}, loadTable)
+	assert.ErrorContains(t, err, "absent not found")
+}
+
+// failPutTestStorage - PutFile for failKey returns error
+type failPutTestStorage struct {
+	*gcTestStorage
+	failKey string
+}
+
+func (s *failPutTestStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	if key == s.failKey {
+		return fmt.Errorf("put %s failed", key)
+	}
+	return s.gcTestStorage.PutFile(ctx, key, r)
+}
+
+func TestSwapRemoteFiles(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+	cfg.General.RetriesOnFailure = 0
+	newBackuper := func(remote storage.RemoteStorage) *Backuper {
+		b := NewBackuper(cfg)
+		b.dst = storage.NewBackupDestinationFromRemoteStorage(cfg, remote, apexLog.WithField("logger", "test"))
+		return b
+	}
+	remote := &gcTestStorage{objects: map[string][]byte{
+		"backup1/metadata.json":  []byte("old metadata"),
+		"backup1/signature.json": []byte("old signature"),
+	}}
+
+	// signature.json upload failed, originals are untouched
+	b := newBackuper(&failPutTestStorage{gcTestStorage: remote, failKey: "backup1/signature.json" + remoteTempSuffix})
+	err := b.swapRemoteFiles(ctx, "backup1/metadata.json", []byte("new metadata"), []byte("old metadata"), "backup1/signature.json", []byte("new signature"))
+	require.Error(t, err)
+	assert.Equal(t, "old metadata", string(remote.objects["backup1/metadata.json"]))
+	assert.Equal(t, "old signature", string(remote.objects["backup1/signature.json"]))
+	assert.NotContains(t, remote.objects, "backup1/metadata.json"+remoteTempSuffix)
+
+	b = newBackuper(remote)
+	require.NoError(t, b.swapRemoteFiles(ctx, "backup1/metadata.json", []byte("new metadata"), []byte("old metadata"), "backup1/signature.json", []byte("new signature")))
+	assert.Equal(t, "new metadata", string(remote.objects["backup1/metadata.json"]))
+	assert.Equal(t, "new signature", string(remote.objects["backup1/signature.json"]))
+	assert.Len(t, remote.objects, 2)
+}
@@ -0,0 +1,271 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+)
+
+const (
+	backupSignatureFile      = "signature.json"
+	backupSignatureAlgorithm = "ed25519"
+)
+
+// backupSignature - sha256 checksums of backup metadata files relative to backup root, signed during upload
+type backupSignature struct {
+	Algorithm string            `json:"algorithm"`
+	KeyId     string            `json:"key_id"`
+	Files     map[string]string `json:"files"`
+	Signature string            `json:"signature,omitempty"`
+}
+
+// payload - signed bytes, json.Marshal sorts map keys, so result doesn't depend on upload order
+func (s *backupSignature) payload() ([]byte, error) {
+	return json.Marshal(backupSignature{Algorithm: s.Algorithm, KeyId: s.KeyId, Files: s.Files})
+}
+
+// backupSigner - collect checksums of uploaded metadata files from concurrent upload go-routines
+type backupSigner struct {
+	privateKey ed25519.PrivateKey
+	mutex      sync.Mutex
+	files      map[string]string
+}
+
+func (s *backupSigner) add(backupName, remoteFile string, content []byte) {
+	checksum := sha256.Sum256(content)
+	s.mutex.Lock()
+	s.files[strings.TrimPrefix(remoteFile, backupName+"/")] = hex.EncodeToString(checksum[:])
+	s.mutex.Unlock()
+}
+
+func (s *backupSigner) sign() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	signature := backupSignature{
+		Algorithm: backupSignatureAlgorithm,
+		KeyId:     signatureKeyId(s.privateKey.Public().(ed25519.PublicKey)),
+		Files:     s.files,
+	}
+	payload, err := signature.payload()
+	if err != nil {
+		return nil, err
+	}
+	signature.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, payload))
+	return json.MarshalIndent(signature, "", "\t")
+}
+
+// signatureKeyId - short fingerprint of public key, help to find which key shall use for verification
+func signatureKeyId(publicKey ed25519.PublicKey) string {
+	checksum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(checksum[:8])
+}
+
+// loadSigningPrivateKey - PEM encoded PKCS #8 ed25519 private key, the same format as `openssl genpkey -algorithm ed25519`
+func loadSigningPrivateKey(keyFile string) (ed25519.PrivateKey, error) {
+	keyBody, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyBody)
+	if block == nil {
+		return nil, fmt.Errorf("%s doesn't contain PEM encoded private key", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", keyFile, err)
+	}
+	privateKey, isEd25519 := key.(ed25519.PrivateKey)
+	if !isEd25519 {
+		return nil, fmt.Errorf("%s contains %T, only ed25519 keys supported", keyFile, key)
+	}
+	return privateKey, nil
+}
+
+// loadVerifyPublicKey - PEM encoded PKIX ed25519 public key, the same format as `openssl pkey -pubout`
+func loadVerifyPublicKey(keyFile string) (ed25519.PublicKey, error) {
+	keyBody, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyBody)
+	if block == nil {
+		return nil, fmt.Errorf("%s doesn't contain PEM encoded public key", keyFile)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", keyFile, err)
+	}
+	publicKey, isEd25519 := key.(ed25519.PublicKey)
+	if !isEd25519 {
+		return nil, fmt.Errorf("%s contains %T, only ed25519 keys supported", keyFile, key)
+	}
+	return publicKey, nil
+}
+
+// verifyBackupSignature - check signature.json body with publicKey
+func verifyBackupSignature(signatureBody []byte, publicKey ed25519.PublicKey) (*backupSignature, error) {
+	signature := &backupSignature{}
+	if err := json.Unmarshal(signatureBody, signature); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", backupSignatureFile, err)
+	}
+	if signature.Algorithm != backupSignatureAlgorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm %s", signature.Algorithm)
+	}
+	if expectedKeyId := signatureKeyId(publicKey); signature.KeyId != expectedKeyId {
+		return nil, fmt.Errorf("signed with key_id=%s, expected key_id=%s", signature.KeyId, expectedKeyId)
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode signature: %v", err)
+	}
+	payload, err := signature.payload()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(publicKey, payload, signatureBytes) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return signature, nil
+}
+
+// initBackupSigner - enable signing for upload when general->signing_private_key_file defined
+func (b *Backuper) initBackupSigner() error {
+	if b.cfg.General.SigningPrivateKeyFile == "" {
+		return nil
+	}
+	privateKey, err := loadSigningPrivateKey(b.cfg.General.SigningPrivateKeyFile)
+	if err != nil {
+		return fmt.Errorf("can't load signing_private_key_file: %v", err)
+	}
+	b.signer = &backupSigner{privateKey: privateKey, files: map[string]string{}}
+	return nil
+}
+
+// addSignedFile - do nothing when signing is not enabled
+func (b *Backuper) addSignedFile(backupName, remoteFile string, content []byte) {
+	if b.signer != nil {
+		b.signer.add(backupName, remoteFile, content)
+	}
+}
+
+// uploadSignature - shall call after all signed files uploaded
+func (b *Backuper) uploadSignature(ctx context.Context, backupName string) (int64, error) {
+	if b.signer == nil {
+		return 0, nil
+	}
+	signatureBody, err := b.signer.sign()
+	if err != nil {
+		return 0, err
+	}
+	remoteSignatureFile := path.Join(backupName, backupSignatureFile)
+	retry := b.newRetrier()
+	err = retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutFile(ctx, remoteSignatureFile, io.NopCloser(bytes.NewReader(signatureBody)))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't upload %s: %v", remoteSignatureFile, err)
+	}
+	return int64(len(signatureBody)), nil
+}
+
+// getVerifiedSignature - download and verify signature.json once for each backup, required backups have own signature
+func (b *Backuper) getVerifiedSignature(ctx context.Context, backupName string) (*backupSignature, error) {
+	b.verifiedSignaturesMutex.Lock()
+	defer b.verifiedSignaturesMutex.Unlock()
+	if signature, exists := b.verifiedSignatures[backupName]; exists {
+		return signature, nil
+	}
+	publicKey, err := loadVerifyPublicKey(b.cfg.General.VerifyPublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't load verify_public_key_file: %v", err)
+	}
+	remoteSignatureFile := path.Join(backupName, backupSignatureFile)
+	var signatureBody []byte
+	retry := b.newRetrier()
+	err = retry.RunCtx(ctx, func(ctx context.Context) error {
+		reader, err := b.dst.GetFileReader(ctx, remoteSignatureFile)
+		if err != nil {
+			return err
+		}
+		if signatureBody, err = io.ReadAll(reader); err != nil {
+			return err
+		}
+		return reader.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: can't download %s, backup is not signed or signature deleted: %v", backupName, remoteSignatureFile, err)
+	}
+	signature, err := verifyBackupSignature(signatureBody, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: signature verification failed: %v", backupName, err)
+	}
+	if b.verifiedSignatures == nil {
+		b.verifiedSignatures = map[string]*backupSignature{}
+	}
+	b.verifiedSignatures[backupName] = signature
+	b.log.WithField("backup", backupName).WithField("key_id", signature.KeyId).Info("signature verified")
+	return signature, nil
+}
+
+// verifyRemoteFile - compare downloaded content with checksum from verified signature, do nothing when general->verify_public_key_file is empty
+func (b *Backuper) verifyRemoteFile(ctx context.Context, backupName, remoteFile string, content []byte) error {
+	if b.cfg.General.VerifyPublicKeyFile == "" {
+		return nil
+	}
+	signature, err := b.getVerifiedSignature(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	signedFile := strings.TrimPrefix(remoteFile, backupName+"/")
+	expectedChecksum, exists := signature.Files[signedFile]
+	if !exists {
+		return fmt.Errorf("%s: %s is not present in %s", backupName, signedFile, backupSignatureFile)
+	}
+	checksum := sha256.Sum256(content)
+	if actualChecksum := hex.EncodeToString(checksum[:]); actualChecksum != expectedChecksum {
+		return fmt.Errorf("%s: %s checksum mismatch, expected %s, actual %s, backup could be modified", backupName, signedFile, expectedChecksum, actualChecksum)
+	}
+	return nil
+}
+
+// readVerifiedBackupMetadataRemote - read metadata.json directly instead of BackupList, which could use catalog.json or local cache
+func (b *Backuper) readVerifiedBackupMetadataRemote(ctx context.Context, backupName string) (*metadata.BackupMetadata, error) {
+	remoteMetadataFile := path.Join(backupName, "metadata.json")
+	var metadataBody []byte
+	retry := b.newRetrier()
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		reader, err := b.dst.GetFileReader(ctx, remoteMetadataFile)
+		if err != nil {
+			return err
+		}
+		if metadataBody, err = io.ReadAll(reader); err != nil {
+			return err
+		}
+		return reader.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't download %s: %v", remoteMetadataFile, err)
+	}
+	if err = b.verifyRemoteFile(ctx, backupName, remoteMetadataFile, metadataBody); err != nil {
+		return nil, err
+	}
+	backupMetadata := &metadata.BackupMetadata{}
+	if err = json.Unmarshal(metadataBody, backupMetadata); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", remoteMetadataFile, err)
+	}
+	return backupMetadata, nil
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+	privateKeyFile := path.Join(t.TempDir(), "private.pem")
+	publicKeyFile := path.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(privateKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyBytes}), 0600))
+	require.NoError(t, os.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes}), 0644))
+
+	loadedPrivateKey, err := loadSigningPrivateKey(privateKeyFile)
+	require.NoError(t, err)
+	loadedPublicKey, err := loadVerifyPublicKey(publicKeyFile)
+	require.NoError(t, err)
+	_, err = loadVerifyPublicKey(privateKeyFile)
+	require.Error(t, err)
+
+	signer := &backupSigner{privateKey: loadedPrivateKey, files: map[string]string{}}
+	signer.add("backup1", "backup1/metadata.json", []byte(`{"backup_name":"backup1"}`))
+	signer.add("backup1", "backup1/metadata/db/t1.json", []byte(`{"table":"t1"}`))
+	signatureBody, err := signer.sign()
+	require.NoError(t, err)
+
+	signature, err := verifyBackupSignature(signatureBody, loadedPublicKey)
+	require.NoError(t, err)
+	assert.Len(t, signature.Files, 2)
+	assert.Contains(t, signature.Files, "metadata/db/t1.json")
+
+	signature.Files["metadata/db/t2.json"] = signature.Files["metadata/db/t1.json"]
+	tamperedBody, err := json.Marshal(signature)
+	require.NoError(t, err)
+	_, err = verifyBackupSignature(tamperedBody, loadedPublicKey)
+	require.Error(t, err)
+
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = verifyBackupSignature(signatureBody, otherPublicKey)
+	require.Error(t, err)
+}
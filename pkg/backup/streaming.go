@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+// streamingSettings - engine settings which define position of consumer, credentials are never saved into backup metadata
+var streamingSettings = map[string][]string{
+	"Kafka":    {"kafka_broker_list", "kafka_topic_list", "kafka_group_name"},
+	"RabbitMQ": {"rabbitmq_host_port", "rabbitmq_address", "rabbitmq_exchange_name", "rabbitmq_routing_key_list", "rabbitmq_queue_base"},
+	"NATS":     {"nats_url", "nats_subjects", "nats_queue_group"},
+}
+
+var streamingSettingRE = regexp.MustCompile(`(?i)\b((?:kafka|rabbitmq|nats)_\w+)\s*=\s*('(?:[^'\\]|\\.)*'|[^,\s]+)`)
+var kafkaEngineArgsRE = regexp.MustCompile(`ENGINE\s*=\s*Kafka\s*\(\s*'((?:[^'\\]|\\.)*)'\s*,\s*'((?:[^'\\]|\\.)*)'\s*,\s*'((?:[^'\\]|\\.)*)'`)
+
+// parseStreamingSettings - settings from SETTINGS clause or from positional arguments of legacy `Kafka('broker', 'topic', 'group', 'format')` syntax
+func parseStreamingSettings(engine, query string) map[string]string {
+	allowedSettings := map[string]bool{}
+	for _, name := range streamingSettings[engine] {
+		allowedSettings[name] = true
+	}
+	settings := map[string]string{}
+	for _, match := range streamingSettingRE.FindAllStringSubmatch(query, -1) {
+		name := strings.ToLower(match[1])
+		if !allowedSettings[name] {
+			continue
+		}
+		settings[name] = strings.Trim(match[2], "'")
+	}
+	if engine == "Kafka" {
+		if args := kafkaEngineArgsRE.FindStringSubmatch(query); len(args) > 0 {
+			for i, name := range streamingSettings["Kafka"] {
+				if _, exists := settings[name]; !exists {
+					settings[name] = args[i+1]
+				}
+			}
+		}
+	}
+	return settings
+}
+
+// getStreamingMetadata - streaming tables don't store data, only consumer position on broker side allows to continue pipeline after restore
+func (b *Backuper) getStreamingMetadata(ctx context.Context, table *clickhouse.Table, log *apexLog.Entry) (*metadata.StreamingMetadata, error) {
+	if _, isStreaming := streamingSettings[table.Engine]; !isStreaming {
+		return nil, nil
+	}
+	streaming := &metadata.StreamingMetadata{
+		Engine:   table.Engine,
+		Settings: parseStreamingSettings(table.Engine, table.CreateTableQuery),
+	}
+	if table.Engine != "Kafka" {
+		return streaming, nil
+	}
+	offsets, err := b.ch.GetKafkaConsumerOffsets(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, offset := range offsets {
+		streaming.Offsets = append(streaming.Offsets, metadata.StreamingOffset{Topic: offset.Topic, Partition: offset.Partition, Offset: offset.Offset})
+	}
+	if len(streaming.Offsets) == 0 {
+		log.Warn("system.kafka_consumers doesn't contain offsets, only consumer settings saved")
+	}
+	return streaming, nil
+}
+
+// getKafkaOffsetResetCommands - kafka-consumer-groups.sh commands which move consumer group to offsets from backup
+func getKafkaOffsetResetCommands(streaming *metadata.StreamingMetadata) []string {
+	brokerList := streaming.Settings["kafka_broker_list"]
+	groupName := streaming.Settings["kafka_group_name"]
+	if brokerList == "" || groupName == "" {
+		return nil
+	}
+	offsets := append([]metadata.StreamingOffset{}, streaming.Offsets...)
+	sort.Slice(offsets, func(i, j int) bool {
+		if offsets[i].Topic != offsets[j].Topic {
+			return offsets[i].Topic < offsets[j].Topic
+		}
+		return offsets[i].Partition < offsets[j].Partition
+	})
+	commands := make([]string, 0, len(offsets))
+	for _, offset := range offsets {
+		// consumer doesn't commit offset before the first message, nothing to reset
+		if offset.Offset < 0 {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("kafka-consumer-groups.sh --bootstrap-server %s --group %s --topic %s:%d --reset-offsets --to-offset %d --execute", brokerList, groupName, offset.Topic, offset.Partition, offset.Offset))
+	}
+	return commands
+}
+
+// reportStreamingTables - restore doesn't touch brokers, print saved consumer position and commands which allow to replay messages from backup time
+func (b *Backuper) reportStreamingTables(tablesForRestore ListOfTables, log *apexLog.Entry) {
+	for _, table := range tablesForRestore {
+		if table.Streaming == nil {
+			continue
+		}
+		dstDatabase := table.Database
+		if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
+			dstDatabase = targetDB
+		}
+		tableLog := log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, table.Table)).WithField("engine", table.Streaming.Engine)
+		settingNames := make([]string, 0, len(table.Streaming.Settings))
+		for name := range table.Streaming.Settings {
+			settingNames = append(settingNames, name)
+		}
+		sort.Strings(settingNames)
+		settings := make([]string, len(settingNames))
+		for i, name := range settingNames {
+			settings[i] = fmt.Sprintf("%s=%s", name, table.Streaming.Settings[name])
+		}
+		tableLog.Infof("streaming table restored, consumer settings at backup time: %s", strings.Join(settings, ", "))
+		if table.Streaming.Engine != "Kafka" {
+			tableLog.Info("broker keeps own position of queue, messages acknowledged after backup will not be consumed again")
+			continue
+		}
+		commands := getKafkaOffsetResetCommands(table.Streaming)
+		if len(commands) == 0 {
+			tableLog.Info("backup doesn't contain consumer offsets")
+			continue
+		}
+		tableLog.Infof("consumer group continues from offsets committed on broker, to replay messages from backup time execute DETACH TABLE `%s`.`%s`, run following commands, then ATTACH TABLE `%s`.`%s`", dstDatabase, table.Table, dstDatabase, table.Table)
+		for _, command := range commands {
+			tableLog.Info(command)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStreamingSettings(t *testing.T) {
+	query := "CREATE TABLE db.queue (`id` UInt64) ENGINE = Kafka SETTINGS kafka_broker_list = 'kafka:9092', kafka_topic_list = 'events', kafka_group_name = 'clickhouse', kafka_format = 'JSONEachRow', kafka_sasl_password = 'secret'"
+	assert.Equal(t, map[string]string{
+		"kafka_broker_list": "kafka:9092",
+		"kafka_topic_list":  "events",
+		"kafka_group_name":  "clickhouse",
+	}, parseStreamingSettings("Kafka", query))
+
+	query = "CREATE TABLE db.queue (`id` UInt64) ENGINE = Kafka('kafka1:9092,kafka2:9092', 'events', 'legacy', 'JSONEachRow')"
+	assert.Equal(t, map[string]string{
+		"kafka_broker_list": "kafka1:9092,kafka2:9092",
+		"kafka_topic_list":  "events",
+		"kafka_group_name":  "legacy",
+	}, parseStreamingSettings("Kafka", query))
+
+	query = "CREATE TABLE db.queue (`id` UInt64) ENGINE = NATS SETTINGS nats_url = 'nats:4222', nats_subjects = 'subject1,subject2', nats_format = 'JSONEachRow', nats_password = 'secret'"
+	assert.Equal(t, map[string]string{"nats_url": "nats:4222", "nats_subjects": "subject1,subject2"}, parseStreamingSettings("NATS", query))
+}
+
+func TestGetKafkaOffsetResetCommands(t *testing.T) {
+	streaming := &metadata.StreamingMetadata{
+		Engine:   "Kafka",
+		Settings: map[string]string{"kafka_broker_list": "kafka:9092", "kafka_group_name": "clickhouse"},
+		Offsets: []metadata.StreamingOffset{
+			{Topic: "events", Partition: 1, Offset: 20},
+			{Topic: "events", Partition: 0, Offset: 10},
+			{Topic: "events", Partition: 2, Offset: -1001},
+		},
+	}
+	assert.Equal(t, []string{
+		"kafka-consumer-groups.sh --bootstrap-server kafka:9092 --group clickhouse --topic events:0 --reset-offsets --to-offset 10 --execute",
+		"kafka-consumer-groups.sh --bootstrap-server kafka:9092 --group clickhouse --topic events:1 --reset-offsets --to-offset 20 --execute",
+	}, getKafkaOffsetResetCommands(streaming))
+	streaming.Settings = map[string]string{"kafka_broker_list": "kafka:9092"}
+	assert.Empty(t, getKafkaOffsetResetCommands(streaming))
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
 )
 
 type ListOfTables []metadata.TableMetadata
@@ -129,6 +130,7 @@ func (b *Backuper) getTableListByPatternLocal(ctx context.Context, metadataPath
 	for i := 0; i < len(result); i++ {
 		if b.shouldSkipByTableEngine(result[i]) {
 			t := result[i]
+			status.Current.AddWarning(ctx, b.log, status.WarningSkippedTable, "%s.%s skipped by clickhouse->skip_table_engines", t.Database, t.Table)
 			delete(resultPartitionNames, metadata.TableTitle{Database: t.Database, Table: t.Table})
 			result = append(result[:i], result[i+1:]...)
 		}
@@ -353,6 +355,43 @@ func changeTableQueryToAdjustDatabaseMapping(originTables *ListOfTables, dbMapRu
 	return nil
 }
 
+// changeTableQueryToAdjustTableName - substitute table name in create query, used by `restore-table --into`
+func changeTableQueryToAdjustTableName(originTable *metadata.TableMetadata, targetTable string) error {
+	if originTable.Table == targetTable {
+		return nil
+	}
+	if !createOrAttachRE.MatchString(originTable.Query) {
+		return fmt.Errorf("error when try to replace table `%s` to `%s` in query: %s", originTable.Table, targetTable, originTable.Query)
+	}
+	matches := queryRE.FindAllStringSubmatch(originTable.Query, -1)
+	if len(matches) == 0 || matches[0][6] != originTable.Table {
+		return fmt.Errorf("invalid SQL: %s for rename table %s to %s", originTable.Query, originTable.Table, targetTable)
+	}
+	createTargetTable := targetTable
+	if !usualIdentifier.MatchString(createTargetTable) {
+		createTargetTable = "`" + createTargetTable + "`"
+	}
+	substitution := fmt.Sprintf("${1} ${2} ${3}${4}${5}.%s${7}${8}${9}${10}${11}${12}${13}${14}${15}${16}${17}", createTargetTable)
+	originTable.Query = queryRE.ReplaceAllString(originTable.Query, substitution)
+	// original table could still exist, renamed table requires other UUID
+	if uuidRE.MatchString(originTable.Query) {
+		newUUID, _ := uuid.NewUUID()
+		originTable.Query = uuidRE.ReplaceAllString(originTable.Query, fmt.Sprintf("UUID '%s'", newUUID.String()))
+	}
+	// https://github.com/Altinity/clickhouse-backup/issues/547
+	if replicatedRE.MatchString(originTable.Query) {
+		matches = replicatedRE.FindAllStringSubmatch(originTable.Query, -1)
+		originPath := matches[0][2]
+		tableReplicatedPattern := "/" + originTable.Table
+		if idx := strings.LastIndex(originPath, tableReplicatedPattern); idx >= 0 && (idx+len(tableReplicatedPattern) == len(originPath) || originPath[idx+len(tableReplicatedPattern)] == '/') {
+			newPath := originPath[:idx] + "/" + targetTable + originPath[idx+len(tableReplicatedPattern):]
+			originTable.Query = replicatedRE.ReplaceAllString(originTable.Query, fmt.Sprintf("${1}('%s'${3})", newPath))
+		}
+	}
+	originTable.Table = targetTable
+	return nil
+}
+
 func filterPartsAndFilesByPartitionsFilter(tableMetadata metadata.TableMetadata, partitionsFilter common.EmptyMap) {
 	if len(partitionsFilter) > 0 {
 		for disk, parts := range tableMetadata.Parts {
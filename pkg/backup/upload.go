@@ -9,9 +9,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,11 +21,11 @@ import (
 	"github.com/Altinity/clickhouse-backup/v2/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
-	"github.com/eapache/go-resiliency/retrier"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/filesystemhelper"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
@@ -42,6 +43,7 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 
 	startUpload := time.Now()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	b.setLogComment("upload", backupName, commandId)
 	var disks []clickhouse.Disk
 	if !resume && b.cfg.General.UseResumableState {
 		resume = true
@@ -54,10 +56,21 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 	if err = b.validateUploadParams(ctx, backupName, diffFrom, diffFromRemote); err != nil {
 		return err
 	}
+	ctx, releaseKeeperLock, err := b.lockKeeper(ctx, "upload", backupName, schemaOnly)
+	if err != nil {
+		return err
+	}
+	defer releaseKeeperLock()
 	if b.cfg.General.RemoteStorage == "custom" {
-		return custom.Upload(ctx, b.cfg, backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly)
+		if b.dryRun != nil {
+			return fmt.Errorf("--dry-run is not supported for remote_storage: custom")
+		}
+		if disks, err = b.ch.GetDisks(ctx, false); err != nil {
+			return err
+		}
+		return custom.Upload(ctx, b.cfg, backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, getCustomDiskMap(disks))
 	}
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "upload",
 	})
@@ -77,8 +90,22 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 	if err != nil {
 		return fmt.Errorf("b.dst.BackupList return error: %v", err)
 	}
+	var onlyFailedBackup *storage.Backup
+	if b.onlyFailed {
+		if onlyFailedBackup, err = b.getRemoteBackupForOnlyFailed(backupName, remoteBackups); err != nil {
+			return err
+		}
+		if len(onlyFailedBackup.FailedTables) == 0 {
+			log.Infof("'%s' on remote storage has no failed_tables, nothing to upload", backupName)
+			return nil
+		}
+		// failed tables shall be incremental to the same required backup as other tables
+		if onlyFailedBackup.RequiredBackup != "" {
+			diffFrom, diffFromRemote = "", onlyFailedBackup.RequiredBackup
+		}
+	}
 	for i := range remoteBackups {
-		if backupName == remoteBackups[i].BackupName {
+		if backupName == remoteBackups[i].BackupName && !b.onlyFailed && !b.resumeRemote {
 			if !b.resume {
 				return fmt.Errorf("'%s' already exists on remote storage", backupName)
 			} else {
@@ -86,22 +113,39 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 			}
 		}
 	}
+	if (diffFrom != "" || diffFromRemote != "") && b.cfg.General.IncrementalMaxBaseAgeDuration > 0 && !b.onlyFailed {
+		diffFrom, diffFromRemote = b.enforceIncrementalMaxBaseAge(ctx, diffFrom, diffFromRemote, remoteBackups, log)
+	}
 	backupMetadata, err := b.ReadBackupMetadataLocal(ctx, backupName)
 	if err != nil {
 		return fmt.Errorf("b.ReadBackupMetadataLocal return error: %v", err)
 	}
+	// uploaded backup would look like complete backup, use `upload --tables --partitions` from complete local backup instead
+	if backupMetadata.PartialDownload != nil {
+		return fmt.Errorf("'%s' is partially downloaded with %s, upload is not supported", backupName, backupMetadata.PartialDownload.String())
+	}
 	var tablesForUpload ListOfTables
 	b.isEmbedded = strings.Contains(backupMetadata.Tags, "embedded")
 	// will ignore partitions cause can't manipulate .backup
 	if b.isEmbedded {
 		partitions = make([]string, 0)
 	}
+	var resumeRemoteBackup *storage.Backup
+	if b.resumeRemote {
+		if resumeRemoteBackup, err = b.getRemoteBackupForResume(backupName, remoteBackups); err != nil {
+			return err
+		}
+	}
 	if len(backupMetadata.Tables) != 0 {
 		tablesForUpload, err = b.prepareTableListToUpload(ctx, backupName, tablePattern, partitions)
 		if err != nil {
 			return fmt.Errorf("b.prepareTableListToUpload return error: %v", err)
 		}
 	}
+	if onlyFailedBackup != nil {
+		tablesForUpload = filterFailedTables(tablesForUpload, onlyFailedBackup.FailedTables)
+		log.Infof("upload %d of %d failed tables", len(tablesForUpload), len(onlyFailedBackup.FailedTables))
+	}
 	tablesForUploadFromDiff := map[metadata.TableTitle]metadata.TableMetadata{}
 
 	if diffFrom != "" && !b.isEmbedded {
@@ -118,8 +162,14 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 		}
 		backupMetadata.RequiredBackup = diffFromRemote
 	}
+	if backupMetadata.RequiredBackup != "" {
+		backupMetadata.RequiredBackupsChain = getRequiredBackupsChain(remoteBackups, backupMetadata.RequiredBackup)
+	}
+	if b.dryRun != nil {
+		return b.planUpload(ctx, backupMetadata, tablesForUpload, tablesForUploadFromDiff, diffFrom, diffFromRemote, schemaOnly, deleteSource, disks)
+	}
 	if b.resume {
-		b.resumableState = resumable.NewState(b.DefaultDataPath, backupName, "upload", map[string]interface{}{
+		b.resumableState = resumable.NewState(b.DefaultDataPath, backupName, b.resumableCommand("upload"), map[string]interface{}{
 			"diffFrom":       diffFrom,
 			"diffFromRemote": diffFromRemote,
 			"tablePattern":   tablePattern,
@@ -128,12 +178,34 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 		})
 	}
 
+	if err = b.initBackupSigner(); err != nil {
+		return err
+	}
+
+	if !schemaOnly && !b.isEmbedded && b.cfg.General.CompressionDictionaryMaxTableSize > 0 {
+		if backupMetadata.CompressionDictionary, err = b.uploadCompressionDictionary(ctx, backupName, tablesForUpload); err != nil {
+			return fmt.Errorf("b.uploadCompressionDictionary return error: %v", err)
+		}
+		if backupMetadata.CompressionDictionary != "" {
+			b.addSignedFile(backupName, path.Join(backupName, backupMetadata.CompressionDictionary), b.compressionDictionary)
+		}
+	}
+
+	uploadedRemoteTables := map[metadata.TableTitle]uploadedRemoteTable{}
+	if resumeRemoteBackup != nil {
+		if uploadedRemoteTables, err = b.reconcileRemoteBackup(ctx, backupName, tablesForUpload, log); err != nil {
+			return err
+		}
+	}
+
 	compressedDataSize := int64(0)
 	metadataSize := int64(0)
 
 	log.Debugf("prepare table concurrent semaphore with concurrency=%d len(tablesForUpload)=%d", b.cfg.General.UploadConcurrency, len(tablesForUpload))
 	uploadGroup, uploadCtx := errgroup.WithContext(ctx)
 	uploadGroup.SetLimit(int(b.cfg.General.UploadConcurrency))
+	progress := b.newProgressReporter("upload", backupName, len(tablesForUpload))
+	failures := newUploadFailures(b.cfg.General.UploadFailurePolicy, b.cfg.General.UploadMaxFailedTables)
 
 	for i, table := range tablesForUpload {
 		start := time.Now()
@@ -147,29 +219,74 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 			}
 		}
 		idx := i
-		uploadGroup.Go(func() error {
+		uploadTable := func() error {
+			if uploaded, isUploaded := uploadedRemoteTables[metadata.TableTitle{Database: tablesForUpload[idx].Database, Table: tablesForUpload[idx].Table}]; isUploaded {
+				tablesForUpload[idx] = uploaded.table
+				atomic.AddInt64(&compressedDataSize, uploaded.dataSize)
+				atomic.AddInt64(&metadataSize, int64(len(uploaded.content)))
+				b.addSignedFile(backupName, uploaded.metadataKey, uploaded.content)
+				tableName := fmt.Sprintf("%s.%s", uploaded.table.Database, uploaded.table.Table)
+				log.WithField("table", tableName).Info("already uploaded, skip")
+				progress.tableDone(tableName, uint64(uploaded.dataSize)+uint64(len(uploaded.content)))
+				return nil
+			}
 			var uploadedBytes int64
 			//skip upload data for embedded backup with empty embedded_backup_disk
 			if !schemaOnly && (!b.isEmbedded || b.cfg.ClickHouse.EmbeddedBackupDisk != "") {
 				var files map[string][]string
 				var err error
-				files, uploadedBytes, err = b.uploadTableData(uploadCtx, backupName, deleteSource, tablesForUpload[idx])
+				if b.cfg.General.UploadDedupFiles && !b.isEmbedded {
+					dedupFiles, dedupBytes, dedupErr := b.markDeduplicatedFiles(uploadCtx, backupName, &tablesForUpload[idx], backupMetadata.DiskTypes)
+					if dedupErr != nil {
+						return dedupErr
+					}
+					log.Debugf("%s.%s %d duplicated files with size %s will not upload", tablesForUpload[idx].Database, tablesForUpload[idx].Table, dedupFiles, utils.FormatBytes(uint64(dedupBytes)))
+				}
+				tablesForUpload[idx].ArchiveSize = b.getTableArchiveSize(backupName, tablesForUpload[idx])
+				var paritySpool *parityArchiveSpool
+				if b.cfg.General.ParityShards > 0 && !b.isEmbedded {
+					paritySpool = newParityArchiveSpool()
+					defer func() {
+						if removeErr := paritySpool.remove(); removeErr != nil {
+							log.Warnf("can't remove parity spool files: %v", removeErr)
+						}
+					}()
+				}
+				files, uploadedBytes, err = b.uploadTableData(uploadCtx, backupName, deleteSource, tablesForUpload[idx], paritySpool)
 				if err != nil {
 					return err
 				}
 				atomic.AddInt64(&compressedDataSize, uploadedBytes)
 				tablesForUpload[idx].Files = files
+				tablesForUpload[idx].DataFormat = b.getTableDataFormat(tablesForUpload[idx])
+				if paritySpool != nil && len(files) > 0 {
+					parityGroups, paritySize, parityErr := b.uploadTableParity(uploadCtx, backupName, tablesForUpload[idx], paritySpool)
+					if parityErr != nil {
+						return parityErr
+					}
+					atomic.AddInt64(&compressedDataSize, paritySize)
+					tablesForUpload[idx].ParityGroups = parityGroups
+				}
 			}
 			tableMetadataSize, err := b.uploadTableMetadata(uploadCtx, backupName, tablesForUpload[idx])
 			if err != nil {
 				return err
 			}
 			atomic.AddInt64(&metadataSize, tableMetadataSize)
+			tableName := fmt.Sprintf("%s.%s", tablesForUpload[idx].Database, tablesForUpload[idx].Table)
 			log.
-				WithField("table", fmt.Sprintf("%s.%s", tablesForUpload[idx].Database, tablesForUpload[idx].Table)).
+				WithField("table", tableName).
+				WithField("phase", "upload_table").
 				WithField("duration", utils.HumanizeDuration(time.Since(start))).
 				WithField("size", utils.FormatBytes(uint64(uploadedBytes+tableMetadataSize))).
 				Info("done")
+			progress.tableDone(tableName, uint64(uploadedBytes+tableMetadataSize))
+			return b.runHooks(uploadCtx, hookEvent{Stage: "after_upload_table", Operation: "upload", BackupName: backupName, Table: tableName}, log)
+		}
+		uploadGroup.Go(func() error {
+			if err := uploadTable(); err != nil {
+				return failures.add(uploadCtx, tablesForUpload[idx], err)
+			}
 			return nil
 		})
 	}
@@ -177,27 +294,50 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 		return fmt.Errorf("one of upload table go-routine return error: %v", err)
 	}
 
-	// upload rbac for backup
-	if backupMetadata.RBACSize, err = b.uploadRBACData(ctx, backupName); err != nil {
-		return fmt.Errorf("b.uploadRBACData return error: %v", err)
-	}
+	if onlyFailedBackup != nil {
+		// rbac, configs and keeper znodes were uploaded with other tables
+		backupMetadata.RBACSize, backupMetadata.ConfigSize, backupMetadata.KeeperSize = onlyFailedBackup.RBACSize, onlyFailedBackup.ConfigSize, onlyFailedBackup.KeeperSize
+	} else {
+		// upload rbac for backup
+		if backupMetadata.RBACSize, err = b.uploadRBACData(ctx, backupName); err != nil {
+			return fmt.Errorf("b.uploadRBACData return error: %v", err)
+		}
 
-	// upload configs for backup
-	if backupMetadata.ConfigSize, err = b.uploadConfigData(ctx, backupName); err != nil {
-		return fmt.Errorf("b.uploadConfigData return error: %v", err)
+		// upload configs for backup
+		if backupMetadata.ConfigSize, err = b.uploadConfigData(ctx, backupName); err != nil {
+			return fmt.Errorf("b.uploadConfigData return error: %v", err)
+		}
+
+		// upload keeper znodes for backup
+		if backupMetadata.KeeperSize, err = b.uploadKeeperData(ctx, backupName); err != nil {
+			return fmt.Errorf("b.uploadKeeperData return error: %v", err)
+		}
 	}
 
 	// upload metadata for backup
 	backupMetadata.CompressedSize = uint64(compressedDataSize)
+	if compressionStats := b.dst.GetCompressionStats(); compressionStats != nil {
+		backupMetadata.CompressionStats = compressionStats
+		log.Infof("compression %s level %d..%d (final %d), ratio %.2f, compress %.1fs, network wait %.1fs", compressionStats.Format, compressionStats.MinLevel, compressionStats.MaxLevel, compressionStats.FinalLevel, float64(compressionStats.UncompressedBytes)/float64(max(compressionStats.CompressedBytes, 1)), compressionStats.CompressSeconds, compressionStats.NetworkWaitSeconds)
+	}
 	backupMetadata.MetadataSize = uint64(metadataSize)
-	tt := make([]metadata.TableTitle, len(tablesForUpload))
+	tt := make([]metadata.TableTitle, 0, len(tablesForUpload))
+	if onlyFailedBackup != nil {
+		tt = append(tt, onlyFailedBackup.Tables...)
+		backupMetadata.CompressedSize += onlyFailedBackup.CompressedSize
+		backupMetadata.MetadataSize += onlyFailedBackup.MetadataSize
+	}
 	for i := range tablesForUpload {
-		tt[i] = metadata.TableTitle{
+		if failures.isFailed(tablesForUpload[i]) {
+			continue
+		}
+		tt = append(tt, metadata.TableTitle{
 			Database: tablesForUpload[i].Database,
 			Table:    tablesForUpload[i].Table,
-		}
+		})
 	}
 	backupMetadata.Tables = tt
+	backupMetadata.FailedTables = failures.failed
 	if b.cfg.GetCompressionFormat() != "none" {
 		backupMetadata.DataFormat = b.cfg.GetCompressionFormat()
 	} else {
@@ -208,8 +348,9 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 		return err
 	}
 	remoteBackupMetaFile := path.Join(backupName, "metadata.json")
+	b.addSignedFile(backupName, remoteBackupMetaFile, newBackupMetadataBody)
 	if !b.resume || (b.resume && !b.resumableState.IsAlreadyProcessedBool(remoteBackupMetaFile)) {
-		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		retry := b.newRetrier()
 		err = retry.RunCtx(ctx, func(ctx context.Context) error {
 			return b.dst.PutFile(ctx, remoteBackupMetaFile, io.NopCloser(bytes.NewReader(newBackupMetadataBody)))
 		})
@@ -217,6 +358,13 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 			return fmt.Errorf("can't upload %s: %v", remoteBackupMetaFile, err)
 		}
 	}
+	signatureSize, err := b.uploadSignature(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if err = b.dst.AddToCatalog(ctx, storage.Backup{BackupMetadata: *backupMetadata, UploadDate: time.Now()}); err != nil {
+		log.Warnf("can't add %s to catalog: %v", backupName, err)
+	}
 	if b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk != "" && backupMetadata.Tables != nil && len(backupMetadata.Tables) > 0 {
 		localClickHouseBackupFile := path.Join(b.EmbeddedBackupDataPath, backupName, ".backup")
 		remoteClickHouseBackupFile := path.Join(backupName, ".backup")
@@ -227,9 +375,18 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 	if b.resume {
 		b.resumableState.Close()
 	}
+	uploadedSize := uint64(compressedDataSize) + uint64(metadataSize) + uint64(len(newBackupMetadataBody)) + uint64(signatureSize)
+	if onlyFailedBackup == nil {
+		uploadedSize += backupMetadata.RBACSize + backupMetadata.ConfigSize + backupMetadata.KeeperSize
+	}
+	status.Current.AddBytes(commandId, uploadedSize)
+	// backup with failed_tables is not complete, retention and delete of local source shall wait `upload --only-failed`
+	if err = failures.summary(backupName); err != nil {
+		return err
+	}
 	log.
 		WithField("duration", utils.HumanizeDuration(time.Since(startUpload))).
-		WithField("size", utils.FormatBytes(uint64(compressedDataSize)+uint64(metadataSize)+uint64(len(newBackupMetadataBody))+backupMetadata.RBACSize+backupMetadata.ConfigSize)).
+		WithField("size", utils.FormatBytes(uploadedSize)).
 		Info("done")
 
 	// Remote old backup retention
@@ -250,9 +407,28 @@ func (b *Backuper) Upload(backupName string, deleteSource bool, diffFrom, diffFr
 	return nil
 }
 
+var (
+	retentionPolicyDeletedHookFunc  = func(policyName string) {}
+	retentionPolicyDeletedHookMutex sync.RWMutex
+)
+
+// SetRetentionPolicyDeletedHook - API server counts remote backups deleted by retention policies in metrics, backup shall not depend on metrics
+func SetRetentionPolicyDeletedHook(hook func(policyName string)) {
+	retentionPolicyDeletedHookMutex.Lock()
+	defer retentionPolicyDeletedHookMutex.Unlock()
+	retentionPolicyDeletedHookFunc = hook
+}
+
+func retentionPolicyDeletedHook(policyName string) {
+	retentionPolicyDeletedHookMutex.RLock()
+	defer retentionPolicyDeletedHookMutex.RUnlock()
+	retentionPolicyDeletedHookFunc(policyName)
+}
+
+// RemoveOldBackupsRemote - apply general->backups_to_keep_remote and general->retention_policies
 func (b *Backuper) RemoveOldBackupsRemote(ctx context.Context) error {
 
-	if b.cfg.General.BackupsToKeepRemote < 1 {
+	if b.cfg.General.BackupsToKeepRemote < 1 && len(b.cfg.General.RetentionPolicies) == 0 {
 		return nil
 	}
 	start := time.Now()
@@ -260,27 +436,45 @@ func (b *Backuper) RemoveOldBackupsRemote(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	backupsToDelete := storage.GetBackupsToDeleteRemote(backupList, b.cfg.General.BackupsToKeepRemote)
+	now := time.Now()
+	if b.cfg.General.RetentionRebaseIncrements {
+		backupList = b.rebaseIncrementalBackups(ctx, backupList, now)
+	}
+	backupsToDeleteByPolicy, liveDependents := excludeBackupsWithLiveDependents(backupList, storage.GetBackupsToDeleteRemoteByPolicies(backupList, b.cfg.General.BackupsToKeepRemote, b.cfg.General.RetentionPolicies, now))
+	for backupName, dependents := range liveDependents {
+		status.Current.AddWarning(ctx, b.dst.Log, status.WarningLiveDependents, "skip delete %s, required by %s which are not deleted", backupName, strings.Join(dependents, ", "))
+	}
 	b.dst.Log.WithFields(apexLog.Fields{
 		"operation": "RemoveOldBackupsRemote",
 		"duration":  utils.HumanizeDuration(time.Since(start)),
 	}).Info("calculate backup list for delete remote")
-	for _, backupToDelete := range backupsToDelete {
-		startDelete := time.Now()
-		err = b.cleanEmbeddedAndObjectDiskRemoteIfSameLocalNotPresent(ctx, backupToDelete, b.dst.Log)
-		if err != nil {
-			return err
-		}
+	for policyName, backupsToDelete := range backupsToDeleteByPolicy {
+		for _, backupToDelete := range backupsToDelete {
+			startDelete := time.Now()
+			if lockErr := b.dst.CheckBackupLock(ctx, backupToDelete.BackupName); lockErr != nil {
+				b.dst.Log.WithField("operation", "RemoveOldBackupsRemote").Warnf("skip delete: %v", lockErr)
+				continue
+			}
+			err = b.cleanEmbeddedAndObjectDiskRemoteIfSameLocalNotPresent(ctx, backupToDelete, b.dst.Log)
+			if err != nil {
+				return err
+			}
 
-		if err := b.dst.RemoveBackupRemote(ctx, backupToDelete); err != nil {
-			b.dst.Log.Warnf("can't deleteKey %s return error : %v", backupToDelete.BackupName, err)
+			err := b.dst.RemoveBackupRemote(ctx, backupToDelete)
+			b.writeAuditRecord(ctx, "retention_remote", []string{backupToDelete.BackupName}, err)
+			if err != nil {
+				b.dst.Log.Warnf("can't deleteKey %s return error : %v", backupToDelete.BackupName, err)
+				continue
+			}
+			retentionPolicyDeletedHook(policyName)
+			b.dst.Log.WithFields(apexLog.Fields{
+				"operation": "RemoveOldBackupsRemote",
+				"location":  "remote",
+				"backup":    backupToDelete.BackupName,
+				"policy":    policyName,
+				"duration":  utils.HumanizeDuration(time.Since(startDelete)),
+			}).Info("done")
 		}
-		b.dst.Log.WithFields(apexLog.Fields{
-			"operation": "RemoveOldBackupsRemote",
-			"location":  "remote",
-			"backup":    backupToDelete.BackupName,
-			"duration":  utils.HumanizeDuration(time.Since(startDelete)),
-		}).Info("done")
 	}
 	b.dst.Log.WithFields(apexLog.Fields{"operation": "RemoveOldBackupsRemote", "duration": utils.HumanizeDuration(time.Since(start))}).Info("done")
 	return nil
@@ -300,7 +494,7 @@ func (b *Backuper) uploadSingleBackupFile(ctx context.Context, localFile, remote
 			log.Warnf("can't close %v: %v", f, err)
 		}
 	}()
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := b.newRetrier()
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.PutFile(ctx, remoteFile, f)
 	})
@@ -329,6 +523,80 @@ func (b *Backuper) prepareTableListToUpload(ctx context.Context, backupName stri
 	return tablesForUpload, nil
 }
 
+// enforceIncrementalMaxBaseAge - upload full backup instead of increment, when full backup at the root of increments chain older than general->incremental_max_base_age
+func (b *Backuper) enforceIncrementalMaxBaseAge(ctx context.Context, diffFrom, diffFromRemote string, remoteBackups []storage.Backup, log *apexLog.Entry) (string, string) {
+	backups := make(map[string]metadata.BackupMetadata, len(remoteBackups))
+	for _, remoteBackup := range remoteBackups {
+		backups[remoteBackup.BackupName] = remoteBackup.BackupMetadata
+	}
+	baseBackup := diffFromRemote
+	if diffFrom != "" {
+		baseBackup = diffFrom
+		localBackups, _, err := b.GetLocalBackups(ctx, nil)
+		if err != nil {
+			log.Warnf("can't check general->incremental_max_base_age, GetLocalBackups return error: %v", err)
+			return diffFrom, diffFromRemote
+		}
+		// uploaded backups contain required_backup only in remote metadata
+		for _, localBackup := range localBackups {
+			if _, exists := backups[localBackup.BackupName]; !exists {
+				backups[localBackup.BackupName] = localBackup.BackupMetadata
+			}
+		}
+	}
+	root, exists := incrementalChainRoot(backups, baseBackup)
+	if !exists || root.CreationDate.IsZero() {
+		return diffFrom, diffFromRemote
+	}
+	if age := time.Since(root.CreationDate); age > b.cfg.General.IncrementalMaxBaseAgeDuration {
+		status.Current.AddWarning(ctx, log, status.WarningFallback, "full backup '%s' at the root of '%s' increments chain created %s ago, older than general->incremental_max_base_age=%s, will upload full backup instead of increment", root.BackupName, baseBackup, utils.HumanizeDuration(age), b.cfg.General.IncrementalMaxBaseAge)
+		return "", ""
+	}
+	return diffFrom, diffFromRemote
+}
+
+// incrementalChainRoot - follow required_backup from baseBackup to full backup, missing required backup means the last found backup is the root
+func incrementalChainRoot(backups map[string]metadata.BackupMetadata, baseBackup string) (metadata.BackupMetadata, bool) {
+	root, exists := backups[baseBackup]
+	if !exists {
+		return root, false
+	}
+	visited := map[string]struct{}{baseBackup: {}}
+	for root.RequiredBackup != "" {
+		if _, isVisited := visited[root.RequiredBackup]; isVisited {
+			break
+		}
+		required, exists := backups[root.RequiredBackup]
+		if !exists {
+			break
+		}
+		visited[root.RequiredBackup] = struct{}{}
+		root = required
+	}
+	return root, true
+}
+
+// IncrementalChainLength - how many backups are required to restore backupName including itself, 1 for full backup, 0 when backup is not found
+func IncrementalChainLength(backups []storage.Backup, backupName string) int {
+	backupsByName := make(map[string]metadata.BackupMetadata, len(backups))
+	for _, backup := range backups {
+		backupsByName[backup.BackupName] = backup.BackupMetadata
+	}
+	length := 0
+	visited := map[string]struct{}{}
+	for name := backupName; name != ""; name = backupsByName[name].RequiredBackup {
+		if _, isVisited := visited[name]; isVisited {
+			break
+		}
+		if _, exists := backupsByName[name]; !exists {
+			break
+		}
+		visited[name] = struct{}{}
+		length++
+	}
+	return length
+}
+
 func (b *Backuper) validateUploadParams(ctx context.Context, backupName string, diffFrom string, diffFromRemote string) error {
 	log := b.log.WithField("logger", "validateUploadParams")
 	if b.cfg.General.RemoteStorage == "none" {
@@ -382,20 +650,10 @@ func (b *Backuper) validateUploadParams(ctx context.Context, backupName string,
 		return fmt.Errorf("can't resume for `remote_storage: custom`")
 	}
 	if b.cfg.General.RemoteStorage == "s3" && len(b.cfg.S3.CustomStorageClassMap) > 0 {
-		for pattern, storageClass := range b.cfg.S3.CustomStorageClassMap {
-			re := regexp.MustCompile(pattern)
-			if re.MatchString(backupName) {
-				b.cfg.S3.StorageClass = storageClass
-			}
-		}
+		b.cfg.S3.StorageClass = getRemoteStorageClass(b.cfg, backupName)
 	}
 	if b.cfg.General.RemoteStorage == "gcs" && len(b.cfg.GCS.CustomStorageClassMap) > 0 {
-		for pattern, storageClass := range b.cfg.GCS.CustomStorageClassMap {
-			re := regexp.MustCompile(pattern)
-			if re.MatchString(backupName) {
-				b.cfg.GCS.StorageClass = storageClass
-			}
-		}
+		b.cfg.GCS.StorageClass = getRemoteStorageClass(b.cfg, backupName)
 	}
 	return nil
 }
@@ -432,6 +690,22 @@ func (b *Backuper) uploadRBACData(ctx context.Context, backupName string) (uint6
 	return b.uploadBackupRelatedDir(ctx, rbacBackupPath, accessFilesGlobPattern, remoteRBACArchive)
 }
 
+func (b *Backuper) uploadKeeperData(ctx context.Context, backupName string) (uint64, error) {
+	backupPath := b.DefaultDataPath
+	keeperBackupPath := path.Join(backupPath, "backup", backupName, "keeper")
+	if b.isEmbedded && b.cfg.ClickHouse.EmbeddedBackupDisk != "" {
+		backupPath = b.EmbeddedBackupDataPath
+		keeperBackupPath = path.Join(backupPath, backupName, "keeper")
+	}
+	keeperFilesGlobPattern := path.Join(keeperBackupPath, "*.jsonl")
+	if b.cfg.GetCompressionFormat() == "none" {
+		remoteKeeperDir := path.Join(backupName, "keeper")
+		return b.uploadBackupRelatedDir(ctx, keeperBackupPath, keeperFilesGlobPattern, remoteKeeperDir)
+	}
+	remoteKeeperArchive := path.Join(backupName, fmt.Sprintf("keeper.%s", b.cfg.GetArchiveExtension()))
+	return b.uploadBackupRelatedDir(ctx, keeperBackupPath, keeperFilesGlobPattern, remoteKeeperArchive)
+}
+
 func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelatedDir, localFilesGlobPattern, destinationRemote string) (uint64, error) {
 	if _, err := os.Stat(localBackupRelatedDir); os.IsNotExist(err) {
 		return 0, nil
@@ -461,7 +735,7 @@ func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelate
 	}
 	if b.cfg.GetCompressionFormat() == "none" {
 		remoteUploadedBytes := int64(0)
-		if remoteUploadedBytes, err = b.dst.UploadPath(ctx, localBackupRelatedDir, localFiles, destinationRemote, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, b.cfg.General.UploadMaxBytesPerSecond); err != nil {
+		if remoteUploadedBytes, err = b.dst.UploadPath(ctx, localBackupRelatedDir, localFiles, destinationRemote, b.newRetrier(), b.cfg.General.UploadMaxBytesPerSecond); err != nil {
 			return 0, fmt.Errorf("can't RBAC or config upload %s: %v", destinationRemote, err)
 		}
 		if b.resume {
@@ -469,16 +743,16 @@ func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelate
 		}
 		return uint64(remoteUploadedBytes), nil
 	}
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := b.newRetrier()
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
-		return b.dst.UploadCompressedStream(ctx, localBackupRelatedDir, localFiles, destinationRemote, b.cfg.General.UploadMaxBytesPerSecond)
+		return b.dst.UploadCompressedStream(ctx, localBackupRelatedDir, localFiles, destinationRemote, b.cfg.GetCompressionFormat(), b.cfg.General.UploadMaxBytesPerSecond, nil, nil)
 	})
 	if err != nil {
 		return 0, fmt.Errorf("can't RBAC or config upload compressed %s: %v", destinationRemote, err)
 	}
 
 	var remoteUploaded storage.RemoteFile
-	retry = retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry = b.newRetrier()
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		remoteUploaded, err = b.dst.StatFile(ctx, destinationRemote)
 		return err
@@ -492,27 +766,34 @@ func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelate
 	return uint64(remoteUploaded.Size()), nil
 }
 
-func (b *Backuper) uploadTableData(ctx context.Context, backupName string, deleteSource bool, table metadata.TableMetadata) (map[string][]string, int64, error) {
+// uploadTableData - paritySpool is nil when parity_shards is not used, otherwise each uploaded archive stream is copied to spool
+func (b *Backuper) uploadTableData(ctx context.Context, backupName string, deleteSource bool, table metadata.TableMetadata, paritySpool *parityArchiveSpool) (map[string][]string, int64, error) {
 	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	uploadedFiles := map[string][]string{}
+	partsWithDetached := table.GetPartsWithDetached()
 	capacity := 0
-	for disk := range table.Parts {
-		capacity += len(table.Parts[disk])
+	for disk := range partsWithDetached {
+		capacity += len(partsWithDetached[disk])
 	}
-	log := b.log.WithField("logger", "uploadTableData")
+	log := b.log.WithField("logger", "uploadTableData").WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table))
 	log.Debugf("start %s.%s with concurrency=%d len(table.Parts[...])=%d", table.Database, table.Table, b.cfg.General.UploadConcurrency, capacity)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	dataGroup, ctx := errgroup.WithContext(ctx)
 	dataGroup.SetLimit(int(b.cfg.General.UploadConcurrency))
 	var uploadedBytes int64
+	var dictionary []byte
+	if b.isCompressionDictionaryTable(table) {
+		dictionary = b.compressionDictionary
+	}
+	compressionFormat := b.cfg.GetTableCompressionFormat(table.Database, table.Table)
 
 	splitParts := make(map[string][]metadata.SplitPartFiles)
 	splitPartsOffset := make(map[string]int)
 	splitPartsCapacity := 0
-	for disk := range table.Parts {
+	for disk := range partsWithDetached {
 		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
-		splitPartsList, err := b.splitPartFiles(backupPath, table.Parts[disk])
+		splitPartsList, err := b.splitPartFiles(backupPath, partsWithDetached[disk], compressionFormat, table.ArchiveSize)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -521,7 +802,7 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, delet
 		splitPartsCapacity += len(splitPartsList)
 	}
 	for common.SumMapValuesInt(splitPartsOffset) < splitPartsCapacity {
-		for disk := range table.Parts {
+		for disk := range partsWithDetached {
 			if splitPartsOffset[disk] >= len(splitParts[disk]) {
 				continue
 			}
@@ -530,8 +811,9 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, delet
 			partSuffix := splitPart.Prefix
 			partFiles := splitPart.Files
 			splitPartsOffset[disk] += 1
+			log := log.WithField("disk", disk)
 			baseRemoteDataPath := path.Join(backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
-			if b.cfg.GetCompressionFormat() == "none" {
+			if compressionFormat == "none" {
 				remotePath := path.Join(baseRemoteDataPath, disk)
 				remotePathFull := path.Join(remotePath, partSuffix)
 				dataGroup.Go(func() error {
@@ -541,8 +823,14 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, delet
 							return nil
 						}
 					}
-					log.Debugf("start upload %d files to %s", len(partFiles), remotePath)
-					if uploadPathBytes, err := b.dst.UploadPath(ctx, backupPath, partFiles, remotePath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, b.cfg.General.UploadMaxBytesPerSecond); err != nil {
+					filesToUpload := partFiles
+					if b.remoteObjects != nil {
+						var uploadedPathBytes int64
+						filesToUpload, uploadedPathBytes = b.remoteObjects.notUploadedFiles(backupPath, remotePath, partFiles)
+						atomic.AddInt64(&uploadedBytes, uploadedPathBytes)
+					}
+					log.Debugf("start upload %d files to %s", len(filesToUpload), remotePath)
+					if uploadPathBytes, err := b.dst.UploadPath(ctx, backupPath, filesToUpload, remotePath, b.newRetrier(), b.cfg.General.UploadMaxBytesPerSecond); err != nil {
 						log.Errorf("UploadPath return error: %v", err)
 						return fmt.Errorf("can't upload: %v", err)
 					} else {
@@ -562,7 +850,7 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, delet
 					return nil
 				})
 			} else {
-				fileName := fmt.Sprintf("%s_%s.%s", disk, common.TablePathEncode(partSuffix), b.cfg.GetArchiveExtension())
+				fileName := getArchiveFileName(disk, partSuffix, compressionFormat)
 				uploadedFiles[disk] = append(uploadedFiles[disk], fileName)
 				remoteDataFile := path.Join(baseRemoteDataPath, fileName)
 				localFiles := partFiles
@@ -573,10 +861,32 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, delet
 							return nil
 						}
 					}
+					if b.remoteObjects != nil {
+						if uploadedSize, isUploaded := b.remoteObjects.uploadedArchiveSize(remoteDataFile); isUploaded {
+							log.Debugf("%s already exists on remote storage, skip", remoteDataFile)
+							atomic.AddInt64(&uploadedBytes, uploadedSize)
+							return nil
+						}
+					}
 					log.Debugf("start upload %d files to %s", len(localFiles), remoteDataFile)
-					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+					retry := b.newRetrier()
 					err := retry.RunCtx(ctx, func(ctx context.Context) error {
-						return b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile, b.cfg.General.UploadMaxBytesPerSecond)
+						if paritySpool == nil {
+							return b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile, compressionFormat, b.cfg.General.UploadMaxBytesPerSecond, dictionary, nil)
+						}
+						spoolPath, err := b.dst.GetStagingPath(remoteDataFile, backupPath)
+						if err != nil {
+							return err
+						}
+						spoolFile, err := paritySpool.create(fileName, spoolPath)
+						if err != nil {
+							return fmt.Errorf("can't create parity spool file: %v", err)
+						}
+						err = b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile, compressionFormat, b.cfg.General.UploadMaxBytesPerSecond, dictionary, spoolFile)
+						if closeErr := spoolFile.Close(); err == nil && closeErr != nil {
+							err = fmt.Errorf("can't close parity spool file: %v", closeErr)
+						}
+						return err
 					})
 					if err != nil {
 						log.Errorf("UploadCompressedStream return error: %v", err)
@@ -584,7 +894,7 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, delet
 					}
 
 					var remoteFile storage.RemoteFile
-					retry = retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+					retry = b.newRetrier()
 					err = retry.RunCtx(ctx, func(ctx context.Context) error {
 						remoteFile, err = b.dst.StatFile(ctx, remoteDataFile)
 						return err
@@ -635,12 +945,13 @@ func (b *Backuper) uploadTableMetadataRegular(ctx context.Context, backupName st
 		return 0, fmt.Errorf("can't marshal json: %v", err)
 	}
 	remoteTableMetaFile := path.Join(backupName, "metadata", common.TablePathEncode(tableMetadata.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableMetadata.Table)))
+	b.addSignedFile(backupName, remoteTableMetaFile, content)
 	if b.resume {
 		if isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remoteTableMetaFile); isProcessed {
 			return processedSize, nil
 		}
 	}
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := b.newRetrier()
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.PutFile(ctx, remoteTableMetaFile, io.NopCloser(bytes.NewReader(content)))
 	})
@@ -658,13 +969,20 @@ func (b *Backuper) uploadTableMetadataEmbedded(ctx context.Context, backupName s
 		return 0, nil
 	}
 	remoteTableMetaFile := path.Join(backupName, "metadata", common.TablePathEncode(tableMetadata.Database), fmt.Sprintf("%s.sql", common.TablePathEncode(tableMetadata.Table)))
+	localTableMetaFile := path.Join(b.EmbeddedBackupDataPath, backupName, "metadata", common.TablePathEncode(tableMetadata.Database), fmt.Sprintf("%s.sql", common.TablePathEncode(tableMetadata.Table)))
+	if b.signer != nil {
+		content, err := os.ReadFile(localTableMetaFile)
+		if err != nil {
+			return 0, fmt.Errorf("can't read %s: %v", localTableMetaFile, err)
+		}
+		b.addSignedFile(backupName, remoteTableMetaFile, content)
+	}
 	if b.resume {
 		if isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remoteTableMetaFile); isProcessed {
 			return processedSize, nil
 		}
 	}
 	log := b.log.WithField("logger", "uploadTableMetadataEmbedded")
-	localTableMetaFile := path.Join(b.EmbeddedBackupDataPath, backupName, "metadata", common.TablePathEncode(tableMetadata.Database), fmt.Sprintf("%s.sql", common.TablePathEncode(tableMetadata.Table)))
 	localReader, err := os.Open(localTableMetaFile)
 	if err != nil {
 		return 0, fmt.Errorf("can't open %s: %v", localTableMetaFile, err)
@@ -674,7 +992,7 @@ func (b *Backuper) uploadTableMetadataEmbedded(ctx context.Context, backupName s
 			log.Warnf("can't close %v: %v", localReader, err)
 		}
 	}()
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := b.newRetrier()
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.PutFile(ctx, remoteTableMetaFile, localReader)
 	})
@@ -700,6 +1018,10 @@ func (b *Backuper) markDuplicatedParts(backup *metadata.BackupMetadata, existsTa
 			}
 			existsPartsMap := common.EmptyMap{}
 			for _, p := range existsTable.Parts[disk] {
+				// files of these parts are restored from other parts after download, look general->upload_dedup_files
+				if len(p.DuplicatedFiles) > 0 {
+					continue
+				}
 				existsPartsMap[p.Name] = struct{}{}
 			}
 			for i := range newParts {
@@ -718,10 +1040,54 @@ func (b *Backuper) markDuplicatedParts(backup *metadata.BackupMetadata, existsTa
 				}
 				newParts[i].Required = true
 			}
+			if checkLocal && b.cfg.General.UploadDiffFiles {
+				b.markDuplicatedFiles(backup, existsTable, newTable, disk, newParts)
+			}
+		}
+	}
+}
+
+// markDuplicatedFiles - parts after lightweight DELETE and ALTER UPDATE mutations have new name, but contain hardlinks to unchanged files of source part, these files will not upload
+func (b *Backuper) markDuplicatedFiles(backup *metadata.BackupMetadata, existsTable *metadata.TableMetadata, newTable *metadata.TableMetadata, disk string, newParts []metadata.Part) {
+	log := b.log.WithField("logger", "markDuplicatedFiles")
+	// base part itself shall contain all files in remote storage, to avoid long chains
+	existsPartsByBlock := map[string]string{}
+	for _, p := range existsTable.Parts[disk] {
+		if p.BasePart == "" && len(p.DuplicatedFiles) == 0 {
+			existsPartsByBlock[partBlockName(p.Name)] = p.Name
+		}
+	}
+	dbAndTablePath := path.Join(common.TablePathEncode(newTable.Database), common.TablePathEncode(newTable.Table))
+	for i := range newParts {
+		if newParts[i].Required {
+			continue
+		}
+		basePart, exists := existsPartsByBlock[partBlockName(newParts[i].Name)]
+		if !exists || basePart == newParts[i].Name {
+			continue
+		}
+		existsPath := path.Join(b.DiskToPathMap[disk], "backup", backup.RequiredBackup, "shadow", dbAndTablePath, disk, basePart)
+		newPath := path.Join(b.DiskToPathMap[disk], "backup", backup.BackupName, "shadow", dbAndTablePath, disk, newParts[i].Name)
+		requiredFiles, err := filesystemhelper.GetDuplicatedFiles(existsPath, newPath)
+		if err != nil {
+			log.Debugf("can't compare '%s' and '%s': %v", existsPath, newPath, err)
+			continue
+		}
+		if len(requiredFiles) > 0 {
+			newParts[i].BasePart = basePart
+			newParts[i].RequiredFiles = requiredFiles
 		}
 	}
 }
 
+// partBlockName - part name without mutation version, all_1_1_0_5 -> all_1_1_0
+func partBlockName(partName string) string {
+	if fields := strings.Split(partName, "_"); len(fields) >= 5 {
+		return strings.Join(fields[:len(fields)-1], "_")
+	}
+	return partName
+}
+
 func (b *Backuper) ReadBackupMetadataLocal(ctx context.Context, backupName string) (*metadata.BackupMetadata, error) {
 	var backupMetadataBody []byte
 	var err error
@@ -763,15 +1129,47 @@ bodyRead:
 	}
 }
 
-func (b *Backuper) splitPartFiles(basePath string, parts []metadata.Part) ([]metadata.SplitPartFiles, error) {
+// getArchiveFileName - name of archive in table remote data path for files split by splitPartFiles
+func getArchiveFileName(disk, partSuffix, compressionFormat string) string {
+	return fmt.Sprintf("%s_%s.%s", disk, common.TablePathEncode(partSuffix), config.ArchiveExtensions[compressionFormat])
+}
+
+// getTableDataFormat - empty when table data uploaded with compression_format of backup, look general->table_compression_format
+func (b *Backuper) getTableDataFormat(table metadata.TableMetadata) string {
+	compressionFormat := b.cfg.GetTableCompressionFormat(table.Database, table.Table)
+	if compressionFormat == b.cfg.GetCompressionFormat() {
+		return ""
+	}
+	if compressionFormat == "none" {
+		return DirectoryFormat
+	}
+	return compressionFormat
+}
+
+// splitPartFiles - archiveSize is table.ArchiveSize, 0 means general->max_file_size
+func (b *Backuper) splitPartFiles(basePath string, parts []metadata.Part, compressionFormat string, archiveSize int64) ([]metadata.SplitPartFiles, error) {
 	if b.cfg.General.UploadByPart {
-		return b.splitFilesByName(basePath, parts)
+		return b.splitFilesByName(basePath, parts, compressionFormat)
 	} else {
-		return b.splitFilesBySize(basePath, parts)
+		return b.splitFilesBySize(basePath, parts, compressionFormat, archiveSize)
 	}
 }
 
-func (b *Backuper) splitFilesByName(basePath string, parts []metadata.Part) ([]metadata.SplitPartFiles, error) {
+// isRequiredFile - file will download from part.BasePart in required backup
+func isRequiredFile(partPath, filePath string, part metadata.Part) bool {
+	if len(part.RequiredFiles) == 0 {
+		return false
+	}
+	relativePath := strings.TrimPrefix(strings.TrimPrefix(filePath, partPath), "/")
+	for _, requiredFile := range part.RequiredFiles {
+		if requiredFile == relativePath {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backuper) splitFilesByName(basePath string, parts []metadata.Part, compressionFormat string) ([]metadata.SplitPartFiles, error) {
 	log := b.log.WithField("logger", "splitFilesByName")
 	result := make([]metadata.SplitPartFiles, 0)
 	for i := range parts {
@@ -779,36 +1177,128 @@ func (b *Backuper) splitFilesByName(basePath string, parts []metadata.Part) ([]m
 			continue
 		}
 		var files []string
+		var sizes []int64
 		partPath := path.Join(basePath, parts[i].Name)
 		err := filepath.Walk(partPath, func(filePath string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			if !info.Mode().IsRegular() {
+			if !info.Mode().IsRegular() || isRequiredFile(partPath, filePath, parts[i]) || isDuplicatedFile(partPath, filePath, parts[i]) {
 				return nil
 			}
 			relativePath := strings.TrimPrefix(filePath, basePath)
 			files = append(files, relativePath)
+			sizes = append(sizes, b.archiveEntrySize(info.Size(), compressionFormat))
 			return nil
 		})
 		if err != nil {
 			log.Warnf("filepath.Walk return error: %v", err)
 		}
-		result = append(result, metadata.SplitPartFiles{
-			Prefix: parts[i].Name,
-			Files:  files,
-		})
+		result = append(result, splitPartArchives(parts[i].Name, files, sizes, b.alignArchiveSize(b.cfg.General.UploadPartArchiveSize, compressionFormat), b.cfg.General.UploadPartMaxArchives)...)
 	}
 	return result, nil
 }
 
-func (b *Backuper) splitFilesBySize(basePath string, parts []metadata.Part) ([]metadata.SplitPartFiles, error) {
-	log := b.log.WithField("logger", "splitFilesBySize")
-	var size int64
+// splitPartArchives - split files of one big part into several archives which upload concurrently, the biggest files are placed first into the least filled archive
+// first archive keeps part name, so findDiffOnePartArchive and old versions still find it, other archives look partArchiveChunkPrefix
+func splitPartArchives(partName string, files []string, sizes []int64, archiveSize int64, maxArchives int) []metadata.SplitPartFiles {
+	var totalSize int64
+	for _, size := range sizes {
+		totalSize += size
+	}
+	if archiveSize <= 0 || totalSize <= archiveSize || len(files) < 2 {
+		return []metadata.SplitPartFiles{{Prefix: partName, Files: files}}
+	}
+	archivesCount := int((totalSize + archiveSize - 1) / archiveSize)
+	if maxArchives > 0 && archivesCount > maxArchives {
+		archivesCount = maxArchives
+	}
+	if archivesCount > len(files) {
+		archivesCount = len(files)
+	}
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sizes[order[i]] > sizes[order[j]]
+	})
+	archiveFiles := make([][]string, archivesCount)
+	archiveSizes := make([]int64, archivesCount)
+	for _, i := range order {
+		smallest := 0
+		for n := 1; n < archivesCount; n++ {
+			if archiveSizes[n] < archiveSizes[smallest] {
+				smallest = n
+			}
+		}
+		archiveFiles[smallest] = append(archiveFiles[smallest], files[i])
+		archiveSizes[smallest] += sizes[i]
+	}
+	result := make([]metadata.SplitPartFiles, 0, archivesCount)
+	for n := range archiveFiles {
+		prefix := partName
+		if n > 0 {
+			prefix = fmt.Sprintf("%s.%d", partName, n+1)
+		}
+		result = append(result, metadata.SplitPartFiles{Prefix: prefix, Files: archiveFiles[n]})
+	}
+	return result
+}
+
+// tarBlockSize - tar header and file content padding size, tar archive ends with two empty blocks
+const tarBlockSize = 512
+
+// alignArchiveSize - round archive split size down to a multiple of remote storage multipart part size when general->upload_align_multipart_parts is true,
+// so each archive uploads as whole parts without tiny last part, for tar archive the end of archive blocks are reserved
+func (b *Backuper) alignArchiveSize(archiveSize int64, compressionFormat string) int64 {
+	if !b.cfg.General.UploadAlignMultipartParts {
+		return archiveSize
+	}
+	reserved := int64(0)
+	if compressionFormat == "tar" {
+		reserved = 2 * tarBlockSize
+	}
+	return alignToPartSize(archiveSize, storage.GetMultipartPartSize(b.cfg), reserved)
+}
+
+// archiveEntrySize - size which file takes in archive, tar header and padding counted only when split size aligned to multipart part size
+func (b *Backuper) archiveEntrySize(size int64, compressionFormat string) int64 {
+	if !b.cfg.General.UploadAlignMultipartParts || compressionFormat != "tar" {
+		return size
+	}
+	return tarBlockSize + (size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+}
+
+func alignToPartSize(archiveSize, partSize, reserved int64) int64 {
+	if archiveSize <= 0 || partSize <= 0 || archiveSize < partSize {
+		return archiveSize
+	}
+	aligned := archiveSize - archiveSize%partSize
+	if aligned > reserved {
+		aligned -= reserved
+	}
+	return aligned
+}
+
+// partArchiveChunkPrefix - archive name prefix for second and next archives of one part created by splitPartArchives, TablePathEncode replaces "." to %2E
+func partArchiveChunkPrefix(disk, partName string) string {
+	return fmt.Sprintf("%s_%s%%2E", disk, common.TablePathEncode(partName))
+}
+
+func (b *Backuper) splitFilesBySize(basePath string, parts []metadata.Part, compressionFormat string, archiveSize int64) ([]metadata.SplitPartFiles, error) {
+	files, sizes := b.collectPartFiles(basePath, parts, compressionFormat)
+	if archiveSize <= 0 {
+		archiveSize = b.alignArchiveSize(b.cfg.General.MaxFileSize, compressionFormat)
+	}
+	return splitFilesByArchiveSize(files, sizes, archiveSize), nil
+}
+
+// collectPartFiles - files which will upload from parts, relative to basePath, sizes are calculated with archiveEntrySize
+func (b *Backuper) collectPartFiles(basePath string, parts []metadata.Part, compressionFormat string) ([]string, []int64) {
+	log := b.log.WithField("logger", "collectPartFiles")
 	var files []string
-	maxSize := b.cfg.General.MaxFileSize
-	result := make([]metadata.SplitPartFiles, 0)
-	partSuffix := 1
+	var sizes []int64
 	for i := range parts {
 		if parts[i].Required {
 			continue
@@ -818,32 +1308,132 @@ func (b *Backuper) splitFilesBySize(basePath string, parts []metadata.Part) ([]m
 			if err != nil {
 				return err
 			}
-			if !info.Mode().IsRegular() {
+			if !info.Mode().IsRegular() || isRequiredFile(partPath, filePath, parts[i]) || isDuplicatedFile(partPath, filePath, parts[i]) {
 				return nil
 			}
-			if (size+info.Size()) > maxSize && len(files) > 0 {
-				result = append(result, metadata.SplitPartFiles{
-					Prefix: strconv.Itoa(partSuffix),
-					Files:  files,
-				})
-				files = []string{}
-				size = 0
-				partSuffix += 1
-			}
-			relativePath := strings.TrimPrefix(filePath, basePath)
-			files = append(files, relativePath)
-			size += info.Size()
+			files = append(files, strings.TrimPrefix(filePath, basePath))
+			sizes = append(sizes, b.archiveEntrySize(info.Size(), compressionFormat))
 			return nil
 		})
 		if err != nil {
 			log.Warnf("filepath.Walk return error: %v", err)
 		}
 	}
-	if len(files) > 0 {
+	return files, sizes
+}
+
+// splitFilesByArchiveSize - files are placed into archives in the same order, next archive starts when archiveSize is exceeded
+func splitFilesByArchiveSize(files []string, sizes []int64, archiveSize int64) []metadata.SplitPartFiles {
+	result := make([]metadata.SplitPartFiles, 0)
+	var size int64
+	var archiveFiles []string
+	for i := range files {
+		if (size+sizes[i]) > archiveSize && len(archiveFiles) > 0 {
+			result = append(result, metadata.SplitPartFiles{
+				Prefix: strconv.Itoa(len(result) + 1),
+				Files:  archiveFiles,
+			})
+			archiveFiles = []string{}
+			size = 0
+		}
+		archiveFiles = append(archiveFiles, files[i])
+		size += sizes[i]
+	}
+	if len(archiveFiles) > 0 {
 		result = append(result, metadata.SplitPartFiles{
-			Prefix: strconv.Itoa(partSuffix),
-			Files:  files,
+			Prefix: strconv.Itoa(len(result) + 1),
+			Files:  archiveFiles,
 		})
 	}
-	return result, nil
+	return result
+}
+
+// countArchivesBySize - archives count which splitFilesByArchiveSize creates for files on each disk
+func countArchivesBySize(diskSizes [][]int64, archiveSize int64) int {
+	count := 0
+	for _, sizes := range diskSizes {
+		var size int64
+		archiveFiles := 0
+		for i := range sizes {
+			if (size+sizes[i]) > archiveSize && archiveFiles > 0 {
+				count++
+				size, archiveFiles = 0, 0
+			}
+			size += sizes[i]
+			archiveFiles++
+		}
+		if archiveFiles > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// balanceArchiveSize - when isBalanced, maxSize decreases to make archives of similar size without tiny last archive, archives count is kept,
+// then size increases until archives count of table is not greater than maxArchives, the biggest archive defines retry cost, so the smallest suitable size is selected
+func balanceArchiveSize(diskSizes [][]int64, maxSize int64, maxArchives int, isBalanced bool) int64 {
+	totalSize := int64(0)
+	for _, sizes := range diskSizes {
+		for _, size := range sizes {
+			totalSize += size
+		}
+	}
+	if maxSize <= 0 || totalSize <= maxSize {
+		return maxSize
+	}
+	archiveSize := maxSize
+	count := countArchivesBySize(diskSizes, archiveSize)
+	if isBalanced {
+		archiveSize = smallestArchiveSize(diskSizes, (totalSize+int64(count)-1)/int64(count), maxSize, count)
+	}
+	if maxArchives > 0 && count > maxArchives {
+		archiveSize = smallestArchiveSize(diskSizes, archiveSize, totalSize, maxArchives)
+	}
+	return archiveSize
+}
+
+// smallestArchiveSize - the smallest size in [low, high] which splits files into not more than maxCount archives, archives count decreases when size increases
+func smallestArchiveSize(diskSizes [][]int64, low, high int64, maxCount int) int64 {
+	for low < high {
+		middle := low + (high-low)/2
+		if countArchivesBySize(diskSizes, middle) <= maxCount {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	return low
+}
+
+// getTableArchiveSize - split size of table data archives for upload_by_part: false, look balanceArchiveSize and general->upload_max_table_archives,
+// selected size is saved into resumable state, so `upload --resume` creates the same archives even when files were deleted with --delete-source or config was changed
+func (b *Backuper) getTableArchiveSize(backupName string, table metadata.TableMetadata) int64 {
+	compressionFormat := b.cfg.GetTableCompressionFormat(table.Database, table.Table)
+	if b.cfg.General.UploadByPart || compressionFormat == "none" {
+		return 0
+	}
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	resumableKey := path.Join(backupName, "shadow", dbAndTablePath, "archive_size")
+	if b.resume && b.resumableState != nil {
+		if isProcessed, archiveSize := b.resumableState.IsAlreadyProcessed(resumableKey); isProcessed {
+			return archiveSize
+		}
+	}
+	partsWithDetached := table.GetPartsWithDetached()
+	disks := make([]string, 0, len(partsWithDetached))
+	for disk := range partsWithDetached {
+		disks = append(disks, disk)
+	}
+	sort.Strings(disks)
+	diskSizes := make([][]int64, 0, len(disks))
+	for _, disk := range disks {
+		_, sizes := b.collectPartFiles(b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath), partsWithDetached[disk], compressionFormat)
+		diskSizes = append(diskSizes, sizes)
+	}
+	maxSize := b.alignArchiveSize(b.cfg.General.MaxFileSize, compressionFormat)
+	archiveSize := balanceArchiveSize(diskSizes, maxSize, b.cfg.General.UploadMaxTableArchives, !b.cfg.General.UploadAlignMultipartParts)
+	if b.resume && b.resumableState != nil {
+		b.resumableState.AppendToState(resumableKey, archiveSize)
+	}
+	return archiveSize
 }
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+)
+
+// WithOnlyFailed - `upload --only-failed`, upload only `failed_tables` of remote backup and add them to `tables` of the same remote backup
+func WithOnlyFailed(onlyFailed bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.onlyFailed = onlyFailed
+	}
+}
+
+// uploadFailures - general->upload_failure_policy, collects failed tables and breaks upload of other tables when policy doesn't allow more failures
+type uploadFailures struct {
+	policy    string
+	maxFailed int
+	mutex     sync.Mutex
+	failed    []metadata.FailedTable
+}
+
+func newUploadFailures(policy string, maxFailed int) *uploadFailures {
+	return &uploadFailures{policy: policy, maxFailed: maxFailed}
+}
+
+// add - returns error which shall stop upload of other tables, nil when upload of other tables could continue
+func (f *uploadFailures) add(ctx context.Context, table metadata.TableMetadata, err error) error {
+	// canceled upload is not failure of table
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	if f.policy != "continue" && f.policy != "threshold" {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failed = append(f.failed, metadata.FailedTable{Database: table.Database, Table: table.Table, Error: err.Error()})
+	if f.policy == "threshold" && len(f.failed) >= f.maxFailed {
+		return fmt.Errorf("%d tables failed, reached general->upload_max_failed_tables, last error: %v", len(f.failed), err)
+	}
+	return nil
+}
+
+// isFailed - table will not be added to `tables` of backup metadata
+func (f *uploadFailures) isFailed(table metadata.TableMetadata) bool {
+	for _, failed := range f.failed {
+		if failed.Database == table.Database && failed.Table == table.Table {
+			return true
+		}
+	}
+	return false
+}
+
+// summary - failed tables, returned after metadata.json with `failed_tables` uploaded
+func (f *uploadFailures) summary(backupName string) error {
+	if len(f.failed) == 0 {
+		return nil
+	}
+	tables := make([]string, len(f.failed))
+	for i, failed := range f.failed {
+		tables[i] = fmt.Sprintf("%s.%s: %s", failed.Database, failed.Table, failed.Error)
+	}
+	return fmt.Errorf("%d tables failed to upload, use `upload --only-failed %s` to finish upload: %s", len(f.failed), backupName, strings.Join(tables, "; "))
+}
+
+// getRemoteBackupForOnlyFailed - `upload --only-failed` continues exists remote backup, files of remote backup which cover all tables can't be extended
+func (b *Backuper) getRemoteBackupForOnlyFailed(backupName string, remoteBackups []storage.Backup) (*storage.Backup, error) {
+	for i := range remoteBackups {
+		if remoteBackups[i].BackupName != backupName {
+			continue
+		}
+		if remoteBackups[i].CompressionDictionary != "" {
+			return nil, fmt.Errorf("'%s' uploaded with compression dictionary, --only-failed is not supported", backupName)
+		}
+		if b.cfg.General.SigningPrivateKeyFile != "" {
+			return nil, fmt.Errorf("--only-failed is not supported with general->signing_private_key_file, signature of '%s' can't be extended", backupName)
+		}
+		return &remoteBackups[i], nil
+	}
+	return nil, fmt.Errorf("'%s' not found on remote storage, --only-failed requires remote backup with failed_tables", backupName)
+}
+
+// filterFailedTables - tables of local backup which are present in `failed_tables` of remote backup
+func filterFailedTables(tables ListOfTables, failedTables []metadata.FailedTable) ListOfTables {
+	result := make(ListOfTables, 0, len(failedTables))
+	for _, table := range tables {
+		for _, failed := range failedTables {
+			if failed.Database == table.Database && failed.Table == table.Table {
+				result = append(result, table)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadFailures(t *testing.T) {
+	ctx := context.Background()
+	t1 := metadata.TableMetadata{Database: "db", Table: "t1"}
+	t2 := metadata.TableMetadata{Database: "db", Table: "t2"}
+	t3 := metadata.TableMetadata{Database: "db", Table: "t3"}
+	uploadErr := errors.New("upload error")
+
+	failFast := newUploadFailures("fail_fast", 0)
+	assert.ErrorIs(t, failFast.add(ctx, t1, uploadErr), uploadErr)
+	assert.Empty(t, failFast.failed)
+	assert.NoError(t, failFast.summary("backup1"))
+
+	continueFailures := newUploadFailures("continue", 0)
+	assert.NoError(t, continueFailures.add(ctx, t1, uploadErr))
+	assert.NoError(t, continueFailures.add(ctx, t2, uploadErr))
+	assert.True(t, continueFailures.isFailed(t2))
+	assert.False(t, continueFailures.isFailed(t3))
+	assert.ErrorContains(t, continueFailures.summary("backup1"), "2 tables failed to upload, use `upload --only-failed backup1`")
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, continueFailures.add(canceledCtx, t3, context.Canceled), context.Canceled)
+	assert.Len(t, continueFailures.failed, 2)
+
+	threshold := newUploadFailures("threshold", 2)
+	assert.NoError(t, threshold.add(ctx, t1, uploadErr))
+	assert.ErrorContains(t, threshold.add(ctx, t2, uploadErr), "reached general->upload_max_failed_tables")
+	assert.Len(t, threshold.failed, 2)
+
+	tables := ListOfTables{t1, t2, t3}
+	assert.Equal(t, ListOfTables{t1, t3}, filterFailedTables(tables, []metadata.FailedTable{{Database: "db", Table: "t3"}, {Database: "db", Table: "t1"}}))
+}
@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+)
+
+// WithResumeRemote - `upload --resume-remote`, continue upload into remote backup without metadata.json, left by interrupted `upload` or `create_remote`, when local resumable state is lost
+func WithResumeRemote(resumeRemote bool) BackuperOpt {
+	return func(b *Backuper) {
+		b.resumeRemote = resumeRemote
+	}
+}
+
+// remoteObjects - objects of incomplete remote backup, listed once before `upload --resume-remote`
+type remoteObjects struct {
+	sizes map[string]int64
+	// trustArchives - object storages create object only when upload finished, so exists archive is complete, sftp and ftp could keep partially written file
+	trustArchives bool
+}
+
+// uploadedArchiveSize - size of exists archive, which shall not upload again
+func (o *remoteObjects) uploadedArchiveSize(key string) (int64, bool) {
+	if !o.trustArchives {
+		return 0, false
+	}
+	size, exists := o.sizes[key]
+	return size, exists && size > 0
+}
+
+// notUploadedFiles - files of `compression_format: none` which are absent in remote backup or have other size, and size of files which already uploaded
+func (o *remoteObjects) notUploadedFiles(localPath, remotePath string, files []string) ([]string, int64) {
+	result := make([]string, 0, len(files))
+	uploadedBytes := int64(0)
+	for _, f := range files {
+		remoteSize, exists := o.sizes[path.Join(remotePath, f)]
+		if exists {
+			if info, err := os.Stat(filepath.Clean(path.Join(localPath, f))); err == nil && info.Size() == remoteSize {
+				uploadedBytes += remoteSize
+				continue
+			}
+		}
+		result = append(result, f)
+	}
+	return result, uploadedBytes
+}
+
+// uploadedRemoteTable - table of incomplete remote backup, which metadata was uploaded after all data, so table will not upload again
+type uploadedRemoteTable struct {
+	table       metadata.TableMetadata
+	metadataKey string
+	content     []byte
+	dataSize    int64
+}
+
+// getRemoteBackupForResume - nil when remote backup doesn't exist and upload shall start from scratch
+func (b *Backuper) getRemoteBackupForResume(backupName string, remoteBackups []storage.Backup) (*storage.Backup, error) {
+	if b.isEmbedded {
+		return nil, fmt.Errorf("--resume-remote is not supported for embedded backup '%s'", backupName)
+	}
+	if b.cfg.General.CompressionDictionaryMaxTableSize > 0 {
+		return nil, fmt.Errorf("--resume-remote is not supported with general->compression_dictionary_max_table_size, new dictionary can't decompress uploaded archives")
+	}
+	for i := range remoteBackups {
+		if remoteBackups[i].BackupName != backupName {
+			continue
+		}
+		if remoteBackups[i].Broken == "" {
+			return nil, fmt.Errorf("'%s' already exists on remote storage and contains metadata.json, nothing to resume", backupName)
+		}
+		return &remoteBackups[i], nil
+	}
+	return nil, nil
+}
+
+// reconcileRemoteBackup - list objects of incomplete remote backup, tables with uploaded metadata and all archives will not upload again, other tables upload only absent objects
+func (b *Backuper) reconcileRemoteBackup(ctx context.Context, backupName string, tables ListOfTables, log *apexLog.Entry) (map[metadata.TableTitle]uploadedRemoteTable, error) {
+	objects := &remoteObjects{
+		sizes:         map[string]int64{},
+		trustArchives: b.dst.Kind() != "SFTP" && b.dst.Kind() != "FTP",
+	}
+	walkErr := b.dst.Walk(ctx, backupName+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		objects.sizes[path.Join(backupName, f.Name())] = f.Size()
+		return nil
+	})
+	if walkErr != nil {
+		return nil, fmt.Errorf("can't list remote backup %s: %v", backupName, walkErr)
+	}
+	uploadedTables := map[metadata.TableTitle]uploadedRemoteTable{}
+	for _, table := range tables {
+		metadataKey := path.Join(backupName, "metadata", common.TablePathEncode(table.Database), fmt.Sprintf("%s.json", common.TablePathEncode(table.Table)))
+		if _, exists := objects.sizes[metadataKey]; !exists {
+			continue
+		}
+		var content []byte
+		retry := b.newRetrier()
+		err := retry.RunCtx(ctx, func(ctx context.Context) error {
+			reader, err := b.dst.GetFileReader(ctx, metadataKey)
+			if err != nil {
+				return err
+			}
+			if content, err = io.ReadAll(reader); err != nil {
+				_ = reader.Close()
+				return err
+			}
+			return reader.Close()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %v", metadataKey, err)
+		}
+		var remoteTable metadata.TableMetadata
+		if err = json.Unmarshal(content, &remoteTable); err != nil {
+			log.Warnf("%s is broken and will upload again: %v", metadataKey, err)
+			continue
+		}
+		dataSize, verified := objects.verifyTableFiles(backupName, remoteTable)
+		if !verified {
+			log.Warnf("%s.%s archives are incomplete on remote storage and will upload again", table.Database, table.Table)
+			continue
+		}
+		uploadedTables[metadata.TableTitle{Database: table.Database, Table: table.Table}] = uploadedRemoteTable{
+			table:       remoteTable,
+			metadataKey: metadataKey,
+			content:     content,
+			dataSize:    dataSize,
+		}
+	}
+	b.remoteObjects = objects
+	log.Infof("%d objects already exist on remote storage, %d of %d tables are completely uploaded", len(objects.sizes), len(uploadedTables), len(tables))
+	return uploadedTables, nil
+}
+
+// verifyTableFiles - all archives listed in table metadata exist, table uploaded with `compression_format: none` lists no archives and is checked file by file during upload
+func (o *remoteObjects) verifyTableFiles(backupName string, table metadata.TableMetadata) (int64, bool) {
+	if len(table.Files) == 0 && len(table.Parts) > 0 {
+		return 0, false
+	}
+	dataSize := int64(0)
+	baseRemoteDataPath := path.Join(backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for _, files := range table.Files {
+		for _, f := range files {
+			size, exists := o.sizes[path.Join(baseRemoteDataPath, f)]
+			if !exists || size == 0 {
+				return 0, false
+			}
+			dataSize += size
+		}
+	}
+	return dataSize, true
+}
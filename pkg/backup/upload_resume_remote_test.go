@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteObjects(t *testing.T) {
+	localPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(localPath, "all_1_1_0"), 0750))
+	require.NoError(t, os.WriteFile(path.Join(localPath, "all_1_1_0", "data.bin"), []byte("12345"), 0640))
+	require.NoError(t, os.WriteFile(path.Join(localPath, "all_1_1_0", "count.txt"), []byte("5"), 0640))
+	require.NoError(t, os.WriteFile(path.Join(localPath, "all_1_1_0", "columns.txt"), []byte("columns"), 0640))
+
+	objects := &remoteObjects{
+		sizes: map[string]int64{
+			"backup1/shadow/db/t1/default/all_1_1_0/data.bin":  5,
+			"backup1/shadow/db/t1/default/all_1_1_0/count.txt": 2,
+			"backup1/shadow/db/t2/default_all_1_1_0.tar.gz":    100,
+			"backup1/shadow/db/t2/default_all_2_2_0.tar.gz":    0,
+		},
+		trustArchives: true,
+	}
+	files, uploadedBytes := objects.notUploadedFiles(localPath, "backup1/shadow/db/t1/default", []string{"all_1_1_0/data.bin", "all_1_1_0/count.txt", "all_1_1_0/columns.txt"})
+	assert.Equal(t, []string{"all_1_1_0/count.txt", "all_1_1_0/columns.txt"}, files)
+	assert.Equal(t, int64(5), uploadedBytes)
+
+	size, isUploaded := objects.uploadedArchiveSize("backup1/shadow/db/t2/default_all_1_1_0.tar.gz")
+	assert.True(t, isUploaded)
+	assert.Equal(t, int64(100), size)
+	_, isUploaded = objects.uploadedArchiveSize("backup1/shadow/db/t2/default_all_2_2_0.tar.gz")
+	assert.False(t, isUploaded)
+	objects.trustArchives = false
+	_, isUploaded = objects.uploadedArchiveSize("backup1/shadow/db/t2/default_all_1_1_0.tar.gz")
+	assert.False(t, isUploaded, "sftp and ftp could contain partially written archive")
+
+	table := metadata.TableMetadata{
+		Database: "db",
+		Table:    "t2",
+		Parts:    map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}},
+		Files:    map[string][]string{"default": {"default_all_1_1_0.tar.gz"}},
+	}
+	dataSize, verified := objects.verifyTableFiles("backup1", table)
+	assert.True(t, verified)
+	assert.Equal(t, int64(100), dataSize)
+	table.Files["default"] = append(table.Files["default"], "default_all_2_2_0.tar.gz")
+	_, verified = objects.verifyTableFiles("backup1", table)
+	assert.False(t, verified)
+	table.Files = nil
+	_, verified = objects.verifyTableFiles("backup1", table)
+	assert.False(t, verified, "compression_format: none table is checked file by file")
+}
@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartBlockName(t *testing.T) {
+	assert.Equal(t, "all_1_1_0", partBlockName("all_1_1_0"))
+	assert.Equal(t, "all_1_1_0", partBlockName("all_1_1_0_5"))
+	assert.Equal(t, "202401_3_8_2", partBlockName("202401_3_8_2_12"))
+	assert.Equal(t, partBlockName("all_1_1_0_3"), partBlockName("all_1_1_0_5"))
+}
+
+func TestIsRequiredFile(t *testing.T) {
+	part := metadata.Part{Name: "all_1_1_0_5", BasePart: "all_1_1_0", RequiredFiles: []string{"data.bin", "projection.proj/data.bin"}}
+	partPath := "/var/lib/clickhouse/backup/test/shadow/default/t1/default/all_1_1_0_5"
+	assert.True(t, isRequiredFile(partPath, partPath+"/data.bin", part))
+	assert.True(t, isRequiredFile(partPath, partPath+"/projection.proj/data.bin", part))
+	assert.False(t, isRequiredFile(partPath, partPath+"/checksums.txt", part))
+	assert.False(t, isRequiredFile(partPath, partPath+"/data.bin", metadata.Part{Name: "all_1_1_0_5"}))
+}
+
+func TestSplitPartArchives(t *testing.T) {
+	files := []string{"/all_1_1_0/checksums.txt", "/all_1_1_0/a.bin", "/all_1_1_0/b.bin", "/all_1_1_0/c.bin", "/all_1_1_0/d.bin"}
+	sizes := []int64{1, 100, 60, 50, 40}
+
+	assert.Equal(t, []metadata.SplitPartFiles{{Prefix: "all_1_1_0", Files: files}}, splitPartArchives("all_1_1_0", files, sizes, 0, 16))
+	assert.Equal(t, []metadata.SplitPartFiles{{Prefix: "all_1_1_0", Files: files}}, splitPartArchives("all_1_1_0", files, sizes, 1000, 16))
+
+	result := splitPartArchives("all_1_1_0", files, sizes, 100, 16)
+	assert.Equal(t, []metadata.SplitPartFiles{
+		{Prefix: "all_1_1_0", Files: []string{"/all_1_1_0/a.bin"}},
+		{Prefix: "all_1_1_0.2", Files: []string{"/all_1_1_0/b.bin", "/all_1_1_0/checksums.txt"}},
+		{Prefix: "all_1_1_0.3", Files: []string{"/all_1_1_0/c.bin", "/all_1_1_0/d.bin"}},
+	}, result)
+
+	assert.Len(t, splitPartArchives("all_1_1_0", files, sizes, 10, 2), 2)
+	assert.Equal(t, "default_all_1_1_0%2E", partArchiveChunkPrefix("default", "all_1_1_0"))
+	assert.Contains(t, "default_all_1_1_0%2E2.tar", partArchiveChunkPrefix("default", "all_1_1_0"))
+}
+
+func TestBalanceArchiveSize(t *testing.T) {
+	files := []string{"/all_1_1_0/a.bin", "/all_1_1_0/b.bin", "/all_2_2_0/a.bin", "/all_2_2_0/b.bin", "/all_3_3_0/a.bin"}
+	sizes := []int64{30, 30, 30, 30, 10}
+	// 90 + 40 without balance
+	assert.Equal(t, 2, countArchivesBySize([][]int64{sizes}, 100))
+	assert.Equal(t, int64(100), balanceArchiveSize([][]int64{sizes}, 100, 0, false))
+	// 60 + 70 with balance
+	archiveSize := balanceArchiveSize([][]int64{sizes}, 100, 0, true)
+	assert.Equal(t, int64(70), archiveSize)
+	assert.Equal(t, []metadata.SplitPartFiles{
+		{Prefix: "1", Files: files[:2]},
+		{Prefix: "2", Files: files[2:]},
+	}, splitFilesByArchiveSize(files, sizes, archiveSize))
+	// max archives per table on all disks
+	archiveSize = balanceArchiveSize([][]int64{sizes, sizes}, 30, 4, false)
+	assert.Equal(t, int64(70), archiveSize)
+	assert.Equal(t, 4, countArchivesBySize([][]int64{sizes, sizes}, archiveSize))
+	// each disk has own archives
+	assert.Equal(t, int64(130), balanceArchiveSize([][]int64{sizes, sizes}, 30, 3, true))
+	assert.Equal(t, int64(300), balanceArchiveSize([][]int64{sizes}, 300, 1, true))
+	assert.Equal(t, int64(0), balanceArchiveSize([][]int64{sizes}, 0, 1, true))
+	assert.Len(t, splitFilesByArchiveSize(files, sizes, 0), len(files))
+}
+
+func TestAlignToPartSize(t *testing.T) {
+	// 1GiB max_file_size with 5MiB s3 part size aligned down to 204 parts
+	assert.Equal(t, int64(204*5*1024*1024), alignToPartSize(1024*1024*1024, 5*1024*1024, 0))
+	assert.Equal(t, int64(204*5*1024*1024-2*tarBlockSize), alignToPartSize(1024*1024*1024, 5*1024*1024, 2*tarBlockSize))
+	assert.Equal(t, int64(100), alignToPartSize(100, 0, 0))
+	assert.Equal(t, int64(100), alignToPartSize(100, 1000, 0))
+	assert.Equal(t, int64(0), alignToPartSize(0, 1000, 0))
+}
+
+func TestIncrementalChainRoot(t *testing.T) {
+	backups := map[string]metadata.BackupMetadata{
+		"full":   {BackupName: "full"},
+		"inc1":   {BackupName: "inc1", RequiredBackup: "full"},
+		"inc2":   {BackupName: "inc2", RequiredBackup: "inc1"},
+		"orphan": {BackupName: "orphan", RequiredBackup: "deleted"},
+		"loop1":  {BackupName: "loop1", RequiredBackup: "loop2"},
+		"loop2":  {BackupName: "loop2", RequiredBackup: "loop1"},
+	}
+	root, exists := incrementalChainRoot(backups, "inc2")
+	assert.True(t, exists)
+	assert.Equal(t, "full", root.BackupName)
+	root, _ = incrementalChainRoot(backups, "full")
+	assert.Equal(t, "full", root.BackupName)
+	root, _ = incrementalChainRoot(backups, "orphan")
+	assert.Equal(t, "orphan", root.BackupName)
+	root, exists = incrementalChainRoot(backups, "loop1")
+	assert.True(t, exists)
+	assert.Equal(t, "loop2", root.BackupName)
+	_, exists = incrementalChainRoot(backups, "unknown")
+	assert.False(t, exists)
+}
+
+// TestEnforceIncrementalMaxBaseAgeDiffFromRemote - create_remote pass only --diff-from-remote, check happens before local backup created
+func TestEnforceIncrementalMaxBaseAgeDiffFromRemote(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.IncrementalMaxBaseAgeDuration = 24 * time.Hour
+	b := NewBackuper(cfg)
+	log := apexLog.WithField("logger", "test")
+	remoteBackups := []storage.Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "old_full", CreationDate: time.Now().Add(-48 * time.Hour)}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "old_inc", RequiredBackup: "old_full", CreationDate: time.Now().Add(-time.Hour)}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "new_full", CreationDate: time.Now().Add(-2 * time.Hour)}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "new_inc", RequiredBackup: "new_full", CreationDate: time.Now().Add(-time.Hour)}},
+	}
+	diffFrom, diffFromRemote := b.enforceIncrementalMaxBaseAge(context.Background(), "", "old_inc", remoteBackups, log)
+	assert.Empty(t, diffFrom)
+	assert.Empty(t, diffFromRemote, "full backup shall be created instead of increment")
+	diffFrom, diffFromRemote = b.enforceIncrementalMaxBaseAge(context.Background(), "", "new_inc", remoteBackups, log)
+	assert.Empty(t, diffFrom)
+	assert.Equal(t, "new_inc", diffFromRemote)
+}
+
+func TestIncrementalChainLength(t *testing.T) {
+	backups := []storage.Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "full"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "inc1", RequiredBackup: "full"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "inc2", RequiredBackup: "inc1"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "orphan", RequiredBackup: "deleted"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "loop1", RequiredBackup: "loop2"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "loop2", RequiredBackup: "loop1"}},
+	}
+	assert.Equal(t, 3, IncrementalChainLength(backups, "inc2"))
+	assert.Equal(t, 1, IncrementalChainLength(backups, "full"))
+	assert.Equal(t, 1, IncrementalChainLength(backups, "orphan"))
+	assert.Equal(t, 2, IncrementalChainLength(backups, "loop1"))
+	assert.Equal(t, 0, IncrementalChainLength(backups, "unknown"))
+}
+
+func TestGetTableDataFormat(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteStorage = "s3"
+	cfg.S3.CompressionFormat = "zstd"
+	cfg.General.UploadByPart = true
+	cfg.General.TableCompressionFormat = map[string]string{"logs.*": "tar", "default.events": "none", "default.*": "tar"}
+	b := &Backuper{cfg: cfg}
+	assert.NoError(t, config.ValidateConfig(cfg))
+
+	assert.Equal(t, "tar", b.getTableDataFormat(metadata.TableMetadata{Database: "logs", Table: "t1"}))
+	assert.Equal(t, "tar", b.getTableDataFormat(metadata.TableMetadata{Database: "default", Table: "t1"}))
+	assert.Equal(t, "", b.getTableDataFormat(metadata.TableMetadata{Database: "other", Table: "t1"}))
+	assert.Equal(t, "zstd", cfg.GetTableCompressionFormat("other", "t1"))
+	// the first pattern in alphabetical order wins
+	assert.Equal(t, "tar", b.getTableDataFormat(metadata.TableMetadata{Database: "default", Table: "events"}))
+	delete(cfg.General.TableCompressionFormat, "default.*")
+	assert.Equal(t, DirectoryFormat, b.getTableDataFormat(metadata.TableMetadata{Database: "default", Table: "events"}))
+
+	assert.Equal(t, "default_all_1_1_0.tar", getArchiveFileName("default", "all_1_1_0", "tar"))
+	assert.Equal(t, "default_1.tar.zstd", getArchiveFileName("default", "1", "zstd"))
+	assert.Equal(t, "tar", (&metadata.TableMetadata{DataFormat: "tar"}).GetDataFormat("zstd"))
+	assert.Equal(t, "zstd", (&metadata.TableMetadata{}).GetDataFormat("zstd"))
+
+	cfg.General.UploadByPart = false
+	assert.ErrorContains(t, config.ValidateConfig(cfg), "upload_by_part")
+	cfg.General.TableCompressionFormat = map[string]string{"logs.*": "gzip"}
+	assert.ErrorContains(t, config.ValidateConfig(cfg), "shall be `tar` or `none`")
+}
+
+func TestReadLastBackupChainMetadata(t *testing.T) {
+	remote := &gcTestStorage{modified: time.Now(), objects: map[string][]byte{}}
+	for _, m := range []metadata.BackupMetadata{
+		{BackupName: "full", CreationDate: time.Now()},
+		{BackupName: "other", CreationDate: time.Now()},
+		{BackupName: "inc1", RequiredBackup: "full", CreationDate: time.Now()},
+		{BackupName: "inc2", RequiredBackup: "inc1", CreationDate: time.Now()},
+	} {
+		body, err := json.Marshal(m)
+		require.NoError(t, err)
+		remote.objects[m.BackupName+"/metadata.json"] = body
+	}
+	cfg := config.DefaultConfig()
+	b := NewBackuper(cfg)
+	bd := storage.NewBackupDestinationFromRemoteStorage(cfg, remote, apexLog.WithField("logger", "test"))
+	// list without parseMetadata, only last backup is parsed
+	backups := []storage.Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "full"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "other"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "inc1"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "inc2", RequiredBackup: "inc1"}},
+	}
+	assert.Equal(t, 2, IncrementalChainLength(backups, "inc2"))
+	b.readLastBackupChainMetadata(context.Background(), bd, backups)
+	assert.Equal(t, 3, IncrementalChainLength(backups, "inc2"))
+	assert.False(t, backups[0].CreationDate.IsZero())
+	assert.True(t, backups[1].CreationDate.IsZero(), "backup outside of chain shall not be parsed")
+}
@@ -2,6 +2,8 @@ package backup
 
 import (
 	"sort"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
 )
 
 func GetBackupsToDeleteLocal(backups []LocalBackup, keep int) []LocalBackup {
@@ -13,3 +15,12 @@ func GetBackupsToDeleteLocal(backups []LocalBackup, keep int) []LocalBackup {
 	}
 	return []LocalBackup{}
 }
+
+// getCustomDiskMap - disk name to path for custom->plugin_command, backup stored in `<path>/backup/<backup_name>` on each disk
+func getCustomDiskMap(disks []clickhouse.Disk) map[string]string {
+	diskMap := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		diskMap[disk.Name] = disk.Path
+	}
+	return diskMap
+}
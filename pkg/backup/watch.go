@@ -6,6 +6,7 @@ import (
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/server/metrics"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/systemd"
 	apexLog "github.com/apex/log"
 	"github.com/urfave/cli"
 	"regexp"
@@ -15,8 +16,43 @@ import (
 
 var watchBackupTemplateTimeRE = regexp.MustCompile(`{time:([^}]+)}`)
 
+// watchProgressInterval - how often waitWithProgress calls systemd.Progress
+var watchProgressInterval = 10 * time.Second
+
+// waitWithProgress - wait d or until ctx canceled, waiting loop is not hung, so call systemd.Progress periodically
+func waitWithProgress(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(watchProgressInterval)
+	defer ticker.Stop()
+	for {
+		systemd.Progress()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// WatchdogProgressTimeout - watch and continuous are hung when no ClickHouse query and no remote storage transfer progress during the longest of clickhouse->timeout and general->stalled_stream_timeout, look systemd.ProgressAlive
+func WatchdogProgressTimeout(cfg *config.Config) time.Duration {
+	timeout := cfg.General.StalledStreamTimeoutDuration
+	if clickhouseTimeout, err := time.ParseDuration(cfg.ClickHouse.Timeout); err == nil && clickhouseTimeout > timeout {
+		timeout = clickhouseTimeout
+	}
+	return timeout + watchProgressInterval
+}
+
 func (b *Backuper) NewBackupWatchName(ctx context.Context, backupType string) (string, error) {
-	backupName, err := b.ch.ApplyMacros(ctx, b.cfg.General.WatchBackupNameTemplate)
+	return b.newBackupNameFromTemplate(ctx, b.cfg.General.WatchBackupNameTemplate, "watch_backup_name_template", backupType)
+}
+
+// newBackupNameFromTemplate - apply system.macros, {type} and {time:layout} to backup name template
+func (b *Backuper) newBackupNameFromTemplate(ctx context.Context, nameTemplate, templateOption, backupType string) (string, error) {
+	backupName, err := b.ch.ApplyMacros(ctx, nameTemplate)
 	if err != nil {
 		return "", err
 	}
@@ -28,13 +64,28 @@ func (b *Backuper) NewBackupWatchName(ctx context.Context, backupType string) (s
 			backupName = strings.ReplaceAll(backupName, templateItem, time.Now().UTC().Format(layout))
 		}
 	} else {
-		return "", fmt.Errorf("watch_backup_name_template doesn't contain {time:layout}, backup name will non unique")
+		return "", fmt.Errorf("%s doesn't contain {time:layout}, backup name will non unique", templateOption)
 	}
 	return backupName, nil
 }
 
-func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate string) error {
+// ValidateWatchParams - watch_interval, full_interval and backups_to_keep_remote from general->retention_policies[retentionPolicy] override general section, CLI parameters override both
+func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate, retentionPolicy string) error {
 	var err error
+	backupsToKeepRemote := b.cfg.General.BackupsToKeepRemote
+	if retentionPolicy != "" {
+		policy := b.cfg.GetRetentionPolicy(retentionPolicy)
+		if policy == nil {
+			return fmt.Errorf("retention policy `%s` not found in general->retention_policies", retentionPolicy)
+		}
+		if policy.WatchInterval != "" {
+			b.cfg.General.WatchInterval, b.cfg.General.WatchDuration = policy.WatchInterval, policy.WatchDuration
+		}
+		if policy.FullInterval != "" {
+			b.cfg.General.FullInterval, b.cfg.General.FullDuration = policy.FullInterval, policy.FullDuration
+		}
+		backupsToKeepRemote = policy.BackupsToKeepRemote
+	}
 	if watchInterval != "" {
 		b.cfg.General.WatchInterval = watchInterval
 		if b.cfg.General.WatchDuration, err = time.ParseDuration(watchInterval); err != nil {
@@ -53,12 +104,43 @@ func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupN
 	if watchBackupNameTemplate != "" {
 		b.cfg.General.WatchBackupNameTemplate = watchBackupNameTemplate
 	}
-	if b.cfg.General.BackupsToKeepRemote > 0 && b.cfg.General.WatchDuration.Seconds()*float64(b.cfg.General.BackupsToKeepRemote) < b.cfg.General.FullDuration.Seconds() {
-		return fmt.Errorf("fullInterval `%s` is too long to keep %d remote backups with watchInterval `%s`", b.cfg.General.FullInterval, b.cfg.General.BackupsToKeepRemote, b.cfg.General.WatchInterval)
+	if b.cfg.General.WatchFullSchedule != "" {
+		if _, _, err = b.getWatchSchedules(); err != nil {
+			return err
+		}
+	}
+	// each policy shall have own backup sequence, calculatePrevBackupNameAndType should not find backups from other policies
+	if retentionPolicy != "" {
+		if !strings.Contains(b.cfg.General.WatchBackupNameTemplate, "{policy}") {
+			b.cfg.General.WatchBackupNameTemplate = "{policy}-" + b.cfg.General.WatchBackupNameTemplate
+		}
+		b.cfg.General.WatchBackupNameTemplate = strings.Replace(b.cfg.General.WatchBackupNameTemplate, "{policy}", retentionPolicy, -1)
+	}
+	if backupsToKeepRemote > 0 && b.cfg.General.WatchDuration.Seconds()*float64(backupsToKeepRemote) < b.cfg.General.FullDuration.Seconds() {
+		return fmt.Errorf("fullInterval `%s` is too long to keep %d remote backups with watchInterval `%s`", b.cfg.General.FullInterval, backupsToKeepRemote, b.cfg.General.WatchInterval)
 	}
 	return nil
 }
 
+// getRetentionPolicyTablePattern - backups created by watch with retention policy shall contain only policy databases, otherwise backup will retain with default policy
+func (b *Backuper) getRetentionPolicyTablePattern(retentionPolicy, tablePattern string) (string, error) {
+	if retentionPolicy == "" {
+		return tablePattern, nil
+	}
+	if tablePattern != "" && tablePattern != "*.*" {
+		return "", fmt.Errorf("--tables=%s can't be used together with --retention-policy=%s", tablePattern, retentionPolicy)
+	}
+	policy := b.cfg.GetRetentionPolicy(retentionPolicy)
+	if policy == nil {
+		return "", fmt.Errorf("retention policy `%s` not found in general->retention_policies", retentionPolicy)
+	}
+	patterns := make([]string, len(policy.Databases))
+	for i, database := range policy.Databases {
+		patterns[i] = database + ".*"
+	}
+	return strings.Join(patterns, ","), nil
+}
+
 // Watch
 // - run create_remote full + delete local full, even when upload failed
 //   - if success save backup type full, next will increment, until reach full interval
@@ -66,7 +148,11 @@ func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupN
 //
 // - each watch-interval, run create_remote increment --diff-from=prev-name + delete local increment, even when upload failed
 //   - save previous backup type incremental, next try will also incremental, until reach full interval
-func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, tablePattern string, partitions []string, schemaOnly, backupRBAC, backupConfigs, skipCheckPartsColumns bool, version string, commandId int, metrics metrics.APIMetricsInterface, cliCtx *cli.Context) error {
+//
+// - with retentionPolicy, backup only policy databases with policy intervals, {policy} in backup name template replaced with policy name
+//
+// - with general->watch_full_schedule, backups created by cron schedules instead of intervals, look watchBySchedule
+func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, retentionPolicy, tablePattern string, partitions []string, schemaOnly, backupRBAC, backupConfigs, skipCheckPartsColumns bool, version string, commandId int, metrics metrics.APIMetricsInterface, cliCtx *cli.Context) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -74,9 +160,75 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
-	if err := b.ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate); err != nil {
+	if err := b.ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate, retentionPolicy); err != nil {
 		return err
 	}
+	if tablePattern, err = b.getRetentionPolicyTablePattern(retentionPolicy, tablePattern); err != nil {
+		return err
+	}
+	defer deleteWatchState(commandId)
+
+	reloadConfig := func() error {
+		if cliCtx == nil {
+			return nil
+		}
+		if cfg, err := config.LoadConfig(config.GetConfigPath(cliCtx)); err == nil {
+			if cliCtx.Bool("no-cache") {
+				cfg.General.RemoteMetadataCacheDuration = 0
+			}
+			b.cfg = cfg
+		} else {
+			b.log.Warnf("watch config.LoadConfig error: %v", err)
+		}
+		return b.ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate, retentionPolicy)
+	}
+
+	createRemoteErrCount := 0
+	deleteLocalErrCount := 0
+	var createRemoteErr error
+	var deleteLocalErr error
+	// createRemoteAndDeleteLocal - create_remote + delete local, even when upload failed
+	createRemoteAndDeleteLocal := func(backupName, diffFromRemote string, log *apexLog.Entry) {
+		if metrics != nil {
+			createRemoteErr, createRemoteErrCount = metrics.ExecuteWithMetrics("create_remote", createRemoteErrCount, func() error {
+				return b.CreateToRemote(backupName, false, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, nil, false, version, commandId)
+			})
+			deleteLocalErr, deleteLocalErrCount = metrics.ExecuteWithMetrics("delete", deleteLocalErrCount, func() error {
+				return b.RemoveBackupLocal(ctx, backupName, nil)
+			})
+
+		} else {
+			createRemoteErr = b.CreateToRemote(backupName, false, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, nil, false, version, commandId)
+			if createRemoteErr != nil {
+				log.Errorf("create_remote %s return error: %v", backupName, createRemoteErr)
+				createRemoteErrCount += 1
+			} else {
+				createRemoteErrCount = 0
+			}
+			deleteLocalErr = b.RemoveBackupLocal(ctx, backupName, nil)
+			if deleteLocalErr != nil {
+				log.Errorf("delete local %s return error: %v", backupName, deleteLocalErr)
+				deleteLocalErrCount += 1
+			} else {
+				deleteLocalErrCount = 0
+			}
+
+		}
+	}
+
+	if b.cfg.General.WatchFullSchedule != "" {
+		return b.watchBySchedule(ctx, commandId, reloadConfig, func(backupName, diffFromRemote string, log *apexLog.Entry) error {
+			createRemoteAndDeleteLocal(backupName, diffFromRemote, log)
+			if createRemoteErr != nil {
+				return fmt.Errorf("create_remote: %v", createRemoteErr)
+			}
+			if deleteLocalErr != nil {
+				return fmt.Errorf("delete local: %v", deleteLocalErr)
+			}
+			return nil
+		})
+	}
+
 	backupType := "full"
 	prevBackupName := ""
 	prevBackupType := ""
@@ -88,10 +240,6 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 		return err
 	}
 
-	createRemoteErrCount := 0
-	deleteLocalErrCount := 0
-	var createRemoteErr error
-	var deleteLocalErr error
 	for {
 		if !b.ch.IsOpen {
 			if err = b.ch.Connect(); err != nil {
@@ -102,15 +250,8 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			if cliCtx != nil {
-				if cfg, err := config.LoadConfig(config.GetConfigPath(cliCtx)); err == nil {
-					b.cfg = cfg
-				} else {
-					b.log.Warnf("watch config.LoadConfig error: %v", err)
-				}
-				if err := b.ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate); err != nil {
-					return err
-				}
+			if err := reloadConfig(); err != nil {
+				return err
 			}
 			backupName, err := b.NewBackupWatchName(ctx, backupType)
 			log := b.log.WithFields(apexLog.Fields{
@@ -124,31 +265,22 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 			if backupType == "increment" {
 				diffFromRemote = prevBackupName
 			}
-			if metrics != nil {
-				createRemoteErr, createRemoteErrCount = metrics.ExecuteWithMetrics("create_remote", createRemoteErrCount, func() error {
-					return b.CreateToRemote(backupName, false, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
-				})
-				deleteLocalErr, deleteLocalErrCount = metrics.ExecuteWithMetrics("delete", deleteLocalErrCount, func() error {
-					return b.RemoveBackupLocal(ctx, backupName, nil)
-				})
-
-			} else {
-				createRemoteErr = b.CreateToRemote(backupName, false, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
+			updateWatchState(commandId, "interval", func(state *WatchState) {
+				state.State, state.BackupName, state.BackupType = "running", backupName, backupType
+				state.NextBackupType, state.NextBackupTime = "", nil
+			})
+			createRemoteAndDeleteLocal(backupName, diffFromRemote, log)
+			updateWatchState(commandId, "interval", func(state *WatchState) {
+				state.ConsecutiveFailures = createRemoteErrCount
 				if createRemoteErr != nil {
-					log.Errorf("create_remote %s return error: %v", backupName, createRemoteErr)
-					createRemoteErrCount += 1
+					state.LastError = createRemoteErr.Error()
+				} else if deleteLocalErr != nil {
+					state.LastError = deleteLocalErr.Error()
 				} else {
-					createRemoteErrCount = 0
+					now := time.Now().UTC()
+					state.LastSuccessBackup, state.LastSuccessTime, state.LastError = backupName, &now, ""
 				}
-				deleteLocalErr = b.RemoveBackupLocal(ctx, backupName, nil)
-				if deleteLocalErr != nil {
-					log.Errorf("delete local %s return error: %v", backupName, deleteLocalErr)
-					deleteLocalErrCount += 1
-				} else {
-					deleteLocalErrCount = 0
-				}
-
-			}
+			})
 
 			if createRemoteErrCount > b.cfg.General.BackupsToKeepRemote || deleteLocalErrCount > b.cfg.General.BackupsToKeepLocal {
 				return fmt.Errorf("too many errors create_remote: %d, delete local: %d, during watch full_interval: %s, abort watching", createRemoteErrCount, deleteLocalErrCount, b.cfg.General.FullInterval)
@@ -163,11 +295,13 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 					backupType = "increment"
 				}
 				now := time.Now()
+				nextBackupTime := lastBackup.Add(b.cfg.General.WatchDuration).UTC()
+				updateWatchState(commandId, "interval", func(state *WatchState) {
+					state.State, state.NextBackupType, state.NextBackupTime = "waiting", backupType, &nextBackupTime
+				})
 				if b.cfg.General.WatchDuration.Seconds()-now.Sub(lastBackup).Seconds() > 0 {
-					select {
-					case <-ctx.Done(): //context cancelled
-						return ctx.Err()
-					case <-time.After(b.cfg.General.WatchDuration - now.Sub(lastBackup)): //timeout
+					if err = waitWithProgress(ctx, b.cfg.General.WatchDuration-now.Sub(lastBackup)); err != nil {
+						return err
 					}
 				}
 				now = time.Now()
@@ -184,32 +318,58 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 	}
 }
 
-// calculatePrevBackupNameAndType - https://github.com/Altinity/clickhouse-backup/pull/804
-func (b *Backuper) calculatePrevBackupNameAndType(ctx context.Context, prevBackupName string, prevBackupType string, lastBackup time.Time, lastFullBackup time.Time, backupType string) (string, string, time.Time, time.Time, string, error) {
+// getBackupNameTemplateRE - whole backup name shall match template, {type} and {time:layout} match any non-space characters
+// other templates like `continuous-{template}` shall not match, otherwise watch and continuous use backups of each other as previous
+func getBackupNameTemplateRE(backupTemplateName string) *regexp.Regexp {
+	backupTemplateNamePrepareRE := regexp.MustCompile(`{type}|{time:([^}]+)}`)
+	templateParts := backupTemplateNamePrepareRE.Split(backupTemplateName, -1)
+	for i := range templateParts {
+		templateParts[i] = regexp.QuoteMeta(templateParts[i])
+	}
+	return regexp.MustCompile("^" + strings.Join(templateParts, `\S+`) + "$")
+}
+
+// getLastWatchBackup - last not broken remote backup which match nameTemplate, with its type, creation date and count of increments after last full backup, lastFullBackup is zero when no full backups found
+func (b *Backuper) getLastWatchBackup(ctx context.Context, nameTemplate string) (prevBackupName, prevBackupType string, lastBackup, lastFullBackup time.Time, incrementsAfterFull int, err error) {
 	remoteBackups, err := b.GetRemoteBackups(ctx, true)
 	if err != nil {
-		return "", "", time.Time{}, time.Time{}, "", err
+		return "", "", time.Time{}, time.Time{}, 0, err
 	}
-	backupTemplateName, err := b.ch.ApplyMacros(ctx, b.cfg.General.WatchBackupNameTemplate)
+	backupTemplateName, err := b.ch.ApplyMacros(ctx, nameTemplate)
 	if err != nil {
-		return "", "", time.Time{}, time.Time{}, "", err
+		return "", "", time.Time{}, time.Time{}, 0, err
 	}
-	backupTemplateNamePrepareRE := regexp.MustCompile(`{type}|{time:([^}]+)}`)
-	backupTemplateNameRE := regexp.MustCompile(backupTemplateNamePrepareRE.ReplaceAllString(backupTemplateName, `\S+`))
+	backupTemplateNameRE := getBackupNameTemplateRE(backupTemplateName)
 
 	for _, remoteBackup := range remoteBackups {
 		if remoteBackup.Broken == "" && backupTemplateNameRE.MatchString(remoteBackup.BackupName) {
 			prevBackupName = remoteBackup.BackupName
+			lastBackup = remoteBackup.CreationDate
 			if strings.Contains(remoteBackup.BackupName, "increment") {
 				prevBackupType = "increment"
-				lastBackup = remoteBackup.CreationDate
+				incrementsAfterFull += 1
 			} else {
 				prevBackupType = "full"
-				lastBackup = remoteBackup.CreationDate
 				lastFullBackup = remoteBackup.CreationDate
+				incrementsAfterFull = 0
 			}
 		}
 	}
+	return prevBackupName, prevBackupType, lastBackup, lastFullBackup, incrementsAfterFull, nil
+}
+
+// calculatePrevBackupNameAndType - https://github.com/Altinity/clickhouse-backup/pull/804
+func (b *Backuper) calculatePrevBackupNameAndType(ctx context.Context, prevBackupName string, prevBackupType string, lastBackup time.Time, lastFullBackup time.Time, backupType string) (string, string, time.Time, time.Time, string, error) {
+	lastBackupName, lastBackupType, lastBackupDate, lastFullBackupDate, _, err := b.getLastWatchBackup(ctx, b.cfg.General.WatchBackupNameTemplate)
+	if err != nil {
+		return "", "", time.Time{}, time.Time{}, "", err
+	}
+	if lastBackupName != "" {
+		prevBackupName, prevBackupType, lastBackup = lastBackupName, lastBackupType, lastBackupDate
+		if !lastFullBackupDate.IsZero() {
+			lastFullBackup = lastFullBackupDate
+		}
+	}
 	if prevBackupName != "" {
 		now := time.Now()
 		timeBeforeDoBackup := int(b.cfg.General.WatchDuration.Seconds() - now.Sub(lastBackup).Seconds())
@@ -0,0 +1,321 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	apexLog "github.com/apex/log"
+)
+
+// watchScheduleMacros - shortcuts for cron expressions
+var watchScheduleMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// watchScheduleSearchLimit - schedule which doesn't match during this period, like 30 February, is invalid
+const watchScheduleSearchLimit = 5 * 366 * 24 * time.Hour
+
+// watchSchedule - parsed cron expression `minute hour day_of_month month day_of_week`, all times in UTC
+type watchSchedule struct {
+	expression string
+	minute     map[int]bool
+	hour       map[int]bool
+	dayOfMonth map[int]bool
+	month      map[int]bool
+	dayOfWeek  map[int]bool
+	// anyDayOfMonth, anyDayOfWeek - field starts with `*`, like `*/2`, when both day fields restricted, day matched by any of them, like in cron
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// parseWatchScheduleField - `*`, `*/step`, `value`, `from-to`, `from-to/step` separated by comma
+func parseWatchScheduleField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if rangeAndStep := strings.SplitN(item, "/", 2); len(rangeAndStep) == 2 {
+			var err error
+			if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in `%s`", item)
+			}
+			item = rangeAndStep[0]
+		}
+		from, to := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value `%s`", item)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range `%s`", item)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("`%s` out of range %d-%d", item, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// parseWatchSchedule - parse cron expression with 5 fields or @hourly, @daily, @weekly, @monthly, day_of_week 7 is Sunday as 0
+func parseWatchSchedule(expression string) (*watchSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	fields := strings.Fields(expression)
+	if macro, exists := watchScheduleMacros[expression]; exists {
+		fields = strings.Fields(macro)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule `%s`, expected 5 fields `minute hour day_of_month month day_of_week`", expression)
+	}
+	s := &watchSchedule{
+		expression:    expression,
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for _, f := range []struct {
+		value    string
+		min, max int
+		target   *map[int]bool
+	}{
+		{fields[0], 0, 59, &s.minute},
+		{fields[1], 0, 23, &s.hour},
+		{fields[2], 1, 31, &s.dayOfMonth},
+		{fields[3], 1, 12, &s.month},
+		{fields[4], 0, 7, &s.dayOfWeek},
+	} {
+		if *f.target, err = parseWatchScheduleField(f.value, f.min, f.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule `%s`: %v", expression, err)
+		}
+	}
+	if s.dayOfWeek[7] {
+		s.dayOfWeek[0] = true
+	}
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule `%s` never matches", expression)
+	}
+	return s, nil
+}
+
+func (s *watchSchedule) matchDay(t time.Time) bool {
+	dayOfMonth, dayOfWeek := s.dayOfMonth[t.Day()], s.dayOfWeek[int(t.Weekday())]
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next - first matched minute after t, zero time when nothing matched during watchScheduleSearchLimit
+func (s *watchSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(watchScheduleSearchLimit)
+	for t.Before(limit) {
+		switch {
+		case !s.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Prev - last matched minute at or before t, zero time when nothing matched during watchScheduleSearchLimit
+func (s *watchSchedule) Prev(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute)
+	limit := t.Add(-watchScheduleSearchLimit)
+	for t.After(limit) {
+		switch {
+		case !s.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.UTC).Add(-time.Minute)
+		case !s.minute[t.Minute()]:
+			t = t.Add(-time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// String - original expression for logs and API
+func (s *watchSchedule) String() string {
+	return s.expression
+}
+
+// getNextWatchScheduledBackup - time and type of next backup, full backup is required immediately when no previous backup or missed full schedule during downtime with catchUp
+// increments scheduled at the same time as full backup are replaced by full backup
+func getNextWatchScheduledBackup(now, lastFullBackup time.Time, hasPrevBackup bool, full, increment *watchSchedule, catchUp bool) (time.Time, string) {
+	if !hasPrevBackup {
+		return now, "full"
+	}
+	if catchUp {
+		if prevFull := full.Prev(now); !prevFull.IsZero() && lastFullBackup.Before(prevFull) {
+			return now, "full"
+		}
+	}
+	nextFull := full.Next(now)
+	if increment != nil {
+		if nextIncrement := increment.Next(now); !nextIncrement.IsZero() && nextIncrement.Before(nextFull) {
+			return nextIncrement, "increment"
+		}
+	}
+	return nextFull, "full"
+}
+
+// getWatchFailureBackoff - delay before retry after failuresCount consecutive failures, doubled after each failure and limited by next scheduled backup
+func getWatchFailureBackoff(base time.Duration, failuresCount int, untilNextSchedule time.Duration) time.Duration {
+	backoff := base
+	for i := 1; i < failuresCount && backoff < untilNextSchedule; i++ {
+		backoff *= 2
+	}
+	if untilNextSchedule > 0 && backoff > untilNextSchedule {
+		backoff = untilNextSchedule
+	}
+	return backoff
+}
+
+// getWatchSchedules - parsed general->watch_full_schedule and general->watch_increment_schedule, increment is nil when only full backups scheduled
+func (b *Backuper) getWatchSchedules() (*watchSchedule, *watchSchedule, error) {
+	full, err := parseWatchSchedule(b.cfg.General.WatchFullSchedule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("watch_full_schedule: %v", err)
+	}
+	var increment *watchSchedule
+	if b.cfg.General.WatchIncrementSchedule != "" {
+		if increment, err = parseWatchSchedule(b.cfg.General.WatchIncrementSchedule); err != nil {
+			return nil, nil, fmt.Errorf("watch_increment_schedule: %v", err)
+		}
+	}
+	return full, increment, nil
+}
+
+// watchBySchedule - `watch` with general->watch_full_schedule
+// - wait next scheduled full or increment backup plus random general->watch_jitter, so replicas don't start backup at the same time
+// - with general->watch_catch_up, when scheduled full backup was missed during downtime, create full backup immediately
+// - after failure retry the same backup after general->watch_failure_backoff, doubled after each next failure, until next scheduled backup
+// - increment always created with --diff-from-remote=previous backup, full backup created when no previous backup
+func (b *Backuper) watchBySchedule(ctx context.Context, commandId int, reloadConfig func() error, createRemoteAndDeleteLocal func(backupName, diffFromRemote string, log *apexLog.Entry) error) error {
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return err
+		}
+	}
+	prevBackupName, _, _, lastFullBackup, _, err := b.getLastWatchBackup(ctx, b.cfg.General.WatchBackupNameTemplate)
+	if err != nil {
+		return err
+	}
+	failures := 0
+	failedBackupType := ""
+	for {
+		if err = reloadConfig(); err != nil {
+			return err
+		}
+		full, increment, err := b.getWatchSchedules()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		state := "waiting"
+		var nextBackupTime time.Time
+		var backupType string
+		if failures == 0 {
+			nextBackupTime, backupType = getNextWatchScheduledBackup(now, lastFullBackup, prevBackupName != "", full, increment, b.cfg.General.WatchCatchUp)
+			if b.cfg.General.WatchJitterDuration > 0 {
+				nextBackupTime = nextBackupTime.Add(time.Duration(rand.Int63n(int64(b.cfg.General.WatchJitterDuration))))
+			}
+		} else {
+			nextBackupTime, backupType = getNextWatchScheduledBackup(now, lastFullBackup, true, full, increment, false)
+			if backoff := getWatchFailureBackoff(b.cfg.General.WatchFailureBackoffDuration, failures, nextBackupTime.Sub(now)); backoff > 0 && now.Add(backoff).Before(nextBackupTime) {
+				state, nextBackupTime, backupType = "backoff", now.Add(backoff), failedBackupType
+			}
+		}
+		if prevBackupName == "" {
+			backupType = "full"
+		}
+		nextBackupTimeUTC := nextBackupTime.UTC()
+		updateWatchState(commandId, "schedule", func(s *WatchState) {
+			s.State, s.FullSchedule, s.BackupName, s.BackupType = state, full.String(), "", ""
+			s.IncrementSchedule = ""
+			if increment != nil {
+				s.IncrementSchedule = increment.String()
+			}
+			s.NextBackupType, s.NextBackupTime = backupType, &nextBackupTimeUTC
+		})
+		if wait := time.Until(nextBackupTime); wait > 0 {
+			b.log.WithField("operation", "watch").Infof("%s, next %s backup at %s", state, backupType, nextBackupTimeUTC.Format(time.RFC3339))
+			if b.ch.IsOpen {
+				b.ch.Close()
+			}
+			if err = waitWithProgress(ctx, wait); err != nil {
+				return err
+			}
+			if err = b.ch.Connect(); err != nil {
+				return err
+			}
+		}
+		backupName, err := b.NewBackupWatchName(ctx, backupType)
+		if err != nil {
+			return err
+		}
+		log := b.log.WithFields(apexLog.Fields{
+			"backup":    backupName,
+			"operation": "watch",
+		})
+		diffFromRemote := ""
+		if backupType == "increment" {
+			diffFromRemote = prevBackupName
+		}
+		updateWatchState(commandId, "schedule", func(s *WatchState) {
+			s.State, s.BackupName, s.BackupType = "running", backupName, backupType
+			s.NextBackupType, s.NextBackupTime = "", nil
+		})
+		backupStart := time.Now()
+		if backupErr := createRemoteAndDeleteLocal(backupName, diffFromRemote, log); backupErr != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures += 1
+			failedBackupType = backupType
+			log.Errorf("scheduled %s backup failed %d times in a row: %v", backupType, failures, backupErr)
+			updateWatchState(commandId, "schedule", func(s *WatchState) {
+				s.LastError, s.ConsecutiveFailures = backupErr.Error(), failures
+			})
+			continue
+		}
+		failures = 0
+		prevBackupName = backupName
+		if backupType == "full" {
+			lastFullBackup = backupStart
+		}
+		updateWatchState(commandId, "schedule", func(s *WatchState) {
+			now := time.Now().UTC()
+			s.LastSuccessBackup, s.LastSuccessTime, s.LastError, s.ConsecutiveFailures = backupName, &now, "", 0
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWatchSchedule(t *testing.T) {
+	for _, expression := range []string{"0 2 * * 0", "*/15 * * * *", "0 0-12/4 1,15 * 1-5", "@daily", "@hourly", "30 3 * * 7"} {
+		_, err := parseWatchSchedule(expression)
+		assert.NoError(t, err, expression)
+	}
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 30 2 *", "@yearly"} {
+		_, err := parseWatchSchedule(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestWatchScheduleNextPrev(t *testing.T) {
+	at := func(value string) time.Time {
+		result, err := time.Parse("2006-01-02 15:04", value)
+		require.NoError(t, err)
+		return result
+	}
+	testCases := []struct {
+		expression string
+		from       string
+		next       string
+		prev       string
+	}{
+		// 2024-01-03 is Wednesday
+		{"0 2 * * 0", "2024-01-03 10:00", "2024-01-07 02:00", "2023-12-31 02:00"},
+		{"0 2 * * 7", "2024-01-03 10:00", "2024-01-07 02:00", "2023-12-31 02:00"},
+		{"*/15 * * * *", "2024-01-03 10:07", "2024-01-03 10:15", "2024-01-03 10:00"},
+		{"0 */4 * * *", "2024-01-03 10:00", "2024-01-03 12:00", "2024-01-03 08:00"},
+		{"@daily", "2024-02-28 23:59", "2024-02-29 00:00", "2024-02-28 00:00"},
+		{"@monthly", "2024-12-15 00:00", "2025-01-01 00:00", "2024-12-01 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00", "2024-02-29 00:00"},
+		// day_of_month or day_of_week, when both restricted
+		{"0 0 1 * 1", "2024-01-03 10:00", "2024-01-08 00:00", "2024-01-01 00:00"},
+		// day field starting with `*` is unrestricted, so day_of_month and day_of_week both shall match
+		{"0 0 */2 * 1", "2024-01-03 10:00", "2024-01-15 00:00", "2024-01-01 00:00"},
+		{"0 0 1 * */2", "2024-01-03 10:00", "2024-02-01 00:00", "2023-10-01 00:00"},
+		{"0 0 */2 * *", "2024-01-03 10:00", "2024-01-05 00:00", "2024-01-03 00:00"},
+		{"0 0 * * */2", "2024-01-03 10:00", "2024-01-04 00:00", "2024-01-02 00:00"},
+		// exact match is previous, but not next
+		{"0 2 * * *", "2024-01-03 02:00", "2024-01-04 02:00", "2024-01-03 02:00"},
+	}
+	for _, tc := range testCases {
+		s, err := parseWatchSchedule(tc.expression)
+		require.NoError(t, err, tc.expression)
+		assert.Equal(t, at(tc.next), s.Next(at(tc.from)), "%s next after %s", tc.expression, tc.from)
+		assert.Equal(t, at(tc.prev), s.Prev(at(tc.from)), "%s prev before %s", tc.expression, tc.from)
+	}
+}
+
+func TestGetNextWatchScheduledBackup(t *testing.T) {
+	full, err := parseWatchSchedule("0 2 * * 0")
+	require.NoError(t, err)
+	increment, err := parseWatchSchedule("0 */6 * * *")
+	require.NoError(t, err)
+	// Wednesday
+	now := time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)
+	lastFull := time.Date(2023, 12, 31, 2, 1, 0, 0, time.UTC)
+
+	next, backupType := getNextWatchScheduledBackup(now, time.Time{}, false, full, increment, false)
+	assert.Equal(t, now, next, "first backup should start immediately")
+	assert.Equal(t, "full", backupType)
+
+	next, backupType = getNextWatchScheduledBackup(now, lastFull, true, full, increment, true)
+	assert.Equal(t, time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC), next)
+	assert.Equal(t, "increment", backupType)
+
+	next, backupType = getNextWatchScheduledBackup(now, lastFull, true, full, nil, true)
+	assert.Equal(t, time.Date(2024, 1, 7, 2, 0, 0, 0, time.UTC), next)
+	assert.Equal(t, "full", backupType)
+
+	missedFull := time.Date(2023, 12, 24, 2, 0, 0, 0, time.UTC)
+	next, backupType = getNextWatchScheduledBackup(now, missedFull, true, full, increment, true)
+	assert.Equal(t, now, next, "missed full backup should catch up")
+	assert.Equal(t, "full", backupType)
+
+	next, backupType = getNextWatchScheduledBackup(now, missedFull, true, full, increment, false)
+	assert.Equal(t, time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC), next, "without catch up wait schedule")
+	assert.Equal(t, "increment", backupType)
+
+	// increment at the same time as full replaced by full
+	beforeFull := time.Date(2024, 1, 7, 0, 30, 0, 0, time.UTC)
+	next, backupType = getNextWatchScheduledBackup(beforeFull, lastFull, true, full, increment, true)
+	assert.Equal(t, time.Date(2024, 1, 7, 2, 0, 0, 0, time.UTC), next)
+	assert.Equal(t, "full", backupType)
+}
+
+func TestGetWatchFailureBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, getWatchFailureBackoff(time.Minute, 1, time.Hour))
+	assert.Equal(t, 2*time.Minute, getWatchFailureBackoff(time.Minute, 2, time.Hour))
+	assert.Equal(t, 16*time.Minute, getWatchFailureBackoff(time.Minute, 5, time.Hour))
+	assert.Equal(t, time.Hour, getWatchFailureBackoff(time.Minute, 10, time.Hour))
+	assert.Equal(t, time.Duration(0), getWatchFailureBackoff(0, 3, time.Hour))
+}
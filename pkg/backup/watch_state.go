@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// WatchState - state machine of running `watch` process, for GET /backup/watch/status
+// State is `waiting` before next backup, `running` during create_remote and delete local, `backoff` when waiting retry after failure
+type WatchState struct {
+	CommandId           int        `json:"command_id"`
+	Mode                string     `json:"mode"`
+	State               string     `json:"state"`
+	FullSchedule        string     `json:"full_schedule,omitempty"`
+	IncrementSchedule   string     `json:"increment_schedule,omitempty"`
+	BackupName          string     `json:"backup_name,omitempty"`
+	BackupType          string     `json:"backup_type,omitempty"`
+	NextBackupType      string     `json:"next_backup_type,omitempty"`
+	NextBackupTime      *time.Time `json:"next_backup_time,omitempty"`
+	LastSuccessBackup   string     `json:"last_success_backup,omitempty"`
+	LastSuccessTime     *time.Time `json:"last_success_time,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+var watchStates = struct {
+	sync.RWMutex
+	states map[int]*WatchState
+}{states: map[int]*WatchState{}}
+
+// updateWatchState - apply change to state of watch process under lock, create state when not exists
+func updateWatchState(commandId int, mode string, change func(state *WatchState)) {
+	watchStates.Lock()
+	defer watchStates.Unlock()
+	state, exists := watchStates.states[commandId]
+	if !exists {
+		state = &WatchState{CommandId: commandId}
+		watchStates.states[commandId] = state
+	}
+	state.Mode = mode
+	change(state)
+	state.UpdatedAt = time.Now().UTC()
+}
+
+// deleteWatchState - watch process finished, cancelled or aborted
+func deleteWatchState(commandId int) {
+	watchStates.Lock()
+	defer watchStates.Unlock()
+	delete(watchStates.states, commandId)
+}
+
+// GetWatchStates - copy of states for all running watch processes, sorted by command id
+func GetWatchStates() []WatchState {
+	watchStates.RLock()
+	defer watchStates.RUnlock()
+	result := make([]WatchState, 0, len(watchStates.states))
+	for _, state := range watchStates.states {
+		result = append(result, *state)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CommandId < result[j].CommandId
+	})
+	return result
+}
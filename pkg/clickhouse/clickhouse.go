@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -18,6 +19,8 @@ import (
 	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/systemd"
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/antchfx/xmlquery"
@@ -33,6 +36,9 @@ type ClickHouse struct {
 	version              int
 	isPartsColumnPresent int8
 	IsOpen               bool
+	// logComment - passed as log_comment setting with each query, to find backup operations in system.query_log
+	logComment            string
+	isLogCommentSupported bool
 }
 
 // Connect - establish connection to ClickHouse
@@ -123,6 +129,7 @@ func (ch *ClickHouse) Connect() error {
 		if err == nil {
 			logFunc("clickhouse connection success: %s", fmt.Sprintf("tcp://%v:%v", ch.Config.Host, ch.Config.Port))
 			ch.IsOpen = true
+			ch.checkLogCommentSupported()
 			break
 		}
 		ch.Log.Warnf("clickhouse connection ping: %s return error: %v, will wait 5 second to reconnect", fmt.Sprintf("tcp://%v:%v", ch.Config.Host, ch.Config.Port), err)
@@ -636,7 +643,7 @@ func (ch *ClickHouse) fixVariousVersions(ctx context.Context, t Table, metadataP
 	if strings.Contains(t.CreateTableQuery, "'[HIDDEN]'") {
 		tableSQLPath := path.Join(metadataPath, common.TablePathEncode(t.Database), common.TablePathEncode(t.Name)+".sql")
 		if attachSQL, err := os.ReadFile(tableSQLPath); err != nil {
-			ch.Log.Warnf("can't read %s: %v", tableSQLPath, err)
+			status.Current.AddWarning(ctx, ch.Log, status.WarningMaskedCredentials, "can't read %s: %v, %s.%s create query will contain masked credentials '[HIDDEN]'", tableSQLPath, err, t.Database, t.Name)
 		} else {
 			t.CreateTableQuery = strings.Replace(string(attachSQL), "ATTACH", "CREATE", 1)
 			t.CreateTableQuery = strings.Replace(t.CreateTableQuery, " _ ", " `"+t.Database+"`.`"+t.Name+"` ", 1)
@@ -685,7 +692,16 @@ func (ch *ClickHouse) FreezeTableByParts(ctx context.Context, table *Table, name
 	if name != "" {
 		withNameQuery = fmt.Sprintf("WITH NAME '%s'", name)
 	}
-	for _, item := range partitions {
+	for i, item := range partitions {
+		// pause between batches spreads hardlinks creation and ALTER locks of tables with thousands of partitions
+		if i > 0 && ch.Config.FreezeByPartBatchSize > 0 && ch.Config.FreezeByPartBatchPauseDuration > 0 && i%ch.Config.FreezeByPartBatchSize == 0 {
+			ch.Log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name)).Debugf("%d of %d partitions frozen, pause %s", i, len(partitions), ch.Config.FreezeByPartBatchPauseDuration)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(ch.Config.FreezeByPartBatchPauseDuration):
+			}
+		}
 		ch.Log.Debugf("  partition '%v'", item.PartitionID)
 		query := fmt.Sprintf(
 			"ALTER TABLE `%v`.`%v` FREEZE PARTITION ID '%v' %s;",
@@ -731,6 +747,17 @@ func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string
 	if version < 19001005 || ch.Config.FreezeByPart {
 		return ch.FreezeTableByParts(ctx, table, name)
 	}
+	if ch.Config.FreezeByPartMinPartitions > 0 {
+		var partitionsCount uint64
+		partitionsCountSQL := "SELECT uniqExact(partition_id) AS partitions_count FROM `system`.`parts` WHERE active AND database=? AND table=?"
+		if err := ch.SelectSingleRow(ctx, &partitionsCount, partitionsCountSQL, table.Database, table.Name); err != nil {
+			return fmt.Errorf("can't get partitions count for '%s.%s': %v", table.Database, table.Name, err)
+		}
+		if partitionsCount >= uint64(ch.Config.FreezeByPartMinPartitions) {
+			ch.Log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name)).Infof("%d partitions, freeze by partition", partitionsCount)
+			return ch.FreezeTableByParts(ctx, table, name)
+		}
+	}
 	withNameQuery := ""
 	if name != "" {
 		withNameQuery = fmt.Sprintf("WITH NAME '%s'", name)
@@ -746,11 +773,14 @@ func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string
 	return nil
 }
 
-// AttachDataParts - execute ALTER TABLE ... ATTACH PART command for specific table
-func (ch *ClickHouse) AttachDataParts(table metadata.TableMetadata, dstTable Table) error {
+// AttachDataParts - execute ALTER TABLE ... ATTACH PART command for specific table, pause between queries allows to spread parts loading during restore on a replica which serves queries
+func (ch *ClickHouse) AttachDataParts(ctx context.Context, table metadata.TableMetadata, dstTable Table, pause time.Duration) error {
 	if dstTable.Database != "" && dstTable.Database != table.Database {
 		table.Database = dstTable.Database
 	}
+	if dstTable.Name != "" && dstTable.Name != table.Table {
+		table.Table = dstTable.Name
+	}
 	canContinue, err := ch.CheckReplicationInProgress(table)
 	if err != nil {
 		return err
@@ -762,10 +792,19 @@ func (ch *ClickHouse) AttachDataParts(table metadata.TableMetadata, dstTable Tab
 		for _, part := range table.Parts[disk] {
 			if !strings.HasSuffix(part.Name, ".proj") {
 				query := fmt.Sprintf("ALTER TABLE `%s`.`%s` ATTACH PART '%s'", table.Database, table.Table, part.Name)
-				if err := ch.Query(query); err != nil {
+				if err := ch.QueryContext(ctx, query); err != nil {
 					return err
 				}
 				ch.Log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table)).WithField("disk", disk).WithField("part", part.Name).Debug("attached")
+				if pause > 0 {
+					timer := time.NewTimer(pause)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return ctx.Err()
+					case <-timer.C:
+					}
+				}
 			}
 		}
 	}
@@ -784,6 +823,9 @@ func (ch *ClickHouse) AttachTable(ctx context.Context, table metadata.TableMetad
 	if dstTable.Database != "" && dstTable.Database != table.Database {
 		table.Database = dstTable.Database
 	}
+	if dstTable.Name != "" && dstTable.Name != table.Table {
+		table.Table = dstTable.Name
+	}
 	canContinue, err := ch.CheckReplicationInProgress(table)
 	if err != nil {
 		return err
@@ -974,6 +1016,10 @@ func (ch *ClickHouse) CreateTable(table Table, query string, dropTable, ignoreDe
 		}
 	}
 
+	// JSON, Dynamic, Variant and Object('json') columns require allow_experimental_* settings
+	if settings := experimentalTypesSettings(query, version); len(settings) > 0 {
+		return ch.QueryContext(withSettings(context.Background(), settings), query)
+	}
 	if err := ch.Query(query); err != nil {
 		return err
 	}
@@ -990,34 +1036,86 @@ func (ch *ClickHouse) StructSelect(dest interface{}, query string, args ...inter
 }
 
 func (ch *ClickHouse) QueryContext(ctx context.Context, query string, args ...interface{}) error {
-	return ch.conn.Exec(ctx, ch.LogQuery(query, args...), args...)
+	return ch.conn.Exec(ch.withLogComment(ctx), ch.LogQuery(query, args...), args...)
 }
 
 func (ch *ClickHouse) Query(query string, args ...interface{}) error {
-	return ch.conn.Exec(context.Background(), ch.LogQuery(query, args...), args...)
+	return ch.conn.Exec(ch.withLogComment(context.Background()), ch.LogQuery(query, args...), args...)
 }
 
 func (ch *ClickHouse) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
-	return ch.conn.Select(ctx, dest, ch.LogQuery(query, args...), args...)
+	return ch.conn.Select(ch.withLogComment(ctx), dest, ch.LogQuery(query, args...), args...)
 }
 
 func (ch *ClickHouse) Select(dest interface{}, query string, args ...interface{}) error {
-	return ch.conn.Select(context.Background(), dest, ch.LogQuery(query, args...), args...)
+	return ch.conn.Select(ch.withLogComment(context.Background()), dest, ch.LogQuery(query, args...), args...)
 }
 
 func (ch *ClickHouse) SelectSingleRow(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
-	return ch.conn.QueryRow(ctx, ch.LogQuery(query, args...), args...).Scan(dest)
+	return ch.conn.QueryRow(ch.withLogComment(ctx), ch.LogQuery(query, args...), args...).Scan(dest)
 }
 
 func (ch *ClickHouse) SelectSingleRowNoCtx(dest interface{}, query string, args ...interface{}) error {
-	err := ch.conn.QueryRow(context.Background(), ch.LogQuery(query, args...), args...).Scan(dest)
+	err := ch.conn.QueryRow(ch.withLogComment(context.Background()), ch.LogQuery(query, args...), args...).Scan(dest)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
 		return nil
 	}
 	return err
 }
 
+// SetLogComment - all next queries will have log_comment setting, look FormatLogComment
+func (ch *ClickHouse) SetLogComment(logComment string) {
+	ch.logComment = logComment
+}
+
+// FormatLogComment - JSON which allows correlate system.query_log with backup operation, like {"tool":"clickhouse-backup","operation":"create","operation_id":"1","backup":"name"}
+func FormatLogComment(operation, operationId, backupName string) string {
+	logComment, _ := json.Marshal(struct {
+		Tool        string `json:"tool"`
+		Operation   string `json:"operation"`
+		OperationId string `json:"operation_id"`
+		Backup      string `json:"backup,omitempty"`
+	}{"clickhouse-backup", operation, operationId, backupName})
+	return string(logComment)
+}
+
+// checkLogCommentSupported - log_comment setting doesn't exist in old ClickHouse versions, unknown setting will fail each query
+func (ch *ClickHouse) checkLogCommentSupported() {
+	var count uint64
+	if err := ch.conn.QueryRow(context.Background(), "SELECT count() FROM system.settings WHERE name='log_comment'").Scan(&count); err != nil {
+		ch.Log.Warnf("can't check log_comment setting: %v", err)
+		ch.isLogCommentSupported = false
+		return
+	}
+	ch.isLogCommentSupported = count > 0
+}
+
+type querySettingsKey struct{}
+
+// withSettings - clickhouse.WithSettings replace all settings of context and driver doesn't allow read them, so settings are kept in own context key and merged
+func withSettings(ctx context.Context, settings clickhouse.Settings) context.Context {
+	merged := clickhouse.Settings{}
+	if existing, ok := ctx.Value(querySettingsKey{}).(clickhouse.Settings); ok {
+		for name, value := range existing {
+			merged[name] = value
+		}
+	}
+	for name, value := range settings {
+		merged[name] = value
+	}
+	return clickhouse.Context(context.WithValue(ctx, querySettingsKey{}, merged), clickhouse.WithSettings(merged))
+}
+
+func (ch *ClickHouse) withLogComment(ctx context.Context) context.Context {
+	if ch.logComment == "" || !ch.isLogCommentSupported {
+		return ctx
+	}
+	return withSettings(ctx, clickhouse.Settings{"log_comment": ch.logComment})
+}
+
+// LogQuery - each query is also systemd.Progress, so systemd watchdog doesn't restart watch during FREEZE of many tables
 func (ch *ClickHouse) LogQuery(query string, args ...interface{}) string {
+	systemd.Progress()
 	var logF func(msg string)
 	if !ch.Config.LogSQLQueries {
 		logF = ch.Log.Debug
@@ -1130,6 +1228,72 @@ func (ch *ClickHouse) GetInProgressMutations(ctx context.Context, database strin
 	return inProgressMutations, nil
 }
 
+// GetProjectionParts - active projection parts of table, empty when system.projection_parts is not available
+func (ch *ClickHouse) GetProjectionParts(ctx context.Context, table *Table) ([]ProjectionPart, error) {
+	projectionParts := make([]ProjectionPart, 0)
+	var projectionPartsExists uint64
+	err := ch.SelectSingleRow(ctx, &projectionPartsExists, "SELECT count() AS is_projection_parts_exists FROM system.tables WHERE database='system' AND name='projection_parts' SETTINGS empty_result_for_aggregation_by_empty_set=0")
+	if err != nil || projectionPartsExists == 0 {
+		return projectionParts, err
+	}
+	if err = ch.SelectContext(ctx, &projectionParts, "SELECT name, parent_name, disk_name FROM system.projection_parts WHERE active AND database=? AND table=?", table.Database, table.Name); err != nil {
+		return nil, fmt.Errorf("can't get projection parts: %v", err)
+	}
+	return projectionParts, nil
+}
+
+// GetKafkaConsumerOffsets - current offsets of all consumers of Kafka table, empty when system.kafka_consumers is not available
+func (ch *ClickHouse) GetKafkaConsumerOffsets(ctx context.Context, table *Table) ([]KafkaConsumerOffset, error) {
+	offsets := make([]KafkaConsumerOffset, 0)
+	var kafkaConsumersExists uint64
+	err := ch.SelectSingleRow(ctx, &kafkaConsumersExists, "SELECT count() AS is_kafka_consumers_exists FROM system.tables WHERE database='system' AND name='kafka_consumers' SETTINGS empty_result_for_aggregation_by_empty_set=0")
+	if err != nil || kafkaConsumersExists == 0 {
+		return offsets, err
+	}
+	offsetsSQL := "SELECT topic, partition_id, current_offset FROM system.kafka_consumers " +
+		"ARRAY JOIN assignments.topic AS topic, assignments.partition_id AS partition_id, assignments.current_offset AS current_offset " +
+		"WHERE database=? AND table=? ORDER BY topic, partition_id"
+	if err = ch.SelectContext(ctx, &offsets, offsetsSQL, table.Database, table.Name); err != nil {
+		return nil, fmt.Errorf("can't get kafka consumer offsets: %v", err)
+	}
+	return offsets, nil
+}
+
+// GetActiveParts - active data parts of table with disk and path
+func (ch *ClickHouse) GetActiveParts(ctx context.Context, database, table string) ([]ActivePart, error) {
+	parts := make([]ActivePart, 0)
+	if err := ch.SelectContext(ctx, &parts, "SELECT name, disk_name, path FROM `system`.`parts` WHERE active AND database=? AND table=?", database, table); err != nil {
+		return nil, fmt.Errorf("can't get active parts for '%s.%s': %v", database, table, err)
+	}
+	return parts, nil
+}
+
+// GetTablesSizeByDisk - size of active parts of all tables on each disk
+func (ch *ClickHouse) GetTablesSizeByDisk(ctx context.Context) ([]TableDiskSize, error) {
+	sizes := make([]TableDiskSize, 0)
+	if err := ch.SelectContext(ctx, &sizes, "SELECT database, table, disk_name, sum(bytes_on_disk) AS size FROM `system`.`parts` WHERE active GROUP BY database, table, disk_name"); err != nil {
+		return nil, fmt.Errorf("can't get tables size by disk: %v", err)
+	}
+	return sizes, nil
+}
+
+// GetPartitionsMaxBlock - max block number of active parts for each partition of table
+func (ch *ClickHouse) GetPartitionsMaxBlock(ctx context.Context, database, table string) (map[string]int64, error) {
+	var partitions []struct {
+		PartitionID string `ch:"partition_id"`
+		MaxBlock    int64  `ch:"max_block"`
+	}
+	maxBlockSQL := "SELECT partition_id, max(max_block_number) AS max_block FROM `system`.`parts` WHERE active AND database=? AND table=? GROUP BY partition_id"
+	if err := ch.SelectContext(ctx, &partitions, maxBlockSQL, database, table); err != nil {
+		return nil, fmt.Errorf("can't get max block numbers for '%s.%s': %v", database, table, err)
+	}
+	maxBlocks := make(map[string]int64, len(partitions))
+	for _, partition := range partitions {
+		maxBlocks[partition.PartitionID] = partition.MaxBlock
+	}
+	return maxBlocks, nil
+}
+
 func (ch *ClickHouse) ApplyMacros(ctx context.Context, s string) (string, error) {
 	var macrosExists uint64
 	err := ch.SelectSingleRow(ctx, &macrosExists, "SELECT count() AS is_macros_exists FROM system.tables WHERE database='system' AND name='macros'  SETTINGS empty_result_for_aggregation_by_empty_set=0")
@@ -1152,6 +1316,26 @@ func (ch *ClickHouse) ApplyMacros(ctx context.Context, s string) (string, error)
 	return s, nil
 }
 
+// GetHostnameMacros - macros which substitution is hostName() or FQDN() of current server, return substitution -> {macro}
+func (ch *ClickHouse) GetHostnameMacros(ctx context.Context) (map[string]string, error) {
+	hostnameMacros := map[string]string{}
+	var macrosExists uint64
+	err := ch.SelectSingleRow(ctx, &macrosExists, "SELECT count() AS is_macros_exists FROM system.tables WHERE database='system' AND name='macros'  SETTINGS empty_result_for_aggregation_by_empty_set=0")
+	if err != nil || macrosExists == 0 {
+		return hostnameMacros, err
+	}
+	macros := make([]Macro, 0)
+	if err = ch.SelectContext(ctx, &macros, "SELECT macro, substitution FROM system.macros WHERE substitution IN (hostName(), FQDN()) ORDER BY macro='replica' DESC, macro"); err != nil {
+		return hostnameMacros, err
+	}
+	for _, macro := range macros {
+		if _, exists := hostnameMacros[macro.Substitution]; !exists {
+			hostnameMacros[macro.Substitution] = fmt.Sprintf("{%s}", macro.Macro)
+		}
+	}
+	return hostnameMacros, nil
+}
+
 func (ch *ClickHouse) ApplyMutation(ctx context.Context, tableMetadata metadata.TableMetadata, mutation metadata.MutationMetadata) error {
 	applyMutatoinSQL := fmt.Sprintf("ALTER TABLE `%s`.`%s` %s", tableMetadata.Database, tableMetadata.Table, mutation.Command)
 	if err := ch.QueryContext(ctx, applyMutatoinSQL); err != nil {
@@ -1189,6 +1373,8 @@ func (ch *ClickHouse) CheckSystemPartsColumns(ctx context.Context, table *Table)
 	partsColumnsSQL := "SELECT column, groupUniqArray(type) AS uniq_types " +
 		"FROM system.parts_columns " +
 		"WHERE active AND database=? AND table=? AND type NOT LIKE 'Enum%(%' AND type NOT LIKE 'Tuple(%' AND type NOT LIKE 'Array(Tuple(%' " +
+		// dynamic structure types, each data part contains own set of paths and subtypes
+		"AND type NOT LIKE 'Object(%' AND type NOT LIKE 'JSON%' AND type NOT LIKE 'Dynamic%' AND type NOT LIKE 'Variant(%' " +
 		"GROUP BY column HAVING length(uniq_types) > 1"
 	if err = ch.SelectContext(ctx, &partColumnsDataTypes, partsColumnsSQL, table.Database, table.Name); err != nil {
 		return err
@@ -1255,6 +1441,67 @@ func (ch *ClickHouse) CheckSettingsExists(ctx context.Context, settings map[stri
 	return settings, nil
 }
 
+// sessionSettings - system.settings which clickhouse-backup sets itself for connection or query, they differ between runs and don't belong to server configuration
+var sessionSettings = map[string]bool{
+	"log_comment":     true,
+	"connect_timeout": true,
+	"receive_timeout": true,
+	"send_timeout":    true,
+}
+
+// IsSessionSetting - look sessionSettings, settings of backups created before they were skipped could contain them
+func IsSessionSetting(name string) bool {
+	return sessionSettings[name]
+}
+
+// GetChangedSettings - settings which differ from defaults, settingsTable shall be system.settings or system.merge_tree_settings
+// sessionSettings and settings passed with query context are skipped, cause they are changed only for current query
+func (ch *ClickHouse) GetChangedSettings(ctx context.Context, settingsTable string) (map[string]string, error) {
+	settings, err := ch.getSettings(ctx, fmt.Sprintf("SELECT name, value FROM %s WHERE changed", settingsTable))
+	if err != nil {
+		return nil, err
+	}
+	return withoutSessionSettings(ctx, settingsTable, settings), nil
+}
+
+func withoutSessionSettings(ctx context.Context, settingsTable string, settings map[string]string) map[string]string {
+	if settingsTable != "system.settings" {
+		return settings
+	}
+	querySettings, _ := ctx.Value(querySettingsKey{}).(clickhouse.Settings)
+	for name := range settings {
+		if _, isQuerySetting := querySettings[name]; isQuerySetting || IsSessionSetting(name) {
+			delete(settings, name)
+		}
+	}
+	return settings
+}
+
+// GetSettingsValues - current values for listed settings, settingsTable shall be system.settings or system.merge_tree_settings
+func (ch *ClickHouse) GetSettingsValues(ctx context.Context, settingsTable string, names []string) (map[string]string, error) {
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	return ch.getSettings(ctx, fmt.Sprintf("SELECT name, value FROM %s WHERE name IN (%s)", settingsTable, placeholders), args...)
+}
+
+func (ch *ClickHouse) getSettings(ctx context.Context, query string, args ...interface{}) (map[string]string, error) {
+	settings := make([]Setting, 0)
+	if err := ch.SelectContext(ctx, &settings, query, args...); err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		result[setting.Name] = setting.Value
+	}
+	return result, nil
+}
+
 func (ch *ClickHouse) GetPreprocessedConfigPath(ctx context.Context) (string, error) {
 	metadataPath, err := ch.getMetadataPath(ctx)
 	if err != nil {
@@ -1,10 +1,12 @@
 package clickhouse
 
 import (
+	"context"
 	"fmt"
 	apexLog "github.com/apex/log"
 	"testing"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -123,3 +125,45 @@ func TestExtractStoragePolicy(t *testing.T) {
 		assert.Equal(t, policy, ch.ExtractStoragePolicy(query))
 	}
 }
+
+func TestFormatLogComment(t *testing.T) {
+	assert.Equal(t, `{"tool":"clickhouse-backup","operation":"create","operation_id":"1","backup":"backup \"name\""}`, FormatLogComment("create", "1", `backup "name"`))
+	assert.Equal(t, `{"tool":"clickhouse-backup","operation":"delete","operation_id":"2"}`, FormatLogComment("delete", "2", ""))
+	ch := ClickHouse{}
+	ch.SetLogComment(FormatLogComment("create", "1", "name"))
+	ctx := context.Background()
+	assert.Equal(t, ctx, ch.withLogComment(ctx), "log_comment is not supported before connect")
+}
+
+func TestWithLogCommentKeepSettings(t *testing.T) {
+	ch := ClickHouse{isLogCommentSupported: true}
+	ch.SetLogComment(FormatLogComment("restore", "1", "name"))
+	ctx := withSettings(context.Background(), clickhouse.Settings{"allow_experimental_object_type": 1})
+	ctx = ch.withLogComment(ctx)
+	settings, ok := ctx.Value(querySettingsKey{}).(clickhouse.Settings)
+	assert.True(t, ok)
+	assert.Equal(t, clickhouse.Settings{"allow_experimental_object_type": 1, "log_comment": ch.logComment}, settings)
+}
+
+func TestWithoutSessionSettings(t *testing.T) {
+	ctx := withSettings(context.Background(), clickhouse.Settings{"allow_experimental_object_type": 1})
+	settings := withoutSessionSettings(ctx, "system.settings", map[string]string{
+		"max_threads":                    "16",
+		"log_comment":                    `{"operation":"create"}`,
+		"receive_timeout":                "300",
+		"allow_experimental_object_type": "1",
+	})
+	assert.Equal(t, map[string]string{"max_threads": "16"}, settings)
+	settings = withoutSessionSettings(ctx, "system.merge_tree_settings", map[string]string{"receive_timeout": "1"})
+	assert.Equal(t, map[string]string{"receive_timeout": "1"}, settings)
+}
+
+func TestIsTableEngineMatched(t *testing.T) {
+	patterns := []string{"Memory", "kafka", "Replicated*", " *Log "}
+	assert.True(t, IsTableEngineMatched("Memory", patterns))
+	assert.True(t, IsTableEngineMatched("Kafka", patterns))
+	assert.True(t, IsTableEngineMatched("ReplicatedReplacingMergeTree", patterns))
+	assert.True(t, IsTableEngineMatched("TinyLog", patterns))
+	assert.False(t, IsTableEngineMatched("MergeTree", patterns))
+	assert.False(t, IsTableEngineMatched("Memory", nil))
+}
@@ -0,0 +1,102 @@
+package clickhouse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// experimentalType - column type which requires setting to create table and minimal version which can read data parts
+type experimentalType struct {
+	name       string
+	re         *regexp.Regexp
+	setting    string
+	minVersion int
+}
+
+// jsonTypeVersion - since 24.8 JSON is new type with own data parts format, before it was alias for Object('json')
+const jsonTypeVersion = 24008000
+
+var experimentalTypes = []experimentalType{
+	{name: "Object('json')", re: regexp.MustCompile(`\bObject\(\s*'json'\s*\)`), setting: "allow_experimental_object_type", minVersion: 22003000},
+	{name: "Variant", re: regexp.MustCompile(`\bVariant\(`), setting: "allow_experimental_variant_type", minVersion: 24001000},
+	{name: "Dynamic", re: regexp.MustCompile(`\bDynamic\b`), setting: "allow_experimental_dynamic_type", minVersion: 24005000},
+	{name: "JSON", re: regexp.MustCompile(`\bJSON\b`), setting: "allow_experimental_json_type", minVersion: jsonTypeVersion},
+}
+
+var (
+	stringLiteralRE   = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+	versionDescribeRE = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+)
+
+// ParseVersionDescribe - convert `v24.8.1.2684-stable` from metadata.json into VERSION_INTEGER format, 0 means unknown version
+func ParseVersionDescribe(versionDescribe string) int {
+	matches := versionDescribeRE.FindStringSubmatch(versionDescribe)
+	if len(matches) != 4 {
+		return 0
+	}
+	version := 0
+	for _, part := range matches[1:] {
+		n, _ := strconv.Atoi(part)
+		version = version*1000 + n
+	}
+	return version
+}
+
+// usedExperimentalTypes - experimental types in CREATE query, string literals are ignored, except Object('json') which contains literal itself
+func usedExperimentalTypes(query string) []experimentalType {
+	var used []experimentalType
+	withoutLiterals := stringLiteralRE.ReplaceAllString(query, "''")
+	for _, t := range experimentalTypes {
+		q := withoutLiterals
+		if t.name == "Object('json')" {
+			q = query
+		}
+		if t.re.MatchString(q) {
+			used = append(used, t)
+		}
+	}
+	return used
+}
+
+// CheckExperimentalTypes - return error when server version can't create table with experimental types from backup, 0 version means unknown,
+// JSON created before 24.8 is Object('json') alias with incompatible data parts, so it can't restore as new JSON type and vice versa
+func CheckExperimentalTypes(database, table, query string, backupVersion, version int) error {
+	formatVersion := func(v int) string {
+		return fmt.Sprintf("%d.%d", v/1000000, v/1000%1000)
+	}
+	for _, t := range usedExperimentalTypes(query) {
+		if t.name == "JSON" {
+			if backupVersion == 0 || version == 0 {
+				continue
+			}
+			if backupVersion < jsonTypeVersion && version >= jsonTypeVersion {
+				return fmt.Errorf("`%s`.`%s` uses JSON column type created in ClickHouse %s as alias for Object('json'), its data parts can't be restored as JSON type in ClickHouse %s or later, current version is %s", database, table, formatVersion(backupVersion), formatVersion(jsonTypeVersion), formatVersion(version))
+			}
+			if backupVersion >= jsonTypeVersion && version < jsonTypeVersion {
+				return fmt.Errorf("`%s`.`%s` uses JSON column type which requires ClickHouse %s or later, current version is %s", database, table, formatVersion(jsonTypeVersion), formatVersion(version))
+			}
+			continue
+		}
+		if version > 0 && version < t.minVersion {
+			return fmt.Errorf("`%s`.`%s` uses %s column type which requires ClickHouse %s or later, current version is %s", database, table, t.name, formatVersion(t.minVersion), formatVersion(version))
+		}
+	}
+	return nil
+}
+
+// experimentalTypesSettings - query level settings which allow CREATE for experimental types used in query
+func experimentalTypesSettings(query string, version int) clickhouse.Settings {
+	settings := clickhouse.Settings{}
+	for _, t := range usedExperimentalTypes(query) {
+		// JSON before 24.8 is Object('json') alias
+		if t.name == "JSON" && version > 0 && version < jsonTypeVersion {
+			settings["allow_experimental_object_type"] = 1
+			continue
+		}
+		settings[t.setting] = 1
+	}
+	return settings
+}
@@ -0,0 +1,37 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersionDescribe(t *testing.T) {
+	assert.Equal(t, 24008001, ParseVersionDescribe("v24.8.1.2684-stable"))
+	assert.Equal(t, 23003010, ParseVersionDescribe("23.3.10.5"))
+	assert.Equal(t, 0, ParseVersionDescribe(""))
+}
+
+func TestCheckExperimentalTypes(t *testing.T) {
+	jsonQuery := "CREATE TABLE db.t (`id` UInt64, `data` JSON) ENGINE = MergeTree ORDER BY id"
+	assert.NoError(t, CheckExperimentalTypes("db", "t", jsonQuery, 24008001, 24010001))
+	assert.NoError(t, CheckExperimentalTypes("db", "t", jsonQuery, 0, 24003001), "unknown backup version")
+	assert.ErrorContains(t, CheckExperimentalTypes("db", "t", jsonQuery, 24008001, 24003001), "requires ClickHouse 24.8 or later, current version is 24.3")
+	assert.ErrorContains(t, CheckExperimentalTypes("db", "t", jsonQuery, 23003010, 24008001), "alias for Object('json')")
+	assert.NoError(t, CheckExperimentalTypes("db", "t", jsonQuery, 23003010, 23008001))
+
+	variantQuery := "CREATE TABLE db.t (`v` Variant(String, UInt64), `d` Dynamic, `s` String DEFAULT 'JSON') ENGINE = MergeTree ORDER BY tuple()"
+	assert.ErrorContains(t, CheckExperimentalTypes("db", "t", variantQuery, 0, 23008001), "uses Variant column type which requires ClickHouse 24.1 or later")
+	assert.ErrorContains(t, CheckExperimentalTypes("db", "t", variantQuery, 0, 24003001), "uses Dynamic column type which requires ClickHouse 24.5 or later")
+	assert.NoError(t, CheckExperimentalTypes("db", "t", variantQuery, 0, 24005001))
+	assert.NoError(t, CheckExperimentalTypes("db", "t", "CREATE TABLE db.t (`s` String COMMENT 'Dynamic JSON') ENGINE = MergeTree ORDER BY s", 24008001, 23008001))
+}
+
+func TestExperimentalTypesSettings(t *testing.T) {
+	assert.Empty(t, experimentalTypesSettings("CREATE TABLE db.t (`j` String DEFAULT toJSONString(map('a', 1))) ENGINE = MergeTree ORDER BY j", 24008001))
+	query := "CREATE TABLE db.t (`j` JSON, `o` Object('json'), `v` Variant(String, UInt64), `d` Dynamic) ENGINE = MergeTree ORDER BY tuple()"
+	assert.Equal(t, 4, len(experimentalTypesSettings(query, 24008001)))
+	legacy := experimentalTypesSettings("CREATE TABLE db.t (`j` JSON) ENGINE = MergeTree ORDER BY tuple()", 23008001)
+	assert.Equal(t, 1, legacy["allow_experimental_object_type"])
+	assert.Nil(t, legacy["allow_experimental_json_type"])
+}
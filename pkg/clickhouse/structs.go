@@ -59,6 +59,12 @@ type Function struct {
 	CreateQuery string `ch:"create_query"`
 }
 
+// Setting - info from system.settings and system.merge_tree_settings
+type Setting struct {
+	Name  string `ch:"name"`
+	Value string `ch:"value"`
+}
+
 // Macro - info from system.macros
 type Macro struct {
 	Macro        string `ch:"macro"`
@@ -101,3 +107,32 @@ type RBACObject struct {
 	Id   string `ch:"id"`
 	Name string `ch:"name"`
 }
+
+// ProjectionPart - active projection part from system.projection_parts
+type ProjectionPart struct {
+	Name       string `ch:"name"`
+	ParentName string `ch:"parent_name"`
+	DiskName   string `ch:"disk_name"`
+}
+
+// KafkaConsumerOffset - current offset of Kafka table consumer assignment from system.kafka_consumers
+type KafkaConsumerOffset struct {
+	Topic     string `ch:"topic"`
+	Partition int32  `ch:"partition_id"`
+	Offset    int64  `ch:"current_offset"`
+}
+
+// ActivePart - active data part from system.parts, path is absolute path of part directory
+type ActivePart struct {
+	Name     string `ch:"name"`
+	DiskName string `ch:"disk_name"`
+	Path     string `ch:"path"`
+}
+
+// TableDiskSize - bytes_on_disk of active parts of table on one disk
+type TableDiskSize struct {
+	Database string `ch:"database"`
+	Table    string `ch:"table"`
+	DiskName string `ch:"disk_name"`
+	Size     uint64 `ch:"size"`
+}
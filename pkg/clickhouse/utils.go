@@ -1,6 +1,7 @@
 package clickhouse
 
 import (
+	"path/filepath"
 	"strings"
 )
 
@@ -39,3 +40,14 @@ func GetDisksByPaths(disks []Disk, dataPaths []string) map[string]string {
 	}
 	return result
 }
+
+// IsTableEngineMatched - case-insensitive match of table engine with engine names or family patterns like `*MergeTree` or `Replicated*`
+func IsTableEngineMatched(engine string, patterns []string) bool {
+	engine = strings.ToLower(engine)
+	for _, pattern := range patterns {
+		if matched, err := filepath.Match(strings.ToLower(strings.Trim(pattern, " \t\r\n")), engine); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
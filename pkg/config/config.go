@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 
+	"github.com/Altinity/clickhouse-backup/v2/pkg/logcli"
 	"github.com/apex/log"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/urfave/cli"
@@ -20,6 +25,10 @@ import (
 
 const (
 	DefaultConfigPath = "/etc/clickhouse-backup/config.yml"
+	// PrimaryDestination - name of remote destination described in the main config
+	PrimaryDestination = "primary"
+	// DefaultRetentionPolicy - backups which don't match any general->retention_policies, retained with general->backups_to_keep_remote
+	DefaultRetentionPolicy = "default"
 )
 
 // Config - config file format
@@ -34,38 +43,244 @@ type Config struct {
 	SFTP       SFTPConfig       `yaml:"sftp" envconfig:"_"`
 	AzureBlob  AzureBlobConfig  `yaml:"azblob" envconfig:"_"`
 	Custom     CustomConfig     `yaml:"custom" envconfig:"_"`
+	// secretReferences - yaml path to secret reference, look resolveSecrets
+	secretReferences map[string]string
 }
 
 // GeneralConfig - general setting section
 type GeneralConfig struct {
-	RemoteStorage             string            `yaml:"remote_storage" envconfig:"REMOTE_STORAGE"`
-	MaxFileSize               int64             `yaml:"max_file_size" envconfig:"MAX_FILE_SIZE"`
-	BackupsToKeepLocal        int               `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
-	BackupsToKeepRemote       int               `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
-	LogLevel                  string            `yaml:"log_level" envconfig:"LOG_LEVEL"`
-	AllowEmptyBackups         bool              `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
-	DownloadConcurrency       uint8             `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
-	UploadConcurrency         uint8             `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
-	UploadMaxBytesPerSecond   uint64            `yaml:"upload_max_bytes_per_second" envconfig:"UPLOAD_MAX_BYTES_PER_SECOND"`
-	DownloadMaxBytesPerSecond uint64            `yaml:"download_max_bytes_per_second" envconfig:"DOWNLOAD_MAX_BYTES_PER_SECOND"`
-	UseResumableState         bool              `yaml:"use_resumable_state" envconfig:"USE_RESUMABLE_STATE"`
-	RestoreSchemaOnCluster    string            `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
-	UploadByPart              bool              `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
-	DownloadByPart            bool              `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
-	RestoreDatabaseMapping    map[string]string `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
-	RetriesOnFailure          int               `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
-	RetriesPause              string            `yaml:"retries_pause" envconfig:"RETRIES_PAUSE"`
-	WatchInterval             string            `yaml:"watch_interval" envconfig:"WATCH_INTERVAL"`
-	FullInterval              string            `yaml:"full_interval" envconfig:"FULL_INTERVAL"`
-	WatchBackupNameTemplate   string            `yaml:"watch_backup_name_template" envconfig:"WATCH_BACKUP_NAME_TEMPLATE"`
-	ShardedOperationMode      string            `yaml:"sharded_operation_mode" envconfig:"SHARDED_OPERATION_MODE"`
-	CPUNicePriority           int               `yaml:"cpu_nice_priority" envconfig:"CPU_NICE_PRIORITY"`
-	IONicePriority            string            `yaml:"io_nice_priority" envconfig:"IO_NICE_PRIORITY"`
-	RBACBackupAlways          bool              `yaml:"rbac_backup_always" envconfig:"RBAC_BACKUP_ALWAYS"`
-	RBACConflictResolution    string            `yaml:"rbac_conflict_resolution" envconfig:"RBAC_CONFLICT_RESOLUTION"`
-	RetriesDuration           time.Duration
-	WatchDuration             time.Duration
-	FullDuration              time.Duration
+	RemoteStorage                     string             `yaml:"remote_storage" envconfig:"REMOTE_STORAGE"`
+	MaxFileSize                       int64              `yaml:"max_file_size" envconfig:"MAX_FILE_SIZE"`
+	BackupsToKeepLocal                int                `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
+	BackupsToKeepRemote               int                `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
+	LogLevel                          string             `yaml:"log_level" envconfig:"LOG_LEVEL"`
+	LogFormat                         string             `yaml:"log_format" envconfig:"LOG_FORMAT"`
+	AllowEmptyBackups                 bool               `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
+	DownloadConcurrency               uint8              `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
+	UploadConcurrency                 uint8              `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
+	UploadMaxBytesPerSecond           uint64             `yaml:"upload_max_bytes_per_second" envconfig:"UPLOAD_MAX_BYTES_PER_SECOND"`
+	DownloadMaxBytesPerSecond         uint64             `yaml:"download_max_bytes_per_second" envconfig:"DOWNLOAD_MAX_BYTES_PER_SECOND"`
+	ObjectDiskCopyConcurrency         int                `yaml:"object_disk_copy_concurrency" envconfig:"OBJECT_DISK_COPY_CONCURRENCY"`
+	ObjectDiskCopyMaxBytesPerSecond   uint64             `yaml:"object_disk_copy_max_bytes_per_second" envconfig:"OBJECT_DISK_COPY_MAX_BYTES_PER_SECOND"`
+	UseResumableState                 bool               `yaml:"use_resumable_state" envconfig:"USE_RESUMABLE_STATE"`
+	RestoreSchemaOnCluster            string             `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
+	RestoreSchemaFidelityCheck        bool               `yaml:"restore_schema_fidelity_check" envconfig:"RESTORE_SCHEMA_FIDELITY_CHECK"`
+	UploadByPart                      bool               `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
+	UploadPartArchiveSize             int64              `yaml:"upload_part_archive_size" envconfig:"UPLOAD_PART_ARCHIVE_SIZE"`
+	UploadPartMaxArchives             int                `yaml:"upload_part_max_archives" envconfig:"UPLOAD_PART_MAX_ARCHIVES"`
+	UploadMaxTableArchives            int                `yaml:"upload_max_table_archives" envconfig:"UPLOAD_MAX_TABLE_ARCHIVES"`
+	UploadAlignMultipartParts         bool               `yaml:"upload_align_multipart_parts" envconfig:"UPLOAD_ALIGN_MULTIPART_PARTS"`
+	UploadDiffFiles                   bool               `yaml:"upload_diff_files" envconfig:"UPLOAD_DIFF_FILES"`
+	UploadDedupFiles                  bool               `yaml:"upload_dedup_files" envconfig:"UPLOAD_DEDUP_FILES"`
+	UploadFailurePolicy               string             `yaml:"upload_failure_policy" envconfig:"UPLOAD_FAILURE_POLICY"`
+	UploadMaxFailedTables             int                `yaml:"upload_max_failed_tables" envconfig:"UPLOAD_MAX_FAILED_TABLES"`
+	DownloadByPart                    bool               `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
+	RestoreDatabaseMapping            map[string]string  `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
+	RetriesOnFailure                  int                `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
+	RetriesPause                      string             `yaml:"retries_pause" envconfig:"RETRIES_PAUSE"`
+	RetriesBackoff                    string             `yaml:"retries_backoff" envconfig:"RETRIES_BACKOFF"`
+	RetriesMaxPause                   string             `yaml:"retries_max_pause" envconfig:"RETRIES_MAX_PAUSE"`
+	RetriesJitter                     float64            `yaml:"retries_jitter" envconfig:"RETRIES_JITTER"`
+	RetriesBudget                     int                `yaml:"retries_budget" envconfig:"RETRIES_BUDGET"`
+	WatchInterval                     string             `yaml:"watch_interval" envconfig:"WATCH_INTERVAL"`
+	FullInterval                      string             `yaml:"full_interval" envconfig:"FULL_INTERVAL"`
+	WatchBackupNameTemplate           string             `yaml:"watch_backup_name_template" envconfig:"WATCH_BACKUP_NAME_TEMPLATE"`
+	WatchFullSchedule                 string             `yaml:"watch_full_schedule" envconfig:"WATCH_FULL_SCHEDULE"`
+	WatchIncrementSchedule            string             `yaml:"watch_increment_schedule" envconfig:"WATCH_INCREMENT_SCHEDULE"`
+	WatchJitter                       string             `yaml:"watch_jitter" envconfig:"WATCH_JITTER"`
+	WatchCatchUp                      bool               `yaml:"watch_catch_up" envconfig:"WATCH_CATCH_UP"`
+	WatchFailureBackoff               string             `yaml:"watch_failure_backoff" envconfig:"WATCH_FAILURE_BACKOFF"`
+	ContinuousInterval                string             `yaml:"continuous_interval" envconfig:"CONTINUOUS_INTERVAL"`
+	ContinuousMaxIncrements           int                `yaml:"continuous_max_increments" envconfig:"CONTINUOUS_MAX_INCREMENTS"`
+	ContinuousBackupNameTemplate      string             `yaml:"continuous_backup_name_template" envconfig:"CONTINUOUS_BACKUP_NAME_TEMPLATE"`
+	ShardedOperationMode              string             `yaml:"sharded_operation_mode" envconfig:"SHARDED_OPERATION_MODE"`
+	CPUNicePriority                   int                `yaml:"cpu_nice_priority" envconfig:"CPU_NICE_PRIORITY"`
+	IONicePriority                    string             `yaml:"io_nice_priority" envconfig:"IO_NICE_PRIORITY"`
+	RBACBackupAlways                  bool               `yaml:"rbac_backup_always" envconfig:"RBAC_BACKUP_ALWAYS"`
+	RBACConflictResolution            string             `yaml:"rbac_conflict_resolution" envconfig:"RBAC_CONFLICT_RESOLUTION"`
+	RemoteDestinations                map[string]string  `yaml:"remote_destinations" envconfig:"REMOTE_DESTINATIONS"`
+	LockFile                          string             `yaml:"lock_file" envconfig:"LOCK_FILE"`
+	AuditLogFile                      string             `yaml:"audit_log_file" envconfig:"AUDIT_LOG_FILE"`
+	AuditLogRemote                    bool               `yaml:"audit_log_remote" envconfig:"AUDIT_LOG_REMOTE"`
+	KeeperLock                        bool               `yaml:"keeper_lock" envconfig:"KEEPER_LOCK"`
+	KeeperLockPath                    string             `yaml:"keeper_lock_path" envconfig:"KEEPER_LOCK_PATH"`
+	KeeperLockTTL                     string             `yaml:"keeper_lock_ttl" envconfig:"KEEPER_LOCK_TTL"`
+	KeeperBackup                      bool               `yaml:"keeper_backup" envconfig:"KEEPER_BACKUP"`
+	KeeperBackupPaths                 []string           `yaml:"keeper_backup_paths" envconfig:"KEEPER_BACKUP_PATHS"`
+	RemoteCatalog                     bool               `yaml:"remote_catalog" envconfig:"REMOTE_CATALOG"`
+	RemoteMetadataCacheTTL            string             `yaml:"remote_metadata_cache_ttl" envconfig:"REMOTE_METADATA_CACHE_TTL"`
+	StalledStreamTimeout              string             `yaml:"stalled_stream_timeout" envconfig:"STALLED_STREAM_TIMEOUT"`
+	RemoteMaxIdleConnections          int                `yaml:"remote_max_idle_connections" envconfig:"REMOTE_MAX_IDLE_CONNECTIONS"`
+	RemoteIdleConnectionTimeout       string             `yaml:"remote_idle_connection_timeout" envconfig:"REMOTE_IDLE_CONNECTION_TIMEOUT"`
+	CompressionDictionaryMaxTableSize uint64             `yaml:"compression_dictionary_max_table_size" envconfig:"COMPRESSION_DICTIONARY_MAX_TABLE_SIZE"`
+	TableCompressionFormat            map[string]string  `yaml:"table_compression_format" envconfig:"TABLE_COMPRESSION_FORMAT"`
+	CompressionConcurrency            int                `yaml:"compression_concurrency" envconfig:"COMPRESSION_CONCURRENCY"`
+	AdaptiveCompressionLevel          bool               `yaml:"adaptive_compression_level" envconfig:"ADAPTIVE_COMPRESSION_LEVEL"`
+	SigningPrivateKeyFile             string             `yaml:"signing_private_key_file" envconfig:"SIGNING_PRIVATE_KEY_FILE"`
+	VerifyPublicKeyFile               string             `yaml:"verify_public_key_file" envconfig:"VERIFY_PUBLIC_KEY_FILE"`
+	IntegrityManifest                 bool               `yaml:"integrity_manifest" envconfig:"INTEGRITY_MANIFEST"`
+	ParityShards                      int                `yaml:"parity_shards" envconfig:"PARITY_SHARDS"`
+	ParityDataShards                  int                `yaml:"parity_data_shards" envconfig:"PARITY_DATA_SHARDS"`
+	RetentionPolicies                 []RetentionPolicy  `yaml:"retention_policies" envconfig:"RETENTION_POLICIES"`
+	RetentionRebaseIncrements         bool               `yaml:"retention_rebase_increments" envconfig:"RETENTION_REBASE_INCREMENTS"`
+	StorageCostPerGB                  map[string]float64 `yaml:"storage_cost_per_gb" envconfig:"STORAGE_COST_PER_GB"`
+	RestoreConcurrency                uint8              `yaml:"restore_concurrency" envconfig:"RESTORE_CONCURRENCY"`
+	RestoreAttachPause                string             `yaml:"restore_attach_pause" envconfig:"RESTORE_ATTACH_PAUSE"`
+	RestoreMaxBytesPerSecond          uint64             `yaml:"restore_max_bytes_per_second" envconfig:"RESTORE_MAX_BYTES_PER_SECOND"`
+	RestoreCPUNicePriority            int                `yaml:"restore_cpu_nice_priority" envconfig:"RESTORE_CPU_NICE_PRIORITY"`
+	RestoreIONicePriority             string             `yaml:"restore_io_nice_priority" envconfig:"RESTORE_IO_NICE_PRIORITY"`
+	IncrementalMaxBaseAge             string             `yaml:"incremental_max_base_age" envconfig:"INCREMENTAL_MAX_BASE_AGE"`
+	SecretsRefreshInterval            string             `yaml:"secrets_refresh_interval" envconfig:"SECRETS_REFRESH_INTERVAL"`
+	CheckDiskSpace                    bool               `yaml:"check_disk_space" envconfig:"CHECK_DISK_SPACE"`
+	StagingPath                       string             `yaml:"staging_path" envconfig:"STAGING_PATH"`
+	DiskSpaceReservePercent           float64            `yaml:"disk_space_reserve_percent" envconfig:"DISK_SPACE_RESERVE_PERCENT"`
+	RetriesDuration                   time.Duration
+	RetriesMaxPauseDuration           time.Duration
+	WatchDuration                     time.Duration
+	FullDuration                      time.Duration
+	RemoteMetadataCacheDuration       time.Duration
+	StalledStreamTimeoutDuration      time.Duration
+	RemoteIdleConnectionDuration      time.Duration
+	RestoreAttachPauseDuration        time.Duration
+	IncrementalMaxBaseAgeDuration     time.Duration
+	KeeperLockTTLDuration             time.Duration
+	WatchJitterDuration               time.Duration
+	WatchFailureBackoffDuration       time.Duration
+	ContinuousDuration                time.Duration
+	RestoreSchemaRewriteRules         []RestoreSchemaRewriteRule `yaml:"restore_schema_rewrite_rules" envconfig:"RESTORE_SCHEMA_REWRITE_RULES"`
+	Hooks                             []BackupHook               `yaml:"hooks" envconfig:"HOOKS"`
+	// FaultInjection* - undocumented, only for staging tests of retries, resume and verification, storage operations fail, streams slow down or truncate with given probability
+	FaultInjectionErrorRate    float64       `yaml:"fault_injection_error_rate,omitempty" envconfig:"FAULT_INJECTION_ERROR_RATE"`
+	FaultInjectionSlowRate     float64       `yaml:"fault_injection_slow_rate,omitempty" envconfig:"FAULT_INJECTION_SLOW_RATE"`
+	FaultInjectionSlowDelay    time.Duration `yaml:"fault_injection_slow_delay,omitempty" envconfig:"FAULT_INJECTION_SLOW_DELAY"`
+	FaultInjectionTruncateRate float64       `yaml:"fault_injection_truncate_rate,omitempty" envconfig:"FAULT_INJECTION_TRUNCATE_RATE"`
+}
+
+// RetentionPolicy - retention and watch schedule for remote backups which contain only databases matched with Databases patterns
+type RetentionPolicy struct {
+	Name                string   `yaml:"name"`
+	Databases           []string `yaml:"databases"`
+	BackupsToKeepRemote int      `yaml:"backups_to_keep_remote"`
+	KeepDaily           int      `yaml:"keep_daily"`
+	KeepMonthly         int      `yaml:"keep_monthly"`
+	MaxAge              string   `yaml:"max_age"`
+	WatchInterval       string   `yaml:"watch_interval"`
+	FullInterval        string   `yaml:"full_interval"`
+	MaxAgeDuration      time.Duration
+	WatchDuration       time.Duration
+	FullDuration        time.Duration
+}
+
+// Decode - envconfig format name=finance;databases=finance|audit;keep_monthly=84, items separated by comma
+func (p *RetentionPolicy) Decode(value string) error {
+	for _, field := range strings.Split(value, ";") {
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return fmt.Errorf("invalid RETENTION_POLICIES item %s, expected key=value pairs separated by semicolon", value)
+		}
+		var err error
+		switch key, fieldValue := strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1]); key {
+		case "name":
+			p.Name = fieldValue
+		case "databases":
+			p.Databases = strings.Split(fieldValue, "|")
+		case "backups_to_keep_remote":
+			p.BackupsToKeepRemote, err = strconv.Atoi(fieldValue)
+		case "keep_daily":
+			p.KeepDaily, err = strconv.Atoi(fieldValue)
+		case "keep_monthly":
+			p.KeepMonthly, err = strconv.Atoi(fieldValue)
+		case "max_age":
+			p.MaxAge = fieldValue
+		case "watch_interval":
+			p.WatchInterval = fieldValue
+		case "full_interval":
+			p.FullInterval = fieldValue
+		default:
+			return fmt.Errorf("invalid RETENTION_POLICIES item %s, unknown key %s", value, key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid RETENTION_POLICIES item %s: %v", value, err)
+		}
+	}
+	return nil
+}
+
+// RestoreSchemaRewriteRule - Match regexp replaced with Replace in CREATE query of tables matched with Tables patterns during restore,
+// Replace is text/template with {{.Database}} and {{.Table}} fields, result could contain $1 and ${name} regexp groups
+type RestoreSchemaRewriteRule struct {
+	Tables  []string `yaml:"tables"`
+	Match   string   `yaml:"match"`
+	Replace string   `yaml:"replace"`
+}
+
+// Decode - envconfig format tables=db.*|logs.*;match=regexp;replace=text, items separated by comma, so match and replace can't contain comma and semicolon
+func (r *RestoreSchemaRewriteRule) Decode(value string) error {
+	for _, field := range strings.Split(value, ";") {
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return fmt.Errorf("invalid RESTORE_SCHEMA_REWRITE_RULES item %s, expected key=value pairs separated by semicolon", value)
+		}
+		switch key, fieldValue := strings.TrimSpace(keyValue[0]), keyValue[1]; key {
+		case "tables":
+			r.Tables = strings.Split(strings.TrimSpace(fieldValue), "|")
+		case "match":
+			r.Match = fieldValue
+		case "replace":
+			r.Replace = fieldValue
+		default:
+			return fmt.Errorf("invalid RESTORE_SCHEMA_REWRITE_RULES item %s, unknown key %s", value, key)
+		}
+	}
+	return nil
+}
+
+// BackupHookStages - stages of create, upload and restore where general->hooks could execute
+var BackupHookStages = []string{"before_freeze", "after_freeze", "after_upload_table", "after_restore_schema", "after_restore"}
+
+// BackupHook - shell Command or HTTP POST to URL executed at Stage, OnFailure is `abort` (default) to fail operation or `ignore` to log error and continue
+type BackupHook struct {
+	Stage           string `yaml:"stage"`
+	Command         string `yaml:"command"`
+	URL             string `yaml:"url"`
+	Timeout         string `yaml:"timeout"`
+	OnFailure       string `yaml:"on_failure"`
+	TimeoutDuration time.Duration
+}
+
+// Decode - envconfig format stage=before_freeze;command=/path/to/script.sh;timeout=1m;on_failure=ignore, items separated by comma, so command and url can't contain comma and semicolon
+func (h *BackupHook) Decode(value string) error {
+	for _, field := range strings.Split(value, ";") {
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return fmt.Errorf("invalid HOOKS item %s, expected key=value pairs separated by semicolon", value)
+		}
+		switch key, fieldValue := strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1]); key {
+		case "stage":
+			h.Stage = fieldValue
+		case "command":
+			h.Command = fieldValue
+		case "url":
+			h.URL = fieldValue
+		case "timeout":
+			h.Timeout = fieldValue
+		case "on_failure":
+			h.OnFailure = fieldValue
+		default:
+			return fmt.Errorf("invalid HOOKS item %s, unknown key %s", value, key)
+		}
+	}
+	return nil
+}
+
+// GetRetentionPolicy - return nil when policy with name not defined in general->retention_policies
+func (cfg *Config) GetRetentionPolicy(name string) *RetentionPolicy {
+	for i := range cfg.General.RetentionPolicies {
+		if cfg.General.RetentionPolicies[i].Name == name {
+			return &cfg.General.RetentionPolicies[i]
+		}
+	}
+	return nil
 }
 
 // GCSConfig - GCS settings section
@@ -116,37 +331,66 @@ type AzureBlobConfig struct {
 
 // S3Config - s3 settings section
 type S3Config struct {
-	AccessKey               string            `yaml:"access_key" envconfig:"S3_ACCESS_KEY"`
-	SecretKey               string            `yaml:"secret_key" envconfig:"S3_SECRET_KEY"`
-	Bucket                  string            `yaml:"bucket" envconfig:"S3_BUCKET"`
-	Endpoint                string            `yaml:"endpoint" envconfig:"S3_ENDPOINT"`
-	Region                  string            `yaml:"region" envconfig:"S3_REGION"`
-	ACL                     string            `yaml:"acl" envconfig:"S3_ACL"`
-	AssumeRoleARN           string            `yaml:"assume_role_arn" envconfig:"S3_ASSUME_ROLE_ARN"`
-	ForcePathStyle          bool              `yaml:"force_path_style" envconfig:"S3_FORCE_PATH_STYLE"`
-	Path                    string            `yaml:"path" envconfig:"S3_PATH"`
-	ObjectDiskPath          string            `yaml:"object_disk_path" envconfig:"S3_OBJECT_DISK_PATH"`
-	DisableSSL              bool              `yaml:"disable_ssl" envconfig:"S3_DISABLE_SSL"`
-	CompressionLevel        int               `yaml:"compression_level" envconfig:"S3_COMPRESSION_LEVEL"`
-	CompressionFormat       string            `yaml:"compression_format" envconfig:"S3_COMPRESSION_FORMAT"`
-	SSE                     string            `yaml:"sse" envconfig:"S3_SSE"`
-	SSEKMSKeyId             string            `yaml:"sse_kms_key_id" envconfig:"S3_SSE_KMS_KEY_ID"`
-	SSECustomerAlgorithm    string            `yaml:"sse_customer_algorithm" envconfig:"S3_SSE_CUSTOMER_ALGORITHM"`
-	SSECustomerKey          string            `yaml:"sse_customer_key" envconfig:"S3_SSE_CUSTOMER_KEY"`
-	SSECustomerKeyMD5       string            `yaml:"sse_customer_key_md5" envconfig:"S3_SSE_CUSTOMER_KEY_MD5"`
-	SSEKMSEncryptionContext string            `yaml:"sse_kms_encryption_context" envconfig:"S3_SSE_KMS_ENCRYPTION_CONTEXT"`
-	DisableCertVerification bool              `yaml:"disable_cert_verification" envconfig:"S3_DISABLE_CERT_VERIFICATION"`
-	UseCustomStorageClass   bool              `yaml:"use_custom_storage_class" envconfig:"S3_USE_CUSTOM_STORAGE_CLASS"`
-	StorageClass            string            `yaml:"storage_class" envconfig:"S3_STORAGE_CLASS"`
-	CustomStorageClassMap   map[string]string `yaml:"custom_storage_class_map" envconfig:"S3_CUSTOM_STORAGE_CLASS_MAP"`
-	Concurrency             int               `yaml:"concurrency" envconfig:"S3_CONCURRENCY"`
-	PartSize                int64             `yaml:"part_size" envconfig:"S3_PART_SIZE"`
-	MaxPartsCount           int64             `yaml:"max_parts_count" envconfig:"S3_MAX_PARTS_COUNT"`
-	AllowMultipartDownload  bool              `yaml:"allow_multipart_download" envconfig:"S3_ALLOW_MULTIPART_DOWNLOAD"`
-	ObjectLabels            map[string]string `yaml:"object_labels" envconfig:"S3_OBJECT_LABELS"`
-	RequestPayer            string            `yaml:"request_payer" envconfig:"S3_REQUEST_PAYER"`
-	CheckSumAlgorithm       string            `yaml:"check_sum_algorithm" envconfig:"S3_CHECKSUM_ALGORITHM"`
-	Debug                   bool              `yaml:"debug" envconfig:"S3_DEBUG"`
+	AccessKey                string                `yaml:"access_key" envconfig:"S3_ACCESS_KEY"`
+	SecretKey                string                `yaml:"secret_key" envconfig:"S3_SECRET_KEY"`
+	Bucket                   string                `yaml:"bucket" envconfig:"S3_BUCKET"`
+	Endpoint                 string                `yaml:"endpoint" envconfig:"S3_ENDPOINT"`
+	Region                   string                `yaml:"region" envconfig:"S3_REGION"`
+	ACL                      string                `yaml:"acl" envconfig:"S3_ACL"`
+	AssumeRoleARN            string                `yaml:"assume_role_arn" envconfig:"S3_ASSUME_ROLE_ARN"`
+	ForcePathStyle           bool                  `yaml:"force_path_style" envconfig:"S3_FORCE_PATH_STYLE"`
+	Path                     string                `yaml:"path" envconfig:"S3_PATH"`
+	ObjectDiskPath           string                `yaml:"object_disk_path" envconfig:"S3_OBJECT_DISK_PATH"`
+	DisableSSL               bool                  `yaml:"disable_ssl" envconfig:"S3_DISABLE_SSL"`
+	CompressionLevel         int                   `yaml:"compression_level" envconfig:"S3_COMPRESSION_LEVEL"`
+	CompressionFormat        string                `yaml:"compression_format" envconfig:"S3_COMPRESSION_FORMAT"`
+	SSE                      string                `yaml:"sse" envconfig:"S3_SSE"`
+	SSEKMSKeyId              string                `yaml:"sse_kms_key_id" envconfig:"S3_SSE_KMS_KEY_ID"`
+	SSECustomerAlgorithm     string                `yaml:"sse_customer_algorithm" envconfig:"S3_SSE_CUSTOMER_ALGORITHM"`
+	SSECustomerKey           string                `yaml:"sse_customer_key" envconfig:"S3_SSE_CUSTOMER_KEY"`
+	SSECustomerKeyMD5        string                `yaml:"sse_customer_key_md5" envconfig:"S3_SSE_CUSTOMER_KEY_MD5"`
+	SSEKMSEncryptionContext  string                `yaml:"sse_kms_encryption_context" envconfig:"S3_SSE_KMS_ENCRYPTION_CONTEXT"`
+	DisableCertVerification  bool                  `yaml:"disable_cert_verification" envconfig:"S3_DISABLE_CERT_VERIFICATION"`
+	UseCustomStorageClass    bool                  `yaml:"use_custom_storage_class" envconfig:"S3_USE_CUSTOM_STORAGE_CLASS"`
+	StorageClass             string                `yaml:"storage_class" envconfig:"S3_STORAGE_CLASS"`
+	CustomStorageClassMap    map[string]string     `yaml:"custom_storage_class_map" envconfig:"S3_CUSTOM_STORAGE_CLASS_MAP"`
+	Concurrency              int                   `yaml:"concurrency" envconfig:"S3_CONCURRENCY"`
+	PartSize                 int64                 `yaml:"part_size" envconfig:"S3_PART_SIZE"`
+	MaxPartsCount            int64                 `yaml:"max_parts_count" envconfig:"S3_MAX_PARTS_COUNT"`
+	BufferSize               int                   `yaml:"buffer_size" envconfig:"S3_BUFFER_SIZE"`
+	AllowMultipartDownload   bool                  `yaml:"allow_multipart_download" envconfig:"S3_ALLOW_MULTIPART_DOWNLOAD"`
+	ObjectLabels             map[string]string     `yaml:"object_labels" envconfig:"S3_OBJECT_LABELS"`
+	RequestPayer             string                `yaml:"request_payer" envconfig:"S3_REQUEST_PAYER"`
+	CheckSumAlgorithm        string                `yaml:"check_sum_algorithm" envconfig:"S3_CHECKSUM_ALGORITHM"`
+	ObjectLockMode           string                `yaml:"object_lock_mode" envconfig:"S3_OBJECT_LOCK_MODE"`
+	ObjectLockRetain         string                `yaml:"object_lock_retain" envconfig:"S3_OBJECT_LOCK_RETAIN"`
+	ObjectLockLegalHold      bool                  `yaml:"object_lock_legal_hold" envconfig:"S3_OBJECT_LOCK_LEGAL_HOLD"`
+	ReadReplicas             []S3ReadReplicaConfig `yaml:"read_replicas" envconfig:"S3_READ_REPLICAS"`
+	FailoverEndpoints        []string              `yaml:"failover_endpoints" envconfig:"S3_FAILOVER_ENDPOINTS"`
+	HealthCheckInterval      string                `yaml:"health_check_interval" envconfig:"S3_HEALTH_CHECK_INTERVAL"`
+	Debug                    bool                  `yaml:"debug" envconfig:"S3_DEBUG"`
+	ObjectLockRetainDuration time.Duration
+	HealthCheckDuration      time.Duration
+}
+
+// S3ReadReplicaConfig - replication target of s3 bucket, used for read operations when primary bucket is unavailable
+type S3ReadReplicaConfig struct {
+	Bucket   string `yaml:"bucket"`
+	Region   string `yaml:"region"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Decode - envconfig format bucket@region or bucket@region@endpoint
+func (r *S3ReadReplicaConfig) Decode(value string) error {
+	fields := strings.SplitN(value, "@", 3)
+	if len(fields) < 2 || fields[0] == "" {
+		return fmt.Errorf("invalid S3_READ_REPLICAS item %s, expected bucket@region or bucket@region@endpoint", value)
+	}
+	r.Bucket, r.Region = fields[0], fields[1]
+	if len(fields) == 3 {
+		r.Endpoint = fields[2]
+	}
+	return nil
 }
 
 // COSConfig - cos settings section
@@ -197,6 +441,7 @@ type CustomConfig struct {
 	UploadCommand          string `yaml:"upload_command" envconfig:"CUSTOM_UPLOAD_COMMAND"`
 	DownloadCommand        string `yaml:"download_command" envconfig:"CUSTOM_DOWNLOAD_COMMAND"`
 	ListCommand            string `yaml:"list_command" envconfig:"CUSTOM_LIST_COMMAND"`
+	PluginCommand          string `yaml:"plugin_command" envconfig:"CUSTOM_PLUGIN_COMMAND"`
 	DeleteCommand          string `yaml:"delete_command" envconfig:"CUSTOM_DELETE_COMMAND"`
 	CommandTimeout         string `yaml:"command_timeout" envconfig:"CUSTOM_COMMAND_TIMEOUT"`
 	CommandTimeoutDuration time.Duration
@@ -211,46 +456,108 @@ type ClickHouseConfig struct {
 	DiskMapping                      map[string]string `yaml:"disk_mapping" envconfig:"CLICKHOUSE_DISK_MAPPING"`
 	SkipTables                       []string          `yaml:"skip_tables" envconfig:"CLICKHOUSE_SKIP_TABLES"`
 	SkipTableEngines                 []string          `yaml:"skip_table_engines" envconfig:"CLICKHOUSE_SKIP_TABLE_ENGINES"`
+	SkipDataTableEngines             []string          `yaml:"skip_data_table_engines" envconfig:"CLICKHOUSE_SKIP_DATA_TABLE_ENGINES"`
 	Timeout                          string            `yaml:"timeout" envconfig:"CLICKHOUSE_TIMEOUT"`
 	FreezeByPart                     bool              `yaml:"freeze_by_part" envconfig:"CLICKHOUSE_FREEZE_BY_PART"`
 	FreezeByPartWhere                string            `yaml:"freeze_by_part_where" envconfig:"CLICKHOUSE_FREEZE_BY_PART_WHERE"`
-	UseEmbeddedBackupRestore         bool              `yaml:"use_embedded_backup_restore" envconfig:"CLICKHOUSE_USE_EMBEDDED_BACKUP_RESTORE"`
-	EmbeddedBackupDisk               string            `yaml:"embedded_backup_disk" envconfig:"CLICKHOUSE_EMBEDDED_BACKUP_DISK"`
-	EmbeddedBackupThreads            uint8             `yaml:"embedded_backup_threads" envconfig:"CLICKHOUSE_EMBEDDED_BACKUP_THREADS"`
-	EmbeddedRestoreThreads           uint8             `yaml:"embedded_restore_threads" envconfig:"CLICKHOUSE_EMBEDDED_RESTORE_THREADS"`
-	BackupMutations                  bool              `yaml:"backup_mutations" envconfig:"CLICKHOUSE_BACKUP_MUTATIONS"`
-	RestoreAsAttach                  bool              `yaml:"restore_as_attach" envconfig:"CLICKHOUSE_RESTORE_AS_ATTACH"`
-	CheckPartsColumns                bool              `yaml:"check_parts_columns" envconfig:"CLICKHOUSE_CHECK_PARTS_COLUMNS"`
-	Secure                           bool              `yaml:"secure" envconfig:"CLICKHOUSE_SECURE"`
-	SkipVerify                       bool              `yaml:"skip_verify" envconfig:"CLICKHOUSE_SKIP_VERIFY"`
-	SyncReplicatedTables             bool              `yaml:"sync_replicated_tables" envconfig:"CLICKHOUSE_SYNC_REPLICATED_TABLES"`
-	LogSQLQueries                    bool              `yaml:"log_sql_queries" envconfig:"CLICKHOUSE_LOG_SQL_QUERIES"`
-	ConfigDir                        string            `yaml:"config_dir" envconfig:"CLICKHOUSE_CONFIG_DIR"`
-	RestartCommand                   string            `yaml:"restart_command" envconfig:"CLICKHOUSE_RESTART_COMMAND"`
-	IgnoreNotExistsErrorDuringFreeze bool              `yaml:"ignore_not_exists_error_during_freeze" envconfig:"CLICKHOUSE_IGNORE_NOT_EXISTS_ERROR_DURING_FREEZE"`
-	CheckReplicasBeforeAttach        bool              `yaml:"check_replicas_before_attach" envconfig:"CLICKHOUSE_CHECK_REPLICAS_BEFORE_ATTACH"`
-	TLSKey                           string            `yaml:"tls_key" envconfig:"CLICKHOUSE_TLS_KEY"`
-	TLSCert                          string            `yaml:"tls_cert" envconfig:"CLICKHOUSE_TLS_CERT"`
-	TLSCa                            string            `yaml:"tls_ca" envconfig:"CLICKHOUSE_TLS_CA"`
-	MaxConnections                   int               `yaml:"max_connections" envconfig:"CLICKHOUSE_MAX_CONNECTIONS"`
-	Debug                            bool              `yaml:"debug" envconfig:"CLICKHOUSE_DEBUG"`
+	FreezeByPartMinPartitions        int               `yaml:"freeze_by_part_min_partitions" envconfig:"CLICKHOUSE_FREEZE_BY_PART_MIN_PARTITIONS"`
+	FreezeByPartBatchSize            int               `yaml:"freeze_by_part_batch_size" envconfig:"CLICKHOUSE_FREEZE_BY_PART_BATCH_SIZE"`
+	FreezeByPartBatchPause           string            `yaml:"freeze_by_part_batch_pause" envconfig:"CLICKHOUSE_FREEZE_BY_PART_BATCH_PAUSE"`
+	FreezeByPartBatchPauseDuration   time.Duration
+	UseEmbeddedBackupRestore         bool     `yaml:"use_embedded_backup_restore" envconfig:"CLICKHOUSE_USE_EMBEDDED_BACKUP_RESTORE"`
+	EmbeddedBackupDisk               string   `yaml:"embedded_backup_disk" envconfig:"CLICKHOUSE_EMBEDDED_BACKUP_DISK"`
+	EmbeddedBackupThreads            uint8    `yaml:"embedded_backup_threads" envconfig:"CLICKHOUSE_EMBEDDED_BACKUP_THREADS"`
+	EmbeddedRestoreThreads           uint8    `yaml:"embedded_restore_threads" envconfig:"CLICKHOUSE_EMBEDDED_RESTORE_THREADS"`
+	AttachReadOnlyDisk               string   `yaml:"attach_readonly_disk" envconfig:"CLICKHOUSE_ATTACH_READONLY_DISK"`
+	BackupMutations                  bool     `yaml:"backup_mutations" envconfig:"CLICKHOUSE_BACKUP_MUTATIONS"`
+	RestoreAsAttach                  bool     `yaml:"restore_as_attach" envconfig:"CLICKHOUSE_RESTORE_AS_ATTACH"`
+	CheckPartsColumns                bool     `yaml:"check_parts_columns" envconfig:"CLICKHOUSE_CHECK_PARTS_COLUMNS"`
+	Secure                           bool     `yaml:"secure" envconfig:"CLICKHOUSE_SECURE"`
+	SkipVerify                       bool     `yaml:"skip_verify" envconfig:"CLICKHOUSE_SKIP_VERIFY"`
+	SyncReplicatedTables             bool     `yaml:"sync_replicated_tables" envconfig:"CLICKHOUSE_SYNC_REPLICATED_TABLES"`
+	LogSQLQueries                    bool     `yaml:"log_sql_queries" envconfig:"CLICKHOUSE_LOG_SQL_QUERIES"`
+	ConfigDir                        string   `yaml:"config_dir" envconfig:"CLICKHOUSE_CONFIG_DIR"`
+	RestartCommand                   string   `yaml:"restart_command" envconfig:"CLICKHOUSE_RESTART_COMMAND"`
+	LocalCommand                     string   `yaml:"local_command" envconfig:"CLICKHOUSE_LOCAL_COMMAND"`
+	FilesystemSnapshotType           string   `yaml:"filesystem_snapshot_type" envconfig:"CLICKHOUSE_FILESYSTEM_SNAPSHOT_TYPE"`
+	FilesystemSnapshotVolume         string   `yaml:"filesystem_snapshot_volume" envconfig:"CLICKHOUSE_FILESYSTEM_SNAPSHOT_VOLUME"`
+	FilesystemSnapshotMountPoint     string   `yaml:"filesystem_snapshot_mount_point" envconfig:"CLICKHOUSE_FILESYSTEM_SNAPSHOT_MOUNT_POINT"`
+	FilesystemSnapshotSize           string   `yaml:"filesystem_snapshot_size" envconfig:"CLICKHOUSE_FILESYSTEM_SNAPSHOT_SIZE"`
+	CloudSnapshotType                string   `yaml:"cloud_snapshot_type" envconfig:"CLICKHOUSE_CLOUD_SNAPSHOT_TYPE"`
+	CloudSnapshotVolumes             []string `yaml:"cloud_snapshot_volumes" envconfig:"CLICKHOUSE_CLOUD_SNAPSHOT_VOLUMES"`
+	CloudSnapshotZone                string   `yaml:"cloud_snapshot_zone" envconfig:"CLICKHOUSE_CLOUD_SNAPSHOT_ZONE"`
+	CloudSnapshotResourceGroup       string   `yaml:"cloud_snapshot_resource_group" envconfig:"CLICKHOUSE_CLOUD_SNAPSHOT_RESOURCE_GROUP"`
+	IgnoreNotExistsErrorDuringFreeze bool     `yaml:"ignore_not_exists_error_during_freeze" envconfig:"CLICKHOUSE_IGNORE_NOT_EXISTS_ERROR_DURING_FREEZE"`
+	CheckReplicasBeforeAttach        bool     `yaml:"check_replicas_before_attach" envconfig:"CLICKHOUSE_CHECK_REPLICAS_BEFORE_ATTACH"`
+	TLSKey                           string   `yaml:"tls_key" envconfig:"CLICKHOUSE_TLS_KEY"`
+	TLSCert                          string   `yaml:"tls_cert" envconfig:"CLICKHOUSE_TLS_CERT"`
+	TLSCa                            string   `yaml:"tls_ca" envconfig:"CLICKHOUSE_TLS_CA"`
+	MaxConnections                   int      `yaml:"max_connections" envconfig:"CLICKHOUSE_MAX_CONNECTIONS"`
+	Debug                            bool     `yaml:"debug" envconfig:"CLICKHOUSE_DEBUG"`
 }
 
 type APIConfig struct {
-	ListenAddr                    string `yaml:"listen" envconfig:"API_LISTEN"`
-	EnableMetrics                 bool   `yaml:"enable_metrics" envconfig:"API_ENABLE_METRICS"`
-	EnablePprof                   bool   `yaml:"enable_pprof" envconfig:"API_ENABLE_PPROF"`
-	Username                      string `yaml:"username" envconfig:"API_USERNAME"`
-	Password                      string `yaml:"password" envconfig:"API_PASSWORD"`
-	Secure                        bool   `yaml:"secure" envconfig:"API_SECURE"`
-	CertificateFile               string `yaml:"certificate_file" envconfig:"API_CERTIFICATE_FILE"`
-	PrivateKeyFile                string `yaml:"private_key_file" envconfig:"API_PRIVATE_KEY_FILE"`
-	CAKeyFile                     string `yaml:"ca_cert_file" envconfig:"API_CA_KEY_FILE"`
-	CACertFile                    string `yaml:"ca_key_file" envconfig:"API_CA_CERT_FILE"`
-	CreateIntegrationTables       bool   `yaml:"create_integration_tables" envconfig:"API_CREATE_INTEGRATION_TABLES"`
-	IntegrationTablesHost         string `yaml:"integration_tables_host" envconfig:"API_INTEGRATION_TABLES_HOST"`
-	AllowParallel                 bool   `yaml:"allow_parallel" envconfig:"API_ALLOW_PARALLEL"`
-	CompleteResumableAfterRestart bool   `yaml:"complete_resumable_after_restart" envconfig:"API_COMPLETE_RESUMABLE_AFTER_RESTART"`
+	ListenAddr                    string            `yaml:"listen" envconfig:"API_LISTEN"`
+	EnableMetrics                 bool              `yaml:"enable_metrics" envconfig:"API_ENABLE_METRICS"`
+	EnablePprof                   bool              `yaml:"enable_pprof" envconfig:"API_ENABLE_PPROF"`
+	EnableSwaggerUI               bool              `yaml:"enable_swagger_ui" envconfig:"API_ENABLE_SWAGGER_UI"`
+	SwaggerUIAssetsURL            string            `yaml:"swagger_ui_assets_url" envconfig:"API_SWAGGER_UI_ASSETS_URL"`
+	Username                      string            `yaml:"username" envconfig:"API_USERNAME"`
+	Password                      string            `yaml:"password" envconfig:"API_PASSWORD"`
+	Users                         []APIUser         `yaml:"users" envconfig:"API_USERS"`
+	Secure                        bool              `yaml:"secure" envconfig:"API_SECURE"`
+	CertificateFile               string            `yaml:"certificate_file" envconfig:"API_CERTIFICATE_FILE"`
+	PrivateKeyFile                string            `yaml:"private_key_file" envconfig:"API_PRIVATE_KEY_FILE"`
+	CAKeyFile                     string            `yaml:"ca_cert_file" envconfig:"API_CA_KEY_FILE"`
+	CACertFile                    string            `yaml:"ca_key_file" envconfig:"API_CA_CERT_FILE"`
+	CreateIntegrationTables       bool              `yaml:"create_integration_tables" envconfig:"API_CREATE_INTEGRATION_TABLES"`
+	IntegrationTablesHost         string            `yaml:"integration_tables_host" envconfig:"API_INTEGRATION_TABLES_HOST"`
+	AllowParallel                 bool              `yaml:"allow_parallel" envconfig:"API_ALLOW_PARALLEL"`
+	MaxConcurrentOperations       int               `yaml:"max_concurrent_operations" envconfig:"API_MAX_CONCURRENT_OPERATIONS"`
+	CompleteResumableAfterRestart bool              `yaml:"complete_resumable_after_restart" envconfig:"API_COMPLETE_RESUMABLE_AFTER_RESTART"`
+	GRPCListenAddr                string            `yaml:"grpc_listen" envconfig:"API_GRPC_LISTEN"`
+	QueueSize                     int               `yaml:"queue_size" envconfig:"API_QUEUE_SIZE"`
+	JobsHistoryFile               string            `yaml:"jobs_history_file" envconfig:"API_JOBS_HISTORY_FILE"`
+	ClientCertAuth                string            `yaml:"client_cert_auth" envconfig:"API_CLIENT_CERT_AUTH"`
+	ClientCertOperators           []string          `yaml:"client_cert_operators" envconfig:"API_CLIENT_CERT_OPERATORS"`
+	ClientCertReadOnly            []string          `yaml:"client_cert_read_only" envconfig:"API_CLIENT_CERT_READ_ONLY"`
+	ClientCertAdmins              []string          `yaml:"client_cert_admins" envconfig:"API_CLIENT_CERT_ADMINS"`
+	OIDCIssuer                    string            `yaml:"oidc_issuer" envconfig:"API_OIDC_ISSUER"`
+	OIDCAudience                  string            `yaml:"oidc_audience" envconfig:"API_OIDC_AUDIENCE"`
+	OIDCJWKSURL                   string            `yaml:"oidc_jwks_url" envconfig:"API_OIDC_JWKS_URL"`
+	OIDCRequiredClaims            map[string]string `yaml:"oidc_required_claims" envconfig:"API_OIDC_REQUIRED_CLAIMS"`
+	OIDCRolesClaim                string            `yaml:"oidc_roles_claim" envconfig:"API_OIDC_ROLES_CLAIM"`
+	OIDCOperatorRole              string            `yaml:"oidc_operator_role" envconfig:"API_OIDC_OPERATOR_ROLE"`
+	OIDCReadOnlyRole              string            `yaml:"oidc_read_only_role" envconfig:"API_OIDC_READ_ONLY_ROLE"`
+	OIDCAdminRole                 string            `yaml:"oidc_admin_role" envconfig:"API_OIDC_ADMIN_ROLE"`
+	DrainTimeout                  string            `yaml:"drain_timeout" envconfig:"API_DRAIN_TIMEOUT"`
+	WedgedJobTimeout              string            `yaml:"wedged_job_timeout" envconfig:"API_WEDGED_JOB_TIMEOUT"`
+	ProbeTimeout                  string            `yaml:"probe_timeout" envconfig:"API_PROBE_TIMEOUT"`
+	ClusterTimeout                string            `yaml:"cluster_timeout" envconfig:"API_CLUSTER_TIMEOUT"`
+	DrainTimeoutDuration          time.Duration
+	WedgedJobTimeoutDuration      time.Duration
+	ProbeTimeoutDuration          time.Duration
+	ClusterTimeoutDuration        time.Duration
+}
+
+// APIUserRoles - read_only allows list and status, operator allows create, upload, download, admin allows everything including delete and restore
+var APIUserRoles = []string{"read_only", "operator", "admin"}
+
+// APIUser - basic auth user with role from APIUserRoles
+type APIUser struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+	Role     string `yaml:"role"`
+}
+
+// Decode - envconfig format name:password:role
+func (u *APIUser) Decode(value string) error {
+	fields := strings.SplitN(value, ":", 3)
+	if len(fields) != 3 || fields[0] == "" {
+		return fmt.Errorf("invalid API_USERS item, expected name:password:role")
+	}
+	u.Name, u.Password, u.Role = fields[0], fields[1], fields[2]
+	return nil
 }
 
 // ArchiveExtensions - list of available compression formats and associated file extensions
@@ -306,6 +613,45 @@ func (cfg *Config) GetCompressionFormat() string {
 	}
 }
 
+// GetTableCompressionFormat - format from general->table_compression_format for the first pattern in alphabetical order which matches database.table, otherwise compression_format of general->remote_storage section
+func (cfg *Config) GetTableCompressionFormat(database, table string) string {
+	if len(cfg.General.TableCompressionFormat) == 0 {
+		return cfg.GetCompressionFormat()
+	}
+	patterns := make([]string, 0, len(cfg.General.TableCompressionFormat))
+	for pattern := range cfg.General.TableCompressionFormat {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	tableName := fmt.Sprintf("%s.%s", database, table)
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, tableName); matched {
+			return cfg.General.TableCompressionFormat[pattern]
+		}
+	}
+	return cfg.GetCompressionFormat()
+}
+
+// GetCompressionLevel - compression_level from general->remote_storage section
+func (cfg *Config) GetCompressionLevel() int {
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		return cfg.S3.CompressionLevel
+	case "gcs":
+		return cfg.GCS.CompressionLevel
+	case "cos":
+		return cfg.COS.CompressionLevel
+	case "ftp":
+		return cfg.FTP.CompressionLevel
+	case "sftp":
+		return cfg.SFTP.CompressionLevel
+	case "azblob":
+		return cfg.AzureBlob.CompressionLevel
+	default:
+		return 0
+	}
+}
+
 var freezeByPartBeginAndRE = regexp.MustCompile(`(?im)^\s*AND\s+`)
 
 // LoadConfig - load config from file + environment variables
@@ -333,16 +679,20 @@ func LoadConfig(configLocation string) (*Config, error) {
 	if (cfg.General.RemoteStorage == "gcs" || cfg.General.RemoteStorage == "azblob" || cfg.General.RemoteStorage == "cos") && cfgWithoutDefault.General.UploadConcurrency == 0 {
 		cfg.General.UploadConcurrency = uint8(runtime.NumCPU() / 2)
 	}
+	if err = cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
 	cfg.AzureBlob.Path = strings.TrimPrefix(cfg.AzureBlob.Path, "/")
 	cfg.S3.Path = strings.TrimPrefix(cfg.S3.Path, "/")
 	cfg.GCS.Path = strings.TrimPrefix(cfg.GCS.Path, "/")
 
 	// https://github.com/Altinity/clickhouse-backup/issues/855
-	if cfg.ClickHouse.FreezeByPart && cfg.ClickHouse.FreezeByPartWhere != "" && !freezeByPartBeginAndRE.MatchString(cfg.ClickHouse.FreezeByPartWhere) {
+	if (cfg.ClickHouse.FreezeByPart || cfg.ClickHouse.FreezeByPartMinPartitions > 0) && cfg.ClickHouse.FreezeByPartWhere != "" && !freezeByPartBeginAndRE.MatchString(cfg.ClickHouse.FreezeByPartWhere) {
 		cfg.ClickHouse.FreezeByPartWhere = " AND " + cfg.ClickHouse.FreezeByPartWhere
 	}
 
 	log.SetLevelFromString(cfg.General.LogLevel)
+	logcli.SetFormat(cfg.General.LogFormat)
 
 	if err = ValidateConfig(cfg); err != nil {
 		return cfg, err
@@ -366,6 +716,27 @@ func ValidateConfig(cfg *Config) error {
 	if cfg.GetCompressionFormat() == "lz4" {
 		return fmt.Errorf("clickhouse already compressed data by lz4")
 	}
+	if cfg.General.LogFormat != "" && cfg.General.LogFormat != "text" && cfg.General.LogFormat != "json" {
+		return fmt.Errorf("general->log_format shall be `text` or `json`, got `%s`", cfg.General.LogFormat)
+	}
+	if cfg.General.UploadPartArchiveSize < 0 || cfg.General.UploadPartMaxArchives < 0 {
+		return fmt.Errorf("upload_part_archive_size=%d and upload_part_max_archives=%d shall be 0 or positive", cfg.General.UploadPartArchiveSize, cfg.General.UploadPartMaxArchives)
+	}
+	if cfg.General.UploadMaxTableArchives < 0 {
+		return fmt.Errorf("upload_max_table_archives=%d shall be 0 or positive", cfg.General.UploadMaxTableArchives)
+	}
+	if cfg.General.UploadFailurePolicy != "" && cfg.General.UploadFailurePolicy != "fail_fast" && cfg.General.UploadFailurePolicy != "continue" && cfg.General.UploadFailurePolicy != "threshold" {
+		return fmt.Errorf("general->upload_failure_policy shall be fail_fast, continue or threshold, actual %s", cfg.General.UploadFailurePolicy)
+	}
+	if cfg.General.UploadFailurePolicy == "threshold" && cfg.General.UploadMaxFailedTables <= 0 {
+		return fmt.Errorf("general->upload_max_failed_tables shall be positive for upload_failure_policy: threshold, actual %d", cfg.General.UploadMaxFailedTables)
+	}
+	if cfg.General.ParityShards < 0 || (cfg.General.ParityShards > 0 && (cfg.General.ParityDataShards <= 0 || cfg.General.ParityDataShards+cfg.General.ParityShards > 256)) {
+		return fmt.Errorf("parity_shards=%d shall be 0 or positive, parity_data_shards=%d shall be positive, sum shall be less or equal 256", cfg.General.ParityShards, cfg.General.ParityDataShards)
+	}
+	if cfg.General.ParityShards > 0 && cfg.GetCompressionFormat() == "none" {
+		return fmt.Errorf("parity_shards=%d is not supported for `compression_format: none`", cfg.General.ParityShards)
+	}
 	if _, ok := ArchiveExtensions[cfg.GetCompressionFormat()]; !ok && cfg.GetCompressionFormat() != "none" {
 		return fmt.Errorf("'%s' is unsupported compression format", cfg.GetCompressionFormat())
 	}
@@ -379,6 +750,46 @@ func ValidateConfig(cfg *Config) error {
 	if cfg.ClickHouse.FreezeByPart && cfg.ClickHouse.UseEmbeddedBackupRestore {
 		return fmt.Errorf("`freeze_by_part: %v` is not compatible with `use_embedded_backup_restore: %v`", cfg.ClickHouse.FreezeByPart, cfg.ClickHouse.UseEmbeddedBackupRestore)
 	}
+	switch cfg.ClickHouse.FilesystemSnapshotType {
+	case "":
+	case "lvm", "zfs", "btrfs":
+		if cfg.ClickHouse.FilesystemSnapshotVolume == "" || cfg.ClickHouse.FilesystemSnapshotMountPoint == "" {
+			return fmt.Errorf("`filesystem_snapshot_type: %s` requires `filesystem_snapshot_volume` and `filesystem_snapshot_mount_point`", cfg.ClickHouse.FilesystemSnapshotType)
+		}
+		if cfg.ClickHouse.UseEmbeddedBackupRestore {
+			return fmt.Errorf("`filesystem_snapshot_type: %s` is not compatible with `use_embedded_backup_restore: true`", cfg.ClickHouse.FilesystemSnapshotType)
+		}
+	default:
+		return fmt.Errorf("unsupported `filesystem_snapshot_type: %s`, shall be lvm, zfs or btrfs", cfg.ClickHouse.FilesystemSnapshotType)
+	}
+	switch cfg.ClickHouse.CloudSnapshotType {
+	case "":
+	case "aws_ebs", "gcp_pd", "azure_disk":
+		if len(cfg.ClickHouse.CloudSnapshotVolumes) == 0 {
+			return fmt.Errorf("`cloud_snapshot_type: %s` requires `cloud_snapshot_volumes`", cfg.ClickHouse.CloudSnapshotType)
+		}
+		if cfg.ClickHouse.CloudSnapshotType == "gcp_pd" && cfg.ClickHouse.CloudSnapshotZone == "" {
+			return fmt.Errorf("`cloud_snapshot_type: gcp_pd` requires `cloud_snapshot_zone`")
+		}
+		if cfg.ClickHouse.CloudSnapshotType == "azure_disk" && cfg.ClickHouse.CloudSnapshotResourceGroup == "" {
+			return fmt.Errorf("`cloud_snapshot_type: azure_disk` requires `cloud_snapshot_resource_group`")
+		}
+		if cfg.ClickHouse.FilesystemSnapshotType != "" || cfg.ClickHouse.UseEmbeddedBackupRestore {
+			return fmt.Errorf("`cloud_snapshot_type: %s` is not compatible with `filesystem_snapshot_type` and `use_embedded_backup_restore: true`", cfg.ClickHouse.CloudSnapshotType)
+		}
+	default:
+		return fmt.Errorf("unsupported `cloud_snapshot_type: %s`, shall be aws_ebs, gcp_pd or azure_disk", cfg.ClickHouse.CloudSnapshotType)
+	}
+	if cfg.ClickHouse.FreezeByPartMinPartitions < 0 || cfg.ClickHouse.FreezeByPartBatchSize < 0 {
+		return fmt.Errorf("`freeze_by_part_min_partitions: %d` and `freeze_by_part_batch_size: %d` shall not be negative", cfg.ClickHouse.FreezeByPartMinPartitions, cfg.ClickHouse.FreezeByPartBatchSize)
+	}
+	if cfg.ClickHouse.FreezeByPartBatchPause != "" {
+		if duration, err := time.ParseDuration(cfg.ClickHouse.FreezeByPartBatchPause); err != nil {
+			return fmt.Errorf("invalid freeze_by_part_batch_pause: %v", err)
+		} else {
+			cfg.ClickHouse.FreezeByPartBatchPauseDuration = duration
+		}
+	}
 	if _, err := time.ParseDuration(cfg.COS.Timeout); err != nil {
 		return fmt.Errorf("invalid cos timeout: %v", err)
 	}
@@ -413,6 +824,135 @@ func ValidateConfig(cfg *Config) error {
 			cfg.S3.Concurrency,
 		)
 	}
+	if cfg.General.CompressionDictionaryMaxTableSize > 0 && cfg.General.RemoteStorage != "none" && cfg.General.RemoteStorage != "custom" && cfg.GetCompressionFormat() != "zstd" {
+		return fmt.Errorf("`compression_dictionary_max_table_size` require `compression_format: zstd` in `%s` config section, actual %s", cfg.General.RemoteStorage, cfg.GetCompressionFormat())
+	}
+	for pattern, format := range cfg.General.TableCompressionFormat {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("general->table_compression_format invalid table pattern %s: %v", pattern, err)
+		}
+		if format != "tar" && format != "none" {
+			return fmt.Errorf("general->table_compression_format[%s] shall be `tar` or `none`, actual %s", pattern, format)
+		}
+		if cfg.GetCompressionFormat() == "none" {
+			return fmt.Errorf("general->table_compression_format require archive `compression_format` in `%s` config section, actual none", cfg.General.RemoteStorage)
+		}
+		if format == "none" && !cfg.General.UploadByPart {
+			return fmt.Errorf("general->table_compression_format[%s]=none incompatible with general->upload_by_part=%v", pattern, cfg.General.UploadByPart)
+		}
+	}
+	if cfg.General.CompressionConcurrency < 0 {
+		return fmt.Errorf("general->compression_concurrency shall be >= 0, actual %d", cfg.General.CompressionConcurrency)
+	}
+	policyNames := map[string]bool{}
+	for i := range cfg.General.RetentionPolicies {
+		policy := &cfg.General.RetentionPolicies[i]
+		if policy.Name == "" || policy.Name == DefaultRetentionPolicy || policyNames[policy.Name] {
+			return fmt.Errorf("general->retention_policies[%d] shall have unique non empty name except %s, actual %q", i, DefaultRetentionPolicy, policy.Name)
+		}
+		policyNames[policy.Name] = true
+		if len(policy.Databases) == 0 {
+			return fmt.Errorf("general->retention_policies[%s] databases is empty", policy.Name)
+		}
+		for _, pattern := range policy.Databases {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("general->retention_policies[%s] invalid databases pattern %s: %v", policy.Name, pattern, err)
+			}
+		}
+		if policy.BackupsToKeepRemote < 0 || policy.KeepDaily < 0 || policy.KeepMonthly < 0 {
+			return fmt.Errorf("general->retention_policies[%s] backups_to_keep_remote, keep_daily and keep_monthly shall not be negative", policy.Name)
+		}
+		for _, interval := range []struct {
+			name     string
+			value    string
+			duration *time.Duration
+		}{
+			{"max_age", policy.MaxAge, &policy.MaxAgeDuration},
+			{"watch_interval", policy.WatchInterval, &policy.WatchDuration},
+			{"full_interval", policy.FullInterval, &policy.FullDuration},
+		} {
+			if interval.value == "" {
+				continue
+			}
+			duration, err := time.ParseDuration(interval.value)
+			if err != nil || duration <= 0 {
+				return fmt.Errorf("general->retention_policies[%s] invalid %s: %s, shall be positive duration, error: %v", policy.Name, interval.name, interval.value, err)
+			}
+			*interval.duration = duration
+		}
+	}
+	for i := range cfg.General.Hooks {
+		hook := &cfg.General.Hooks[i]
+		isKnownStage := false
+		for _, stage := range BackupHookStages {
+			if hook.Stage == stage {
+				isKnownStage = true
+				break
+			}
+		}
+		if !isKnownStage {
+			return fmt.Errorf("general->hooks[%d] invalid stage %q, shall be one of %s", i, hook.Stage, strings.Join(BackupHookStages, ", "))
+		}
+		if (hook.Command == "") == (hook.URL == "") {
+			return fmt.Errorf("general->hooks[%d] shall contain only one of command or url", i)
+		}
+		if hook.OnFailure == "" {
+			hook.OnFailure = "abort"
+		}
+		if hook.OnFailure != "abort" && hook.OnFailure != "ignore" {
+			return fmt.Errorf("general->hooks[%d] invalid on_failure %q, shall be abort or ignore", i, hook.OnFailure)
+		}
+		hook.TimeoutDuration = time.Minute
+		if hook.Timeout != "" {
+			duration, err := time.ParseDuration(hook.Timeout)
+			if err != nil || duration <= 0 {
+				return fmt.Errorf("general->hooks[%d] invalid timeout: %s, shall be positive duration, error: %v", i, hook.Timeout, err)
+			}
+			hook.TimeoutDuration = duration
+		}
+	}
+	for i, rule := range cfg.General.RestoreSchemaRewriteRules {
+		if rule.Match == "" {
+			return fmt.Errorf("general->restore_schema_rewrite_rules[%d] match is empty", i)
+		}
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("general->restore_schema_rewrite_rules[%d] invalid match regexp %s: %v", i, rule.Match, err)
+		}
+		if _, err := template.New("replace").Parse(rule.Replace); err != nil {
+			return fmt.Errorf("general->restore_schema_rewrite_rules[%d] invalid replace template %s: %v", i, rule.Replace, err)
+		}
+		for _, pattern := range rule.Tables {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("general->restore_schema_rewrite_rules[%d] invalid tables pattern %s: %v", i, pattern, err)
+			}
+		}
+	}
+	for _, replica := range cfg.S3.ReadReplicas {
+		if replica.Bucket == "" {
+			return fmt.Errorf("s3->read_replicas contains item with empty bucket: %#v", replica)
+		}
+	}
+	if len(cfg.S3.FailoverEndpoints) > 0 {
+		if cfg.S3.Endpoint == "" {
+			return fmt.Errorf("s3->failover_endpoints require s3->endpoint")
+		}
+		if duration, err := time.ParseDuration(cfg.S3.HealthCheckInterval); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid s3 health_check_interval: %s, shall be positive duration when failover_endpoints defined, error: %v", cfg.S3.HealthCheckInterval, err)
+		} else {
+			cfg.S3.HealthCheckDuration = duration
+		}
+	}
+	if cfg.S3.ObjectLockMode != "" {
+		cfg.S3.ObjectLockMode = strings.ToUpper(cfg.S3.ObjectLockMode)
+		if cfg.S3.ObjectLockMode != string(s3types.ObjectLockModeGovernance) && cfg.S3.ObjectLockMode != string(s3types.ObjectLockModeCompliance) {
+			return fmt.Errorf("'%s' is bad S3_OBJECT_LOCK_MODE, select one of: %#v", cfg.S3.ObjectLockMode, s3types.ObjectLockMode("").Values())
+		}
+		if duration, err := time.ParseDuration(cfg.S3.ObjectLockRetain); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid s3 object_lock_retain: %s, shall be positive duration when object_lock_mode defined, error: %v", cfg.S3.ObjectLockRetain, err)
+		} else {
+			cfg.S3.ObjectLockRetainDuration = duration
+		}
+	}
 	if cfg.API.Secure {
 		if cfg.API.CertificateFile == "" {
 			return fmt.Errorf("api.certificate_file must be defined")
@@ -425,6 +965,50 @@ func ValidateConfig(cfg *Config) error {
 			return err
 		}
 	}
+	if cfg.API.MaxConcurrentOperations < 0 {
+		return fmt.Errorf("invalid api max_concurrent_operations: %d, expected 0 or positive value", cfg.API.MaxConcurrentOperations)
+	}
+	for _, timeout := range []struct {
+		name     string
+		value    string
+		duration *time.Duration
+	}{
+		{"drain_timeout", cfg.API.DrainTimeout, &cfg.API.DrainTimeoutDuration},
+		{"wedged_job_timeout", cfg.API.WedgedJobTimeout, &cfg.API.WedgedJobTimeoutDuration},
+		{"probe_timeout", cfg.API.ProbeTimeout, &cfg.API.ProbeTimeoutDuration},
+		{"cluster_timeout", cfg.API.ClusterTimeout, &cfg.API.ClusterTimeoutDuration},
+	} {
+		if timeout.value == "" {
+			*timeout.duration = 0
+			continue
+		}
+		duration, err := time.ParseDuration(timeout.value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("invalid api %s: %s, shall be zero or positive duration, error: %v", timeout.name, timeout.value, err)
+		}
+		*timeout.duration = duration
+	}
+	if cfg.API.ClientCertAuth != "" && cfg.API.ClientCertAuth != "require" && cfg.API.ClientCertAuth != "verify_if_given" {
+		return fmt.Errorf("invalid api client_cert_auth: %s, expected require or verify_if_given", cfg.API.ClientCertAuth)
+	}
+	if (len(cfg.API.ClientCertOperators) > 0 || len(cfg.API.ClientCertReadOnly) > 0 || len(cfg.API.ClientCertAdmins) > 0) && cfg.API.CACertFile == "" {
+		return fmt.Errorf("api client_cert_admins, client_cert_operators and client_cert_read_only require ca_cert_file")
+	}
+	for _, user := range cfg.API.Users {
+		if user.Name == "" {
+			return fmt.Errorf("api users contains item with empty name")
+		}
+		validRole := false
+		for _, role := range APIUserRoles {
+			validRole = validRole || user.Role == role
+		}
+		if !validRole {
+			return fmt.Errorf("api users %s has invalid role %q, expected one of %v", user.Name, user.Role, APIUserRoles)
+		}
+	}
+	if cfg.API.OIDCIssuer != "" && cfg.API.OIDCAudience == "" {
+		return fmt.Errorf("api oidc_issuer requires oidc_audience")
+	}
 	if cfg.Custom.CommandTimeout != "" {
 		if duration, err := time.ParseDuration(cfg.Custom.CommandTimeout); err != nil {
 			return fmt.Errorf("invalid custom command timeout: %v", err)
@@ -443,6 +1027,80 @@ func ValidateConfig(cfg *Config) error {
 	} else {
 		return fmt.Errorf("empty retries pause")
 	}
+	if cfg.General.RetriesBackoff != "" && cfg.General.RetriesBackoff != "constant" && cfg.General.RetriesBackoff != "exponential" {
+		return fmt.Errorf("general->retries_backoff shall be constant or exponential, actual %s", cfg.General.RetriesBackoff)
+	}
+	if cfg.General.RetriesMaxPause != "" {
+		if duration, err := time.ParseDuration(cfg.General.RetriesMaxPause); err != nil {
+			return fmt.Errorf("invalid retries_max_pause: %v", err)
+		} else {
+			cfg.General.RetriesMaxPauseDuration = duration
+		}
+	}
+	if cfg.General.RetriesJitter < 0 || cfg.General.RetriesJitter > 1 {
+		return fmt.Errorf("general->retries_jitter=%v shall be between 0 and 1", cfg.General.RetriesJitter)
+	}
+	if cfg.General.RetriesBudget < 0 {
+		return fmt.Errorf("general->retries_budget shall be >= 0, actual %d", cfg.General.RetriesBudget)
+	}
+	if cfg.General.RemoteMetadataCacheTTL != "" {
+		if duration, err := time.ParseDuration(cfg.General.RemoteMetadataCacheTTL); err != nil {
+			return fmt.Errorf("invalid remote_metadata_cache_ttl: %v", err)
+		} else {
+			cfg.General.RemoteMetadataCacheDuration = duration
+		}
+	}
+	for name, rate := range map[string]float64{"fault_injection_error_rate": cfg.General.FaultInjectionErrorRate, "fault_injection_slow_rate": cfg.General.FaultInjectionSlowRate, "fault_injection_truncate_rate": cfg.General.FaultInjectionTruncateRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s=%v shall be between 0 and 1", name, rate)
+		}
+	}
+	if cfg.General.StalledStreamTimeout != "" {
+		if duration, err := time.ParseDuration(cfg.General.StalledStreamTimeout); err != nil {
+			return fmt.Errorf("invalid stalled_stream_timeout: %v", err)
+		} else {
+			cfg.General.StalledStreamTimeoutDuration = duration
+		}
+	}
+	if cfg.General.RemoteMaxIdleConnections < 0 {
+		return fmt.Errorf("general->remote_max_idle_connections shall be >= 0, actual %d", cfg.General.RemoteMaxIdleConnections)
+	}
+	if cfg.General.RemoteIdleConnectionTimeout != "" {
+		if duration, err := time.ParseDuration(cfg.General.RemoteIdleConnectionTimeout); err != nil {
+			return fmt.Errorf("invalid remote_idle_connection_timeout: %v", err)
+		} else {
+			cfg.General.RemoteIdleConnectionDuration = duration
+		}
+	}
+	if cfg.General.RestoreAttachPause != "" {
+		if duration, err := time.ParseDuration(cfg.General.RestoreAttachPause); err != nil {
+			return fmt.Errorf("invalid restore_attach_pause: %v", err)
+		} else {
+			cfg.General.RestoreAttachPauseDuration = duration
+		}
+	}
+	if cfg.General.KeeperLock {
+		if duration, err := time.ParseDuration(cfg.General.KeeperLockTTL); err != nil {
+			return fmt.Errorf("invalid keeper_lock_ttl: %v", err)
+		} else if duration < 3*time.Second {
+			return fmt.Errorf("keeper_lock_ttl shall be at least 3s, current value: %s", cfg.General.KeeperLockTTL)
+		} else {
+			cfg.General.KeeperLockTTLDuration = duration
+		}
+		if cfg.General.KeeperLockPath == "" {
+			return fmt.Errorf("keeper_lock_path can't be empty when keeper_lock: true")
+		}
+	}
+	if cfg.General.DiskSpaceReservePercent < 0 || cfg.General.DiskSpaceReservePercent >= 100 {
+		return fmt.Errorf("disk_space_reserve_percent shall be between 0 and 100, current value: %v", cfg.General.DiskSpaceReservePercent)
+	}
+	if cfg.General.IncrementalMaxBaseAge != "" {
+		if duration, err := time.ParseDuration(cfg.General.IncrementalMaxBaseAge); err != nil {
+			return fmt.Errorf("invalid incremental_max_base_age: %v", err)
+		} else {
+			cfg.General.IncrementalMaxBaseAgeDuration = duration
+		}
+	}
 	if cfg.General.WatchInterval != "" {
 		if duration, err := time.ParseDuration(cfg.General.WatchInterval); err != nil {
 			return fmt.Errorf("invalid watch interval: %v", err)
@@ -457,9 +1115,65 @@ func ValidateConfig(cfg *Config) error {
 			cfg.General.FullDuration = duration
 		}
 	}
+	if cfg.General.WatchJitter != "" {
+		if duration, err := time.ParseDuration(cfg.General.WatchJitter); err != nil {
+			return fmt.Errorf("invalid watch_jitter: %v", err)
+		} else {
+			cfg.General.WatchJitterDuration = duration
+		}
+	}
+	if cfg.General.WatchFailureBackoff != "" {
+		if duration, err := time.ParseDuration(cfg.General.WatchFailureBackoff); err != nil {
+			return fmt.Errorf("invalid watch_failure_backoff: %v", err)
+		} else {
+			cfg.General.WatchFailureBackoffDuration = duration
+		}
+	}
+	if cfg.General.WatchIncrementSchedule != "" && cfg.General.WatchFullSchedule == "" {
+		return fmt.Errorf("watch_increment_schedule requires watch_full_schedule")
+	}
+	if cfg.General.ContinuousInterval != "" {
+		if duration, err := time.ParseDuration(cfg.General.ContinuousInterval); err != nil {
+			return fmt.Errorf("invalid continuous_interval: %v", err)
+		} else {
+			cfg.General.ContinuousDuration = duration
+		}
+	}
 	return nil
 }
 
+// LoadDestinationConfig - return copy of config, where keys from general->remote_destinations[name] config file override current values
+func (cfg *Config) LoadDestinationConfig(name string) (*Config, error) {
+	if name == "" || name == PrimaryDestination {
+		return cfg, nil
+	}
+	destinationPath, exists := cfg.General.RemoteDestinations[name]
+	if !exists {
+		return nil, fmt.Errorf("'%s' is not found in general->remote_destinations", name)
+	}
+	currentYaml, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal config: %v", err)
+	}
+	destinationCfg := &Config{}
+	if err = yaml.Unmarshal(currentYaml, destinationCfg); err != nil {
+		return nil, fmt.Errorf("can't copy config: %v", err)
+	}
+	destinationYaml, err := os.ReadFile(destinationPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open destination %s config file: %v", name, err)
+	}
+	if err = yaml.Unmarshal(destinationYaml, destinationCfg); err != nil {
+		return nil, fmt.Errorf("can't parse destination %s config file: %v", name, err)
+	}
+	// avoid recursive destinations
+	destinationCfg.General.RemoteDestinations = nil
+	if err = ValidateConfig(destinationCfg); err != nil {
+		return nil, fmt.Errorf("invalid destination %s config: %v", name, err)
+	}
+	return destinationCfg, nil
+}
+
 func ValidateObjectDiskConfig(cfg *Config) error {
 	if !cfg.ClickHouse.UseEmbeddedBackupRestore {
 		switch cfg.General.RemoteStorage {
@@ -488,6 +1202,7 @@ func PrintConfig(ctx *cli.Context) error {
 	} else {
 		cfg = GetConfigFromCli(ctx)
 	}
+	cfg.restoreSecretReferences()
 	yml, _ := yaml.Marshal(&cfg)
 	fmt.Print(string(yml))
 	return nil
@@ -508,30 +1223,55 @@ func DefaultConfig() *Config {
 	}
 	return &Config{
 		General: GeneralConfig{
-			RemoteStorage:           "none",
-			MaxFileSize:             0,
-			BackupsToKeepLocal:      0,
-			BackupsToKeepRemote:     0,
-			LogLevel:                "info",
-			UploadConcurrency:       uploadConcurrency,
-			DownloadConcurrency:     downloadConcurrency,
-			RestoreSchemaOnCluster:  "",
-			UploadByPart:            true,
-			DownloadByPart:          true,
-			UseResumableState:       true,
-			RetriesOnFailure:        3,
-			RetriesPause:            "30s",
-			RetriesDuration:         100 * time.Millisecond,
-			WatchInterval:           "1h",
-			WatchDuration:           1 * time.Hour,
-			FullInterval:            "24h",
-			FullDuration:            24 * time.Hour,
-			WatchBackupNameTemplate: "shard{shard}-{type}-{time:20060102150405}",
-			RestoreDatabaseMapping:  make(map[string]string, 0),
-			IONicePriority:          "idle",
-			CPUNicePriority:         15,
-			RBACBackupAlways:        true,
-			RBACConflictResolution:  "recreate",
+			RemoteStorage:                "none",
+			MaxFileSize:                  0,
+			BackupsToKeepLocal:           0,
+			BackupsToKeepRemote:          0,
+			LogLevel:                     "info",
+			LogFormat:                    "text",
+			UploadConcurrency:            uploadConcurrency,
+			DownloadConcurrency:          downloadConcurrency,
+			RestoreSchemaOnCluster:       "",
+			RestoreSchemaFidelityCheck:   true,
+			UploadByPart:                 true,
+			UploadPartMaxArchives:        16,
+			UploadFailurePolicy:          "fail_fast",
+			DownloadByPart:               true,
+			UseResumableState:            true,
+			RetriesOnFailure:             3,
+			RetriesPause:                 "30s",
+			RetriesBackoff:               "constant",
+			RetriesMaxPause:              "5m",
+			RetriesMaxPauseDuration:      5 * time.Minute,
+			SecretsRefreshInterval:       "5m",
+			CheckDiskSpace:               true,
+			RetriesDuration:              100 * time.Millisecond,
+			WatchInterval:                "1h",
+			WatchDuration:                1 * time.Hour,
+			FullInterval:                 "24h",
+			FullDuration:                 24 * time.Hour,
+			WatchBackupNameTemplate:      "shard{shard}-{type}-{time:20060102150405}",
+			WatchCatchUp:                 true,
+			WatchFailureBackoff:          "1m",
+			WatchFailureBackoffDuration:  time.Minute,
+			ContinuousInterval:           "5m",
+			ContinuousDuration:           5 * time.Minute,
+			ContinuousMaxIncrements:      288,
+			ContinuousBackupNameTemplate: "continuous-shard{shard}-{type}-{time:20060102150405}",
+			RestoreDatabaseMapping:       make(map[string]string, 0),
+			IONicePriority:               "idle",
+			CPUNicePriority:              15,
+			RBACBackupAlways:             true,
+			RBACConflictResolution:       "recreate",
+			RemoteMetadataCacheTTL:       "1h",
+			KeeperLockPath:               "/clickhouse-backup/locks/{shard}",
+			KeeperLockTTL:                "10m",
+			RemoteMetadataCacheDuration:  time.Hour,
+			StalledStreamTimeout:         "10m",
+			StalledStreamTimeoutDuration: 10 * time.Minute,
+			RemoteIdleConnectionTimeout:  "90s",
+			RemoteIdleConnectionDuration: 90 * time.Second,
+			ParityDataShards:             10,
 		},
 		ClickHouse: ClickHouseConfig{
 			Username: "default",
@@ -549,6 +1289,7 @@ func DefaultConfig() *Config {
 			LogSQLQueries:                    true,
 			ConfigDir:                        "/etc/clickhouse-server/",
 			RestartCommand:                   "exec:systemctl restart clickhouse-server",
+			LocalCommand:                     "clickhouse-local",
 			IgnoreNotExistsErrorDuringFreeze: true,
 			CheckReplicasBeforeAttach:        true,
 			UseEmbeddedBackupRestore:         false,
@@ -580,6 +1321,8 @@ func DefaultConfig() *Config {
 			Concurrency:             int(downloadConcurrency + 1),
 			PartSize:                0,
 			MaxPartsCount:           4000,
+			BufferSize:              128 * 1024,
+			HealthCheckInterval:     "30s",
 		},
 		GCS: GCSConfig{
 			CompressionLevel:  1,
@@ -600,6 +1343,19 @@ func DefaultConfig() *Config {
 			ListenAddr:                    "localhost:7171",
 			EnableMetrics:                 true,
 			CompleteResumableAfterRestart: true,
+			ClientCertAuth:                "require",
+			OIDCRolesClaim:                "roles",
+			OIDCOperatorRole:              "operator",
+			OIDCReadOnlyRole:              "read_only",
+			OIDCAdminRole:                 "admin",
+			SwaggerUIAssetsURL:            "https://unpkg.com/swagger-ui-dist@5",
+			DrainTimeout:                  "25s",
+			WedgedJobTimeout:              "0s",
+			ProbeTimeout:                  "5s",
+			ClusterTimeout:                "24h",
+			DrainTimeoutDuration:          25 * time.Second,
+			ProbeTimeoutDuration:          5 * time.Second,
+			ClusterTimeoutDuration:        24 * time.Hour,
 		},
 		FTP: FTPConfig{
 			Timeout:           "2m",
@@ -627,6 +1383,10 @@ func GetConfigFromCli(ctx *cli.Context) *Config {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	// `--no-cache` ignore local cache of remote metadata.json only for current command
+	if ctx.Bool("no-cache") {
+		cfg.General.RemoteMetadataCacheDuration = 0
+	}
 	return cfg
 }
 
@@ -11,3 +11,15 @@ func (cfg *Config) SetPriority() error {
 	}
 	return nil
 }
+
+// SetRestorePriority - apply restore_cpu_nice_priority when defined, i/o priority is not supported
+func (cfg *Config) SetRestorePriority() error {
+	cpuNicePriority := cfg.General.CPUNicePriority
+	if cfg.General.RestoreCPUNicePriority != 0 {
+		cpuNicePriority = cfg.General.RestoreCPUNicePriority
+	}
+	if err := syscall.Setpriority(0, 0, cpuNicePriority); err != nil {
+		log.Warnf("can't set CPU priority %v, error: %v", cpuNicePriority, err)
+	}
+	return nil
+}
@@ -6,18 +6,34 @@ import (
 )
 
 func (cfg *Config) SetPriority() error {
+	return setProcessPriority(cfg.General.CPUNicePriority, cfg.General.IONicePriority)
+}
+
+// SetRestorePriority - apply restore_cpu_nice_priority and restore_io_nice_priority when defined, call SetPriority after restore to return general priorities
+func (cfg *Config) SetRestorePriority() error {
+	cpuNicePriority, ioNicePriority := cfg.General.CPUNicePriority, cfg.General.IONicePriority
+	if cfg.General.RestoreCPUNicePriority != 0 {
+		cpuNicePriority = cfg.General.RestoreCPUNicePriority
+	}
+	if cfg.General.RestoreIONicePriority != "" {
+		ioNicePriority = cfg.General.RestoreIONicePriority
+	}
+	return setProcessPriority(cpuNicePriority, ioNicePriority)
+}
+
+func setProcessPriority(cpuNicePriority int, ioNicePriority string) error {
 	var err error
-	if cfg.General.IONicePriority != "" {
+	if ioNicePriority != "" {
 		var nicePriority gionice.PriClass
-		if nicePriority, err = gionice.Parse(cfg.General.IONicePriority); err != nil {
+		if nicePriority, err = gionice.Parse(ioNicePriority); err != nil {
 			return err
 		}
 		if err = gionice.SetIDPri(0, nicePriority, 7, gionice.IOPRIO_WHO_PGRP); err != nil {
-			log.Warnf("can't set i/o priority %s, error: %v", cfg.General.IONicePriority, err)
+			log.Warnf("can't set i/o priority %s, error: %v", ioNicePriority, err)
 		}
 	}
-	if err = gionice.SetNicePri(0, gionice.PRIO_PROCESS, cfg.General.CPUNicePriority); err != nil {
-		log.Warnf("can't set CPU priority %v, error: %v", cfg.General.CPUNicePriority, err)
+	if err = gionice.SetNicePri(0, gionice.PRIO_PROCESS, cpuNicePriority); err != nil {
+		log.Warnf("can't set CPU priority %v, error: %v", cpuNicePriority, err)
 	}
 	return nil
 }
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/secrets"
+)
+
+// resolveSecrets - replace `vault:`, `aws-sm:` and `gcp-sm:` references with secret values, references keep for print-config
+func (cfg *Config) resolveSecrets() error {
+	refreshInterval := time.Duration(0)
+	if cfg.General.SecretsRefreshInterval != "" {
+		duration, err := time.ParseDuration(cfg.General.SecretsRefreshInterval)
+		if err != nil {
+			return fmt.Errorf("invalid secrets_refresh_interval: %v", err)
+		}
+		refreshInterval = duration
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	references := make(map[string]string)
+	err := walkConfigStrings(reflect.ValueOf(cfg).Elem(), "", func(path, value string) (string, error) {
+		if !secrets.IsReference(value) {
+			return value, nil
+		}
+		resolved, err := secrets.Resolve(ctx, value, refreshInterval)
+		if err != nil {
+			return value, fmt.Errorf("%s: %v", path, err)
+		}
+		references[path] = value
+		return resolved, nil
+	})
+	cfg.secretReferences = references
+	return err
+}
+
+// restoreSecretReferences - put references back instead of resolved values, to avoid print secrets in plain text
+func (cfg *Config) restoreSecretReferences() {
+	if len(cfg.secretReferences) == 0 {
+		return
+	}
+	_ = walkConfigStrings(reflect.ValueOf(cfg).Elem(), "", func(path, value string) (string, error) {
+		if reference, exists := cfg.secretReferences[path]; exists {
+			return reference, nil
+		}
+		return value, nil
+	})
+}
+
+// walkConfigStrings - call fn for each string in nested structs, slices and string maps, path contains yaml names like `s3.secret_key`
+func walkConfigStrings(v reflect.Value, path string, fn func(path, value string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return walkConfigStrings(v.Elem(), path, fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := walkConfigStrings(v.Field(i), name, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkConfigStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, k := range v.MapKeys() {
+			value := v.MapIndex(k).String()
+			newValue, err := fn(fmt.Sprintf("%s[%v]", path, k), value)
+			if err != nil {
+				return err
+			}
+			if newValue != value {
+				v.SetMapIndex(k, reflect.ValueOf(newValue).Convert(v.Type().Elem()))
+			}
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		newValue, err := fn(path, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(newValue)
+	}
+	return nil
+}
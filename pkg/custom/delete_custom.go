@@ -10,6 +10,22 @@ import (
 )
 
 func DeleteRemote(ctx context.Context, cfg *config.Config, backupName string) error {
+	if cfg.Custom.PluginCommand != "" {
+		startPluginDelete := time.Now()
+		if _, err := RunPlugin(ctx, cfg, PluginRequest{Operation: "delete", BackupName: backupName}); err != nil {
+			log.WithFields(log.Fields{
+				"backup":    backupName,
+				"operation": "delete_custom",
+			}).Error(err.Error())
+			return err
+		}
+		log.WithFields(log.Fields{
+			"backup":    backupName,
+			"operation": "delete_custom",
+			"duration":  utils.HumanizeDuration(time.Since(startPluginDelete)),
+		}).Info("done")
+		return nil
+	}
 	if cfg.Custom.DeleteCommand == "" {
 		return fmt.Errorf("CUSTOM_DELETE_COMMAND is not defined")
 	}
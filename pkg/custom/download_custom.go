@@ -4,14 +4,24 @@ import (
 	"context"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
 	"github.com/apex/log"
-	"github.com/eapache/go-resiliency/retrier"
 	"time"
 )
 
-func Download(ctx context.Context, cfg *config.Config, backupName string, tablePattern string, partitions []string, schemaOnly bool) error {
+func Download(ctx context.Context, cfg *config.Config, backupName string, tablePattern string, partitions []string, schemaOnly bool, disks map[string]string) error {
 	startCustomDownload := time.Now()
+	if cfg.Custom.PluginCommand != "" {
+		return runPluginWithRetries(ctx, cfg, PluginRequest{
+			Operation:    "download",
+			BackupName:   backupName,
+			TablePattern: tablePattern,
+			Partitions:   partitions,
+			SchemaOnly:   schemaOnly,
+			Disks:        disks,
+		})
+	}
 	if cfg.Custom.DownloadCommand == "" {
 		return fmt.Errorf("CUSTOM_DOWNLOAD_COMMAND is not defined")
 	}
@@ -34,7 +44,7 @@ func Download(ctx context.Context, cfg *config.Config, backupName string, tableP
 		"schema":        schemaOnly,
 	}
 	args := ApplyCommandTemplate(cfg.Custom.DownloadCommand, templateData)
-	retry := retrier.New(retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration), nil)
+	retry := storage.NewRetryPolicy(cfg).NewRetrier()
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
 		return utils.ExecCmd(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
 	})
@@ -13,6 +13,19 @@ import (
 )
 
 func List(ctx context.Context, cfg *config.Config) ([]storage.Backup, error) {
+	if cfg.Custom.PluginCommand != "" {
+		startPluginList := time.Now()
+		backupList, err := RunPlugin(ctx, cfg, PluginRequest{Operation: "list"})
+		if err != nil {
+			log.WithField("operation", "list_custom").Error(err.Error())
+			return nil, err
+		}
+		log.
+			WithField("operation", "list_custom").
+			WithField("duration", utils.HumanizeDuration(time.Since(startPluginList))).
+			Info("done")
+		return backupList, nil
+	}
 	if cfg.Custom.ListCommand == "" {
 		return nil, fmt.Errorf("CUSTOM_LIST_COMMAND is not defined")
 	}
@@ -0,0 +1,206 @@
+package custom
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	"github.com/apex/log"
+)
+
+// PluginProtocolVersion - version of JSON protocol between clickhouse-backup and custom->plugin_command, increments only on incompatible changes
+const PluginProtocolVersion = 1
+
+// maxPluginMessageSize - `backup` message contains whole metadata.json of remote backup
+const maxPluginMessageSize = 64 * 1024 * 1024
+
+// PluginRequest - single JSON line written to stdin of custom->plugin_command, stdin closed after it
+// Operation is one of upload, download, list, delete, Disks contains local disk name to path, backup stored in `<path>/backup/<backup_name>` on each disk
+type PluginRequest struct {
+	ProtocolVersion     int               `json:"protocol_version"`
+	Operation           string            `json:"operation"`
+	BackupName          string            `json:"backup_name,omitempty"`
+	DiffFrom            string            `json:"diff_from,omitempty"`
+	DiffFromRemote      string            `json:"diff_from_remote,omitempty"`
+	TablePattern        string            `json:"table_pattern,omitempty"`
+	Partitions          []string          `json:"partitions,omitempty"`
+	SchemaOnly          bool              `json:"schema_only,omitempty"`
+	Disks               map[string]string `json:"disks,omitempty"`
+	BackupsToKeepRemote int               `json:"backups_to_keep_remote,omitempty"`
+}
+
+// PluginMessage - JSON line in stdout of custom->plugin_command, Type is one of
+// - `log` with Level and Message
+// - `progress` with File, Bytes and TotalBytes, for upload and download
+// - `backup` with Backup, one message for each remote backup, only for list
+// - `error` with Message, operation fails after plugin exit
+// - `done`, shall be the last message, operation without `done` fails even when plugin exit code is 0
+type PluginMessage struct {
+	Type       string          `json:"type"`
+	Level      string          `json:"level,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	File       string          `json:"file,omitempty"`
+	Bytes      int64           `json:"bytes,omitempty"`
+	TotalBytes int64           `json:"total_bytes,omitempty"`
+	Backup     *storage.Backup `json:"backup,omitempty"`
+}
+
+// pluginResult - state collected from plugin messages
+type pluginResult struct {
+	done    bool
+	errors  []string
+	backups []storage.Backup
+}
+
+// handlePluginMessage - process one stdout line, lines which are not JSON logged as is to help debug plugins
+func handlePluginMessage(line []byte, result *pluginResult, logger *log.Entry) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	msg := PluginMessage{}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		logger.Warnf("not a plugin protocol message: %s", string(line))
+		return
+	}
+	switch msg.Type {
+	case "log":
+		switch strings.ToLower(msg.Level) {
+		case "debug":
+			logger.Debug(msg.Message)
+		case "warn", "warning":
+			logger.Warn(msg.Message)
+		case "error":
+			logger.Error(msg.Message)
+		default:
+			logger.Info(msg.Message)
+		}
+	case "progress":
+		progressLog := logger.WithField("file", msg.File).WithField("bytes", utils.FormatBytes(uint64(msg.Bytes)))
+		if msg.TotalBytes > 0 {
+			progressLog = progressLog.WithField("progress", fmt.Sprintf("%.1f%%", float64(msg.Bytes)*100/float64(msg.TotalBytes)))
+		}
+		progressLog.Info("progress")
+	case "backup":
+		if msg.Backup == nil {
+			logger.Warnf("`backup` message without `backup` field: %s", string(line))
+			return
+		}
+		result.backups = append(result.backups, *msg.Backup)
+	case "error":
+		result.errors = append(result.errors, msg.Message)
+		logger.Error(msg.Message)
+	case "done":
+		result.done = true
+	default:
+		logger.Warnf("unknown plugin message type %q: %s", msg.Type, string(line))
+	}
+}
+
+// RunPlugin - execute custom->plugin_command once, write request to stdin and process stdout messages while plugin works
+func RunPlugin(ctx context.Context, cfg *config.Config, request PluginRequest) ([]storage.Backup, error) {
+	request.ProtocolVersion = PluginProtocolVersion
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	args := ApplyCommandTemplate(cfg.Custom.PluginCommand, map[string]interface{}{"cfg": cfg})
+	logger := log.WithField("operation", request.Operation+"_custom").WithField("plugin", args[0])
+	if request.BackupName != "" {
+		logger = logger.WithField("backup", request.BackupName)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Custom.CommandTimeoutDuration)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(append(requestBody, '\n'))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("can't start %s: %v", args[0], err)
+	}
+	result := pluginResult{}
+	reader := bufio.NewReaderSize(stdout, 1024*1024)
+	var readErr error
+	for {
+		line, err := readPluginLine(reader)
+		if len(line) > 0 {
+			handlePluginMessage(line, &result, logger)
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+				_, _ = io.Copy(io.Discard, stdout)
+			}
+			break
+		}
+	}
+	waitErr := cmd.Wait()
+	if stderrOut := strings.TrimSpace(stderr.String()); stderrOut != "" {
+		logger.Debug(stderrOut)
+	}
+	return result.backups, getPluginError(result, readErr, waitErr, stderr.String())
+}
+
+// readPluginLine - line without `\n`, error when line longer than maxPluginMessageSize
+func readPluginLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		line = append(line, chunk...)
+		if len(line) > maxPluginMessageSize {
+			return nil, fmt.Errorf("plugin message is longer than %s", utils.FormatBytes(maxPluginMessageSize))
+		}
+		if err != nil || !isPrefix {
+			return line, err
+		}
+	}
+}
+
+// getPluginError - operation successful only when plugin exit with 0, send `done` and doesn't send `error`
+func getPluginError(result pluginResult, readErr, waitErr error, stderr string) error {
+	if readErr != nil {
+		return fmt.Errorf("can't read plugin output: %v", readErr)
+	}
+	if waitErr != nil {
+		if stderr = strings.TrimSpace(stderr); len(stderr) > 1024 {
+			stderr = "..." + stderr[len(stderr)-1024:]
+		}
+		return fmt.Errorf("plugin return error: %v, stderr: %s", waitErr, stderr)
+	}
+	if len(result.errors) > 0 {
+		return fmt.Errorf("plugin return errors: %s", strings.Join(result.errors, "; "))
+	}
+	if !result.done {
+		return fmt.Errorf("plugin exit without `done` message")
+	}
+	return nil
+}
+
+// runPluginWithRetries - upload and download retried according to general->retries_on_failure like custom commands
+func runPluginWithRetries(ctx context.Context, cfg *config.Config, request PluginRequest) error {
+	start := time.Now()
+	retry := storage.NewRetryPolicy(cfg).NewRetrier()
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		_, err := RunPlugin(ctx, cfg, request)
+		return err
+	})
+	logger := log.WithField("operation", request.Operation+"_custom").WithField("backup", request.BackupName)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	logger.WithField("duration", utils.HumanizeDuration(time.Since(start))).Info("done")
+	return nil
+}
@@ -0,0 +1,54 @@
+package custom
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePlugin(t *testing.T, body string) *config.Config {
+	pluginFile := path.Join(t.TempDir(), "plugin.sh")
+	require.NoError(t, os.WriteFile(pluginFile, []byte("#!/bin/sh\nREQUEST=$(head -n 1)\n"+body), 0755))
+	cfg := &config.Config{}
+	cfg.Custom.PluginCommand = pluginFile
+	cfg.Custom.CommandTimeoutDuration = time.Minute
+	return cfg
+}
+
+func TestRunPlugin(t *testing.T) {
+	cfg := writePlugin(t, `echo "$REQUEST" | grep -q '"protocol_version":1,"operation":"list"' || exit 3
+echo '{"type":"log","level":"info","message":"listing"}'
+echo 'plain text line'
+echo '{"type":"backup","backup":{"backup_name":"first","data_size":10}}'
+echo '{"type":"backup","backup":{"backup_name":"second"}}'
+echo '{"type":"done"}'
+`)
+	backups, err := RunPlugin(context.Background(), cfg, PluginRequest{Operation: "list"})
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, "first", backups[0].BackupName)
+	assert.Equal(t, uint64(10), backups[0].DataSize)
+	assert.Equal(t, "second", backups[1].BackupName)
+}
+
+func TestRunPluginErrors(t *testing.T) {
+	testCases := []struct {
+		body  string
+		error string
+	}{
+		{`echo '{"type":"progress","file":"default","bytes":1,"total_bytes":2}'`, "without `done`"},
+		{"echo '{\"type\":\"error\",\"message\":\"tape is full\"}'\necho '{\"type\":\"done\"}'", "tape is full"},
+		{"echo broken >&2\nexit 2", "stderr: broken"},
+	}
+	for _, tc := range testCases {
+		cfg := writePlugin(t, tc.body)
+		_, err := RunPlugin(context.Background(), cfg, PluginRequest{Operation: "upload", BackupName: "test"})
+		assert.ErrorContains(t, err, tc.error, tc.body)
+	}
+}
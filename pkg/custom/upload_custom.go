@@ -4,14 +4,27 @@ import (
 	"context"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
 	"github.com/apex/log"
-	"github.com/eapache/go-resiliency/retrier"
 	"time"
 )
 
-func Upload(ctx context.Context, cfg *config.Config, backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly bool) error {
+func Upload(ctx context.Context, cfg *config.Config, backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly bool, disks map[string]string) error {
 	startCustomUpload := time.Now()
+	if cfg.Custom.PluginCommand != "" {
+		return runPluginWithRetries(ctx, cfg, PluginRequest{
+			Operation:           "upload",
+			BackupName:          backupName,
+			DiffFrom:            diffFrom,
+			DiffFromRemote:      diffFromRemote,
+			TablePattern:        tablePattern,
+			Partitions:          partitions,
+			SchemaOnly:          schemaOnly,
+			Disks:               disks,
+			BackupsToKeepRemote: cfg.General.BackupsToKeepRemote,
+		})
+	}
 	if cfg.Custom.UploadCommand == "" {
 		return fmt.Errorf("CUSTOM_UPLOAD_COMMAND is not defined")
 	}
@@ -40,7 +53,7 @@ func Upload(ctx context.Context, cfg *config.Config, backupName, diffFrom, diffF
 		"schema":           schemaOnly,
 	}
 	args := ApplyCommandTemplate(cfg.Custom.UploadCommand, templateData)
-	retry := retrier.New(retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration), nil)
+	retry := storage.NewRetryPolicy(cfg).NewRetrier()
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
 		return utils.ExecCmd(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
 	})
@@ -347,3 +347,29 @@ func IsDuplicatedParts(part1, part2 string) error {
 	}
 	return nil
 }
+
+// GetDuplicatedFiles - list of files in part2 which are hardlinks to the same files in part1, paths relative to part2
+func GetDuplicatedFiles(part1, part2 string) ([]string, error) {
+	duplicatedFiles := make([]string, 0)
+	err := filepath.Walk(part2, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(filePath, part2), "/")
+		part1File, err := os.Stat(path.Join(part1, relativePath))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if os.SameFile(part1File, info) {
+			duplicatedFiles = append(duplicatedFiles, relativePath)
+		}
+		return nil
+	})
+	return duplicatedFiles, err
+}
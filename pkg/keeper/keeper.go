@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/antchfx/xmlquery"
 	"github.com/apex/log"
@@ -112,6 +113,15 @@ func (k *Keeper) GetReplicatedAccessPath(userDirectory string) (string, error) {
 }
 
 func (k *Keeper) Dump(prefix, dumpFile string) (int, error) {
+	return k.dump(prefix, dumpFile, false)
+}
+
+// DumpPersistent - the same as Dump, but skip ephemeral znodes like replicas/*/is_active, they shall be re-created by alive owner session only
+func (k *Keeper) DumpPersistent(prefix, dumpFile string) (int, error) {
+	return k.dump(prefix, dumpFile, true)
+}
+
+func (k *Keeper) dump(prefix, dumpFile string, skipEphemeral bool) (int, error) {
 	f, err := os.Create(dumpFile)
 	if err != nil {
 		return 0, fmt.Errorf("can't create %s: %v", dumpFile, err)
@@ -124,7 +134,7 @@ func (k *Keeper) Dump(prefix, dumpFile string) (int, error) {
 	if !strings.HasPrefix(prefix, "/") && k.root != "" {
 		prefix = path.Join(k.root, prefix)
 	}
-	bytes, err := k.dumpNodeRecursive(prefix, "", f)
+	bytes, err := k.dumpNodeRecursive(prefix, "", f, skipEphemeral)
 	if err != nil {
 		return 0, fmt.Errorf("dumpNodeRecursive(%s) return error: %v", prefix, err)
 	}
@@ -136,11 +146,14 @@ func (k *Keeper) ChildCount(prefix, nodePath string) (int, error) {
 	return len(childrenNodes), err
 }
 
-func (k *Keeper) dumpNodeRecursive(prefix, nodePath string, f *os.File) (int, error) {
-	value, _, err := k.conn.Get(path.Join(prefix, nodePath))
+func (k *Keeper) dumpNodeRecursive(prefix, nodePath string, f *os.File, skipEphemeral bool) (int, error) {
+	value, stat, err := k.conn.Get(path.Join(prefix, nodePath))
 	if err != nil {
 		return 0, err
 	}
+	if skipEphemeral && stat.EphemeralOwner != 0 {
+		return 0, nil
+	}
 	bytes, err := k.writeJsonString(f, DumpNode{Path: nodePath, Value: string(value)})
 	if err != nil {
 		return 0, err
@@ -150,7 +163,11 @@ func (k *Keeper) dumpNodeRecursive(prefix, nodePath string, f *os.File) (int, er
 		return 0, err
 	}
 	for _, childPath := range children {
-		if childBytes, err := k.dumpNodeRecursive(prefix, path.Join(nodePath, childPath), f); err != nil {
+		if childBytes, err := k.dumpNodeRecursive(prefix, path.Join(nodePath, childPath), f, skipEphemeral); err != nil {
+			// znode could be removed during dump, like replication log entries or queue items
+			if skipEphemeral && errors.Is(err, zk.ErrNoNode) {
+				continue
+			}
 			return 0, err
 		} else {
 			bytes += childBytes
@@ -211,6 +228,47 @@ func (k *Keeper) Restore(dumpFile, prefix string) error {
 	return nil
 }
 
+// RestoreMissing - create znodes from dumpFile which don't exist under prefix, existing znodes keep untouched, for disaster recovery after Keeper data loss
+func (k *Keeper) RestoreMissing(dumpFile, prefix string) (int, error) {
+	f, err := os.Open(dumpFile)
+	if err != nil {
+		return 0, fmt.Errorf("can't open %s: %v", dumpFile, err)
+	}
+	defer func() {
+		if err = f.Close(); err != nil {
+			k.Log.Warnf("can't close %s: %v", dumpFile, err)
+		}
+	}()
+	if !strings.HasPrefix(prefix, "/") && k.root != "" {
+		prefix = path.Join(k.root, prefix)
+	}
+	if err = k.createParents(prefix); err != nil {
+		return 0, err
+	}
+	created := 0
+	scanner := bufio.NewScanner(f)
+	// replication log entries could be bigger than default 64Kb token size
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		node := DumpNode{}
+		if err = json.Unmarshal(scanner.Bytes(), &node); err != nil {
+			return created, err
+		}
+		node.Path = path.Join(prefix, node.Path)
+		if _, err = k.conn.Create(node.Path, []byte(node.Value), 0, zk.WorldACL(zk.PermAll)); err != nil {
+			if errors.Is(err, zk.ErrNodeExists) {
+				continue
+			}
+			return created, fmt.Errorf("can't create znode %s, error: %v", node.Path, err)
+		}
+		created++
+	}
+	if err = scanner.Err(); err != nil {
+		return created, fmt.Errorf("can't scan %s, error: %s", dumpFile, err)
+	}
+	return created, nil
+}
+
 type WalkCallBack = func(node DumpNode) (bool, error)
 
 func (k *Keeper) Walk(prefix, relativePath string, recursive bool, callback WalkCallBack) error {
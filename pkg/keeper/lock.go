@@ -0,0 +1,181 @@
+package keeper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// LockInfo - value of lock znode, lock is stale when ExpiresAt passed, owner refresh ExpiresAt while holding the lock
+type LockInfo struct {
+	Owner      string    `json:"owner"`
+	Hostname   string    `json:"hostname"`
+	Pid        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// IsStale - lock without owner or with expired TTL could be taken over
+func (l LockInfo) IsStale(now time.Time) bool {
+	return l.Owner == "" || now.After(l.ExpiresAt)
+}
+
+// Lock - lock znode held by current process
+type Lock struct {
+	k       *Keeper
+	path    string
+	info    LockInfo
+	version int32
+	stop    chan struct{}
+	lost    chan struct{}
+	lostErr error
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+}
+
+func newLockInfo(owner string, ttl time.Duration) LockInfo {
+	hostname, _ := os.Hostname()
+	now := time.Now().UTC()
+	return LockInfo{
+		Owner:      owner,
+		Hostname:   hostname,
+		Pid:        os.Getpid(),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+}
+
+// createParents - create persistent parent znodes for lockPath, already existing nodes are ignored
+func (k *Keeper) createParents(lockPath string) error {
+	parent := ""
+	for _, part := range strings.Split(strings.Trim(path.Dir(lockPath), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		parent = parent + "/" + part
+		if _, err := k.conn.Create(parent, []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return fmt.Errorf("can't create znode %s, error: %v", parent, err)
+		}
+	}
+	return nil
+}
+
+// AcquireLock - create lockPath znode with owner and TTL, take over stale lock which TTL expired, TTL refreshed in background until Release
+func (k *Keeper) AcquireLock(lockPath, owner string, ttl time.Duration) (*Lock, error) {
+	if !strings.HasPrefix(lockPath, "/") && k.root != "" {
+		lockPath = path.Join(k.root, lockPath)
+	}
+	if err := k.createParents(lockPath); err != nil {
+		return nil, err
+	}
+	info := newLockInfo(owner, ttl)
+	value, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err = k.conn.Create(lockPath, value, 0, zk.WorldACL(zk.PermAll)); err == nil {
+			break
+		}
+		if !errors.Is(err, zk.ErrNodeExists) {
+			return nil, fmt.Errorf("can't create znode %s, error: %v", lockPath, err)
+		}
+		existingValue, stat, getErr := k.conn.Get(lockPath)
+		if errors.Is(getErr, zk.ErrNoNode) {
+			continue
+		}
+		if getErr != nil {
+			return nil, fmt.Errorf("can't get znode %s, error: %v", lockPath, getErr)
+		}
+		existing := LockInfo{}
+		if unmarshalErr := json.Unmarshal(existingValue, &existing); unmarshalErr != nil {
+			k.Log.Warnf("can't parse lock %s value %s: %v, will take over", lockPath, string(existingValue), unmarshalErr)
+		}
+		if !existing.IsStale(time.Now()) {
+			return nil, fmt.Errorf("%s locked by %s on %s pid=%d since %s until %s", lockPath, existing.Owner, existing.Hostname, existing.Pid, existing.AcquiredAt.Format(time.RFC3339), existing.ExpiresAt.Format(time.RFC3339))
+		}
+		k.Log.Warnf("take over stale lock %s from %s on %s pid=%d expired at %s", lockPath, existing.Owner, existing.Hostname, existing.Pid, existing.ExpiresAt.Format(time.RFC3339))
+		// delete with version, to avoid removing lock which was taken over by another process at the same time
+		if deleteErr := k.conn.Delete(lockPath, stat.Version); deleteErr != nil && !errors.Is(deleteErr, zk.ErrNoNode) && !errors.Is(deleteErr, zk.ErrBadVersion) {
+			return nil, fmt.Errorf("can't delete stale znode %s, error: %v", lockPath, deleteErr)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't acquire lock %s: %v", lockPath, err)
+	}
+	l := &Lock{k: k, path: lockPath, info: info, stop: make(chan struct{}), lost: make(chan struct{})}
+	l.wg.Add(1)
+	go l.refresh(ttl)
+	return l, nil
+}
+
+// refresh - extend ExpiresAt every ttl/3, so long operation doesn't lose the lock, crashed process lose the lock after ttl
+// when znode was deleted or taken over by another process, or can't be refreshed until ExpiresAt, the lock is lost and Lost channel closed
+func (l *Lock) refresh(ttl time.Duration) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			info := l.info
+			info.ExpiresAt = time.Now().UTC().Add(ttl)
+			value, err := json.Marshal(info)
+			if err == nil {
+				var stat *zk.Stat
+				if stat, err = l.k.conn.Set(l.path, value, l.version); err == nil {
+					l.version = stat.Version
+					l.info = info
+				}
+			}
+			expired := time.Now().After(l.info.ExpiresAt)
+			l.mu.Unlock()
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, zk.ErrNoNode) || errors.Is(err, zk.ErrBadVersion) || expired {
+				l.lostErr = fmt.Errorf("keeper lock %s lost: %v", l.path, err)
+				close(l.lost)
+				return
+			}
+			l.k.Log.Warnf("can't refresh lock %s: %v", l.path, err)
+		}
+	}
+}
+
+// Lost - closed when lock can't be refreshed anymore, operation which holds the lock shall stop, cause another process could acquire it
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err - why lock was lost, nil until Lost channel closed
+func (l *Lock) Err() error {
+	select {
+	case <-l.lost:
+		return l.lostErr
+	default:
+		return nil
+	}
+}
+
+// Release - stop refresh and delete lock znode, if lock was taken over by another process, znode version changed and keep untouched
+func (l *Lock) Release() error {
+	close(l.stop)
+	l.wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.k.conn.Delete(l.path, l.version); err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return fmt.Errorf("can't delete znode %s, error: %v", l.path, err)
+	}
+	return nil
+}
@@ -2,11 +2,14 @@
 package logcli
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/apex/log"
 	"io"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Strings mapping.
@@ -23,6 +26,8 @@ type Handler struct {
 	mu      sync.Mutex
 	Writer  io.Writer
 	Padding int
+	// JSON - write each entry as JSON object with time, level, message and all fields, for Loki and ELK
+	JSON bool
 }
 
 // New handler.
@@ -40,6 +45,19 @@ func New(w io.Writer) *Handler {
 	}
 }
 
+// SetFormat - switch format of Handler installed with log.SetHandler, `json` or `text`, other handlers are not changed
+func SetFormat(format string) {
+	logger, ok := log.Log.(*log.Logger)
+	if !ok {
+		return
+	}
+	if h, ok := logger.Handler.(*Handler); ok {
+		h.mu.Lock()
+		h.JSON = format == "json"
+		h.mu.Unlock()
+	}
+}
+
 // HandleLog implements log.Handler.
 func (h *Handler) HandleLog(e *log.Entry) error {
 	level := Strings[e.Level]
@@ -48,6 +66,10 @@ func (h *Handler) HandleLog(e *log.Entry) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.JSON {
+		return h.handleJSON(e, strings.TrimSpace(level), names)
+	}
+
 	_, _ = fmt.Fprintf(h.Writer, "%s %-5s %-25s", e.Timestamp.Format("2006/01/02 15:04:05.000000"), level, e.Message)
 
 	for _, name := range names {
@@ -61,3 +83,27 @@ func (h *Handler) HandleLog(e *log.Entry) error {
 
 	return nil
 }
+
+// handleJSON - one line JSON object, errors written as text, fields can't overwrite time, level and message
+func (h *Handler) handleJSON(e *log.Entry, level string, names []string) error {
+	line := make(map[string]interface{}, len(names)+3)
+	for _, name := range names {
+		if name == "source" {
+			continue
+		}
+		value := e.Fields.Get(name)
+		if err, isErr := value.(error); isErr {
+			value = err.Error()
+		}
+		line[name] = value
+	}
+	line["time"] = e.Timestamp.Format(time.RFC3339Nano)
+	line["level"] = level
+	line["message"] = e.Message
+	body, err := json.Marshal(line)
+	if err != nil {
+		body, _ = json.Marshal(map[string]string{"time": e.Timestamp.Format(time.RFC3339Nano), "level": level, "message": e.Message, "error": err.Error()})
+	}
+	_, err = h.Writer.Write(append(body, '\n'))
+	return err
+}
@@ -0,0 +1,48 @@
+package logcli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLogJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	h := New(out)
+	logger := &log.Logger{Handler: h, Level: log.DebugLevel}
+	entry := logger.WithFields(log.Fields{"operation_id": "42", "backup": "backup1", "table": "default.t1", "disk": "default", "phase": "upload_table", "message": "overwrite"})
+
+	entry.Info("text line")
+	assert.True(t, strings.Contains(out.String(), "text line") && strings.Contains(out.String(), "operation_id=42"), out.String())
+
+	out.Reset()
+	h.JSON = true
+	entry.WithError(fmt.Errorf("broken")).Warn("json line")
+	line := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line), out.String())
+	assert.Equal(t, "warn", line["level"])
+	assert.Equal(t, "json line", line["message"])
+	assert.Equal(t, "42", line["operation_id"])
+	assert.Equal(t, "backup1", line["backup"])
+	assert.Equal(t, "default.t1", line["table"])
+	assert.Equal(t, "default", line["disk"])
+	assert.Equal(t, "upload_table", line["phase"])
+	assert.Equal(t, "broken", line["error"])
+	assert.NotEmpty(t, line["time"])
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+}
+
+func TestSetFormat(t *testing.T) {
+	h := New(&bytes.Buffer{})
+	log.SetHandler(h)
+	SetFormat("json")
+	assert.True(t, h.JSON)
+	SetFormat("text")
+	assert.False(t, h.JSON)
+}
@@ -0,0 +1,30 @@
+package metadata
+
+import (
+	"time"
+)
+
+// ClusterBackupMetadata - cluster level manifest written by `create_cluster`, describe which backup was created on each replica of each shard
+type ClusterBackupMetadata struct {
+	BackupName              string                 `json:"backup_name"`
+	Cluster                 string                 `json:"cluster"`
+	ClickhouseBackupVersion string                 `json:"version"`
+	CreationDate            time.Time              `json:"creation_date"`
+	Shards                  []ClusterShardMetadata `json:"shards"`
+}
+
+type ClusterShardMetadata struct {
+	ShardNum uint32                   `json:"shard_num"`
+	Replicas []ClusterReplicaMetadata `json:"replicas"`
+}
+
+// ClusterReplicaMetadata - only one replica in each shard contains data, other replicas contain schema only
+type ClusterReplicaMetadata struct {
+	ReplicaNum  uint32 `json:"replica_num"`
+	Host        string `json:"host"`
+	BackupName  string `json:"backup_name"`
+	Data        bool   `json:"data"`
+	OperationId int    `json:"operation_id,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
@@ -1,6 +1,7 @@
 package metadata
 
 import (
+	"strings"
 	"time"
 )
 
@@ -10,23 +11,104 @@ type TableTitle struct {
 }
 
 type BackupMetadata struct {
-	BackupName              string            `json:"backup_name"`
-	Disks                   map[string]string `json:"disks"`      // "default": "/var/lib/clickhouse"
-	DiskTypes               map[string]string `json:"disk_types"` // "default": "local"
-	ClickhouseBackupVersion string            `json:"version"`
-	CreationDate            time.Time         `json:"creation_date"`
-	Tags                    string            `json:"tags,omitempty"` // "regular,embedded"
-	ClickHouseVersion       string            `json:"clickhouse_version,omitempty"`
-	DataSize                uint64            `json:"data_size,omitempty"`
-	MetadataSize            uint64            `json:"metadata_size"`
-	RBACSize                uint64            `json:"rbac_size,omitempty"`
-	ConfigSize              uint64            `json:"config_size,omitempty"`
-	CompressedSize          uint64            `json:"compressed_size,omitempty"`
-	Databases               []DatabasesMeta   `json:"databases,omitempty"`
-	Tables                  []TableTitle      `json:"tables"`
-	Functions               []FunctionsMeta   `json:"functions"`
-	DataFormat              string            `json:"data_format"`
-	RequiredBackup          string            `json:"required_backup,omitempty"`
+	BackupName              string                   `json:"backup_name"`
+	Disks                   map[string]string        `json:"disks"`      // "default": "/var/lib/clickhouse"
+	DiskTypes               map[string]string        `json:"disk_types"` // "default": "local"
+	ClickhouseBackupVersion string                   `json:"version"`
+	CreationDate            time.Time                `json:"creation_date"`
+	Tags                    string                   `json:"tags,omitempty"` // "regular,embedded"
+	ClickHouseVersion       string                   `json:"clickhouse_version,omitempty"`
+	DataSize                uint64                   `json:"data_size,omitempty"`
+	MetadataSize            uint64                   `json:"metadata_size"`
+	RBACSize                uint64                   `json:"rbac_size,omitempty"`
+	ConfigSize              uint64                   `json:"config_size,omitempty"`
+	KeeperSize              uint64                   `json:"keeper_size,omitempty"`
+	CompressedSize          uint64                   `json:"compressed_size,omitempty"`
+	Databases               []DatabasesMeta          `json:"databases,omitempty"`
+	Tables                  []TableTitle             `json:"tables"`
+	Functions               []FunctionsMeta          `json:"functions"`
+	DataFormat              string                   `json:"data_format"`
+	RequiredBackup          string                   `json:"required_backup,omitempty"`
+	RequiredBackupsChain    []string                 `json:"required_backups_chain,omitempty"` // all required backups from required_backup to full backup during upload, look `chain` command
+	CompressionDictionary   string                   `json:"compression_dictionary,omitempty"`
+	CompressionStats        *CompressionStats        `json:"compression_stats,omitempty"`    // statistics of compression_format archives of last upload
+	OriginalUploadDate      *time.Time               `json:"original_upload_date,omitempty"` // first upload date, when metadata.json was re-uploaded after required_backup changed by retention
+	Destinations            []DestinationStatus      `json:"destinations,omitempty"`
+	Settings                map[string]string        `json:"settings,omitempty"`            // changed system.settings during backup
+	MergeTreeSettings       map[string]string        `json:"merge_tree_settings,omitempty"` // changed system.merge_tree_settings during backup
+	PartialDownload         *PartialDownload         `json:"partial_download,omitempty"`    // local backup contains only tables and partitions selected during download
+	EncryptedDisks          map[string]EncryptedDisk `json:"encrypted_disks,omitempty"`     // settings of `encrypted` disks, files of these disks are backed up as is
+	CloudSnapshots          []CloudSnapshot          `json:"cloud_snapshots,omitempty"`     // clickhouse->cloud_snapshot_type, data of backup is inside these snapshots
+	FailedTables            []FailedTable            `json:"failed_tables,omitempty"`       // tables which failed with general->upload_failure_policy continue or threshold, look `upload --only-failed`
+}
+
+// FailedTable - table which wasn't uploaded, it is not present in `tables`
+type FailedTable struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Error    string `json:"error"`
+}
+
+// CompressionStats - archives compressed during `upload`, look general->compression_concurrency and general->adaptive_compression_level
+type CompressionStats struct {
+	Format             string  `json:"format"`
+	Concurrency        int     `json:"concurrency,omitempty"` // 0 means default of codec
+	Archives           int     `json:"archives"`
+	UncompressedBytes  uint64  `json:"uncompressed_bytes"`
+	CompressedBytes    uint64  `json:"compressed_bytes"`
+	CompressSeconds    float64 `json:"compress_seconds"`     // time of reading files and compression, summarized for all archives
+	NetworkWaitSeconds float64 `json:"network_wait_seconds"` // time when compression waited for remote storage, summarized for all archives
+	MinLevel           int     `json:"min_level"`
+	MaxLevel           int     `json:"max_level"`
+	FinalLevel         int     `json:"final_level"`
+	LevelChanges       int     `json:"level_changes,omitempty"`
+}
+
+// CloudSnapshot - cloud disk snapshot taken during `create`, look `restore --from-snapshot`
+type CloudSnapshot struct {
+	Type       string `json:"type"`           // aws_ebs, gcp_pd, azure_disk
+	Volume     string `json:"volume"`         // source EBS volume id, GCP disk name or Azure disk name
+	SnapshotId string `json:"snapshot_id"`    // EBS snapshot id, GCP snapshot name or Azure snapshot resource id
+	Zone       string `json:"zone,omitempty"` // clickhouse->cloud_snapshot_zone during backup
+}
+
+// EncryptedDisk - settings of `encrypted` disk during backup, keys are never saved, only sha256 fingerprints
+type EncryptedDisk struct {
+	Algorithm  string   `json:"algorithm"`
+	CurrentKey string   `json:"current_key"` // fingerprint of key which used for new files
+	Keys       []string `json:"keys"`        // fingerprints of all configured keys, old files could be encrypted with any of them
+}
+
+// PartialDownload - filters used by `download --tables --partitions --schema`
+type PartialDownload struct {
+	Tables       string   `json:"tables,omitempty"`
+	Partitions   []string `json:"partitions,omitempty"`
+	SchemaOnly   bool     `json:"schema_only,omitempty"`
+	RemoteTables int      `json:"remote_tables"` // tables count in remote backup
+}
+
+// String - download flags which produced partial backup
+func (p *PartialDownload) String() string {
+	flags := make([]string, 0, 3)
+	if p.Tables != "" {
+		flags = append(flags, "--tables="+p.Tables)
+	}
+	if len(p.Partitions) > 0 {
+		flags = append(flags, "--partitions="+strings.Join(p.Partitions, ","))
+	}
+	if p.SchemaOnly {
+		flags = append(flags, "--schema")
+	}
+	return strings.Join(flags, " ")
+}
+
+// DestinationStatus - upload result for each remote destination, when backup uploaded with `--destinations`
+type DestinationStatus struct {
+	Name          string    `json:"name"`
+	RemoteStorage string    `json:"remote_storage"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	UploadDate    time.Time `json:"upload_date"`
 }
 
 type DatabasesMeta struct {
@@ -41,19 +123,77 @@ type FunctionsMeta struct {
 }
 
 type TableMetadata struct {
-	Files                map[string][]string `json:"files,omitempty"`
-	RebalancedFiles      map[string]string   `json:"rebalanced_files,omitempty"`
-	Table                string              `json:"table"`
-	Database             string              `json:"database"`
-	Parts                map[string][]Part   `json:"parts"`
-	Query                string              `json:"query"`
-	Size                 map[string]int64    `json:"size"`                  // how much size on each disk
-	TotalBytes           uint64              `json:"total_bytes,omitempty"` // total table size
-	DependenciesTable    string              `json:"dependencies_table,omitempty"`
-	DependenciesDatabase string              `json:"dependencies_database,omitempty"`
-	Mutations            []MutationMetadata  `json:"mutations,omitempty"`
-	MetadataOnly         bool                `json:"metadata_only"`
-	LocalFile            string              `json:"local_file,omitempty"`
+	Files                map[string][]string          `json:"files,omitempty"`
+	RebalancedFiles      map[string]string            `json:"rebalanced_files,omitempty"`
+	Table                string                       `json:"table"`
+	Database             string                       `json:"database"`
+	Parts                map[string][]Part            `json:"parts"`
+	Query                string                       `json:"query"`
+	Size                 map[string]int64             `json:"size"`                  // how much size on each disk
+	TotalBytes           uint64                       `json:"total_bytes,omitempty"` // total table size
+	DependenciesTable    string                       `json:"dependencies_table,omitempty"`
+	DependenciesDatabase string                       `json:"dependencies_database,omitempty"`
+	Mutations            []MutationMetadata           `json:"mutations,omitempty"`
+	MetadataOnly         bool                         `json:"metadata_only"`
+	LocalFile            string                       `json:"local_file,omitempty"`
+	Checksums            map[string]map[string]string `json:"checksums,omitempty"` // sha256 for each <part>/<file> on each disk, look general->integrity_manifest
+	ParityGroups         []ParityGroup                `json:"parity_groups,omitempty"`
+	DetachedParts        map[string][]Part            `json:"detached_parts,omitempty"` // parts from `detached` directory on each disk, look `create --include-detached`
+	Projections          []ProjectionMetadata         `json:"projections,omitempty"`
+	Streaming            *StreamingMetadata           `json:"streaming,omitempty"`
+	DataFormat           string                       `json:"data_format,omitempty"`  // look general->table_compression_format, empty means data_format of backup
+	ArchiveSize          int64                        `json:"archive_size,omitempty"` // split size of data archives when upload_by_part is false, look general->upload_max_table_archives
+}
+
+// GetDataFormat - table data could be uploaded in other format than other tables of backup
+func (tm *TableMetadata) GetDataFormat(backupDataFormat string) string {
+	if tm.DataFormat != "" {
+		return tm.DataFormat
+	}
+	return backupDataFormat
+}
+
+// GetPartsWithDetached - active and detached parts, both are stored in the same backup directory of disk and uploaded together
+func (tm *TableMetadata) GetPartsWithDetached() map[string][]Part {
+	if len(tm.DetachedParts) == 0 {
+		return tm.Parts
+	}
+	result := make(map[string][]Part, len(tm.Parts)+len(tm.DetachedParts))
+	for disk, parts := range tm.Parts {
+		result[disk] = append(result[disk], parts...)
+	}
+	for disk, parts := range tm.DetachedParts {
+		result[disk] = append(result[disk], parts...)
+	}
+	return result
+}
+
+// ParityGroup - Reed-Solomon parity archives for group of archives from TableMetadata.Files, look general->parity_shards
+type ParityGroup struct {
+	Files       []string `json:"files"`
+	Sizes       []int64  `json:"sizes"`
+	Checksums   []string `json:"checksums"` // sha256 of each archive, check rebuilt archive
+	ParityFiles []string `json:"parity_files"`
+}
+
+// ProjectionMetadata - `<name>.proj` directories inside data parts on each disk, validated during create
+type ProjectionMetadata struct {
+	Name        string              `json:"name"`
+	Parts       map[string][]string `json:"parts,omitempty"`
+	BrokenParts map[string][]string `json:"broken_parts,omitempty"` // parts where projection is absent or incomplete, look `restore --repair-projections`
+}
+
+// StreamingMetadata - consumer settings and offsets of `Kafka`, `RabbitMQ` and `NATS` tables at backup time, printed as report after restore schema
+type StreamingMetadata struct {
+	Engine   string            `json:"engine"`
+	Settings map[string]string `json:"settings,omitempty"`
+	Offsets  []StreamingOffset `json:"offsets,omitempty"`
+}
+
+type StreamingOffset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
 }
 
 type MutationMetadata struct {
@@ -62,9 +202,12 @@ type MutationMetadata struct {
 }
 
 type Part struct {
-	Name           string `json:"name"`
-	Required       bool   `json:"required,omitempty"`
-	RebalancedDisk string `json:"rebalanced_disk,omitempty"`
+	Name            string            `json:"name"`
+	Required        bool              `json:"required,omitempty"`
+	RebalancedDisk  string            `json:"rebalanced_disk,omitempty"`
+	BasePart        string            `json:"base_part,omitempty"`        // part from required backup, which contains RequiredFiles, look general->upload_diff_files
+	RequiredFiles   []string          `json:"required_files,omitempty"`   // files inside part which weren't uploaded and shall link from BasePart after download
+	DuplicatedFiles map[string]string `json:"duplicated_files,omitempty"` // files inside part which weren't uploaded and shall link from "<part>/<file>" of the same disk after download, look general->upload_dedup_files
 }
 
 type SplitPartFiles struct {
@@ -15,11 +15,14 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 		DependenciesTable:    tm.DependenciesTable,
 		DependenciesDatabase: tm.DependenciesDatabase,
 		MetadataOnly:         true,
+		Streaming:            tm.Streaming,
 	}
 
 	if !metadataOnly {
 		newTM.Files = tm.Files
 		newTM.Parts = tm.Parts
+		newTM.DetachedParts = tm.DetachedParts
+		newTM.Projections = tm.Projections
 		newTM.Size = tm.Size
 		newTM.TotalBytes = tm.TotalBytes
 		newTM.MetadataOnly = false
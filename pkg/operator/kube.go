@@ -0,0 +1,208 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// errConflict - resource was changed after it was read, another operator replica could claim it
+var errConflict = errors.New("resource version conflict")
+
+// kubeClient - minimal Kubernetes REST client for custom resources, token re-read on each request, because bound service account tokens rotate
+type kubeClient struct {
+	baseURL    string
+	tokenFile  string
+	namespace  string
+	httpClient *http.Client
+}
+
+// resourceMeta - subset of ObjectMeta used by operator
+type resourceMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	Generation      int64  `json:"generation,omitempty"`
+}
+
+// resource - ClickHouseBackup or ClickHouseRestore, spec parsed depends on kind
+type resource struct {
+	Kind     string          `json:"kind,omitempty"`
+	Metadata resourceMeta    `json:"metadata"`
+	Spec     json.RawMessage `json:"spec,omitempty"`
+	Status   resourceStatus  `json:"status,omitempty"`
+}
+
+type resourceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []resource `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// newInClusterClient - use service account of pod, empty namespace means namespace of pod
+func newInClusterClient(namespace string) (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not defined, operator shall run inside Kubernetes pod")
+	}
+	caCert, err := os.ReadFile(serviceAccountPath + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("can't read service account CA: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("can't parse %s/ca.crt", serviceAccountPath)
+	}
+	if namespace == "" {
+		namespaceBytes, err := os.ReadFile(serviceAccountPath + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("can't read service account namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(namespaceBytes))
+	}
+	return &kubeClient{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccountPath + "/token",
+		namespace: namespace,
+		httpClient: &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: caPool},
+		}},
+	}, nil
+}
+
+func (k *kubeClient) resourcePath(plural, name string, subresource ...string) string {
+	p := fmt.Sprintf("%s/apis/%s/%s/namespaces/%s/%s", k.baseURL, Group, Version, url.PathEscape(k.namespace), plural)
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	for _, s := range subresource {
+		p += "/" + s
+	}
+	return p
+}
+
+func (k *kubeClient) do(ctx context.Context, method, requestURL, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read service account token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusConflict {
+			return nil, errConflict
+		}
+		return nil, fmt.Errorf("%s %s return %d: %s", method, requestURL, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return resp, nil
+}
+
+// list - all resources in namespace and resourceVersion for watch
+func (k *kubeClient) list(ctx context.Context, plural string) (*resourceList, error) {
+	resp, err := k.do(ctx, http.MethodGet, k.resourcePath(plural, ""), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	list := &resourceList{}
+	if err = json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, fmt.Errorf("can't decode %s list: %v", plural, err)
+	}
+	return list, nil
+}
+
+// watch - call handler for each event until server closes stream, return last seen resourceVersion for next watch
+func (k *kubeClient) watch(ctx context.Context, plural, resourceVersion string, handler func(eventType string, r resource)) (string, error) {
+	query := url.Values{"watch": {"true"}, "resourceVersion": {resourceVersion}, "allowWatchBookmarks": {"true"}}
+	resp, err := k.do(ctx, http.MethodGet, k.resourcePath(plural, "")+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		event := watchEvent{}
+		if err = decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return resourceVersion, ctx.Err()
+			}
+			return resourceVersion, err
+		}
+		// 410 Gone arrives as ERROR event, caller shall list again
+		if event.Type == "ERROR" {
+			return "", fmt.Errorf("%s watch error: %s", plural, string(event.Object))
+		}
+		r := resource{}
+		if err = json.Unmarshal(event.Object, &r); err != nil {
+			return resourceVersion, fmt.Errorf("can't decode %s watch event: %v", plural, err)
+		}
+		resourceVersion = r.Metadata.ResourceVersion
+		if event.Type != "BOOKMARK" {
+			handler(event.Type, r)
+		}
+	}
+}
+
+// patchStatus - merge patch of status subresource, not empty resourceVersion is a precondition and returns errConflict when resource changed
+func (k *kubeClient) patchStatus(ctx context.Context, plural, name, resourceVersion string, status resourceStatus) (*resource, error) {
+	patch := map[string]interface{}{"status": status}
+	if resourceVersion != "" {
+		patch["metadata"] = map[string]string{"resourceVersion": resourceVersion}
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.do(ctx, http.MethodPatch, k.resourcePath(plural, name, "status"), "application/merge-patch+json", body)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	patched := &resource{}
+	if err = json.NewDecoder(resp.Body).Decode(patched); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
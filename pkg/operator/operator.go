@@ -0,0 +1,347 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/backup"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	apexLog "github.com/apex/log"
+	"github.com/urfave/cli"
+)
+
+const (
+	Group   = "clickhouse-backup.altinity.com"
+	Version = "v1"
+)
+
+// custom resources plural names, look CRD in Examples.md
+const (
+	backupsPlural  = "clickhousebackups"
+	restoresPlural = "clickhouserestores"
+)
+
+// resource phases, empty phase means resource is not processed yet
+const (
+	PhaseRunning   = "Running"
+	PhaseCompleted = "Completed"
+	PhaseFailed    = "Failed"
+)
+
+// relistPause - pause before list again after watch error
+const relistPause = 5 * time.Second
+
+// BackupSpec - spec of ClickHouseBackup, LocalOnly runs `create` instead of `create_remote`
+type BackupSpec struct {
+	BackupName     string   `json:"backupName,omitempty"`
+	Tables         string   `json:"tables,omitempty"`
+	Partitions     []string `json:"partitions,omitempty"`
+	DiffFromRemote string   `json:"diffFromRemote,omitempty"`
+	SchemaOnly     bool     `json:"schemaOnly,omitempty"`
+	RBAC           bool     `json:"rbac,omitempty"`
+	Configs        bool     `json:"configs,omitempty"`
+	LocalOnly      bool     `json:"localOnly,omitempty"`
+	DeleteSource   bool     `json:"deleteSource,omitempty"`
+	Host           string   `json:"host,omitempty"`
+}
+
+// RestoreSpec - spec of ClickHouseRestore, LocalOnly runs `restore` instead of `restore_remote`
+type RestoreSpec struct {
+	BackupName      string   `json:"backupName"`
+	Tables          string   `json:"tables,omitempty"`
+	DatabaseMapping []string `json:"databaseMapping,omitempty"`
+	Partitions      []string `json:"partitions,omitempty"`
+	SchemaOnly      bool     `json:"schemaOnly,omitempty"`
+	DataOnly        bool     `json:"dataOnly,omitempty"`
+	DropExists      bool     `json:"dropExists,omitempty"`
+	RBAC            bool     `json:"rbac,omitempty"`
+	Configs         bool     `json:"configs,omitempty"`
+	LocalOnly       bool     `json:"localOnly,omitempty"`
+	Host            string   `json:"host,omitempty"`
+}
+
+// resourceStatus - status subresource of ClickHouseBackup and ClickHouseRestore
+type resourceStatus struct {
+	Phase              string   `json:"phase,omitempty"`
+	Message            string   `json:"message,omitempty"`
+	Host               string   `json:"host,omitempty"`
+	BackupName         string   `json:"backupName,omitempty"`
+	Command            string   `json:"command,omitempty"`
+	StartTime          string   `json:"startTime,omitempty"`
+	CompletionTime     string   `json:"completionTime,omitempty"`
+	Warnings           []string `json:"warnings,omitempty"`
+	ObservedGeneration int64    `json:"observedGeneration,omitempty"`
+}
+
+// backupArgs - CLI arguments for ClickHouseBackup
+func (spec BackupSpec) args(backupName string) []string {
+	command := "create_remote"
+	if spec.LocalOnly {
+		command = "create"
+	}
+	args := []string{command}
+	if spec.Tables != "" {
+		args = append(args, "--tables="+spec.Tables)
+	}
+	for _, p := range spec.Partitions {
+		args = append(args, "--partitions="+p)
+	}
+	if spec.DiffFromRemote != "" {
+		args = append(args, "--diff-from-remote="+spec.DiffFromRemote)
+	}
+	if spec.SchemaOnly {
+		args = append(args, "--schema")
+	}
+	if spec.RBAC {
+		args = append(args, "--rbac")
+	}
+	if spec.Configs {
+		args = append(args, "--configs")
+	}
+	if spec.DeleteSource && !spec.LocalOnly {
+		args = append(args, "--delete-source")
+	}
+	return append(args, backupName)
+}
+
+// args - CLI arguments for ClickHouseRestore
+func (spec RestoreSpec) args(backupName string) []string {
+	command := "restore_remote"
+	if spec.LocalOnly {
+		command = "restore"
+	}
+	args := []string{command}
+	if spec.Tables != "" {
+		args = append(args, "--tables="+spec.Tables)
+	}
+	for _, m := range spec.DatabaseMapping {
+		args = append(args, "--restore-database-mapping="+m)
+	}
+	for _, p := range spec.Partitions {
+		args = append(args, "--partitions="+p)
+	}
+	if spec.SchemaOnly {
+		args = append(args, "--schema")
+	}
+	if spec.DataOnly {
+		args = append(args, "--data")
+	}
+	if spec.DropExists {
+		args = append(args, "--rm")
+	}
+	if spec.RBAC {
+		args = append(args, "--rbac")
+	}
+	if spec.Configs {
+		args = append(args, "--configs")
+	}
+	return append(args, backupName)
+}
+
+// task - resource which waits for execution
+type task struct {
+	plural string
+	r      resource
+}
+
+type Operator struct {
+	cliApp     *cli.App
+	configPath string
+	client     *kubeClient
+	host       string
+	tasks      chan task
+	// running - plural/name of resource which is executing now
+	running atomic.Value
+	log     *apexLog.Entry
+}
+
+// Run - watch ClickHouseBackup and ClickHouseRestore in namespace, execute resources for current host one by one until SIGTERM
+func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string) error {
+	client, err := newInClusterClient(cliCtx.String("namespace"))
+	if err != nil {
+		return err
+	}
+	host := cliCtx.String("host")
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	o := &Operator{
+		cliApp:     cliApp,
+		configPath: configPath,
+		client:     client,
+		host:       host,
+		tasks:      make(chan task, 100),
+		log:        apexLog.WithFields(apexLog.Fields{"logger": "operator", "namespace": client.namespace, "host": host}),
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		status.Current.CancelAll("canceled during operator stop")
+	}()
+	o.log.Infof("watch %s and %s in %s/%s", backupsPlural, restoresPlural, Group, Version)
+	for _, plural := range []string{backupsPlural, restoresPlural} {
+		go o.watchResources(ctx, plural)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			o.log.Info("stopping operator")
+			return nil
+		case t := <-o.tasks:
+			o.execute(ctx, t)
+		}
+	}
+}
+
+// isOwned - resource without spec.host could be executed by any operator replica, the first successful claim wins
+func (o *Operator) isOwned(r resource) bool {
+	spec := struct {
+		Host string `json:"host"`
+	}{}
+	if err := json.Unmarshal(r.Spec, &spec); err != nil {
+		o.log.Warnf("%s can't parse spec: %v", r.Metadata.Name, err)
+		return false
+	}
+	return spec.Host == "" || spec.Host == o.host
+}
+
+// watchResources - list and watch resources, enqueue not processed resources, resources interrupted by restart of current host mark as Failed
+func (o *Operator) watchResources(ctx context.Context, plural string) {
+	for ctx.Err() == nil {
+		list, err := o.client.list(ctx, plural)
+		if err != nil {
+			o.log.Errorf("list %s error: %v", plural, err)
+			o.pause(ctx)
+			continue
+		}
+		for _, r := range list.Items {
+			if running, _ := o.running.Load().(string); r.Status.Phase == PhaseRunning && r.Status.Host == o.host && running != plural+"/"+r.Metadata.Name {
+				o.finish(plural, r, r.Status, fmt.Errorf("interrupted by clickhouse-backup restart"))
+				continue
+			}
+			o.enqueue(plural, r)
+		}
+		resourceVersion := list.Metadata.ResourceVersion
+		for ctx.Err() == nil && resourceVersion != "" {
+			resourceVersion, err = o.client.watch(ctx, plural, resourceVersion, func(eventType string, r resource) {
+				if eventType == "ADDED" || eventType == "MODIFIED" {
+					o.enqueue(plural, r)
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				o.log.Warnf("watch %s error: %v", plural, err)
+				break
+			}
+		}
+		o.pause(ctx)
+	}
+}
+
+func (o *Operator) enqueue(plural string, r resource) {
+	if r.Status.Phase != "" || !o.isOwned(r) {
+		return
+	}
+	o.log.Infof("%s/%s queued", plural, r.Metadata.Name)
+	o.tasks <- task{plural: plural, r: r}
+}
+
+func (o *Operator) pause(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(relistPause):
+	}
+}
+
+// execute - claim resource with resourceVersion precondition, run command the same way as API server and write result to status
+func (o *Operator) execute(ctx context.Context, t task) {
+	log := o.log.WithField("resource", t.plural+"/"+t.r.Metadata.Name)
+	var args []string
+	switch t.plural {
+	case backupsPlural:
+		spec := BackupSpec{}
+		if err := json.Unmarshal(t.r.Spec, &spec); err != nil {
+			o.finish(t.plural, t.r, resourceStatus{Host: o.host}, fmt.Errorf("invalid spec: %v", err))
+			return
+		}
+		backupName := utils.CleanBackupNameRE.ReplaceAllString(spec.BackupName, "")
+		if backupName == "" {
+			backupName = backup.NewBackupName()
+		}
+		args = spec.args(backupName)
+	case restoresPlural:
+		spec := RestoreSpec{}
+		if err := json.Unmarshal(t.r.Spec, &spec); err != nil {
+			o.finish(t.plural, t.r, resourceStatus{Host: o.host}, fmt.Errorf("invalid spec: %v", err))
+			return
+		}
+		backupName := utils.CleanBackupNameRE.ReplaceAllString(spec.BackupName, "")
+		if backupName == "" {
+			o.finish(t.plural, t.r, resourceStatus{Host: o.host}, fmt.Errorf("spec.backupName is required"))
+			return
+		}
+		args = spec.args(backupName)
+	}
+	o.running.Store(t.plural + "/" + t.r.Metadata.Name)
+	defer o.running.Store("")
+	fullCommand := strings.Join(args, " ")
+	running := resourceStatus{
+		Phase:              PhaseRunning,
+		Host:               o.host,
+		BackupName:         args[len(args)-1],
+		Command:            fullCommand,
+		StartTime:          time.Now().UTC().Format(time.RFC3339),
+		ObservedGeneration: t.r.Metadata.Generation,
+	}
+	claimed, err := o.client.patchStatus(ctx, t.plural, t.r.Metadata.Name, t.r.Metadata.ResourceVersion, running)
+	if err != nil {
+		if errors.Is(err, errConflict) {
+			log.Debugf("already claimed or changed, skip")
+		} else {
+			log.Errorf("can't set %s phase: %v", PhaseRunning, err)
+		}
+		return
+	}
+	log.Infof("%s started", fullCommand)
+	commandId, _ := status.Current.Start(fullCommand)
+	err = o.cliApp.Run(append([]string{"clickhouse-backup", "-c", o.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
+	status.Current.Stop(commandId, err)
+	if row, exists := status.Current.GetStatusById(commandId); exists {
+		for _, w := range row.Warnings {
+			running.Warnings = append(running.Warnings, fmt.Sprintf("[%s] %s", w.Kind, w.Message))
+		}
+	}
+	o.finish(t.plural, *claimed, running, err)
+}
+
+// finish - write Completed or Failed phase, use background context to write result during stop
+func (o *Operator) finish(plural string, r resource, result resourceStatus, err error) {
+	log := o.log.WithField("resource", plural+"/"+r.Metadata.Name)
+	result.Phase = PhaseCompleted
+	result.Message = ""
+	if err != nil {
+		result.Phase = PhaseFailed
+		result.Message = err.Error()
+		log.Errorf("%s failed: %v", result.Command, err)
+	} else {
+		log.Infof("%s completed", result.Command)
+	}
+	result.CompletionTime = time.Now().UTC().Format(time.RFC3339)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, patchErr := o.client.patchStatus(ctx, plural, r.Metadata.Name, "", result); patchErr != nil {
+		log.Errorf("can't set %s phase: %v", result.Phase, patchErr)
+	}
+}
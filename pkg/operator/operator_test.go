@@ -0,0 +1,78 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpecArgs(t *testing.T) {
+	backupSpec := BackupSpec{Tables: "db.*", Partitions: []string{"2024"}, DiffFromRemote: "base", RBAC: true, DeleteSource: true}
+	assert.Equal(t, []string{"create_remote", "--tables=db.*", "--partitions=2024", "--diff-from-remote=base", "--rbac", "--delete-source", "b1"}, backupSpec.args("b1"))
+	backupSpec = BackupSpec{LocalOnly: true, DeleteSource: true, SchemaOnly: true}
+	assert.Equal(t, []string{"create", "--schema", "b1"}, backupSpec.args("b1"))
+
+	restoreSpec := RestoreSpec{BackupName: "b1", DatabaseMapping: []string{"db:db2"}, DataOnly: true, DropExists: true}
+	assert.Equal(t, []string{"restore_remote", "--restore-database-mapping=db:db2", "--data", "--rm", "b1"}, restoreSpec.args("b1"))
+	restoreSpec = RestoreSpec{BackupName: "b1", LocalOnly: true, Configs: true}
+	assert.Equal(t, []string{"restore", "--configs", "b1"}, restoreSpec.args("b1"))
+}
+
+func TestKubeClient(t *testing.T) {
+	basePath := fmt.Sprintf("/apis/%s/%s/namespaces/ns/%s", Group, Version, backupsPlural)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == basePath && r.URL.Query().Get("watch") == "":
+			_, _ = io.WriteString(w, `{"metadata":{"resourceVersion":"10"},"items":[{"metadata":{"name":"b1","resourceVersion":"9"},"spec":{"host":"h1"}}]}`)
+		case r.Method == http.MethodGet && r.URL.Path == basePath:
+			assert.Equal(t, "10", r.URL.Query().Get("resourceVersion"))
+			_, _ = io.WriteString(w, `{"type":"ADDED","object":{"metadata":{"name":"b2","resourceVersion":"11"},"spec":{}}}`+"\n")
+			_, _ = io.WriteString(w, `{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"12"}}}`+"\n")
+		case r.Method == http.MethodPatch && r.URL.Path == basePath+"/b1/status":
+			assert.Equal(t, "application/merge-patch+json", r.Header.Get("Content-Type"))
+			patch := struct {
+				Metadata resourceMeta   `json:"metadata"`
+				Status   resourceStatus `json:"status"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+			if patch.Metadata.ResourceVersion != "9" {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(resource{Metadata: resourceMeta{Name: "b1", ResourceVersion: "13"}, Status: patch.Status})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	client := &kubeClient{baseURL: srv.URL, namespace: "ns", httpClient: srv.Client()}
+	o := &Operator{host: "h2"}
+	ctx := context.Background()
+
+	list, err := client.list(ctx, backupsPlural)
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	assert.False(t, o.isOwned(list.Items[0]), "spec.host is another host")
+
+	events := make([]string, 0)
+	resourceVersion, err := client.watch(ctx, backupsPlural, list.Metadata.ResourceVersion, func(eventType string, r resource) {
+		events = append(events, eventType+" "+r.Metadata.Name)
+		assert.True(t, o.isOwned(r))
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "12", resourceVersion)
+	assert.Equal(t, []string{"ADDED b2"}, events)
+
+	patched, err := client.patchStatus(ctx, backupsPlural, "b1", "9", resourceStatus{Phase: PhaseRunning})
+	require.NoError(t, err)
+	assert.Equal(t, PhaseRunning, patched.Status.Phase)
+	_, err = client.patchStatus(ctx, backupsPlural, "b1", "8", resourceStatus{Phase: PhaseRunning})
+	assert.ErrorIs(t, err, errConflict)
+}
@@ -9,6 +9,7 @@ import (
 	apexLog "github.com/apex/log"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -185,29 +186,133 @@ func dropPartitionIdTable(ch *clickhouse.ClickHouse, database string, partitionI
 
 var partitionTupleRE = regexp.MustCompile(`\)\s*,\s*\(`)
 
+// tablePartitionsRE - `db.table:partitions` per-table partitions, table pattern can't contain `(` to avoid mix with DateTime values in tuples
+var tablePartitionsRE = regexp.MustCompile(`^([^:(\s]+\.[^:(\s]+):(.+)$`)
+var partitionRangeDateRE = regexp.MustCompile(`^(\d{4})-(\d{2})(?:-(\d{2}))?$`)
+
+// splitTablePartitions - table pattern and partitions from one --partitions value, empty table pattern means partitions for all tables
+func splitTablePartitions(partitionArg string) (string, string) {
+	if match := tablePartitionsRE.FindStringSubmatch(partitionArg); match != nil {
+		return match[1], strings.Trim(match[2], " \t")
+	}
+	return "", partitionArg
+}
+
+func matchTablePattern(tablePattern, database, table string) bool {
+	if tablePattern == "" {
+		return true
+	}
+	for _, pattern := range strings.Split(tablePattern, "|") {
+		if matched, _ := filepath.Match(pattern, fmt.Sprintf("%s.%s", database, table)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePartitionRange - `from..to` inclusive range of partition_id, YYYY-MM-DD and YYYY-MM bounds converted to toYYYYMMDD and toYYYYMM partition_id format, empty bound means open range
+func parsePartitionRange(item string) (string, string, bool) {
+	from, to, isRange := strings.Cut(item, "..")
+	if !isRange {
+		return "", "", false
+	}
+	normalize := func(bound string) string {
+		bound = strings.Trim(strings.TrimSpace(bound), "'")
+		if match := partitionRangeDateRE.FindStringSubmatch(bound); match != nil {
+			return match[1] + match[2] + match[3]
+		}
+		return bound
+	}
+	return normalize(from), normalize(to), true
+}
+
+// matchPartitionRange - numeric partition_id compared as numbers, other as strings
+func matchPartitionRange(partitionId, from, to string) bool {
+	compare := func(a, b string) int {
+		aInt, aErr := strconv.ParseInt(a, 10, 64)
+		bInt, bErr := strconv.ParseInt(b, 10, 64)
+		if aErr == nil && bErr == nil {
+			switch {
+			case aInt < bInt:
+				return -1
+			case aInt > bInt:
+				return 1
+			}
+			return 0
+		}
+		return strings.Compare(a, b)
+	}
+	return (from == "" || compare(partitionId, from) >= 0) && (to == "" || compare(partitionId, to) <= 0)
+}
+
+// getPartitionIdsFromParts - part name is <partition_id>_<min_block>_<max_block>_<level>[_<mutation>]
+func getPartitionIdsFromParts(parts map[string][]metadata.Part) []string {
+	partitionIds := make([]string, 0)
+	for _, diskParts := range parts {
+		for _, part := range diskParts {
+			partitionId, _, _ := strings.Cut(part.Name, "_")
+			partitionIds = common.AddStringToSliceIfNotExists(partitionIds, partitionId)
+		}
+	}
+	return partitionIds
+}
+
+func getPartitionIdsFromClickHouse(ctx context.Context, ch *clickhouse.ClickHouse, database, table string) ([]string, error) {
+	partitionIds := make([]struct {
+		PartitionId string `ch:"partition_id"`
+	}, 0)
+	if err := ch.SelectContext(ctx, &partitionIds, "SELECT DISTINCT partition_id FROM system.parts WHERE active AND database=? AND table=?", database, table); err != nil {
+		return nil, err
+	}
+	result := make([]string, len(partitionIds))
+	for i := range partitionIds {
+		result[i] = partitionIds[i].PartitionId
+	}
+	return result, nil
+}
+
+// addPartitionRange - add partition_id from range for one table, when no partition matched range then range itself is added to partitionsIdMap, so no parts are selected instead of all parts
+func addPartitionRange(partitionIds []string, from, to, item, database, table string, partitionsIdMap map[metadata.TableTitle]common.EmptyMap, partitionsNameList map[metadata.TableTitle][]string) {
+	matched := false
+	for _, partitionId := range partitionIds {
+		if matchPartitionRange(partitionId, from, to) {
+			matched = true
+			addItemToIdMapAndNameListIfNotExists(partitionId, partitionId, database, table, partitionsIdMap, partitionsNameList)
+		}
+	}
+	if !matched {
+		apexLog.Warnf("`%s`.`%s` doesn't contain partitions in %s range", database, table, item)
+		addItemToIdMapAndNameListIfNotExists(item, "", database, table, partitionsIdMap, partitionsNameList)
+	}
+}
+
 // ConvertPartitionsToIdsMapAndNamesList - get partitions from CLI/API params and convert it for NameList and IdMap for each table
+// each value could start with `db.table:` pattern to apply partitions only for matched tables, values could be `from..to` inclusive partition_id ranges
 func ConvertPartitionsToIdsMapAndNamesList(ctx context.Context, ch *clickhouse.ClickHouse, tablesFromClickHouse []clickhouse.Table, tablesFromMetadata []metadata.TableMetadata, partitions []string) (map[metadata.TableTitle]common.EmptyMap, map[metadata.TableTitle][]string) {
 	partitionsIdMap := map[metadata.TableTitle]common.EmptyMap{}
 	partitionsNameList := map[metadata.TableTitle][]string{}
+	for _, t := range tablesFromClickHouse {
+		createIdMapAndNameListIfNotExists(t.Database, t.Name, partitionsIdMap, partitionsNameList)
+	}
+	for _, t := range tablesFromMetadata {
+		createIdMapAndNameListIfNotExists(t.Database, t.Table, partitionsIdMap, partitionsNameList)
+	}
 	if len(partitions) == 0 {
-		for _, t := range tablesFromClickHouse {
-			createIdMapAndNameListIfNotExists(t.Database, t.Name, partitionsIdMap, partitionsNameList)
-		}
-		for _, t := range tablesFromMetadata {
-			createIdMapAndNameListIfNotExists(t.Database, t.Table, partitionsIdMap, partitionsNameList)
-		}
 		return partitionsIdMap, partitionsNameList
 	}
 
 	// to allow use --partitions val1 --partitions val2, https://github.com/Altinity/clickhouse-backup/issues/425#issuecomment-1149855063
 	for _, partitionArg := range partitions {
-		partitionArg = strings.Trim(partitionArg, " \t")
+		var tablePattern string
+		tablePattern, partitionArg = splitTablePartitions(strings.Trim(partitionArg, " \t"))
 		// when PARTITION BY clause return partition_id field as hash, https://github.com/Altinity/clickhouse-backup/issues/602
 		if strings.HasPrefix(partitionArg, "(") {
 			partitionArg = strings.TrimSuffix(strings.TrimPrefix(partitionArg, "("), ")")
 			for _, partitionTuple := range partitionTupleRE.Split(partitionArg, -1) {
 				for _, t := range tablesFromClickHouse {
-					createIdMapAndNameListIfNotExists(t.Database, t.Name, partitionsIdMap, partitionsNameList)
+					if !matchTablePattern(tablePattern, t.Database, t.Name) {
+						continue
+					}
 					if partitionId, partitionName, err := GetPartitionIdAndName(ctx, ch, t.Database, t.Name, t.CreateTableQuery, partitionTuple); err != nil {
 						apexLog.Fatalf("partition.GetPartitionIdAndName error: %v", err)
 					} else if partitionId != "" {
@@ -215,7 +320,9 @@ func ConvertPartitionsToIdsMapAndNamesList(ctx context.Context, ch *clickhouse.C
 					}
 				}
 				for _, t := range tablesFromMetadata {
-					createIdMapAndNameListIfNotExists(t.Database, t.Table, partitionsIdMap, partitionsNameList)
+					if !matchTablePattern(tablePattern, t.Database, t.Table) {
+						continue
+					}
 					if partitionId, partitionName, err := GetPartitionIdAndName(ctx, ch, t.Database, t.Table, t.Query, partitionTuple); err != nil {
 						apexLog.Fatalf("partition.GetPartitionIdAndName error: %v", err)
 					} else if partitionId != "" {
@@ -227,13 +334,30 @@ func ConvertPartitionsToIdsMapAndNamesList(ctx context.Context, ch *clickhouse.C
 			// when partitionId == partitionName
 			for _, item := range strings.Split(partitionArg, ",") {
 				item = strings.Trim(item, " \t")
+				from, to, isRange := parsePartitionRange(item)
 				for _, t := range tablesFromClickHouse {
-					createIdMapAndNameListIfNotExists(t.Database, t.Name, partitionsIdMap, partitionsNameList)
-					addItemToIdMapAndNameListIfNotExists(item, item, t.Database, t.Name, partitionsIdMap, partitionsNameList)
+					if !matchTablePattern(tablePattern, t.Database, t.Name) {
+						continue
+					}
+					if !isRange {
+						addItemToIdMapAndNameListIfNotExists(item, item, t.Database, t.Name, partitionsIdMap, partitionsNameList)
+						continue
+					}
+					partitionIds, err := getPartitionIdsFromClickHouse(ctx, ch, t.Database, t.Name)
+					if err != nil {
+						apexLog.Fatalf("partition.getPartitionIdsFromClickHouse error: %v", err)
+					}
+					addPartitionRange(partitionIds, from, to, item, t.Database, t.Name, partitionsIdMap, partitionsNameList)
 				}
 				for _, t := range tablesFromMetadata {
-					createIdMapAndNameListIfNotExists(t.Database, t.Table, partitionsIdMap, partitionsNameList)
-					addItemToIdMapAndNameListIfNotExists(item, item, t.Database, t.Table, partitionsIdMap, partitionsNameList)
+					if !matchTablePattern(tablePattern, t.Database, t.Table) {
+						continue
+					}
+					if !isRange {
+						addItemToIdMapAndNameListIfNotExists(item, item, t.Database, t.Table, partitionsIdMap, partitionsNameList)
+						continue
+					}
+					addPartitionRange(getPartitionIdsFromParts(t.Parts), from, to, item, t.Database, t.Table, partitionsIdMap, partitionsNameList)
 				}
 			}
 		}
@@ -0,0 +1,50 @@
+package partition
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitTablePartitions(t *testing.T) {
+	tablePattern, partitions := splitTablePartitions("db.table_*:202401,202402")
+	assert.Equal(t, "db.table_*", tablePattern)
+	assert.Equal(t, "202401,202402", partitions)
+	tablePattern, partitions = splitTablePartitions("202401,202402")
+	assert.Equal(t, "", tablePattern)
+	assert.Equal(t, "202401,202402", partitions)
+	// DateTime value in tuple is not table pattern
+	tablePattern, partitions = splitTablePartitions("('2024-01-01 00:00:00',1)")
+	assert.Equal(t, "", tablePattern)
+	assert.Equal(t, "('2024-01-01 00:00:00',1)", partitions)
+	assert.True(t, matchTablePattern("db.t1|logs.*", "logs", "events"))
+	assert.False(t, matchTablePattern("db.t1|logs.*", "db", "t2"))
+	assert.True(t, matchTablePattern("", "db", "t2"))
+}
+
+func TestPartitionRange(t *testing.T) {
+	from, to, isRange := parsePartitionRange("2024-01-15..'2024-02-01'")
+	assert.True(t, isRange)
+	assert.Equal(t, "20240115", from)
+	assert.Equal(t, "20240201", to)
+	_, _, isRange = parsePartitionRange("202401")
+	assert.False(t, isRange)
+	from, to, _ = parsePartitionRange("2024-02..")
+	assert.Equal(t, "202402", from)
+	assert.Equal(t, "", to)
+
+	assert.True(t, matchPartitionRange("20240115", "20240115", "20240201"))
+	assert.True(t, matchPartitionRange("20240201", "20240115", "20240201"))
+	assert.False(t, matchPartitionRange("20240202", "20240115", "20240201"))
+	assert.True(t, matchPartitionRange("202412", "202402", ""))
+	// numeric compare instead of string compare
+	assert.False(t, matchPartitionRange("9", "10", "20"))
+	assert.True(t, matchPartitionRange("b", "a", "c"))
+
+	parts := map[string][]metadata.Part{
+		"default": {{Name: "202401_1_1_0"}, {Name: "202401_2_2_0"}, {Name: "202402_3_3_0_5"}},
+		"hdd":     {{Name: "202312_4_4_0"}},
+	}
+	assert.ElementsMatch(t, []string{"202401", "202402", "202312"}, getPartitionIdsFromParts(parts))
+}
@@ -0,0 +1,131 @@
+package resumable
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportVersion - increase when format of ExportedState changes incompatible
+const ExportVersion = 1
+
+// ExportedState - portable copy of <default_data_path>/backup/<backup_name>/<command>.state, local paths in Processed are relative to DefaultDataPath of exporting host
+type ExportedState struct {
+	Version         int                    `json:"version"`
+	BackupName      string                 `json:"backup_name"`
+	Command         string                 `json:"command"`
+	Hostname        string                 `json:"hostname"`
+	DefaultDataPath string                 `json:"default_data_path"`
+	ExportedAt      time.Time              `json:"exported_at"`
+	Params          map[string]interface{} `json:"params,omitempty"`
+	Processed       []ProcessedItem        `json:"processed"`
+}
+
+// ProcessedItem - already uploaded or downloaded file and size, Relocate means Path is relative to DefaultDataPath
+type ProcessedItem struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Relocate bool   `json:"relocate,omitempty"`
+}
+
+// ListStateCommands - commands which have state file for backupName, command could contain @destination suffix
+func ListStateCommands(defaultDiskPath, backupName string) ([]string, error) {
+	backupDir := path.Join(defaultDiskPath, "backup", backupName)
+	stateFiles, err := filepath.Glob(path.Join(backupDir, "*.state"))
+	if err != nil {
+		return nil, err
+	}
+	commands := make([]string, 0, len(stateFiles))
+	for _, stateFile := range stateFiles {
+		commands = append(commands, strings.TrimSuffix(strings.TrimPrefix(stateFile, backupDir+"/"), ".state"))
+	}
+	return commands, nil
+}
+
+// Export - read state file without lock, state file is append only, so partially written last line will skip
+func Export(defaultDiskPath, backupName, command string) (*ExportedState, error) {
+	stateFile := path.Join(defaultDiskPath, "backup", backupName, fmt.Sprintf("%s.state", command))
+	stateBody, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	exported := &ExportedState{
+		Version:         ExportVersion,
+		BackupName:      backupName,
+		Command:         command,
+		Hostname:        hostname,
+		DefaultDataPath: defaultDiskPath,
+		ExportedAt:      time.Now().UTC(),
+		Processed:       make([]ProcessedItem, 0),
+	}
+	for i, line := range strings.Split(string(stateBody), "\n") {
+		if line == "" {
+			continue
+		}
+		// params saved as first line with size 0, look NewState
+		if i == 0 && strings.HasPrefix(line, "{") {
+			if err = json.Unmarshal([]byte(strings.TrimSuffix(line, ":0")), &exported.Params); err != nil {
+				return nil, fmt.Errorf("can't parse params in %s: %v", stateFile, err)
+			}
+			continue
+		}
+		sizeIndex := strings.LastIndex(line, ":")
+		if sizeIndex < 0 {
+			continue
+		}
+		size, err := strconv.ParseInt(line[sizeIndex+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		item := ProcessedItem{Path: line[:sizeIndex], Size: size}
+		if strings.HasPrefix(item.Path, defaultDiskPath+"/") {
+			item.Path = strings.TrimPrefix(item.Path, defaultDiskPath)
+			item.Relocate = true
+		}
+		exported.Processed = append(exported.Processed, item)
+	}
+	return exported, nil
+}
+
+// Import - write state file for resume on current host, local backup shall exist, local paths will relocate to defaultDiskPath
+func Import(defaultDiskPath string, exported *ExportedState, force bool) (string, error) {
+	if exported.Version != ExportVersion {
+		return "", fmt.Errorf("unsupported resumable state version %d, expected %d", exported.Version, ExportVersion)
+	}
+	if exported.BackupName == "" || exported.Command == "" || strings.ContainsAny(exported.BackupName+exported.Command, "/\\") {
+		return "", fmt.Errorf("invalid backup_name=%q or command=%q", exported.BackupName, exported.Command)
+	}
+	backupDir := path.Join(defaultDiskPath, "backup", exported.BackupName)
+	if info, err := os.Stat(backupDir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("local backup %s not found, copy local backup to %s before import: %v", exported.BackupName, backupDir, err)
+	}
+	stateFile := path.Join(backupDir, fmt.Sprintf("%s.state", exported.Command))
+	if _, err := os.Stat(stateFile); err == nil && !force {
+		return "", fmt.Errorf("%s already exists, use --force to overwrite", stateFile)
+	}
+	var stateBody strings.Builder
+	if len(exported.Params) > 0 {
+		paramsBytes, err := json.Marshal(exported.Params)
+		if err != nil {
+			return "", err
+		}
+		stateBody.WriteString(fmt.Sprintf("%s:0\n", paramsBytes))
+	}
+	for _, item := range exported.Processed {
+		itemPath := item.Path
+		if item.Relocate {
+			itemPath = path.Join(defaultDiskPath, itemPath)
+		}
+		stateBody.WriteString(fmt.Sprintf("%s:%d\n", itemPath, item.Size))
+	}
+	if err := os.WriteFile(stateFile, []byte(stateBody.String()), 0644); err != nil {
+		return "", err
+	}
+	return stateFile, nil
+}
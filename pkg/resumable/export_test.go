@@ -0,0 +1,51 @@
+package resumable
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	srcPath := t.TempDir()
+	dstPath := t.TempDir()
+	backupName := "test_backup"
+	require.NoError(t, os.MkdirAll(path.Join(srcPath, "backup", backupName), 0755))
+	state := NewState(srcPath, backupName, "download", map[string]interface{}{"tablePattern": "db.*"})
+	state.AppendToState(path.Join(srcPath, "backup", backupName, "metadata", "db", "t.json"), 100)
+	state.AppendToState("db/t/default_all_1_1_0.tar", 200)
+	state.Close()
+
+	commands, err := ListStateCommands(srcPath, backupName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"download"}, commands)
+
+	exported, err := Export(srcPath, backupName, "download")
+	require.NoError(t, err)
+	assert.Equal(t, "db.*", exported.Params["tablePattern"])
+	assert.Equal(t, []ProcessedItem{
+		{Path: "/backup/test_backup/metadata/db/t.json", Size: 100, Relocate: true},
+		{Path: "db/t/default_all_1_1_0.tar", Size: 200},
+	}, exported.Processed)
+
+	_, err = Import(dstPath, exported, false)
+	assert.Error(t, err, "local backup shall exist")
+	require.NoError(t, os.MkdirAll(path.Join(dstPath, "backup", backupName), 0755))
+	_, err = Import(dstPath, exported, false)
+	require.NoError(t, err)
+	_, err = Import(dstPath, exported, false)
+	assert.Error(t, err, "exists state shall not overwrite without force")
+	_, err = Import(dstPath, exported, true)
+	require.NoError(t, err)
+
+	imported := NewState(dstPath, backupName, "download", map[string]interface{}{"tablePattern": "db.*"})
+	defer imported.Close()
+	isProcessed, size := imported.IsAlreadyProcessed(path.Join(dstPath, "backup", backupName, "metadata", "db", "t.json"))
+	assert.True(t, isProcessed)
+	assert.Equal(t, int64(100), size)
+	isProcessed, _ = imported.IsAlreadyProcessed("db/t/default_all_1_1_0.tar")
+	assert.True(t, isProcessed)
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsV2Config "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// awsBackend - AWS Secrets Manager GetSecretValue, credentials and region from default AWS chain (environment, shared config, IRSA, instance profile),
+// region from secret ARN has priority, AWS_SECRETS_MANAGER_ENDPOINT allows VPC endpoint or localstack
+type awsBackend struct{}
+
+// read - SecretString with JSON object allows #key in reference, plain text SecretString available with key omitted
+func (a *awsBackend) read(ctx context.Context, secretId string) (*secret, error) {
+	awsConfig, err := awsV2Config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if arnParts := strings.Split(secretId, ":"); len(arnParts) > 3 && arnParts[0] == "arn" {
+		awsConfig.Region = arnParts[3]
+	}
+	if awsConfig.Region == "" {
+		return nil, fmt.Errorf("AWS region is not defined, set AWS_REGION or use secret ARN")
+	}
+	endpoint := os.Getenv("AWS_SECRETS_MANAGER_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", awsConfig.Region)
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": secretId})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	creds, err := awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := sha256.Sum256(body)
+	if err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "secretsmanager", awsConfig.Region, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetSecretValue return %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	result := struct {
+		SecretString string `json:"SecretString"`
+	}{}
+	if err = json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("can't decode GetSecretValue response: %v", err)
+	}
+	return &secret{fields: secretStringFields(result.SecretString)}, nil
+}
+
+// secretStringFields - JSON object fields, or single field with whole value for plain text
+func secretStringFields(value string) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil || len(fields) == 0 {
+		return map[string]interface{}{"": value}
+	}
+	return fields
+}
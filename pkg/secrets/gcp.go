@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/api/option"
+	googleHTTPTransport "google.golang.org/api/transport/http"
+)
+
+// gcpBackend - GCP Secret Manager versions:access, credentials from Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS, workload identity, metadata server),
+// GCP_SECRET_MANAGER_ENDPOINT allows private service connect endpoint
+type gcpBackend struct{}
+
+// read - path is projects/<project>/secrets/<secret>, /versions/latest is added when version is omitted
+func (g *gcpBackend) read(ctx context.Context, name string) (*secret, error) {
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return nil, fmt.Errorf("%s shall be in projects/<project>/secrets/<secret>[/versions/<version>] format", name)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	endpoint := os.Getenv("GCP_SECRET_MANAGER_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://secretmanager.googleapis.com"
+	}
+	client, _, err := googleHTTPTransport.NewClient(ctx, option.WithScopes("https://www.googleapis.com/auth/cloud-platform"))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/v1/"+name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s:access return %d: %s", name, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	result := struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}{}
+	if err = json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("can't decode %s:access response: %v", name, err)
+	}
+	value, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode %s payload: %v", name, err)
+	}
+	return &secret{fields: secretStringFields(string(value))}, nil
+}
@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+)
+
+// reference prefixes, `vault:secret/data/chbackup#s3_secret`, `aws-sm:prod/chbackup#s3_secret`, `gcp-sm:projects/p/secrets/chbackup#s3_secret`
+const (
+	VaultPrefix = "vault:"
+	AWSPrefix   = "aws-sm:"
+	GCPPrefix   = "gcp-sm:"
+)
+
+// secret - all fields of secret, cached by path, so keys of dynamic credentials like access_key and secret_key come from one lease,
+// leaseId is not empty for Vault dynamic secrets
+type secret struct {
+	fields        map[string]interface{}
+	expires       time.Time
+	leaseId       string
+	leaseDuration time.Duration
+	renewable     bool
+}
+
+type backend interface {
+	read(ctx context.Context, path string) (*secret, error)
+}
+
+// leaseRenewer - backend which supports lease renewal for dynamic credentials
+type leaseRenewer interface {
+	renew(ctx context.Context, leaseId string, increment time.Duration) (time.Duration, error)
+}
+
+var (
+	cacheMutex sync.Mutex
+	cache      = map[string]*secret{}
+	backends   = map[string]backend{
+		VaultPrefix: &vaultBackend{},
+		AWSPrefix:   &awsBackend{},
+		GCPPrefix:   &gcpBackend{},
+	}
+)
+
+// IsReference - value shall be resolved from secrets backend
+func IsReference(value string) bool {
+	for prefix := range backends {
+		if strings.HasPrefix(value, prefix) && len(value) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseReference - split reference to backend prefix, path and optional key after last `#`
+func ParseReference(reference string) (prefix, path, key string, err error) {
+	for p := range backends {
+		if strings.HasPrefix(reference, p) {
+			prefix = p
+			break
+		}
+	}
+	if prefix == "" {
+		return "", "", "", fmt.Errorf("unknown secret reference %s, supported prefixes %s, %s, %s", reference, VaultPrefix, AWSPrefix, GCPPrefix)
+	}
+	path = strings.TrimPrefix(reference, prefix)
+	if idx := strings.LastIndex(path, "#"); idx >= 0 {
+		path, key = path[:idx], path[idx+1:]
+	}
+	if path == "" {
+		return "", "", "", fmt.Errorf("empty path in secret reference %s", reference)
+	}
+	return prefix, path, key, nil
+}
+
+// Resolve - return secret value, secrets are cached for refreshInterval, Vault leases renew in background until lease can't be renewed,
+// renewed or expired secret read again during next Resolve, so API server gets rotated credentials during config reload
+func Resolve(ctx context.Context, reference string, refreshInterval time.Duration) (string, error) {
+	prefix, path, key, err := ParseReference(reference)
+	if err != nil {
+		return "", err
+	}
+	cacheKey := prefix + path
+	cacheMutex.Lock()
+	cached, exists := cache[cacheKey]
+	if exists && time.Now().Before(cached.expires) {
+		cacheMutex.Unlock()
+		return valueByKey(cached.fields, key)
+	}
+	cacheMutex.Unlock()
+	s, err := backends[prefix].read(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("can't resolve secret %s: %v", reference, err)
+	}
+	s.expires = time.Now().Add(refreshInterval)
+	if s.leaseDuration > 0 && time.Now().Add(s.leaseDuration).Before(s.expires) {
+		s.expires = time.Now().Add(s.leaseDuration)
+	}
+	cacheMutex.Lock()
+	cache[cacheKey] = s
+	cacheMutex.Unlock()
+	if renewer, ok := backends[prefix].(leaseRenewer); ok && s.renewable && s.leaseId != "" && s.leaseDuration > 0 {
+		go renewLease(cacheKey, s, renewer)
+	}
+	value, err := valueByKey(s.fields, key)
+	if err != nil {
+		return "", fmt.Errorf("can't resolve secret %s: %v", reference, err)
+	}
+	return value, nil
+}
+
+// renewLease - renew at 2/3 of lease duration while secret is cached, stop when renew fails, then next Resolve reads new credentials
+func renewLease(cacheKey string, s *secret, renewer leaseRenewer) {
+	log := apexLog.WithFields(apexLog.Fields{"logger": "secrets", "secret": cacheKey})
+	leaseDuration := s.leaseDuration
+	for {
+		time.Sleep(leaseDuration * 2 / 3)
+		cacheMutex.Lock()
+		current := cache[cacheKey]
+		cacheMutex.Unlock()
+		if current != s {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		renewed, err := renewer.renew(ctx, s.leaseId, s.leaseDuration)
+		cancel()
+		if err != nil || renewed <= 0 {
+			log.Warnf("can't renew lease %s, secret will read again: %v", s.leaseId, err)
+			cacheMutex.Lock()
+			if cache[cacheKey] == s {
+				delete(cache, cacheKey)
+			}
+			cacheMutex.Unlock()
+			return
+		}
+		log.Debugf("lease %s renewed for %s", s.leaseId, renewed)
+		leaseDuration = renewed
+		cacheMutex.Lock()
+		if leaseExpires := time.Now().Add(renewed); leaseExpires.After(s.expires) && cache[cacheKey] == s {
+			s.expires = leaseExpires
+		}
+		cacheMutex.Unlock()
+	}
+}
+
+// valueByKey - pick key from secret fields, key could be omitted when secret contains only one field
+func valueByKey(fields map[string]interface{}, key string) (string, error) {
+	if key == "" {
+		if len(fields) != 1 {
+			return "", fmt.Errorf("secret contains %d fields, add #key to reference", len(fields))
+		}
+		for k := range fields {
+			key = k
+		}
+	}
+	value, exists := fields[key]
+	if !exists {
+		return "", fmt.Errorf("key %s not found", key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", value), nil
+}
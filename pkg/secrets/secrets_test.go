@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	prefix, path, key, err := ParseReference("vault:secret/data/chbackup#s3_secret")
+	require.NoError(t, err)
+	assert.Equal(t, []string{VaultPrefix, "secret/data/chbackup", "s3_secret"}, []string{prefix, path, key})
+	prefix, path, key, err = ParseReference("aws-sm:arn:aws:secretsmanager:eu-west-1:123:secret:chbackup")
+	require.NoError(t, err)
+	assert.Equal(t, []string{AWSPrefix, "arn:aws:secretsmanager:eu-west-1:123:secret:chbackup", ""}, []string{prefix, path, key})
+	_, _, _, err = ParseReference("gcp-sm:#key")
+	assert.Error(t, err)
+	assert.True(t, IsReference("gcp-sm:projects/p/secrets/s"))
+	assert.False(t, IsReference("vault:"))
+	assert.False(t, IsReference("plain-password"))
+}
+
+func TestSecretStringFields(t *testing.T) {
+	value, err := valueByKey(secretStringFields(`{"access_key":"AK","port":9000}`), "port")
+	require.NoError(t, err)
+	assert.Equal(t, "9000", value)
+	value, err = valueByKey(secretStringFields("plain"), "")
+	require.NoError(t, err)
+	assert.Equal(t, "plain", value)
+	_, err = valueByKey(secretStringFields(`{"a":"1","b":"2"}`), "")
+	assert.Error(t, err)
+}
+
+func TestVaultResolve(t *testing.T) {
+	var reads, renews atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-token", r.Header.Get("X-Vault-Token"))
+		switch r.URL.Path {
+		case "/v1/secret/data/chbackup":
+			_, _ = w.Write([]byte(`{"data":{"data":{"s3_secret":"kv2-secret"},"metadata":{"version":3}}}`))
+		case "/v1/aws/creds/backup":
+			reads.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"lease_id": "aws/creds/backup/1", "lease_duration": 3, "renewable": true,
+				"data": map[string]string{"access_key": "AK", "secret_key": "SK"},
+			})
+		case "/v1/sys/leases/renew":
+			renews.Add(1)
+			_, _ = w.Write([]byte(`{"lease_id":"aws/creds/backup/1","lease_duration":3,"renewable":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":["not found"]}`))
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	ctx := context.Background()
+
+	value, err := Resolve(ctx, "vault:secret/data/chbackup#s3_secret", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "kv2-secret", value)
+
+	// both keys of dynamic credentials come from one lease
+	accessKey, err := Resolve(ctx, "vault:aws/creds/backup#access_key", time.Minute)
+	require.NoError(t, err)
+	secretKey, err := Resolve(ctx, "vault:aws/creds/backup#secret_key", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AK", "SK"}, []string{accessKey, secretKey})
+	assert.Equal(t, int32(1), reads.Load())
+	assert.Eventually(t, func() bool { return renews.Load() > 0 }, 5*time.Second, 100*time.Millisecond)
+
+	_, err = Resolve(ctx, "vault:secret/data/missing#key", time.Minute)
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestVaultKubernetesTokenRenewAndLogin(t *testing.T) {
+	var logins, renewSelf atomic.Int32
+	var revoked atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			n := logins.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": fmt.Sprintf("k8s-token-%d", n), "lease_duration": 3, "renewable": true},
+			})
+		case "/v1/auth/token/renew-self":
+			renewSelf.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": r.Header.Get("X-Vault-Token"), "lease_duration": 3, "renewable": true},
+			})
+		case "/v1/secret/data/chbackup":
+			if revoked.Load() && r.Header.Get("X-Vault-Token") == "k8s-token-1" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"data":{"s3_secret":"kv2-secret"},"metadata":{"version":3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	jwtFile := path.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(jwtFile, []byte("jwt"), 0600))
+	oldTokenFile := vaultServiceAccountTokenFile
+	vaultServiceAccountTokenFile = jwtFile
+	defer func() {
+		vaultServiceAccountTokenFile = oldTokenFile
+	}()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_K8S_ROLE", "backup")
+	ctx := context.Background()
+	v := &vaultBackend{}
+
+	_, err := v.read(ctx, "secret/data/chbackup")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), logins.Load())
+
+	// renewed after 2/3 of lease_duration
+	v.tokenRenewAt = time.Now().Add(-time.Second)
+	_, err = v.read(ctx, "secret/data/chbackup")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), logins.Load())
+	assert.Equal(t, int32(1), renewSelf.Load())
+
+	// revoked token, login again after 403
+	revoked.Store(true)
+	s, err := v.read(ctx, "secret/data/chbackup")
+	require.NoError(t, err)
+	assert.Equal(t, "kv2-secret", s.fields["s3_secret"])
+	assert.Equal(t, int32(2), logins.Load())
+	assert.Equal(t, "k8s-token-2", v.token)
+
+	// expired token is not renewed
+	v.tokenRenewAt = time.Now().Add(-2 * time.Second)
+	v.tokenExpire = time.Now().Add(-time.Second)
+	_, err = v.read(ctx, "secret/data/chbackup")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), logins.Load())
+	assert.Equal(t, int32(1), renewSelf.Load())
+}
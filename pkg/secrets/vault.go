@@ -0,0 +1,240 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+)
+
+// vaultBackend - HashiCorp Vault HTTP API, configured with the same environment variables as vault CLI:
+// VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE, VAULT_CACERT, VAULT_SKIP_VERIFY,
+// when VAULT_TOKEN is empty and VAULT_K8S_ROLE is set, login with Kubernetes auth method mounted on VAULT_K8S_MOUNT (default kubernetes)
+type vaultBackend struct {
+	sync.Mutex
+	clientOnce sync.Once
+	client     *http.Client
+	clientErr  error
+	token      string
+	// tokenRenewAt - zero when token from Kubernetes auth has no TTL
+	tokenRenewAt   time.Time
+	tokenExpire    time.Time
+	tokenRenewable bool
+}
+
+// vaultServiceAccountTokenFile - var for tests
+var vaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+type vaultStatusError struct {
+	method     string
+	path       string
+	statusCode int
+	errors     []string
+}
+
+func (e *vaultStatusError) Error() string {
+	return fmt.Sprintf("%s %s return %d: %s", e.method, e.path, e.statusCode, strings.Join(e.errors, ", "))
+}
+
+type vaultResponse struct {
+	LeaseId       string                 `json:"lease_id"`
+	LeaseDuration int64                  `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *vaultAuth             `json:"auth"`
+	Errors        []string               `json:"errors"`
+}
+
+func (v *vaultBackend) httpClient() (*http.Client, error) {
+	v.clientOnce.Do(func() {
+		v.client, v.clientErr = newVaultHTTPClient()
+	})
+	return v.client, v.clientErr
+}
+
+func newVaultHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: os.Getenv("VAULT_SKIP_VERIFY") == "true" || os.Getenv("VAULT_SKIP_VERIFY") == "1"}
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read VAULT_CACERT: %v", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("can't parse VAULT_CACERT %s", caFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}, nil
+}
+
+// doWithToken - token from Kubernetes auth could be revoked before lease_duration, so login again once after 403
+func (v *vaultBackend) doWithToken(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	token, err := v.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.do(ctx, method, path, body, token)
+	var statusErr *vaultStatusError
+	if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusForbidden && os.Getenv("VAULT_TOKEN") == "" {
+		v.resetToken(token)
+		if token, err = v.getToken(ctx); err != nil {
+			return nil, err
+		}
+		return v.do(ctx, method, path, body, token)
+	}
+	return resp, err
+}
+
+// do - empty token for login requests
+func (v *vaultBackend) do(ctx context.Context, method, path string, body interface{}, token string) (*vaultResponse, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not defined")
+	}
+	client, err := v.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	var bodyReader io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, addr+"/v1/"+strings.TrimPrefix(path, "/"), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	result := &vaultResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("can't decode vault response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &vaultStatusError{method: method, path: path, statusCode: resp.StatusCode, errors: result.Errors}
+	}
+	return result, nil
+}
+
+// getToken - VAULT_TOKEN or token from Kubernetes auth, which is renewed after 2/3 of lease_duration and requested again when renew failed or token expired
+func (v *vaultBackend) getToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	v.Lock()
+	defer v.Unlock()
+	if v.token != "" && (v.tokenRenewAt.IsZero() || time.Now().Before(v.tokenRenewAt)) {
+		return v.token, nil
+	}
+	if v.token != "" && v.tokenRenewable && time.Now().Before(v.tokenExpire) {
+		resp, err := v.do(ctx, http.MethodPost, "auth/token/renew-self", nil, v.token)
+		if err == nil && resp.Auth != nil && resp.Auth.ClientToken != "" {
+			v.setToken(resp.Auth)
+			return v.token, nil
+		}
+		apexLog.WithField("logger", "secrets").Warnf("can't renew vault token, will login again: %v", err)
+	}
+	v.token = ""
+	role := os.Getenv("VAULT_K8S_ROLE")
+	if role == "" {
+		return "", fmt.Errorf("VAULT_TOKEN or VAULT_K8S_ROLE shall be defined")
+	}
+	mount := os.Getenv("VAULT_K8S_MOUNT")
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	jwt, err := os.ReadFile(vaultServiceAccountTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("can't read service account token: %v", err)
+	}
+	resp, err := v.do(ctx, http.MethodPost, "auth/"+mount+"/login", map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))}, "")
+	if err != nil {
+		return "", fmt.Errorf("vault kubernetes login error: %v", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault kubernetes login doesn't return client_token")
+	}
+	v.setToken(resp.Auth)
+	return v.token, nil
+}
+
+func (v *vaultBackend) setToken(auth *vaultAuth) {
+	v.token = auth.ClientToken
+	v.tokenRenewable = auth.Renewable
+	v.tokenRenewAt = time.Time{}
+	v.tokenExpire = time.Time{}
+	if auth.LeaseDuration > 0 {
+		lease := time.Duration(auth.LeaseDuration) * time.Second
+		v.tokenRenewAt = time.Now().Add(lease * 2 / 3)
+		v.tokenExpire = time.Now().Add(lease)
+	}
+}
+
+// resetToken - other goroutine could already get new token
+func (v *vaultBackend) resetToken(token string) {
+	v.Lock()
+	defer v.Unlock()
+	if v.token == token {
+		v.token = ""
+	}
+}
+
+// read - KV v2 secrets contain fields in data.data, KV v1 and dynamic secrets engines in data
+func (v *vaultBackend) read(ctx context.Context, path string) (*secret, error) {
+	resp, err := v.doWithToken(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	fields := resp.Data
+	if kv2Data, isKV2 := resp.Data["data"].(map[string]interface{}); isKV2 {
+		if _, hasMetadata := resp.Data["metadata"]; hasMetadata {
+			fields = kv2Data
+		}
+	}
+	return &secret{
+		fields:        fields,
+		leaseId:       resp.LeaseId,
+		leaseDuration: time.Duration(resp.LeaseDuration) * time.Second,
+		renewable:     resp.Renewable,
+	}, nil
+}
+
+func (v *vaultBackend) renew(ctx context.Context, leaseId string, increment time.Duration) (time.Duration, error) {
+	resp, err := v.doWithToken(ctx, http.MethodPut, "sys/leases/renew", map[string]interface{}{"lease_id": leaseId, "increment": int64(increment.Seconds())})
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(resp.LeaseDuration) * time.Second, nil
+}
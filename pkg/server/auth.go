@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/google/shlex"
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiRoleReadOnly = "read_only"
+	apiRoleOperator = "operator"
+	apiRoleAdmin    = "admin"
+)
+
+// apiRoleLevels - each role allows everything allowed for roles with lower level
+var apiRoleLevels = map[string]int{
+	apiRoleReadOnly: 1,
+	apiRoleOperator: 2,
+	apiRoleAdmin:    3,
+}
+
+// readOnlyRoutes - GET and HEAD routes allowed for read_only role
+var readOnlyRoutes = map[string]bool{
+	"/":                    true,
+	"/health":              true,
+	"/healthz":             true,
+	"/readyz":              true,
+	"/startupz":            true,
+	"/metrics":             true,
+	"/backup/tables":       true,
+	"/backup/tables/all":   true,
+	"/backup/list":         true,
+	"/backup/list/{where}": true,
+	"/backup/status":       true,
+	"/backup/watch/status": true,
+	"/backup/actions":      true,
+	"/openapi.json":        true,
+	"/swagger/":            true,
+}
+
+// adminRoutes - routes which could destroy data or backups, or interrupt the server, require admin role for any method, all other routes require operator role
+var adminRoutes = map[string]bool{
+	"/restart":                      true,
+	"/backup/actions/reload-config": true,
+	"/backup/clean":                 true,
+	"/backup/clean/remote_broken":   true,
+	"/backup/restore/{name}":        true,
+	"/backup/delete/{where}/{name}": true,
+}
+
+// adminCommands - the same as adminRoutes for POST /backup/actions
+var adminCommands = map[string]bool{
+	"restore":             true,
+	"restore_remote":      true,
+	"delete":              true,
+	"clean":               true,
+	"clean_remote_broken": true,
+}
+
+// apiIdentity - authenticated API client
+type apiIdentity struct {
+	name string
+	role string
+	via  string
+}
+
+// apiAuthError - statusCode is http.StatusUnauthorized or http.StatusForbidden
+type apiAuthError struct {
+	statusCode int
+	err        error
+}
+
+func (e *apiAuthError) Error() string {
+	return e.err.Error()
+}
+
+// apiUserKey - name of authenticated user in request context, saved into command status for audit log
+type apiUserKey struct{}
+
+// getAPIUser - empty when authentication is disabled
+func getAPIUser(r *http.Request) string {
+	user, _ := r.Context().Value(apiUserKey{}).(string)
+	return user
+}
+
+// apiCredentials - everything which client could provide for authentication
+type apiCredentials struct {
+	bearerToken string
+	user        string
+	pass        string
+	tlsState    *tls.ConnectionState
+}
+
+// isBasicAuthEnabled - without username and password basic auth allows everyone only when other authentication methods are not configured, the same as before OIDC and client certificates roles
+func (api *APIServer) isBasicAuthEnabled() bool {
+	if api.GetConfig().API.Username != "" || api.GetConfig().API.Password != "" {
+		return true
+	}
+	return api.oidc == nil && len(api.GetConfig().API.Users) == 0 && len(api.GetConfig().API.ClientCertAdmins) == 0 && len(api.GetConfig().API.ClientCertOperators) == 0 && len(api.GetConfig().API.ClientCertReadOnly) == 0
+}
+
+// authenticate - bearer token validated with OIDC first, then verified client certificate CN, then api->users, then api->username and api->password with admin role
+func (api *APIServer) authenticate(ctx context.Context, creds apiCredentials) (apiIdentity, error) {
+	if creds.bearerToken != "" {
+		if api.oidc == nil {
+			return apiIdentity{}, &apiAuthError{http.StatusUnauthorized, fmt.Errorf("bearer token authorization is not configured, api->oidc_issuer is empty")}
+		}
+		claims, err := api.oidc.verify(ctx, creds.bearerToken)
+		if err != nil {
+			return apiIdentity{}, &apiAuthError{http.StatusUnauthorized, fmt.Errorf("invalid bearer token: %v", err)}
+		}
+		name, _ := claims["sub"].(string)
+		if preferredName, exists := claims["preferred_username"].(string); exists {
+			name = preferredName
+		}
+		return apiIdentity{name: name, role: api.oidc.role(claims), via: "oidc"}, nil
+	}
+	if creds.tlsState != nil && len(creds.tlsState.VerifiedChains) > 0 && len(creds.tlsState.VerifiedChains[0]) > 0 {
+		commonName := creds.tlsState.VerifiedChains[0][0].Subject.CommonName
+		for _, certRoles := range []struct {
+			role        string
+			commonNames []string
+		}{
+			{apiRoleAdmin, api.GetConfig().API.ClientCertAdmins},
+			{apiRoleOperator, api.GetConfig().API.ClientCertOperators},
+			{apiRoleReadOnly, api.GetConfig().API.ClientCertReadOnly},
+		} {
+			for _, allowedName := range certRoles.commonNames {
+				if commonName == allowedName {
+					return apiIdentity{name: commonName, role: certRoles.role, via: "client_cert"}, nil
+				}
+			}
+		}
+	}
+	for _, user := range api.GetConfig().API.Users {
+		if creds.user == user.Name && creds.pass == user.Password {
+			return apiIdentity{name: creds.user, role: user.Role, via: "basic"}, nil
+		}
+	}
+	if api.isBasicAuthEnabled() && creds.user == api.GetConfig().API.Username && creds.pass == api.GetConfig().API.Password {
+		return apiIdentity{name: creds.user, role: apiRoleAdmin, via: "basic"}, nil
+	}
+	return apiIdentity{}, &apiAuthError{http.StatusUnauthorized, fmt.Errorf("authorization failed for user %s", creds.user)}
+}
+
+// authorize - identity role level shall be not less than requiredRole level, empty role doesn't allow anything
+func (api *APIServer) authorize(identity apiIdentity, requiredRole string) error {
+	if level, exists := apiRoleLevels[identity.role]; exists && level >= apiRoleLevels[requiredRole] {
+		return nil
+	}
+	return &apiAuthError{http.StatusForbidden, fmt.Errorf("%s %s has role %q, but %q required", identity.via, identity.name, identity.role, requiredRole)}
+}
+
+// requiredHTTPRole - route template is available, because middleware executes after mux routing
+func requiredHTTPRole(r *http.Request) string {
+	pathTemplate := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		pathTemplate, _ = route.GetPathTemplate()
+	}
+	if pathTemplate == "/backup/actions" && r.Method == http.MethodPost {
+		return requiredActionsRole(r)
+	}
+	return requiredRouteRole(pathTemplate, r.Method)
+}
+
+// requiredRouteRole - role which doesn't depend on request body, POST /backup/actions requires at least operator
+func requiredRouteRole(pathTemplate, method string) string {
+	if adminRoutes[pathTemplate] || (pathTemplate == "/" && method == http.MethodPost) {
+		return apiRoleAdmin
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		if readOnlyRoutes[pathTemplate] {
+			return apiRoleReadOnly
+		}
+	}
+	return apiRoleOperator
+}
+
+// requiredActionsRole - body shall be available for actions handler after read, invalid body will be rejected by handler
+func requiredActionsRole(r *http.Request) string {
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return apiRoleOperator
+	}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		row := status.ActionRow{}
+		if err = json.Unmarshal(line, &row); err != nil {
+			continue
+		}
+		if args, err := shlex.Split(row.Command); err == nil && len(args) > 0 && adminCommands[args[0]] {
+			return apiRoleAdmin
+		}
+	}
+	return apiRoleOperator
+}
+
+func (api *APIServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" && !probeRoutes[r.URL.Path] {
+			api.log.Infof("API call %s %s", r.Method, r.URL.Path)
+		} else {
+			api.log.Debugf("API call %s %s", r.Method, r.URL.Path)
+		}
+		// probes could be configured without credentials in Kubernetes, response doesn't contain sensitive data
+		if route := mux.CurrentRoute(r); route != nil {
+			if pathTemplate, _ := route.GetPathTemplate(); probeRoutes[pathTemplate] {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		creds := apiCredentials{tlsState: r.TLS}
+		if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+			creds.bearerToken = strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+		}
+		creds.user, creds.pass, _ = r.BasicAuth()
+		query := r.URL.Query()
+		if u, exist := query["user"]; exist {
+			creds.user = u[0]
+		}
+		if p, exist := query["pass"]; exist {
+			creds.pass = p[0]
+		}
+		identity, err := api.authenticate(r.Context(), creds)
+		if err == nil {
+			err = api.authorize(identity, requiredHTTPRole(r))
+		}
+		if err != nil {
+			api.log.Warnf("%s %s Authorization failed: %v", r.Method, r.URL.Path, err)
+			statusCode := http.StatusUnauthorized
+			if authErr, ok := err.(*apiAuthError); ok {
+				statusCode = authErr.statusCode
+			}
+			if statusCode == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"Provide username and password\"")
+				if api.oidc != nil {
+					w.Header().Add("WWW-Authenticate", "Bearer")
+				}
+			}
+			w.WriteHeader(statusCode)
+			if _, err := w.Write([]byte(fmt.Sprintf("%d %s\n", statusCode, http.StatusText(statusCode)))); err != nil {
+				api.log.Errorf("RequestWriter.Write return error: %v", err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiUserKey{}, identity.name)))
+	})
+}
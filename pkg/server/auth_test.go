@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCAuthentication(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var issuer string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+		case "/keys":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+			}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer idp.Close()
+	issuer = idp.URL
+
+	cfg := config.DefaultConfig()
+	cfg.API.OIDCIssuer = issuer
+	cfg.API.OIDCAudience = "clickhouse-backup"
+	cfg.API.OIDCRequiredClaims = map[string]string{"email_verified": "true"}
+	cfg.API.OIDCRolesClaim = "realm_access.roles"
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "server"), oidc: newOIDCVerifier(cfg.API)}
+
+	signToken := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(privateKey)
+		require.NoError(t, err)
+		return signed
+	}
+	validClaims := func(roles ...interface{}) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            issuer,
+			"aud":            []interface{}{"clickhouse-backup", "other"},
+			"sub":            "user1",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"email_verified": true,
+			"realm_access":   map[string]interface{}{"roles": roles},
+		}
+	}
+
+	identity, err := api.authenticate(context.Background(), apiCredentials{bearerToken: signToken(validClaims("read_only"))})
+	require.NoError(t, err)
+	assert.Equal(t, apiRoleReadOnly, identity.role)
+	assert.NoError(t, api.authorize(identity, apiRoleReadOnly))
+	assert.Error(t, api.authorize(identity, apiRoleOperator))
+
+	identity, err = api.authenticate(context.Background(), apiCredentials{bearerToken: signToken(validClaims("operator"))})
+	require.NoError(t, err)
+	assert.NoError(t, api.authorize(identity, apiRoleOperator))
+	assert.Error(t, api.authorize(identity, apiRoleAdmin))
+
+	identity, err = api.authenticate(context.Background(), apiCredentials{bearerToken: signToken(validClaims("operator", "admin"))})
+	require.NoError(t, err)
+	assert.Equal(t, apiRoleAdmin, identity.role)
+
+	identity, err = api.authenticate(context.Background(), apiCredentials{bearerToken: signToken(validClaims())})
+	require.NoError(t, err)
+	assert.Error(t, api.authorize(identity, apiRoleReadOnly))
+
+	wrongAudience := validClaims("operator")
+	wrongAudience["aud"] = "other"
+	_, err = api.authenticate(context.Background(), apiCredentials{bearerToken: signToken(wrongAudience)})
+	assert.Error(t, err)
+
+	expired := validClaims("operator")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = api.authenticate(context.Background(), apiCredentials{bearerToken: signToken(expired)})
+	assert.Error(t, err)
+
+	notVerified := validClaims("operator")
+	notVerified["email_verified"] = false
+	_, err = api.authenticate(context.Background(), apiCredentials{bearerToken: signToken(notVerified)})
+	assert.Error(t, err)
+
+	// basic auth disabled with empty username and password when OIDC configured
+	_, err = api.authenticate(context.Background(), apiCredentials{})
+	assert.Error(t, err)
+}
+
+func TestAPIRoles(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.Users = []config.APIUser{
+		{Name: "oncall", Password: "oncall", Role: apiRoleReadOnly},
+		{Name: "ci", Password: "ci", Role: apiRoleOperator},
+		{Name: "dba", Password: "dba", Role: apiRoleAdmin},
+	}
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "server")}
+
+	r := mux.NewRouter()
+	r.Use(api.authMiddleware)
+	for _, route := range []struct {
+		path    string
+		methods []string
+	}{
+		{"/", []string{"GET", "POST"}},
+		{"/backup/list", []string{"GET"}},
+		{"/backup/create", []string{"POST"}},
+		{"/backup/upload/{name}", []string{"POST"}},
+		{"/backup/restore/{name}", []string{"POST"}},
+		{"/backup/delete/{where}/{name}", []string{"POST"}},
+		{"/backup/actions", []string{"GET", "POST"}},
+	} {
+		r.HandleFunc(route.path, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		}).Methods(route.methods...)
+	}
+	request := func(user, method, url, body string) int {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.SetBasicAuth(user, user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	testCases := []struct {
+		method, url, body string
+		expected          map[string]int
+	}{
+		{"GET", "/backup/list", "", map[string]int{"oncall": 200, "ci": 200, "dba": 200}},
+		{"POST", "/backup/create", "", map[string]int{"oncall": 403, "ci": 200, "dba": 200}},
+		{"POST", "/backup/upload/b1", "", map[string]int{"oncall": 403, "ci": 200, "dba": 200}},
+		{"POST", "/backup/restore/b1", "", map[string]int{"oncall": 403, "ci": 403, "dba": 200}},
+		{"POST", "/backup/delete/remote/b1", "", map[string]int{"oncall": 403, "ci": 403, "dba": 200}},
+		{"POST", "/", "", map[string]int{"oncall": 403, "ci": 403, "dba": 200}},
+		{"POST", "/backup/actions", `{"command":"create b1"}`, map[string]int{"oncall": 403, "ci": 200, "dba": 200}},
+		{"POST", "/backup/actions", "{\"command\":\"upload b1\"}\n{\"command\":\"delete local b1\"}", map[string]int{"oncall": 403, "ci": 403, "dba": 200}},
+	}
+	for _, tc := range testCases {
+		for user, expectedCode := range tc.expected {
+			assert.Equal(t, expectedCode, request(user, tc.method, tc.url, tc.body), "%s %s %s %s", user, tc.method, tc.url, tc.body)
+		}
+	}
+	assert.Equal(t, http.StatusUnauthorized, request("unknown", "GET", "/backup/list", ""))
+	// default empty username and password are not allowed when api->users defined
+	assert.Equal(t, http.StatusUnauthorized, request("", "GET", "/backup/list", ""))
+}
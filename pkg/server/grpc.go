@@ -0,0 +1,362 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcProgressInterval - how often streaming methods send status of running command
+const grpcProgressInterval = time.Second
+
+// grpcStreamingCommands - each command available as server streaming method, look clickhouse_backup.proto
+var grpcStreamingCommands = map[string]string{
+	"Create":   "create",
+	"Upload":   "upload",
+	"Download": "download",
+	"Restore":  "restore",
+	"Delete":   "delete",
+}
+
+// grpcCommandFlags - flags which client could pass in args for each streaming command, the same flags as CLI command,
+// other flags and positional arguments are rejected, so client can't override config, command id or pass additional backup names
+var grpcCommandFlags = map[string][]string{
+	"create": {
+		"table", "tables", "t", "diff-from-remote", "partitions", "schema", "s", "rbac", "backup-rbac", "do-backup-rbac",
+		"configs", "backup-configs", "do-backup-configs", "rbac-only", "configs-only", "skip-check-parts-columns",
+		"include-detached", "consistent-snapshot", "if-not-exists", "dry-run",
+	},
+	"upload": {
+		"diff-from", "diff-from-remote", "table", "tables", "t", "partitions", "schema", "s", "resume", "resumable",
+		"destinations", "destinations-parallel", "delete", "delete-source", "delete-local", "dry-run", "only-failed", "resume-remote",
+	},
+	"download": {
+		"table", "tables", "t", "partitions", "schema", "s", "resume", "resumable", "destinations", "no-cache",
+	},
+	"restore": {
+		"table", "tables", "t", "restore-database-mapping", "m", "partitions", "schema", "s", "data", "d", "rm", "drop",
+		"i", "ignore-dependencies", "rbac", "restore-rbac", "do-restore-rbac", "configs", "restore-configs", "do-restore-configs",
+		"rbac-only", "configs-only", "convert-replicated", "reshard-cluster", "sync-replicas", "encrypted-disk-mode", "detached",
+		"repair-projections", "from-snapshot", "keeper-only", "attach-readonly", "dry-run",
+	},
+	"delete": {"dry-run", "force-gc"},
+}
+
+// grpcCommandPositionalArgs - positional arguments before backup name, delete requires exactly one of them
+var grpcCommandPositionalArgs = map[string][]string{
+	"delete": {"local", "remote"},
+}
+
+// grpcCommandArgs - each arg shall be `--flag` or `--flag=value` from grpcCommandFlags, values can't be passed as separate arg
+func grpcCommandArgs(command string, values []*structpb.Value) ([]string, error) {
+	args := make([]string, 0, len(values))
+	positionalArgs := 0
+	for _, value := range values {
+		arg := value.GetStringValue()
+		if slices.Contains(grpcCommandPositionalArgs[command], arg) {
+			positionalArgs++
+			if positionalArgs > 1 {
+				return nil, fmt.Errorf("only one of %v is allowed for %s", grpcCommandPositionalArgs[command], command)
+			}
+			// positional argument shall be before flags
+			args = append([]string{arg}, args...)
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("unexpected argument %q for %s, only flags are allowed, pass value as --flag=value", arg, command)
+		}
+		flagName, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !slices.Contains(grpcCommandFlags[command], flagName) {
+			return nil, fmt.Errorf("flag %q is not allowed for %s", flagName, command)
+		}
+		args = append(args, arg)
+	}
+	if len(grpcCommandPositionalArgs[command]) > 0 && positionalArgs == 0 {
+		return nil, fmt.Errorf("one of %v is required for %s", grpcCommandPositionalArgs[command], command)
+	}
+	return args, nil
+}
+
+// grpcServiceDesc - messages are google.protobuf.Struct, so clients don't need generated code for request and response types
+func (api *APIServer) grpcServiceDesc() *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: "clickhouse_backup.v1.ClickHouseBackup",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "List", Handler: api.grpcUnaryHandler("/clickhouse_backup.v1.ClickHouseBackup/List", api.grpcList)},
+			{MethodName: "Status", Handler: api.grpcUnaryHandler("/clickhouse_backup.v1.ClickHouseBackup/Status", api.grpcStatus)},
+		},
+		Streams:  make([]grpc.StreamDesc, 0, len(grpcStreamingCommands)),
+		Metadata: "clickhouse_backup.proto",
+	}
+	for methodName, command := range grpcStreamingCommands {
+		command := command
+		desc.Streams = append(desc.Streams, grpc.StreamDesc{
+			StreamName:    methodName,
+			ServerStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				return api.grpcRunCommand(command, stream)
+			},
+		})
+	}
+	return desc
+}
+
+// RunGRPC - serve gRPC API on api->grpc_listen until Stop, use the same TLS and credentials as REST API
+func (api *APIServer) RunGRPC() error {
+	listener, err := net.Listen("tcp", api.GetConfig().API.GRPCListenAddr)
+	if err != nil {
+		return fmt.Errorf("can't listen gRPC %s: %v", api.GetConfig().API.GRPCListenAddr, err)
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := api.grpcCheckAuth(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := api.grpcCheckAuth(stream.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+	if api.GetConfig().API.Secure {
+		tlsConfig, err := api.grpcTLSConfig()
+		if err != nil {
+			return fmt.Errorf("can't load gRPC TLS credentials: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	api.grpcServer = grpc.NewServer(opts...)
+	api.grpcServer.RegisterService(api.grpcServiceDesc(), api)
+	api.log.Infof("Starting gRPC API server on %s", api.GetConfig().API.GRPCListenAddr)
+	return api.grpcServer.Serve(listener)
+}
+
+// grpcTLSConfig - the same certificates and client certificates verification as REST API
+func (api *APIServer) grpcTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(api.GetConfig().API.CertificateFile, api.GetConfig().API.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if api.GetConfig().API.CACertFile != "" {
+		caCert, err := os.ReadFile(api.GetConfig().API.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		tlsConfig.ClientCAs.AppendCertsFromPEM(caCert)
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if api.GetConfig().API.ClientCertAuth == "verify_if_given" {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+// grpcMethodRoles - required role for each method, the same as REST API endpoints, other methods require operator role
+var grpcMethodRoles = map[string]string{
+	"/clickhouse_backup.v1.ClickHouseBackup/List":    apiRoleReadOnly,
+	"/clickhouse_backup.v1.ClickHouseBackup/Status":  apiRoleReadOnly,
+	"/clickhouse_backup.v1.ClickHouseBackup/Restore": apiRoleAdmin,
+	"/clickhouse_backup.v1.ClickHouseBackup/Delete":  apiRoleAdmin,
+}
+
+// grpcCheckAuth - `authorization: Basic base64(username:password)` or `authorization: Bearer <token>` metadata and client certificate, the same as REST API
+func (api *APIServer) grpcCheckAuth(ctx context.Context, method string) error {
+	api.log.Debugf("gRPC call %s", method)
+	creds := apiCredentials{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, authorization := range md.Get("authorization") {
+			if token, isBearer := strings.CutPrefix(authorization, "Bearer "); isBearer {
+				creds.bearerToken = strings.TrimSpace(token)
+			} else if encoded, isBasic := strings.CutPrefix(authorization, "Basic "); isBasic {
+				if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+					creds.user, creds.pass, _ = strings.Cut(string(decoded), ":")
+				}
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, isTLS := p.AuthInfo.(credentials.TLSInfo); isTLS {
+			creds.tlsState = &tlsInfo.State
+		}
+	}
+	requiredRole, exists := grpcMethodRoles[method]
+	if !exists {
+		requiredRole = apiRoleOperator
+	}
+	identity, err := api.authenticate(ctx, creds)
+	if err == nil {
+		err = api.authorize(identity, requiredRole)
+	}
+	if err != nil {
+		api.log.Warnf("gRPC %s Authorization failed: %v", method, err)
+		if authErr, ok := err.(*apiAuthError); ok && authErr.statusCode == http.StatusForbidden {
+			return grpcStatus.Error(codes.PermissionDenied, err.Error())
+		}
+		return grpcStatus.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	return nil
+}
+
+func (api *APIServer) grpcUnaryHandler(fullMethod string, f func(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := &structpb.Struct{}
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return f(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: api, FullMethod: fullMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return f(ctx, req.(*structpb.Struct))
+		})
+	}
+}
+
+// grpcList - request {"where": "local|remote"}, empty where means both, response {"backups": [...]}, backup fields the same as GET /backup/list
+func (api *APIServer) grpcList(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	where := req.GetFields()["where"].GetStringValue()
+	if where != "" && where != "local" && where != "remote" {
+		return nil, grpcStatus.Errorf(codes.InvalidArgument, "invalid where=%s, expected local or remote", where)
+	}
+	cfg, err := api.ReloadConfig(nil, "list")
+	if err != nil {
+		return nil, grpcStatus.Error(codes.Internal, err.Error())
+	}
+	fullCommand := strings.TrimSpace("list " + where)
+	commandId, commandCtx := status.Current.Start(fullCommand)
+	// cancel list when client gone
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = status.Current.CancelById(commandId, ctx.Err())
+		case <-commandCtx.Done():
+		}
+	}()
+	backups, err := api.listBackups(commandCtx, cfg, where)
+	status.Current.Stop(commandId, err)
+	if err != nil {
+		return nil, grpcStatus.Error(codes.Internal, err.Error())
+	}
+	return grpcStruct(map[string]interface{}{"backups": backups})
+}
+
+// grpcStatus - response {"actions": [...]}, action fields the same as GET /backup/actions
+func (api *APIServer) grpcStatus(_ context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	filter := req.GetFields()["filter"].GetStringValue()
+	last := int(req.GetFields()["last"].GetNumberValue())
+	return grpcStruct(map[string]interface{}{"actions": status.Current.GetStatus(false, filter, last)})
+}
+
+// grpcRunCommand - request {"backup_name": "...", "args": ["--tables=db.*"]}, stream status of command each grpcProgressInterval until command finished
+// command continue to run when client disconnected, the same as asynchronous REST API
+func (api *APIServer) grpcRunCommand(command string, stream grpc.ServerStream) error {
+	req := &structpb.Struct{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	backupName := utils.CleanBackupNameRE.ReplaceAllString(req.GetFields()["backup_name"].GetStringValue(), "")
+	if backupName == "" && command != "create" {
+		return grpcStatus.Errorf(codes.InvalidArgument, "backup_name is required for %s", command)
+	}
+	commandArgs, err := grpcCommandArgs(command, req.GetFields()["args"].GetListValue().GetValues())
+	if err != nil {
+		return grpcStatus.Error(codes.InvalidArgument, err.Error())
+	}
+	args := append([]string{command}, commandArgs...)
+	if backupName != "" {
+		args = append(args, backupName)
+	}
+	fullCommand := strings.Join(args, " ")
+	commandId, err := api.startCommand(fullCommand)
+	if err != nil {
+		return grpcStatus.Error(codes.FailedPrecondition, err.Error())
+	}
+	go func() {
+		if err := status.Current.WaitQueued(commandId, api.GetConfig().API.AllowParallel, api.GetConfig().API.MaxConcurrentOperations); err != nil {
+			api.log.Warnf("gRPC %s: %v", fullCommand, err)
+			return
+		}
+		err, _ := api.metrics.ExecuteWithMetrics(command, 0, func() error {
+			return api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
+		})
+		status.Current.Stop(commandId, err)
+		if err != nil {
+			api.log.Errorf("gRPC %s error: %v", fullCommand, err)
+			return
+		}
+		go func() {
+			if err := api.UpdateBackupMetrics(context.Background(), command != "upload" && command != "delete"); err != nil {
+				api.log.Errorf("UpdateBackupMetrics return error: %v", err)
+			}
+		}()
+	}()
+	ticker := time.NewTicker(grpcProgressInterval)
+	defer ticker.Stop()
+	startTime := time.Now()
+	for {
+		row, _ := status.Current.GetStatusById(commandId)
+		progress, err := grpcStruct(map[string]interface{}{
+			"command_id": commandId,
+			"command":    row.Command,
+			"status":     row.Status,
+			"start":      row.Start,
+			"finish":     row.Finish,
+			"error":      row.Error,
+			"bytes":      row.Bytes,
+			"elapsed":    time.Since(startTime).Round(time.Second).String(),
+		})
+		if err != nil {
+			return grpcStatus.Error(codes.Internal, err.Error())
+		}
+		if err = stream.SendMsg(progress); err != nil {
+			return err
+		}
+		if row.Status != status.InProgressStatus && row.Status != status.QueuedStatus {
+			return nil
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// grpcStruct - convert JSON serializable value to google.protobuf.Struct
+func grpcStruct(v map[string]interface{}) (*structpb.Struct, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
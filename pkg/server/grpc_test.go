@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestGrpcCommandArgs(t *testing.T) {
+	values := func(args ...string) []*structpb.Value {
+		result := make([]*structpb.Value, 0, len(args))
+		for _, arg := range args {
+			result = append(result, structpb.NewStringValue(arg))
+		}
+		return result
+	}
+	args, err := grpcCommandArgs("create", values("--tables=db.*", "--schema"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--tables=db.*", "--schema"}, args)
+
+	args, err = grpcCommandArgs("delete", values("--dry-run", "remote"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remote", "--dry-run"}, args)
+
+	for _, wrongArgs := range [][]string{
+		{"--config=/tmp/other.yml"},
+		{"--command-id=1"},
+		{"--tables", "db.*"},
+		{"other_backup"},
+		{"--rm"},
+	} {
+		_, err = grpcCommandArgs("create", values(wrongArgs...))
+		assert.Error(t, err, "%v", wrongArgs)
+	}
+	_, err = grpcCommandArgs("delete", values("--dry-run"))
+	assert.Error(t, err)
+	_, err = grpcCommandArgs("delete", values("local", "remote"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// AlertingRulesParams - Selector is comma separated label matchers added to each metric, like `job="clickhouse-backup"`,
+// StaleFactor is how many watch intervals could pass without new remote backup
+type AlertingRulesParams struct {
+	Selector    string
+	StaleFactor float64
+}
+
+type alertingRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type alertingRulesGroup struct {
+	Name  string         `yaml:"name"`
+	Rules []alertingRule `yaml:"rules"`
+}
+
+// AlertingRules - Prometheus rules file with alerts on metrics registered in RegisterMetrics,
+// thresholds calculated from watch_interval, full_interval and retention_policies in general section
+func AlertingRules(cfg *config.Config, params AlertingRulesParams) ([]byte, error) {
+	if params.StaleFactor < 1 {
+		return nil, fmt.Errorf("stale factor shall be greater or equal 1, got %v", params.StaleFactor)
+	}
+	if cfg.General.WatchDuration <= 0 {
+		return nil, fmt.Errorf("general->watch_interval is required for stale backup alerts")
+	}
+	if strings.ContainsAny(params.Selector, "{}\n") {
+		return nil, fmt.Errorf("selector shall contain only label matchers without braces, got %s", params.Selector)
+	}
+	metric := func(name string, matchers ...string) string {
+		if params.Selector != "" {
+			matchers = append(matchers, params.Selector)
+		}
+		fqName := prometheus.BuildFQName("clickhouse_backup", "", name)
+		if len(matchers) == 0 {
+			return fqName
+		}
+		return fqName + "{" + strings.Join(matchers, ",") + "}"
+	}
+	staleAfter := time.Duration(float64(cfg.General.WatchDuration) * params.StaleFactor)
+	maxChainLength := expectedChainLength(cfg.General.WatchDuration, cfg.General.FullDuration)
+	rules := []alertingRule{
+		{
+			Alert:  "ClickHouseBackupStale",
+			Expr:   fmt.Sprintf("time() - %s > %d", metric("last_create_remote_finish"), int64(staleAfter.Seconds())),
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "No new remote backup on {{ $labels.instance }}",
+				"description": fmt.Sprintf("Last remote backup finished {{ $value | humanizeDuration }} ago, watch_interval is %s", cfg.General.WatchInterval),
+			},
+		},
+	}
+	for _, policy := range cfg.General.RetentionPolicies {
+		watchInterval, watchDuration := policy.WatchInterval, policy.WatchDuration
+		if watchDuration == 0 {
+			watchInterval, watchDuration = cfg.General.WatchInterval, cfg.General.WatchDuration
+		}
+		fullDuration := policy.FullDuration
+		if fullDuration == 0 {
+			fullDuration = cfg.General.FullDuration
+		}
+		if chainLength := expectedChainLength(watchDuration, fullDuration); chainLength > maxChainLength {
+			maxChainLength = chainLength
+		}
+		policyLastBackup := metric("retention_policy_last_backup_remote", fmt.Sprintf("policy=%q", policy.Name))
+		rules = append(rules, alertingRule{
+			Alert:  "ClickHouseBackupPolicyStale",
+			Expr:   fmt.Sprintf("time() - %s > %d or absent(%s)", policyLastBackup, int64((time.Duration(float64(watchDuration) * params.StaleFactor)).Seconds()), policyLastBackup),
+			Labels: map[string]string{"severity": "critical", "policy": policy.Name},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("No new remote backup for retention policy %s on {{ $labels.instance }}", policy.Name),
+				"description": fmt.Sprintf("Last remote backup of retention policy %s uploaded {{ $value | humanizeDuration }} ago or never, watch_interval is %s", policy.Name, watchInterval),
+			},
+		})
+	}
+	failedCommands := make([]string, 0)
+	for _, command := range []string{"create", "upload", "create_remote"} {
+		failedCommands = append(failedCommands, metric(fmt.Sprintf("last_%s_status", command))+" == 0")
+	}
+	storageErrorsWindow := fmt.Sprintf("%ds", int64(staleAfter.Seconds()))
+	storageErrors := make([]string, 0)
+	for _, name := range []string{"failed_uploads", "failed_downloads", "stalled_uploads"} {
+		storageErrors = append(storageErrors, fmt.Sprintf("increase(%s[%s])", metric(name), storageErrorsWindow))
+	}
+	rules = append(rules,
+		alertingRule{
+			Alert:  "ClickHouseBackupFailed",
+			Expr:   strings.Join(failedCommands, " or "),
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "Last {{ $labels.__name__ }} failed on {{ $labels.instance }}",
+				"description": "Check clickhouse-backup logs and GET /backup/actions for error details",
+			},
+		},
+		alertingRule{
+			Alert:  "ClickHouseBackupChainTooLong",
+			Expr:   fmt.Sprintf("%s > %d", metric("last_backup_chain_length_remote"), maxChainLength),
+			For:    "1h",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Increments chain of last remote backup on {{ $labels.instance }} is too long",
+				"description": fmt.Sprintf("{{ $value }} backups required to restore last remote backup, full_interval %s and watch_interval %s expect at most %d, full backups are not created, check full_interval and incremental_max_base_age", cfg.General.FullInterval, cfg.General.WatchInterval, maxChainLength),
+			},
+		},
+		alertingRule{
+			Alert:  "ClickHouseBackupStorageErrorsRising",
+			Expr:   strings.Join(storageErrors, " + ") + " > 0",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Remote storage errors on {{ $labels.instance }}",
+				"description": fmt.Sprintf("{{ $value }} failed uploads, failed downloads and stalled upload streams during last %s, check remote storage availability and credentials", utils.HumanizeDuration(staleAfter)),
+			},
+		},
+		alertingRule{
+			Alert:  "ClickHouseBackupRemoteBroken",
+			Expr:   fmt.Sprintf("%s > 0", metric("number_backups_remote_broken")),
+			For:    "1h",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Broken remote backups on {{ $labels.instance }}",
+				"description": "{{ $value }} remote backups without valid metadata.json, check `clickhouse-backup list remote` and use `clickhouse-backup clean_remote_broken`",
+			},
+		},
+	)
+	return yaml.Marshal(map[string][]alertingRulesGroup{
+		"groups": {{Name: "clickhouse-backup", Rules: rules}},
+	})
+}
+
+// expectedChainLength - full backup and increments created every watch interval until next full backup, plus one for schedule delays
+func expectedChainLength(watchDuration, fullDuration time.Duration) int {
+	if watchDuration <= 0 || fullDuration <= watchDuration {
+		return 1
+	}
+	return int(math.Ceil(float64(fullDuration)/float64(watchDuration))) + 1
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAlertingRules(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RetentionPolicies = []config.RetentionPolicy{{Name: "finance", WatchInterval: "6h", WatchDuration: 6 * time.Hour, FullInterval: "168h", FullDuration: 168 * time.Hour}}
+	rules, err := AlertingRules(cfg, AlertingRulesParams{Selector: `job="clickhouse-backup"`, StaleFactor: 2})
+	require.NoError(t, err)
+	parsed := struct {
+		Groups []alertingRulesGroup `yaml:"groups"`
+	}{}
+	require.NoError(t, yaml.Unmarshal(rules, &parsed))
+	require.Len(t, parsed.Groups, 1)
+	expressions := map[string]string{}
+	for _, rule := range parsed.Groups[0].Rules {
+		expressions[rule.Alert] = rule.Expr
+	}
+	assert.Equal(t, `time() - clickhouse_backup_last_create_remote_finish{job="clickhouse-backup"} > 7200`, expressions["ClickHouseBackupStale"])
+	assert.Contains(t, expressions["ClickHouseBackupPolicyStale"], `clickhouse_backup_retention_policy_last_backup_remote{policy="finance",job="clickhouse-backup"} > 43200`)
+	// 168h / 6h full backup schedule of policy is longer than default 24h / 1h
+	assert.Equal(t, `clickhouse_backup_last_backup_chain_length_remote{job="clickhouse-backup"} > 29`, expressions["ClickHouseBackupChainTooLong"])
+	assert.Equal(t, `clickhouse_backup_number_backups_remote_broken{job="clickhouse-backup"} > 0`, expressions["ClickHouseBackupRemoteBroken"])
+
+	// all metrics used in expressions shall be exposed by API server
+	m := NewAPIMetrics()
+	m.RegisterMetrics()
+	m.RegisterCounterFunc("stalled_uploads", "", func() float64 { return 0 })
+	m.RetentionPolicyLastBackupRemote.WithLabelValues("finance").Set(0)
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	exposed := map[string]bool{}
+	for _, family := range families {
+		exposed[family.GetName()] = true
+	}
+	for alert, expr := range expressions {
+		for _, name := range regexp.MustCompile(`clickhouse_backup_\w+`).FindAllString(expr, -1) {
+			assert.True(t, exposed[name], "%s use unknown metric %s", alert, name)
+		}
+	}
+
+	_, err = AlertingRules(cfg, AlertingRulesParams{StaleFactor: 0.5})
+	assert.Error(t, err)
+	_, err = AlertingRules(cfg, AlertingRulesParams{Selector: `{job="x"}`, StaleFactor: 2})
+	assert.Error(t, err)
+}
+
+func TestExpectedChainLength(t *testing.T) {
+	assert.Equal(t, 25, expectedChainLength(time.Hour, 24*time.Hour))
+	assert.Equal(t, 5, expectedChainLength(7*time.Hour, 24*time.Hour))
+	assert.Equal(t, 1, expectedChainLength(time.Hour, time.Hour))
+}
@@ -7,6 +7,20 @@ import (
 	"time"
 )
 
+// RetentionPolicyDeletedBackups - counter of remote backups deleted by each retention policy, incremented via backup.SetRetentionPolicyDeletedHook, registered in RegisterMetrics
+var RetentionPolicyDeletedBackups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "clickhouse_backup",
+	Name:      "retention_policy_deleted_backups",
+	Help:      "Counter of remote backups deleted by retention policy",
+}, []string{"policy"})
+
+// Warnings - counter of non-fatal issues during operations, incremented via status.SetWarningHook, registered in RegisterMetrics
+var Warnings = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "clickhouse_backup",
+	Name:      "warnings",
+	Help:      "Counter of non-fatal issues during operations, like skipped tables or fallback paths",
+}, []string{"kind"})
+
 type APIMetricsInterface interface {
 	Start(command string, startTime time.Time)
 	Finish(command string, startTime time.Time)
@@ -31,6 +45,11 @@ type APIMetrics struct {
 	NumberBackupsRemoteExpected prometheus.Gauge
 	NumberBackupsLocalExpected  prometheus.Gauge
 	InProgressCommands          prometheus.Gauge
+	LastBackupChainLengthRemote prometheus.Gauge
+
+	RetentionPolicyBackupsRemote         *prometheus.GaugeVec
+	RetentionPolicyBackupsRemoteExpected *prometheus.GaugeVec
+	RetentionPolicyLastBackupRemote      *prometheus.GaugeVec
 
 	SubCommands map[string][]string
 	log         *apexLog.Entry
@@ -118,7 +137,7 @@ func (m *APIMetrics) RegisterMetrics() {
 	m.NumberBackupsRemoteBroken = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "clickhouse_backup",
 		Name:      "number_backups_remote_broken",
-		Help:      "Number of broken remote backups",
+		Help:      "Number of broken remote backups, updated by GET /backup/list and by metrics refresh when retention_policies defined",
 	})
 
 	m.NumberBackupsRemoteExpected = prometheus.NewGauge(prometheus.GaugeOpts{
@@ -145,6 +164,30 @@ func (m *APIMetrics) RegisterMetrics() {
 		Help:      "How many commands running in progress",
 	})
 
+	m.LastBackupChainLengthRemote = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_backup_chain_length_remote",
+		Help:      "How many remote backups are required to restore last remote backup, 1 means full backup",
+	})
+
+	m.RetentionPolicyBackupsRemote = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "retention_policy_number_backups_remote",
+		Help:      "Number of stored remote backups for each retention policy",
+	}, []string{"policy"})
+
+	m.RetentionPolicyBackupsRemoteExpected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "retention_policy_number_backups_remote_expected",
+		Help:      "How many backups expected on remote storage for each retention policy, 0 means unlimited or depends on keep_daily, keep_monthly and max_age",
+	}, []string{"policy"})
+
+	m.RetentionPolicyLastBackupRemote = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "retention_policy_last_backup_remote",
+		Help:      "Last remote backup upload timestamp for each retention policy",
+	}, []string{"policy"})
+
 	for _, command := range commandList {
 		prometheus.MustRegister(
 			m.SuccessfulCounter[command],
@@ -160,10 +203,17 @@ func (m *APIMetrics) RegisterMetrics() {
 		m.LastBackupSizeLocal,
 		m.LastBackupSizeRemote,
 		m.NumberBackupsRemote,
+		m.NumberBackupsRemoteBroken,
 		m.NumberBackupsLocal,
 		m.NumberBackupsRemoteExpected,
 		m.NumberBackupsLocalExpected,
 		m.InProgressCommands,
+		m.LastBackupChainLengthRemote,
+		m.RetentionPolicyBackupsRemote,
+		m.RetentionPolicyBackupsRemoteExpected,
+		m.RetentionPolicyLastBackupRemote,
+		RetentionPolicyDeletedBackups,
+		Warnings,
 	)
 
 	for _, command := range commandList {
@@ -171,6 +221,15 @@ func (m *APIMetrics) RegisterMetrics() {
 	}
 }
 
+// RegisterCounterFunc - register counter which value is maintained outside metrics package
+func (m *APIMetrics) RegisterCounterFunc(name, help string, value func() float64) {
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      name,
+		Help:      help,
+	}, value))
+}
+
 func (m *APIMetrics) Start(command string, startTime time.Time) {
 	if _, exists := m.LastStart[command]; exists {
 		m.LastStart[command].Set(float64(startTime.Unix()))
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// oidcKeysRefreshInterval - unknown `kid` triggers JWKS reload not often than this interval, protect identity provider from invalid tokens flood
+const oidcKeysRefreshInterval = time.Minute
+
+// oidcVerifier - validate bearer tokens issued by api->oidc_issuer with keys from JWKS endpoint
+type oidcVerifier struct {
+	cfg         config.APIConfig
+	client      *http.Client
+	parser      *jwt.Parser
+	mutex       sync.Mutex
+	jwksURL     string
+	keys        map[string]interface{}
+	lastRefresh time.Time
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newOIDCVerifier(cfg config.APIConfig) *oidcVerifier {
+	return &oidcVerifier{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		parser:  jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"})),
+		jwksURL: cfg.OIDCJWKSURL,
+	}
+}
+
+// verify - check signature, exp, iss, aud and api->oidc_required_claims, return token claims
+func (v *oidcVerifier) verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.getKey(ctx, kid)
+	}); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("token doesn't contain valid exp claim")
+	}
+	if !claims.VerifyIssuer(v.cfg.OIDCIssuer, true) {
+		return nil, fmt.Errorf("unexpected iss claim %v", claims["iss"])
+	}
+	if !claims.VerifyAudience(v.cfg.OIDCAudience, true) {
+		return nil, fmt.Errorf("unexpected aud claim %v", claims["aud"])
+	}
+	for claim, expectedValue := range v.cfg.OIDCRequiredClaims {
+		if !claimContains(claims, claim, expectedValue) {
+			return nil, fmt.Errorf("claim %s doesn't contain %s", claim, expectedValue)
+		}
+	}
+	return claims, nil
+}
+
+// role - the highest role from roles claim, empty role means token valid, but doesn't allow any endpoint
+func (v *oidcVerifier) role(claims jwt.MapClaims) string {
+	if v.cfg.OIDCAdminRole != "" && claimContains(claims, v.cfg.OIDCRolesClaim, v.cfg.OIDCAdminRole) {
+		return apiRoleAdmin
+	}
+	if v.cfg.OIDCOperatorRole != "" && claimContains(claims, v.cfg.OIDCRolesClaim, v.cfg.OIDCOperatorRole) {
+		return apiRoleOperator
+	}
+	if v.cfg.OIDCReadOnlyRole != "" && claimContains(claims, v.cfg.OIDCRolesClaim, v.cfg.OIDCReadOnlyRole) {
+		return apiRoleReadOnly
+	}
+	return ""
+}
+
+// claimContains - claim could be nested via dots, like `realm_access.roles`, value could be string, space separated string like `scope` or array
+func claimContains(claims jwt.MapClaims, claim string, expectedValue string) bool {
+	var value interface{} = map[string]interface{}(claims)
+	for _, key := range strings.Split(claim, ".") {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return false
+		}
+		if value = object[key]; value == nil {
+			return false
+		}
+	}
+	switch typedValue := value.(type) {
+	case string:
+		for _, item := range strings.Fields(typedValue) {
+			if item == expectedValue {
+				return true
+			}
+		}
+		return typedValue == expectedValue
+	case []interface{}:
+		for _, item := range typedValue {
+			if fmt.Sprint(item) == expectedValue {
+				return true
+			}
+		}
+	case bool, float64:
+		return fmt.Sprint(typedValue) == expectedValue
+	}
+	return false
+}
+
+func (v *oidcVerifier) getKey(ctx context.Context, kid string) (interface{}, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if key, exists := v.findKey(kid); exists {
+		return key, nil
+	}
+	if time.Since(v.lastRefresh) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown kid=%s", kid)
+	}
+	if err := v.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	if key, exists := v.findKey(kid); exists {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown kid=%s", kid)
+}
+
+// findKey - token without kid allowed only when JWKS contains one key
+func (v *oidcVerifier) findKey(kid string) (interface{}, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, exists := v.keys[kid]
+	return key, exists
+}
+
+func (v *oidcVerifier) refreshKeys(ctx context.Context) error {
+	v.lastRefresh = time.Now()
+	if v.jwksURL == "" {
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err := v.getJSON(ctx, strings.TrimSuffix(v.cfg.OIDCIssuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return fmt.Errorf("OIDC discovery error: %v", err)
+		}
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("OIDC discovery for %s doesn't contain jwks_uri", v.cfg.OIDCIssuer)
+		}
+		v.jwksURL = discovery.JWKSURI
+	}
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := v.getJSON(ctx, v.jwksURL, &jwks); err != nil {
+		return fmt.Errorf("can't get JWKS: %v", err)
+	}
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("invalid JWKS key kid=%s: %v", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *oidcVerifier) getJSON(ctx context.Context, url string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s return %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// publicKey - return nil for unsupported key types, they will be ignored
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, exists := curves[jwk.Crv]
+		if !exists {
+			return nil, fmt.Errorf("unsupported crv=%s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported crv=%s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
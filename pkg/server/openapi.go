@@ -0,0 +1,360 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIParam - query argument, all query arguments are strings, boolean flags are enabled when argument exists with any value
+type openAPIParam struct {
+	name        string
+	description string
+	flag        bool
+}
+
+// openAPIOperation - description for route, routes without description still present in /openapi.json
+type openAPIOperation struct {
+	summary     string
+	description string
+	params      []openAPIParam
+	response    string
+	async       bool
+}
+
+var openAPITableParams = []openAPIParam{
+	{name: "table", description: "the same as --table CLI argument"},
+	{name: "partitions", description: "the same as --partitions CLI argument"},
+}
+
+var openAPICallbackParam = openAPIParam{name: "callback", description: "URL which will call with POST and {\"status\":\"error|success\",\"error\":\"...\"} payload when operation finished"}
+
+// openAPIOperations - key is "METHOD path_template", look registerHTTPHandlers
+var openAPIOperations = map[string]openAPIOperation{
+	"GET /":             {summary: "List all current applicable HTTP routes", response: "text"},
+	"HEAD /":            {summary: "Check API server is alive", response: "text"},
+	"POST /":            {summary: "Restart HTTP server", response: "OperationStatus"},
+	"GET /restart":      {summary: "Restart HTTP server", response: "OperationStatus"},
+	"POST /restart":     {summary: "Restart HTTP server", response: "OperationStatus"},
+	"GET /health":       {summary: "Health check", response: "OperationStatus"},
+	"GET /healthz":      {summary: "Liveness probe, fails when operation in progress longer than api->wedged_job_timeout", response: "object"},
+	"GET /readyz":       {summary: "Readiness probe, checks ClickHouse, remote storage, wedged operations and drain", response: "object"},
+	"GET /startupz":     {summary: "Startup probe, succeeds after API server started", response: "object"},
+	"GET /metrics":      {summary: "Prometheus metrics", response: "text"},
+	"GET /openapi.json": {summary: "This OpenAPI specification", response: "object"},
+	"GET /backup/kill": {summary: "Kill command from GET /backup/actions list", params: []openAPIParam{
+		{name: "command", description: "command to kill, first in progress command when omitted"},
+	}, response: "OperationStatus"},
+	"POST /backup/kill": {summary: "Kill command from GET /backup/actions list", params: []openAPIParam{
+		{name: "command", description: "command to kill, first in progress command when omitted"},
+	}, response: "OperationStatus"},
+	"GET /backup/tables": {summary: "List tables, exclude tables matched with skip_tables", params: []openAPIParam{
+		{name: "table", description: "the same as --table CLI argument"},
+		{name: "remote_backup", description: "the same as --remote-backup CLI argument"},
+	}, response: "TableList"},
+	"GET /backup/tables/all": {summary: "List tables, ignore skip_tables", params: []openAPIParam{
+		{name: "table", description: "the same as --table CLI argument"},
+		{name: "remote_backup", description: "the same as --remote-backup CLI argument"},
+	}, response: "TableList"},
+	"GET /backup/list":         {summary: "List local and remote backups", response: "BackupList"},
+	"HEAD /backup/list":        {summary: "Check backup list is available", response: "text"},
+	"GET /backup/list/{where}": {summary: "List local or remote backups", response: "BackupList"},
+	"POST /backup/create": {summary: "Create local backup", async: true, params: append(append([]openAPIParam{
+		{name: "name", description: "backup name, generated when omitted"},
+		{name: "diff-from-remote", description: "the same as --diff-from-remote CLI argument"},
+		{name: "schema", description: "backup schema only", flag: true},
+		{name: "rbac", description: "backup RBAC", flag: true},
+		{name: "configs", description: "backup configs", flag: true},
+		{name: "check_parts_columns", description: "the same as --check-parts-columns CLI argument"},
+	}, openAPITableParams...), openAPICallbackParam)},
+	"GET /backup/watch":                {summary: "Run background watch process", async: true, params: openAPIWatchParams},
+	"POST /backup/watch":               {summary: "Run background watch process", async: true, params: openAPIWatchParams},
+	"GET /backup/watch/status":         {summary: "Show state of running watch processes", response: "object"},
+	"POST /backup/clean":               {summary: "Clean shadow folders on all disks", response: "OperationStatus"},
+	"POST /backup/clean/remote_broken": {summary: "Remove all broken remote backups", response: "OperationStatus"},
+	"POST /backup/upload/{name}": {summary: "Upload local backup to remote storage", async: true, params: append(append([]openAPIParam{
+		{name: "delete-source", description: "delete local backup after upload", flag: true},
+		{name: "diff-from", description: "the same as --diff-from CLI argument"},
+		{name: "diff-from-remote", description: "the same as --diff-from-remote CLI argument"},
+		{name: "schema", description: "upload schema only", flag: true},
+		{name: "resumable", description: "save intermediate upload state", flag: true},
+	}, openAPITableParams...), openAPICallbackParam)},
+	"POST /backup/download/{name}": {summary: "Download remote backup to local storage", async: true, params: append(append([]openAPIParam{
+		{name: "schema", description: "download schema only", flag: true},
+		{name: "resumable", description: "save intermediate download state", flag: true},
+	}, openAPITableParams...), openAPICallbackParam)},
+	"POST /backup/restore/{name}": {summary: "Restore schema and data from local backup", async: true, params: append(append([]openAPIParam{
+		{name: "schema", description: "restore schema only", flag: true},
+		{name: "data", description: "restore data only", flag: true},
+		{name: "rm", description: "drop tables before restore", flag: true},
+		{name: "drop", description: "drop tables before restore", flag: true},
+		{name: "ignore_dependencies", description: "the same as --ignore-dependencies CLI argument", flag: true},
+		{name: "rbac", description: "restore RBAC", flag: true},
+		{name: "configs", description: "restore configs", flag: true},
+		{name: "restore_database_mapping", description: "the same as --restore-database-mapping CLI argument"},
+	}, openAPITableParams...), openAPICallbackParam)},
+	"POST /backup/delete/{where}/{name}": {summary: "Delete local or remote backup", response: "OperationStatus"},
+	"GET /backup/status":                 {summary: "List in progress operations", response: "ActionList"},
+	"GET /backup/actions": {summary: "List all operations from start of API server", params: []openAPIParam{
+		{name: "filter", description: "filter operations on server side"},
+		{name: "last", description: "show only last N operations"},
+	}, response: "ActionList"},
+	"HEAD /backup/actions":               {summary: "Check operations list is available", response: "text"},
+	"POST /backup/actions":               {summary: "Execute commands", description: "Request body is JSONEachRow with {\"command\":\"create backup_name\"} rows", response: "OperationStatus"},
+	"POST /backup/actions/{id}/cancel":   {summary: "Cancel in progress or queued operation", response: "OperationStatus"},
+	"POST /backup/actions/reload-config": {summary: "Re-read config file for new operations without restart HTTP server", description: "Operations in progress and jobs history stay untouched, response contains changed config sections and api settings which require POST /restart", response: "object"},
+}
+
+var openAPIWatchParams = append([]openAPIParam{
+	{name: "watch_interval", description: "the same as --watch-interval CLI argument"},
+	{name: "full_interval", description: "the same as --full-interval CLI argument"},
+	{name: "watch_backup_name_template", description: "the same as --watch-backup-name-template CLI argument"},
+	{name: "retention_policy", description: "the same as --retention-policy CLI argument"},
+	{name: "schema", description: "backup schema only", flag: true},
+	{name: "rbac", description: "backup RBAC", flag: true},
+	{name: "configs", description: "backup configs", flag: true},
+	{name: "skip_check_parts_columns", description: "the same as --skip-check-parts-columns CLI argument"},
+}, openAPITableParams...)
+
+var openAPIPathParamRE = regexp.MustCompile(`\{([^}]+)}`)
+
+var openAPIPathParamDescriptions = map[string]string{
+	"name":  "backup name",
+	"where": "local or remote",
+	"id":    "operation_id",
+}
+
+// buildOpenAPISpec - generate OpenAPI 3 document from registered routes, so spec can't miss any route
+func buildOpenAPISpec(r *mux.Router, version string) ([]byte, error) {
+	paths := map[string]map[string]interface{}{}
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(pathTemplate, "/debug/") || strings.HasPrefix(pathTemplate, "/swagger") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+		if _, exists := paths[pathTemplate]; !exists {
+			paths[pathTemplate] = map[string]interface{}{}
+		}
+		for _, method := range methods {
+			paths[pathTemplate][strings.ToLower(method)] = buildOpenAPIOperation(method, pathTemplate)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "clickhouse-backup API",
+			"description": "REST API of clickhouse-backup server, look https://github.com/Altinity/clickhouse-backup#api. All JSON responses use JSONEachRow format, list responses contain one JSON object per line",
+			"version":     version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+			"schemas": openAPISchemas,
+		},
+		"security": []interface{}{
+			map[string]interface{}{"basicAuth": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func buildOpenAPIOperation(method, pathTemplate string) map[string]interface{} {
+	op, described := openAPIOperations[method+" "+pathTemplate]
+	if !described {
+		op = openAPIOperation{summary: fmt.Sprintf("%s %s", method, pathTemplate)}
+	}
+	parameters := make([]interface{}, 0)
+	for _, match := range openAPIPathParamRE.FindAllStringSubmatch(pathTemplate, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name": match[1], "in": "path", "required": true,
+			"description": openAPIPathParamDescriptions[match[1]],
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, param := range op.params {
+		description := param.description
+		if param.flag {
+			description += ", enabled when argument exists"
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name": param.name, "in": "query", "required": false,
+			"description": description,
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	successCode := "200"
+	response := op.response
+	if op.async {
+		successCode = "201"
+		response = "OperationStatus"
+	}
+	tag := "server"
+	if parts := strings.Split(strings.Trim(pathTemplate, "/"), "/"); len(parts) > 1 && parts[0] == "backup" {
+		tag = parts[1]
+	}
+	operation := map[string]interface{}{
+		"summary":         op.summary,
+		"operationId":     openAPIOperationId(method, pathTemplate),
+		"tags":            []string{tag},
+		"x-required-role": requiredRouteRole(pathTemplate, method),
+		"responses": map[string]interface{}{
+			successCode: openAPIResponse("OK", response),
+			"default":   openAPIResponse("Error", "Error"),
+		},
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if op.description != "" {
+		operation["description"] = op.description
+	}
+	if op.async {
+		operation["description"] = "Asynchronous operation, returns operation_id immediately, look GET /backup/actions for result"
+	}
+	if method == http.MethodPost && pathTemplate == "/backup/actions" {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/ActionCommand"}},
+			},
+		}
+	}
+	return operation
+}
+
+func openAPIResponse(description, response string) map[string]interface{} {
+	switch response {
+	case "", "text":
+		return map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}},
+		}
+	case "object":
+		return map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}},
+		}
+	}
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/" + response}}},
+	}
+}
+
+// openAPIOperationId - unique name for code generators, like post_backup_upload_name
+func openAPIOperationId(method, pathTemplate string) string {
+	id := strings.NewReplacer("{", "", "}", "", ".", "_", "-", "_").Replace(strings.Trim(pathTemplate, "/"))
+	id = strings.ReplaceAll(id, "/", "_")
+	if id == "" {
+		id = "root"
+	}
+	return strings.ToLower(method) + "_" + id
+}
+
+var openAPISchemas = map[string]interface{}{
+	"Error": openAPIObject(map[string]string{"status": "string", "operation": "string", "error": "string"}),
+	"OperationStatus": openAPIObject(map[string]string{
+		"status": "string", "operation": "string", "operation_id": "integer", "backup_name": "string",
+	}),
+	"Action": func() map[string]interface{} {
+		action := openAPIObject(map[string]string{
+			"id": "integer", "command": "string", "status": "string", "start": "string", "finish": "string", "error": "string", "bytes": "integer",
+		})
+		action["properties"].(map[string]interface{})["warnings"] = map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Warning"}}
+		return action
+	}(),
+	"Warning":       openAPIObject(map[string]string{"kind": "string", "message": "string", "time": "string"}),
+	"ActionList":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Action"}},
+	"ActionCommand": openAPIObject(map[string]string{"command": "string"}),
+	"Backup": openAPIObject(map[string]string{
+		"name": "string", "created": "string", "size": "integer", "location": "string", "required": "string", "desc": "string",
+	}),
+	"BackupList": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Backup"}},
+	"Table": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"Database": map[string]interface{}{"type": "string"},
+			"Name":     map[string]interface{}{"type": "string"},
+			"Engine":   map[string]interface{}{"type": "string"},
+			"Skip":     map[string]interface{}{"type": "boolean"},
+		},
+		"additionalProperties": true,
+	},
+	"TableList": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Table"}},
+}
+
+func openAPIObject(fields map[string]string) map[string]interface{} {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	properties := map[string]interface{}{}
+	for _, name := range names {
+		properties[name] = map[string]interface{}{"type": fields[name]}
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// httpOpenAPIHandler - serve specification generated in registerHTTPHandlers
+func (api *APIServer) httpOpenAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(api.openAPISpec)
+}
+
+var swaggerUITemplate = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8"/>
+  <title>clickhouse-backup API</title>
+  <link rel="stylesheet" href="{{ .AssetsURL }}/swagger-ui.css"/>
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{ .AssetsURL }}/swagger-ui-bundle.js" crossorigin></script>
+<script>
+  window.onload = function () {
+    window.ui = SwaggerUIBundle({url: "{{ .SpecURL }}", dom_id: "#swagger-ui"});
+  };
+</script>
+</body>
+</html>
+`))
+
+// httpSwaggerUIHandler - HTML page which loads swagger-ui assets from api->swagger_ui_assets_url and render /openapi.json
+func (api *APIServer) httpSwaggerUIHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	if err := swaggerUITemplate.Execute(w, struct {
+		AssetsURL string
+		SpecURL   string
+	}{
+		AssetsURL: strings.TrimSuffix(api.GetConfig().API.SwaggerUIAssetsURL, "/"),
+		SpecURL:   "/openapi.json",
+	}); err != nil {
+		api.log.Errorf("swagger-ui template error: %v", err)
+	}
+}
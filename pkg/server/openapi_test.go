@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.EnableSwaggerUI = true
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "server"), clickhouseBackupVersion: "test"}
+	srv := api.registerHTTPHandlers()
+	require.NotNil(t, srv)
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	spec := struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]struct {
+			Summary      string `json:"summary"`
+			OperationId  string `json:"operationId"`
+			RequiredRole string `json:"x-required-role"`
+			Parameters   []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			Responses map[string]interface{} `json:"responses"`
+		} `json:"paths"`
+	}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+	assert.Equal(t, "test", spec.Info.Version)
+
+	// each registered route shall be described
+	operationIds := map[string]bool{}
+	checkedRoutes := map[string]bool{}
+	for _, route := range api.routes {
+		// the same path is registered separately for different methods, like "/" and "/backup/actions", Swagger UI is not a part of API
+		if checkedRoutes[route] || route == "/swagger/" {
+			continue
+		}
+		checkedRoutes[route] = true
+		require.Contains(t, spec.Paths, route)
+		for method, op := range spec.Paths[route] {
+			_, described := openAPIOperations[strings.ToUpper(method)+" "+route]
+			assert.True(t, described, "%s %s", method, route)
+			assert.NotContains(t, operationIds, op.OperationId)
+			operationIds[op.OperationId] = true
+			assert.NotEmpty(t, op.RequiredRole)
+		}
+	}
+	assert.NotContains(t, spec.Paths, "/swagger/")
+
+	upload := spec.Paths["/backup/upload/{name}"]["post"]
+	assert.Equal(t, "post_backup_upload_name", upload.OperationId)
+	assert.Equal(t, "operator", upload.RequiredRole)
+	assert.Contains(t, upload.Responses, "201")
+	parameters := map[string]string{}
+	for _, p := range upload.Parameters {
+		parameters[p.Name] = p.In
+	}
+	assert.Equal(t, "path", parameters["name"])
+	assert.Equal(t, "query", parameters["resumable"])
+	assert.Equal(t, "query", parameters["callback"])
+	assert.Equal(t, "admin", spec.Paths["/backup/delete/{where}/{name}"]["post"].RequiredRole)
+	assert.Equal(t, "read_only", spec.Paths["/backup/list"]["get"].RequiredRole)
+	assert.Contains(t, spec.Paths, "/health")
+
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/swagger/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js")
+	assert.Contains(t, w.Body.String(), "/openapi.json")
+}
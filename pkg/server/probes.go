@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+// probeRoutes - Kubernetes probes, available without authorization and during drain
+var probeRoutes = map[string]bool{
+	"/healthz":  true,
+	"/readyz":   true,
+	"/startupz": true,
+}
+
+// readyzProbeFile - StatFile for not existent key checks remote storage credentials and connectivity without listing all backups
+const readyzProbeFile = ".readyz"
+
+const (
+	probeOK      = "ok"
+	probeFail    = "fail"
+	probeSkip    = "skip"
+	probeWedged  = "wedged"
+	probeDrain   = "draining"
+	probeStarted = "starting"
+)
+
+type probeStatus struct {
+	Status        string `json:"status"`
+	ClickHouse    string `json:"clickhouse,omitempty"`
+	RemoteStorage string `json:"remote_storage,omitempty"`
+	Jobs          string `json:"jobs,omitempty"`
+	Wedged        []int  `json:"wedged,omitempty"`
+}
+
+func (api *APIServer) registerProbeHandlers(r *mux.Router) {
+	r.HandleFunc("/healthz", api.httpHealthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", api.httpReadyzHandler).Methods("GET")
+	r.HandleFunc("/startupz", api.httpStartupzHandler).Methods("GET")
+}
+
+// wedgedJobs - ids of operations in progress longer than api->wedged_job_timeout
+func (api *APIServer) wedgedJobs() []int {
+	wedged := status.Current.Wedged(api.GetConfig().API.WedgedJobTimeoutDuration)
+	ids := make([]int, len(wedged))
+	for i := range wedged {
+		api.log.Warnf("operation id=%d `%s` in progress since %s, longer than api->wedged_job_timeout=%s", wedged[i].Id, wedged[i].Command, wedged[i].Start, api.GetConfig().API.WedgedJobTimeout)
+		ids[i] = wedged[i].Id
+	}
+	return ids
+}
+
+// httpHealthzHandler - liveness probe, fail only when operation wedged, restart will not help when ClickHouse or remote storage unavailable
+func (api *APIServer) httpHealthzHandler(w http.ResponseWriter, _ *http.Request) {
+	if wedged := api.wedgedJobs(); len(wedged) > 0 {
+		api.sendJSONEachRow(w, http.StatusServiceUnavailable, probeStatus{Status: probeFail, Jobs: probeWedged, Wedged: wedged})
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, probeStatus{Status: probeOK, Jobs: probeOK})
+}
+
+// httpReadyzHandler - readiness probe, ClickHouse and remote storage reachable, no wedged operations and server is not draining
+func (api *APIServer) httpReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if api.draining.Load() {
+		api.sendJSONEachRow(w, http.StatusServiceUnavailable, probeStatus{Status: probeDrain})
+		return
+	}
+	ctx := r.Context()
+	if api.GetConfig().API.ProbeTimeoutDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.GetConfig().API.ProbeTimeoutDuration)
+		defer cancel()
+	}
+	result := probeStatus{Status: probeOK, ClickHouse: probeOK, RemoteStorage: probeOK, Jobs: probeOK}
+	if err := api.checkClickHouse(ctx); err != nil {
+		api.log.Warnf("/readyz clickhouse: %v", err)
+		result.Status, result.ClickHouse, result.RemoteStorage = probeFail, probeFail, probeSkip
+	} else if checked, err := api.checkRemoteStorage(ctx); err != nil {
+		api.log.Warnf("/readyz remote_storage: %v", err)
+		result.Status, result.RemoteStorage = probeFail, probeFail
+	} else if !checked {
+		result.RemoteStorage = probeSkip
+	}
+	if result.Wedged = api.wedgedJobs(); len(result.Wedged) > 0 {
+		result.Status, result.Jobs = probeFail, probeWedged
+	}
+	statusCode := http.StatusOK
+	if result.Status != probeOK {
+		statusCode = http.StatusServiceUnavailable
+	}
+	api.sendJSONEachRow(w, statusCode, result)
+}
+
+// httpStartupzHandler - startup probe, ok after ClickHouse was available and all API server components started
+func (api *APIServer) httpStartupzHandler(w http.ResponseWriter, _ *http.Request) {
+	if !api.started.Load() {
+		api.sendJSONEachRow(w, http.StatusServiceUnavailable, probeStatus{Status: probeStarted})
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, probeStatus{Status: probeOK})
+}
+
+// checkClickHouse - ping connection which opened during server startup, clickhouse-go reconnects when required
+func (api *APIServer) checkClickHouse(ctx context.Context) error {
+	ch := api.getClickHouse()
+	if ch == nil || !ch.IsOpen {
+		return fmt.Errorf("clickhouse connection is not opened")
+	}
+	return ch.GetConn().Ping(ctx)
+}
+
+// checkRemoteStorage - return false when remote_storage is none or custom, custom commands could be expensive
+func (api *APIServer) checkRemoteStorage(ctx context.Context) (bool, error) {
+	cfg := api.GetConfig()
+	if cfg.General.RemoteStorage == "none" || cfg.General.RemoteStorage == "custom" {
+		return false, nil
+	}
+	dst := api.getReadyzDestination(cfg)
+	select {
+	case <-ctx.Done():
+		return true, fmt.Errorf("connect to remote storage: %w", ctx.Err())
+	case <-dst.connected:
+	}
+	if dst.err != nil {
+		api.resetReadyzDestination(dst)
+		return true, dst.err
+	}
+	if _, err := dst.bd.StatFile(ctx, readyzProbeFile); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		// reconnect during next probe, credentials could be rotated
+		api.resetReadyzDestination(dst)
+		return true, err
+	}
+	return true, nil
+}
+
+// newReadyzBackupDestination - NewBackupDestination applies macros to storage config, so use copy
+var newReadyzBackupDestination = func(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse) (*storage.BackupDestination, error) {
+	bdCfg := *cfg
+	bd, err := storage.NewBackupDestination(ctx, &bdCfg, ch, false, "")
+	if err != nil {
+		return nil, err
+	}
+	return bd, bd.Connect(ctx)
+}
+
+// readyzDestination - remote storage connection reused by /readyz until config reloaded or check failed
+type readyzDestination struct {
+	cfg       *config.Config
+	bd        *storage.BackupDestination
+	err       error
+	connected chan struct{}
+}
+
+// getReadyzDestination - connection shall outlive probe request, so it is opened in background without probe context, slow connect will be ready for next probe
+func (api *APIServer) getReadyzDestination(cfg *config.Config) *readyzDestination {
+	api.readyzMutex.Lock()
+	defer api.readyzMutex.Unlock()
+	if api.readyz != nil && api.readyz.cfg == cfg {
+		return api.readyz
+	}
+	if api.readyz != nil {
+		go api.closeReadyzDestination(api.readyz)
+	}
+	dst := &readyzDestination{cfg: cfg, connected: make(chan struct{})}
+	ch := api.getClickHouse()
+	go func() {
+		defer close(dst.connected)
+		dst.bd, dst.err = newReadyzBackupDestination(context.Background(), cfg, ch)
+	}()
+	api.readyz = dst
+	return dst
+}
+
+func (api *APIServer) resetReadyzDestination(dst *readyzDestination) {
+	api.readyzMutex.Lock()
+	if api.readyz == dst {
+		api.readyz = nil
+	}
+	api.readyzMutex.Unlock()
+	go api.closeReadyzDestination(dst)
+}
+
+func (api *APIServer) closeReadyzDestination(dst *readyzDestination) {
+	<-dst.connected
+	if dst.err != nil || dst.bd == nil {
+		return
+	}
+	if err := dst.bd.Close(context.Background()); err != nil {
+		api.log.Warnf("can't close BackupDestination error: %v", err)
+	}
+}
+
+// drainMiddleware - during drain allow only read only requests, probes and cancel of running operations
+func (api *APIServer) drainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.draining.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pathTemplate := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			pathTemplate, _ = route.GetPathTemplate()
+		}
+		if probeRoutes[pathTemplate] || pathTemplate == "/backup/kill" || pathTemplate == "/backup/actions/{id}/cancel" || requiredRouteRole(pathTemplate, r.Method) == apiRoleReadOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "60")
+		api.writeError(w, http.StatusServiceUnavailable, r.URL.Path, ErrAPIDraining)
+	})
+}
+
+// drain - reject new operations, cancel queued operations and watch, wait in progress operations up to api->drain_timeout or until next signal
+// operations which don't finish will cancel by Stop, upload and download with resumable state continue after restart when api->complete_resumable_after_restart: true
+func (api *APIServer) drain(sigterm <-chan os.Signal) {
+	api.draining.Store(true)
+	timeout := api.GetConfig().API.DrainTimeoutDuration
+	if timeout <= 0 {
+		return
+	}
+	status.Current.CancelPending("canceled during server drain")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-sigterm:
+			api.log.Warn("got signal again during drain, stop immediately")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	api.log.Infof("drain: wait up to %s for operations in progress", timeout)
+	start := time.Now()
+	if err := status.Current.WaitInProgress(ctx); err != nil {
+		api.log.Warnf("drain: operations in progress will cancel after %s: %v, upload and download with `general->use_resumable_state: true` will continue after restart", time.Since(start).Round(time.Millisecond), err)
+		return
+	}
+	api.log.Infof("drain: all operations finished after %s", time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.Username = "admin"
+	cfg.API.Password = "secret"
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "server")}
+	srv := api.registerHTTPHandlers()
+	require.NotNil(t, srv)
+	probe := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+
+	// probes don't require credentials
+	assert.Equal(t, http.StatusUnauthorized, probe(http.MethodGet, "/backup/list").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(http.MethodGet, "/startupz").Code)
+	api.started.Store(true)
+	assert.Equal(t, http.StatusOK, probe(http.MethodGet, "/startupz").Code)
+	assert.Equal(t, http.StatusOK, probe(http.MethodGet, "/healthz").Code)
+
+	w := probe(http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "clickhouse connection is not opened")
+	assert.Contains(t, w.Body.String(), `"clickhouse":"fail"`)
+
+	api.draining.Store(true)
+	w = probe(http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"draining"`)
+	assert.Equal(t, http.StatusOK, probe(http.MethodGet, "/healthz").Code)
+	w = probe(http.MethodPost, "/backup/create?user=admin&pass=secret")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), ErrAPIDraining.Error())
+	_, err := api.startCommand("create backup_a")
+	assert.ErrorIs(t, err, ErrAPIDraining)
+}
+
+type readyzTestStorage struct {
+	storage.RemoteStorage
+	statErr error
+}
+
+func (s *readyzTestStorage) StatFile(ctx context.Context, key string) (storage.RemoteFile, error) {
+	return nil, s.statErr
+}
+
+func (s *readyzTestStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+func TestCheckRemoteStorageReuseConnection(t *testing.T) {
+	remote := &readyzTestStorage{statErr: storage.ErrNotFound}
+	var connects atomic.Int32
+	connectDelay := time.Duration(0)
+	newDestination := newReadyzBackupDestination
+	newReadyzBackupDestination = func(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse) (*storage.BackupDestination, error) {
+		connects.Add(1)
+		time.Sleep(connectDelay)
+		return storage.NewBackupDestinationFromRemoteStorage(cfg, remote, apexLog.WithField("logger", "test")), nil
+	}
+	defer func() {
+		newReadyzBackupDestination = newDestination
+	}()
+	cfg := config.DefaultConfig()
+	cfg.General.RemoteStorage = "s3"
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "server")}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		checked, err := api.checkRemoteStorage(ctx)
+		require.NoError(t, err)
+		require.True(t, checked)
+	}
+	assert.Equal(t, int32(1), connects.Load())
+
+	// failed check reconnect during next probe
+	remote.statErr = errors.New("access denied")
+	_, err := api.checkRemoteStorage(ctx)
+	require.Error(t, err)
+	remote.statErr = storage.ErrNotFound
+	_, err = api.checkRemoteStorage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), connects.Load())
+
+	// reloaded config reconnect, slow connect fails probe by timeout and is reused by next probe
+	connectDelay = 100 * time.Millisecond
+	reloaded := *cfg
+	api.setConfig(&reloaded)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = api.checkRemoteStorage(timeoutCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = api.checkRemoteStorage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), connects.Load())
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// restartRequiredAPISettings - api settings which apply only when HTTP or gRPC server starts, hot reload keeps previous values until POST /restart
+var restartRequiredAPISettings = map[string]bool{
+	"listen":               true,
+	"grpc_listen":          true,
+	"enable_metrics":       true,
+	"enable_pprof":         true,
+	"enable_swagger_ui":    true,
+	"secure":               true,
+	"certificate_file":     true,
+	"private_key_file":     true,
+	"ca_cert_file":         true,
+	"ca_key_file":          true,
+	"client_cert_auth":     true,
+	"jobs_history_file":    true,
+	"oidc_issuer":          true,
+	"oidc_audience":        true,
+	"oidc_jwks_url":        true,
+	"oidc_required_claims": true,
+	"oidc_roles_claim":     true,
+	"oidc_operator_role":   true,
+	"oidc_read_only_role":  true,
+	"oidc_admin_role":      true,
+}
+
+// configReloadStatus - response of POST /backup/actions/reload-config
+type configReloadStatus struct {
+	Status          string   `json:"status"`
+	Operation       string   `json:"operation"`
+	Changed         []string `json:"changed"`
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// GetConfig - config for new operations, could be swapped by HotReloadConfig at any time, so read it once per operation
+func (api *APIServer) GetConfig() *config.Config {
+	api.configMutex.RLock()
+	defer api.configMutex.RUnlock()
+	return api.config
+}
+
+func (api *APIServer) setConfig(cfg *config.Config) {
+	api.configMutex.Lock()
+	defer api.configMutex.Unlock()
+	api.config = cfg
+}
+
+func (api *APIServer) getClickHouse() *clickhouse.ClickHouse {
+	api.configMutex.RLock()
+	defer api.configMutex.RUnlock()
+	return api.ch
+}
+
+// HotReloadConfig - re-read config file and swap config used by new operations,
+// unlike Restart, operations in progress, queue and jobs history stay untouched, invalid config keeps previous one
+func (api *APIServer) HotReloadConfig(source string) (configReloadStatus, error) {
+	api.reloadMutex.Lock()
+	defer api.reloadMutex.Unlock()
+	log := apexLog.WithFields(apexLog.Fields{"logger": "server.HotReloadConfig", "source": source})
+	result := configReloadStatus{Status: "success", Operation: "reload-config"}
+	oldCfg := api.GetConfig()
+	cfg, err := api.ReloadConfig(nil, "reload-config")
+	if err != nil {
+		return result, err
+	}
+	result.Changed = changedConfigSections(oldCfg, cfg)
+	if api.serverConfig != nil {
+		result.RestartRequired = changedRestartRequiredSettings(api.serverConfig.API, cfg.API)
+	}
+	if ch := api.getClickHouse(); ch != nil && !reflect.DeepEqual(*ch.Config, cfg.ClickHouse) {
+		api.reconnectClickHouse(cfg, log)
+	}
+	log.Infof("config %s reloaded, changed sections: [%s]", api.configPath, strings.Join(result.Changed, ", "))
+	if len(result.RestartRequired) > 0 {
+		log.Warnf("api settings [%s] will apply after POST /restart", strings.Join(result.RestartRequired, ", "))
+	}
+	return result, nil
+}
+
+// reconnectClickHouse - connection for /readyz shall use rotated credentials, keep previous connection when new one fails
+func (api *APIServer) reconnectClickHouse(cfg *config.Config, log *apexLog.Entry) {
+	ch := &clickhouse.ClickHouse{
+		Config: &cfg.ClickHouse,
+		Log:    apexLog.WithField("logger", "clickhouse"),
+	}
+	if err := ch.Connect(); err != nil {
+		log.Warnf("can't connect to clickhouse with reloaded config, keep previous connection: %v", err)
+		return
+	}
+	api.configMutex.Lock()
+	oldCh := api.ch
+	api.ch = ch
+	api.configMutex.Unlock()
+	oldCh.Close()
+}
+
+// changedConfigSections - yaml names of top level config sections which differ
+func changedConfigSections(oldCfg, newCfg *config.Config) []string {
+	changed := make([]string, 0)
+	oldValue, newValue := reflect.ValueOf(*oldCfg), reflect.ValueOf(*newCfg)
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !oldValue.Type().Field(i).IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, yamlName(oldValue.Type().Field(i)))
+		}
+	}
+	return changed
+}
+
+// changedRestartRequiredSettings - yaml names of restartRequiredAPISettings which differ from settings used during HTTP server start
+func changedRestartRequiredSettings(oldAPI, newAPI config.APIConfig) []string {
+	var changed []string
+	oldValue, newValue := reflect.ValueOf(oldAPI), reflect.ValueOf(newAPI)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := yamlName(oldValue.Type().Field(i))
+		if restartRequiredAPISettings[name] && !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+func yamlName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+// httpReloadConfigHandler - hot reload config without restart HTTP server
+func (api *APIServer) httpReloadConfigHandler(w http.ResponseWriter, _ *http.Request) {
+	result, err := api.HotReloadConfig("api")
+	if err != nil {
+		api.writeError(w, http.StatusInternalServerError, "reload-config", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, result)
+}
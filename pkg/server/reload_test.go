@@ -0,0 +1,23 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedConfigSections(t *testing.T) {
+	oldCfg := config.DefaultConfig()
+	newCfg := config.DefaultConfig()
+	assert.Empty(t, changedConfigSections(oldCfg, newCfg))
+	assert.Empty(t, changedRestartRequiredSettings(oldCfg.API, newCfg.API))
+
+	newCfg.General.BackupsToKeepRemote = 10
+	newCfg.S3.SecretKey = "rotated"
+	newCfg.API.Password = "rotated"
+	newCfg.API.ListenAddr = "localhost:7172"
+	newCfg.API.OIDCIssuer = "https://issuer"
+	assert.Equal(t, []string{"general", "s3", "api"}, changedConfigSections(oldCfg, newCfg))
+	assert.Equal(t, []string{"listen", "oidc_issuer"}, changedRestartRequiredSettings(oldCfg.API, newCfg.API))
+}
@@ -19,6 +19,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -29,6 +31,8 @@ import (
 	"github.com/Altinity/clickhouse-backup/v2/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/server/metrics"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/systemd"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/utils"
 
 	apexLog "github.com/apex/log"
@@ -36,23 +40,42 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli"
+	"google.golang.org/grpc"
 )
 
 type APIServer struct {
-	cliApp                  *cli.App
-	cliCtx                  *cli.Context
-	configPath              string
-	config                  *config.Config
-	server                  *http.Server
-	restart                 chan struct{}
+	cliApp     *cli.App
+	cliCtx     *cli.Context
+	configPath string
+	config     *config.Config
+	server     *http.Server
+	grpcServer *grpc.Server
+	oidc       *oidcVerifier
+	restart    chan struct{}
+	// aliveCheck - serve loop in Run closes received channel, look isAlive
+	aliveCheck              chan chan struct{}
 	metrics                 *metrics.APIMetrics
 	log                     *apexLog.Entry
 	routes                  []string
+	openAPISpec             []byte
 	clickhouseBackupVersion string
+	// ch - connection opened during startup, used by /readyz
+	ch       *clickhouse.ClickHouse
+	started  atomic.Bool
+	draining atomic.Bool
+	// configMutex - protects config and ch swapped by HotReloadConfig, look GetConfig
+	configMutex sync.RWMutex
+	reloadMutex sync.Mutex
+	// serverConfig - config used during last HTTP server start, look restartRequiredAPISettings
+	serverConfig *config.Config
+	// readyz - remote storage connection for /readyz, look getReadyzDestination
+	readyz      *readyzDestination
+	readyzMutex sync.Mutex
 }
 
 var (
-	ErrAPILocked = errors.New("another operation is currently running")
+	ErrAPILocked   = errors.New("another operation is currently running")
+	ErrAPIDraining = errors.New("API server is draining before shutdown, new operations are not allowed")
 )
 
 // Run - expose CLI commands as REST API
@@ -60,6 +83,7 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 	log := apexLog.WithField("logger", "server.Run")
 	var (
 		cfg *config.Config
+		ch  *clickhouse.ClickHouse
 		err error
 	)
 	log.Debug("Wait for ClickHouse")
@@ -70,7 +94,7 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 			time.Sleep(5 * time.Second)
 			continue
 		}
-		ch := clickhouse.ClickHouse{
+		ch = &clickhouse.ClickHouse{
 			Config: &cfg.ClickHouse,
 			Log:    apexLog.WithField("logger", "clickhouse"),
 		}
@@ -79,7 +103,6 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 			time.Sleep(5 * time.Second)
 			continue
 		}
-		_ = ch.GetConn().Close()
 		break
 	}
 	api := APIServer{
@@ -88,26 +111,69 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 		configPath:              configPath,
 		config:                  cfg,
 		restart:                 make(chan struct{}),
+		aliveCheck:              make(chan chan struct{}),
 		clickhouseBackupVersion: clickhouseBackupVersion,
 		metrics:                 metrics.NewAPIMetrics(),
 		log:                     apexLog.WithField("logger", "server"),
+		ch:                      ch,
 	}
 	if cfg.API.CreateIntegrationTables {
 		if err := api.CreateIntegrationTables(); err != nil {
 			log.Error(err.Error())
 		}
 	}
+	if err := status.Current.LoadHistory(api.GetConfig().API.JobsHistoryFile); err != nil {
+		log.Warnf("can't load operations history: %v", err)
+	}
+	if cfg.API.MaxConcurrentOperations > 0 && cfg.General.LockFile != "" {
+		log.Warnf("api->max_concurrent_operations=%d, but general->lock_file=%s allows only one operation at the same time", cfg.API.MaxConcurrentOperations, cfg.General.LockFile)
+	}
 	api.metrics.RegisterMetrics()
+	status.Current.SetWarningHook(func(kind string) {
+		metrics.Warnings.WithLabelValues(kind).Inc()
+	})
+	backup.SetRetentionPolicyDeletedHook(func(policyName string) {
+		metrics.RetentionPolicyDeletedBackups.WithLabelValues(policyName).Inc()
+	})
+	api.metrics.RegisterCounterFunc("stalled_uploads", "Counter of upload streams which aborted and retried after stalled_stream_timeout without progress", func() float64 {
+		return float64(storage.StalledUploads.Load())
+	})
+	if storage.IsFaultInjectionEnabled(cfg.General) {
+		api.metrics.RegisterCounterFunc("injected_faults", "Counter of storage errors, slow and truncated streams injected by general->fault_injection_* options", func() float64 {
+			return float64(storage.InjectedFaults.Load())
+		})
+	}
 
-	log.Infof("Starting API server on %s", api.config.API.ListenAddr)
+	log.Infof("Starting API server on %s", api.GetConfig().API.ListenAddr)
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
 	sighup := make(chan os.Signal, 1)
-	signal.Notify(sighup, os.Interrupt, syscall.SIGHUP)
+	signal.Notify(sighup, syscall.SIGHUP)
 	if err := api.Restart(); err != nil {
 		return err
 	}
-	if api.config.API.CompleteResumableAfterRestart {
+	if api.GetConfig().API.GRPCListenAddr != "" {
+		go func() {
+			if err := api.RunGRPC(); err != nil {
+				log.Errorf("gRPC API server return error: %v", err)
+			}
+		}()
+	}
+	api.notifySystemd(systemd.Ready)
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	isAlive := api.isAlive
+	if cliCtx.Bool("watch") || cliCtx.Bool("continuous") {
+		watchAlive := systemd.ProgressAlive(backup.WatchdogProgressTimeout(api.GetConfig()))
+		isAlive = func(ctx context.Context) error {
+			if err := api.isAlive(ctx); err != nil {
+				return err
+			}
+			return watchAlive(ctx)
+		}
+	}
+	go systemd.RunWatchdog(watchdogCtx, isAlive)
+	if api.GetConfig().API.CompleteResumableAfterRestart {
 		go func() {
 			if err := api.ResumeOperationsAfterRestart(); err != nil {
 				log.Errorf("ResumeOperationsAfterRestart return error: %v", err)
@@ -124,47 +190,142 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 	if cliCtx.Bool("watch") {
 		go api.RunWatch(cliCtx)
 	}
+	if cliCtx.Bool("continuous") {
+		go api.RunContinuous(cliCtx)
+	}
+	api.started.Store(true)
 
 	for {
 		select {
+		case alive := <-api.aliveCheck:
+			close(alive)
 		case <-api.restart:
-			if err := api.Restart(); err != nil {
+			api.notifySystemd(systemd.Reloading)
+			err := api.Restart()
+			api.notifySystemd(systemd.Ready)
+			if err != nil {
 				log.Errorf("Failed to restarting API server: %v", err)
 				continue
 			}
 			log.Infof("Reloaded by HTTP")
 		case <-sighup:
-			if err := api.Restart(); err != nil {
-				log.Errorf("Failed to restarting API server: %v", err)
+			api.notifySystemd(systemd.Reloading)
+			_, err := api.HotReloadConfig("SIGHUP")
+			api.notifySystemd(systemd.Ready)
+			if err != nil {
+				log.Errorf("Failed to reload config, previous config is kept: %v", err)
 				continue
 			}
 			log.Info("Reloaded by SIGHUP")
 		case <-sigterm:
 			log.Info("Stopping API server")
+			api.notifySystemd(systemd.Stopping)
+			api.drain(sigterm)
 			return api.Stop()
 		}
 	}
 }
 
+// notifySystemd - failed notification shall not stop API server
+func (api *APIServer) notifySystemd(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		api.log.Warnf("can't notify systemd %s: %v", state, err)
+	}
+}
+
+// isAlive - systemd watchdog liveness check, serve loop in Run shall answer, HTTP request to itself would fail with client certificates or API authentication
+func (api *APIServer) isAlive(ctx context.Context) error {
+	alive := make(chan struct{})
+	select {
+	case api.aliveCheck <- alive:
+	case <-ctx.Done():
+		return fmt.Errorf("serve loop is not responding: %v", ctx.Err())
+	}
+	<-alive
+	return nil
+}
+
 func (api *APIServer) GetMetrics() *metrics.APIMetrics {
 	return api.metrics
 }
 
 func (api *APIServer) RunWatch(cliCtx *cli.Context) {
 	api.log.Info("Starting API Server in watch mode")
-	b := backup.NewBackuper(api.config)
+	b := backup.NewBackuper(api.GetConfig())
 	commandId, _ := status.Current.Start("watch")
 	err := b.Watch(
 		cliCtx.String("watch-interval"), cliCtx.String("full-interval"), cliCtx.String("watch-backup-name-template"),
-		"*.*", nil, false, false, false, false,
+		cliCtx.String("retention-policy"), "*.*", nil, false, false, false, false,
 		api.clickhouseBackupVersion, commandId, api.GetMetrics(), cliCtx,
 	)
 	status.Current.Stop(commandId, err)
 }
 
-// Stop cancel all running commands, @todo think about graceful period
+// RunContinuous - `server --continuous`, upload new parts as incremental backups in background
+func (api *APIServer) RunContinuous(cliCtx *cli.Context) {
+	api.log.Info("Starting API Server in continuous mode")
+	b := backup.NewBackuper(api.GetConfig())
+	commandId, _ := status.Current.Start("continuous")
+	err := b.Continuous(cliCtx.String("continuous-interval"), "*.*", false, api.clickhouseBackupVersion, commandId, api.GetMetrics(), cliCtx)
+	status.Current.Stop(commandId, err)
+}
+
+// startCommand - register asynchronous command, with api->queue_size > 0 command waits in queue when another command in progress, otherwise return ErrAPILocked
+// command go-routine shall call status.Current.WaitQueued before execution
+func (api *APIServer) startCommand(fullCommand string) (int, error) {
+	if api.draining.Load() {
+		return -1, ErrAPIDraining
+	}
+	if api.GetConfig().API.QueueSize > 0 {
+		return status.Current.Enqueue(fullCommand, api.GetConfig().API.QueueSize)
+	}
+	commandId, _, err := api.tryStart(fullCommand)
+	return commandId, err
+}
+
+// tryStart - start command when it is not locked, otherwise return ErrAPILocked
+// with api->max_concurrent_operations > 0 only conflicted commands lock each other, look status.AsyncStatus.isLocked, otherwise any command in progress locks when allow_parallel: false
+func (api *APIServer) tryStart(fullCommand string) (int, context.Context, error) {
+	if api.draining.Load() {
+		return -1, nil, ErrAPIDraining
+	}
+	if api.GetConfig().API.MaxConcurrentOperations > 0 {
+		commandId, ctx, err := status.Current.TryStart(fullCommand, api.GetConfig().API.MaxConcurrentOperations)
+		if err != nil {
+			return -1, nil, ErrAPILocked
+		}
+		return commandId, ctx, nil
+	}
+	if !api.GetConfig().API.AllowParallel && status.Current.InProgress() {
+		return -1, nil, ErrAPILocked
+	}
+	commandId, ctx := status.Current.Start(fullCommand)
+	return commandId, ctx, nil
+}
+
+// isLocked - check before full command known, empty fullCommand is locked only by exclusive commands or max_concurrent_operations, tryStart shall check again
+func (api *APIServer) isLocked(fullCommand string) bool {
+	if api.GetConfig().API.MaxConcurrentOperations > 0 {
+		return status.Current.IsLocked(fullCommand, api.GetConfig().API.MaxConcurrentOperations)
+	}
+	return !api.GetConfig().API.AllowParallel && status.Current.InProgress()
+}
+
+// Stop cancel all running commands, look drain for graceful period
 func (api *APIServer) Stop() error {
 	status.Current.CancelAll("canceled during server stop")
+	api.readyzMutex.Lock()
+	if api.readyz != nil {
+		go api.closeReadyzDestination(api.readyz)
+		api.readyz = nil
+	}
+	api.readyzMutex.Unlock()
+	if ch := api.getClickHouse(); ch != nil {
+		ch.Close()
+	}
+	if api.grpcServer != nil {
+		api.grpcServer.Stop()
+	}
 	return api.server.Close()
 }
 
@@ -175,14 +336,15 @@ func (api *APIServer) Restart() error {
 		return err
 	}
 	status.Current.CancelAll("canceled via API /restart")
+	api.serverConfig = api.GetConfig()
 	if api.server != nil {
 		_ = api.server.Close()
 	}
 	server := api.registerHTTPHandlers()
 	api.server = server
-	if api.config.API.Secure {
+	if api.GetConfig().API.Secure {
 		go func() {
-			err = api.server.ListenAndServeTLS(api.config.API.CertificateFile, api.config.API.PrivateKeyFile)
+			err = api.server.ListenAndServeTLS(api.GetConfig().API.CertificateFile, api.GetConfig().API.PrivateKeyFile)
 			if err != nil {
 				if errors.Is(err, http.ErrServerClosed) {
 					log.Warnf("ListenAndServeTLS get signal: %s", err.Error())
@@ -209,8 +371,13 @@ func (api *APIServer) Restart() error {
 // registerHTTPHandlers - resister API routes
 func (api *APIServer) registerHTTPHandlers() *http.Server {
 	log := apexLog.WithField("logger", "registerHTTPHandlers")
+	api.oidc = nil
+	if api.GetConfig().API.OIDCIssuer != "" {
+		api.oidc = newOIDCVerifier(api.GetConfig().API)
+	}
 	r := mux.NewRouter()
-	r.Use(api.basicAuthMiddleware)
+	r.Use(api.authMiddleware)
+	r.Use(api.drainMiddleware)
 	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		api.writeError(w, http.StatusNotFound, r.URL.Path, fmt.Errorf("%s %s 404 Not Found", r.Method, r.URL))
 	})
@@ -223,6 +390,7 @@ func (api *APIServer) registerHTTPHandlers() *http.Server {
 	r.HandleFunc("/restart", api.httpRestartHandler).Methods("POST", "GET")
 	r.HandleFunc("/backup/kill", api.httpKillHandler).Methods("POST", "GET")
 	r.HandleFunc("/backup/watch", api.httpWatchHandler).Methods("POST", "GET")
+	r.HandleFunc("/backup/watch/status", api.httpWatchStatusHandler).Methods("GET")
 	r.HandleFunc("/backup/tables", api.httpTablesHandler).Methods("GET")
 	r.HandleFunc("/backup/tables/all", api.httpTablesHandler).Methods("GET")
 	r.HandleFunc("/backup/list", api.httpListHandler).Methods("GET", "HEAD")
@@ -238,6 +406,12 @@ func (api *APIServer) registerHTTPHandlers() *http.Server {
 
 	r.HandleFunc("/backup/actions", api.actionsLog).Methods("GET", "HEAD")
 	r.HandleFunc("/backup/actions", api.actions).Methods("POST")
+	r.HandleFunc("/backup/actions/reload-config", api.httpReloadConfigHandler).Methods("POST")
+	r.HandleFunc("/backup/actions/{id}/cancel", api.httpActionCancelHandler).Methods("POST")
+	r.HandleFunc("/openapi.json", api.httpOpenAPIHandler).Methods("GET")
+	if api.GetConfig().API.EnableSwaggerUI {
+		r.HandleFunc("/swagger/", api.httpSwaggerUIHandler).Methods("GET")
+	}
 
 	var routes []string
 	if err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
@@ -253,15 +427,22 @@ func (api *APIServer) registerHTTPHandlers() *http.Server {
 	}
 
 	api.routes = routes
-	api.registerMetricsHandlers(r, api.config.API.EnableMetrics, api.config.API.EnablePprof)
+	api.registerMetricsHandlers(r, api.GetConfig().API.EnableMetrics, api.GetConfig().API.EnablePprof)
+	api.registerProbeHandlers(r)
+	openAPISpec, err := buildOpenAPISpec(r, api.clickhouseBackupVersion)
+	if err != nil {
+		log.Errorf("buildOpenAPISpec return error: %v", err)
+		return nil
+	}
+	api.openAPISpec = openAPISpec
 	srv := &http.Server{
-		Addr:    api.config.API.ListenAddr,
+		Addr:    api.GetConfig().API.ListenAddr,
 		Handler: r,
 	}
-	if api.config.API.CACertFile != "" {
-		caCert, err := os.ReadFile(api.config.API.CACertFile)
+	if api.GetConfig().API.CACertFile != "" {
+		caCert, err := os.ReadFile(api.GetConfig().API.CACertFile)
 		if err != nil {
-			api.log.Fatalf("api initialization error %s: %v", api.config.API.CAKeyFile, err)
+			api.log.Fatalf("api initialization error %s: %v", api.GetConfig().API.CAKeyFile, err)
 		}
 		caCertPool := x509.NewCertPool()
 		caCertPool.AppendCertsFromPEM(caCert)
@@ -269,42 +450,19 @@ func (api *APIServer) registerHTTPHandlers() *http.Server {
 			ClientCAs:  caCertPool,
 			ClientAuth: tls.RequireAndVerifyClientCert,
 		}
+		// allow clients without certificate use bearer token or basic auth
+		if api.GetConfig().API.ClientCertAuth == "verify_if_given" {
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
 	}
 
 	return srv
 }
 
-func (api *APIServer) basicAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/metrics" {
-			api.log.Infof("API call %s %s", r.Method, r.URL.Path)
-		} else {
-			api.log.Debugf("API call %s %s", r.Method, r.URL.Path)
-		}
-		user, pass, _ := r.BasicAuth()
-		query := r.URL.Query()
-		if u, exist := query["user"]; exist {
-			user = u[0]
-		}
-		if p, exist := query["pass"]; exist {
-			pass = p[0]
-		}
-		if (user != api.config.API.Username) || (pass != api.config.API.Password) {
-			api.log.Warnf("%s %s Authorization failed %s:%s", r.Method, r.URL, user, pass)
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Provide username and password\"")
-			w.WriteHeader(http.StatusUnauthorized)
-			if _, err := w.Write([]byte("401 Unauthorized\n")); err != nil {
-				api.log.Errorf("RequestWriter.Write return error: %v", err)
-			}
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 type actionsResultsRow struct {
-	Status    string `json:"status"`
-	Operation string `json:"operation"`
+	Status      string `json:"status"`
+	Operation   string `json:"operation"`
+	OperationId int    `json:"operation_id"`
 }
 
 // CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String) ENGINE=URL('http://127.0.0.1:7171/backup/actions?user=user&pass=pass', JSONEachRow)
@@ -331,6 +489,7 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 			api.writeError(w, http.StatusBadRequest, string(line), err)
 			return
 		}
+		row.User = getAPIUser(r)
 		api.log.Infof("/backup/actions call: %s", row.Command)
 		args, err := shlex.Split(row.Command)
 		if err != nil {
@@ -379,11 +538,12 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *APIServer) actionsDeleteHandler(row status.ActionRow, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		return actionsResults, ErrAPILocked
+	commandId, _, err := api.tryStart(row.Command)
+	if err != nil {
+		return actionsResults, err
 	}
-	commandId, _ := status.Current.Start(row.Command)
-	err := api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
+	status.Current.SetUser(commandId, row.User)
+	err = api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
 	status.Current.Stop(commandId, err)
 	if err != nil {
 		return actionsResults, err
@@ -395,19 +555,25 @@ func (api *APIServer) actionsDeleteHandler(row status.ActionRow, args []string,
 		}
 	}()
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "success",
-		Operation: row.Command,
+		Status:      "success",
+		Operation:   row.Command,
+		OperationId: commandId,
 	})
 	return actionsResults, nil
 }
 
 func (api *APIServer) actionsAsyncCommandsHandler(command string, args []string, row status.ActionRow, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		return actionsResults, ErrAPILocked
-	}
 	// to avoid race condition between GET /backup/actions and POST /backup/actions
-	commandId, _ := status.Current.Start(row.Command)
+	commandId, err := api.startCommand(row.Command)
+	if err != nil {
+		return actionsResults, err
+	}
+	status.Current.SetUser(commandId, row.User)
 	go func() {
+		if err := status.Current.WaitQueued(commandId, api.GetConfig().API.AllowParallel, api.GetConfig().API.MaxConcurrentOperations); err != nil {
+			api.log.Warnf("API /backup/actions %s: %v", row.Command, err)
+			return
+		}
 		err, _ := api.metrics.ExecuteWithMetrics(command, 0, func() error {
 			return api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
 		})
@@ -423,8 +589,9 @@ func (api *APIServer) actionsAsyncCommandsHandler(command string, args []string,
 		}()
 	}()
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "acknowledged",
-		Operation: row.Command,
+		Status:      "acknowledged",
+		Operation:   row.Command,
+		OperationId: commandId,
 	})
 	return actionsResults, nil
 }
@@ -441,18 +608,20 @@ func (api *APIServer) actionsKillHandler(row status.ActionRow, args []string, ac
 		return actionsResults, err
 	}
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "success",
-		Operation: row.Command,
+		Status:      "success",
+		Operation:   row.Command,
+		OperationId: commandId,
 	})
 	return actionsResults, nil
 }
 
 func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row status.ActionRow, command string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Warn(ErrAPILocked.Error())
-		return actionsResults, ErrAPILocked
+	commandId, _, err := api.tryStart(command)
+	if err != nil {
+		api.log.Warn(err.Error())
+		return actionsResults, err
 	}
-	commandId, _ := status.Current.Start(command)
+	status.Current.SetUser(commandId, row.User)
 	cfg, err := api.ReloadConfig(w, "clean_remote_broken")
 	if err != nil {
 		status.Current.Stop(commandId, err)
@@ -473,14 +642,15 @@ func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row
 	}()
 	status.Current.Stop(commandId, nil)
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "success",
-		Operation: row.Command,
+		Status:      "success",
+		Operation:   row.Command,
+		OperationId: commandId,
 	})
 	return actionsResults, nil
 }
 
 func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.ActionRow, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if (!api.config.API.AllowParallel && status.Current.InProgress()) || status.Current.CheckCommandInProgress(row.Command) {
+	if api.isLocked("") || status.Current.CheckCommandInProgress(row.Command) {
 		api.log.Info(ErrAPILocked.Error())
 		return actionsResults, ErrAPILocked
 	}
@@ -497,6 +667,7 @@ func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.Acti
 	watchInterval := ""
 	fullInterval := ""
 	watchBackupNameTemplate := ""
+	retentionPolicy := ""
 	fullCommand := "watch"
 
 	simpleParseArg := func(i int, args []string, paramName string) (bool, string) {
@@ -528,6 +699,10 @@ func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.Acti
 		if matchParam, watchBackupNameTemplate = simpleParseArg(i, args, "--watch-backup-name-template"); matchParam {
 			fullCommand = fmt.Sprintf("%s --watch-backup-name-template=\"%s\"", fullCommand, watchBackupNameTemplate)
 		}
+		if matchParam, policy := simpleParseArg(i, args, "--retention-policy"); matchParam {
+			retentionPolicy = policy
+			fullCommand = fmt.Sprintf("%s --retention-policy=\"%s\"", fullCommand, retentionPolicy)
+		}
 		if matchParam, tablePattern = simpleParseArg(i, args, "--tables"); matchParam {
 			fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
 		}
@@ -554,9 +729,10 @@ func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.Acti
 	}
 
 	commandId, _ := status.Current.Start(fullCommand)
+	status.Current.SetUser(commandId, row.User)
 	go func() {
 		b := backup.NewBackuper(cfg)
-		err := b.Watch(watchInterval, fullInterval, watchBackupNameTemplate, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, skipCheckPartsColumns, api.clickhouseBackupVersion, commandId, api.GetMetrics(), api.cliCtx)
+		err := b.Watch(watchInterval, fullInterval, watchBackupNameTemplate, retentionPolicy, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, skipCheckPartsColumns, api.clickhouseBackupVersion, commandId, api.GetMetrics(), api.cliCtx)
 		defer status.Current.Stop(commandId, err)
 		if err != nil {
 			api.log.Errorf("Watch error: %v", err)
@@ -564,8 +740,9 @@ func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.Acti
 	}()
 
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "acknowledged",
-		Operation: row.Command,
+		Status:      "acknowledged",
+		Operation:   row.Command,
+		OperationId: commandId,
 	})
 	return actionsResults, nil
 }
@@ -695,44 +872,48 @@ func (api *APIServer) getTablesWithSkip(tables []clickhouse.Table) []clickhouse.
 // httpListHandler - display list of all backups stored locally and remotely, could run in parallel independent of allow_parallel=true
 // CREATE TABLE system.backup_list (name String, created DateTime, size Int64, location String, desc String) ENGINE=URL('http://127.0.0.1:7171/backup/list?user=user&pass=pass', JSONEachRow)
 // SELECT * FROM system.backup_list
+type backupJSON struct {
+	Name           string `json:"name"`
+	Created        string `json:"created"`
+	Size           uint64 `json:"size,omitempty"`
+	Location       string `json:"location"`
+	RequiredBackup string `json:"required"`
+	Desc           string `json:"desc"`
+}
+
 func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodHead {
 		api.sendJSONEachRow(w, http.StatusOK, "")
 		return
 	}
-
-	type backupJSON struct {
-		Name           string `json:"name"`
-		Created        string `json:"created"`
-		Size           uint64 `json:"size,omitempty"`
-		Location       string `json:"location"`
-		RequiredBackup string `json:"required"`
-		Desc           string `json:"desc"`
-	}
-	backupsJSON := make([]backupJSON, 0)
 	cfg, err := api.ReloadConfig(w, "list")
 	if err != nil {
 		return
 	}
 	vars := mux.Vars(r)
-	where, wherePresent := vars["where"]
+	where := vars["where"]
 	fullCommand := "list"
-	if wherePresent {
+	if where != "" {
 		fullCommand += " " + where
 	}
 	commandId, ctx := status.Current.Start(fullCommand)
-	defer status.Current.Stop(commandId, err)
+	backupsJSON, err := api.listBackups(ctx, cfg, where)
+	status.Current.Stop(commandId, err)
 	if err != nil {
 		api.writeError(w, http.StatusInternalServerError, "list", err)
 		return
 	}
+	api.sendJSONEachRow(w, http.StatusOK, backupsJSON)
+}
+
+// listBackups - empty where means local and remote backups, used by REST and gRPC API
+func (api *APIServer) listBackups(ctx context.Context, cfg *config.Config, where string) ([]backupJSON, error) {
+	backupsJSON := make([]backupJSON, 0)
 	b := backup.NewBackuper(cfg)
-	if where == "local" || !wherePresent {
-		var localBackups []backup.LocalBackup
-		localBackups, _, err = b.GetLocalBackups(ctx, nil)
+	if where == "local" || where == "" {
+		localBackups, _, err := b.GetLocalBackups(ctx, nil)
 		if err != nil && !os.IsNotExist(err) {
-			api.writeError(w, http.StatusInternalServerError, "list", err)
-			return
+			return nil, err
 		}
 		for _, item := range localBackups {
 			description := item.DataFormat
@@ -756,12 +937,11 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		api.metrics.NumberBackupsLocal.Set(float64(len(localBackups)))
 	}
-	if cfg.General.RemoteStorage != "none" && (where == "remote" || !wherePresent) {
+	if cfg.General.RemoteStorage != "none" && (where == "remote" || where == "") {
 		brokenBackups := 0
 		remoteBackups, err := b.GetRemoteBackups(ctx, true)
 		if err != nil {
-			api.writeError(w, http.StatusInternalServerError, "list", err)
-			return
+			return nil, err
 		}
 		for i, b := range remoteBackups {
 			description := b.DataFormat
@@ -791,12 +971,12 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 		api.metrics.NumberBackupsRemoteBroken.Set(float64(brokenBackups))
 		api.metrics.NumberBackupsRemote.Set(float64(len(remoteBackups)))
 	}
-	api.sendJSONEachRow(w, http.StatusOK, backupsJSON)
+	return backupsJSON, nil
 }
 
 // httpCreateHandler - create a backup
 func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
+	if api.GetConfig().API.QueueSize <= 0 && api.isLocked("") {
 		api.log.Info(ErrAPILocked.Error())
 		api.writeError(w, http.StatusLocked, "create", ErrAPILocked)
 		return
@@ -849,6 +1029,23 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		checkPartsColumns, _ = strconv.ParseBool(partsColumns[0])
 		fullCommand = fmt.Sprintf("%s --check-parts-columns=%v", fullCommand, checkPartsColumns)
 	}
+	ifNotExists := false
+	if ifNotExistsParam, exist := query["if_not_exists"]; exist {
+		ifNotExists, _ = strconv.ParseBool(ifNotExistsParam[0])
+		if ifNotExists {
+			fullCommand = fmt.Sprintf("%s --if-not-exists", fullCommand)
+		}
+	}
+	includeDetached := false
+	if _, exist := query["include_detached"]; exist {
+		includeDetached = true
+		fullCommand += " --include-detached"
+	}
+	consistentSnapshot := false
+	if _, exist := query["consistent_snapshot"]; exist {
+		consistentSnapshot = true
+		fullCommand += " --consistent-snapshot"
+	}
 
 	if name, exist := query["name"]; exist {
 		backupName = utils.CleanBackupNameRE.ReplaceAllString(name[0], "")
@@ -862,10 +1059,20 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	commandId, _ := status.Current.Start(fullCommand)
+	commandId, err := api.startCommand(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "create", err)
+		return
+	}
+	status.Current.SetUser(commandId, getAPIUser(r))
 	go func() {
+		if err := status.Current.WaitQueued(commandId, api.GetConfig().API.AllowParallel, api.GetConfig().API.MaxConcurrentOperations); err != nil {
+			api.log.Warnf("API /backup/create: %v", err)
+			return
+		}
 		err, _ := api.metrics.ExecuteWithMetrics("create", 0, func() error {
-			b := backup.NewBackuper(cfg)
+			b := backup.NewBackuper(cfg, backup.WithIfNotExists(ifNotExists), backup.WithIncludeDetached(includeDetached), backup.WithConsistentSnapshot(consistentSnapshot))
 			return b.CreateBackup(backupName, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly, createRBAC, false, createConfigs, false, checkPartsColumns, api.clickhouseBackupVersion, commandId)
 		})
 		if err != nil {
@@ -884,19 +1091,21 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		api.successCallback(context.Background(), callback)
 	}()
 	api.sendJSONEachRow(w, http.StatusCreated, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "create",
-		BackupName: backupName,
+		Status:      "acknowledged",
+		Operation:   "create",
+		OperationId: commandId,
+		BackupName:  backupName,
 	})
 }
 
 // httpWatchHandler - run watch command go routine, can't run the same watch command twice
 func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
+	if api.isLocked("") {
 		api.log.Info(ErrAPILocked.Error())
 		api.writeError(w, http.StatusLocked, "watch", ErrAPILocked)
 		return
@@ -914,6 +1123,7 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 	watchInterval := ""
 	fullInterval := ""
 	watchBackupNameTemplate := ""
+	retentionPolicy := ""
 	fullCommand := "watch"
 	query := r.URL.Query()
 	if interval, exist := query["watch_interval"]; exist {
@@ -928,6 +1138,10 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 		watchBackupNameTemplate = template[0]
 		fullCommand = fmt.Sprintf("%s --watch-backup-name-template=\"%s\"", fullCommand, watchBackupNameTemplate)
 	}
+	if policy, exist := query["retention_policy"]; exist {
+		retentionPolicy = policy[0]
+		fullCommand = fmt.Sprintf("%s --retention-policy=\"%s\"", fullCommand, retentionPolicy)
+	}
 	if tp, exist := query["table"]; exist {
 		tablePattern = tp[0]
 		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
@@ -968,9 +1182,10 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	commandId, _ := status.Current.Start(fullCommand)
+	status.Current.SetUser(commandId, getAPIUser(r))
 	go func() {
 		b := backup.NewBackuper(cfg)
-		err := b.Watch(watchInterval, fullInterval, watchBackupNameTemplate, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, skipCheckPartsColumns, api.clickhouseBackupVersion, commandId, api.GetMetrics(), api.cliCtx)
+		err := b.Watch(watchInterval, fullInterval, watchBackupNameTemplate, retentionPolicy, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, skipCheckPartsColumns, api.clickhouseBackupVersion, commandId, api.GetMetrics(), api.cliCtx)
 		defer status.Current.Stop(commandId, err)
 		if err != nil {
 			api.log.Errorf("Watch error: %v", err)
@@ -990,10 +1205,14 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 
 // httpCleanHandler - clean ./shadow directory
 func (api *APIServer) httpCleanHandler(w http.ResponseWriter, _ *http.Request) {
-	var err error
 	fullCommand := "clean"
-	commandId, ctx := status.Current.Start(fullCommand)
-	b := backup.NewBackuper(api.config)
+	commandId, ctx, err := api.tryStart(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "clean", err)
+		return
+	}
+	b := backup.NewBackuper(api.GetConfig())
 	err = b.Clean(ctx)
 	defer status.Current.Stop(commandId, err)
 	if err != nil {
@@ -1011,12 +1230,19 @@ func (api *APIServer) httpCleanHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 // httpCleanRemoteBrokenHandler - delete all remote backups with `broken` in description
-func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, _ *http.Request) {
+func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, r *http.Request) {
+	commandId, _, err := api.tryStart("clean_remote_broken")
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "clean_remote_broken", err)
+		return
+	}
+	status.Current.SetUser(commandId, getAPIUser(r))
 	cfg, err := api.ReloadConfig(w, "clean_remote_broken")
 	if err != nil {
+		status.Current.Stop(commandId, err)
 		return
 	}
-	commandId, _ := status.Current.Start("clean_remote_broken")
 	defer status.Current.Stop(commandId, err)
 
 	b := backup.NewBackuper(cfg)
@@ -1043,7 +1269,7 @@ func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, _ *htt
 
 // httpUploadHandler - upload a backup to remote storage
 func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
+	if api.GetConfig().API.QueueSize <= 0 && api.isLocked("") {
 		api.log.Info(ErrAPILocked.Error())
 		api.writeError(w, http.StatusLocked, "upload", ErrAPILocked)
 		return
@@ -1093,6 +1319,16 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		resume = true
 		fullCommand += " --resumable"
 	}
+	onlyFailed := false
+	if _, exist := query["only-failed"]; exist {
+		onlyFailed = true
+		fullCommand += " --only-failed"
+	}
+	resumeRemote := false
+	if _, exist := query["resume-remote"]; exist {
+		resumeRemote = true
+		fullCommand += " --resume-remote"
+	}
 
 	fullCommand = fmt.Sprint(fullCommand, " ", name)
 
@@ -1103,10 +1339,20 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	commandId, err := api.startCommand(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "upload", err)
+		return
+	}
+	status.Current.SetUser(commandId, getAPIUser(r))
 	go func() {
-		commandId, _ := status.Current.Start(fullCommand)
+		if err := status.Current.WaitQueued(commandId, api.GetConfig().API.AllowParallel, api.GetConfig().API.MaxConcurrentOperations); err != nil {
+			api.log.Warnf("API /backup/upload: %v", err)
+			return
+		}
 		err, _ := api.metrics.ExecuteWithMetrics("upload", 0, func() error {
-			b := backup.NewBackuper(cfg)
+			b := backup.NewBackuper(cfg, backup.WithOnlyFailed(onlyFailed), backup.WithResumeRemote(resumeRemote))
 			return b.Upload(name, deleteSource, diffFrom, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly, resume, commandId)
 		})
 		if err != nil {
@@ -1124,17 +1370,19 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		api.successCallback(context.Background(), callback)
 	}()
 	api.sendJSONEachRow(w, http.StatusOK, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
-		BackupFrom string `json:"backup_from,omitempty"`
-		Diff       bool   `json:"diff"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
+		BackupFrom  string `json:"backup_from,omitempty"`
+		Diff        bool   `json:"diff"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "upload",
-		BackupName: name,
-		BackupFrom: diffFrom,
-		Diff:       diffFrom != "",
+		Status:      "acknowledged",
+		Operation:   "upload",
+		OperationId: commandId,
+		BackupName:  name,
+		BackupFrom:  diffFrom,
+		Diff:        diffFrom != "",
 	})
 }
 
@@ -1142,7 +1390,7 @@ var databaseMappingRE = regexp.MustCompile(`[\w+]:[\w+]`)
 
 // httpRestoreHandler - restore a backup from local storage
 func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
+	if api.GetConfig().API.QueueSize <= 0 && api.isLocked("") {
 		api.log.Info(ErrAPILocked.Error())
 		api.writeError(w, http.StatusLocked, "restore", ErrAPILocked)
 		return
@@ -1215,6 +1463,46 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		restoreConfigs = true
 		fullCommand += " --configs"
 	}
+	convertReplicated := false
+	if _, exist := query["convert_replicated"]; exist {
+		convertReplicated = true
+		fullCommand += " --convert-replicated"
+	}
+	reshardCluster := ""
+	if cluster, exist := query["reshard_cluster"]; exist {
+		reshardCluster = cluster[0]
+		fullCommand = fmt.Sprintf("%s --reshard-cluster=\"%s\"", fullCommand, reshardCluster)
+	}
+	syncReplicasCluster := ""
+	if cluster, exist := query["sync_replicas"]; exist {
+		syncReplicasCluster = cluster[0]
+		fullCommand = fmt.Sprintf("%s --sync-replicas=\"%s\"", fullCommand, syncReplicasCluster)
+	}
+	restoreDetached := false
+	if _, exist := query["detached"]; exist {
+		restoreDetached = true
+		fullCommand += " --detached"
+	}
+	repairProjections := false
+	if _, exist := query["repair_projections"]; exist {
+		repairProjections = true
+		fullCommand += " --repair-projections"
+	}
+	fromSnapshot := false
+	if _, exist := query["from_snapshot"]; exist {
+		fromSnapshot = true
+		fullCommand += " --from-snapshot"
+	}
+	encryptedDiskMode := ""
+	if mode, exist := query["encrypted_disk_mode"]; exist {
+		encryptedDiskMode = mode[0]
+		fullCommand = fmt.Sprintf("%s --encrypted-disk-mode=%s", fullCommand, encryptedDiskMode)
+	}
+	keeperOnly := false
+	if _, exist := query["keeper_only"]; exist {
+		keeperOnly = true
+		fullCommand += " --keeper-only"
+	}
 
 	name := utils.CleanBackupNameRE.ReplaceAllString(vars["name"], "")
 	fullCommand += fmt.Sprintf(" %s", name)
@@ -1226,10 +1514,20 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	commandId, _ := status.Current.Start(fullCommand)
+	commandId, err := api.startCommand(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "restore", err)
+		return
+	}
+	status.Current.SetUser(commandId, getAPIUser(r))
 	go func() {
+		if err := status.Current.WaitQueued(commandId, api.GetConfig().API.AllowParallel, api.GetConfig().API.MaxConcurrentOperations); err != nil {
+			api.log.Warnf("API /backup/restore: %v", err)
+			return
+		}
 		err, _ := api.metrics.ExecuteWithMetrics("restore", 0, func() error {
-			b := backup.NewBackuper(api.config)
+			b := backup.NewBackuper(api.GetConfig(), backup.WithConvertReplicated(convertReplicated), backup.WithReshardCluster(reshardCluster), backup.WithSyncReplicas(syncReplicasCluster), backup.WithRestoreKeeperOnly(keeperOnly), backup.WithEncryptedDiskMode(encryptedDiskMode), backup.WithRestoreDetached(restoreDetached), backup.WithRepairProjections(repairProjections), backup.WithFromSnapshot(fromSnapshot))
 			return b.Restore(name, tablePattern, databaseMappingToRestore, partitionsToBackup, schemaOnly, dataOnly, dropExists, ignoreDependencies, restoreRBAC, false, restoreConfigs, false, commandId)
 		})
 		status.Current.Stop(commandId, err)
@@ -1241,19 +1539,21 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		api.successCallback(context.Background(), callback)
 	}()
 	api.sendJSONEachRow(w, http.StatusOK, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "restore",
-		BackupName: name,
+		Status:      "acknowledged",
+		Operation:   "restore",
+		OperationId: commandId,
+		BackupName:  name,
 	})
 }
 
 // httpDownloadHandler - download a backup from remote to local storage
 func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
+	if api.GetConfig().API.QueueSize <= 0 && api.isLocked("") {
 		api.log.Info(ErrAPILocked.Error())
 		api.writeError(w, http.StatusLocked, "download", ErrAPILocked)
 		return
@@ -1297,8 +1597,18 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	commandId, err := api.startCommand(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "download", err)
+		return
+	}
+	status.Current.SetUser(commandId, getAPIUser(r))
 	go func() {
-		commandId, _ := status.Current.Start(fullCommand)
+		if err := status.Current.WaitQueued(commandId, api.GetConfig().API.AllowParallel, api.GetConfig().API.MaxConcurrentOperations); err != nil {
+			api.log.Warnf("API /backup/download: %v", err)
+			return
+		}
 		err, _ := api.metrics.ExecuteWithMetrics("download", 0, func() error {
 			b := backup.NewBackuper(cfg)
 			return b.Download(name, tablePattern, partitionsToBackup, schemaOnly, resume, commandId)
@@ -1318,19 +1628,21 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		api.successCallback(context.Background(), callback)
 	}()
 	api.sendJSONEachRow(w, http.StatusOK, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		BackupName  string `json:"backup_name"`
 	}{
-		Status:     "acknowledged",
-		Operation:  "download",
-		BackupName: name,
+		Status:      "acknowledged",
+		Operation:   "download",
+		OperationId: commandId,
+		BackupName:  name,
 	})
 }
 
 // httpDeleteHandler - delete a backup from local or remote storage
 func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
+	if api.isLocked("") {
 		api.log.Info(ErrAPILocked.Error())
 		api.writeError(w, http.StatusLocked, "delete", ErrAPILocked)
 		return
@@ -1340,9 +1652,21 @@ func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	vars := mux.Vars(r)
-	fullCommand := fmt.Sprintf("delete %s %s", vars["where"], vars["name"])
-	commandId, ctx := status.Current.Start(fullCommand)
-	b := backup.NewBackuper(cfg)
+	fullCommand := "delete"
+	forceGC := false
+	if _, exist := r.URL.Query()["force-gc"]; exist {
+		forceGC = true
+		fullCommand += " --force-gc"
+	}
+	fullCommand = fmt.Sprintf("%s %s %s", fullCommand, vars["where"], vars["name"])
+	commandId, ctx, err := api.tryStart(fullCommand)
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "delete", err)
+		return
+	}
+	status.Current.SetUser(commandId, getAPIUser(r))
+	b := backup.NewBackuper(cfg, backup.WithForceGC(forceGC))
 	switch vars["where"] {
 	case "local":
 		err = b.RemoveBackupLocal(ctx, vars["name"], nil)
@@ -1375,10 +1699,44 @@ func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// httpActionCancelHandler - cancel in progress or queued command by operation_id
+func (api *APIServer) httpActionCancelHandler(w http.ResponseWriter, r *http.Request) {
+	commandId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "cancel", fmt.Errorf("invalid operation id: %v", err))
+		return
+	}
+	row, exists := status.Current.GetStatusById(commandId)
+	if !exists {
+		api.writeError(w, http.StatusNotFound, "cancel", fmt.Errorf("operation %d not found", commandId))
+		return
+	}
+	if err = status.Current.CancelById(commandId, fmt.Errorf("canceled from API /backup/actions/%d/cancel", commandId)); err != nil {
+		api.writeError(w, http.StatusConflict, "cancel", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status      string `json:"status"`
+		Operation   string `json:"operation"`
+		OperationId int    `json:"operation_id"`
+		Command     string `json:"command"`
+	}{
+		Status:      "success",
+		Operation:   "cancel",
+		OperationId: commandId,
+		Command:     row.Command,
+	})
+}
+
 func (api *APIServer) httpBackupStatusHandler(w http.ResponseWriter, _ *http.Request) {
 	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetStatus(true, "", 0))
 }
 
+// httpWatchStatusHandler - state, next scheduled backup and last failures of each running watch process
+func (api *APIServer) httpWatchStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	api.sendJSONEachRow(w, http.StatusOK, backup.GetWatchStates())
+}
+
 func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) error {
 	// calc lastXXX metrics, fix https://github.com/Altinity/clickhouse-backup/issues/515
 	var lastBackupCreateLocal *time.Time
@@ -1392,10 +1750,10 @@ func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) e
 	numberBackupsRemoteBroken := 0
 
 	api.log.Infof("Update backup metrics start (onlyLocal=%v)", onlyLocal)
-	if !api.config.API.EnableMetrics {
+	if !api.GetConfig().API.EnableMetrics {
 		return nil
 	}
-	b := backup.NewBackuper(api.config)
+	b := backup.NewBackuper(api.GetConfig())
 	localBackups, _, err := b.GetLocalBackups(ctx, nil)
 	if err != nil {
 		return err
@@ -1403,7 +1761,7 @@ func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) e
 	if len(localBackups) > 0 {
 		numberBackupsLocal = len(localBackups)
 		lastBackup := localBackups[numberBackupsLocal-1]
-		lastSizeLocal = lastBackup.DataSize + lastBackup.MetadataSize + lastBackup.ConfigSize + lastBackup.RBACSize
+		lastSizeLocal = lastBackup.DataSize + lastBackup.MetadataSize + lastBackup.ConfigSize + lastBackup.RBACSize + lastBackup.KeeperSize
 		lastBackupCreateLocal = &lastBackup.CreationDate
 		api.metrics.LastBackupSizeLocal.Set(float64(lastSizeLocal))
 		api.metrics.NumberBackupsLocal.Set(float64(numberBackupsLocal))
@@ -1411,31 +1769,39 @@ func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) e
 		api.metrics.LastBackupSizeLocal.Set(0)
 		api.metrics.NumberBackupsLocal.Set(0)
 	}
-	if api.config.General.RemoteStorage == "none" || onlyLocal {
+	if api.GetConfig().General.RemoteStorage == "none" || onlyLocal {
 		return nil
 	}
-	remoteBackups, err := b.GetRemoteBackups(ctx, false)
+	// retention policies metrics require databases list from metadata.json, chain length metric requires required_backup only from last backup chain
+	parseMetadata := len(api.GetConfig().General.RetentionPolicies) > 0
+	remoteBackups, err := b.GetRemoteBackupsWithLastChain(ctx, parseMetadata)
 	if err != nil {
 		return err
 	}
-	if len(remoteBackups) > 0 {
-		numberBackupsRemote = len(remoteBackups)
+	// broken backups are detected only when metadata.json parsed, otherwise keep value from last GET /backup/list
+	if parseMetadata {
 		for _, b := range remoteBackups {
 			if b.Broken != "" {
 				numberBackupsRemoteBroken++
 			}
 		}
+		api.metrics.NumberBackupsRemoteBroken.Set(float64(numberBackupsRemoteBroken))
+	}
+	if len(remoteBackups) > 0 {
+		numberBackupsRemote = len(remoteBackups)
 		lastBackup := remoteBackups[numberBackupsRemote-1]
 		lastSizeRemote = lastBackup.GetFullSize()
 		lastBackupCreateRemote = &lastBackup.CreationDate
 		lastBackupUpload = &lastBackup.UploadDate
 		api.metrics.LastBackupSizeRemote.Set(float64(lastSizeRemote))
 		api.metrics.NumberBackupsRemote.Set(float64(numberBackupsRemote))
-		api.metrics.NumberBackupsRemoteBroken.Set(float64(numberBackupsRemoteBroken))
+		api.metrics.LastBackupChainLengthRemote.Set(float64(backup.IncrementalChainLength(remoteBackups, lastBackup.BackupName)))
+		api.updateRetentionPolicyMetrics(remoteBackups)
 	} else {
 		api.metrics.LastBackupSizeRemote.Set(0)
 		api.metrics.NumberBackupsRemote.Set(0)
-		api.metrics.NumberBackupsRemoteBroken.Set(0)
+		api.metrics.LastBackupChainLengthRemote.Set(0)
+		api.updateRetentionPolicyMetrics(nil)
 	}
 
 	if lastBackupCreateLocal != nil {
@@ -1467,6 +1833,30 @@ func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) e
 	return nil
 }
 
+// updateRetentionPolicyMetrics - policies without backups reported with zero value
+func (api *APIServer) updateRetentionPolicyMetrics(remoteBackups []storage.Backup) {
+	numberBackups := map[string]int{}
+	lastUpload := map[string]time.Time{}
+	for _, policy := range api.GetConfig().General.RetentionPolicies {
+		numberBackups[policy.Name] = 0
+	}
+	for _, remoteBackup := range remoteBackups {
+		policyName := storage.GetRetentionPolicyName(api.GetConfig().General.RetentionPolicies, remoteBackup)
+		numberBackups[policyName]++
+		if remoteBackup.UploadDate.After(lastUpload[policyName]) {
+			lastUpload[policyName] = remoteBackup.UploadDate
+		}
+	}
+	api.metrics.RetentionPolicyBackupsRemote.Reset()
+	api.metrics.RetentionPolicyLastBackupRemote.Reset()
+	for policyName, number := range numberBackups {
+		api.metrics.RetentionPolicyBackupsRemote.WithLabelValues(policyName).Set(float64(number))
+		if uploadDate, exists := lastUpload[policyName]; exists {
+			api.metrics.RetentionPolicyLastBackupRemote.WithLabelValues(policyName).Set(float64(uploadDate.Unix()))
+		}
+	}
+}
+
 func (api *APIServer) registerMetricsHandlers(r *mux.Router, enableMetrics bool, enablePprof bool) {
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		api.sendJSONEachRow(w, http.StatusOK, struct {
@@ -1494,7 +1884,7 @@ func (api *APIServer) registerMetricsHandlers(r *mux.Router, enableMetrics bool,
 func (api *APIServer) CreateIntegrationTables() error {
 	api.log.Infof("Create integration tables")
 	ch := &clickhouse.ClickHouse{
-		Config: &api.config.ClickHouse,
+		Config: &api.GetConfig().ClickHouse,
 		Log:    api.log.WithField("logger", "clickhouse"),
 	}
 	if err := ch.Connect(); err != nil {
@@ -1502,23 +1892,23 @@ func (api *APIServer) CreateIntegrationTables() error {
 	}
 	defer ch.Close()
 	port := "80"
-	if strings.Contains(api.config.API.ListenAddr, ":") {
-		port = api.config.API.ListenAddr[strings.Index(api.config.API.ListenAddr, ":")+1:]
+	if strings.Contains(api.GetConfig().API.ListenAddr, ":") {
+		port = api.GetConfig().API.ListenAddr[strings.Index(api.GetConfig().API.ListenAddr, ":")+1:]
 	}
 	auth := ""
-	if api.config.API.Username != "" || api.config.API.Password != "" {
+	if api.GetConfig().API.Username != "" || api.GetConfig().API.Password != "" {
 		params := url.Values{}
-		params.Add("user", api.config.API.Username)
-		params.Add("pass", api.config.API.Password)
+		params.Add("user", api.GetConfig().API.Username)
+		params.Add("pass", api.GetConfig().API.Password)
 		auth = fmt.Sprintf("?%s", params.Encode())
 	}
 	schema := "http"
-	if api.config.API.Secure {
+	if api.GetConfig().API.Secure {
 		schema = "https"
 	}
 	host := "127.0.0.1"
-	if api.config.API.IntegrationTablesHost != "" {
-		host = api.config.API.IntegrationTablesHost
+	if api.GetConfig().API.IntegrationTablesHost != "" {
+		host = api.GetConfig().API.IntegrationTablesHost
 	}
 	settings := ""
 	version, err := ch.GetVersion(context.Background())
@@ -1556,16 +1946,20 @@ func (api *APIServer) ReloadConfig(w http.ResponseWriter, command string) (*conf
 		}
 		return nil, err
 	}
-	api.config = cfg
+	api.setConfig(cfg)
 	api.log = apexLog.WithField("logger", "server")
 	api.metrics.NumberBackupsRemoteExpected.Set(float64(cfg.General.BackupsToKeepRemote))
 	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
+	api.metrics.RetentionPolicyBackupsRemoteExpected.Reset()
+	for _, policy := range cfg.General.RetentionPolicies {
+		api.metrics.RetentionPolicyBackupsRemoteExpected.WithLabelValues(policy.Name).Set(float64(policy.BackupsToKeepRemote))
+	}
 	return cfg, nil
 }
 
 func (api *APIServer) ResumeOperationsAfterRestart() error {
 	ch := clickhouse.ClickHouse{
-		Config: &api.config.ClickHouse,
+		Config: &api.GetConfig().ClickHouse,
 		Log:    apexLog.WithField("logger", "clickhouse"),
 	}
 	if err := ch.Connect(); err != nil {
@@ -1600,14 +1994,19 @@ func (api *APIServer) ResumeOperationsAfterRestart() error {
 				state := resumable.NewState(defaultDiskPath, backupName, command, nil)
 				params := state.GetParams()
 				state.Close()
-				if !api.config.API.AllowParallel && status.Current.InProgress() {
+				if api.isLocked("") {
 					return fmt.Errorf("another commands in progress")
 				}
-				switch command {
+				// destination suffix, look backup.resumableCommand
+				commandName, destination, _ := strings.Cut(command, "@")
+				switch commandName {
 				case "download":
 				case "upload":
 					args := make([]string, 0)
-					args = append(args, command)
+					args = append(args, commandName)
+					if destination != "" {
+						args = append(args, fmt.Sprintf("--destinations=\"%s\"", destination))
+					}
 					if diffFrom, ok := params["diffFrom"]; ok && diffFrom.(string) != "" {
 						args = append(args, fmt.Sprintf("--diff-from=\"%s\"", diffFrom))
 					}
@@ -1634,7 +2033,7 @@ func (api *APIServer) ResumeOperationsAfterRestart() error {
 					fullCommand := strings.Join(args, " ")
 					api.log.WithField("operation", "ResumeOperationsAfterRestart").Info(fullCommand)
 					commandId, _ := status.Current.Start(fullCommand)
-					err, _ = api.metrics.ExecuteWithMetrics(command, 0, func() error {
+					err, _ = api.metrics.ExecuteWithMetrics(commandName, 0, func() error {
 						return api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
 					})
 					status.Current.Stop(commandId, err)
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanHandlersLocked(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.API.MaxConcurrentOperations = 1
+	api := &APIServer{config: cfg, log: apexLog.WithField("logger", "server")}
+	srv := api.registerHTTPHandlers()
+	require.NotNil(t, srv)
+
+	commandId, _ := status.Current.Start("create backup_a")
+	defer status.Current.Stop(commandId, nil)
+	for _, url := range []string{"/backup/clean", "/backup/clean/remote_broken"} {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, nil))
+		assert.Equal(t, http.StatusLocked, w.Code, url)
+		assert.Contains(t, w.Body.String(), ErrAPILocked.Error(), url)
+	}
+}
+
+func TestIsAlive(t *testing.T) {
+	api := &APIServer{aliveCheck: make(chan chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorContains(t, api.isAlive(ctx), "serve loop is not responding")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case alive := <-api.aliveCheck:
+				close(alive)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	assert.NoError(t, api.isAlive(context.Background()))
+}
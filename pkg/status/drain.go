@@ -0,0 +1,79 @@
+package status
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+)
+
+// isWatchCommand - watch runs until canceled, so it never wedged and never waited during drain
+func isWatchCommand(command string) bool {
+	return command == "watch" || strings.HasPrefix(command, "watch ")
+}
+
+// Wedged - in progress commands which started earlier than timeout ago, watch commands skipped
+func (status *AsyncStatus) Wedged(timeout time.Duration) []ActionRowStatus {
+	status.RLock()
+	defer status.RUnlock()
+	wedged := make([]ActionRowStatus, 0)
+	if timeout <= 0 {
+		return wedged
+	}
+	for _, cmd := range status.commands {
+		if cmd.Status != InProgressStatus || isWatchCommand(cmd.Command) {
+			continue
+		}
+		start, err := time.ParseInLocation(common.TimeFormat, cmd.Start, time.Local)
+		if err != nil {
+			continue
+		}
+		if time.Since(start) > timeout {
+			wedged = append(wedged, cmd.ActionRowStatus)
+		}
+	}
+	return wedged
+}
+
+// CancelPending - cancel queued commands and watch commands, which will never finish by itself
+func (status *AsyncStatus) CancelPending(cancelMsg string) {
+	status.Lock()
+	defer status.Unlock()
+	for i := range status.commands {
+		if status.commands[i].Ctx == nil {
+			continue
+		}
+		if status.commands[i].Status == QueuedStatus || isWatchCommand(status.commands[i].Command) {
+			status.cancelCommand(i, cancelMsg)
+		}
+	}
+}
+
+// WaitInProgress - block until all in progress commands finished, return ctx.Err() when ctx done earlier
+func (status *AsyncStatus) WaitInProgress(ctx context.Context) error {
+	for {
+		status.Lock()
+		inProgress := false
+		for i := range status.commands {
+			if status.commands[i].Status == InProgressStatus {
+				inProgress = true
+				break
+			}
+		}
+		if !inProgress {
+			status.Unlock()
+			return nil
+		}
+		if status.changed == nil {
+			status.changed = make(chan struct{})
+		}
+		changed := status.changed
+		status.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
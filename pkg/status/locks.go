@@ -0,0 +1,139 @@
+package status
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	"github.com/google/shlex"
+)
+
+// exclusiveCommands - commands which touch all backups or shadow folders, can't run concurrently with any other command
+var exclusiveCommands = map[string]bool{
+	"clean":               true,
+	"clean_remote_broken": true,
+	"clean_local_broken":  true,
+}
+
+// nonBlockingCommands - short read only commands, never lock other commands and not counted in api->max_concurrent_operations
+var nonBlockingCommands = map[string]bool{
+	"list":   true,
+	"tables": true,
+	"kill":   true,
+}
+
+// serializedCommands - commands which change ClickHouse tables, only one of them could run at the same time
+var serializedCommands = map[string]string{
+	"restore":        "restore",
+	"restore_remote": "restore",
+}
+
+// commandLocks - parse command like `upload --diff-from-remote="base" backup_name`, return command name, exclusive flag and lock keys
+// lock keys are backup names which command creates, reads or deletes, and name of serialized group
+func commandLocks(command string) (string, bool, []string) {
+	args, err := shlex.Split(command)
+	if err != nil || len(args) == 0 {
+		return "", command != "", nil
+	}
+	name := args[0]
+	if exclusiveCommands[name] {
+		return name, true, nil
+	}
+	keys := make([]string, 0)
+	if group, exists := serializedCommands[name]; exists {
+		keys = append(keys, "command:"+group)
+	}
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "--diff-from=") || strings.HasPrefix(arg, "--diff-from-remote=") {
+			if base := strings.SplitN(arg, "=", 2)[1]; base != "" {
+				keys = append(keys, "backup:"+base)
+			}
+			continue
+		}
+		if strings.HasPrefix(arg, "-") || (name == "delete" && (arg == "local" || arg == "remote")) {
+			continue
+		}
+		keys = append(keys, "backup:"+arg)
+	}
+	return name, false, keys
+}
+
+// isNonBlocking - nonBlockingCommands and --dry-run previews, which only read state and shall not wait for real operations
+func isNonBlocking(command string) bool {
+	args, err := shlex.Split(command)
+	if err != nil || len(args) == 0 {
+		return false
+	}
+	if nonBlockingCommands[args[0]] {
+		return true
+	}
+	for _, arg := range args[1:] {
+		if arg == "--dry-run" || arg == "--dry-run=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocked - command can't start when conflicted command in progress or maxConcurrent commands already in progress, shall call under Lock
+// empty command is locked only by exclusive commands and maxConcurrent
+func (status *AsyncStatus) isLocked(command string, maxConcurrent int) bool {
+	if isNonBlocking(command) {
+		return false
+	}
+	_, exclusive, keys := commandLocks(command)
+	running := 0
+	for i := range status.commands {
+		if status.commands[i].Status != InProgressStatus {
+			continue
+		}
+		if isNonBlocking(status.commands[i].Command) {
+			continue
+		}
+		_, runningExclusive, runningKeys := commandLocks(status.commands[i].Command)
+		running++
+		if exclusive || runningExclusive {
+			return true
+		}
+		for _, key := range keys {
+			for _, runningKey := range runningKeys {
+				if key == runningKey {
+					return true
+				}
+			}
+		}
+	}
+	return running >= maxConcurrent
+}
+
+// IsLocked - look isLocked, use it for api->max_concurrent_operations > 0
+func (status *AsyncStatus) IsLocked(command string, maxConcurrent int) bool {
+	status.RLock()
+	defer status.RUnlock()
+	return status.isLocked(command, maxConcurrent)
+}
+
+// TryStart - the same as Start, but atomically check command is not locked, return ErrLocked otherwise
+func (status *AsyncStatus) TryStart(command string, maxConcurrent int) (int, context.Context, error) {
+	status.Lock()
+	defer status.Unlock()
+	if status.isLocked(command, maxConcurrent) {
+		return -1, nil, ErrLocked
+	}
+	ctx, cancel := context.WithCancel(withCommandId(context.Background(), status.idOffset+len(status.commands)))
+	status.commands = append(status.commands, ActionRow{
+		ActionRowStatus: ActionRowStatus{
+			Id:      status.idOffset + len(status.commands),
+			Command: command,
+			Start:   time.Now().Format(common.TimeFormat),
+			Status:  InProgressStatus,
+		},
+		Ctx:    ctx,
+		Cancel: cancel,
+	})
+	lastCommandId := status.idOffset + len(status.commands) - 1
+	status.log.Debugf("api.status.TryStart -> status.commands[%d] == %+v", lastCommandId, status.commands[len(status.commands)-1])
+	status.saveHistory()
+	return lastCommandId, ctx, nil
+}
@@ -2,9 +2,13 @@ package status
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
 	apexLog "github.com/apex/log"
+	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +16,7 @@ import (
 
 const (
 	InProgressStatus = "in progress"
+	QueuedStatus     = "queued"
 	SuccessStatus    = "success"
 	CancelStatus     = "cancel"
 	ErrorStatus      = "error"
@@ -23,18 +28,41 @@ var Current = &AsyncStatus{
 
 const NotFromAPI = int(-1)
 
+// maxHistoryRows - how many finished commands keep in history file
+const maxHistoryRows = 1000
+
+// ErrQueueFull - api->queue_size commands already wait in queue
+var ErrQueueFull = errors.New("too many queued operations")
+
+// ErrLocked - conflicted command in progress or api->max_concurrent_operations reached
+var ErrLocked = errors.New("conflicted operation is currently running or max concurrent operations reached")
+
 type AsyncStatus struct {
-	commands []ActionRow
-	log      *apexLog.Entry
+	commands    []ActionRow
+	log         *apexLog.Entry
+	historyFile string
+	// idOffset - id of status.commands[0], commands rotated from history file shift it
+	idOffset int
+	// changed - closed and replaced when any command finished, allow queued commands to wait own turn
+	changed chan struct{}
+	// cliWarnings - warnings of NotFromAPI commands, look AddWarning
+	cliWarnings []Warning
+	// warningHook - called for each warning, look SetWarningHook
+	warningHook func(kind string)
 	sync.RWMutex
 }
 
 type ActionRowStatus struct {
-	Command string `json:"command"`
-	Status  string `json:"status"`
-	Start   string `json:"start,omitempty"`
-	Finish  string `json:"finish,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Id       int       `json:"id"`
+	Command  string    `json:"command"`
+	Status   string    `json:"status"`
+	Start    string    `json:"start,omitempty"`
+	Finish   string    `json:"finish,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Bytes    uint64    `json:"bytes,omitempty"`
+	Warnings []Warning `json:"warnings,omitempty"`
+	// User - authenticated API user which started command, for audit log
+	User string `json:"user,omitempty"`
 }
 
 type ActionRow struct {
@@ -46,9 +74,10 @@ type ActionRow struct {
 func (status *AsyncStatus) Start(command string) (int, context.Context) {
 	status.Lock()
 	defer status.Unlock()
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(withCommandId(context.Background(), status.idOffset+len(status.commands)))
 	status.commands = append(status.commands, ActionRow{
 		ActionRowStatus: ActionRowStatus{
+			Id:      status.idOffset + len(status.commands),
 			Command: command,
 			Start:   time.Now().Format(common.TimeFormat),
 			Status:  InProgressStatus,
@@ -56,11 +85,108 @@ func (status *AsyncStatus) Start(command string) (int, context.Context) {
 		Ctx:    ctx,
 		Cancel: cancel,
 	})
-	lastCommandId := len(status.commands) - 1
-	status.log.Debugf("api.status.Start -> status.commands[%d] == %+v", lastCommandId, status.commands[lastCommandId])
+	lastCommandId := status.idOffset + len(status.commands) - 1
+	status.log.Debugf("api.status.Start -> status.commands[%d] == %+v", lastCommandId, status.commands[len(status.commands)-1])
+	status.saveHistory()
 	return lastCommandId, ctx
 }
 
+// Enqueue - register command with QueuedStatus, command shall call WaitQueued before execution, return ErrQueueFull when queueSize commands already queued
+func (status *AsyncStatus) Enqueue(command string, queueSize int) (int, error) {
+	status.Lock()
+	defer status.Unlock()
+	queued := 0
+	for _, cmd := range status.commands {
+		if cmd.Status == QueuedStatus {
+			queued++
+		}
+	}
+	if queued >= queueSize {
+		return -1, ErrQueueFull
+	}
+	ctx, cancel := context.WithCancel(withCommandId(context.Background(), status.idOffset+len(status.commands)))
+	status.commands = append(status.commands, ActionRow{
+		ActionRowStatus: ActionRowStatus{
+			Id:      status.idOffset + len(status.commands),
+			Command: command,
+			Status:  QueuedStatus,
+		},
+		Ctx:    ctx,
+		Cancel: cancel,
+	})
+	lastCommandId := status.idOffset + len(status.commands) - 1
+	status.log.Debugf("api.status.Enqueue -> status.commands[%d] == %+v", lastCommandId, status.commands[len(status.commands)-1])
+	status.saveHistory()
+	return lastCommandId, nil
+}
+
+// WaitQueued - block until all earlier queued commands started and, when allowParallel=false, no other command except watch in progress, then switch command to InProgressStatus
+// with maxConcurrent > 0 allowParallel ignored, command waits until no conflicted commands in progress and less than maxConcurrent commands in progress, look isLocked
+// return error when command was canceled during wait
+func (status *AsyncStatus) WaitQueued(commandId int, allowParallel bool, maxConcurrent int) error {
+	for {
+		status.Lock()
+		idx, exists := status.commandIndex(commandId)
+		if !exists {
+			status.Unlock()
+			return fmt.Errorf("commandId=%d not exists", commandId)
+		}
+		cmd := &status.commands[idx]
+		if cmd.Status != QueuedStatus {
+			s := cmd.Status
+			status.Unlock()
+			if s == InProgressStatus {
+				return nil
+			}
+			return fmt.Errorf("commandId=%d `%s` has status=%s", commandId, cmd.Command, s)
+		}
+		turn := true
+		for i := range status.commands {
+			if (i < idx && status.commands[i].Status == QueuedStatus) || (maxConcurrent <= 0 && !allowParallel && status.commands[i].Status == InProgressStatus && !isWatchCommand(status.commands[i].Command)) {
+				turn = false
+				break
+			}
+		}
+		if turn && maxConcurrent > 0 {
+			turn = !status.isLocked(cmd.Command, maxConcurrent)
+		}
+		if turn {
+			cmd.Status = InProgressStatus
+			cmd.Start = time.Now().Format(common.TimeFormat)
+			status.log.Debugf("api.status.WaitQueued -> status.commands[%d] == %+v", commandId, *cmd)
+			status.saveHistory()
+			status.Unlock()
+			return nil
+		}
+		if status.changed == nil {
+			status.changed = make(chan struct{})
+		}
+		changed := status.changed
+		ctx := cmd.Ctx
+		status.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// commandIndex - position of commandId in status.commands, shall call under Lock
+func (status *AsyncStatus) commandIndex(commandId int) (int, bool) {
+	idx := commandId - status.idOffset
+	return idx, idx >= 0 && idx < len(status.commands)
+}
+
+// notifyChanged - shall call under Lock
+func (status *AsyncStatus) notifyChanged() {
+	if status.changed != nil {
+		close(status.changed)
+		status.changed = nil
+	}
+	status.saveHistory()
+}
+
 func (status *AsyncStatus) CheckCommandInProgress(command string) bool {
 	status.RLock()
 	defer status.RUnlock()
@@ -91,35 +217,38 @@ func (status *AsyncStatus) GetContextWithCancel(commandId int) (context.Context,
 	status.RLock()
 	defer status.RUnlock()
 	if commandId == NotFromAPI {
-		ctx, cancel := context.WithCancel(context.Background())
+		ctx, cancel := context.WithCancel(withCommandId(context.Background(), NotFromAPI))
 		return ctx, cancel, nil
 	}
-	if commandId >= len(status.commands) {
+	idx, exists := status.commandIndex(commandId)
+	if !exists {
 		return nil, nil, fmt.Errorf("commandId=%d not exists in current running commands", commandId)
 	}
-	if status.commands[commandId].Ctx == nil {
-		return nil, nil, fmt.Errorf("commands[%d]=%s have nil context ", commandId, status.commands[commandId].Command)
+	if status.commands[idx].Ctx == nil {
+		return nil, nil, fmt.Errorf("commands[%d]=%s have nil context ", commandId, status.commands[idx].Command)
 	}
-	return status.commands[commandId].Ctx, status.commands[commandId].Cancel, nil
+	return status.commands[idx].Ctx, status.commands[idx].Cancel, nil
 }
 
 func (status *AsyncStatus) Stop(commandId int, err error) {
 	status.Lock()
 	defer status.Unlock()
-	if status.commands[commandId].Status != InProgressStatus {
+	idx, exists := status.commandIndex(commandId)
+	if !exists || status.commands[idx].Status != InProgressStatus {
 		return
 	}
-	status.commands[commandId].Cancel()
+	status.commands[idx].Cancel()
 	s := SuccessStatus
 	if err != nil {
 		s = ErrorStatus
-		status.commands[commandId].Error = err.Error()
+		status.commands[idx].Error = err.Error()
 	}
-	status.commands[commandId].Status = s
-	status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
-	status.commands[commandId].Ctx = nil
-	status.commands[commandId].Cancel = nil
-	status.log.Debugf("api.status.stop -> status.commands[%d] == %+v", commandId, status.commands[commandId])
+	status.commands[idx].Status = s
+	status.commands[idx].Finish = time.Now().Format(common.TimeFormat)
+	status.commands[idx].Ctx = nil
+	status.commands[idx].Cancel = nil
+	status.log.Debugf("api.status.stop -> status.commands[%d] == %+v", commandId, status.commands[idx])
+	status.notifyChanged()
 }
 
 func (status *AsyncStatus) Cancel(command string, err error) error {
@@ -154,31 +283,46 @@ func (status *AsyncStatus) Cancel(command string, err error) error {
 	if status.commands[commandId].Status != InProgressStatus {
 		status.log.Warnf("found `%s` with status=%s", command, status.commands[commandId].Status)
 	}
-	if status.commands[commandId].Ctx != nil {
-		status.commands[commandId].Cancel()
-		status.commands[commandId].Ctx = nil
-		status.commands[commandId].Cancel = nil
+	status.cancelCommand(commandId, err.Error())
+	return nil
+}
+
+// CancelById - cancel in progress or queued command, return error when command not exists or already finished
+func (status *AsyncStatus) CancelById(commandId int, err error) error {
+	status.Lock()
+	defer status.Unlock()
+	idx, exists := status.commandIndex(commandId)
+	if !exists {
+		return fmt.Errorf("commandId=%d not found", commandId)
+	}
+	if status.commands[idx].Ctx == nil {
+		return fmt.Errorf("commandId=%d `%s` already finished with status=%s", commandId, status.commands[idx].Command, status.commands[idx].Status)
 	}
-	status.commands[commandId].Error = err.Error()
-	status.commands[commandId].Status = CancelStatus
-	status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
-	status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, status.commands[commandId])
+	status.cancelCommand(idx, err.Error())
 	return nil
 }
 
+// cancelCommand - idx is position in status.commands, shall call under Lock
+func (status *AsyncStatus) cancelCommand(idx int, cancelMsg string) {
+	if status.commands[idx].Ctx != nil {
+		status.commands[idx].Cancel()
+		status.commands[idx].Ctx = nil
+		status.commands[idx].Cancel = nil
+	}
+	status.commands[idx].Error = cancelMsg
+	status.commands[idx].Status = CancelStatus
+	status.commands[idx].Finish = time.Now().Format(common.TimeFormat)
+	status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", status.commands[idx].Id, status.commands[idx])
+	status.notifyChanged()
+}
+
 func (status *AsyncStatus) CancelAll(cancelMsg string) {
 	status.Lock()
 	defer status.Unlock()
 	for commandId := range status.commands {
 		if status.commands[commandId].Ctx != nil {
-			status.commands[commandId].Cancel()
-			status.commands[commandId].Ctx = nil
-			status.commands[commandId].Cancel = nil
+			status.cancelCommand(commandId, cancelMsg)
 		}
-		status.commands[commandId].Status = CancelStatus
-		status.commands[commandId].Error = cancelMsg
-		status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
-		status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, status.commands[commandId])
 	}
 }
 
@@ -197,13 +341,7 @@ func (status *AsyncStatus) GetStatus(current bool, filter string, last int) []Ac
 	for _, command := range status.commands {
 		if filter == "" || (strings.Contains(command.Command, filter) || strings.Contains(command.Status, filter) || strings.Contains(command.Error, filter)) {
 			// copy without context and cancel
-			filteredCommands = append(filteredCommands, ActionRowStatus{
-				Command: command.Command,
-				Status:  command.Status,
-				Start:   command.Start,
-				Finish:  command.Finish,
-				Error:   command.Error,
-			})
+			filteredCommands = append(filteredCommands, command.ActionRowStatus)
 		}
 	}
 	if len(filteredCommands) == 0 {
@@ -221,3 +359,113 @@ func (status *AsyncStatus) GetStatus(current bool, filter string, last int) []Ac
 	}
 	return filteredCommands[begin:end]
 }
+
+// GetStatusById - return false when commandId is not exists
+func (status *AsyncStatus) GetStatusById(commandId int) (ActionRowStatus, bool) {
+	status.RLock()
+	defer status.RUnlock()
+	idx, exists := status.commandIndex(commandId)
+	if !exists {
+		return ActionRowStatus{}, false
+	}
+	return status.commands[idx].ActionRowStatus, true
+}
+
+// SetUser - remember API user which started command, empty user is ignored
+func (status *AsyncStatus) SetUser(commandId int, user string) {
+	if user == "" {
+		return
+	}
+	status.Lock()
+	defer status.Unlock()
+	if idx, exists := status.commandIndex(commandId); exists {
+		status.commands[idx].User = user
+	}
+}
+
+// AddBytes - account transferred bytes for command started from API
+func (status *AsyncStatus) AddBytes(commandId int, bytes uint64) {
+	status.Lock()
+	defer status.Unlock()
+	if idx, exists := status.commandIndex(commandId); exists {
+		status.commands[idx].Bytes += bytes
+	}
+}
+
+// LoadHistory - restore commands from historyFile saved by previous API server run, all commands which was in progress or queued marked as canceled
+// after load each command change saved to historyFile, empty historyFile disable persistence
+func (status *AsyncStatus) LoadHistory(historyFile string) error {
+	status.Lock()
+	defer status.Unlock()
+	status.historyFile = historyFile
+	if historyFile == "" {
+		return nil
+	}
+	body, err := os.ReadFile(historyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var history []ActionRowStatus
+	if err = json.Unmarshal(body, &history); err != nil {
+		return fmt.Errorf("can't parse %s: %v", historyFile, err)
+	}
+	if len(history) > maxHistoryRows {
+		history = history[len(history)-maxHistoryRows:]
+	}
+	commands := make([]ActionRow, 0, len(history))
+	for _, row := range history {
+		if row.Status == InProgressStatus || row.Status == QueuedStatus {
+			row.Status = CancelStatus
+			row.Error = "interrupted by API server restart"
+			row.Finish = time.Now().Format(common.TimeFormat)
+		}
+		commands = append(commands, ActionRow{ActionRowStatus: row})
+	}
+	// commands started before load shall keep own commandId
+	if len(status.commands) > 0 {
+		status.log.Warnf("%d commands started before load %s, history ignored", len(status.commands), historyFile)
+		return nil
+	}
+	if len(commands) > 0 {
+		status.idOffset = commands[0].Id
+		for i := range commands {
+			commands[i].Id = status.idOffset + i
+		}
+	}
+	status.commands = commands
+	return nil
+}
+
+// saveHistory - shall call under Lock, failed write shall not fail command
+func (status *AsyncStatus) saveHistory() {
+	if status.historyFile == "" {
+		return
+	}
+	history := make([]ActionRowStatus, 0, len(status.commands))
+	for _, cmd := range status.commands {
+		history = append(history, cmd.ActionRowStatus)
+	}
+	if len(history) > maxHistoryRows {
+		history = history[len(history)-maxHistoryRows:]
+	}
+	body, err := json.Marshal(history)
+	if err != nil {
+		status.log.Warnf("can't marshal commands history: %v", err)
+		return
+	}
+	if err = os.MkdirAll(path.Dir(status.historyFile), 0750); err != nil {
+		status.log.Warnf("can't create %s: %v", path.Dir(status.historyFile), err)
+		return
+	}
+	tmpFile := status.historyFile + ".tmp"
+	if err = os.WriteFile(tmpFile, body, 0640); err != nil {
+		status.log.Warnf("can't write %s: %v", tmpFile, err)
+		return
+	}
+	if err = os.Rename(tmpFile, status.historyFile); err != nil {
+		status.log.Warnf("can't rename %s: %v", tmpFile, err)
+	}
+}
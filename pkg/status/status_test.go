@@ -0,0 +1,213 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	firstId, _ := s.Start("create first")
+	secondId, err := s.Enqueue("upload second", 1)
+	require.NoError(t, err)
+	_, err = s.Enqueue("upload third", 1)
+	require.ErrorIs(t, err, ErrQueueFull)
+
+	started := make(chan error)
+	go func() {
+		started <- s.WaitQueued(secondId, false, 0)
+	}()
+	select {
+	case <-started:
+		t.Fatal("queued command shall wait until first command finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.Stop(firstId, nil)
+	require.NoError(t, <-started)
+	row, exists := s.GetStatusById(secondId)
+	require.True(t, exists)
+	assert.Equal(t, InProgressStatus, row.Status)
+
+	thirdId, err := s.Enqueue("download third", 1)
+	require.NoError(t, err)
+	go func() {
+		started <- s.WaitQueued(thirdId, false, 0)
+	}()
+	require.NoError(t, s.CancelById(thirdId, fmt.Errorf("canceled")))
+	require.Error(t, <-started)
+	require.Error(t, s.CancelById(thirdId, fmt.Errorf("canceled")))
+	require.Error(t, s.CancelById(100, fmt.Errorf("canceled")))
+
+	// `server --watch` runs watch until stop, queued command shall not wait for it
+	s.Stop(secondId, nil)
+	watchId, _ := s.Start("watch")
+	fourthId, err := s.Enqueue("upload fourth", 1)
+	require.NoError(t, err)
+	go func() {
+		started <- s.WaitQueued(fourthId, false, 0)
+	}()
+	select {
+	case err = <-started:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued command shall not wait for watch")
+	}
+	s.Stop(fourthId, nil)
+	s.Stop(watchId, nil)
+}
+
+func TestHistory(t *testing.T) {
+	historyFile := path.Join(t.TempDir(), "history.json")
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	require.NoError(t, s.LoadHistory(historyFile))
+	finishedId, _ := s.Start("create finished")
+	s.AddBytes(finishedId, 100)
+	s.Stop(finishedId, nil)
+	runningId, _ := s.Start("upload running")
+
+	restarted := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	require.NoError(t, restarted.LoadHistory(historyFile))
+	row, exists := restarted.GetStatusById(finishedId)
+	require.True(t, exists)
+	assert.Equal(t, SuccessStatus, row.Status)
+	assert.Equal(t, uint64(100), row.Bytes)
+	row, exists = restarted.GetStatusById(runningId)
+	require.True(t, exists)
+	assert.Equal(t, CancelStatus, row.Status)
+	newId, _ := restarted.Start("download new")
+	assert.Equal(t, runningId+1, newId)
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	uploadId, _, err := s.TryStart("upload --diff-from-remote=\"base\" backup_a", 2)
+	require.NoError(t, err)
+	_, _, err = s.TryStart("upload backup_a", 2)
+	require.ErrorIs(t, err, ErrLocked, "the same backup")
+	_, _, err = s.TryStart("delete remote base", 2)
+	require.ErrorIs(t, err, ErrLocked, "base of incremental upload")
+	_, _, err = s.TryStart("clean", 2)
+	require.ErrorIs(t, err, ErrLocked, "exclusive command")
+	listId, _, err := s.TryStart("list remote", 2)
+	require.NoError(t, err, "list never locked")
+	_, _, err = s.TryStart("upload --dry-run backup_a", 2)
+	require.NoError(t, err, "dry-run never locked")
+
+	createId, _, err := s.TryStart("create --tables=\"db.*\" backup_b", 2)
+	require.NoError(t, err)
+	_, _, err = s.TryStart("download backup_c", 2)
+	require.ErrorIs(t, err, ErrLocked, "max concurrent operations reached")
+
+	queuedId, err := s.Enqueue("download backup_c", 1)
+	require.NoError(t, err)
+	started := make(chan error)
+	go func() {
+		started <- s.WaitQueued(queuedId, false, 2)
+	}()
+	select {
+	case <-started:
+		t.Fatal("queued command shall wait until one of commands finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.Stop(listId, nil)
+	select {
+	case <-started:
+		t.Fatal("list shall not free slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.Stop(createId, nil)
+	require.NoError(t, <-started)
+	s.Stop(queuedId, nil)
+
+	_, _, err = s.TryStart("restore_remote backup_d", 2)
+	require.NoError(t, err)
+	_, _, err = s.TryStart("restore backup_e", 2)
+	require.ErrorIs(t, err, ErrLocked, "only one restore")
+	s.Stop(uploadId, nil)
+	assert.True(t, s.IsLocked("clean", 2))
+	assert.False(t, s.IsLocked("", 2))
+}
+
+func TestDrain(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	watchId, _ := s.Start("watch")
+	uploadId, _ := s.Start("upload backup_a")
+	queuedId, err := s.Enqueue("download backup_b", 1)
+	require.NoError(t, err)
+	assert.Empty(t, s.Wedged(0))
+	assert.Empty(t, s.Wedged(time.Hour))
+	s.Lock()
+	s.commands[uploadId].Start = time.Now().Add(-2 * time.Hour).Format(common.TimeFormat)
+	s.commands[watchId].Start = s.commands[uploadId].Start
+	s.Unlock()
+	wedged := s.Wedged(time.Hour)
+	require.Len(t, wedged, 1)
+	assert.Equal(t, uploadId, wedged[0].Id)
+
+	s.CancelPending("drain")
+	row, _ := s.GetStatusById(queuedId)
+	assert.Equal(t, CancelStatus, row.Status)
+	row, _ = s.GetStatusById(watchId)
+	assert.Equal(t, CancelStatus, row.Status)
+	row, _ = s.GetStatusById(uploadId)
+	assert.Equal(t, InProgressStatus, row.Status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.WaitInProgress(ctx), context.DeadlineExceeded)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Stop(uploadId, nil)
+	}()
+	require.NoError(t, s.WaitInProgress(context.Background()))
+}
+
+func TestWarnings(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	hookKinds := make([]string, 0)
+	s.SetWarningHook(func(kind string) {
+		hookKinds = append(hookKinds, kind)
+	})
+	commandId, ctx := s.Start("create backup_a")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.AddWarning(ctx, s.log, WarningSkippedTable, "%s skipped", "default.t1")
+	s.Stop(commandId, nil)
+	row, exists := s.GetStatusById(commandId)
+	require.True(t, exists)
+	require.Len(t, row.Warnings, 1)
+	assert.Equal(t, WarningSkippedTable, row.Warnings[0].Kind)
+	assert.Equal(t, "default.t1 skipped", row.Warnings[0].Message)
+
+	cliCtx, cliCancel, err := s.GetContextWithCancel(NotFromAPI)
+	require.NoError(t, err)
+	defer cliCancel()
+	s.AddWarning(cliCtx, s.log, WarningClockSkew, "clock skew")
+	s.AddWarning(context.Background(), s.log, WarningFallback, "only logged")
+	warnings := s.CLIWarnings()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, WarningClockSkew, warnings[0].Kind)
+	assert.Empty(t, s.CLIWarnings())
+	assert.Equal(t, []string{WarningSkippedTable, WarningClockSkew, WarningFallback}, hookKinds)
+}
+
+func TestSetUser(t *testing.T) {
+	s := &AsyncStatus{log: apexLog.WithField("logger", "status")}
+	commandId, ctx := s.Start("delete remote backup_a")
+	s.SetUser(commandId, "admin")
+	s.SetUser(commandId, "")
+	s.Stop(commandId, nil)
+	row, exists := s.GetStatusById(commandId)
+	require.True(t, exists)
+	assert.Equal(t, "admin", row.User)
+	assert.Equal(t, commandId, GetCommandId(ctx))
+	assert.Equal(t, NotFromAPI, GetCommandId(context.Background()))
+}
@@ -0,0 +1,80 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/common"
+	apexLog "github.com/apex/log"
+)
+
+// warning kinds, used as `kind` label for clickhouse_backup_warnings metric
+const (
+	WarningSkippedTable      = "skipped_table"
+	WarningMaskedCredentials = "masked_credentials"
+	WarningFallback          = "fallback"
+	WarningClockSkew         = "clock_skew"
+	WarningDDLDivergence     = "ddl_divergence"
+	WarningLiveDependents    = "live_dependents"
+)
+
+// Warning - non-fatal issue which happens during operation, operation continues but result could differ from expected
+type Warning struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Time    string `json:"time"`
+}
+
+type commandIdKey struct{}
+
+// withCommandId - each command context allows AddWarning to find command without passing commandId through all calls
+func withCommandId(ctx context.Context, commandId int) context.Context {
+	return context.WithValue(ctx, commandIdKey{}, commandId)
+}
+
+// GetCommandId - commandId of command which ctx was received from Start, Enqueue or GetContextWithCancel, NotFromAPI for other contexts
+func GetCommandId(ctx context.Context) int {
+	if commandId, exists := ctx.Value(commandIdKey{}).(int); exists {
+		return commandId
+	}
+	return NotFromAPI
+}
+
+// AddWarning - log warning and add it to command which ctx was received from GetContextWithCancel, commands from CLI keep warnings until CLIWarnings
+func (status *AsyncStatus) AddWarning(ctx context.Context, log *apexLog.Entry, kind, format string, args ...interface{}) {
+	w := Warning{Kind: kind, Message: fmt.Sprintf(format, args...), Time: time.Now().Format(common.TimeFormat)}
+	log.WithField("warning", kind).Warn(w.Message)
+	status.Lock()
+	defer status.Unlock()
+	if status.warningHook != nil {
+		status.warningHook(kind)
+	}
+	commandId, exists := ctx.Value(commandIdKey{}).(int)
+	if !exists {
+		return
+	}
+	if commandId == NotFromAPI {
+		status.cliWarnings = append(status.cliWarnings, w)
+		return
+	}
+	if idx, exists := status.commandIndex(commandId); exists {
+		status.commands[idx].Warnings = append(status.commands[idx].Warnings, w)
+	}
+}
+
+// SetWarningHook - API server counts warnings in clickhouse_backup_warnings metric, status shall not depend on metrics
+func (status *AsyncStatus) SetWarningHook(hook func(kind string)) {
+	status.Lock()
+	defer status.Unlock()
+	status.warningHook = hook
+}
+
+// CLIWarnings - return and reset warnings of commands which run from CLI, look NotFromAPI
+func (status *AsyncStatus) CLIWarnings() []Warning {
+	status.Lock()
+	defer status.Unlock()
+	warnings := status.cliWarnings
+	status.cliWarnings = nil
+	return warnings
+}
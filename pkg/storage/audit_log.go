@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+)
+
+// AuditLogDir - records of general->audit_log_remote in the root of remote storage, skipped by BackupList
+const AuditLogDir = "audit_log"
+
+// PutAuditRecord - each record is separate object, remote storages don't support append
+func (bd *BackupDestination) PutAuditRecord(ctx context.Context, name string, record []byte) error {
+	return bd.PutFile(ctx, path.Join(AuditLogDir, name), io.NopCloser(bytes.NewReader(record)))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
 	apexLog "github.com/apex/log"
 	"io"
+	"net/http"
 	"net/url"
 	"path"
 	"strings"
@@ -28,6 +29,7 @@ type AzureBlob struct {
 	Pipeline  pipeline.Pipeline
 	CPK       azblob.ClientProvidedKeyOptions
 	Config    *config.AzureBlobConfig
+	HTTPPool  HTTPPool
 	Log       *apexLog.Entry
 }
 
@@ -121,6 +123,7 @@ func (a *AzureBlob) Connect(ctx context.Context) error {
 			Retry: azblob.RetryOptions{
 				TryTimeout: timeout,
 			},
+			HTTPSender: newAzblobHTTPSender(a.HTTPPool.newTransport(false)),
 		})
 		a.Container = azblob.NewServiceURL(*u, a.Pipeline).NewContainerURL(a.Config.Container)
 		_, err = a.Container.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
@@ -157,6 +160,20 @@ func (a *AzureBlob) Connect(ctx context.Context) error {
 	return nil
 }
 
+// newAzblobHTTPSender - the same as default sender of azure-pipeline-go, but with configured connections pool
+func newAzblobHTTPSender(transport http.RoundTripper) pipeline.Factory {
+	httpClient := &http.Client{Transport: transport}
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			r, err := httpClient.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(r), err
+		}
+	})
+}
+
 func (a *AzureBlob) Close(ctx context.Context) error {
 	return nil
 }
@@ -184,9 +201,25 @@ func (a *AzureBlob) PutFile(ctx context.Context, key string, r io.ReadCloser) er
 }
 
 func (a *AzureBlob) PutFileAbsolute(ctx context.Context, key string, r io.ReadCloser) error {
+	return a.putFileAbsolute(ctx, key, r, a.Config.BufferSize)
+}
+
+// https://learn.microsoft.com/en-us/rest/api/storageservices/understanding-block-blobs--append-blobs--and-page-blobs
+const (
+	azblobMaxBlocks    = 50000
+	azblobMaxBlockSize = 4000 * 1024 * 1024
+)
+
+// PutFileWithSize - implements sizedFilePutter, block blob contains not more than 50000 blocks, so buffer_size increases for big blobs
+func (a *AzureBlob) PutFileWithSize(ctx context.Context, key string, r io.ReadCloser, expectedSize int64) error {
+	bufferSize := int(calculatePartSize(int64(a.Config.BufferSize), expectedSize, azblobMaxBlocks, azblobMaxBlockSize))
+	return a.putFileAbsolute(ctx, path.Join(a.Config.Path, key), r, bufferSize)
+}
+
+func (a *AzureBlob) putFileAbsolute(ctx context.Context, key string, r io.ReadCloser, bufferSize int) error {
 	a.logf("AZBLOB->PutFileAbsolute %s", key)
 	blob := a.Container.NewBlockBlobURL(key)
-	bufferSize := a.Config.BufferSize // Configure the size of the rotating buffers that are used when uploading
+	// bufferSize is the size of the rotating buffers that are used when uploading
 	maxBuffers := a.Config.MaxBuffers // Configure the number of rotating buffers that are used when uploading
 	_, err := x.UploadStreamToBlockBlob(ctx, r, blob, azblob.UploadStreamToBlockBlobOptions{BufferSize: bufferSize, MaxBuffers: maxBuffers}, a.CPK)
 	return err
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CatalogFile - index of all backups in the root of remote storage, allow `list remote` and `--diff-from-remote` use one GetFile instead of List + GetFile for each metadata.json
+const CatalogFile = "catalog.json"
+
+type Catalog struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Backups   []Backup  `json:"backups"`
+}
+
+var catalogLock sync.Mutex
+
+// loadCatalog - return nil without error when catalog doesn't exist yet
+func (bd *BackupDestination) loadCatalog(ctx context.Context) (*Catalog, error) {
+	if _, err := bd.StatFile(ctx, CatalogFile); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r, err := bd.GetFileReader(ctx, CatalogFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if err = r.Close(); err != nil {
+		return nil, err
+	}
+	catalog := &Catalog{}
+	if err = json.Unmarshal(body, catalog); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", CatalogFile, err)
+	}
+	return catalog, nil
+}
+
+// saveCatalog - PutFile replace whole object, so readers never see partially written catalog on object storages
+func (bd *BackupDestination) saveCatalog(ctx context.Context, catalog *Catalog) error {
+	body, err := marshalCatalog(catalog)
+	if err != nil {
+		return err
+	}
+	return bd.PutFile(ctx, CatalogFile, io.NopCloser(bytes.NewReader(body)))
+}
+
+func marshalCatalog(catalog *Catalog) ([]byte, error) {
+	catalog.UpdatedAt = time.Now().UTC()
+	sortBackupList(catalog.Backups)
+	return json.Marshal(catalog)
+}
+
+// catalogUpdateRetries - how many times read-modify-write of catalog is repeated when catalog was changed by another host
+const catalogUpdateRetries = 5
+
+// updateCatalog - catalogLock protects only current process, so when remote storage supports conditional put,
+// catalog is replaced only when it was not changed after read and update is repeated otherwise,
+// when update failed, catalog is deleted to avoid stale listing, next BackupList will rebuild it
+func (bd *BackupDestination) updateCatalog(ctx context.Context, update func(catalog *Catalog)) error {
+	if !bd.useCatalog {
+		return nil
+	}
+	var err error
+	if putter, isPutter := bd.RemoteStorage.(ConditionalPutter); isPutter {
+		for attempt := 1; attempt <= catalogUpdateRetries; attempt++ {
+			if err = bd.updateCatalogIfVersion(ctx, putter, update); !errors.Is(err, ErrVersionMismatch) {
+				break
+			}
+			bd.Log.Debugf("%s was changed by another writer, attempt %d/%d", CatalogFile, attempt, catalogUpdateRetries)
+		}
+	} else {
+		catalogLock.Lock()
+		err = bd.updateCatalogLocked(ctx, update)
+		catalogLock.Unlock()
+	}
+	if err != nil {
+		if deleteErr := bd.DeleteFile(ctx, CatalogFile); deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+			return fmt.Errorf("can't update %s: %v, and can't delete it: %v", CatalogFile, err, deleteErr)
+		}
+		return fmt.Errorf("can't update %s, deleted for rebuild: %v", CatalogFile, err)
+	}
+	return nil
+}
+
+// updateCatalogIfVersion - version is read before body, so when catalog is changed between reads, put fails with ErrVersionMismatch
+func (bd *BackupDestination) updateCatalogIfVersion(ctx context.Context, putter ConditionalPutter, update func(catalog *Catalog)) error {
+	version, err := putter.GetFileVersion(ctx, CatalogFile)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// will build during next BackupList
+			return nil
+		}
+		return err
+	}
+	catalog, err := bd.loadCatalog(ctx)
+	if err != nil || catalog == nil {
+		return err
+	}
+	update(catalog)
+	body, err := marshalCatalog(catalog)
+	if err != nil {
+		return err
+	}
+	return putter.PutFileIfVersion(ctx, CatalogFile, io.NopCloser(bytes.NewReader(body)), version)
+}
+
+func (bd *BackupDestination) updateCatalogLocked(ctx context.Context, update func(catalog *Catalog)) error {
+	catalog, err := bd.loadCatalog(ctx)
+	if err != nil || catalog == nil {
+		// will build during next BackupList
+		return err
+	}
+	update(catalog)
+	return bd.saveCatalog(ctx, catalog)
+}
+
+// AddToCatalog - shall be called after metadata.json upload
+func (bd *BackupDestination) AddToCatalog(ctx context.Context, backup Backup) error {
+	return bd.updateCatalog(ctx, func(catalog *Catalog) {
+		for i := range catalog.Backups {
+			if catalog.Backups[i].BackupName == backup.BackupName {
+				catalog.Backups[i] = backup
+				return
+			}
+		}
+		catalog.Backups = append(catalog.Backups, backup)
+	})
+}
+
+// RemoveFromCatalog - shall be called after backup deletion
+func (bd *BackupDestination) RemoveFromCatalog(ctx context.Context, backupName string) error {
+	return bd.updateCatalog(ctx, func(catalog *Catalog) {
+		backups := make([]Backup, 0, len(catalog.Backups))
+		for _, backup := range catalog.Backups {
+			if backup.BackupName != backupName {
+				backups = append(backups, backup)
+			}
+		}
+		catalog.Backups = backups
+	})
+}
+
+// backupListFromCatalog - return false when catalog disabled or not exists yet
+func (bd *BackupDestination) backupListFromCatalog(ctx context.Context) ([]Backup, bool) {
+	if !bd.useCatalog {
+		return nil, false
+	}
+	catalogLock.Lock()
+	defer catalogLock.Unlock()
+	catalog, err := bd.loadCatalog(ctx)
+	if err != nil {
+		bd.Log.Warnf("can't load %s, will use full list: %v", CatalogFile, err)
+		return nil, false
+	}
+	if catalog == nil {
+		return nil, false
+	}
+	bd.Log.Debugf("%s load %d backups, updated at %s", CatalogFile, len(catalog.Backups), catalog.UpdatedAt.Format(time.RFC3339))
+	if isStale, err := bd.isCatalogStale(ctx, catalog); err != nil {
+		bd.Log.Warnf("can't check %s, will use full list: %v", CatalogFile, err)
+		return nil, false
+	} else if isStale {
+		bd.Log.Warnf("%s doesn't match backups on remote storage, will use full list and rebuild it", CatalogFile)
+		return nil, false
+	}
+	sortBackupList(catalog.Backups)
+	return catalog.Backups, true
+}
+
+// isCatalogStale - compare backup names in catalog with top level names on remote storage, one non-recursive list is much cheaper than GetFile for each metadata.json,
+// catalog could miss changes made by another host or by operation which was interrupted between upload and catalog update
+func (bd *BackupDestination) isCatalogStale(ctx context.Context, catalog *Catalog) (bool, error) {
+	catalogNames := make(map[string]bool, len(catalog.Backups))
+	for _, backup := range catalog.Backups {
+		catalogNames[backup.BackupName] = true
+	}
+	remoteNames := 0
+	isStale := false
+	err := bd.Walk(ctx, "/", false, func(ctx context.Context, o RemoteFile) error {
+		backupName := strings.Trim(o.Name(), "/")
+		if !isBackupListEntry(backupName) {
+			return nil
+		}
+		remoteNames++
+		if !catalogNames[backupName] {
+			isStale = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return isStale || remoteNames != len(catalogNames), nil
+}
+
+// rebuildCatalog - save full list of backups, which was received with parsed metadata.json
+func (bd *BackupDestination) rebuildCatalog(ctx context.Context, backups []Backup) error {
+	catalogLock.Lock()
+	defer catalogLock.Unlock()
+	catalog := &Catalog{Backups: make([]Backup, len(backups))}
+	copy(catalog.Backups, backups)
+	if err := bd.saveCatalog(ctx, catalog); err != nil {
+		return fmt.Errorf("can't save %s: %v", CatalogFile, err)
+	}
+	bd.Log.Infof("%s rebuilt with %d backups", CatalogFile, len(backups))
+	return nil
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type catalogTestFile struct {
+	name string
+	size int64
+}
+
+func (f catalogTestFile) Size() int64             { return f.size }
+func (f catalogTestFile) Name() string            { return f.name }
+func (f catalogTestFile) LastModified() time.Time { return time.Time{} }
+
+// catalogTestStorage - in-memory storage with generation per object, beforePut emulates another host which writes between read and put
+type catalogTestStorage struct {
+	RemoteStorage
+	files       map[string][]byte
+	generations map[string]int
+	beforePut   func()
+}
+
+func newCatalogTestStorage() *catalogTestStorage {
+	return &catalogTestStorage{files: map[string][]byte{}, generations: map[string]int{}}
+}
+
+func (s *catalogTestStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	body, exists := s.files[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return catalogTestFile{name: key, size: int64(len(body))}, nil
+}
+
+func (s *catalogTestStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, exists := s.files[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (s *catalogTestStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.files[key] = body
+	s.generations[key]++
+	return nil
+}
+
+func (s *catalogTestStorage) DeleteFile(ctx context.Context, key string) error {
+	delete(s.files, key)
+	return nil
+}
+
+func (s *catalogTestStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, RemoteFile) error) error {
+	names := map[string]bool{}
+	for key := range s.files {
+		name := key
+		if i := strings.Index(key, "/"); i >= 0 {
+			name = key[:i+1]
+		}
+		if !names[name] {
+			names[name] = true
+			if err := fn(ctx, catalogTestFile{name: name}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *catalogTestStorage) GetFileVersion(ctx context.Context, key string) (string, error) {
+	if _, exists := s.files[key]; !exists {
+		return "", ErrNotFound
+	}
+	return strconv.Itoa(s.generations[key]), nil
+}
+
+func (s *catalogTestStorage) PutFileIfVersion(ctx context.Context, key string, r io.ReadCloser, version string) error {
+	if s.beforePut != nil {
+		beforePut := s.beforePut
+		s.beforePut = nil
+		beforePut()
+	}
+	// current is empty when object doesn't exist
+	current, _ := s.GetFileVersion(ctx, key)
+	if current != version {
+		return ErrVersionMismatch
+	}
+	return s.PutFile(ctx, key, r)
+}
+
+func (s *catalogTestStorage) putCatalog(t *testing.T, backupNames ...string) {
+	catalog := Catalog{}
+	for _, backupName := range backupNames {
+		catalog.Backups = append(catalog.Backups, Backup{BackupMetadata: metadata.BackupMetadata{BackupName: backupName}})
+		s.files[backupName+"/metadata.json"] = []byte("{}")
+	}
+	body, err := json.Marshal(catalog)
+	require.NoError(t, err)
+	require.NoError(t, s.PutFile(context.Background(), CatalogFile, io.NopCloser(bytes.NewReader(body))))
+}
+
+func catalogBackupNames(t *testing.T, s *catalogTestStorage) []string {
+	catalog := Catalog{}
+	require.NoError(t, json.Unmarshal(s.files[CatalogFile], &catalog))
+	names := make([]string, 0, len(catalog.Backups))
+	for _, backup := range catalog.Backups {
+		names = append(names, backup.BackupName)
+	}
+	return names
+}
+
+func TestUpdateCatalogRetryWhenChangedByAnotherHost(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalogTestStorage()
+	s.putCatalog(t, "backup1")
+	bd := &BackupDestination{RemoteStorage: s, Log: apexLog.WithField("logger", "test"), useCatalog: true}
+	s.beforePut = func() {
+		anotherHost := &BackupDestination{RemoteStorage: s, Log: bd.Log, useCatalog: true}
+		require.NoError(t, anotherHost.AddToCatalog(ctx, Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup2"}}))
+	}
+	require.NoError(t, bd.AddToCatalog(ctx, Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup3"}}))
+	assert.ElementsMatch(t, []string{"backup1", "backup2", "backup3"}, catalogBackupNames(t, s))
+}
+
+func TestBackupListFromCatalogIgnoresStaleCatalog(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalogTestStorage()
+	s.putCatalog(t, "backup1", "backup2")
+	s.files["backup1.cluster.json"] = []byte("{}")
+	bd := &BackupDestination{RemoteStorage: s, Log: apexLog.WithField("logger", "test"), useCatalog: true}
+	backups, isCatalog := bd.backupListFromCatalog(ctx)
+	require.True(t, isCatalog)
+	assert.Len(t, backups, 2)
+
+	// uploaded by host which failed before catalog update
+	s.files["backup3/metadata.json"] = []byte("{}")
+	_, isCatalog = bd.backupListFromCatalog(ctx)
+	assert.False(t, isCatalog)
+
+	// deleted by another host
+	delete(s.files, "backup3/metadata.json")
+	delete(s.files, "backup2/metadata.json")
+	_, isCatalog = bd.backupListFromCatalog(ctx)
+	assert.False(t, isCatalog)
+}
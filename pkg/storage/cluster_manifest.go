@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+)
+
+// ClusterManifestSuffix - cluster level manifest stored in the root of remote storage as <backup_name>.cluster.json, next to backups of each replica
+const ClusterManifestSuffix = ".cluster.json"
+
+// PutClusterManifest - PutFile replace whole object, so `restore_cluster` never see partially written manifest
+func (bd *BackupDestination) PutClusterManifest(ctx context.Context, manifest metadata.ClusterBackupMetadata) error {
+	body, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return bd.PutFile(ctx, manifest.BackupName+ClusterManifestSuffix, io.NopCloser(bytes.NewReader(body)))
+}
+
+func (bd *BackupDestination) GetClusterManifest(ctx context.Context, backupName string) (*metadata.ClusterBackupMetadata, error) {
+	r, err := bd.GetFileReader(ctx, backupName+ClusterManifestSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s%s: %w", backupName, ClusterManifestSuffix, err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if err = r.Close(); err != nil {
+		return nil, err
+	}
+	manifest := &metadata.ClusterBackupMetadata{}
+	if err = json.Unmarshal(body, manifest); err != nil {
+		return nil, fmt.Errorf("can't parse %s%s: %v", backupName, ClusterManifestSuffix, err)
+	}
+	return manifest, nil
+}
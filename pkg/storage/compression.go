@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/klauspost/pgzip"
+	"github.com/mholt/archiver/v4"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionBlockSize - size of block which compressed independently by parallel compressors, look general->compression_concurrency
+const compressionBlockSize = 4 * 1024 * 1024
+
+// compressionLevels - levels which general->adaptive_compression_level switches one by one, zstd levels are mapped to 4 encoder levels by zstd.EncoderLevelFromZstd
+var compressionLevels = map[string][]int{
+	"gzip":   {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	"gz":     {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	"bzip2":  {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	"bz2":    {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	"br":     {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	"brotli": {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	"lz4":    {0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+	"zstd":   {1, 3, 6, 10},
+}
+
+// pgzipCompression - gzip with general->compression_concurrency blocks compressed in parallel
+type pgzipCompression struct {
+	archiver.Gz
+	concurrency int
+}
+
+func (gz pgzipCompression) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	level := gz.CompressionLevel
+	if level == 0 {
+		level = pgzip.DefaultCompression
+	}
+	wc, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	if err = wc.SetConcurrency(compressionBlockSize/4, gz.concurrency); err != nil {
+		return nil, err
+	}
+	return wc, nil
+}
+
+// lz4Compression - archiver.Lz4 casts level to lz4.CompressionLevel directly, so levels 1-9 are converted here
+type lz4Compression struct {
+	archiver.Lz4
+	concurrency int
+}
+
+func (lz lz4Compression) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	level := lz4.Fast
+	if lz.CompressionLevel > 0 {
+		level = lz4.CompressionLevel(1 << (8 + lz.CompressionLevel))
+	}
+	options := []lz4.Option{lz4.CompressionLevelOption(level)}
+	if lz.concurrency > 0 {
+		options = append(options, lz4.ConcurrencyOption(lz.concurrency))
+	}
+	wc := lz4.NewWriter(w)
+	if err := wc.Apply(options...); err != nil {
+		return nil, err
+	}
+	return wc, nil
+}
+
+// xzCompression - xz doesn't support parallel compression of one stream, each block is compressed into separate xz stream, xz.Reader reads concatenated streams as one
+type xzCompression struct {
+	archiver.Xz
+	concurrency int
+}
+
+func (x xzCompression) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	return newParallelBlockWriter(w, x.concurrency, func(dst io.Writer, block []byte) error {
+		wc, err := xz.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+		if _, err = wc.Write(block); err != nil {
+			return err
+		}
+		return wc.Close()
+	}), nil
+}
+
+type compressedBlock struct {
+	data *bytes.Buffer
+	err  error
+}
+
+// parallelBlockWriter - compress up to concurrency blocks at the same time, compressed blocks are written in original order
+type parallelBlockWriter struct {
+	w           io.Writer
+	concurrency int
+	compress    func(dst io.Writer, block []byte) error
+	block       []byte
+	inFlight    []chan compressedBlock
+}
+
+func newParallelBlockWriter(w io.Writer, concurrency int, compress func(dst io.Writer, block []byte) error) *parallelBlockWriter {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &parallelBlockWriter{
+		w:           w,
+		concurrency: concurrency,
+		compress:    compress,
+		block:       make([]byte, 0, compressionBlockSize),
+	}
+}
+
+func (p *parallelBlockWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		size := compressionBlockSize - len(p.block)
+		if size > len(data) {
+			size = len(data)
+		}
+		p.block = append(p.block, data[:size]...)
+		data = data[size:]
+		written += size
+		if len(p.block) == compressionBlockSize {
+			if err := p.flushBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (p *parallelBlockWriter) flushBlock() error {
+	if len(p.inFlight) == p.concurrency {
+		if err := p.writeOldestBlock(); err != nil {
+			return err
+		}
+	}
+	block := p.block
+	p.block = make([]byte, 0, compressionBlockSize)
+	result := make(chan compressedBlock, 1)
+	go func() {
+		compressed := &bytes.Buffer{}
+		err := p.compress(compressed, block)
+		result <- compressedBlock{data: compressed, err: err}
+	}()
+	p.inFlight = append(p.inFlight, result)
+	return nil
+}
+
+func (p *parallelBlockWriter) writeOldestBlock() error {
+	compressed := <-p.inFlight[0]
+	p.inFlight = p.inFlight[1:]
+	if compressed.err != nil {
+		return compressed.err
+	}
+	_, err := p.w.Write(compressed.data.Bytes())
+	return err
+}
+
+func (p *parallelBlockWriter) Close() error {
+	if len(p.block) > 0 {
+		if err := p.flushBlock(); err != nil {
+			return err
+		}
+	}
+	for len(p.inFlight) > 0 {
+		if err := p.writeOldestBlock(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adaptiveCompression - compression level shared by all archives uploaded with BackupDestination, when general->adaptive_compression_level is true
+// level decreases after archive which writer rarely waited for remote storage (CPU is bottleneck), and increases after archive which writer mostly waited (network is bottleneck)
+type adaptiveCompression struct {
+	mu          sync.Mutex
+	format      string
+	concurrency int
+	isAdaptive  bool
+	levels      []int
+	levelIdx    int
+	level       int
+	stats       metadata.CompressionStats
+}
+
+func newAdaptiveCompression(format string, level, concurrency int, isAdaptive bool) *adaptiveCompression {
+	c := &adaptiveCompression{
+		format:      format,
+		concurrency: concurrency,
+		levels:      compressionLevels[format],
+		level:       level,
+		stats:       metadata.CompressionStats{Format: format, Concurrency: concurrency, MinLevel: level, MaxLevel: level},
+	}
+	c.isAdaptive = isAdaptive && len(c.levels) > 0
+	if !c.isAdaptive {
+		return c
+	}
+	// start from the closest level to compression_level
+	for i, l := range c.levels {
+		if l <= level {
+			c.levelIdx = i
+		}
+	}
+	c.level = c.levels[c.levelIdx]
+	c.stats.MinLevel, c.stats.MaxLevel = c.level, c.level
+	return c
+}
+
+// Level - level for next archive of compression_format
+func (c *adaptiveCompression) Level() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.level
+}
+
+// Report - compressDuration contains reading of files and compression, networkWait is time when archive writer was blocked until remote storage read archive
+func (c *adaptiveCompression) Report(uncompressedBytes, compressedBytes int64, compressDuration, networkWait time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Archives++
+	c.stats.UncompressedBytes += uint64(uncompressedBytes)
+	c.stats.CompressedBytes += uint64(compressedBytes)
+	c.stats.CompressSeconds += compressDuration.Seconds()
+	c.stats.NetworkWaitSeconds += networkWait.Seconds()
+	if !c.isAdaptive || compressDuration+networkWait <= 0 {
+		return
+	}
+	networkShare := float64(networkWait) / float64(compressDuration+networkWait)
+	if networkShare > 0.5 && c.levelIdx < len(c.levels)-1 {
+		c.levelIdx++
+	} else if networkShare < 0.1 && c.levelIdx > 0 {
+		c.levelIdx--
+	} else {
+		return
+	}
+	c.level = c.levels[c.levelIdx]
+	c.stats.LevelChanges++
+	if c.level < c.stats.MinLevel {
+		c.stats.MinLevel = c.level
+	}
+	if c.level > c.stats.MaxLevel {
+		c.stats.MaxLevel = c.level
+	}
+}
+
+// Stats - nil when no archives compressed with compression_format
+func (c *adaptiveCompression) Stats() *metadata.CompressionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats.Archives == 0 {
+		return nil
+	}
+	stats := c.stats
+	stats.FinalLevel = c.level
+	return &stats
+}
+
+// timedWriter - count bytes and time blocked in Write, pipe writer blocks when upload to remote storage is slower than compression
+type timedWriter struct {
+	io.Writer
+	written int64
+	blocked time.Duration
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.Writer.Write(p)
+	t.blocked += time.Since(start)
+	t.written += int64(n)
+	return n, err
+}
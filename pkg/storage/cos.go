@@ -17,8 +17,9 @@ import (
 )
 
 type COS struct {
-	client *cos.Client
-	Config *config.COSConfig
+	client   *cos.Client
+	Config   *config.COSConfig
+	HTTPPool HTTPPool
 }
 
 func (c *COS) Kind() string {
@@ -47,6 +48,7 @@ func (c *COS) Connect(ctx context.Context) error {
 				RequestBody:    false,
 				ResponseHeader: c.Config.Debug,
 				ResponseBody:   false,
+				Transport:      c.HTTPPool.newTransport(false),
 			},
 		},
 	})
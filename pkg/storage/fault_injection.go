@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+)
+
+// ErrFaultInjected - returned by storage operations when general->fault_injection_error_rate triggered, looks like retryable server error
+var ErrFaultInjected = errors.New("fault injection: 503 Service Unavailable")
+
+// InjectedFaults - counter of injected errors, slow and truncated streams
+var InjectedFaults atomic.Int64
+
+// faultInjectionStorage - wrap RemoteStorage for staging tests, fail operations, slow down and truncate streams with configured probability
+type faultInjectionStorage struct {
+	RemoteStorage
+	errorRate    float64
+	slowRate     float64
+	slowDelay    time.Duration
+	truncateRate float64
+	random       func() float64
+}
+
+func newFaultInjectionStorage(remoteStorage RemoteStorage, cfg config.GeneralConfig) *faultInjectionStorage {
+	return &faultInjectionStorage{
+		RemoteStorage: remoteStorage,
+		errorRate:     cfg.FaultInjectionErrorRate,
+		slowRate:      cfg.FaultInjectionSlowRate,
+		slowDelay:     cfg.FaultInjectionSlowDelay,
+		truncateRate:  cfg.FaultInjectionTruncateRate,
+		random:        rand.Float64,
+	}
+}
+
+// IsFaultInjectionEnabled - any of general->fault_injection_* options enabled
+func IsFaultInjectionEnabled(cfg config.GeneralConfig) bool {
+	return cfg.FaultInjectionErrorRate > 0 || (cfg.FaultInjectionSlowRate > 0 && cfg.FaultInjectionSlowDelay > 0) || cfg.FaultInjectionTruncateRate > 0
+}
+
+func (f *faultInjectionStorage) injectError(operation, key string) error {
+	if f.errorRate > 0 && f.random() < f.errorRate {
+		InjectedFaults.Add(1)
+		return fmt.Errorf("%s %s: %w", operation, key, ErrFaultInjected)
+	}
+	return nil
+}
+
+// wrapReader - slow stream sleeps before each read, truncated stream returns io.EOF after random part of data, to check verification of downloaded data
+func (f *faultInjectionStorage) wrapReader(r io.ReadCloser, allowTruncate bool) io.ReadCloser {
+	slow := f.slowRate > 0 && f.slowDelay > 0 && f.random() < f.slowRate
+	truncateAfter := int64(-1)
+	if allowTruncate && f.truncateRate > 0 && f.random() < f.truncateRate {
+		truncateAfter = int64(f.random() * 1024 * 1024)
+	}
+	if !slow && truncateAfter < 0 {
+		return r
+	}
+	InjectedFaults.Add(1)
+	return &faultInjectionReader{ReadCloser: r, slow: slow, slowDelay: f.slowDelay, truncateAfter: truncateAfter}
+}
+
+type faultInjectionReader struct {
+	io.ReadCloser
+	slow          bool
+	slowDelay     time.Duration
+	truncateAfter int64
+	read          int64
+}
+
+func (r *faultInjectionReader) Read(p []byte) (int, error) {
+	if r.slow {
+		time.Sleep(r.slowDelay)
+	}
+	if r.truncateAfter >= 0 {
+		if r.read >= r.truncateAfter {
+			return 0, io.EOF
+		}
+		if int64(len(p)) > r.truncateAfter-r.read {
+			p = p[:r.truncateAfter-r.read]
+		}
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func (f *faultInjectionStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	if err := f.injectError("StatFile", key); err != nil {
+		return nil, err
+	}
+	return f.RemoteStorage.StatFile(ctx, key)
+}
+
+func (f *faultInjectionStorage) DeleteFile(ctx context.Context, key string) error {
+	if err := f.injectError("DeleteFile", key); err != nil {
+		return err
+	}
+	return f.RemoteStorage.DeleteFile(ctx, key)
+}
+
+func (f *faultInjectionStorage) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) error {
+	if err := f.injectError("DeleteFileFromObjectDiskBackup", key); err != nil {
+		return err
+	}
+	return f.RemoteStorage.DeleteFileFromObjectDiskBackup(ctx, key)
+}
+
+func (f *faultInjectionStorage) Walk(ctx context.Context, prefix string, recursive bool, fn func(context.Context, RemoteFile) error) error {
+	if err := f.injectError("Walk", prefix); err != nil {
+		return err
+	}
+	return f.RemoteStorage.Walk(ctx, prefix, recursive, fn)
+}
+
+func (f *faultInjectionStorage) WalkAbsolute(ctx context.Context, absolutePrefix string, recursive bool, fn func(context.Context, RemoteFile) error) error {
+	if err := f.injectError("WalkAbsolute", absolutePrefix); err != nil {
+		return err
+	}
+	return f.RemoteStorage.WalkAbsolute(ctx, absolutePrefix, recursive, fn)
+}
+
+func (f *faultInjectionStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := f.injectError("GetFileReader", key); err != nil {
+		return nil, err
+	}
+	r, err := f.RemoteStorage.GetFileReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapReader(r, true), nil
+}
+
+func (f *faultInjectionStorage) GetFileReaderAbsolute(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := f.injectError("GetFileReaderAbsolute", key); err != nil {
+		return nil, err
+	}
+	r, err := f.RemoteStorage.GetFileReaderAbsolute(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapReader(r, true), nil
+}
+
+func (f *faultInjectionStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	if err := f.injectError("GetFileReaderWithLocalPath", key); err != nil {
+		return nil, err
+	}
+	r, err := f.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapReader(r, true), nil
+}
+
+// PutFile - upload stream is never truncated, cause storage will save truncated object without error, only slow down
+func (f *faultInjectionStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	if err := f.injectError("PutFile", key); err != nil {
+		return err
+	}
+	return f.RemoteStorage.PutFile(ctx, key, f.wrapReader(r, false))
+}
+
+// PutFileWithSize - keep part size calculation of wrapped storage, look sizedFilePutter
+func (f *faultInjectionStorage) PutFileWithSize(ctx context.Context, key string, r io.ReadCloser, expectedSize int64) error {
+	if err := f.injectError("PutFile", key); err != nil {
+		return err
+	}
+	if putter, isPutter := f.RemoteStorage.(sizedFilePutter); isPutter {
+		return putter.PutFileWithSize(ctx, key, f.wrapReader(r, false), expectedSize)
+	}
+	return f.RemoteStorage.PutFile(ctx, key, f.wrapReader(r, false))
+}
+
+func (f *faultInjectionStorage) PutFileAbsolute(ctx context.Context, key string, r io.ReadCloser) error {
+	if err := f.injectError("PutFileAbsolute", key); err != nil {
+		return err
+	}
+	return f.RemoteStorage.PutFileAbsolute(ctx, key, f.wrapReader(r, false))
+}
+
+func (f *faultInjectionStorage) CopyObject(ctx context.Context, srcSize int64, srcBucket, srcKey, dstKey string) (int64, error) {
+	if err := f.injectError("CopyObject", dstKey); err != nil {
+		return 0, err
+	}
+	return f.RemoteStorage.CopyObject(ctx, srcSize, srcBucket, srcKey, dstKey)
+}
+
+// ListIncompleteUploads - look IncompleteUploadsCleaner, wrapped storage without multipart uploads returns nothing
+func (f *faultInjectionStorage) ListIncompleteUploads(ctx context.Context) ([]IncompleteUpload, error) {
+	if err := f.injectError("ListIncompleteUploads", ""); err != nil {
+		return nil, err
+	}
+	if cleaner, isCleaner := f.RemoteStorage.(IncompleteUploadsCleaner); isCleaner {
+		return cleaner.ListIncompleteUploads(ctx)
+	}
+	return nil, nil
+}
+
+func (f *faultInjectionStorage) AbortIncompleteUpload(ctx context.Context, upload IncompleteUpload) error {
+	if err := f.injectError("AbortIncompleteUpload", upload.Key); err != nil {
+		return err
+	}
+	if cleaner, isCleaner := f.RemoteStorage.(IncompleteUploadsCleaner); isCleaner {
+		return cleaner.AbortIncompleteUpload(ctx, upload)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type faultInjectionTestStorage struct {
+	RemoteStorage
+}
+
+func (s *faultInjectionTestStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(strings.Repeat("x", 100))), nil
+}
+
+func (s *faultInjectionTestStorage) DeleteFile(ctx context.Context, key string) error {
+	return nil
+}
+
+func TestFaultInjectionStorage(t *testing.T) {
+	assert.False(t, IsFaultInjectionEnabled(config.GeneralConfig{}))
+	assert.False(t, IsFaultInjectionEnabled(config.GeneralConfig{FaultInjectionSlowRate: 0.5}))
+	assert.True(t, IsFaultInjectionEnabled(config.GeneralConfig{FaultInjectionTruncateRate: 0.1}))
+
+	randomValues := make([]float64, 0)
+	f := newFaultInjectionStorage(&faultInjectionTestStorage{}, config.GeneralConfig{FaultInjectionErrorRate: 0.5, FaultInjectionTruncateRate: 0.5})
+	f.random = func() float64 {
+		value := randomValues[0]
+		randomValues = randomValues[1:]
+		return value
+	}
+	randomValues = append(randomValues, 0.1)
+	err := f.DeleteFile(context.Background(), "backup/metadata.json")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrFaultInjected))
+	randomValues = append(randomValues, 0.9)
+	assert.NoError(t, f.DeleteFile(context.Background(), "backup/metadata.json"))
+
+	// no error, truncate after 10 bytes
+	randomValues = append(randomValues, 0.9, 0.1, 10.0/1024/1024)
+	r, err := f.GetFileReader(context.Background(), "backup/shadow/default.tar")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Len(t, data, 10)
+
+	// no error, no truncate
+	randomValues = append(randomValues, 0.9, 0.9)
+	r, err = f.GetFileReader(context.Background(), "backup/shadow/default.tar")
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Len(t, data, 100)
+}
@@ -2,17 +2,17 @@ package storage
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
@@ -29,6 +29,7 @@ import (
 type GCS struct {
 	client     *storage.Client
 	Config     *config.GCSConfig
+	HTTPPool   HTTPPool
 	clientPool *pool.ObjectPool
 }
 
@@ -104,62 +105,35 @@ func (gcs *GCS) Connect(ctx context.Context) error {
 		clientOptions = append(clientOptions, option.WithoutAuthentication())
 	}
 
+	// These clientOptions are passed in by storage.NewClient. However, to set a custom HTTP client
+	// we must pass all these in manually.
+	if gcs.Config.Endpoint == "" {
+		clientOptions = append([]option.ClientOption{option.WithScopes(storage.ScopeFullControl)}, clientOptions...)
+	}
+	clientOptions = append(clientOptions, internaloption.WithDefaultEndpoint(endpoint))
+	if !gcs.Config.ForceHttp && strings.HasPrefix(endpoint, "https://") {
+		clientOptions = append(clientOptions, internaloption.WithDefaultMTLSEndpoint(endpoint))
+	}
+
+	// all clients in clientPool share one transport, so connections are reused between objects
+	httpTransport := gcs.HTTPPool.newTransport(false)
+	var baseTransport http.RoundTripper = httpTransport
 	if gcs.Config.ForceHttp {
-		customTransport := &http.Transport{
-			WriteBufferSize: 128 * 1024,
-			Proxy:           http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			MaxIdleConns:          1,
-			MaxIdleConnsPerHost:   1,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		}
+		httpTransport.WriteBufferSize = 128 * 1024
 		// must set ForceAttemptHTTP2 to false so that when a custom TLSClientConfig
 		// is provided Golang does not setup HTTP/2 transport
-		customTransport.ForceAttemptHTTP2 = false
-		customTransport.TLSClientConfig = &tls.Config{
-			NextProtos: []string{"http/1.1"},
-		}
-		// These clientOptions are passed in by storage.NewClient. However, to set a custom HTTP client
-		// we must pass all these in manually.
-
-		if gcs.Config.Endpoint == "" {
-			clientOptions = append([]option.ClientOption{option.WithScopes(storage.ScopeFullControl)}, clientOptions...)
-		}
-		clientOptions = append(clientOptions, internaloption.WithDefaultEndpoint(endpoint))
-
-		customRoundTripper := &rewriteTransport{base: customTransport}
-		gcpTransport, _, err := googleHTTPTransport.NewClient(ctx, clientOptions...)
-		transport, err := googleHTTPTransport.NewTransport(ctx, customRoundTripper, clientOptions...)
-		gcpTransport.Transport = transport
-		if err != nil {
-			return fmt.Errorf("failed to create GCP transport: %v", err)
-		}
-
-		clientOptions = append(clientOptions, option.WithHTTPClient(gcpTransport))
-
+		httpTransport.ForceAttemptHTTP2 = false
+		httpTransport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		baseTransport = &rewriteTransport{base: httpTransport}
+	}
+	gcpTransport, err := googleHTTPTransport.NewTransport(ctx, baseTransport, clientOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP transport: %v", err)
 	}
-
 	if gcs.Config.Debug {
-		if gcs.Config.Endpoint == "" {
-			clientOptions = append([]option.ClientOption{option.WithScopes(storage.ScopeFullControl)}, clientOptions...)
-		}
-		clientOptions = append(clientOptions, internaloption.WithDefaultEndpoint(endpoint))
-		if strings.HasPrefix(endpoint, "https://") {
-			clientOptions = append(clientOptions, internaloption.WithDefaultMTLSEndpoint(endpoint))
-		}
-
-		debugClient, _, err := googleHTTPTransport.NewClient(ctx, clientOptions...)
-		if err != nil {
-			return fmt.Errorf("googleHTTPTransport.NewClient error: %v", err)
-		}
-		debugClient.Transport = debugGCSTransport{base: debugClient.Transport}
-		clientOptions = append(clientOptions, option.WithHTTPClient(debugClient))
+		gcpTransport = debugGCSTransport{base: gcpTransport}
 	}
+	clientOptions = append(clientOptions, option.WithHTTPClient(&http.Client{Transport: gcpTransport}))
 
 	factory := pool.NewPooledObjectFactorySimple(
 		func(context.Context) (interface{}, error) {
@@ -306,6 +280,45 @@ func (gcs *GCS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	}, nil
 }
 
+// GetFileVersion - implements ConditionalPutter, return object generation
+func (gcs *GCS) GetFileVersion(ctx context.Context, key string) (string, error) {
+	objAttr, err := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key)).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return strconv.FormatInt(objAttr.Generation, 10), nil
+}
+
+// PutFileIfVersion - implements ConditionalPutter, https://cloud.google.com/storage/docs/request-preconditions
+func (gcs *GCS) PutFileIfVersion(ctx context.Context, key string, r io.ReadCloser, version string) error {
+	conditions := storage.Conditions{DoesNotExist: true}
+	if version != "" {
+		generation, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return fmt.Errorf("wrong generation %s: %v", version, err)
+		}
+		conditions = storage.Conditions{GenerationMatch: generation}
+	}
+	writer := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key)).If(conditions).NewWriter(ctx)
+	writer.StorageClass = gcs.Config.StorageClass
+	if len(gcs.Config.ObjectLabels) > 0 {
+		writer.Metadata = gcs.Config.ObjectLabels
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	err := writer.Close()
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) && gcsErr.Code == http.StatusPreconditionFailed {
+		return ErrVersionMismatch
+	}
+	return err
+}
+
 func (gcs *GCS) deleteKey(ctx context.Context, key string) error {
 	pClientObj, err := gcs.clientPool.BorrowObject(ctx)
 	if err != nil {
@@ -338,7 +351,16 @@ func (gcs *GCS) DeleteFileFromObjectDiskBackup(ctx context.Context, key string)
 }
 
 func (gcs *GCS) CopyObject(ctx context.Context, srcSize int64, srcBucket, srcKey, dstKey string) (int64, error) {
-	dstKey = path.Join(gcs.Config.ObjectDiskPath, dstKey)
+	return gcs.copyObject(ctx, srcBucket, srcKey, path.Join(gcs.Config.ObjectDiskPath, dstKey))
+}
+
+// CopyFile - server side copy inside gcs->path, look BackupDestination.CopyFile
+func (gcs *GCS) CopyFile(ctx context.Context, srcSize int64, srcKey, dstKey string) error {
+	_, err := gcs.copyObject(ctx, gcs.Config.Bucket, path.Join(gcs.Config.Path, srcKey), path.Join(gcs.Config.Path, dstKey))
+	return err
+}
+
+func (gcs *GCS) copyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
 	apexLog.Debugf("GCS->CopyObject %s/%s -> %s/%s", srcBucket, srcKey, gcs.Config.Bucket, dstKey)
 	pClientObj, err := gcs.clientPool.BorrowObject(ctx)
 	if err != nil {
@@ -2,12 +2,13 @@ package storage
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/clickhouse"
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
-	"github.com/eapache/go-resiliency/retrier"
 	"io"
 	"os"
 	"path"
@@ -45,7 +46,7 @@ type Backup struct {
 }
 
 func (b *Backup) GetFullSize() uint64 {
-	return b.DataSize + b.MetadataSize + b.ConfigSize + b.RBACSize
+	return b.DataSize + b.MetadataSize + b.ConfigSize + b.RBACSize + b.KeeperSize
 }
 
 type BackupDestination struct {
@@ -53,11 +54,59 @@ type BackupDestination struct {
 	Log               *apexLog.Entry
 	compressionFormat string
 	compressionLevel  int
+	useCatalog        bool
+	metadataCacheTTL  time.Duration
+	// stalledStreamTimeout - abort upload stream without progress, to retry it with new connection
+	stalledStreamTimeout time.Duration
+	// stagingPath - general->staging_path, temporary archives are downloaded here instead of directory where they are extracted
+	stagingPath string
+	// compression - level and statistics of compression_format archives, look general->adaptive_compression_level
+	compression *adaptiveCompression
+}
+
+// metadataCacheEntry - MetadataFileSize and MetadataModified validate cached metadata.json after metadataCacheTTL expiration
+type metadataCacheEntry struct {
+	Backup
+	MetadataFileSize int64     `json:"metadata_file_size"`
+	MetadataModified time.Time `json:"metadata_modified"`
+	CachedAt         time.Time `json:"cached_at"`
 }
 
 var metadataCacheLock sync.RWMutex
 
+// ErrBackupLocked - returned when backup objects protected by object lock retention or legal hold
+var ErrBackupLocked = errors.New("backup is locked on remote storage")
+
+// CheckBackupLock - return ErrBackupLocked when metadata.json of backup can't be deleted, metadata.json uploads last, so it has the longest retention
+func (bd *BackupDestination) CheckBackupLock(ctx context.Context, backupName string) error {
+	locker, isLocker := bd.RemoteStorage.(ObjectLocker)
+	if !isLocker {
+		return nil
+	}
+	lock, err := locker.GetObjectLock(ctx, path.Join(backupName, "metadata.json"))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("can't get object lock for %s: %v", backupName, err)
+	}
+	if lock.IsLocked() {
+		return fmt.Errorf("%s %w, %s", backupName, ErrBackupLocked, lock.String())
+	}
+	return nil
+}
+
 func (bd *BackupDestination) RemoveBackupRemote(ctx context.Context, backup Backup) error {
+	if err := bd.removeBackupFiles(ctx, backup); err != nil {
+		return err
+	}
+	if err := bd.RemoveFromCatalog(ctx, backup.BackupName); err != nil {
+		bd.Log.Warnf("RemoveBackupRemote %v", err)
+	}
+	return nil
+}
+
+func (bd *BackupDestination) removeBackupFiles(ctx context.Context, backup Backup) error {
 	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" {
 		return bd.DeleteFile(ctx, backup.BackupName)
 	}
@@ -73,9 +122,13 @@ func (bd *BackupDestination) RemoveBackupRemote(ctx context.Context, backup Back
 	})
 }
 
-func (bd *BackupDestination) loadMetadataCache(ctx context.Context) (map[string]Backup, error) {
+func (bd *BackupDestination) loadMetadataCache(ctx context.Context) (map[string]metadataCacheEntry, error) {
 	listCacheFile := path.Join(os.TempDir(), fmt.Sprintf(".clickhouse-backup-metadata.cache.%s", bd.Kind()))
-	listCache := map[string]Backup{}
+	listCache := map[string]metadataCacheEntry{}
+	if bd.metadataCacheTTL <= 0 {
+		bd.Log.Debugf("metadata cache disabled, skip load %s", listCacheFile)
+		return listCache, nil
+	}
 	if info, err := os.Stat(listCacheFile); os.IsNotExist(err) || info.IsDir() {
 		bd.Log.Debugf("%s not found, load %d elements", listCacheFile, len(listCache))
 		return listCache, nil
@@ -101,7 +154,7 @@ func (bd *BackupDestination) loadMetadataCache(ctx context.Context) (map[string]
 		}
 		if string(body) != "" {
 			if err := json.Unmarshal(body, &listCache); err != nil {
-				bd.Log.Fatalf("can't parse %s to map[string]Backup\n\n%s\n\nreturn error %v", listCacheFile, body, err)
+				bd.Log.Fatalf("can't parse %s to map[string]metadataCacheEntry\n\n%s\n\nreturn error %v", listCacheFile, body, err)
 			}
 		}
 		bd.Log.Debugf("%s load %d elements", listCacheFile, len(listCache))
@@ -109,7 +162,7 @@ func (bd *BackupDestination) loadMetadataCache(ctx context.Context) (map[string]
 	}
 }
 
-func (bd *BackupDestination) saveMetadataCache(ctx context.Context, listCache map[string]Backup, actualList []Backup) error {
+func (bd *BackupDestination) saveMetadataCache(ctx context.Context, listCache map[string]metadataCacheEntry, actualList []Backup) error {
 	listCacheFile := path.Join(os.TempDir(), fmt.Sprintf(".clickhouse-backup-metadata.cache.%s", bd.Kind()))
 	f, err := os.OpenFile(listCacheFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
@@ -157,7 +210,49 @@ func (bd *BackupDestination) saveMetadataCache(ctx context.Context, listCache ma
 	}
 }
 
+// RemoveFromMetadataCache - shall be called after metadata.json re-upload, otherwise BackupList returns old metadata until metadataCacheTTL expiration
+func (bd *BackupDestination) RemoveFromMetadataCache(ctx context.Context, backupName string) error {
+	metadataCacheLock.Lock()
+	defer metadataCacheLock.Unlock()
+	listCache, err := bd.loadMetadataCache(ctx)
+	if err != nil {
+		return err
+	}
+	if _, isCached := listCache[backupName]; !isCached {
+		return nil
+	}
+	delete(listCache, backupName)
+	actualList := make([]Backup, 0, len(listCache))
+	for _, cachedMetadata := range listCache {
+		actualList = append(actualList, cachedMetadata.Backup)
+	}
+	return bd.saveMetadataCache(ctx, listCache, actualList)
+}
+
 func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool, parseMetadataOnly string) ([]Backup, error) {
+	if backupList, isCatalog := bd.backupListFromCatalog(ctx); isCatalog {
+		return backupList, nil
+	}
+	// catalog shall contain parsed metadata.json for all backups
+	rebuildCatalog := bd.useCatalog
+	if rebuildCatalog {
+		parseMetadata = true
+		parseMetadataOnly = ""
+	}
+	return bd.listBackups(ctx, parseMetadata, parseMetadataOnly, rebuildCatalog)
+}
+
+// BackupListWithoutCatalog - list `<backup>/metadata.json` directly, catalog.json could be stale, so operations which delete data shall not trust it
+func (bd *BackupDestination) BackupListWithoutCatalog(ctx context.Context, parseMetadata bool, parseMetadataOnly string) ([]Backup, error) {
+	return bd.listBackups(ctx, parseMetadata, parseMetadataOnly, false)
+}
+
+// isBackupListEntry - false for top level names on remote storage which are not backups
+func isBackupListEntry(name string) bool {
+	return name != CatalogFile && name != AuditLogDir && !strings.HasSuffix(name, ClusterManifestSuffix)
+}
+
+func (bd *BackupDestination) listBackups(ctx context.Context, parseMetadata bool, parseMetadataOnly string, rebuildCatalog bool) ([]Backup, error) {
 	result := make([]Backup, 0)
 	metadataCacheLock.Lock()
 	defer metadataCacheLock.Unlock()
@@ -167,9 +262,12 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 	}
 	err = bd.Walk(ctx, "/", false, func(ctx context.Context, o RemoteFile) error {
 		backupName := strings.Trim(o.Name(), "/")
+		if !isBackupListEntry(backupName) {
+			return nil
+		}
 		if !parseMetadata || (parseMetadataOnly != "" && parseMetadataOnly != backupName) {
 			if cachedMetadata, isCached := listCache[backupName]; isCached {
-				result = append(result, cachedMetadata)
+				result = append(result, cachedMetadata.Backup)
 			} else {
 				result = append(result, Backup{
 					BackupMetadata: metadata.BackupMetadata{
@@ -179,8 +277,9 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 			}
 			return nil
 		}
-		if cachedMetadata, isCached := listCache[backupName]; isCached {
-			result = append(result, cachedMetadata)
+		cachedMetadata, isCached := listCache[backupName]
+		if isCached && time.Since(cachedMetadata.CachedAt) < bd.metadataCacheTTL {
+			result = append(result, cachedMetadata.Backup)
 			return nil
 		}
 		mf, err := bd.StatFile(ctx, path.Join(o.Name(), "metadata.json"))
@@ -196,6 +295,13 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 			result = append(result, brokenBackup)
 			return nil
 		}
+		// expired cache entry is still valid when metadata.json was not changed
+		if isCached && cachedMetadata.MetadataFileSize == mf.Size() && cachedMetadata.MetadataModified.Equal(mf.LastModified()) {
+			cachedMetadata.CachedAt = time.Now()
+			listCache[backupName] = cachedMetadata
+			result = append(result, cachedMetadata.Backup)
+			return nil
+		}
 		r, err := bd.GetFileReader(ctx, path.Join(o.Name(), "metadata.json"))
 		if err != nil {
 			brokenBackup := Backup{
@@ -238,38 +344,70 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 			result = append(result, brokenBackup)
 			return nil
 		}
-		goodBackup := Backup{m, "", "", mf.LastModified()}
-		listCache[backupName] = goodBackup
+		uploadDate := mf.LastModified()
+		// metadata.json was re-uploaded by retention, keep the first upload date for retention and `list remote`
+		if m.OriginalUploadDate != nil {
+			uploadDate = *m.OriginalUploadDate
+		}
+		goodBackup := Backup{m, "", "", uploadDate}
+		listCache[backupName] = metadataCacheEntry{Backup: goodBackup, MetadataFileSize: mf.Size(), MetadataModified: mf.LastModified(), CachedAt: time.Now()}
 		result = append(result, goodBackup)
 		return nil
 	})
 	if err != nil {
 		bd.Log.Warnf("BackupList bd.Walk return error: %v", err)
 	}
-	// sort by name for the same not parsed metadata.json
-	sort.SliceStable(result, func(i, j int) bool {
-		return result[i].BackupName < result[j].BackupName
-	})
-	sort.SliceStable(result, func(i, j int) bool {
-		return result[i].UploadDate.Before(result[j].UploadDate)
-	})
+	sortBackupList(result)
+	if rebuildCatalog && err == nil {
+		if rebuildErr := bd.rebuildCatalog(ctx, result); rebuildErr != nil {
+			bd.Log.Warnf("BackupList %v", rebuildErr)
+		}
+	}
 	if err = bd.saveMetadataCache(ctx, listCache, result); err != nil {
 		return nil, fmt.Errorf("bd.saveMetadataCache return error: %v", err)
 	}
 	return result, nil
 }
 
-func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remotePath string, localPath string, maxSpeed uint64) error {
+// sortBackupList - sort by name for the same not parsed metadata.json, and by upload date
+func sortBackupList(backupList []Backup) {
+	sort.SliceStable(backupList, func(i, j int) bool {
+		return backupList[i].BackupName < backupList[j].BackupName
+	})
+	sort.SliceStable(backupList, func(i, j int) bool {
+		return backupList[i].UploadDate.Before(backupList[j].UploadDate)
+	})
+}
+
+// GetStagingPath - directory for temporary archive of remotePath, layout of remote backup is kept inside general->staging_path,
+// so `<staging_path>/<backup_name>` contains only files of one backup and could be removed after download
+func (bd *BackupDestination) GetStagingPath(remotePath, localPath string) (string, error) {
+	if bd.stagingPath == "" {
+		return localPath, nil
+	}
+	stagingPath := path.Join(bd.stagingPath, path.Dir(remotePath))
+	if err := os.MkdirAll(stagingPath, 0750); err != nil {
+		return "", fmt.Errorf("can't create staging directory: %v", err)
+	}
+	return stagingPath, nil
+}
+
+// DownloadCompressedStream - extract remote archive to localPath, dictionaries used only for zstd archives compressed with shared dictionary
+func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remotePath string, localPath string, maxSpeed uint64, dictionaries [][]byte) error {
 	if err := os.MkdirAll(localPath, 0750); err != nil {
 		return err
 	}
+	stagingPath, err := bd.GetStagingPath(remotePath, localPath)
+	if err != nil {
+		return err
+	}
 	// get this first as GetFileReader blocks the ftp control channel
 	remoteFileInfo, err := bd.StatFile(ctx, remotePath)
 	if err != nil {
 		return err
 	}
 	startTime := time.Now()
-	reader, err := bd.GetFileReaderWithLocalPath(ctx, remotePath, localPath)
+	reader, err := bd.GetFileReaderWithLocalPath(ctx, remotePath, stagingPath)
 	if err != nil {
 		return err
 	}
@@ -285,15 +423,26 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 			}
 		}
 	}()
+	if err = bd.ExtractArchive(ctx, reader, remotePath, localPath, dictionaries); err != nil {
+		return err
+	}
+	bd.throttleSpeed(startTime, remoteFileInfo.Size(), maxSpeed)
+	return nil
+}
 
+// ExtractArchive - compression format detected by remotePath extension
+func (bd *BackupDestination) ExtractArchive(ctx context.Context, reader io.Reader, remotePath string, localPath string, dictionaries [][]byte) error {
 	buf := buffer.New(BufferSize)
 	bufReader := nio.NewReader(reader, buf)
 	compressionFormat := bd.compressionFormat
 	if !checkArchiveExtension(path.Ext(remotePath), compressionFormat) {
-		bd.Log.Warnf("remote file backup extension %s not equal with %s", remotePath, compressionFormat)
+		// general->table_compression_format uploads some tables as tar archives
+		if path.Ext(remotePath) != ".tar" {
+			bd.Log.Warnf("remote file backup extension %s not equal with %s", remotePath, compressionFormat)
+		}
 		compressionFormat = strings.Replace(path.Ext(remotePath), ".", "", -1)
 	}
-	z, err := getArchiveReader(compressionFormat)
+	z, err := getArchiveReader(compressionFormat, dictionaries)
 	if err != nil {
 		return err
 	}
@@ -336,11 +485,45 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 	}); err != nil {
 		return err
 	}
-	bd.throttleSpeed(startTime, remoteFileInfo.Size(), maxSpeed)
 	return nil
 }
 
-func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLocalPath string, files []string, remotePath string, maxSpeed uint64) error {
+// EstimateCompressedSize - compress sample with compression_format and compression_level, scale size with the same ratio
+func (bd *BackupDestination) EstimateCompressedSize(size int64, sample []byte) (int64, error) {
+	if bd.compressionFormat == "none" || len(sample) == 0 {
+		return size, nil
+	}
+	archive, err := getArchiveWriter(bd.compressionFormat, bd.compressionLevel, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	if archive.Compression == nil {
+		return size, nil
+	}
+	compressed := &bytes.Buffer{}
+	w, err := archive.Compression.OpenWriter(compressed)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = w.Write(sample); err != nil {
+		return 0, err
+	}
+	if err = w.Close(); err != nil {
+		return 0, err
+	}
+	return int64(float64(size) * float64(compressed.Len()) / float64(len(sample))), nil
+}
+
+// UploadCompressedStream - archive files and upload to remotePath, archive is written into pipe and never stored in temporary file,
+// compressionFormat could be different from compression_format for tables from general->table_compression_format, non-empty dictionary applies only to zstd
+// UploadCompressedStream - tee receives a copy of uploaded archive when it is not nil
+func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLocalPath string, files []string, remotePath string, compressionFormat string, maxSpeed uint64, dictionary []byte, tee io.Writer) error {
+	return watchStall(ctx, bd.stalledStreamTimeout, &StalledUploads, func(ctx context.Context, trackProgress func(io.ReadCloser) io.ReadCloser) error {
+		return bd.uploadCompressedStream(ctx, trackProgress, baseLocalPath, files, remotePath, compressionFormat, maxSpeed, dictionary, tee)
+	})
+}
+
+func (bd *BackupDestination) uploadCompressedStream(ctx context.Context, trackProgress func(io.ReadCloser) io.ReadCloser, baseLocalPath string, files []string, remotePath string, compressionFormat string, maxSpeed uint64, dictionary []byte, tee io.Writer) error {
 	var totalBytes int64
 	for _, filename := range files {
 		fInfo, err := os.Stat(path.Join(baseLocalPath, filename))
@@ -368,7 +551,13 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 				}
 			}
 		}()
-		z, err := getArchiveWriter(bd.compressionFormat, bd.compressionLevel)
+		// general->adaptive_compression_level changes level only for compression_format, table_compression_format is always tar or none
+		level := bd.compressionLevel
+		isReported := compressionFormat == bd.compressionFormat && compressionFormat != "tar"
+		if isReported {
+			level = bd.compression.Level()
+		}
+		z, err := getArchiveWriter(compressionFormat, level, bd.compression.concurrency, dictionary)
 		if err != nil {
 			return err
 		}
@@ -393,9 +582,18 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 			archiveFiles = append(archiveFiles, file)
 			//bd.Log.Debugf("add %s to archive %s", filePath, remotePath)
 		}
-		if writerErr = z.Archive(ctx, w, archiveFiles); writerErr != nil {
+		tw := &timedWriter{Writer: w}
+		var archiveWriter io.Writer = tw
+		if tee != nil {
+			archiveWriter = io.MultiWriter(tw, tee)
+		}
+		archiveStart := time.Now()
+		if writerErr = z.Archive(ctx, archiveWriter, archiveFiles); writerErr != nil {
 			return writerErr
 		}
+		if isReported {
+			bd.compression.Report(totalBytes, tw.written, time.Since(archiveStart)-tw.blocked, tw.blocked)
+		}
 		return nil
 	})
 	g.Go(func() error {
@@ -410,7 +608,7 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 				}
 			}
 		}()
-		readerErr = bd.PutFile(ctx, remotePath, body)
+		readerErr = bd.putFileWithSize(ctx, remotePath, trackProgress(body), totalBytes)
 		return readerErr
 	})
 	if waitErr := g.Wait(); waitErr != nil {
@@ -420,7 +618,50 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 	return nil
 }
 
-func (bd *BackupDestination) DownloadPath(ctx context.Context, remotePath string, localPath string, RetriesOnFailure int, RetriesDuration time.Duration, maxSpeed uint64) error {
+// GetCompressionStats - statistics of compression_format archives uploaded with current BackupDestination, nil when nothing was compressed
+func (bd *BackupDestination) GetCompressionStats() *metadata.CompressionStats {
+	if bd.compression == nil {
+		return nil
+	}
+	return bd.compression.Stats()
+}
+
+// sizedFilePutter - remote storage which increases multipart part size for big objects, to stay under provider limit of parts count
+type sizedFilePutter interface {
+	PutFileWithSize(ctx context.Context, key string, r io.ReadCloser, expectedSize int64) error
+}
+
+// putFileWithSize - expectedSize could be bigger than actual object size, for compressed archive it is size of files before compression
+func (bd *BackupDestination) putFileWithSize(ctx context.Context, key string, r io.ReadCloser, expectedSize int64) error {
+	if putter, isPutter := bd.RemoteStorage.(sizedFilePutter); isPutter && expectedSize > 0 {
+		return putter.PutFileWithSize(ctx, key, r, expectedSize)
+	}
+	return bd.PutFile(ctx, key, r)
+}
+
+// remoteFileCopier - remote storage which can copy file inside backup path without download, keys are relative to backup path
+type remoteFileCopier interface {
+	CopyFile(ctx context.Context, srcSize int64, srcKey, dstKey string) error
+}
+
+// CopyFile - server side copy when remote storage supports it, otherwise stream file through clickhouse-backup
+func (bd *BackupDestination) CopyFile(ctx context.Context, srcSize int64, srcKey, dstKey string) error {
+	if copier, isCopier := bd.RemoteStorage.(remoteFileCopier); isCopier {
+		return copier.CopyFile(ctx, srcSize, srcKey, dstKey)
+	}
+	r, err := bd.GetFileReader(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := r.Close(); closeErr != nil {
+			bd.Log.Warnf("can't close %s: %v", srcKey, closeErr)
+		}
+	}()
+	return bd.putFileWithSize(ctx, dstKey, io.NopCloser(r), srcSize)
+}
+
+func (bd *BackupDestination) DownloadPath(ctx context.Context, remotePath string, localPath string, retry *Retrier, maxSpeed uint64) error {
 	log := bd.Log.WithFields(apexLog.Fields{
 		"path":      remotePath,
 		"operation": "download",
@@ -429,7 +670,6 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, remotePath string
 		if bd.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
 			return nil
 		}
-		retry := retrier.New(retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration), nil)
 		err := retry.RunCtx(ctx, func(ctx context.Context) error {
 			startTime := time.Now()
 			r, err := bd.GetFileReader(ctx, path.Join(remotePath, f.Name()))
@@ -476,7 +716,7 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, remotePath string
 	})
 }
 
-func (bd *BackupDestination) UploadPath(ctx context.Context, baseLocalPath string, files []string, remotePath string, RetriesOnFailure int, RetriesDuration time.Duration, maxSpeed uint64) (int64, error) {
+func (bd *BackupDestination) UploadPath(ctx context.Context, baseLocalPath string, files []string, remotePath string, retry *Retrier, maxSpeed uint64) (int64, error) {
 	totalBytes := int64(0)
 	for _, filename := range files {
 		startTime := time.Now()
@@ -496,9 +736,14 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, baseLocalPath strin
 				bd.Log.Warnf("can't close UploadPath file descriptor %v: %v", f, err)
 			}
 		}
-		retry := retrier.New(retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration), nil)
 		err = retry.RunCtx(ctx, func(ctx context.Context) error {
-			return bd.PutFile(ctx, path.Join(remotePath, filename), f)
+			// previous attempt could read part of file
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			return watchStall(ctx, bd.stalledStreamTimeout, &StalledUploads, func(ctx context.Context, trackProgress func(io.ReadCloser) io.ReadCloser) error {
+				return bd.putFileWithSize(ctx, path.Join(remotePath, filename), trackProgress(f), fInfo.Size())
+			})
 		})
 		if err != nil {
 			closeFile()
@@ -526,7 +771,95 @@ func (bd *BackupDestination) throttleSpeed(startTime time.Time, size int64, maxS
 	}
 }
 
+// GetMultipartPartSize - size of one part which remote storage uses for multipart upload of one object, 0 means remote storage upload object without fixed size parts
+func GetMultipartPartSize(cfg *config.Config) int64 {
+	switch cfg.General.RemoteStorage {
+	case "azblob":
+		bufferSize := cfg.AzureBlob.BufferSize
+		// https://github.com/Altinity/clickhouse-backup/issues/317
+		if bufferSize <= 0 {
+			bufferSize = int(cfg.General.MaxFileSize) / cfg.AzureBlob.MaxPartsCount
+			if int(cfg.General.MaxFileSize)%cfg.AzureBlob.MaxPartsCount > 0 {
+				bufferSize += int(cfg.General.MaxFileSize) % cfg.AzureBlob.MaxPartsCount
+			}
+			if bufferSize < 2*1024*1024 {
+				bufferSize = 2 * 1024 * 1024
+			}
+			if bufferSize > 10*1024*1024 {
+				bufferSize = 10 * 1024 * 1024
+			}
+		}
+		return int64(bufferSize)
+	case "s3":
+		partSize := cfg.S3.PartSize
+		if cfg.S3.PartSize <= 0 {
+			partSize = cfg.General.MaxFileSize / cfg.S3.MaxPartsCount
+			if cfg.General.MaxFileSize%cfg.S3.MaxPartsCount > 0 {
+				partSize++
+			}
+			if partSize < 5*1024*1024 {
+				partSize = 5 * 1024 * 1024
+			}
+			if partSize > 5*1024*1024*1024 {
+				partSize = 5 * 1024 * 1024 * 1024
+			}
+		}
+		return partSize
+	case "gcs":
+		return int64(cfg.GCS.ChunkSize)
+	}
+	return 0
+}
+
+// calculatePartSize - the smallest part size which is not less than partSize and uploads expectedSize with not more than maxParts parts, maxPartSize is limit of provider
+func calculatePartSize(partSize, expectedSize, maxParts, maxPartSize int64) int64 {
+	if maxParts <= 0 || expectedSize <= 0 {
+		return partSize
+	}
+	requiredSize := expectedSize / maxParts
+	if expectedSize%maxParts > 0 {
+		requiredSize++
+	}
+	if requiredSize > partSize {
+		partSize = requiredSize
+	}
+	if maxPartSize > 0 && partSize > maxPartSize {
+		partSize = maxPartSize
+	}
+	return partSize
+}
+
 func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, calcMaxSize bool, backupName string) (*BackupDestination, error) {
+	bd, err := newBackupDestination(ctx, cfg, ch, calcMaxSize, backupName)
+	if err != nil || !IsFaultInjectionEnabled(cfg.General) {
+		return bd, err
+	}
+	bd.Log.Warnf("fault injection enabled, error_rate=%v slow_rate=%v slow_delay=%s truncate_rate=%v, don't use it in production", cfg.General.FaultInjectionErrorRate, cfg.General.FaultInjectionSlowRate, cfg.General.FaultInjectionSlowDelay, cfg.General.FaultInjectionTruncateRate)
+	bd.RemoteStorage = newFaultInjectionStorage(bd.RemoteStorage, cfg.General)
+	return bd, nil
+}
+
+// NewBackupDestinationFromRemoteStorage - wrap RemoteStorage implementation of application which embeds clickhouse-backup as library
+// compression settings got from general->remote_storage section, general->max_file_size is not calculated
+func NewBackupDestinationFromRemoteStorage(cfg *config.Config, remoteStorage RemoteStorage, log *apexLog.Entry) *BackupDestination {
+	bd := &BackupDestination{
+		remoteStorage,
+		log,
+		cfg.GetCompressionFormat(),
+		cfg.GetCompressionLevel(),
+		cfg.General.RemoteCatalog,
+		cfg.General.RemoteMetadataCacheDuration,
+		cfg.General.StalledStreamTimeoutDuration,
+		cfg.General.StagingPath,
+		newAdaptiveCompression(cfg.GetCompressionFormat(), cfg.GetCompressionLevel(), cfg.General.CompressionConcurrency, cfg.General.AdaptiveCompressionLevel),
+	}
+	if IsFaultInjectionEnabled(cfg.General) {
+		bd.RemoteStorage = newFaultInjectionStorage(bd.RemoteStorage, cfg.General)
+	}
+	return bd
+}
+
+func newBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, calcMaxSize bool, backupName string) (*BackupDestination, error) {
 	log := apexLog.WithField("logger", "NewBackupDestination")
 	var err error
 	// https://github.com/Altinity/clickhouse-backup/issues/404
@@ -545,8 +878,9 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 	switch cfg.General.RemoteStorage {
 	case "azblob":
 		azblobStorage := &AzureBlob{
-			Config: &cfg.AzureBlob,
-			Log:    log.WithField("logger", "AZBLOB"),
+			Config:   &cfg.AzureBlob,
+			HTTPPool: NewHTTPPool(cfg, cfg.AzureBlob.MaxBuffers),
+			Log:      log.WithField("logger", "AZBLOB"),
 		}
 		azblobStorage.Config.Path, err = ch.ApplyMacros(ctx, azblobStorage.Config.Path)
 		if err != nil {
@@ -557,46 +891,30 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 
-		bufferSize := azblobStorage.Config.BufferSize
-		// https://github.com/Altinity/clickhouse-backup/issues/317
-		if bufferSize <= 0 {
-			bufferSize = int(cfg.General.MaxFileSize) / cfg.AzureBlob.MaxPartsCount
-			if int(cfg.General.MaxFileSize)%cfg.AzureBlob.MaxPartsCount > 0 {
-				bufferSize += int(cfg.General.MaxFileSize) % cfg.AzureBlob.MaxPartsCount
-			}
-			if bufferSize < 2*1024*1024 {
-				bufferSize = 2 * 1024 * 1024
-			}
-			if bufferSize > 10*1024*1024 {
-				bufferSize = 10 * 1024 * 1024
-			}
-		}
-		azblobStorage.Config.BufferSize = bufferSize
+		azblobStorage.Config.BufferSize = int(GetMultipartPartSize(cfg))
 		return &BackupDestination{
 			azblobStorage,
 			log.WithField("logger", "azure"),
 			cfg.AzureBlob.CompressionFormat,
 			cfg.AzureBlob.CompressionLevel,
+			cfg.General.RemoteCatalog,
+			cfg.General.RemoteMetadataCacheDuration,
+			cfg.General.StalledStreamTimeoutDuration,
+			cfg.General.StagingPath,
+			newAdaptiveCompression(cfg.AzureBlob.CompressionFormat, cfg.AzureBlob.CompressionLevel, cfg.General.CompressionConcurrency, cfg.General.AdaptiveCompressionLevel),
 		}, nil
 	case "s3":
-		partSize := cfg.S3.PartSize
-		if cfg.S3.PartSize <= 0 {
-			partSize = cfg.General.MaxFileSize / cfg.S3.MaxPartsCount
-			if cfg.General.MaxFileSize%cfg.S3.MaxPartsCount > 0 {
-				partSize++
-			}
-			if partSize < 5*1024*1024 {
-				partSize = 5 * 1024 * 1024
-			}
-			if partSize > 5*1024*1024*1024 {
-				partSize = 5 * 1024 * 1024 * 1024
-			}
+		// s3->buffer_size is size of pooled buffers for each concurrent part
+		bufferSize := cfg.S3.BufferSize
+		if bufferSize <= 0 {
+			bufferSize = 128 * 1024
 		}
 		s3Storage := &S3{
 			Config:      &cfg.S3,
 			Concurrency: cfg.S3.Concurrency,
-			BufferSize:  128 * 1024,
-			PartSize:    partSize,
+			BufferSize:  bufferSize,
+			PartSize:    GetMultipartPartSize(cfg),
+			HTTPPool:    NewHTTPPool(cfg, cfg.S3.Concurrency),
 			Log:         log.WithField("logger", "S3"),
 		}
 		s3Storage.Config.Path, err = ch.ApplyMacros(ctx, s3Storage.Config.Path)
@@ -621,9 +939,14 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			log.WithField("logger", "s3"),
 			cfg.S3.CompressionFormat,
 			cfg.S3.CompressionLevel,
+			cfg.General.RemoteCatalog,
+			cfg.General.RemoteMetadataCacheDuration,
+			cfg.General.StalledStreamTimeoutDuration,
+			cfg.General.StagingPath,
+			newAdaptiveCompression(cfg.S3.CompressionFormat, cfg.S3.CompressionLevel, cfg.General.CompressionConcurrency, cfg.General.AdaptiveCompressionLevel),
 		}, nil
 	case "gcs":
-		googleCloudStorage := &GCS{Config: &cfg.GCS}
+		googleCloudStorage := &GCS{Config: &cfg.GCS, HTTPPool: NewHTTPPool(cfg, 1)}
 		googleCloudStorage.Config.Path, err = ch.ApplyMacros(ctx, googleCloudStorage.Config.Path)
 		if err != nil {
 			return nil, err
@@ -646,9 +969,14 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			log.WithField("logger", "gcs"),
 			cfg.GCS.CompressionFormat,
 			cfg.GCS.CompressionLevel,
+			cfg.General.RemoteCatalog,
+			cfg.General.RemoteMetadataCacheDuration,
+			cfg.General.StalledStreamTimeoutDuration,
+			cfg.General.StagingPath,
+			newAdaptiveCompression(cfg.GCS.CompressionFormat, cfg.GCS.CompressionLevel, cfg.General.CompressionConcurrency, cfg.General.AdaptiveCompressionLevel),
 		}, nil
 	case "cos":
-		tencentStorage := &COS{Config: &cfg.COS}
+		tencentStorage := &COS{Config: &cfg.COS, HTTPPool: NewHTTPPool(cfg, 1)}
 		tencentStorage.Config.Path, err = ch.ApplyMacros(ctx, tencentStorage.Config.Path)
 		if err != nil {
 			return nil, err
@@ -658,6 +986,11 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			log.WithField("logger", "cos"),
 			cfg.COS.CompressionFormat,
 			cfg.COS.CompressionLevel,
+			cfg.General.RemoteCatalog,
+			cfg.General.RemoteMetadataCacheDuration,
+			cfg.General.StalledStreamTimeoutDuration,
+			cfg.General.StagingPath,
+			newAdaptiveCompression(cfg.COS.CompressionFormat, cfg.COS.CompressionLevel, cfg.General.CompressionConcurrency, cfg.General.AdaptiveCompressionLevel),
 		}, nil
 	case "ftp":
 		ftpStorage := &FTP{
@@ -673,6 +1006,11 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			log.WithField("logger", "FTP"),
 			cfg.FTP.CompressionFormat,
 			cfg.FTP.CompressionLevel,
+			cfg.General.RemoteCatalog,
+			cfg.General.RemoteMetadataCacheDuration,
+			cfg.General.StalledStreamTimeoutDuration,
+			cfg.General.StagingPath,
+			newAdaptiveCompression(cfg.FTP.CompressionFormat, cfg.FTP.CompressionLevel, cfg.General.CompressionConcurrency, cfg.General.AdaptiveCompressionLevel),
 		}, nil
 	case "sftp":
 		sftpStorage := &SFTP{
@@ -687,6 +1025,11 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			log.WithField("logger", "SFTP"),
 			cfg.SFTP.CompressionFormat,
 			cfg.SFTP.CompressionLevel,
+			cfg.General.RemoteCatalog,
+			cfg.General.RemoteMetadataCacheDuration,
+			cfg.General.StalledStreamTimeoutDuration,
+			cfg.General.StagingPath,
+			newAdaptiveCompression(cfg.SFTP.CompressionFormat, cfg.SFTP.CompressionLevel, cfg.General.CompressionConcurrency, cfg.General.AdaptiveCompressionLevel),
 		}, nil
 	default:
 		return nil, fmt.Errorf("NewBackupDestination error: storage type '%s' is not supported", cfg.General.RemoteStorage)
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStagingPath(t *testing.T) {
+	bd := &BackupDestination{}
+	stagingPath, err := bd.GetStagingPath("backup1/shadow/db/table/default_all_1_1_0.tar", "/var/lib/clickhouse/backup/backup1/shadow/db/table/default")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/clickhouse/backup/backup1/shadow/db/table/default", stagingPath)
+
+	bd.stagingPath = t.TempDir()
+	stagingPath, err = bd.GetStagingPath("backup1/shadow/db/table/default_all_1_1_0.tar", "/var/lib/clickhouse/backup/backup1/shadow/db/table/default")
+	require.NoError(t, err)
+	assert.Equal(t, path.Join(bd.stagingPath, "backup1/shadow/db/table"), stagingPath)
+	assert.DirExists(t, stagingPath)
+}
+
+func TestCalculatePartSize(t *testing.T) {
+	const mb = 1024 * 1024
+	// 5MB part_size is enough for 1GB object with 4000 parts
+	assert.Equal(t, int64(5*mb), calculatePartSize(5*mb, 1024*mb, 4000, s3MaxPartSize))
+	// 100GB object with 4000 parts requires bigger parts
+	assert.Equal(t, int64(100*1024*mb/4000+1), calculatePartSize(5*mb, 100*1024*mb, 4000, s3MaxPartSize))
+	assert.Equal(t, int64(s3MaxPartSize), calculatePartSize(5*mb, 100*1024*1024*mb, 4000, s3MaxPartSize))
+	assert.Equal(t, int64(5*mb), calculatePartSize(5*mb, 0, 4000, s3MaxPartSize))
+	assert.Equal(t, int64(5*mb), calculatePartSize(5*mb, 1024*1024*mb, 0, s3MaxPartSize))
+}
+
+func TestHTTPPool(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.UploadConcurrency = 16
+	cfg.General.DownloadConcurrency = 8
+	p := NewHTTPPool(cfg, 10)
+	assert.Equal(t, 160, p.MaxIdleConnections)
+	assert.Equal(t, 90*time.Second, p.IdleTimeout)
+	assert.Equal(t, defaultMaxIdleConnections, NewHTTPPool(cfg, 1).MaxIdleConnections)
+	cfg.General.RemoteMaxIdleConnections = 5
+	assert.Equal(t, 5, NewHTTPPool(cfg, 10).MaxIdleConnections)
+
+	transport := HTTPPool{}.newTransport(true)
+	assert.Equal(t, defaultMaxIdleConnections, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, defaultIdleConnectionTimeout, transport.IdleConnTimeout)
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+	assert.NotNil(t, transport.TLSClientConfig.ClientSessionCache)
+
+	// TLS connection is opened once and reused by all sequential requests
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: transport}
+	var reused atomic.Int32
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if info.Reused {
+			reused.Add(1)
+		}
+	}}
+	for i := 0; i < 10; i++ {
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	assert.Equal(t, int32(9), reused.Load())
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+)
+
+const (
+	// defaultMaxIdleConnections - the same as default pool size of aws-sdk-go-v2 and azure-pipeline-go clients
+	defaultMaxIdleConnections    = 100
+	defaultIdleConnectionTimeout = 90 * time.Second
+)
+
+// HTTPPool - keep-alive connections and TLS sessions pool, created once in Connect and shared by all object operations of one remote storage
+// zero value means defaults, look general->remote_max_idle_connections and general->remote_idle_connection_timeout
+type HTTPPool struct {
+	MaxIdleConnections int
+	IdleTimeout        time.Duration
+}
+
+// NewHTTPPool - when general->remote_max_idle_connections is 0, every upload or download worker could keep multipartConcurrency connections
+func NewHTTPPool(cfg *config.Config, multipartConcurrency int) HTTPPool {
+	maxIdleConnections := cfg.General.RemoteMaxIdleConnections
+	if maxIdleConnections <= 0 {
+		maxIdleConnections = max(int(cfg.General.UploadConcurrency), int(cfg.General.DownloadConcurrency), 1) * max(multipartConcurrency, 1)
+		maxIdleConnections = max(maxIdleConnections, defaultMaxIdleConnections)
+	}
+	return HTTPPool{
+		MaxIdleConnections: maxIdleConnections,
+		IdleTimeout:        cfg.General.RemoteIdleConnectionDuration,
+	}
+}
+
+// newTransport - clone of http.DefaultTransport to keep proxy from environment and HTTP/2, but without default limit of 2 idle connections per host,
+// which closes and opens connection for almost each request when operations run concurrently
+func (p HTTPPool) newTransport(insecureSkipVerify bool) *http.Transport {
+	maxIdleConnections := p.MaxIdleConnections
+	if maxIdleConnections <= 0 {
+		maxIdleConnections = defaultMaxIdleConnections
+	}
+	idleTimeout := p.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleConnectionTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConnections
+	transport.MaxIdleConnsPerHost = maxIdleConnections
+	transport.IdleConnTimeout = idleTimeout
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+		// new connections resume TLS session instead of full handshake
+		ClientSessionCache: tls.NewLRUClientSessionCache(maxIdleConnections),
+	}
+	return transport
+}
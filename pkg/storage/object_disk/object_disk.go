@@ -427,7 +427,7 @@ func makeObjectDiskConnection(ctx context.Context, ch *clickhouse.ClickHouse, cf
 	if !exists {
 		return nil, fmt.Errorf("%s is not presnet in object_disk.SystemDisks", diskName)
 	}
-	if disk.Type != "s3" && disk.Type != "s3_plain" && disk.Type != "azure_blob_storage" && disk.Type != "azure" && disk.Type != "encrypted" {
+	if disk.Type != "s3" && disk.Type != "s3_plain" && disk.Type != "azure_blob_storage" && disk.Type != "azure" && disk.Type != "encrypted" && disk.Type != "cache" {
 		return nil, fmt.Errorf("%s have unsupported type %s", diskName, disk.Type)
 	}
 	connection.MetadataPath = disk.Path
@@ -484,7 +484,7 @@ func makeObjectDiskConnection(ctx context.Context, ch *clickhouse.ClickHouse, cf
 		// need for CopyObject
 		s3cfg.ObjectDiskPath = s3cfg.Path
 		s3cfg.Debug = cfg.S3.Debug
-		connection.S3 = &storage.S3{Config: &s3cfg, Log: apexLog.WithField("logger", "S3")}
+		connection.S3 = &storage.S3{Config: &s3cfg, HTTPPool: storage.NewHTTPPool(cfg, cfg.General.ObjectDiskCopyConcurrency), Log: apexLog.WithField("logger", "S3")}
 		if err = connection.S3.Connect(ctx); err != nil {
 			return nil, err
 		}
@@ -523,7 +523,7 @@ func makeObjectDiskConnection(ctx context.Context, ch *clickhouse.ClickHouse, cf
 			azureCfg.Container = creds.AzureContainerName
 		}
 		azureCfg.Debug = cfg.AzureBlob.Debug
-		connection.AzureBlob = &storage.AzureBlob{Config: &azureCfg, Log: apexLog.WithField("logger", "AZBLOB")}
+		connection.AzureBlob = &storage.AzureBlob{Config: &azureCfg, HTTPPool: storage.NewHTTPPool(cfg, cfg.General.ObjectDiskCopyConcurrency), Log: apexLog.WithField("logger", "AZBLOB")}
 		if err = connection.AzureBlob.Connect(ctx); err != nil {
 			return nil, err
 		}
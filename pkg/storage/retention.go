@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+)
+
+// GetRetentionPolicyName - first policy from general->retention_policies where all backup databases matched with policy databases patterns, otherwise config.DefaultRetentionPolicy
+func GetRetentionPolicyName(policies []config.RetentionPolicy, backup Backup) string {
+	databases := map[string]struct{}{}
+	for _, table := range backup.Tables {
+		databases[table.Database] = struct{}{}
+	}
+	for _, database := range backup.Databases {
+		databases[database.Name] = struct{}{}
+	}
+	if len(databases) == 0 {
+		return config.DefaultRetentionPolicy
+	}
+	for _, policy := range policies {
+		allMatched := true
+		for database := range databases {
+			if !matchAnyPattern(policy.Databases, database) {
+				allMatched = false
+				break
+			}
+		}
+		if allMatched {
+			return policy.Name
+		}
+	}
+	return config.DefaultRetentionPolicy
+}
+
+func matchAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBackupsToDeleteRemoteByPolicies - group backups by retention policy, backups from config.DefaultRetentionPolicy group retained with keep the same as GetBackupsToDeleteRemote
+// backups required for incremental backups which still retained are never deleted, even when required backup belongs to other policy
+// backups with failed_tables are incomplete until `upload --only-failed`, they are not counted by retention and never deleted
+func GetBackupsToDeleteRemoteByPolicies(backups []Backup, keep int, policies []config.RetentionPolicy, now time.Time) map[string][]Backup {
+	groups := map[string][]Backup{}
+	for _, backup := range backups {
+		if len(backup.FailedTables) > 0 {
+			continue
+		}
+		policyName := GetRetentionPolicyName(policies, backup)
+		groups[policyName] = append(groups[policyName], backup)
+	}
+	deleteCandidates := map[string]string{}
+	for policyName, group := range groups {
+		if policyName == config.DefaultRetentionPolicy {
+			if keep < 1 {
+				continue
+			}
+			for _, backup := range GetBackupsToDeleteRemote(group, keep) {
+				deleteCandidates[backup.BackupName] = policyName
+			}
+			continue
+		}
+		for i := range policies {
+			if policies[i].Name == policyName {
+				retained := getRetainedBackupsByPolicy(policies[i], group, now)
+				for _, backup := range group {
+					if !retained[backup.BackupName] {
+						deleteCandidates[backup.BackupName] = policyName
+					}
+				}
+				break
+			}
+		}
+	}
+	requiredBackups := map[string]string{}
+	for _, backup := range backups {
+		requiredBackups[backup.BackupName] = backup.RequiredBackup
+	}
+	for _, backup := range backups {
+		if _, isDeleted := deleteCandidates[backup.BackupName]; isDeleted {
+			continue
+		}
+		for required := backup.RequiredBackup; required != ""; required = requiredBackups[required] {
+			delete(deleteCandidates, required)
+		}
+	}
+	backupsToDelete := map[string][]Backup{}
+	for _, backup := range backups {
+		// avoid race condition for multiple shards copy, the same as GetBackupsToDeleteRemote
+		if policyName, isDeleted := deleteCandidates[backup.BackupName]; isDeleted && !backup.UploadDate.IsZero() && backup.UploadDate != time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC) {
+			backupsToDelete[policyName] = append(backupsToDelete[policyName], backup)
+		}
+	}
+	return backupsToDelete
+}
+
+// GetBackupsNeverDeletedRemote - backups which retention will never delete with reason, policy without rules retains all backups, backup without upload date is skipped the same as GetBackupsToDeleteRemote, incomplete backup with failed_tables is skipped the same as GetBackupsToDeleteRemoteByPolicies,
+// required backups of such backups are never deleted too
+func GetBackupsNeverDeletedRemote(backups []Backup, keep int, policies []config.RetentionPolicy) map[string]string {
+	neverDeleted := map[string]string{}
+	for _, backup := range backups {
+		if len(backup.FailedTables) > 0 {
+			neverDeleted[backup.BackupName] = fmt.Sprintf("incomplete, %d tables failed during upload", len(backup.FailedTables))
+			continue
+		}
+		policyName := GetRetentionPolicyName(policies, backup)
+		if policyName == config.DefaultRetentionPolicy && keep < 1 {
+			neverDeleted[backup.BackupName] = "backups_to_keep_remote is 0"
+			continue
+		}
+		for _, policy := range policies {
+			if policy.Name == policyName && policy.BackupsToKeepRemote <= 0 && policy.KeepDaily <= 0 && policy.KeepMonthly <= 0 && policy.MaxAgeDuration <= 0 {
+				neverDeleted[backup.BackupName] = fmt.Sprintf("retention policy %s has no rules", policyName)
+				break
+			}
+		}
+		if _, exists := neverDeleted[backup.BackupName]; !exists && (backup.UploadDate.IsZero() || backup.UploadDate == time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+			neverDeleted[backup.BackupName] = "upload date is unknown"
+		}
+	}
+	requiredBackups := map[string]string{}
+	for _, backup := range backups {
+		requiredBackups[backup.BackupName] = backup.RequiredBackup
+	}
+	for _, backup := range backups {
+		if _, exists := neverDeleted[backup.BackupName]; !exists {
+			continue
+		}
+		for required := backup.RequiredBackup; required != ""; required = requiredBackups[required] {
+			if _, exists := neverDeleted[required]; exists {
+				break
+			}
+			neverDeleted[required] = fmt.Sprintf("required by %s", backup.BackupName)
+		}
+	}
+	return neverDeleted
+}
+
+// getRetainedBackupsByPolicy - union of backups_to_keep_remote latest backups, latest backup for each of keep_daily days and keep_monthly months, limited by max_age
+// policy without any rules retains all backups
+func getRetainedBackupsByPolicy(policy config.RetentionPolicy, backups []Backup, now time.Time) map[string]bool {
+	sorted := make([]Backup, len(backups))
+	copy(sorted, backups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].UploadDate.After(sorted[j].UploadDate)
+	})
+	retained := map[string]bool{}
+	hasCountRules := policy.BackupsToKeepRemote > 0 || policy.KeepDaily > 0 || policy.KeepMonthly > 0
+	for i, backup := range sorted {
+		if !hasCountRules || i < policy.BackupsToKeepRemote {
+			retained[backup.BackupName] = true
+		}
+	}
+	retainLatestInPeriod := func(periods int, layout string) {
+		seenPeriods := map[string]bool{}
+		for _, backup := range sorted {
+			period := backup.UploadDate.UTC().Format(layout)
+			if seenPeriods[period] {
+				continue
+			}
+			if len(seenPeriods) >= periods {
+				return
+			}
+			seenPeriods[period] = true
+			retained[backup.BackupName] = true
+		}
+	}
+	retainLatestInPeriod(policy.KeepDaily, "2006-01-02")
+	retainLatestInPeriod(policy.KeepMonthly, "2006-01")
+	if policy.MaxAgeDuration > 0 {
+		for _, backup := range sorted {
+			if now.Sub(backup.UploadDate) > policy.MaxAgeDuration {
+				delete(retained, backup.BackupName)
+			}
+		}
+	}
+	return retained
+}
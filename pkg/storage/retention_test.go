@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func retentionTestBackup(name string, uploadDate time.Time, requiredBackup string, databases ...string) Backup {
+	backup := Backup{BackupMetadata: metadata.BackupMetadata{BackupName: name, RequiredBackup: requiredBackup}, UploadDate: uploadDate}
+	for _, database := range databases {
+		backup.Tables = append(backup.Tables, metadata.TableTitle{Database: database, Table: "t"})
+	}
+	return backup
+}
+
+func retentionBackupNames(backups []Backup) []string {
+	names := make([]string, 0, len(backups))
+	for _, backup := range backups {
+		names = append(names, backup.BackupName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestGetRetentionPolicyName(t *testing.T) {
+	policies := []config.RetentionPolicy{
+		{Name: "finance", Databases: []string{"finance", "audit"}},
+		{Name: "staging", Databases: []string{"staging_*"}},
+	}
+	now := time.Now()
+	assert.Equal(t, "finance", GetRetentionPolicyName(policies, retentionTestBackup("b1", now, "", "finance", "audit")))
+	assert.Equal(t, "staging", GetRetentionPolicyName(policies, retentionTestBackup("b2", now, "", "staging_1", "staging_2")))
+	assert.Equal(t, config.DefaultRetentionPolicy, GetRetentionPolicyName(policies, retentionTestBackup("b3", now, "", "finance", "staging_1")))
+	assert.Equal(t, config.DefaultRetentionPolicy, GetRetentionPolicyName(policies, retentionTestBackup("b4", now, "")))
+}
+
+func TestGetBackupsToDeleteRemoteByPolicies(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	policies := []config.RetentionPolicy{
+		{Name: "finance", Databases: []string{"finance"}, KeepMonthly: 2},
+		{Name: "staging", Databases: []string{"staging_*"}, MaxAgeDuration: 72 * time.Hour},
+		{Name: "logs", Databases: []string{"logs"}, BackupsToKeepRemote: 1, KeepDaily: 2},
+	}
+	backups := []Backup{
+		retentionTestBackup("finance-2024-04", time.Date(2024, time.April, 30, 0, 0, 0, 0, time.UTC), "", "finance"),
+		retentionTestBackup("finance-2024-05-01", time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC), "", "finance"),
+		retentionTestBackup("finance-2024-05-31", time.Date(2024, time.May, 31, 0, 0, 0, 0, time.UTC), "", "finance"),
+		retentionTestBackup("finance-2024-06-01", time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), "", "finance"),
+		retentionTestBackup("finance-2024-06-14", time.Date(2024, time.June, 14, 0, 0, 0, 0, time.UTC), "finance-2024-06-01", "finance"),
+		retentionTestBackup("staging-old", now.Add(-96*time.Hour), "", "staging_1"),
+		retentionTestBackup("staging-new", now.Add(-24*time.Hour), "", "staging_1", "staging_2"),
+		retentionTestBackup("logs-1", now.Add(-50*time.Hour), "", "logs"),
+		retentionTestBackup("logs-2", now.Add(-26*time.Hour), "", "logs"),
+		retentionTestBackup("logs-3", now.Add(-2*time.Hour), "", "logs"),
+		retentionTestBackup("logs-4", now.Add(-1*time.Hour), "", "logs"),
+		retentionTestBackup("default-1", now.Add(-3*time.Hour), "", "default"),
+		retentionTestBackup("default-2", now.Add(-2*time.Hour), "", "default"),
+	}
+	result := GetBackupsToDeleteRemoteByPolicies(backups, 1, policies, now)
+	// finance-2024-06-01 is not latest in month, but required for finance-2024-06-14
+	assert.Equal(t, []string{"finance-2024-04", "finance-2024-05-01"}, retentionBackupNames(result["finance"]))
+	assert.Equal(t, []string{"staging-old"}, retentionBackupNames(result["staging"]))
+	assert.Equal(t, []string{"logs-1", "logs-3"}, retentionBackupNames(result["logs"]))
+	assert.Equal(t, []string{"default-1"}, retentionBackupNames(result[config.DefaultRetentionPolicy]))
+
+	result = GetBackupsToDeleteRemoteByPolicies(backups, 0, policies, now)
+	assert.NotContains(t, result, config.DefaultRetentionPolicy)
+	assert.Empty(t, GetBackupsToDeleteRemoteByPolicies(backups, 0, nil, now))
+}
+
+func TestGetBackupsNeverDeletedRemote(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	policies := []config.RetentionPolicy{
+		{Name: "finance", Databases: []string{"finance"}},
+		{Name: "logs", Databases: []string{"logs"}, BackupsToKeepRemote: 1},
+	}
+	backups := []Backup{
+		retentionTestBackup("finance-full", now.Add(-48*time.Hour), "", "finance"),
+		retentionTestBackup("finance-increment", now.Add(-24*time.Hour), "finance-full", "finance"),
+		retentionTestBackup("logs-full", now.Add(-48*time.Hour), "", "logs"),
+		retentionTestBackup("logs-increment", time.Time{}, "logs-full", "logs"),
+		retentionTestBackup("logs-new", now, "", "logs"),
+		retentionTestBackup("default-1", now, "", "default"),
+	}
+	assert.Equal(t, map[string]string{
+		"finance-full":      "retention policy finance has no rules",
+		"finance-increment": "retention policy finance has no rules",
+		"logs-increment":    "upload date is unknown",
+		"logs-full":         "required by logs-increment",
+	}, GetBackupsNeverDeletedRemote(backups, 1, policies))
+
+	neverDeleted := GetBackupsNeverDeletedRemote(backups, 0, policies)
+	assert.Equal(t, "backups_to_keep_remote is 0", neverDeleted["default-1"])
+	assert.NotContains(t, neverDeleted, "logs-new")
+}
+
+func TestGetBackupsToDeleteRemoteByPoliciesSkipIncomplete(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	incomplete := retentionTestBackup("incomplete", now.Add(-1*time.Hour), "full-2", "default")
+	incomplete.FailedTables = []metadata.FailedTable{{Database: "default", Table: "t2", Error: "upload failed"}}
+	backups := []Backup{
+		retentionTestBackup("full-1", now.Add(-4*time.Hour), "", "default"),
+		retentionTestBackup("full-2", now.Add(-3*time.Hour), "", "default"),
+		retentionTestBackup("full-3", now.Add(-2*time.Hour), "", "default"),
+		incomplete,
+	}
+	// incomplete backup is newest, but it doesn't push full-3 out and keeps full-2 which it requires
+	result := GetBackupsToDeleteRemoteByPolicies(backups, 1, nil, now)
+	assert.Equal(t, []string{"full-1"}, retentionBackupNames(result[config.DefaultRetentionPolicy]))
+
+	policies := []config.RetentionPolicy{{Name: "all", Databases: []string{"*"}, BackupsToKeepRemote: 1}}
+	result = GetBackupsToDeleteRemoteByPolicies(backups, 1, policies, now)
+	assert.Equal(t, []string{"full-1"}, retentionBackupNames(result["all"]))
+
+	neverDeleted := GetBackupsNeverDeletedRemote(backups, 1, nil)
+	assert.Equal(t, "incomplete, 1 tables failed during upload", neverDeleted["incomplete"])
+	assert.Equal(t, "required by incomplete", neverDeleted["full-2"])
+	assert.NotContains(t, neverDeleted, "full-3")
+}
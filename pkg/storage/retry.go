@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	apexLog "github.com/apex/log"
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/tencentyun/cos-go-sdk-v5"
+	"google.golang.org/api/googleapi"
+)
+
+// fatalStatusCodes - the same request will fail again, 400 is not here, cause S3 returns RequestTimeout with 400
+var fatalStatusCodes = map[int]struct{}{
+	http.StatusUnauthorized:                 {},
+	http.StatusForbidden:                    {},
+	http.StatusNotFound:                     {},
+	http.StatusMethodNotAllowed:             {},
+	http.StatusLengthRequired:               {},
+	http.StatusPreconditionFailed:           {},
+	http.StatusRequestEntityTooLarge:        {},
+	http.StatusRequestedRangeNotSatisfiable: {},
+}
+
+// RetryPolicy - the same retries for all storage operations during one command, look general->retries_*
+type RetryPolicy struct {
+	MaxRetries int
+	Pause      time.Duration
+	MaxPause   time.Duration
+	Backoff    string
+	Jitter     float64
+	// budget - how many retries left for all operations, nil means unlimited
+	budget *atomic.Int64
+	log    *apexLog.Entry
+}
+
+func NewRetryPolicy(cfg *config.Config) *RetryPolicy {
+	p := &RetryPolicy{
+		MaxRetries: cfg.General.RetriesOnFailure,
+		Pause:      cfg.General.RetriesDuration,
+		MaxPause:   cfg.General.RetriesMaxPauseDuration,
+		Backoff:    cfg.General.RetriesBackoff,
+		Jitter:     cfg.General.RetriesJitter,
+		log:        apexLog.WithField("logger", "retry"),
+	}
+	if cfg.General.RetriesBudget > 0 {
+		p.budget = &atomic.Int64{}
+		p.budget.Store(int64(cfg.General.RetriesBudget))
+	}
+	return p
+}
+
+// Retrier - retries of RetryPolicy, safe for concurrent use, each RunCtx counts own retries
+type Retrier struct {
+	policy  *RetryPolicy
+	backoff []time.Duration
+}
+
+// NewRetrier - all retriers of one policy share retries budget
+func (p *RetryPolicy) NewRetrier() *Retrier {
+	var backoff []time.Duration
+	if p.Backoff == "exponential" {
+		maxPause := p.MaxPause
+		if maxPause < p.Pause {
+			maxPause = p.Pause
+		}
+		backoff = retrier.LimitedExponentialBackoff(p.MaxRetries, p.Pause, maxPause)
+	} else {
+		backoff = retrier.ConstantBackoff(p.MaxRetries, p.Pause)
+	}
+	return &Retrier{policy: p, backoff: backoff}
+}
+
+// RunCtx - run work until it succeeds, returns fatal error or retries are exhausted
+func (r *Retrier) RunCtx(ctx context.Context, work func(ctx context.Context) error) error {
+	rt := retrier.New(r.backoff, &retryClassifier{policy: r.policy, retriesLeft: len(r.backoff)})
+	rt.SetJitter(r.policy.Jitter)
+	return rt.RunCtx(ctx, work)
+}
+
+// retryClassifier - implements retrier.Classifier for one RunCtx, retriesLeft is required to skip budget for the last attempt which will not retry
+type retryClassifier struct {
+	policy      *RetryPolicy
+	retriesLeft int
+}
+
+// Classify - fatal errors, errors of the last attempt and errors after exhausted budget are not retried
+func (c *retryClassifier) Classify(err error) retrier.Action {
+	if err == nil {
+		return retrier.Succeed
+	}
+	if !IsRetryableError(err) || c.retriesLeft <= 0 {
+		return retrier.Fail
+	}
+	if c.policy.budget != nil && c.policy.budget.Add(-1) < 0 {
+		c.policy.log.Warnf("general->retries_budget exhausted, fail without retry: %v", err)
+		return retrier.Fail
+	}
+	c.retriesLeft--
+	return retrier.Retry
+}
+
+// IsRetryableError - 429, 5xx, timeouts, network and unknown errors are retryable, authorization errors, missing objects and canceled context are not
+func IsRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrNotFound) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	if statusCode := getErrorStatusCode(err); statusCode != 0 {
+		_, isFatal := fatalStatusCodes[statusCode]
+		return !isFatal
+	}
+	return true
+}
+
+// getErrorStatusCode - HTTP status code of error returned by s3, gcs, azblob or cos client, 0 when error has no HTTP response
+func getErrorStatusCode(err error) int {
+	// s3 *awshttp.ResponseError and *smithyhttp.ResponseError
+	var statusCodeErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusCodeErr) {
+		return statusCodeErr.HTTPStatusCode()
+	}
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		return gcsErr.Code
+	}
+	var azblobErr azblob.StorageError
+	if errors.As(err, &azblobErr) && azblobErr.Response() != nil {
+		return azblobErr.Response().StatusCode
+	}
+	var cosErr *cos.ErrorResponse
+	if errors.As(err, &cosErr) && cosErr.Response != nil {
+		return cosErr.Response.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, IsRetryableError(errors.New("connection reset by peer")))
+	assert.True(t, IsRetryableError(ErrFaultInjected))
+	assert.True(t, IsRetryableError(fmt.Errorf("upload: %w", &googleapi.Error{Code: http.StatusTooManyRequests})))
+	assert.True(t, IsRetryableError(&googleapi.Error{Code: http.StatusServiceUnavailable}))
+	assert.True(t, IsRetryableError(&googleapi.Error{Code: http.StatusBadRequest}))
+	assert.False(t, IsRetryableError(fmt.Errorf("upload: %w", &googleapi.Error{Code: http.StatusForbidden})))
+	assert.False(t, IsRetryableError(fmt.Errorf("download: %w", ErrNotFound)))
+	assert.False(t, IsRetryableError(context.Canceled))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RetriesOnFailure = 3
+	cfg.General.RetriesDuration = time.Millisecond
+	cfg.General.RetriesBudget = 4
+	p := NewRetryPolicy(cfg)
+	ctx := context.Background()
+
+	attempts := 0
+	err := p.NewRetrier().RunCtx(ctx, func(ctx context.Context) error {
+		attempts++
+		return &googleapi.Error{Code: http.StatusForbidden}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts, "fatal error shall not retry")
+
+	attempts = 0
+	err = p.NewRetrier().RunCtx(ctx, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return &googleapi.Error{Code: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// 2 of 4 retries are left in budget, which shared by all retriers of policy
+	attempts = 0
+	err = p.NewRetrier().RunCtx(ctx, func(ctx context.Context) error {
+		attempts++
+		return ErrFaultInjected
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// the last attempt doesn't consume budget, cause it will not retry
+	cfg.General.RetriesOnFailure = 1
+	cfg.General.RetriesBudget = 2
+	p = NewRetryPolicy(cfg)
+	for i := 0; i < 2; i++ {
+		attempts = 0
+		err = p.NewRetrier().RunCtx(ctx, func(ctx context.Context) error {
+			attempts++
+			return ErrFaultInjected
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 2, attempts)
+	}
+	assert.Equal(t, int64(0), p.budget.Load())
+
+	cfg.General.RetriesOnFailure = 3
+	cfg.General.RetriesBudget = 0
+	cfg.General.RetriesBackoff = "exponential"
+	cfg.General.RetriesMaxPauseDuration = 3 * time.Millisecond
+	attempts = 0
+	start := time.Now()
+	err = NewRetryPolicy(cfg).NewRetrier().RunCtx(ctx, func(ctx context.Context) error {
+		attempts++
+		return ErrFaultInjected
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 4, attempts)
+	// 1ms + 2ms + 3ms
+	assert.GreaterOrEqual(t, time.Since(start), 6*time.Millisecond)
+}
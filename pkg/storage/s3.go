@@ -2,7 +2,6 @@ package storage
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,9 +10,11 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	"github.com/Altinity/clickhouse-backup/v2/pkg/status"
 	apexLog "github.com/apex/log"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
@@ -93,7 +94,24 @@ type S3 struct {
 	PartSize    int64
 	Concurrency int
 	BufferSize  int
+	HTTPPool    HTTPPool
 	versioning  bool
+	// readReplicas - primary bucket is always first, https://docs.aws.amazon.com/AmazonS3/latest/userguide/replication.html
+	readReplicas      []s3ReadReplica
+	activeReadReplica atomic.Int32
+	// endpoints - `endpoint` and `failover_endpoints`, all requests go to endpoints[activeEndpoint]
+	endpoints       []string
+	endpointClients []*s3.Client
+	activeEndpoint  atomic.Int32
+	failoverMutex   sync.Mutex
+	stopHealthCheck context.CancelFunc
+}
+
+type s3ReadReplica struct {
+	client     *s3.Client
+	downloader *s3manager.Downloader
+	bucket     string
+	region     string
 }
 
 func (s *S3) Kind() string {
@@ -143,19 +161,17 @@ func (s *S3) Connect(ctx context.Context) error {
 		awsConfig.ClientLogMode = aws.LogRetries | aws.LogRequest | aws.LogResponse
 	}
 
-	httpTransport := http.DefaultTransport
-	if s.Config.DisableCertVerification {
-		httpTransport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		awsConfig.HTTPClient = &http.Client{Transport: httpTransport}
-	}
+	// one transport for all clients, uploader and downloader, connections are reused between objects
+	httpTransport := s.HTTPPool.newTransport(s.Config.DisableCertVerification)
+	awsConfig.HTTPClient = &http.Client{Transport: httpTransport}
 
 	if s.Config.Endpoint != "" {
+		s.endpoints = append([]string{s.Config.Endpoint}, s.Config.FailoverEndpoints...)
+		s.activeEndpoint.Store(0)
 		awsConfig.EndpointResolverWithOptions = aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 			return aws.Endpoint{
 				PartitionID:       "aws",
-				URL:               s.Config.Endpoint,
+				URL:               s.endpoints[s.activeEndpoint.Load()],
 				SigningRegion:     s.Config.Region,
 				HostnameImmutable: true,
 				Source:            aws.EndpointSourceCustom,
@@ -185,12 +201,199 @@ func (s *S3) Connect(ctx context.Context) error {
 	s.downloader.BufferProvider = s3manager.NewPooledBufferedWriterReadFromProvider(s.BufferSize)
 	s.downloader.PartSize = s.PartSize
 
+	if len(s.endpoints) > 1 {
+		s.endpointClients = make([]*s3.Client, len(s.endpoints))
+		for i, endpoint := range s.endpoints {
+			endpointConfig := awsConfig.Copy()
+			endpointConfig.EndpointResolverWithOptions = aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{
+					PartitionID:       "aws",
+					URL:               endpoint,
+					SigningRegion:     s.Config.Region,
+					HostnameImmutable: true,
+					Source:            aws.EndpointSourceCustom,
+				}, nil
+			})
+			s.endpointClients[i] = s3.NewFromConfig(endpointConfig, func(o *s3.Options) {
+				o.UsePathStyle = s.Config.ForcePathStyle
+				o.EndpointOptions.DisableHTTPS = s.Config.DisableSSL
+			})
+		}
+		// scheduled backups shall survive storage node failure, so choose healthy endpoint before first request
+		s.failoverEndpoint(ctx, 0)
+		if s.stopHealthCheck != nil {
+			s.stopHealthCheck()
+		}
+		var healthCheckCtx context.Context
+		healthCheckCtx, s.stopHealthCheck = context.WithCancel(ctx)
+		go s.watchEndpointsHealth(healthCheckCtx)
+	}
+
 	s.versioning = s.isVersioningEnabled(ctx)
 
+	s.readReplicas = []s3ReadReplica{{client: s.client, downloader: s.downloader, bucket: s.Config.Bucket, region: awsConfig.Region}}
+	for _, replica := range s.Config.ReadReplicas {
+		replicaConfig := awsConfig.Copy()
+		if replica.Region != "" {
+			replicaConfig.Region = replica.Region
+		}
+		replicaConfig.EndpointResolverWithOptions = nil
+		if replica.Endpoint != "" {
+			replicaEndpoint, replicaRegion := replica.Endpoint, replicaConfig.Region
+			replicaConfig.EndpointResolverWithOptions = aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{
+					PartitionID:       "aws",
+					URL:               replicaEndpoint,
+					SigningRegion:     replicaRegion,
+					HostnameImmutable: true,
+					Source:            aws.EndpointSourceCustom,
+				}, nil
+			})
+		}
+		replicaClient := s3.NewFromConfig(replicaConfig, func(o *s3.Options) {
+			o.UsePathStyle = s.Config.ForcePathStyle
+			o.EndpointOptions.DisableHTTPS = s.Config.DisableSSL
+		})
+		replicaDownloader := s3manager.NewDownloader(replicaClient)
+		replicaDownloader.Concurrency = s.Concurrency
+		replicaDownloader.BufferProvider = s3manager.NewPooledBufferedWriterReadFromProvider(s.BufferSize)
+		replicaDownloader.PartSize = s.PartSize
+		s.readReplicas = append(s.readReplicas, s3ReadReplica{client: replicaClient, downloader: replicaDownloader, bucket: replica.Bucket, region: replicaConfig.Region})
+	}
+
 	return nil
 }
 
+// checkEndpointHealth - HeadBucket shall be allowed for the same credentials which used for backup
+func (s *S3) checkEndpointHealth(ctx context.Context, i int32) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Config.HealthCheckDuration)
+	defer cancel()
+	_, err := s.endpointClients[i].HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.Config.Bucket)})
+	return err
+}
+
+// failoverEndpoint - when failed endpoint is unhealthy, switch to first healthy endpoint, return true when endpoint changed
+func (s *S3) failoverEndpoint(ctx context.Context, failed int32) bool {
+	s.failoverMutex.Lock()
+	defer s.failoverMutex.Unlock()
+	// other goroutine already switched endpoint
+	if s.activeEndpoint.Load() != failed {
+		return true
+	}
+	healthErr := s.checkEndpointHealth(ctx, failed)
+	if healthErr == nil {
+		return false
+	}
+	for i := range s.endpoints {
+		if int32(i) == failed {
+			continue
+		}
+		if err := s.checkEndpointHealth(ctx, int32(i)); err != nil {
+			s.Log.Warnf("endpoint %s is unhealthy: %v", s.endpoints[i], err)
+			continue
+		}
+		s.Log.Warnf("endpoint %s is unhealthy: %v, switch to %s", s.endpoints[failed], healthErr, s.endpoints[i])
+		s.activeEndpoint.Store(int32(i))
+		return true
+	}
+	s.Log.Errorf("all endpoints %v are unhealthy, last error: %v", s.endpoints, healthErr)
+	return false
+}
+
+// watchEndpointsHealth - switch back to `endpoint` when it becomes healthy, and switch from active endpoint when it becomes unhealthy between requests
+func (s *S3) watchEndpointsHealth(ctx context.Context) {
+	ticker := time.NewTicker(s.Config.HealthCheckDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			active := s.activeEndpoint.Load()
+			if active != 0 && s.checkEndpointHealth(ctx, 0) == nil {
+				s.Log.Infof("endpoint %s is healthy again, switch back from %s", s.endpoints[0], s.endpoints[active])
+				s.activeEndpoint.CompareAndSwap(active, 0)
+				continue
+			}
+			s.failoverEndpoint(ctx, active)
+		}
+	}
+}
+
+// withEndpointFailover - when request fails, switch to healthy endpoint, request with non-replayable body will retry by caller, look general->retries_on_failure
+func (s *S3) withEndpointFailover(ctx context.Context, replayable bool, request func() error) error {
+	active := s.activeEndpoint.Load()
+	err := request()
+	if err == nil || len(s.endpointClients) < 2 || ctx.Err() != nil || errors.Is(err, ErrNotFound) || isPartialReadError(err) {
+		return err
+	}
+	if s.failoverEndpoint(ctx, active) && replayable {
+		s.Log.Warnf("retry request on endpoint %s after error: %v", s.endpoints[s.activeEndpoint.Load()], err)
+		return request()
+	}
+	return err
+}
+
+// readWithFallback - read from last successful bucket, when it is unavailable try other read replicas
+func (s *S3) readWithFallback(ctx context.Context, read func(replica s3ReadReplica) error) error {
+	if len(s.readReplicas) == 0 {
+		return read(s3ReadReplica{client: s.client, downloader: s.downloader, bucket: s.Config.Bucket, region: s.Config.Region})
+	}
+	readReplica := func(i int) error {
+		// primary bucket could be available via failover endpoint
+		if i == 0 {
+			return s.withEndpointFailover(ctx, true, func() error {
+				return read(s.readReplicas[0])
+			})
+		}
+		return read(s.readReplicas[i])
+	}
+	active := int(s.activeReadReplica.Load())
+	err := readReplica(active)
+	if err == nil || len(s.readReplicas) == 1 || !s.isReadFallbackError(ctx, err) {
+		return err
+	}
+	for i := range s.readReplicas {
+		if i == active {
+			continue
+		}
+		status.Current.AddWarning(ctx, s.Log, status.WarningFallback, "read from bucket %s in %s return error: %v, fallback to bucket %s in %s", s.readReplicas[active].bucket, s.readReplicas[active].region, err, s.readReplicas[i].bucket, s.readReplicas[i].region)
+		if err = readReplica(i); err == nil || !s.isReadFallbackError(ctx, err) {
+			if err == nil {
+				s.activeReadReplica.Store(int32(i))
+			}
+			return err
+		}
+	}
+	return err
+}
+
+// partialReadError - read already passed some data to caller, so it can't repeat on other read replica or endpoint without duplicated or missed data
+type partialReadError struct {
+	err error
+}
+
+func (e *partialReadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *partialReadError) Unwrap() error {
+	return e.err
+}
+
+func isPartialReadError(err error) bool {
+	var partialErr *partialReadError
+	return errors.As(err, &partialErr)
+}
+
+func (s *S3) isReadFallbackError(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, ErrNotFound) && !isPartialReadError(err)
+}
+
 func (s *S3) Close(ctx context.Context) error {
+	if s.stopHealthCheck != nil {
+		s.stopHealthCheck()
+	}
 	return nil
 }
 
@@ -204,7 +407,13 @@ func (s *S3) GetFileReaderAbsolute(ctx context.Context, key string) (io.ReadClos
 		Key:    aws.String(key),
 	}
 	s.enrichGetObjectParams(params)
-	resp, err := s.client.GetObject(ctx, params)
+	var resp *s3.GetObjectOutput
+	err := s.readWithFallback(ctx, func(replica s3ReadReplica) error {
+		var getErr error
+		params.Bucket = aws.String(replica.bucket)
+		resp, getErr = replica.client.GetObject(ctx, params)
+		return getErr
+	})
 	if err != nil {
 		var opError *smithy.OperationError
 		if errors.As(err, &opError) {
@@ -256,9 +465,15 @@ func (s *S3) GetFileReaderWithLocalPath(ctx context.Context, key, localPath stri
 		if err != nil {
 			return nil, err
 		}
-		_, err = s.downloader.Download(ctx, writer, &s3.GetObjectInput{
-			Bucket: aws.String(s.Config.Bucket),
-			Key:    aws.String(path.Join(s.Config.Path, key)),
+		err = s.readWithFallback(ctx, func(replica s3ReadReplica) error {
+			if _, seekErr := writer.Seek(0, io.SeekStart); seekErr != nil {
+				return seekErr
+			}
+			_, downloadErr := replica.downloader.Download(ctx, writer, &s3.GetObjectInput{
+				Bucket: aws.String(replica.bucket),
+				Key:    aws.String(path.Join(s.Config.Path, key)),
+			})
+			return downloadErr
 		})
 		if err != nil {
 			return nil, err
@@ -274,6 +489,32 @@ func (s *S3) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 }
 
 func (s *S3) PutFileAbsolute(ctx context.Context, key string, r io.ReadCloser) error {
+	return s.putFileAbsolute(ctx, key, r, s.PartSize)
+}
+
+// s3MaxPartSize - https://docs.aws.amazon.com/AmazonS3/latest/userguide/qfacts.html
+const s3MaxPartSize = 5 * 1024 * 1024 * 1024
+
+// PutFileWithSize - implements sizedFilePutter, part size increases when expectedSize is bigger than part_size * max_parts_count
+func (s *S3) PutFileWithSize(ctx context.Context, key string, r io.ReadCloser, expectedSize int64) error {
+	partSize := calculatePartSize(s.PartSize, expectedSize, s.Config.MaxPartsCount, s3MaxPartSize)
+	if partSize != s.PartSize {
+		s.Log.Debugf("PutFileWithSize %s expected size %d, part_size increased from %d to %d", key, expectedSize, s.PartSize, partSize)
+	}
+	return s.putFileAbsolute(ctx, path.Join(s.Config.Path, key), r, partSize)
+}
+
+func (s *S3) putFileAbsolute(ctx context.Context, key string, r io.ReadCloser, partSize int64) error {
+	params := s.newPutObjectInput(key, r)
+	return s.withEndpointFailover(ctx, false, func() error {
+		_, err := s.uploader.Upload(ctx, &params, func(u *s3manager.Uploader) {
+			u.PartSize = partSize
+		})
+		return err
+	})
+}
+
+func (s *S3) newPutObjectInput(key string, r io.ReadCloser) s3.PutObjectInput {
 	params := s3.PutObjectInput{
 		Bucket:       aws.String(s.Config.Bucket),
 		Key:          aws.String(key),
@@ -317,10 +558,99 @@ func (s *S3) PutFileAbsolute(ctx context.Context, key string, r io.ReadCloser) e
 	if s.Config.SSEKMSEncryptionContext != "" {
 		params.SSEKMSEncryptionContext = aws.String(s.Config.SSEKMSEncryptionContext)
 	}
-	_, err := s.uploader.Upload(ctx, &params)
+	s.enrichObjectLockParams(&params.ObjectLockMode, &params.ObjectLockRetainUntilDate, &params.ObjectLockLegalHoldStatus, &params.ChecksumAlgorithm)
+	return params
+}
+
+// GetFileVersion - implements ConditionalPutter, return ETag of object
+func (s *S3) GetFileVersion(ctx context.Context, key string) (string, error) {
+	params := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+	}
+	s.enrichHeadParams(params)
+	head, err := s.client.HeadObject(ctx, params)
+	if err != nil {
+		if s3ResponseStatusCode(err) == http.StatusNotFound {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return aws.ToString(head.ETag), nil
+}
+
+// PutFileIfVersion - implements ConditionalPutter, https://docs.aws.amazon.com/AmazonS3/latest/userguide/conditional-requests.html,
+// single PutObject without multipart upload, cause If-Match and If-None-Match are not supported by uploader
+func (s *S3) PutFileIfVersion(ctx context.Context, key string, r io.ReadCloser, version string) error {
+	params := s.newPutObjectInput(path.Join(s.Config.Path, key), r)
+	header, value := "If-Match", version
+	if version == "" {
+		header, value = "If-None-Match", "*"
+	}
+	_, err := s.client.PutObject(ctx, &params, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, awsV2http.AddHeaderValue(header, value))
+	})
+	if statusCode := s3ResponseStatusCode(err); statusCode == http.StatusPreconditionFailed || statusCode == http.StatusConflict {
+		return ErrVersionMismatch
+	}
 	return err
 }
 
+// s3ResponseStatusCode - return 0 when err is not HTTP response error
+func s3ResponseStatusCode(err error) int {
+	var httpErr *awsV2http.ResponseError
+	if err != nil && errors.As(err, &httpErr) && httpErr.Response != nil {
+		return httpErr.Response.StatusCode
+	}
+	return 0
+}
+
+// enrichObjectLockParams - https://docs.aws.amazon.com/AmazonS3/latest/userguide/object-lock.html, PutObject and CreateMultipartUpload have the same fields
+func (s *S3) enrichObjectLockParams(mode *s3types.ObjectLockMode, retainUntil **time.Time, legalHold *s3types.ObjectLockLegalHoldStatus, checksumAlgorithm *s3types.ChecksumAlgorithm) {
+	if s.Config.ObjectLockMode == "" && !s.Config.ObjectLockLegalHold {
+		return
+	}
+	if s.Config.ObjectLockMode != "" {
+		*mode = s3types.ObjectLockMode(s.Config.ObjectLockMode)
+		*retainUntil = aws.Time(time.Now().Add(s.Config.ObjectLockRetainDuration))
+	}
+	if s.Config.ObjectLockLegalHold {
+		*legalHold = s3types.ObjectLockLegalHoldStatusOn
+	}
+	// object lock require Content-MD5 or checksum header
+	if *checksumAlgorithm == "" {
+		*checksumAlgorithm = s3types.ChecksumAlgorithmCrc32
+	}
+}
+
+// GetObjectLock - implements ObjectLocker, HeadObject return object lock fields only with s3:GetObjectRetention and s3:GetObjectLegalHold permissions
+func (s *S3) GetObjectLock(ctx context.Context, key string) (*ObjectLock, error) {
+	params := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+	}
+	s.enrichHeadParams(params)
+	head, err := s.client.HeadObject(ctx, params)
+	if err != nil {
+		var opError *smithy.OperationError
+		if errors.As(err, &opError) {
+			var httpErr *awsV2http.ResponseError
+			if errors.As(opError.Err, &httpErr) && httpErr.Response.StatusCode == http.StatusNotFound {
+				return nil, ErrNotFound
+			}
+		}
+		return nil, err
+	}
+	lock := &ObjectLock{
+		Mode:      string(head.ObjectLockMode),
+		LegalHold: head.ObjectLockLegalHoldStatus == s3types.ObjectLockLegalHoldStatusOn,
+	}
+	if head.ObjectLockRetainUntilDate != nil {
+		lock.RetainUntil = *head.ObjectLockRetainUntilDate
+	}
+	return lock, nil
+}
+
 func (s *S3) deleteKey(ctx context.Context, key string) error {
 	params := &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
@@ -336,7 +666,10 @@ func (s *S3) deleteKey(ctx context.Context, key string) error {
 		}
 		params.VersionId = objVersion
 	}
-	if _, err := s.client.DeleteObject(ctx, params); err != nil {
+	if err := s.withEndpointFailover(ctx, true, func() error {
+		_, err := s.client.DeleteObject(ctx, params)
+		return err
+	}); err != nil {
 		return errors.Wrapf(err, "deleteKey, deleting object bucket: %s key: %s version: %v", s.Config.Bucket, key, params.VersionId)
 	}
 	return nil
@@ -383,17 +716,24 @@ func (s *S3) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 		Key:    aws.String(path.Join(s.Config.Path, key)),
 	}
 	s.enrichHeadParams(params)
-	head, err := s.client.HeadObject(ctx, params)
-	if err != nil {
-		var opError *smithy.OperationError
-		if errors.As(err, &opError) {
-			var httpErr *awsV2http.ResponseError
-			if errors.As(opError.Err, &httpErr) {
-				if httpErr.Response.StatusCode == http.StatusNotFound {
-					return nil, ErrNotFound
+	var head *s3.HeadObjectOutput
+	err := s.readWithFallback(ctx, func(replica s3ReadReplica) error {
+		var headErr error
+		params.Bucket = aws.String(replica.bucket)
+		if head, headErr = replica.client.HeadObject(ctx, params); headErr != nil {
+			var opError *smithy.OperationError
+			if errors.As(headErr, &opError) {
+				var httpErr *awsV2http.ResponseError
+				if errors.As(opError.Err, &httpErr) {
+					if httpErr.Response.StatusCode == http.StatusNotFound {
+						return ErrNotFound
+					}
 				}
 			}
 		}
+		return headErr
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &s3File{*head.ContentLength, *head.LastModified, string(head.StorageClass), key}, nil
@@ -437,6 +777,57 @@ func (s *S3) WalkAbsolute(ctx context.Context, prefix string, recursive bool, pr
 	return g.Wait()
 }
 
+// ListIncompleteUploads - implements IncompleteUploadsCleaner, empty s3->path or s3->object_disk_path is not listed, cause bucket could be shared with other applications
+func (s *S3) ListIncompleteUploads(ctx context.Context) ([]IncompleteUpload, error) {
+	var uploads []IncompleteUpload
+	for _, prefix := range []string{s.Config.Path, s.Config.ObjectDiskPath} {
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" {
+			continue
+		}
+		params := &s3.ListMultipartUploadsInput{
+			Bucket: aws.String(s.Config.Bucket),
+			Prefix: aws.String(prefix + "/"),
+		}
+		if s.Config.RequestPayer != "" {
+			params.RequestPayer = s3types.RequestPayer(s.Config.RequestPayer)
+		}
+		for {
+			page, err := s.client.ListMultipartUploads(ctx, params)
+			if err != nil {
+				return nil, fmt.Errorf("list multipart uploads %s: %v", prefix, err)
+			}
+			for _, u := range page.Uploads {
+				upload := IncompleteUpload{Key: aws.ToString(u.Key), UploadID: aws.ToString(u.UploadId)}
+				if u.Initiated != nil {
+					upload.Initiated = *u.Initiated
+				}
+				uploads = append(uploads, upload)
+			}
+			if !aws.ToBool(page.IsTruncated) {
+				break
+			}
+			params.KeyMarker = page.NextKeyMarker
+			params.UploadIdMarker = page.NextUploadIdMarker
+		}
+	}
+	return uploads, nil
+}
+
+// AbortIncompleteUpload - implements IncompleteUploadsCleaner, delete uploaded parts
+func (s *S3) AbortIncompleteUpload(ctx context.Context, upload IncompleteUpload) error {
+	params := &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.Config.Bucket),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.UploadID),
+	}
+	if s.Config.RequestPayer != "" {
+		params.RequestPayer = s3types.RequestPayer(s.Config.RequestPayer)
+	}
+	_, err := s.client.AbortMultipartUpload(ctx, params)
+	return err
+}
+
 func (s *S3) remotePager(ctx context.Context, s3Path string, recursive bool, process func(page *s3.ListObjectsV2Output)) error {
 	prefix := s3Path + "/"
 	if s3Path == "" || s3Path == "/" {
@@ -450,21 +841,39 @@ func (s *S3) remotePager(ctx context.Context, s3Path string, recursive bool, pro
 	if !recursive {
 		params.Delimiter = aws.String("/")
 	}
-	pager := s3.NewListObjectsV2Paginator(s.client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
-		o.Limit = 1000
-	})
-	for pager.HasMorePages() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return err
+	processedPages := 0
+	return s.readWithFallback(ctx, func(replica s3ReadReplica) error {
+		params.Bucket = aws.String(replica.bucket)
+		pager := s3.NewListObjectsV2Paginator(replica.client, params, func(o *s3.ListObjectsV2PaginatorOptions) {
+			o.Limit = 1000
+		})
+		for pager.HasMorePages() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				// can't switch bucket in the middle of listing, cause process already received some pages
+				if processedPages > 0 {
+					return &partialReadError{err: fmt.Errorf("list %s from bucket %s failed after %d pages: %v", prefix, replica.bucket, processedPages, err)}
+				}
+				return err
+			}
+			process(page)
+			processedPages++
 		}
-		process(page)
-	}
-	return nil
+		return nil
+	})
 }
 
 func (s *S3) CopyObject(ctx context.Context, srcSize int64, srcBucket, srcKey, dstKey string) (int64, error) {
-	dstKey = path.Join(s.Config.ObjectDiskPath, dstKey)
+	return s.copyObject(ctx, srcSize, srcBucket, srcKey, path.Join(s.Config.ObjectDiskPath, dstKey))
+}
+
+// CopyFile - server side copy inside s3->path, look BackupDestination.CopyFile
+func (s *S3) CopyFile(ctx context.Context, srcSize int64, srcKey, dstKey string) error {
+	_, err := s.copyObject(ctx, srcSize, s.Config.Bucket, path.Join(s.Config.Path, srcKey), path.Join(s.Config.Path, dstKey))
+	return err
+}
+
+func (s *S3) copyObject(ctx context.Context, srcSize int64, srcBucket, srcKey, dstKey string) (int64, error) {
 	s.Log.Debugf("S3->CopyObject %s/%s -> %s/%s", srcBucket, srcKey, s.Config.Bucket, dstKey)
 	// just copy object without multipart
 	if srcSize < 5*1024*1024*1024 || strings.Contains(s.Config.Endpoint, "storage.googleapis.com") {
@@ -631,6 +1040,7 @@ func (s *S3) enrichCreateMultipartUploadParams(params *s3.CreateMultipartUploadI
 	if s.Config.SSEKMSEncryptionContext != "" {
 		params.SSEKMSEncryptionContext = aws.String(s.Config.SSEKMSEncryptionContext)
 	}
+	s.enrichObjectLockParams(&params.ObjectLockMode, &params.ObjectLockRetainUntilDate, &params.ObjectLockLegalHoldStatus, &params.ChecksumAlgorithm)
 }
 
 func (s *S3) enrichCopyObjectParams(params *s3.CopyObjectInput) {
@@ -669,6 +1079,7 @@ func (s *S3) enrichCopyObjectParams(params *s3.CopyObjectInput) {
 	if s.Config.RequestPayer != "" {
 		params.RequestPayer = s3types.RequestPayer(s.Config.RequestPayer)
 	}
+	s.enrichObjectLockParams(&params.ObjectLockMode, &params.ObjectLockRetainUntilDate, &params.ObjectLockLegalHoldStatus, &params.ChecksumAlgorithm)
 }
 
 func (s *S3) restoreObject(ctx context.Context, key string) error {
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const s3TestListPage = `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><Prefix>backup/</Prefix><KeyCount>1</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>%t</IsTruncated><NextContinuationToken>%s</NextContinuationToken><Contents><Key>%s</Key><Size>1</Size></Contents></ListBucketResult>`
+
+func newS3TestClient(url string) *s3.Client {
+	return s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(url),
+		UsePathStyle:     true,
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	})
+}
+
+func TestRemotePagerNoFallbackAfterFirstPage(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("continuation-token") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprint(w, `<Error><Code>InternalError</Code><Message>page 2 failed</Message></Error>`)
+			return
+		}
+		_, _ = fmt.Fprintf(w, s3TestListPage, "primary", true, "page2", "backup/a")
+	}))
+	defer primary.Close()
+	var replicaRequests atomic.Int32
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replicaRequests.Add(1)
+		_, _ = fmt.Fprintf(w, s3TestListPage, "replica", false, "", "backup/b")
+	}))
+	defer replica.Close()
+
+	s := &S3{
+		Config: &config.S3Config{Bucket: "primary"},
+		Log:    apexLog.WithField("logger", "s3"),
+		readReplicas: []s3ReadReplica{
+			{client: newS3TestClient(primary.URL), bucket: "primary", region: "us-east-1"},
+			{client: newS3TestClient(replica.URL), bucket: "replica", region: "us-east-1"},
+		},
+	}
+	var keys []string
+	err := s.remotePager(context.Background(), "backup", true, func(page *s3.ListObjectsV2Output) {
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+	})
+	require.Error(t, err, "partial listing shall not be reported as complete")
+	assert.ErrorContains(t, err, "failed after 1 pages")
+	assert.Equal(t, []string{"backup/a"}, keys)
+	assert.Equal(t, int32(0), replicaRequests.Load(), "replica shall not be used after first page")
+	assert.Equal(t, int32(0), s.activeReadReplica.Load())
+
+	// fail before first page, fallback to replica
+	primary.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, `<Error><Code>InternalError</Code><Message>failed</Message></Error>`)
+	})
+	keys = nil
+	require.NoError(t, s.remotePager(context.Background(), "backup", true, func(page *s3.ListObjectsV2Output) {
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+	}))
+	assert.Equal(t, []string{"backup/b"}, keys)
+	assert.Equal(t, int32(1), s.activeReadReplica.Load())
+}
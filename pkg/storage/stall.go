@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/v2/pkg/systemd"
+)
+
+// ErrStreamStalled - stream didn't transfer any byte during general->stalled_stream_timeout
+var ErrStreamStalled = errors.New("stream stalled")
+
+// StalledUploads - counter of aborted upload streams, exposed in /metrics by API server
+var StalledUploads atomic.Int64
+
+type progressReader struct {
+	io.ReadCloser
+	lastProgress *atomic.Int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.lastProgress.Store(time.Now().UnixNano())
+		systemd.Progress()
+	}
+	return n, err
+}
+
+// progressReadSeeker - keep io.ReaderAt and io.Seeker for uploaders which upload parts of file in parallel
+type progressReadSeeker struct {
+	progressReader
+	readerAt io.ReaderAt
+	seeker   io.Seeker
+}
+
+func (r *progressReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.readerAt.ReadAt(p, off)
+	if n > 0 {
+		r.lastProgress.Store(time.Now().UnixNano())
+		systemd.Progress()
+	}
+	return n, err
+}
+
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+// watchStall - run f with context which will cancel when readers wrapped with trackProgress don't make progress during timeout, zero timeout disables watchdog
+// each transferred chunk is also systemd.Progress, so systemd watchdog doesn't restart watch during long transfer
+func watchStall(ctx context.Context, timeout time.Duration, stallCounter *atomic.Int64, f func(ctx context.Context, trackProgress func(io.ReadCloser) io.ReadCloser) error) error {
+	lastProgress := &atomic.Int64{}
+	lastProgress.Store(time.Now().UnixNano())
+	trackProgress := func(r io.ReadCloser) io.ReadCloser {
+		tracked := progressReader{ReadCloser: r, lastProgress: lastProgress}
+		readerAt, isReaderAt := r.(io.ReaderAt)
+		seeker, isSeeker := r.(io.Seeker)
+		if isReaderAt && isSeeker {
+			return &progressReadSeeker{progressReader: tracked, readerAt: readerAt, seeker: seeker}
+		}
+		return &tracked
+	}
+	if timeout <= 0 {
+		return f(ctx, trackProgress)
+	}
+	stallCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		checkInterval := timeout / 4
+		if checkInterval < time.Second {
+			checkInterval = time.Second
+		}
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stallCtx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, lastProgress.Load())) >= timeout {
+					stallCounter.Add(1)
+					cancel(ErrStreamStalled)
+					return
+				}
+			}
+		}
+	}()
+	err := f(stallCtx, trackProgress)
+	if errors.Is(context.Cause(stallCtx), ErrStreamStalled) {
+		return fmt.Errorf("%w: no progress during %s, last error: %v", ErrStreamStalled, timeout, err)
+	}
+	return err
+}
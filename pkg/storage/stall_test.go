@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchStall(t *testing.T) {
+	stallCounter := &atomic.Int64{}
+	err := watchStall(context.Background(), time.Second, stallCounter, func(ctx context.Context, trackProgress func(io.ReadCloser) io.ReadCloser) error {
+		_, err := io.ReadAll(trackProgress(io.NopCloser(strings.NewReader("data"))))
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stallCounter.Load())
+
+	err = watchStall(context.Background(), time.Second, stallCounter, func(ctx context.Context, trackProgress func(io.ReadCloser) io.ReadCloser) error {
+		// hung upload which never read body
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrStreamStalled))
+	assert.Equal(t, int64(1), stallCounter.Load())
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"time"
 )
@@ -10,6 +11,8 @@ import (
 var (
 	// ErrNotFound is returned when file/object cannot be found
 	ErrNotFound = errors.New("key not found")
+	// ErrVersionMismatch is returned by conditional put when object was changed or created by another writer after read
+	ErrVersionMismatch = errors.New("object version mismatch")
 )
 
 // RemoteFile - interface describe file on remote storage
@@ -19,6 +22,50 @@ type RemoteFile interface {
 	LastModified() time.Time
 }
 
+// ObjectLock - retention and legal hold status of remote object
+type ObjectLock struct {
+	Mode        string
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+// IsLocked - object can't be deleted right now
+func (l ObjectLock) IsLocked() bool {
+	return l.LegalHold || (l.Mode != "" && l.RetainUntil.After(time.Now()))
+}
+
+func (l ObjectLock) String() string {
+	if l.LegalHold {
+		return "legal hold is ON"
+	}
+	return fmt.Sprintf("%s retention until %s", l.Mode, l.RetainUntil.Format(time.RFC3339))
+}
+
+// ObjectLocker - remote storage which could protect uploaded objects from deletion
+type ObjectLocker interface {
+	GetObjectLock(ctx context.Context, key string) (*ObjectLock, error)
+}
+
+// IncompleteUpload - multipart upload which was neither completed nor aborted, uploaded parts are stored and billed until abort
+type IncompleteUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// IncompleteUploadsCleaner - remote storage which keeps parts of interrupted multipart uploads, keys are absolute
+type IncompleteUploadsCleaner interface {
+	ListIncompleteUploads(ctx context.Context) ([]IncompleteUpload, error)
+	AbortIncompleteUpload(ctx context.Context, upload IncompleteUpload) error
+}
+
+// ConditionalPutter - remote storage which could replace object only when it wasn't changed after read,
+// version is ETag or generation, empty version means object doesn't exist and shall not be created by another writer
+type ConditionalPutter interface {
+	GetFileVersion(ctx context.Context, key string) (string, error)
+	PutFileIfVersion(ctx context.Context, key string, r io.ReadCloser, version string) error
+}
+
 // RemoteStorage -
 type RemoteStorage interface {
 	Kind() string
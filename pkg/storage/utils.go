@@ -60,29 +60,43 @@ func GetBackupsToDeleteRemote(backups []Backup, keep int) []Backup {
 	return []Backup{}
 }
 
-func getArchiveWriter(format string, level int) (*archiver.CompressedArchive, error) {
+// getArchiveWriter - concurrency is general->compression_concurrency, 0 means default of each codec
+func getArchiveWriter(format string, level int, concurrency int, dictionary []byte) (*archiver.CompressedArchive, error) {
 	switch format {
 	case "tar":
 		return &archiver.CompressedArchive{Archival: archiver.Tar{}}, nil
 	case "lz4":
-		return &archiver.CompressedArchive{Compression: archiver.Lz4{CompressionLevel: level}, Archival: archiver.Tar{}}, nil
+		return &archiver.CompressedArchive{Compression: lz4Compression{Lz4: archiver.Lz4{CompressionLevel: level}, concurrency: concurrency}, Archival: archiver.Tar{}}, nil
 	case "bzip2", "bz2":
 		return &archiver.CompressedArchive{Compression: archiver.Bz2{CompressionLevel: level}, Archival: archiver.Tar{}}, nil
 	case "gzip", "gz":
+		if concurrency > 0 {
+			return &archiver.CompressedArchive{Compression: pgzipCompression{Gz: archiver.Gz{CompressionLevel: level, Multithreaded: true}, concurrency: concurrency}, Archival: archiver.Tar{}}, nil
+		}
 		return &archiver.CompressedArchive{Compression: archiver.Gz{CompressionLevel: level, Multithreaded: true}, Archival: archiver.Tar{}}, nil
 	case "sz":
 		return &archiver.CompressedArchive{Compression: archiver.Sz{}, Archival: archiver.Tar{}}, nil
 	case "xz":
+		if concurrency > 1 {
+			return &archiver.CompressedArchive{Compression: xzCompression{concurrency: concurrency}, Archival: archiver.Tar{}}, nil
+		}
 		return &archiver.CompressedArchive{Compression: archiver.Xz{}, Archival: archiver.Tar{}}, nil
 	case "br", "brotli":
 		return &archiver.CompressedArchive{Compression: archiver.Brotli{Quality: level}, Archival: archiver.Tar{}}, nil
 	case "zstd":
-		return &archiver.CompressedArchive{Compression: archiver.Zstd{EncoderOptions: []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}}, Archival: archiver.Tar{}}, nil
+		encoderOptions := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}
+		if concurrency > 0 {
+			encoderOptions = append(encoderOptions, zstd.WithEncoderConcurrency(concurrency))
+		}
+		if len(dictionary) > 0 {
+			encoderOptions = append(encoderOptions, zstd.WithEncoderDict(dictionary))
+		}
+		return &archiver.CompressedArchive{Compression: archiver.Zstd{EncoderOptions: encoderOptions}, Archival: archiver.Tar{}}, nil
 	}
 	return nil, fmt.Errorf("wrong compression_format: %s, supported: 'tar', 'lz4', 'bzip2', 'bz2', 'gzip', 'gz', 'sz', 'xz', 'br', 'brotli', 'zstd'", format)
 }
 
-func getArchiveReader(format string) (*archiver.CompressedArchive, error) {
+func getArchiveReader(format string, dictionaries [][]byte) (*archiver.CompressedArchive, error) {
 	switch format {
 	case "tar":
 		return &archiver.CompressedArchive{Archival: archiver.Tar{}}, nil
@@ -99,7 +113,11 @@ func getArchiveReader(format string) (*archiver.CompressedArchive, error) {
 	case "br", "brotli":
 		return &archiver.CompressedArchive{Compression: archiver.Brotli{}, Archival: archiver.Tar{}}, nil
 	case "zstd":
-		return &archiver.CompressedArchive{Compression: archiver.Zstd{}, Archival: archiver.Tar{}}, nil
+		var decoderOptions []zstd.DOption
+		if len(dictionaries) > 0 {
+			decoderOptions = append(decoderOptions, zstd.WithDecoderDicts(dictionaries...))
+		}
+		return &archiver.CompressedArchive{Compression: archiver.Zstd{DecoderOptions: decoderOptions}, Archival: archiver.Tar{}}, nil
 	}
 	return nil, fmt.Errorf("wrong compression_format: %s, supported: 'tar', 'lz4', 'bzip2', 'bz2', 'gzip', 'gz', 'sz', 'xz', 'br', 'brotli', 'zstd'", format)
 }
@@ -1,11 +1,20 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"os"
+	"path"
 	"testing"
 	"time"
 
 	"github.com/Altinity/clickhouse-backup/v2/pkg/metadata"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mholt/archiver/v4"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -125,3 +134,121 @@ func TestGetBackupsToDeleteWithRecursiveRequiredBackups(t *testing.T) {
 	}
 	assert.Equal(t, expectedData, GetBackupsToDeleteRemote(testData, 6))
 }
+
+func TestArchiveWithCompressionDictionary(t *testing.T) {
+	// zstd.BuildDict requires at least 512 sequences in samples
+	r := rand.New(rand.NewSource(1))
+	generateColumns := func() []byte {
+		columns := []byte("columns format version: 1\n256 columns:\n")
+		for i := 0; i < 256; i++ {
+			columns = append(columns, fmt.Sprintf("`column_%d` %s\n", r.Intn(100000), []string{"UInt64", "String", "DateTime", "Float64"}[r.Intn(4)])...)
+		}
+		return columns
+	}
+	content := generateColumns()
+	dictionary, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1 << 15,
+		Contents: [][]byte{generateColumns(), generateColumns(), generateColumns()},
+		History:  generateColumns(),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	assert.NoError(t, err)
+	localFile := path.Join(t.TempDir(), "columns.txt")
+	assert.NoError(t, os.WriteFile(localFile, content, 0640))
+	info, err := os.Stat(localFile)
+	assert.NoError(t, err)
+	writer, err := getArchiveWriter("zstd", 3, 0, dictionary)
+	assert.NoError(t, err)
+	archive := bytes.Buffer{}
+	err = writer.Archive(context.Background(), &archive, []archiver.File{{
+		FileInfo:      info,
+		NameInArchive: "columns.txt",
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(localFile)
+		},
+	}})
+	assert.NoError(t, err)
+
+	extract := func(dictionaries [][]byte) ([]byte, error) {
+		reader, err := getArchiveReader("zstd", dictionaries)
+		assert.NoError(t, err)
+		var extracted []byte
+		err = reader.Extract(context.Background(), bytes.NewReader(archive.Bytes()), nil, func(ctx context.Context, f archiver.File) error {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			extracted, err = io.ReadAll(r)
+			return err
+		})
+		return extracted, err
+	}
+	_, err = extract(nil)
+	assert.Error(t, err)
+	extracted, err := extract([][]byte{dictionary})
+	assert.NoError(t, err)
+	assert.Equal(t, content, extracted)
+}
+
+func TestArchiveWithCompressionConcurrency(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	content := make([]byte, compressionBlockSize*2+1024)
+	for i := range content {
+		content[i] = byte('a' + r.Intn(4))
+	}
+	localFile := path.Join(t.TempDir(), "data.bin")
+	assert.NoError(t, os.WriteFile(localFile, content, 0640))
+	info, err := os.Stat(localFile)
+	assert.NoError(t, err)
+	for _, format := range []string{"gzip", "lz4", "xz", "zstd"} {
+		writer, err := getArchiveWriter(format, 1, 3, nil)
+		assert.NoError(t, err)
+		archive := bytes.Buffer{}
+		err = writer.Archive(context.Background(), &archive, []archiver.File{{
+			FileInfo:      info,
+			NameInArchive: "data.bin",
+			Open: func() (io.ReadCloser, error) {
+				return os.Open(localFile)
+			},
+		}})
+		assert.NoError(t, err, format)
+		reader, err := getArchiveReader(format, nil)
+		assert.NoError(t, err)
+		var extracted []byte
+		err = reader.Extract(context.Background(), bytes.NewReader(archive.Bytes()), nil, func(ctx context.Context, f archiver.File) error {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			extracted, err = io.ReadAll(r)
+			return err
+		})
+		assert.NoError(t, err, format)
+		assert.Equal(t, content, extracted, format)
+	}
+}
+
+func TestAdaptiveCompressionLevel(t *testing.T) {
+	c := newAdaptiveCompression("zstd", 4, 2, true)
+	assert.Equal(t, 3, c.Level())
+	// network is bottleneck, level increases
+	c.Report(100, 10, time.Second, 3*time.Second)
+	assert.Equal(t, 6, c.Level())
+	// CPU is bottleneck, level decreases twice
+	c.Report(100, 10, 10*time.Second, 0)
+	c.Report(100, 10, 10*time.Second, 0)
+	assert.Equal(t, 1, c.Level())
+	stats := c.Stats()
+	assert.Equal(t, 3, stats.Archives)
+	assert.Equal(t, 1, stats.MinLevel)
+	assert.Equal(t, 6, stats.MaxLevel)
+	assert.Equal(t, 1, stats.FinalLevel)
+	assert.Equal(t, 3, stats.LevelChanges)
+
+	fixed := newAdaptiveCompression("zstd", 4, 0, false)
+	fixed.Report(100, 10, 10*time.Second, 0)
+	assert.Equal(t, 4, fixed.Level())
+	assert.Nil(t, newAdaptiveCompression("gzip", 1, 0, true).Stats())
+}
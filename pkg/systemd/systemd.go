@@ -0,0 +1,167 @@
+package systemd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	apexLog "github.com/apex/log"
+)
+
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify - send state to systemd via $NOTIFY_SOCKET, look https://www.freedesktop.org/software/systemd/man/latest/sd_notify.html
+// return false without error when process is not running under systemd with Type=notify
+func Notify(state string) (bool, error) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return false, nil
+	}
+	// abstract unix socket
+	if socketAddr[0] == '@' {
+		socketAddr = "\x00" + socketAddr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			apexLog.Warnf("can't close NOTIFY_SOCKET connection: %v", closeErr)
+		}
+	}()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval - return 0 when WatchdogSec is not defined in unit or watchdog is expected for other process
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	interval, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC=%s", usec)
+	}
+	return time.Duration(interval) * time.Microsecond, nil
+}
+
+// lastProgress - unix nanoseconds of last Progress call, look ProgressAlive
+var lastProgress atomic.Int64
+
+// Progress - watch and continuous loops, ClickHouse queries and remote storage transfers mark that process is not hung
+func Progress() {
+	lastProgress.Store(time.Now().UnixNano())
+}
+
+// ProgressAlive - RunWatchdog liveness check, fail when Progress was not called during timeout
+func ProgressAlive(timeout time.Duration) func(ctx context.Context) error {
+	Progress()
+	return func(ctx context.Context) error {
+		if since := time.Since(time.Unix(0, lastProgress.Load())); since > timeout {
+			return fmt.Errorf("no progress during %s", since.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// RunWatchdog - send WATCHDOG=1 twice per WatchdogSec while isAlive return nil, so systemd will restart hung process
+func RunWatchdog(ctx context.Context, isAlive func(ctx context.Context) error) {
+	log := apexLog.WithField("logger", "systemd.RunWatchdog")
+	interval, err := WatchdogInterval()
+	if err != nil {
+		log.Warnf("watchdog disabled: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	log.Debugf("start with interval %s", interval/2)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if isAlive != nil {
+				aliveCtx, cancel := context.WithTimeout(ctx, interval/2)
+				err = isAlive(aliveCtx)
+				cancel()
+				if err != nil {
+					log.Warnf("liveness check failed, skip %s: %v", Watchdog, err)
+					continue
+				}
+			}
+			if _, err = Notify(Watchdog); err != nil {
+				log.Warnf("can't send %s: %v", Watchdog, err)
+			}
+		}
+	}
+}
+
+// UnitParams - values for UnitTemplate
+type UnitParams struct {
+	Binary      string
+	ConfigPath  string
+	Command     string
+	User        string
+	WatchdogSec int
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=clickhouse-backup {{ .Command }}
+Documentation=https://github.com/Altinity/clickhouse-backup
+After=network-online.target clickhouse-server.service
+Wants=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{ .Binary }} -c {{ .ConfigPath }} {{ .Command }}
+ExecReload=/bin/kill -HUP $MAINPID
+User={{ .User }}
+Group={{ .User }}
+Restart=on-failure
+RestartSec=30s
+{{- if gt .WatchdogSec 0 }}
+WatchdogSec={{ .WatchdogSec }}s
+{{- end }}
+TimeoutStopSec=1min
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// UnitTemplate - generate systemd unit for long-running `server` or `watch` commands
+func UnitTemplate(params UnitParams) (string, error) {
+	if params.Command == "" {
+		return "", fmt.Errorf("command is required")
+	}
+	if strings.ContainsAny(params.Binary+params.ConfigPath+params.Command+params.User, "\n\r") {
+		return "", fmt.Errorf("unit parameters shall not contain new lines")
+	}
+	buf := &bytes.Buffer{}
+	if err := unitTemplate.Execute(buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,81 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(Ready)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	socketPath := path.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+	sent, err = Notify(Ready)
+	require.NoError(t, err)
+	assert.True(t, sent)
+	buf := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, Ready, string(buf[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	interval, err := WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	interval, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(1<<30))
+	interval, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "bad")
+	_, err = WatchdogInterval()
+	assert.Error(t, err)
+}
+
+func TestProgressAlive(t *testing.T) {
+	isAlive := ProgressAlive(50 * time.Millisecond)
+	assert.NoError(t, isAlive(context.Background()))
+	time.Sleep(100 * time.Millisecond)
+	assert.ErrorContains(t, isAlive(context.Background()), "no progress during")
+	Progress()
+	assert.NoError(t, isAlive(context.Background()))
+}
+
+func TestUnitTemplate(t *testing.T) {
+	unit, err := UnitTemplate(UnitParams{Binary: "/usr/bin/clickhouse-backup", ConfigPath: "/etc/clickhouse-backup/config.yml", Command: "server --watch", User: "clickhouse", WatchdogSec: 60})
+	require.NoError(t, err)
+	assert.Contains(t, unit, "ExecStart=/usr/bin/clickhouse-backup -c /etc/clickhouse-backup/config.yml server --watch\n")
+	assert.Contains(t, unit, "Type=notify\n")
+	assert.Contains(t, unit, "WatchdogSec=60s\n")
+
+	unit, err = UnitTemplate(UnitParams{Binary: "/usr/bin/clickhouse-backup", ConfigPath: "/etc/clickhouse-backup/config.yml", Command: "watch", User: "clickhouse"})
+	require.NoError(t, err)
+	assert.NotContains(t, unit, "WatchdogSec")
+
+	_, err = UnitTemplate(UnitParams{Binary: "/usr/bin/clickhouse-backup", Command: "watch\nExecStartPre=/bin/false"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// FileLock - advisory lock shared between clickhouse-backup processes on the same host
+type FileLock struct {
+	file *os.File
+}
+
+// AcquireFileLock - non-blocking flock, when lock already acquired return error with description of holder
+func AcquireFileLock(lockPath, owner string) (*FileLock, error) {
+	if err := os.MkdirAll(path.Dir(lockPath), 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		holder, _ := io.ReadAll(f)
+		if closeErr := f.Close(); closeErr != nil {
+			return nil, closeErr
+		}
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s already locked by %s", lockPath, strings.TrimSpace(string(holder)))
+		}
+		return nil, err
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(fmt.Sprintf("pid=%d %s\n", os.Getpid(), owner)), 0)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &FileLock{file: f}, nil
+}
+
+// Release - file is not removed, to avoid race with other process which already opened it
+func (l *FileLock) Release() error {
+	if err := l.file.Truncate(0); err != nil {
+		_ = l.file.Close()
+		return err
+	}
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN); err != nil {
+		_ = l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
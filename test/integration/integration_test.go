@@ -1986,6 +1986,12 @@ func TestIntegrationCustomRsync(t *testing.T) {
 	runIntegrationCustom(t, r, "rsync")
 }
 
+func TestIntegrationCustomPlugin(t *testing.T) {
+	r := require.New(t)
+	installDebIfNotExists(r, "clickhouse-backup", "jq")
+	runIntegrationCustom(t, r, "plugin")
+}
+
 func runIntegrationCustom(t *testing.T, r *require.Assertions, customType string) {
 	r.NoError(dockerExec("clickhouse-backup", "mkdir", "-pv", "/custom/"+customType))
 	r.NoError(dockerCP("./"+customType+"/", "clickhouse-backup:/custom/"))
@@ -0,0 +1,77 @@
+#!/usr/bin/env bash
+# example of custom->plugin_command, stores backups in local directory ${PLUGIN_STORAGE_DIR}
+# reads one JSON request from stdin, writes JSON messages to stdout, look "custom" section in ReadMe.md for protocol description
+set -euo pipefail
+STORAGE_DIR="${PLUGIN_STORAGE_DIR:-/var/lib/clickhouse-backup-plugin}"
+REQUEST=$(head -n 1)
+OPERATION=$(jq -r '.operation' <<<"${REQUEST}")
+BACKUP_NAME=$(jq -r '.backup_name // ""' <<<"${REQUEST}")
+
+log() {
+  jq -c -n --arg level "$1" --arg message "$2" '{type: "log", level: $level, message: $message}'
+}
+progress() {
+  jq -c -n --arg file "$1" --argjson bytes "$2" --argjson total "$3" '{type: "progress", file: $file, bytes: $bytes, total_bytes: $total}'
+}
+fail() {
+  jq -c -n --arg message "$1" '{type: "error", message: $message}'
+  exit 1
+}
+disks() {
+  jq -r '.disks // {} | to_entries[] | [.key, .value] | @tsv' <<<"${REQUEST}"
+}
+list_backups() {
+  for metadata in "${STORAGE_DIR}"/*/default/metadata.json; do
+    [[ -f "${metadata}" ]] || continue
+    jq -c -r -M '. + {upload_date: .creation_date}' "${metadata}"
+  done
+}
+
+if [[ "$(jq -r '.protocol_version' <<<"${REQUEST}")" != "1" ]]; then
+  fail "unsupported protocol_version in ${REQUEST}"
+fi
+mkdir -p "${STORAGE_DIR}"
+case "${OPERATION}" in
+  upload)
+    total=$(disks | while IFS=$'\t' read -r disk_name disk_path; do [[ -d "${disk_path}/backup/${BACKUP_NAME}" ]] && du -sb "${disk_path}/backup/${BACKUP_NAME}" | cut -f 1 || true; done | awk '{s+=$1} END {print s+0}')
+    uploaded=0
+    while IFS=$'\t' read -r disk_name disk_path; do
+      [[ -d "${disk_path}/backup/${BACKUP_NAME}" ]] || continue
+      mkdir -p "${STORAGE_DIR}/${BACKUP_NAME}/${disk_name}"
+      cp -a "${disk_path}/backup/${BACKUP_NAME}/." "${STORAGE_DIR}/${BACKUP_NAME}/${disk_name}/"
+      uploaded=$((uploaded + $(du -sb "${disk_path}/backup/${BACKUP_NAME}" | cut -f 1)))
+      progress "${disk_name}" "${uploaded}" "${total}"
+    done < <(disks)
+    keep=$(jq -r '.backups_to_keep_remote // 0' <<<"${REQUEST}")
+    if [[ "${keep}" -gt 0 ]]; then
+      list_backups | jq -r '[.creation_date, .backup_name] | @tsv' | sort | head -n -"${keep}" | cut -f 2 | while read -r old_backup; do
+        log info "delete ${old_backup} due backups_to_keep_remote=${keep}"
+        rm -rf "${STORAGE_DIR:?}/${old_backup}"
+      done
+    fi
+    ;;
+  download)
+    [[ -d "${STORAGE_DIR}/${BACKUP_NAME}" ]] || fail "${BACKUP_NAME} not found in ${STORAGE_DIR}"
+    total=$(du -sb "${STORAGE_DIR}/${BACKUP_NAME}"/* | awk '{s+=$1} END {print s+0}')
+    downloaded=0
+    for remote_disk in "${STORAGE_DIR}/${BACKUP_NAME}"/*; do
+      disk_name=$(basename "${remote_disk}")
+      disk_path=$(jq -r --arg disk "${disk_name}" '.disks[$disk] // ""' <<<"${REQUEST}")
+      [[ -n "${disk_path}" ]] || fail "disk ${disk_name} from ${BACKUP_NAME} not exists in system.disks"
+      mkdir -p "${disk_path}/backup/${BACKUP_NAME}"
+      cp -a "${remote_disk}/." "${disk_path}/backup/${BACKUP_NAME}/"
+      downloaded=$((downloaded + $(du -sb "${remote_disk}" | cut -f 1)))
+      progress "${disk_name}" "${downloaded}" "${total}"
+    done
+    ;;
+  list)
+    list_backups | jq -c '{type: "backup", backup: .}'
+    ;;
+  delete)
+    rm -rf "${STORAGE_DIR:?}/${BACKUP_NAME:?}"
+    ;;
+  *)
+    fail "unknown operation ${OPERATION}"
+    ;;
+esac
+jq -c -n '{type: "done"}'